@@ -0,0 +1,75 @@
+// Package admin serves the diagnostics of the collector on a separate port, i.e. the profiles
+// of net/http/pprof and the metrics of the Go runtime, to diagnose the performance issues of
+// large deployments in production.
+package admin
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"time"
+
+	"github.com/pegasus-kv/collector/logging"
+	"github.com/pegasus-kv/collector/security"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/spf13/viper"
+	"gopkg.in/tomb.v2"
+)
+
+var log = logging.Module("admin")
+
+// newHandler returns the handler of the profiles on "/debug/pprof/", the memory statistics on
+// "/debug/vars", and the metrics of the Go runtime gathered from `registry` on "/metrics", e.g.
+// the GC pauses and the heap.
+func newHandler(registry *prometheus.Registry) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.Handle("/debug/vars", expvar.Handler())
+	mux.Handle("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+	return mux
+}
+
+// newRuntimeRegistry returns the registry of the metrics of the Go runtime and the process.
+func newRuntimeRegistry() *prometheus.Registry {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(prometheus.NewGoCollector())
+	registry.MustRegister(prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}))
+	return registry
+}
+
+// Start serves the diagnostics on "admin.port" of "admin.host" until the tomb dies, unless the
+// port is 0. The block and the mutex profiles are enabled by "admin.block_profile_rate" and
+// "admin.mutex_profile_fraction".
+func Start(tom *tomb.Tomb) {
+	port := viper.GetInt("admin.port")
+	if port == 0 {
+		return
+	}
+	viper.SetDefault("admin.host", "127.0.0.1")
+	runtime.SetBlockProfileRate(viper.GetInt("admin.block_profile_rate"))
+	runtime.SetMutexProfileFraction(viper.GetInt("admin.mutex_profile_fraction"))
+
+	srv := &http.Server{
+		Addr:    fmt.Sprintf("%s:%d", viper.GetString("admin.host"), port),
+		Handler: newHandler(newRuntimeRegistry()),
+	}
+	go func() {
+		<-tom.Dying()
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(ctx)
+	}()
+
+	log.Infof("serve the diagnostics on %s", srv.Addr)
+	if err := security.ListenAndServe(srv); err != nil && err != http.ErrServerClosed {
+		log.Errorf("admin server terminates: %s", err)
+	}
+}
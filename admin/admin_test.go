@@ -0,0 +1,41 @@
+package admin
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandler(t *testing.T) {
+	server := httptest.NewServer(newHandler(newRuntimeRegistry()))
+	defer server.Close()
+
+	get := func(path string) (int, string) {
+		resp, err := http.Get(server.URL + path)
+		assert.Nil(t, err)
+		defer resp.Body.Close()
+		body, err := ioutil.ReadAll(resp.Body)
+		assert.Nil(t, err)
+		return resp.StatusCode, string(body)
+	}
+
+	code, body := get("/metrics")
+	assert.Equal(t, code, http.StatusOK)
+	assert.Contains(t, body, "go_gc_duration_seconds")
+	assert.Contains(t, body, "go_memstats_heap_inuse_bytes")
+	assert.Contains(t, body, "go_goroutines")
+
+	code, body = get("/debug/pprof/")
+	assert.Equal(t, code, http.StatusOK)
+	assert.Contains(t, body, "goroutine")
+
+	code, _ = get("/debug/pprof/heap")
+	assert.Equal(t, code, http.StatusOK)
+
+	code, body = get("/debug/vars")
+	assert.Equal(t, code, http.StatusOK)
+	assert.Contains(t, body, "memstats")
+}
@@ -41,6 +41,8 @@ var v1Tov2MetricsConversion = map[string]string{
 	"replica*app.pegasus*rdb.bf_point_positive_true":               "rdb_bf_point_positive_true",
 	"replica*app.pegasus*rdb.bf_point_positive_total":              "rdb_bf_point_positive_total",
 	"replica*app.pegasus*rdb.bf_point_negatives":                   "rdb_bf_point_negatives",
+	"replica*app.pegasus*rdb.estimate_pending_compaction_bytes":    "rdb_estimate_pending_compaction_bytes",
+	"replica*app.pegasus*rdb.compaction_pending":                   "rdb_compaction_pending",
 }
 
 var aggregatableSet = map[string]interface{}{
@@ -48,22 +50,72 @@ var aggregatableSet = map[string]interface{}{
 	"write_qps":   nil,
 	"read_bytes":  nil,
 	"write_bytes": nil,
+
+	"compaction_pending_bytes": nil,
+	"compaction_pending_tasks": nil,
+	"sst_file_count":           nil,
+
+	"primary_migration_count": nil,
+	"config_change_count":     nil,
+
+	"replica_count":     nil,
+	"min_replica_count": nil,
+	"max_replica_count": nil,
+	"avg_replica_count": nil,
+}
+
+// clusterOnlyMetrics are derived on cluster level only, rather than aggregated from the tables.
+var clusterOnlyMetrics = map[string]interface{}{
+	tableCountMetric:     nil,
+	aliveNodeCountMetric: nil,
+	deadNodeCountMetric:  nil,
+
+	nodeStateChangeCountMetric: nil,
+	flappingNodeCountMetric:    nil,
+
+	partitionCountMetric:           nil,
+	unhealthyPartitionCountMetric:  nil,
+	readOnlyPartitionCountMetric:   nil,
+	unreadablePartitionCountMetric: nil,
+
+	balanceOperationCountMetric:     nil,
+	balanceMovePrimaryCountMetric:   nil,
+	balanceCopyPrimaryCountMetric:   nil,
+	balanceCopySecondaryCountMetric: nil,
+
+	nodeMaxStorageMetric:  nil,
+	nodeMinStorageMetric:  nil,
+	nodeAvgStorageMetric:  nil,
+	storageImbalanceRatio: nil,
 }
 
 // aggregatable returns whether the counter is to be aggregated on collector,
-// including v1Tov2MetricsConversion and aggregatableSet.
+// including v1Tov2MetricsConversion, v2Metrics and aggregatableSet.
 func aggregatable(pc *partitionPerfCounter) bool {
 	v2Name, found := v1Tov2MetricsConversion[pc.name]
 	if found { // ignored
 		pc.name = v2Name
 		return true // listed above are all aggregatable
 	}
+	if v2Metrics[pc.name] {
+		// reported by the HTTP metrics API, which is already converted
+		return true
+	}
 	_, found = aggregatableSet[pc.name]
 	return found
 }
 
-// AllMetrics returns metrics tracked within this collector.
-// The sets of metrics from cluster level and table level are completely equal.
+// v2Metrics is the set of the converted names in v1Tov2MetricsConversion.
+var v2Metrics = func() map[string]bool {
+	res := make(map[string]bool)
+	for _, v2Name := range v1Tov2MetricsConversion {
+		res[v2Name] = true
+	}
+	return res
+}()
+
+// AllMetrics returns metrics tracked on table level within this collector.
+// They are all tracked on cluster level as well, see ClusterMetrics.
 func AllMetrics() (res []string) {
 	for _, newName := range v1Tov2MetricsConversion {
 		res = append(res, newName)
@@ -71,5 +123,20 @@ func AllMetrics() (res []string) {
 	for name := range aggregatableSet {
 		res = append(res, name)
 	}
+	for _, name := range derivedMetricNames() {
+		if _, found := aggregatableSet[name]; !found {
+			res = append(res, name)
+		}
+	}
+	return res
+}
+
+// ClusterMetrics returns metrics tracked on cluster level, which are AllMetrics
+// plus the cluster-only metrics like "dead_node_count".
+func ClusterMetrics() []string {
+	res := AllMetrics()
+	for name := range clusterOnlyMetrics {
+		res = append(res, name)
+	}
 	return res
 }
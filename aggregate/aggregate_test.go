@@ -0,0 +1,16 @@
+package aggregate
+
+import (
+	"testing"
+
+	"go.uber.org/goleak"
+)
+
+// TestMain fails the tests if any goroutine is leaked after all tests are done,
+// e.g. a session that is never closed.
+func TestMain(m *testing.M) {
+	goleak.VerifyTestMain(m,
+		// the log file of pegasus-go-client is rotated in the background for the whole process
+		goleak.IgnoreTopFunction("gopkg.in/natefinch/lumberjack%2ev2.(*Logger).millRun"),
+	)
+}
@@ -0,0 +1,129 @@
+package aggregate
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+)
+
+// AggregationPolicy is how the values of a metric are aggregated over the partitions of
+// a table, and over the tables of the cluster.
+type AggregationPolicy string
+
+const (
+	// AggregateSum sums the values up. It's the default policy.
+	AggregateSum AggregationPolicy = "sum"
+
+	// AggregateMax takes the largest value, e.g. for the latency percentiles.
+	AggregateMax AggregationPolicy = "max"
+
+	// AggregateMin takes the smallest value.
+	AggregateMin AggregationPolicy = "min"
+
+	// AggregateAvg takes the mean of the values, e.g. for the gauge-like metrics.
+	AggregateAvg AggregationPolicy = "avg"
+
+	// AggregateWeightedAvg takes the mean of the values weighted by another metric, e.g.
+	// the average latency weighted by the QPS. It falls back to AggregateAvg if the total
+	// weight is zero.
+	AggregateWeightedAvg AggregationPolicy = "weighted_avg"
+)
+
+// AggregationRule applies the policy to the metrics whose names match the pattern.
+type AggregationRule struct {
+	Pattern *regexp.Regexp
+	Policy  AggregationPolicy
+
+	// The metric whose values are the weights of AggregateWeightedAvg, e.g. "get_qps".
+	WeightMetric string
+}
+
+// NewAggregationRule validates and returns an AggregationRule.
+func NewAggregationRule(pattern string, policy AggregationPolicy, weightMetric string) (AggregationRule, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return AggregationRule{}, fmt.Errorf("invalid pattern of aggregation rule: %s", err)
+	}
+	switch policy {
+	case AggregateSum, AggregateMax, AggregateMin, AggregateAvg:
+	case AggregateWeightedAvg:
+		if weightMetric == "" {
+			return AggregationRule{}, fmt.Errorf("no weight metric is given for \"%s\" of %s", policy, pattern)
+		}
+	default:
+		return AggregationRule{}, fmt.Errorf("invalid aggregation policy \"%s\" of %s", policy, pattern)
+	}
+	return AggregationRule{Pattern: re, Policy: policy, WeightMetric: weightMetric}, nil
+}
+
+// ruleOf returns the first rule matching the metric, or nil if the metric is summed up.
+func (o AggregateOptions) ruleOf(name string) *AggregationRule {
+	for i := range o.Rules {
+		if o.Rules[i].Pattern.MatchString(name) {
+			return &o.Rules[i]
+		}
+	}
+	return nil
+}
+
+// aggregateStats aggregates the stats of the entities (the partitions of a table or the tables
+// of the cluster) by the rules. The summed values are divided by `divisor`.
+func aggregateStats(entities []map[string]float64, options AggregateOptions, divisor float64) map[string]float64 {
+	// metric name -> the values over the entities
+	values := make(map[string][]float64)
+	// metric name -> the weights of the values, for AggregateWeightedAvg only
+	weights := make(map[string][]float64)
+	for _, stats := range entities {
+		for name, value := range stats {
+			values[name] = append(values[name], value)
+			if rule := options.ruleOf(name); rule != nil && rule.Policy == AggregateWeightedAvg {
+				weights[name] = append(weights[name], stats[rule.WeightMetric])
+			}
+		}
+	}
+
+	res := make(map[string]float64, len(values))
+	for name, vals := range values {
+		policy := AggregateSum
+		if rule := options.ruleOf(name); rule != nil {
+			policy = rule.Policy
+		}
+		res[name] = aggregateValues(policy, vals, weights[name], divisor)
+	}
+	return res
+}
+
+func aggregateValues(policy AggregationPolicy, values []float64, weights []float64, divisor float64) float64 {
+	sum := float64(0)
+	for _, v := range values {
+		sum += v
+	}
+	switch policy {
+	case AggregateMax:
+		max := math.Inf(-1)
+		for _, v := range values {
+			max = math.Max(max, v)
+		}
+		return max
+	case AggregateMin:
+		min := math.Inf(1)
+		for _, v := range values {
+			min = math.Min(min, v)
+		}
+		return min
+	case AggregateAvg:
+		return sum / float64(len(values))
+	case AggregateWeightedAvg:
+		weightedSum, totalWeight := float64(0), float64(0)
+		for i, v := range values {
+			weightedSum += v * weights[i]
+			totalWeight += weights[i]
+		}
+		if totalWeight == 0 {
+			return sum / float64(len(values))
+		}
+		return weightedSum / totalWeight
+	default:
+		return sum / divisor
+	}
+}
@@ -0,0 +1,71 @@
+package aggregate
+
+import (
+	"testing"
+
+	"github.com/XiaoMi/pegasus-go-client/idl/admin"
+	"github.com/stretchr/testify/assert"
+)
+
+func mustAggregationRule(t *testing.T, pattern string, policy AggregationPolicy, weight string) AggregationRule {
+	rule, err := NewAggregationRule(pattern, policy, weight)
+	assert.Nil(t, err)
+	return rule
+}
+
+func TestNewAggregationRule(t *testing.T) {
+	_, err := NewAggregationRule("(", AggregateMax, "")
+	assert.NotNil(t, err)
+	_, err = NewAggregationRule("_p99$", "median", "")
+	assert.NotNil(t, err)
+	_, err = NewAggregationRule("latency", AggregateWeightedAvg, "")
+	assert.NotNil(t, err)
+}
+
+func TestAggregateStatsByRules(t *testing.T) {
+	options := AggregateOptions{Rules: []AggregationRule{
+		mustAggregationRule(t, "^get_latency$", AggregateWeightedAvg, "get_qps"),
+		mustAggregationRule(t, "_p99$", AggregateMax, ""),
+		mustAggregationRule(t, "_p50$", AggregateMin, ""),
+		mustAggregationRule(t, "^sst_count$", AggregateAvg, ""),
+	}}
+	entities := []map[string]float64{
+		{"get_qps": 100, "get_latency": 10, "get_p99": 50, "get_p50": 5, "sst_count": 2},
+		{"get_qps": 300, "get_latency": 20, "get_p99": 80, "get_p50": 3, "sst_count": 4},
+	}
+	assert.Equal(t, aggregateStats(entities, options, 1), map[string]float64{
+		"get_qps":     400,
+		"get_latency": 17.5,
+		"get_p99":     80,
+		"get_p50":     3,
+		"sst_count":   3,
+	})
+
+	// the weighted average falls back to the average without any weight
+	entities = []map[string]float64{{"get_latency": 10}, {"get_latency": 20}}
+	assert.Equal(t, aggregateStats(entities, options, 1), map[string]float64{"get_latency": 15})
+}
+
+func TestAggregateClusterStatsByRules(t *testing.T) {
+	ag := &tableStatsAggregator{
+		tables: make(map[int32]*TableStats),
+		aggregation: AggregateOptions{Rules: []AggregationRule{
+			mustAggregationRule(t, "_p99$", AggregateMax, ""),
+		}},
+	}
+	ag.doUpdateTableMap([]*admin.AppInfo{
+		{AppID: 1, AppName: "stat", PartitionCount: 2},
+		{AppID: 2, AppName: "test", PartitionCount: 1},
+	})
+	ag.tables[1].Partitions[0].getOrInitStats()["get_p99"] = 50
+	ag.tables[1].Partitions[1].getOrInitStats()["get_p99"] = 70
+	ag.tables[2].Partitions[0].getOrInitStats()["get_p99"] = 60
+	for _, tb := range ag.tables {
+		tb.aggregate(ag.aggregation)
+	}
+	ag.aggregateClusterStats()
+
+	assert.Equal(t, ag.tables[1].Stats["get_p99"], float64(70))
+	assert.Equal(t, ag.tables[2].Stats["get_p99"], float64(60))
+	assert.Equal(t, ag.allStats.Stats["get_p99"], float64(70))
+}
@@ -0,0 +1,60 @@
+package aggregate
+
+import (
+	"math"
+)
+
+// MetricSummary is the statistics of a metric across the partitions of a table.
+type MetricSummary struct {
+	Sum      float64
+	Mean     float64
+	StdDev   float64
+	Variance float64
+	Min      float64
+	Max      float64
+
+	// The number of partitions reporting this metric.
+	PartitionCount int
+}
+
+// AggregationReport is the statistics of all metrics of a table.
+type AggregationReport struct {
+	TableName string
+
+	// perfCounter's name -> the statistics.
+	Metrics map[string]*MetricSummary
+}
+
+// AggregateStats computes the statistics of each metric across the partitions.
+// The variance is the population variance, computed by Welford's online algorithm
+// for numerical stability.
+func (tb *TableStats) AggregateStats() AggregationReport {
+	report := AggregationReport{
+		TableName: tb.TableName,
+		Metrics:   make(map[string]*MetricSummary),
+	}
+	// perfCounter's name -> the sum of squares of differences from the current mean
+	m2 := make(map[string]float64)
+	for _, part := range tb.Partitions {
+		for name, value := range part.Stats {
+			s, found := report.Metrics[name]
+			if !found {
+				s = &MetricSummary{Min: math.Inf(1), Max: math.Inf(-1)}
+				report.Metrics[name] = s
+			}
+			s.PartitionCount++
+			s.Sum += value
+			s.Min = math.Min(s.Min, value)
+			s.Max = math.Max(s.Max, value)
+
+			delta := value - s.Mean
+			s.Mean += delta / float64(s.PartitionCount)
+			m2[name] += delta * (value - s.Mean)
+		}
+	}
+	for name, s := range report.Metrics {
+		s.Variance = m2[name] / float64(s.PartitionCount)
+		s.StdDev = math.Sqrt(s.Variance)
+	}
+	return report
+}
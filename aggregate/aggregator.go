@@ -1,27 +1,68 @@
 package aggregate
 
 import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
 	"time"
 
 	"github.com/XiaoMi/pegasus-go-client/idl/admin"
-	log "github.com/sirupsen/logrus"
+	"github.com/XiaoMi/pegasus-go-client/idl/base"
+	"github.com/XiaoMi/pegasus-go-client/idl/replication"
+	"github.com/pegasus-kv/collector/logging"
+	"github.com/pegasus-kv/collector/reload"
+	"github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
 	"gopkg.in/tomb.v2"
 )
 
+var log = logging.Module("aggregate")
+
 // TableStatsAggregator aggregates the metric on each partition into table-level metrics.
 // It's reponsible for all tables in the pegasus cluster.
 // After all TableStats have been collected, TableStatsAggregator sums them up into a
 // ClusterStats. Users of this pacakage can use the hooks to watch every changes of the stats.
 type TableStatsAggregator interface {
-	Aggregate() (map[int32]*TableStats, *ClusterStats)
+	// Aggregate collects and aggregates the stats. The aggregation is skipped if the
+	// meta servers are unreachable, in which case the stats of the last round are returned.
+	// The in-flight queries are abandoned once `ctx` is done.
+	Aggregate(ctx context.Context) (map[int32]*TableStats, *ClusterStats)
+
+	// Splits returns the channel of partition split events, which are detected
+	// when the table map is updated before each aggregation.
+	Splits() <-chan *SplitEvent
+
+	// Close terminates the sessions to the pegasus cluster.
+	Close()
 }
 
+// splitEventsCapacity is the buffer size of the split events channel. Events are dropped
+// if the channel is full.
+const splitEventsCapacity = 16
+
 // NewTableStatsAggregator returns a TableStatsAggregator instance.
 func NewTableStatsAggregator(metaAddrs []string) TableStatsAggregator {
+	return NewTableStatsAggregatorWithOptions(metaAddrs, DefaultPerfClientOptions())
+}
+
+// NewTableStatsAggregatorWithOptions returns a TableStatsAggregator instance whose
+// PerfClient is configured with `opts`.
+func NewTableStatsAggregatorWithOptions(metaAddrs []string, opts PerfClientOptions) TableStatsAggregator {
 	return &tableStatsAggregator{
 		tables: make(map[int32]*TableStats),
-		client: NewPerfClient(metaAddrs),
+		client: NewPerfClientWithOptions(metaAddrs, opts),
+		expiry: newMetricExpiryTracker(opts.MetricExpiryWindow),
+		rates:  newCounterRateTracker(opts.CumulativeCounters),
+		splits: make(chan *SplitEvent, splitEventsCapacity),
+
+		configChanges: newConfigChangeTracker(),
+		liveness:      newNodeLivenessTracker(opts.NodeFlapWindow, opts.NodeFlapThreshold),
+
+		aggregation: opts.Aggregation,
+
+		cluster:    opts.ClusterName,
+		additional: opts.Additional,
 	}
 }
 
@@ -30,55 +71,428 @@ type tableStatsAggregator struct {
 	allStats *ClusterStats
 
 	client *PerfClient
+
+	expiry *metricExpiryTracker
+
+	rates *counterRateTracker
+
+	configChanges *configChangeTracker
+
+	liveness *nodeLivenessTracker
+
+	// the leader of the meta servers in the last round
+	metaLeader string
+
+	aggregation AggregateOptions
+
+	splits chan *SplitEvent
+
+	cluster string
+	// true if the cluster is not the first one configured, whose stats are passed to the hooks
+	// of all clusters only
+	additional bool
+}
+
+// noHooks is the hooks of the additional clusters, which is always empty.
+var noHooks tableStatsHooksManager
+
+// logger returns the logger tagged with the cluster.
+func (ag *tableStatsAggregator) logger() *logrus.Entry {
+	return log.WithField("cluster", ag.cluster)
+}
+
+// hooks returns the hooks of the first cluster, or noHooks for the additional clusters.
+func (ag *tableStatsAggregator) hooks() *tableStatsHooksManager {
+	if ag.additional {
+		return &noHooks
+	}
+	return &hooksManager
+}
+
+// ClusterConfig is a pegasus cluster to collect.
+type ClusterConfig struct {
+	Name        string
+	MetaServers []string `mapstructure:"meta_servers"`
+}
+
+// ClustersFromConfig returns the clusters of "clusters", each of which is like
+// `{name: onebox, meta_servers: ["127.0.0.1:34601"]}`, or the single cluster of "cluster_name"
+// and "meta_servers" if it's empty. No cluster is returned if neither is given while the
+// clusters are discovered from ZooKeeper.
+func ClustersFromConfig() ([]ClusterConfig, error) {
+	var clusters []ClusterConfig
+	if err := viper.UnmarshalKey("clusters", &clusters); err != nil {
+		return nil, err
+	}
+	if len(clusters) == 0 {
+		metaServers := viper.GetStringSlice("meta_servers")
+		if len(metaServers) == 0 && viper.GetString("meta_server") != "" {
+			// for compatibility
+			metaServers = []string{viper.GetString("meta_server")}
+		}
+		if len(metaServers) == 0 && len(viper.GetStringSlice("discovery.zookeeper.servers")) != 0 {
+			return nil, nil
+		}
+		clusters = []ClusterConfig{{Name: viper.GetString("cluster_name"), MetaServers: metaServers}}
+	}
+	names := make(map[string]bool)
+	for _, c := range clusters {
+		if c.Name == "" || len(c.MetaServers) == 0 {
+			return nil, fmt.Errorf("the name and the meta servers of every cluster are required: %+v", c)
+		}
+		if names[c.Name] {
+			return nil, fmt.Errorf("duplicate cluster %q", c.Name)
+		}
+		names[c.Name] = true
+	}
+	return clusters, nil
 }
 
-// Start looping for metrics aggregation
+// Start looping for metrics aggregation of every cluster, including those discovered from
+// "discovery.zookeeper" if it's configured. The stats of the first cluster configured, or of
+// the discovered cluster of "cluster_name" if none is configured, are passed to all hooks,
+// while those of the others are passed to the hooks of all clusters only, e.g.
+// AddHookAfterClusterStatsEmitted. The clusters and the options of the collection are
+// reloaded once their config changes.
 func Start(tom *tomb.Tomb) {
-	aggregateInterval := viper.GetDuration("metrics.report_interval")
-	ticker := time.NewTicker(aggregateInterval)
+	clusters, err := ClustersFromConfig()
+	if err != nil {
+		log.Fatal(err)
+		return
+	}
+	discovery, err := DiscoveryFromConfig()
+	if err != nil {
+		log.Fatal(err)
+		return
+	}
+	opts, err := PerfClientOptionsFromConfig()
+	if err != nil {
+		log.Fatal(err)
+		return
+	}
+	if opts.SASL != nil {
+		log.Infof("authenticate to the Pegasus servers as %s by Kerberos", viper.GetString("kerberos.principal"))
+	}
 
-	metaAddr := viper.GetString("meta_server")
-	ag := NewTableStatsAggregator([]string{metaAddr})
+	// cancel the in-flight aggregation on shutdown
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-tom.Dying()
+		cancel()
+	}()
+
+	g := newClusterGroup(ctx, opts, viper.GetDuration("metrics.report_interval"), primaryCluster(clusters))
+	g.updateConfigured(clusters, g.primary)
+	reload.AddHookAfterChanged(perfClientOptionKeys, func() error {
+		opts, err := PerfClientOptionsFromConfig()
+		if err != nil {
+			return err
+		}
+		g.setOptions(opts, viper.GetDuration("metrics.report_interval"))
+		return nil
+	})
+	reload.AddHookAfterChanged([]string{"clusters", "cluster_name", "meta_servers", "meta_server"}, func() error {
+		clusters, err := ClustersFromConfig()
+		if err != nil {
+			return err
+		}
+		g.updateConfigured(clusters, primaryCluster(clusters))
+		return nil
+	})
+	if discovery != nil {
+		d, err := newZkDiscovery(*discovery)
+		if err != nil {
+			log.Fatal("failed to connect to the ZooKeeper of the cluster discovery: ", err)
+			return
+		}
+		log.Infof("discover the clusters under %s of ZooKeeper %v", discovery.Path, discovery.Servers)
+		d.watch(ctx, g.updateDiscovered)
+	}
+	g.wait()
+}
+
+// primaryCluster returns the first cluster configured, or "cluster_name" if none is configured.
+func primaryCluster(clusters []ClusterConfig) string {
+	if len(clusters) != 0 {
+		return clusters[0].Name
+	}
+	return viper.GetString("cluster_name")
+}
 
+// perfClientOptionKeys are the config keys of PerfClientOptionsFromConfig, together with the
+// interval of the collection.
+var perfClientOptionKeys = []string{
+	"metrics.report_interval",
+	"metrics.backend",
+	"metrics.cumulative_counters",
+	"metrics.primaries_only",
+	"metrics.collect_secondaries",
+	"metrics.fanout_concurrency",
+	"metrics.node_timeout",
+	"metrics.scrape_timeout",
+	"metrics.partition_config_cache_ttl",
+	"metrics.node_flap_window",
+	"metrics.node_flap_threshold",
+	"metrics.aggregation_rules",
+	"metrics.tables",
+	"kerberos",
+}
+
+// PerfClientOptionsFromConfig returns the options of the collection from the config.
+func PerfClientOptionsFromConfig() (PerfClientOptions, error) {
+	opts := DefaultPerfClientOptions()
+	opts.MetricsBackend = MetricsBackend(viper.GetString("metrics.backend"))
+	switch opts.MetricsBackend {
+	case "", MetricsBackendPerfCounter, MetricsBackendHTTP, MetricsBackendAuto:
+	default:
+		return opts, fmt.Errorf("invalid metrics.backend %q, which should be one of %q, %q and %q",
+			opts.MetricsBackend, MetricsBackendPerfCounter, MetricsBackendHTTP, MetricsBackendAuto)
+	}
+	opts.CumulativeCounters = viper.GetStringSlice("metrics.cumulative_counters")
+	if viper.IsSet("metrics.primaries_only") {
+		opts.PrimariesOnly = viper.GetBool("metrics.primaries_only")
+	}
+	opts.CollectSecondaries = viper.GetBool("metrics.collect_secondaries")
+	opts.FanOutConcurrency = viper.GetInt("metrics.fanout_concurrency")
+	opts.NodeTimeout = viper.GetDuration("metrics.node_timeout")
+	opts.ScrapeTimeout = viper.GetDuration("metrics.scrape_timeout")
+	opts.PartitionConfigCacheTTL = viper.GetDuration("metrics.partition_config_cache_ttl")
+	opts.NodeFlapWindow = viper.GetDuration("metrics.node_flap_window")
+	opts.NodeFlapThreshold = viper.GetInt("metrics.node_flap_threshold")
+	rules, err := aggregationRulesFromConfig()
+	if err != nil {
+		return opts, err
+	}
+	opts.Aggregation.Rules = rules
+	if opts.TableFilter, err = tableFilterFromConfig(); err != nil {
+		return opts, err
+	}
+	kerberos, err := KerberosFromConfig()
+	if err != nil {
+		return opts, err
+	}
+	if kerberos != nil {
+		if opts.SASL, err = NewSASLAuthenticator(*kerberos); err != nil {
+			return opts, err
+		}
+	}
+	return opts, nil
+}
+
+// loopAggregation aggregates the stats every interval until ctx is done.
+func loopAggregation(ctx context.Context, ag TableStatsAggregator, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
 	for {
 		select {
-		case <-tom.Dying(): // check if context cancelled
+		case <-ctx.Done():
 			return
 		case <-ticker.C:
 		}
 
-		ag.Aggregate()
+		ag.Aggregate(ctx)
+	}
+}
+
+// aggregationRulesFromConfig parses the rules of "metrics.aggregation_rules", each of which is
+// like `{pattern: "^get_latency$", policy: weighted_avg, weight: get_qps}`.
+func aggregationRulesFromConfig() ([]AggregationRule, error) {
+	var cfgs []struct {
+		Pattern string
+		Policy  string
+		Weight  string
+	}
+	if err := viper.UnmarshalKey("metrics.aggregation_rules", &cfgs); err != nil {
+		return nil, err
+	}
+	var rules []AggregationRule
+	for _, cfg := range cfgs {
+		rule, err := NewAggregationRule(cfg.Pattern, AggregationPolicy(cfg.Policy), cfg.Weight)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
 	}
+	return rules, nil
 }
 
-func (ag *tableStatsAggregator) Aggregate() (map[int32]*TableStats, *ClusterStats) {
-	ag.updateTableMap()
+func (ag *tableStatsAggregator) Aggregate(ctx context.Context) (map[int32]*TableStats, *ClusterStats) {
+	start := time.Now()
+	defer func() {
+		aggregationDuration.WithLabelValues(ag.cluster).Observe(time.Since(start).Seconds())
+	}()
+	// the nodes are listed from meta once per round, which also tells whether meta is reachable
+	statuses, err := ag.client.listNodeStatuses(ctx)
+	if err != nil {
+		// the last stats are returned
+		err = fmt.Errorf("meta servers are unreachable: %s", err)
+		ag.logger().Errorf("skip the aggregation: %s", err)
+		allClustersHooks.afterClusterAggregated(AggregationResult{Cluster: ag.cluster, Time: time.Now(), MetaErr: err})
+		return ag.tables, ag.allStats
+	}
+	ag.updateTableMap(ctx)
 
-	// TODO(wutao1): reduce meta queries for listing nodes
-	partitions := ag.client.GetPartitionStats()
+	ag.client.updateNodesWithStatuses(ctx, statuses)
+	partitions, err := ag.client.collectPartitionStats(ctx)
+	if err != nil {
+		// the partitions of the failed nodes keep their last stats
+		ag.logger().Warnf("the stats are partially collected: %s", err)
+	}
+	// the secondaries are replaced by the latest collection
+	for _, table := range ag.tables {
+		table.Secondaries = nil
+	}
+	var primaries []*PartitionStats
 	for _, p := range partitions {
+		if ag.rates != nil && ag.rates.enabled() {
+			ag.rates.convert(p)
+		}
+		if p.IsSecondary() {
+			ag.updateSecondaryStat(p)
+			continue
+		}
 		ag.updatePartitionStat(p)
+		primaries = append(primaries, p)
+	}
+	if ag.expiry != nil && ag.expiry.enabled() {
+		ag.expireStaleMetrics(primaries)
+	}
+	if ag.configChanges != nil {
+		ag.detectConfigChanges(ag.client.lastPartitionConfigs())
 	}
 
 	var batchTableStats []TableStats
 	for _, table := range ag.tables {
-		table.aggregate()
+		table.aggregate(ag.aggregation)
 		batchTableStats = append(batchTableStats, *table)
 	}
 	ag.aggregateClusterStats()
-	hooksManager.afterTableStatsEmitted(batchTableStats, *ag.allStats)
+	ag.updateClusterHealthStats(ctx, statuses)
+	ag.updateMetaLeader(ctx)
+	ag.hooks().afterTableStatsEmitted(batchTableStats, *ag.allStats)
+	allClustersHooks.afterTableStatsEmitted(batchTableStats, *ag.allStats)
+	if ag.hooks().hasNodeHooks() || allClustersHooks.hasNodeHooks() {
+		nodes := aggregateNodeStats(ag.tables, ag.aggregation.Rules, ag.allStats.Timestamp)
+		for i := range nodes {
+			nodes[i].Cluster = ag.cluster
+		}
+		ag.hooks().afterNodeStatsEmitted(nodes)
+		allClustersHooks.afterNodeStatsEmitted(nodes)
+	}
+	if ag.hooks().hasDiagnosedHooks() {
+		ag.hooks().afterCollectionDiagnosed(ag.diagnose())
+	}
+	ag.client.evaluateAlerts(ag.tables)
+	allClustersHooks.afterClusterAggregated(AggregationResult{Cluster: ag.cluster, Time: time.Now(), ScrapeErr: err})
 
 	return ag.tables, ag.allStats
 }
 
+func (ag *tableStatsAggregator) Splits() <-chan *SplitEvent {
+	return ag.splits
+}
+
+func (ag *tableStatsAggregator) Close() {
+	ag.client.Close()
+}
+
 func (ag *tableStatsAggregator) aggregateClusterStats() {
-	ag.allStats = &ClusterStats{
-		Stats:     make(map[string]float64),
-		Timestamp: time.Now(),
+	ag.allStats = &ClusterStats{Cluster: ag.cluster, Timestamp: time.Now()}
+	entities := make([]map[string]float64, 0, len(ag.tables))
+	for _, table := range ag.tables {
+		entities = append(entities, table.Stats)
 	}
+	// the normalization by partition count applies to the tables only
+	ag.allStats.Stats = aggregateStats(entities, AggregateOptions{Rules: ag.aggregation.Rules}, 1)
+	for k := range clusterReplicaStats {
+		delete(ag.allStats.Stats, k)
+	}
+	reapplyDerivedMetrics(ag.allStats.Stats)
+	extendClusterReplicaStats(ag.allStats, ag.tables)
+	extendClusterStorageStats(ag.allStats, ag.tables)
+
+	entities = entities[:0]
 	for _, table := range ag.tables {
-		for k, v := range table.Stats {
-			ag.allStats.Stats[k] += v
+		if table.SecondaryStats != nil {
+			entities = append(entities, table.SecondaryStats)
+		}
+	}
+	if len(entities) != 0 {
+		ag.allStats.SecondaryStats = aggregateStats(entities, AggregateOptions{Rules: ag.aggregation.Rules}, 1)
+		reapplyDerivedMetrics(ag.allStats.SecondaryStats)
+	}
+}
+
+// aggregateNodeStats aggregates the stats of the partitions on each node by the rules,
+// sorted by the address.
+func aggregateNodeStats(tables map[int32]*TableStats, rules []AggregationRule, now time.Time) []NodeStat {
+	// node address -> the stats of the partitions on the node
+	entities := make(map[string][]map[string]float64)
+	for _, table := range tables {
+		for _, part := range table.Partitions {
+			if part.Addr == "" || !part.HasStats() {
+				continue
+			}
+			entities[part.Addr] = append(entities[part.Addr], part.Stats)
+		}
+	}
+	res := make([]NodeStat, 0, len(entities))
+	for addr, stats := range entities {
+		node := NodeStat{
+			Addr:        addr,
+			Stats:       aggregateStats(stats, AggregateOptions{Rules: rules}, 1),
+			CollectedAt: now,
+		}
+		reapplyDerivedMetrics(node.Stats)
+		res = append(res, node)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Addr < res[j].Addr
+	})
+	return res
+}
+
+// clusterReplicaStats are the table stats that are meaningless to be summed up over tables.
+var clusterReplicaStats = map[string]bool{
+	"min_replica_count": true,
+	"max_replica_count": true,
+	"avg_replica_count": true,
+}
+
+// Extends the cluster stats with min/max/avg_replica_count over the partitions of all tables.
+func extendClusterReplicaStats(allStats *ClusterStats, tables map[int32]*TableStats) {
+	count := 0
+	min, max, sum := math.Inf(1), math.Inf(-1), float64(0)
+	for _, tb := range tables {
+		for _, part := range tb.Partitions {
+			v, found := part.Stats["replica_count"]
+			if !found {
+				continue
+			}
+			count++
+			min = math.Min(min, v)
+			max = math.Max(max, v)
+			sum += v
+		}
+	}
+	if count == 0 {
+		return
+	}
+	allStats.Stats["min_replica_count"] = min
+	allStats.Stats["max_replica_count"] = max
+	allStats.Stats["avg_replica_count"] = sum / float64(count)
+}
+
+// expireStaleMetrics removes the metrics that are not reported in a number of consecutive cycles.
+func (ag *tableStatsAggregator) expireStaleMetrics(reported []*PartitionStats) {
+	ag.expiry.nextCycle()
+	for _, p := range reported {
+		ag.expiry.observe(p.Gpid.String(), p.Stats)
+	}
+	for _, tb := range ag.tables {
+		for _, part := range tb.Partitions {
+			ag.expiry.expire(part.Gpid.String(), part.Stats)
 		}
 	}
 }
@@ -86,35 +500,71 @@ func (ag *tableStatsAggregator) aggregateClusterStats() {
 // Some tables may disappear (be dropped) or first show up.
 // This function maintains the local table map
 // to keep consistent with the pegasus cluster.
-func (ag *tableStatsAggregator) updateTableMap() {
-	tables := ag.client.listTables()
+func (ag *tableStatsAggregator) updateTableMap(ctx context.Context) {
+	tables, err := ag.client.listCollectedTables(ctx)
+	if err != nil {
+		// keep the local tables rather than dropping all of them
+		ag.logger().Error(err)
+		return
+	}
 	ag.doUpdateTableMap(tables)
 }
 
 func (ag *tableStatsAggregator) doUpdateTableMap(tables []*admin.AppInfo) {
 	currentTableSet := make(map[int32]*struct{})
+	var splitPrev, splitCurr []*TableStats
 	for _, tb := range tables {
 		currentTableSet[tb.AppID] = nil
-		if _, found := ag.tables[tb.AppID]; !found {
+		prevTb, found := ag.tables[tb.AppID]
+		if !found {
 			// non-exisistent table, create it
 			ag.tables[tb.AppID] = newTableStats(tb)
-			log.Infof("found new table: %+v", tb)
-
-			// TODO(wutao1): some tables may have partitions splitted,
-			//               recreate the tableStats then.
+			ag.logger().WithField("table", tb.AppName).Infof("found new table: %+v", tb)
+		} else if int(tb.PartitionCount) > len(prevTb.Partitions) {
+			// the table has partitions splitted, recreate the tableStats
+			ag.tables[tb.AppID] = newTableStats(tb)
+			splitPrev = append(splitPrev, prevTb)
+			splitCurr = append(splitCurr, ag.tables[tb.AppID])
+		} else {
+			// the envs may be updated
+			prevTb.Metadata = newTableMetadata(tb)
 		}
 	}
+	for _, event := range DetectPartitionSplits(splitPrev, splitCurr) {
+		ag.logger().WithField("table", event.TableName).Infof("partition split detected: %+v", event)
+		ag.emitSplitEvent(event)
+	}
 	for appID, tb := range ag.tables {
 		// disappeared table, delete it
 		if _, found := currentTableSet[appID]; !found {
-			log.Infof("remove table from collector: {AppID: %d, PartitionCount: %d}", appID, len(tb.Partitions))
+			ag.logger().WithField("table", tb.TableName).Infof("remove table from collector: {AppID: %d, PartitionCount: %d}", appID, len(tb.Partitions))
 			delete(ag.tables, appID)
+			for _, part := range tb.Partitions {
+				if ag.expiry != nil {
+					ag.expiry.forget(part.Gpid.String())
+				}
+				if ag.rates != nil {
+					ag.rates.forget(part.Gpid.String())
+				}
+			}
+			if ag.configChanges != nil {
+				ag.configChanges.forget(appID)
+			}
 
-			hooksManager.afterTableDropped(appID)
+			ag.hooks().afterTableDropped(appID)
+			allClustersHooks.afterClusterTableDropped(ag.cluster, appID)
 		}
 	}
 }
 
+func (ag *tableStatsAggregator) emitSplitEvent(event *SplitEvent) {
+	select {
+	case ag.splits <- event:
+	default:
+		ag.logger().WithField("table", event.TableName).Warnf("split events channel is full, drop event of table %s", event.TableName)
+	}
+}
+
 // Update the counter value.
 func (ag *tableStatsAggregator) updatePartitionStat(pc *PartitionStats) {
 	tb, found := ag.tables[pc.Gpid.Appid]
@@ -124,8 +574,59 @@ func (ag *tableStatsAggregator) updatePartitionStat(pc *PartitionStats) {
 	}
 	part, found := tb.Partitions[int(pc.Gpid.PartitionIndex)]
 	if !found {
-		log.Errorf("no such partition %+v", pc.Gpid)
+		ag.logger().Errorf("no such partition %+v", pc.Gpid)
 		return
 	}
 	*part = *pc
 }
+
+// detectConfigChanges emits the events of the partitions whose configurations have changed
+// since the last round, and marks these partitions with the stats "primary_migration_count"
+// and "config_change_count", which are 1 in the round of the change, otherwise 0.
+func (ag *tableStatsAggregator) detectConfigChanges(configs map[base.Gpid]*replication.PartitionConfiguration) {
+	if configs == nil {
+		// no configuration is queried, e.g. in the dry-run mode
+		return
+	}
+	events := ag.configChanges.update(replicaConfigsOf(configs))
+	kinds := make(map[base.Gpid]ConfigChangeKind, len(events))
+	for _, e := range events {
+		ag.logger().Infof("the configuration of partition %s has changed: %s, primary %q -> %q, ballot %d -> %d",
+			e.Gpid.String(), e.Kind, e.OldPrimary, e.NewPrimary, e.OldBallot, e.NewBallot)
+		kinds[e.Gpid] = e.Kind
+	}
+	for gpid := range configs {
+		tb, found := ag.tables[gpid.Appid]
+		if !found {
+			continue
+		}
+		part, found := tb.Partitions[int(gpid.PartitionIndex)]
+		if !found || !part.HasStats() {
+			continue
+		}
+		kind, changed := kinds[gpid]
+		part.Stats["primary_migration_count"] = boolToFloat(kind == PrimaryMoved)
+		part.Stats["config_change_count"] = boolToFloat(changed)
+	}
+	if len(events) != 0 {
+		for _, e := range events {
+			e.Cluster = ag.cluster
+		}
+		ag.hooks().afterConfigChanged(events)
+	}
+}
+
+func boolToFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func (ag *tableStatsAggregator) updateSecondaryStat(pc *PartitionStats) {
+	tb, found := ag.tables[pc.Gpid.Appid]
+	if !found {
+		return
+	}
+	tb.Secondaries = append(tb.Secondaries, pc)
+}
@@ -1,7 +1,9 @@
 package aggregate
 
 import (
+	"context"
 	"testing"
+	"time"
 
 	"github.com/XiaoMi/pegasus-go-client/idl/admin"
 	"github.com/XiaoMi/pegasus-go-client/idl/base"
@@ -13,7 +15,8 @@ func TestUpdateLocalTableMap(t *testing.T) {
 		client: NewPerfClient([]string{"127.0.0.1:34601"}),
 		tables: make(map[int32]*TableStats),
 	}
-	ag.updateTableMap()
+	defer ag.Close()
+	ag.updateTableMap(context.Background())
 	assert.Equal(t, len(ag.tables), 2)
 	assert.Equal(t, len(ag.tables[1].Partitions), 4) // test
 	assert.Equal(t, len(ag.tables[2].Partitions), 8) // stat
@@ -63,16 +66,145 @@ func TestUpdatePartitionStats(t *testing.T) {
 
 func TestAggregate(t *testing.T) {
 	ag := NewTableStatsAggregator([]string{"127.0.0.1:34601"})
-	tableStats, allStat := ag.Aggregate()
+	defer ag.Close()
+	tableStats, allStat := ag.Aggregate(context.Background())
 	assert.Greater(t, len(allStat.Stats), 0)
 
 	assert.Equal(t, len(tableStats), 2)
 
-	// ensure len(tableStats) == len(partitionStats) == len(clusterStats)
+	// ensure partitionStats ⊆ tableStats ⊆ clusterStats, where the table-level replica stats
+	// and the cluster-only metrics like dead_node_count are derived in addition
+	assert.Contains(t, allStat.Stats, "dead_node_count")
+	assert.Contains(t, allStat.Stats, "alive_node_count")
+	assert.Equal(t, allStat.Stats["table_count"], float64(2))
+	assert.Equal(t, allStat.Stats["partition_count"], float64(12))
+	assert.Equal(t, allStat.Stats["unhealthy_partition_count"], float64(0))
+	clusterOnly := 0
+	for name := range allStat.Stats {
+		if _, found := clusterOnlyMetrics[name]; found {
+			clusterOnly++
+		}
+	}
 	for _, tb := range tableStats {
-		assert.Equal(t, len(tb.Stats), len(allStat.Stats))
+		assert.Equal(t, len(tb.Stats)+clusterOnly, len(allStat.Stats))
+		for name := range tb.Stats {
+			assert.Contains(t, allStat.Stats, name)
+		}
 		for _, p := range tb.Partitions {
-			assert.Equal(t, len(p.Stats), len(allStat.Stats))
+			for name := range p.Stats {
+				assert.Contains(t, tb.Stats, name)
+			}
 		}
 	}
 }
+
+func TestDiagnose(t *testing.T) {
+	ag := &tableStatsAggregator{
+		tables: make(map[int32]*TableStats),
+		client: &PerfClient{
+			nodeDurations: map[string]time.Duration{
+				"127.0.0.1:34801": time.Second,
+				"127.0.0.1:34802": 2 * time.Second,
+			},
+		},
+	}
+	ag.doUpdateTableMap([]*admin.AppInfo{{AppID: 1, AppName: "stat", PartitionCount: 2}})
+	ag.tables[1].Partitions[0].Addr = "127.0.0.1:34801"
+	ag.tables[1].Partitions[1].Addr = "127.0.0.1:34801"
+
+	diags := ag.diagnose()
+	assert.Equal(t, len(diags), 1)
+	assert.Equal(t, diags[0].TableName, "stat")
+	assert.Equal(t, diags[0].NodeDurations, map[string]time.Duration{"127.0.0.1:34801": time.Second})
+}
+
+func TestAggregateClusterReplicaStats(t *testing.T) {
+	ag := &tableStatsAggregator{tables: make(map[int32]*TableStats)}
+	ag.doUpdateTableMap([]*admin.AppInfo{
+		{AppID: 1, AppName: "stat", PartitionCount: 2},
+		{AppID: 2, AppName: "test", PartitionCount: 2},
+	})
+	ag.tables[1].Partitions[0].getOrInitStats()["replica_count"] = 3
+	ag.tables[1].Partitions[1].getOrInitStats()["replica_count"] = 3
+	ag.tables[2].Partitions[0].getOrInitStats()["replica_count"] = 2
+	ag.tables[2].Partitions[1].getOrInitStats()["replica_count"] = 3
+	for _, tb := range ag.tables {
+		tb.aggregate(AggregateOptions{})
+	}
+
+	ag.aggregateClusterStats()
+	assert.Equal(t, ag.allStats.Stats["replica_count"], float64(11))
+	assert.Equal(t, ag.allStats.Stats["min_replica_count"], float64(2))
+	assert.Equal(t, ag.allStats.Stats["max_replica_count"], float64(3))
+	assert.Equal(t, ag.allStats.Stats["avg_replica_count"], 11.0/4)
+}
+
+func TestAggregateNodeStats(t *testing.T) {
+	ag := &tableStatsAggregator{tables: make(map[int32]*TableStats)}
+	ag.doUpdateTableMap([]*admin.AppInfo{
+		{AppID: 1, AppName: "stat", PartitionCount: 2},
+		{AppID: 2, AppName: "test", PartitionCount: 1},
+	})
+	ag.tables[1].Partitions[0].Addr = "127.0.0.1:34801"
+	ag.tables[1].Partitions[0].getOrInitStats()["get_qps"] = 10
+	ag.tables[1].Partitions[1].Addr = "127.0.0.1:34802"
+	ag.tables[1].Partitions[1].getOrInitStats()["get_qps"] = 20
+	ag.tables[2].Partitions[0].Addr = "127.0.0.1:34801"
+	ag.tables[2].Partitions[0].getOrInitStats()["get_qps"] = 5
+
+	now := time.Now()
+	nodes := aggregateNodeStats(ag.tables, nil, now)
+	assert.Equal(t, nodes, []NodeStat{
+		{Addr: "127.0.0.1:34801", Stats: map[string]float64{"get_qps": 15}, CollectedAt: now},
+		{Addr: "127.0.0.1:34802", Stats: map[string]float64{"get_qps": 20}, CollectedAt: now},
+	})
+}
+
+func TestClusterMetrics(t *testing.T) {
+	assert.NotContains(t, AllMetrics(), "dead_node_count")
+	assert.Contains(t, ClusterMetrics(), "dead_node_count")
+	assert.Subset(t, ClusterMetrics(), AllMetrics())
+}
+
+func TestUpdateTableMetadata(t *testing.T) {
+	ag := &tableStatsAggregator{tables: make(map[int32]*TableStats)}
+	ag.doUpdateTableMap([]*admin.AppInfo{{AppID: 1, AppName: "stat", PartitionCount: 2}})
+	assert.Equal(t, ag.tables[1].Metadata.Envs, map[string]string{})
+
+	ag.doUpdateTableMap([]*admin.AppInfo{{AppID: 1, AppName: "stat", PartitionCount: 2, Envs: map[string]string{"default_ttl": "60"}}})
+	assert.Equal(t, ag.tables[1].Metadata.DefaultTTL, time.Minute)
+}
+
+func TestAggregateSecondaryStats(t *testing.T) {
+	ag := &tableStatsAggregator{tables: make(map[int32]*TableStats)}
+	ag.doUpdateTableMap([]*admin.AppInfo{
+		{AppID: 1, AppName: "stat", PartitionCount: 1},
+		{AppID: 2, AppName: "test", PartitionCount: 1},
+	})
+	ag.updatePartitionStat(&PartitionStats{
+		Gpid:  base.Gpid{Appid: 1, PartitionIndex: 0},
+		Addr:  "127.0.0.1:34801",
+		Stats: map[string]float64{"get_qps": 10},
+	})
+	for _, addr := range []string{"127.0.0.1:34802", "127.0.0.1:34803"} {
+		ag.updateSecondaryStat(&PartitionStats{
+			Gpid:  base.Gpid{Appid: 1, PartitionIndex: 0},
+			Addr:  addr,
+			Stats: map[string]float64{"get_qps": 1},
+			Role:  RoleSecondary,
+		})
+	}
+	for _, tb := range ag.tables {
+		tb.aggregate(AggregateOptions{})
+	}
+	ag.aggregateClusterStats()
+
+	assert.Equal(t, ag.tables[1].Stats["get_qps"], float64(10))
+	assert.Equal(t, ag.tables[1].SecondaryStats, map[string]float64{"get_qps": 2})
+	assert.Nil(t, ag.tables[2].SecondaryStats)
+	assert.Equal(t, ag.allStats.Stats["get_qps"], float64(10))
+	assert.Equal(t, ag.allStats.SecondaryStats, map[string]float64{"get_qps": 2})
+	// the secondaries are not counted as the partitions of the node
+	nodes := aggregateNodeStats(ag.tables, nil, time.Now())
+	assert.Equal(t, len(nodes), 1)
+}
@@ -0,0 +1,71 @@
+package aggregate
+
+// ThresholdDirection decides on which side of the threshold an alert fires.
+type ThresholdDirection int
+
+// The threshold directions.
+const (
+	// Above fires the alert when the value is greater than the threshold.
+	Above ThresholdDirection = iota
+	// Below fires the alert when the value is less than the threshold.
+	Below
+)
+
+// AlertFunc is called when a metric of the table crosses the threshold.
+type AlertFunc func(table *TableStats, metric string, value float64)
+
+// alertAllTables is the wildcard table name matching all tables.
+const alertAllTables = "*"
+
+type alertRule struct {
+	tableName  string
+	metricName string
+	threshold  float64
+	direction  ThresholdDirection
+	fn         AlertFunc
+}
+
+// RegisterAlert calls `fn` after each round of aggregation if the metric of the table
+// crosses the threshold in the direction. The table name "*" matches all tables.
+func (m *PerfClient) RegisterAlert(tableName, metricName string, threshold float64, direction ThresholdDirection, fn AlertFunc) {
+	m.alertsLock.Lock()
+	defer m.alertsLock.Unlock()
+	m.alerts = append(m.alerts, &alertRule{
+		tableName:  tableName,
+		metricName: metricName,
+		threshold:  threshold,
+		direction:  direction,
+		fn:         fn,
+	})
+}
+
+// evaluateAlerts calls the AlertFuncs of the fired alerts in goroutines. Each AlertFunc is given
+// a copy of the table, since the table is updated by the next round of aggregation.
+func (m *PerfClient) evaluateAlerts(tables map[int32]*TableStats) {
+	m.alertsLock.RLock()
+	defer m.alertsLock.RUnlock()
+
+	for _, rule := range m.alerts {
+		for _, tb := range tables {
+			if rule.tableName != alertAllTables && rule.tableName != tb.TableName {
+				continue
+			}
+			value, found := tb.Stats[rule.metricName]
+			if !found || !rule.crossed(value) {
+				continue
+			}
+			m.alertsWg.Add(1)
+			go func(rule *alertRule, snapshot *TableStats, value float64) {
+				defer m.alertsWg.Done()
+				rule.fn(snapshot, rule.metricName, value)
+			}(rule, tb.deepCopy(), value)
+		}
+	}
+}
+
+func (r *alertRule) crossed(value float64) bool {
+	if r.direction == Above {
+		return value > r.threshold
+	}
+	return value < r.threshold
+}
@@ -0,0 +1,46 @@
+package aggregate
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/XiaoMi/pegasus-go-client/idl/admin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEvaluateAlerts(t *testing.T) {
+	tables := map[int32]*TableStats{
+		1: newTableStats(&admin.AppInfo{AppID: 1, AppName: "stat", PartitionCount: 1}),
+		2: newTableStats(&admin.AppInfo{AppID: 2, AppName: "temp", PartitionCount: 1}),
+	}
+	tables[1].Stats["write_qps"] = 1000
+	tables[2].Stats["write_qps"] = 10
+
+	type firedAlert struct {
+		table string
+		value float64
+	}
+	fired := make(chan firedAlert, 10)
+	fn := func(table *TableStats, metric string, value float64) {
+		assert.Equal(t, metric, "write_qps")
+		assert.Equal(t, table.Stats[metric], value)
+		fired <- firedAlert{table.TableName, value}
+	}
+
+	pclient := &PerfClient{}
+	pclient.RegisterAlert("stat", "write_qps", 500, Above, fn)
+	pclient.RegisterAlert("*", "write_qps", 100, Below, fn)
+	pclient.RegisterAlert("*", "read_qps", 100, Below, fn) // absent metric
+	pclient.RegisterAlert("temp", "write_qps", 500, Above, fn)
+	pclient.evaluateAlerts(tables)
+	// the next round of aggregation doesn't affect the tables given to the AlertFuncs
+	tables[1].Stats["write_qps"] = 0
+	tables[2].Stats["write_qps"] = 0
+
+	var alerts []firedAlert
+	alerts = append(alerts, <-fired, <-fired)
+	sort.Slice(alerts, func(i, j int) bool { return alerts[i].table < alerts[j].table })
+	assert.Equal(t, alerts, []firedAlert{{"stat", 1000}, {"temp", 10}})
+	pclient.alertsWg.Wait()
+	assert.Empty(t, fired)
+}
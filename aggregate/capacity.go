@@ -0,0 +1,77 @@
+package aggregate
+
+import (
+	"context"
+
+	"github.com/XiaoMi/pegasus-go-client/idl/admin"
+)
+
+// ClusterCapacityStats is the size of the cluster.
+type ClusterCapacityStats struct {
+	TotalPartitions int
+
+	// The number of primaries and secondaries of all partitions.
+	TotalReplicas int
+
+	TotalDiskUsedBytes  float64
+	TotalDiskTotalBytes float64
+
+	// The number of alive replica nodes.
+	NodeCount int
+}
+
+// The perf-counters of the disk capacity on each replica node, in MB.
+const (
+	diskCapacityCounterPrefix = "replica*eon.replica_stub*disk."
+	diskCapacityTotalCounter  = diskCapacityCounterPrefix + "capacity.total(MB)"
+	diskCapacityAvailCounter  = diskCapacityCounterPrefix + "available.total(MB)"
+	bytesPerMB                = 1024 * 1024
+)
+
+// GetClusterCapacityStats returns the number of partitions, replicas and nodes, and the
+// disk usage of the cluster. The partitions are queried from meta, and the disk usage
+// is retrieved from the perf-counters of the alive replica nodes. The state of the client,
+// e.g. the sessions and the collection diagnostics, is left unchanged.
+func (m *PerfClient) GetClusterCapacityStats(ctx context.Context) (*ClusterCapacityStats, error) {
+	nodes, err := m.listNodesWithStatus(ctx, admin.NodeStatus_NS_ALIVE)
+	if err != nil {
+		return nil, err
+	}
+	tables, err := m.queryTables(ctx)
+	if err != nil {
+		return nil, err
+	}
+	configs, _, err := m.queryPartitionConfigs(ctx, tables)
+	if err != nil {
+		return nil, err
+	}
+
+	capacity := &ClusterCapacityStats{
+		TotalPartitions: len(configs),
+		NodeCount:       len(nodes),
+	}
+	for _, cfg := range configs {
+		capacity.TotalReplicas += replicaCountOf(cfg)
+	}
+
+	// reuse the sessions of the client, and dial the nodes that it doesn't know yet
+	var sessions []*PerfSession
+	for _, n := range nodes {
+		addr := n.Address.GetAddress()
+		session, found := m.nodeSession(addr)
+		if !found {
+			session = m.newPerfSession(addr)
+			defer session.Close()
+		}
+		sessions = append(sessions, session)
+	}
+	// summed up over the nodes as they respond
+	var totals StatsAccumulator
+	if _, _, err := m.getNodeStats(ctx, sessions, diskCapacityCounterPrefix, &totals); err != nil {
+		return nil, err
+	}
+	disk := totals.Snapshot()
+	capacity.TotalDiskTotalBytes = disk[diskCapacityTotalCounter] * bytesPerMB
+	capacity.TotalDiskUsedBytes = (disk[diskCapacityTotalCounter] - disk[diskCapacityAvailCounter]) * bytesPerMB
+	return capacity, nil
+}
@@ -0,0 +1,177 @@
+package aggregate
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/XiaoMi/pegasus-go-client/idl/admin"
+	"github.com/XiaoMi/pegasus-go-client/idl/base"
+	"github.com/XiaoMi/pegasus-go-client/idl/replication"
+	"github.com/XiaoMi/pegasus-go-client/session"
+)
+
+// The cluster-only metrics of the health of the cluster, collected from the meta server.
+const (
+	tableCountMetric     = "table_count"
+	aliveNodeCountMetric = "alive_node_count"
+	deadNodeCountMetric  = "dead_node_count"
+
+	partitionCountMetric = "partition_count"
+	// the partitions lacking any replica
+	unhealthyPartitionCountMetric = "unhealthy_partition_count"
+	// the partitions which have the primary but not the majority of the replicas to write
+	readOnlyPartitionCountMetric = "read_only_partition_count"
+	// the partitions without primary
+	unreadablePartitionCountMetric = "unreadable_partition_count"
+
+	balanceOperationCountMetric     = "balance_operation_count"
+	balanceMovePrimaryCountMetric   = "balance_move_primary_count"
+	balanceCopyPrimaryCountMetric   = "balance_copy_primary_count"
+	balanceCopySecondaryCountMetric = "balance_copy_secondary_count"
+)
+
+// The section of the perf-counters of the load balancer on the meta server, and the counters
+// in it -> the metrics. The "recent_" counters are the operations since the last retrieval.
+const balancerCounterSection = "eon.greedy_balancer*"
+
+var balancerCounters = map[string]string{
+	"balance_operation_count":             balanceOperationCountMetric,
+	"recent_balance_move_primary_count":   balanceMovePrimaryCountMetric,
+	"recent_balance_copy_primary_count":   balanceCopyPrimaryCountMetric,
+	"recent_balance_copy_secondary_count": balanceCopySecondaryCountMetric,
+}
+
+// partitionHealth is the number of the partitions in each state of health.
+type partitionHealth struct {
+	total      int
+	unhealthy  int
+	readOnly   int
+	unreadable int
+}
+
+// partitionHealthOf counts the partitions by their health, in the way of "ls -d" of the shell.
+func partitionHealthOf(configs map[base.Gpid]*replication.PartitionConfiguration) partitionHealth {
+	var h partitionHealth
+	for _, cfg := range configs {
+		h.total++
+		replicas := int32(replicaCountOf(cfg))
+		if replicas < cfg.MaxReplicaCount || cfg.Primary == nil || cfg.Primary.GetRawAddress() == 0 {
+			h.unhealthy++
+		}
+		if cfg.Primary == nil || cfg.Primary.GetRawAddress() == 0 {
+			h.unreadable++
+		} else if replicas < cfg.MaxReplicaCount/2+1 {
+			h.readOnly++
+		}
+	}
+	return h
+}
+
+// balanceStatsOf returns the balancer metrics from the perf-counters of the meta servers. Only
+// the leader balances the cluster, so the maximum over the servers is taken.
+func balanceStatsOf(nodes []*NodeStat) map[string]float64 {
+	res := make(map[string]float64)
+	for _, n := range nodes {
+		for name, value := range n.Stats {
+			metric, found := balancerCounters[name[strings.LastIndex(name, "*")+1:]]
+			if !found {
+				continue
+			}
+			if prev, found := res[metric]; !found || value > prev {
+				res[metric] = value
+			}
+		}
+	}
+	return res
+}
+
+// listNodeStatuses returns the status of every replica node, by a single ListNodes.
+func (m *PerfClient) listNodeStatuses(ctx context.Context) (map[string]admin.NodeStatus, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
+	defer cancel()
+	// NS_INVALID lists all nodes on meta server
+	resp, err := m.metaManager().ListNodes(ctx, &admin.ListNodesRequest{
+		Status: admin.NodeStatus_NS_INVALID,
+	})
+	if err != nil {
+		return nil, err
+	}
+	res := make(map[string]admin.NodeStatus, len(resp.Infos))
+	for _, n := range resp.Infos {
+		res[n.Address.GetAddress()] = n.Status
+	}
+	return res, nil
+}
+
+// getBalanceStats retrieves the balancer metrics from the perf-counters of the meta servers.
+// The stats are returned if any of the servers succeeds.
+func (m *PerfClient) getBalanceStats(ctx context.Context) (map[string]float64, error) {
+	nodes, _, err := m.getNodeStats(ctx, m.metaSessions(), balancerCounterSection, nil)
+	if len(nodes) == 0 && err != nil {
+		return nil, err
+	}
+	return balanceStatsOf(nodes), nil
+}
+
+// metaSessions returns the sessions to retrieve the perf-counters of the meta servers, which
+// are dialed on the first use.
+func (m *PerfClient) metaSessions() []*PerfSession {
+	m.metaLock.Lock()
+	defer m.metaLock.Unlock()
+	if m.metaNodes == nil {
+		m.metaNodes = make(map[string]*PerfSession)
+	}
+	sessions := make([]*PerfSession, 0, len(m.metaAddrs))
+	for _, addr := range m.metaAddrs {
+		s, found := m.metaNodes[addr]
+		if !found {
+			s = &PerfSession{
+				remoteCmdCaller: &nodeSessionCmdClient{session: m.newNodeSession(addr, session.NodeTypeMeta)},
+				Address:         addr,
+			}
+			m.metaNodes[addr] = s
+		}
+		sessions = append(sessions, s)
+	}
+	return sessions
+}
+
+// updateClusterHealthStats sets the cluster-only metrics of the tables, the partitions, the
+// nodes and the load balancer. The metrics are absent if they're unable to be collected.
+// `statuses` are the nodes listed from meta in this round.
+func (ag *tableStatsAggregator) updateClusterHealthStats(ctx context.Context, statuses map[string]admin.NodeStatus) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
+	defer cancel()
+	stats := ag.allStats.Stats
+	stats[tableCountMetric] = float64(len(ag.tables))
+
+	// no configuration is queried in the dry-run mode
+	if configs := ag.client.lastPartitionConfigs(); configs != nil {
+		h := partitionHealthOf(configs)
+		stats[partitionCountMetric] = float64(h.total)
+		stats[unhealthyPartitionCountMetric] = float64(h.unhealthy)
+		stats[readOnlyPartitionCountMetric] = float64(h.readOnly)
+		stats[unreadablePartitionCountMetric] = float64(h.unreadable)
+	}
+
+	counts := make(map[admin.NodeStatus]int)
+	for _, status := range statuses {
+		counts[status]++
+	}
+	stats[aliveNodeCountMetric] = float64(counts[admin.NodeStatus_NS_ALIVE])
+	stats[deadNodeCountMetric] = float64(counts[admin.NodeStatus_NS_UNALIVE])
+	ag.updateNodeLiveness(statuses)
+
+	if ag.client.opts.DryRun {
+		return
+	}
+	balance, err := ag.client.getBalanceStats(ctx)
+	if err != nil {
+		ag.logger().Errorf("unable to get the balancer stats: %s", err)
+		return
+	}
+	for name, value := range balance {
+		stats[name] = value
+	}
+}
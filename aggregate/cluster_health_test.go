@@ -0,0 +1,87 @@
+package aggregate
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/XiaoMi/pegasus-go-client/idl/base"
+	"github.com/XiaoMi/pegasus-go-client/idl/replication"
+	"github.com/apache/thrift/lib/go/thrift"
+	"github.com/stretchr/testify/assert"
+)
+
+// rpcAddress returns the address of the raw value, since base.RPCAddress has no constructor.
+func rpcAddress(raw int64) *base.RPCAddress {
+	buf := thrift.NewTMemoryBuffer()
+	proto := thrift.NewTBinaryProtocolTransport(buf)
+	_ = proto.WriteI64(raw)
+	_ = proto.Flush(context.Background())
+	addr := &base.RPCAddress{}
+	_ = addr.Read(proto)
+	return addr
+}
+
+func TestPartitionHealthOf(t *testing.T) {
+	node1, node2, node3 := rpcAddress(1), rpcAddress(2), rpcAddress(3)
+	partition := func(idx int32, primary *base.RPCAddress, secondaries ...*base.RPCAddress) *replication.PartitionConfiguration {
+		return &replication.PartitionConfiguration{
+			Pid:             &base.Gpid{Appid: 1, PartitionIndex: idx},
+			Primary:         primary,
+			Secondaries:     secondaries,
+			MaxReplicaCount: 3,
+		}
+	}
+	configs := make(map[base.Gpid]*replication.PartitionConfiguration)
+	for _, cfg := range []*replication.PartitionConfiguration{
+		partition(0, node1, node2, node3),
+		partition(1, node2, node1, node3),
+		// lacking a secondary
+		partition(2, node3, node1),
+		// the majority is lost
+		partition(3, node1),
+		// no primary
+		partition(4, &base.RPCAddress{}, node2, node3),
+		partition(5, nil),
+	} {
+		configs[*cfg.Pid] = cfg
+	}
+	assert.Equal(t, partitionHealthOf(configs), partitionHealth{total: 6, unhealthy: 4, readOnly: 1, unreadable: 2})
+}
+
+func TestBalanceStatsOf(t *testing.T) {
+	nodes := []*NodeStat{
+		{Addr: "127.0.0.1:34601", Stats: map[string]float64{
+			"meta*eon.greedy_balancer*balance_operation_count":           5,
+			"meta*eon.greedy_balancer*recent_balance_move_primary_count": 2,
+			"meta*eon.greedy_balancer*unknown_count":                     1,
+		}},
+		// the follower
+		{Addr: "127.0.0.1:34602", Stats: map[string]float64{
+			"meta*eon.greedy_balancer*balance_operation_count":           0,
+			"meta*eon.greedy_balancer*recent_balance_move_primary_count": 0,
+		}},
+	}
+	assert.Equal(t, balanceStatsOf(nodes), map[string]float64{
+		"balance_operation_count":    5,
+		"balance_move_primary_count": 2,
+	})
+}
+
+func TestPerfClientGetBalanceStats(t *testing.T) {
+	result := `{"counters":[{"name":"meta*eon.greedy_balancer*recent_balance_copy_secondary_count","value":3}]}`
+	pclient := &PerfClient{
+		metaAddrs: []string{"127.0.0.1:34601", "127.0.0.1:34602"},
+		metaNodes: map[string]*PerfSession{
+			"127.0.0.1:34601": {remoteCmdCaller: &fakeCmdCaller{result: result}, Address: "127.0.0.1:34601"},
+			"127.0.0.1:34602": {remoteCmdCaller: &fakeCmdCaller{err: errors.New("connection refused")}, Address: "127.0.0.1:34602"},
+		},
+	}
+	stats, err := pclient.getBalanceStats(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, stats, map[string]float64{"balance_copy_secondary_count": 3})
+
+	pclient.metaNodes["127.0.0.1:34601"].remoteCmdCaller = &fakeCmdCaller{err: errors.New("connection refused")}
+	_, err = pclient.getBalanceStats(context.Background())
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,114 @@
+package aggregate
+
+import (
+	"time"
+
+	"github.com/XiaoMi/pegasus-go-client/idl/base"
+	"github.com/XiaoMi/pegasus-go-client/idl/replication"
+)
+
+// ConfigChangeKind is the kind of a ConfigChangeEvent.
+type ConfigChangeKind string
+
+const (
+	// PrimaryMoved means the primary of the partition is on another node, or is lost.
+	PrimaryMoved ConfigChangeKind = "primary_moved"
+
+	// BallotChanged means the ballot of the partition increases with the primary unchanged,
+	// e.g. a secondary is added or removed.
+	BallotChanged ConfigChangeKind = "ballot_changed"
+)
+
+// ConfigChangeEvent indicates that the configuration of a partition has changed between two
+// rounds of collection, which is usually the result of the rebalancing or a node failure.
+type ConfigChangeEvent struct {
+	// the cluster of the partition, set by the aggregator
+	Cluster string
+
+	Gpid base.Gpid
+	Kind ConfigChangeKind
+
+	// The primary address is empty if the partition has no primary.
+	OldPrimary string
+	NewPrimary string
+
+	OldBallot int64
+	NewBallot int64
+
+	DetectedAt time.Time
+}
+
+// ReplicaConfig is the part of a partition configuration that is tracked for the changes.
+type ReplicaConfig struct {
+	Primary string
+	Ballot  int64
+}
+
+// replicaConfigsOf returns the tracked part of the configurations.
+func replicaConfigsOf(configs map[base.Gpid]*replication.PartitionConfiguration) map[base.Gpid]ReplicaConfig {
+	primaries := primariesOf(configs)
+	res := make(map[base.Gpid]ReplicaConfig, len(configs))
+	for gpid, p := range configs {
+		res[gpid] = ReplicaConfig{Primary: primaries[gpid], Ballot: p.Ballot}
+	}
+	return res
+}
+
+// DetectConfigChanges compares the configurations of two rounds, and returns an event for each
+// partition whose primary or ballot has changed. Partitions that only exist in one of them
+// are ignored.
+func DetectConfigChanges(prev, curr map[base.Gpid]ReplicaConfig) []*ConfigChangeEvent {
+	now := time.Now()
+	var events []*ConfigChangeEvent
+	for gpid, c := range curr {
+		p, found := prev[gpid]
+		if !found {
+			continue
+		}
+		event := &ConfigChangeEvent{
+			Gpid:       gpid,
+			OldPrimary: p.Primary,
+			NewPrimary: c.Primary,
+			OldBallot:  p.Ballot,
+			NewBallot:  c.Ballot,
+			DetectedAt: now,
+		}
+		if p.Primary != c.Primary {
+			event.Kind = PrimaryMoved
+		} else if p.Ballot != c.Ballot {
+			event.Kind = BallotChanged
+		} else {
+			continue
+		}
+		events = append(events, event)
+	}
+	return events
+}
+
+// configChangeTracker remembers the configurations of the last round to detect the changes.
+type configChangeTracker struct {
+	last map[base.Gpid]ReplicaConfig
+}
+
+func newConfigChangeTracker() *configChangeTracker {
+	return &configChangeTracker{last: make(map[base.Gpid]ReplicaConfig)}
+}
+
+// update returns the changes since the last round. The partitions absent from `configs`,
+// e.g. of the tables that failed to be queried, keep their last configurations.
+func (t *configChangeTracker) update(configs map[base.Gpid]ReplicaConfig) []*ConfigChangeEvent {
+	events := DetectConfigChanges(t.last, configs)
+	for gpid, c := range configs {
+		t.last[gpid] = c
+	}
+	return events
+}
+
+// forget removes the configurations of the table, e.g. after the table is dropped.
+func (t *configChangeTracker) forget(appID int32) {
+	for gpid := range t.last {
+		if gpid.Appid == appID {
+			delete(t.last, gpid)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package aggregate
+
+import (
+	"testing"
+
+	"github.com/XiaoMi/pegasus-go-client/idl/admin"
+	"github.com/XiaoMi/pegasus-go-client/idl/base"
+	"github.com/XiaoMi/pegasus-go-client/idl/replication"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDetectConfigChanges(t *testing.T) {
+	gpid0 := base.Gpid{Appid: 1, PartitionIndex: 0}
+	gpid1 := base.Gpid{Appid: 1, PartitionIndex: 1}
+	gpid2 := base.Gpid{Appid: 1, PartitionIndex: 2}
+	prev := map[base.Gpid]ReplicaConfig{
+		gpid0: {Primary: "127.0.0.1:34801", Ballot: 3},
+		gpid1: {Primary: "127.0.0.1:34802", Ballot: 5},
+		gpid2: {Primary: "127.0.0.1:34803", Ballot: 7},
+	}
+	curr := map[base.Gpid]ReplicaConfig{
+		gpid0: {Primary: "127.0.0.1:34801", Ballot: 3},
+		gpid1: {Primary: "127.0.0.1:34803", Ballot: 6},
+		gpid2: {Primary: "127.0.0.1:34803", Ballot: 8},
+		// a new partition
+		{Appid: 2, PartitionIndex: 0}: {Primary: "127.0.0.1:34801", Ballot: 1},
+	}
+	events := DetectConfigChanges(prev, curr)
+	assert.Equal(t, len(events), 2)
+	kinds := make(map[base.Gpid]ConfigChangeKind)
+	for _, e := range events {
+		kinds[e.Gpid] = e.Kind
+	}
+	assert.Equal(t, kinds, map[base.Gpid]ConfigChangeKind{gpid1: PrimaryMoved, gpid2: BallotChanged})
+}
+
+func TestAggregatorDetectConfigChanges(t *testing.T) {
+	ag := &tableStatsAggregator{tables: make(map[int32]*TableStats), configChanges: newConfigChangeTracker()}
+	ag.doUpdateTableMap([]*admin.AppInfo{{AppID: 1, AppName: "stat", PartitionCount: 2}})
+	ag.tables[1].Partitions[0].getOrInitStats()["get_qps"] = 1
+	ag.tables[1].Partitions[1].getOrInitStats()["get_qps"] = 1
+
+	var actual []*ConfigChangeEvent
+	AddHookAfterConfigChanged(func(events []*ConfigChangeEvent) {
+		actual = events
+	})
+	defer func() {
+		hooksManager = tableStatsHooksManager{}
+	}()
+
+	configsOf := func(ballot0, ballot1 int64) map[base.Gpid]*replication.PartitionConfiguration {
+		return map[base.Gpid]*replication.PartitionConfiguration{
+			{Appid: 1, PartitionIndex: 0}: {Pid: &base.Gpid{Appid: 1, PartitionIndex: 0}, Ballot: ballot0},
+			{Appid: 1, PartitionIndex: 1}: {Pid: &base.Gpid{Appid: 1, PartitionIndex: 1}, Ballot: ballot1},
+		}
+	}
+	ag.detectConfigChanges(configsOf(1, 1))
+	assert.Nil(t, actual)
+	assert.Equal(t, ag.tables[1].Partitions[0].Stats["config_change_count"], float64(0))
+
+	ag.detectConfigChanges(configsOf(1, 2))
+	assert.Equal(t, len(actual), 1)
+	assert.Equal(t, actual[0].Kind, BallotChanged)
+	assert.Equal(t, actual[0].Gpid, base.Gpid{Appid: 1, PartitionIndex: 1})
+	assert.Equal(t, ag.tables[1].Partitions[0].Stats["config_change_count"], float64(0))
+	assert.Equal(t, ag.tables[1].Partitions[1].Stats["config_change_count"], float64(1))
+	assert.Equal(t, ag.tables[1].Partitions[1].Stats["primary_migration_count"], float64(0))
+
+	ag.configChanges.forget(1)
+	assert.Empty(t, ag.configChanges.last)
+}
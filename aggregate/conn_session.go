@@ -0,0 +1,174 @@
+package aggregate
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/XiaoMi/pegasus-go-client/idl/base"
+	"github.com/XiaoMi/pegasus-go-client/rpc"
+	"github.com/XiaoMi/pegasus-go-client/session"
+)
+
+const connDialTimeout = 3 * time.Second
+
+// connSession is a NodeSession on a connection dialed by the collector itself, which supports
+// TLS and the SASL authentication that the sessions of pegasus-go-client lack. The calls are
+// serialized on a single connection, which is enough for collecting perf-counters periodically.
+type connSession struct {
+	addr  string
+	ntype session.NodeType
+	// TLS is enabled if it's non-nil
+	tlsCfg *tls.Config
+	// the connection is authenticated once dialed if it's non-nil
+	sasl  *SASLAuthenticator
+	codec *session.PegasusCodec
+
+	mu    sync.Mutex
+	conn  net.Conn
+	seqID int32
+}
+
+func newConnSession(addr string, ntype session.NodeType, tlsCfg *tls.Config, sasl *SASLAuthenticator) *connSession {
+	return &connSession{
+		addr:   addr,
+		ntype:  ntype,
+		tlsCfg: tlsCfg,
+		sasl:   sasl,
+		codec:  session.NewPegasusCodec(),
+	}
+}
+
+func (s *connSession) String() string {
+	return fmt.Sprintf("[%s(%s)]", s.addr, s.ntype)
+}
+
+// ConnState returns ConnStateReady once connected. The connection is dialed on the first call.
+func (s *connSession) ConnState() rpc.ConnState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn == nil {
+		return rpc.ConnStateInit
+	}
+	return rpc.ConnStateReady
+}
+
+// CallWithGpid invokes an RPC.
+func (s *connSession) CallWithGpid(ctx context.Context, gpid *base.Gpid, args session.RpcRequestArgs, name string) (session.RpcResponseResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	result, err := s.call(ctx, gpid, args, name)
+	if err != nil && s.conn != nil {
+		// the connection may be broken, reconnect on the next call
+		s.conn.Close()
+		s.conn = nil
+	}
+	return result, err
+}
+
+func (s *connSession) call(ctx context.Context, gpid *base.Gpid, args session.RpcRequestArgs, name string) (session.RpcResponseResult, error) {
+	deadline, _ := ctx.Deadline()
+	if s.conn == nil {
+		if err := s.dial(deadline); err != nil {
+			return nil, err
+		}
+	}
+	if err := s.conn.SetDeadline(deadline); err != nil {
+		return nil, err
+	}
+	resp, err := s.roundTrip(gpid, args, name)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Result, nil
+}
+
+// dial connects to the server, and authenticates the connection if SASL is enabled.
+func (s *connSession) dial(deadline time.Time) error {
+	dialer := &net.Dialer{Timeout: connDialTimeout}
+	var conn net.Conn
+	var err error
+	if s.tlsCfg != nil {
+		conn, err = tls.DialWithDialer(dialer, "tcp", s.addr, s.tlsCfg)
+	} else {
+		conn, err = dialer.Dial("tcp", s.addr)
+	}
+	if err != nil {
+		return err
+	}
+	s.conn = conn
+	if s.sasl == nil {
+		return nil
+	}
+	if err := conn.SetDeadline(deadline); err != nil {
+		return err
+	}
+	err = negotiate(func(req *negotiationRequest) (*negotiationResponse, error) {
+		resp, err := s.roundTrip(&base.Gpid{}, &negotiationArgs{Req: req}, rpcNegotiation)
+		if err != nil {
+			return nil, err
+		}
+		result, ok := resp.Result.(*negotiationResult)
+		if !ok {
+			return nil, fmt.Errorf("unexpected result of negotiation: %T", resp.Result)
+		}
+		return result.Success, nil
+	}, s.sasl.newMechanism(s.addr))
+	if err != nil {
+		return fmt.Errorf("failed to authenticate the session to %s: %s", s.addr, err)
+	}
+	return nil
+}
+
+// roundTrip sends the request and reads its response on the connection.
+func (s *connSession) roundTrip(gpid *base.Gpid, args session.RpcRequestArgs, name string) (*session.PegasusRpcCall, error) {
+	s.seqID++
+	rcall, err := session.MarshallPegasusRpc(s.codec, s.seqID, gpid, args, name)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := s.conn.Write(rcall.RawReq); err != nil {
+		return nil, err
+	}
+
+	// the response starts with a 4-bytes length field, which includes itself
+	lenBuf := make([]byte, 4)
+	if _, err := io.ReadFull(s.conn, lenBuf); err != nil {
+		return nil, err
+	}
+	respLen := binary.BigEndian.Uint32(lenBuf)
+	if respLen < 4 {
+		return nil, fmt.Errorf("response length(%d) smaller than 4 bytes", respLen)
+	}
+	buf := make([]byte, respLen-4)
+	if _, err := io.ReadFull(s.conn, buf); err != nil {
+		return nil, err
+	}
+
+	resp := &session.PegasusRpcCall{}
+	if err := s.codec.Unmarshal(buf, resp); err != nil {
+		return nil, err
+	}
+	if resp.Err != nil {
+		return nil, resp.Err
+	}
+	return resp, nil
+}
+
+// Close the connection.
+func (s *connSession) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn != nil {
+		err := s.conn.Close()
+		s.conn = nil
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,148 @@
+package aggregate
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/XiaoMi/pegasus-go-client/idl/base"
+	"github.com/XiaoMi/pegasus-go-client/idl/cmd"
+	"github.com/XiaoMi/pegasus-go-client/idl/replication"
+	"github.com/XiaoMi/pegasus-go-client/idl/rrdb"
+	"github.com/apache/thrift/lib/go/thrift"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeReplicaServer replies every RPC with the result of `ack`, over TLS with the certificate
+// of httptest.
+type fakeReplicaServer struct {
+	listener net.Listener
+	// the client config trusting the certificate of the server
+	clientTLS *tls.Config
+
+	ack    string
+	result thrift.TStruct
+
+	// the bytes of the requests read and the responses written
+	bytesRead    int64
+	bytesWritten int64
+}
+
+func newFakeReplicaServer(t *testing.T, ack string, result thrift.TStruct) *fakeReplicaServer {
+	certServer := httptest.NewUnstartedServer(nil)
+	certServer.StartTLS()
+	defer certServer.Close()
+
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: certServer.TLS.Certificates})
+	assert.Nil(t, err)
+	s := &fakeReplicaServer{
+		listener:  listener,
+		clientTLS: certServer.Client().Transport.(*http.Transport).TLSClientConfig,
+		ack:       ack,
+		result:    result,
+	}
+	go s.serve()
+	return s
+}
+
+func (s *fakeReplicaServer) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer conn.Close()
+			for s.reply(conn) == nil {
+			}
+		}()
+	}
+}
+
+// reply reads a request, whose body length is in the 48-bytes header, and writes the response,
+// which starts with its length.
+func (s *fakeReplicaServer) reply(conn net.Conn) error {
+	header := make([]byte, 48)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return err
+	}
+	body := make([]byte, binary.BigEndian.Uint32(header[16:20]))
+	if _, err := io.ReadFull(conn, body); err != nil {
+		return err
+	}
+	atomic.AddInt64(&s.bytesRead, int64(len(header)+len(body)))
+	buf := thrift.NewTMemoryBuffer()
+	buf.Write(body)
+	_, _, seqID, err := thrift.NewTBinaryProtocolTransport(buf).ReadMessageBegin()
+	if err != nil {
+		return err
+	}
+
+	resp := thrift.NewTMemoryBuffer()
+	resp.Write(make([]byte, 4))
+	oprot := thrift.NewTBinaryProtocolTransport(resp)
+	if err := (&base.ErrorCode{Errno: base.ERR_OK.String()}).Write(oprot); err != nil {
+		return err
+	}
+	if err := oprot.WriteMessageBegin(s.ack, thrift.REPLY, seqID); err != nil {
+		return err
+	}
+	if err := s.result.Write(oprot); err != nil {
+		return err
+	}
+	if err := oprot.WriteMessageEnd(); err != nil {
+		return err
+	}
+	data := resp.Bytes()
+	binary.BigEndian.PutUint32(data[0:4], uint32(len(data)))
+	atomic.AddInt64(&s.bytesWritten, int64(len(data)))
+	_, err = conn.Write(data)
+	return err
+}
+
+func (s *fakeReplicaServer) Close() {
+	s.listener.Close()
+}
+
+func TestTLSPerfSession(t *testing.T) {
+	result := `{"counters":[{"name":"replica*app.pegasus*get_qps@1.0","value":10}]}`
+	server := newFakeReplicaServer(t, "RPC_CLI_CLI_CALL_ACK", &cmd.RemoteCmdServiceCallCommandResult{Success: &result})
+	defer server.Close()
+
+	s := NewTLSPerfSession(server.listener.Addr().String(), server.clientTLS)
+	defer s.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	counters, err := s.GetPerfCounters(ctx, "@")
+	assert.Nil(t, err)
+	assert.Equal(t, len(counters), 1)
+	assert.Equal(t, counters[0].Name, "replica*app.pegasus*get_qps@1.0")
+	assert.Equal(t, counters[0].Value, float64(10))
+
+	// the session stats are the bytes on the wire
+	stats := s.Stats()
+	assert.Equal(t, stats.TotalBytesSent, uint64(atomic.LoadInt64(&server.bytesRead)))
+	assert.Equal(t, stats.TotalBytesRecv, uint64(atomic.LoadInt64(&server.bytesWritten)))
+}
+
+func TestTLSPerfSessionUnexpectedResult(t *testing.T) {
+	// the response is of another RPC
+	server := newFakeReplicaServer(t, "RPC_CM_QUERY_PARTITION_CONFIG_BY_INDEX_ACK",
+		&rrdb.MetaQueryCfgResult{Success: &replication.QueryCfgResponse{Err: &base.ErrorCode{Errno: base.ERR_OK.String()}}})
+	defer server.Close()
+
+	s := NewTLSPerfSession(server.listener.Addr().String(), server.clientTLS)
+	defer s.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err := s.GetPerfCounters(ctx, "@")
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "unexpected result")
+}
@@ -0,0 +1,108 @@
+package aggregate
+
+import (
+	"strings"
+	"time"
+)
+
+// counterRateTracker converts the cumulative counters of the partitions into per-second rates,
+// by remembering the previous sample of each partition. The rate of a counter is omitted
+// on the first sample of the partition, and after the primary migrates to another node,
+// since the counters on different nodes are unrelated.
+// A counter that decreases on the same node is considered to be reset (e.g. the replica
+// restarts), and its increment since the reset is used, see PartitionStats.SetWithReset.
+type counterRateTracker struct {
+	counters map[string]bool
+
+	// sampleKey -> the previous sample
+	lastSamples map[string]*counterSample
+}
+
+type counterSample struct {
+	addr   string
+	at     time.Time
+	values map[string]float64
+}
+
+func newCounterRateTracker(counters []string) *counterRateTracker {
+	t := &counterRateTracker{
+		counters:    make(map[string]bool),
+		lastSamples: make(map[string]*counterSample),
+	}
+	for _, name := range counters {
+		t.counters[name] = true
+	}
+	return t
+}
+
+func (t *counterRateTracker) enabled() bool {
+	return len(t.counters) > 0
+}
+
+// convert replaces the cumulative counters of the partition with their rates in place.
+func (t *counterRateTracker) convert(part *PartitionStats) {
+	if !part.HasStats() {
+		return
+	}
+	at := part.CollectedAt
+	if at.IsZero() {
+		at = time.Now()
+	}
+	curr := &counterSample{addr: part.Addr, at: at, values: make(map[string]float64)}
+	for name, value := range part.Stats {
+		if t.counters[name] {
+			curr.values[name] = value
+		}
+	}
+	if len(curr.values) == 0 {
+		return
+	}
+
+	key := sampleKey(part)
+	prev := t.lastSamples[key]
+	t.lastSamples[key] = curr
+	seconds := float64(0)
+	if prev != nil && prev.addr == curr.addr {
+		seconds = curr.at.Sub(prev.at).Seconds()
+	}
+	for name, value := range curr.values {
+		prevValue, found := float64(0), false
+		if seconds > 0 {
+			prevValue, found = prev.values[name]
+		}
+		if !found {
+			delete(part.Stats, name)
+			continue
+		}
+		part.Stats[name] = part.SetWithReset(name, value, prevValue) / seconds
+	}
+}
+
+// forget removes the samples of the partition, including the secondaries, e.g. after the
+// table is dropped.
+func (t *counterRateTracker) forget(gpid string) {
+	delete(t.lastSamples, gpid)
+	for key := range t.lastSamples {
+		if strings.HasPrefix(key, gpid+"@") {
+			delete(t.lastSamples, key)
+		}
+	}
+}
+
+// retain removes the samples of the replicas whose sampleKey is absent from `keys`.
+func (t *counterRateTracker) retain(keys map[string]bool) {
+	for key := range t.lastSamples {
+		if !keys[key] {
+			delete(t.lastSamples, key)
+		}
+	}
+}
+
+// sampleKey identifies the replica of the sample: the gpid for the primary, and "gpid@addr"
+// for the secondaries.
+func sampleKey(part *PartitionStats) string {
+	if part.IsSecondary() {
+		return part.Gpid.String() + "@" + part.Addr
+	}
+	return part.Gpid.String()
+}
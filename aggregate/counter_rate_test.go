@@ -0,0 +1,60 @@
+package aggregate
+
+import (
+	"testing"
+	"time"
+
+	"github.com/XiaoMi/pegasus-go-client/idl/base"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCounterRateTracker(t *testing.T) {
+	tracker := newCounterRateTracker([]string{"get_count"})
+	start := time.Now()
+	sample := func(addr string, seconds int, getCount float64) *PartitionStats {
+		return &PartitionStats{
+			Gpid:        base.Gpid{Appid: 1, PartitionIndex: 0},
+			Addr:        addr,
+			CollectedAt: start.Add(time.Duration(seconds) * time.Second),
+			Stats:       map[string]float64{"get_count": getCount, "sst_count": 3},
+		}
+	}
+
+	// the first sample has no rate
+	part := sample("127.0.0.1:34801", 0, 100)
+	tracker.convert(part)
+	assert.Equal(t, part.Stats, map[string]float64{"sst_count": 3})
+
+	part = sample("127.0.0.1:34801", 10, 300)
+	tracker.convert(part)
+	assert.Equal(t, part.Stats, map[string]float64{"get_count": 20, "sst_count": 3})
+
+	// the replica restarts
+	part = sample("127.0.0.1:34801", 20, 50)
+	tracker.convert(part)
+	assert.Equal(t, part.Stats, map[string]float64{"get_count": 5, "sst_count": 3})
+	assert.Equal(t, part.CounterResets, map[string]int{"get_count": 1})
+
+	// the primary migrates, whose counter is unrelated to the previous one
+	part = sample("127.0.0.1:34802", 30, 1000)
+	tracker.convert(part)
+	assert.Equal(t, part.Stats, map[string]float64{"sst_count": 3})
+	part = sample("127.0.0.1:34802", 40, 1100)
+	tracker.convert(part)
+	assert.Equal(t, part.Stats, map[string]float64{"get_count": 10, "sst_count": 3})
+
+	// the secondaries are tracked separately from the primary
+	secondary := sample("127.0.0.1:34803", 40, 7)
+	secondary.Role = RoleSecondary
+	tracker.convert(secondary)
+	secondary = sample("127.0.0.1:34803", 50, 27)
+	secondary.Role = RoleSecondary
+	tracker.convert(secondary)
+	assert.Equal(t, secondary.Stats, map[string]float64{"get_count": 2, "sst_count": 3})
+
+	tracker.forget(part.Gpid.String())
+	assert.Empty(t, tracker.lastSamples)
+	part = sample("127.0.0.1:34802", 50, 1200)
+	tracker.convert(part)
+	assert.Equal(t, part.Stats, map[string]float64{"sst_count": 3})
+}
@@ -0,0 +1,383 @@
+package aggregate
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/spf13/viper"
+)
+
+// DerivedMetric is a metric computed from the other metrics of the same partition, e.g.
+// `read_qps = get_qps + multi_get_qps + scan_qps`. The expression supports the numbers,
+// the metric names, `+ - * /` and parentheses, so sums, differences and ratios of the counters
+// can be defined without a code change.
+//
+// A metric absent from the stats is taken as 0. The derived metric is omitted if the expression
+// divides by zero. The non-linear metrics, e.g. the ratios, are re-evaluated on the aggregated
+// stats of the tables, the nodes and the cluster rather than aggregated.
+type DerivedMetric struct {
+	Name string
+	Expr string
+
+	expr derivedExpr
+}
+
+// NewDerivedMetric parses the expression and returns a DerivedMetric.
+func NewDerivedMetric(name string, expr string) (DerivedMetric, error) {
+	if name == "" {
+		return DerivedMetric{}, fmt.Errorf("no name is given for the derived metric \"%s\"", expr)
+	}
+	p := &exprParser{input: expr}
+	e, err := p.parse()
+	if err != nil {
+		return DerivedMetric{}, fmt.Errorf("invalid expression of derived metric %s: %s", name, err)
+	}
+	return DerivedMetric{Name: name, Expr: expr, expr: e}, nil
+}
+
+// Eval evaluates the expression on the stats, which is undefined if it divides by zero.
+func (d *DerivedMetric) Eval(stats map[string]float64) (float64, bool) {
+	return d.expr.eval(stats)
+}
+
+// apply sets the metric on the stats, or removes it if it's undefined.
+func (d *DerivedMetric) apply(stats map[string]float64) {
+	if v, ok := d.Eval(stats); ok {
+		stats[d.Name] = v
+	} else {
+		delete(stats, d.Name)
+	}
+}
+
+// defaultDerivedMetrics are the read/write stats summed up from the operations.
+var defaultDerivedMetrics = func() []DerivedMetric {
+	reads := []string{"get", "multi_get", "scan"}
+	writes := []string{"put", "remove", "multi_put", "multi_remove", "check_and_set", "check_and_mutate"}
+	sumOf := func(ops []string, suffix string) string {
+		var operands []string
+		for _, op := range ops {
+			operands = append(operands, op+suffix)
+		}
+		return strings.Join(operands, " + ")
+	}
+
+	var res []DerivedMetric
+	for _, d := range [][2]string{
+		{"read_qps", sumOf(reads, "_qps")},
+		{"read_bytes", sumOf(reads, "_bytes")},
+		{"write_qps", sumOf(writes, "_qps")},
+		{"write_bytes", sumOf(writes, "_bytes")},
+	} {
+		m, err := NewDerivedMetric(d[0], d[1])
+		if err != nil {
+			panic(err)
+		}
+		res = append(res, m)
+	}
+	return res
+}()
+
+var (
+	derivedMetricsLock sync.RWMutex
+	derivedMetrics     = defaultDerivedMetrics
+)
+
+// SetDerivedMetrics adds the derived metrics to the defaults (read_qps, read_bytes, write_qps and
+// write_bytes), where a metric with the same name as a default one replaces it. The metrics are
+// evaluated in order, so a metric can refer to the ones before it.
+func SetDerivedMetrics(metrics []DerivedMetric) {
+	res := make([]DerivedMetric, 0, len(defaultDerivedMetrics)+len(metrics))
+	overridden := make(map[string]bool)
+	for _, m := range metrics {
+		overridden[m.Name] = true
+	}
+	for _, m := range defaultDerivedMetrics {
+		if !overridden[m.Name] {
+			res = append(res, m)
+		}
+	}
+	res = append(res, metrics...)
+
+	derivedMetricsLock.Lock()
+	derivedMetrics = res
+	derivedMetricsLock.Unlock()
+}
+
+func getDerivedMetrics() []DerivedMetric {
+	derivedMetricsLock.RLock()
+	defer derivedMetricsLock.RUnlock()
+	return derivedMetrics
+}
+
+// DerivedMetricsFromConfig parses the metrics of "metrics.derived_metrics", each of which is
+// like `{name: get_hit_ratio, expr: "rdb_block_cache_hit_count / rdb_block_cache_total_count"}`.
+func DerivedMetricsFromConfig() ([]DerivedMetric, error) {
+	var cfgs []struct {
+		Name string
+		Expr string
+	}
+	if err := viper.UnmarshalKey("metrics.derived_metrics", &cfgs); err != nil {
+		return nil, err
+	}
+	var res []DerivedMetric
+	for _, cfg := range cfgs {
+		m, err := NewDerivedMetric(cfg.Name, cfg.Expr)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, m)
+	}
+	return res, nil
+}
+
+// applyDerivedMetrics extends the partition stats with all the derived metrics.
+func applyDerivedMetrics(stats map[string]float64) {
+	for _, m := range getDerivedMetrics() {
+		m.apply(stats)
+	}
+}
+
+// reapplyDerivedMetrics re-evaluates the non-linear derived metrics present in the aggregated
+// stats from the aggregated operands, so that the ratios are not summed up over the partitions
+// or tables. The linear ones, e.g. the sums, are aggregated as they are.
+func reapplyDerivedMetrics(stats map[string]float64) {
+	for _, m := range getDerivedMetrics() {
+		if _, found := stats[m.Name]; found && !m.expr.linear() {
+			m.apply(stats)
+		}
+	}
+}
+
+// derivedMetricNames returns the names of the derived metrics.
+func derivedMetricNames() []string {
+	var res []string
+	for _, m := range getDerivedMetrics() {
+		res = append(res, m.Name)
+	}
+	return res
+}
+
+// derivedExpr is the parsed expression of a DerivedMetric.
+type derivedExpr interface {
+	eval(stats map[string]float64) (float64, bool)
+
+	// linear returns whether the expression is a linear combination of the metrics, whose value
+	// on the summed stats equals the sum of its values.
+	linear() bool
+
+	hasMetric() bool
+}
+
+type numberExpr float64
+
+func (e numberExpr) eval(map[string]float64) (float64, bool) {
+	return float64(e), true
+}
+
+func (e numberExpr) linear() bool {
+	return true
+}
+
+func (e numberExpr) hasMetric() bool {
+	return false
+}
+
+type metricExpr string
+
+func (e metricExpr) eval(stats map[string]float64) (float64, bool) {
+	return stats[string(e)], true
+}
+
+func (e metricExpr) linear() bool {
+	return true
+}
+
+func (e metricExpr) hasMetric() bool {
+	return true
+}
+
+type negExpr struct {
+	operand derivedExpr
+}
+
+func (e *negExpr) eval(stats map[string]float64) (float64, bool) {
+	v, ok := e.operand.eval(stats)
+	return -v, ok
+}
+
+func (e *negExpr) linear() bool {
+	return e.operand.linear()
+}
+
+func (e *negExpr) hasMetric() bool {
+	return e.operand.hasMetric()
+}
+
+type binaryExpr struct {
+	op          byte
+	left, right derivedExpr
+}
+
+func (e *binaryExpr) eval(stats map[string]float64) (float64, bool) {
+	l, ok := e.left.eval(stats)
+	if !ok {
+		return 0, false
+	}
+	r, ok := e.right.eval(stats)
+	if !ok {
+		return 0, false
+	}
+	switch e.op {
+	case '+':
+		return l + r, true
+	case '-':
+		return l - r, true
+	case '*':
+		return l * r, true
+	default:
+		if r == 0 {
+			return 0, false
+		}
+		return l / r, true
+	}
+}
+
+func (e *binaryExpr) linear() bool {
+	switch e.op {
+	case '+', '-':
+		return e.left.linear() && e.right.linear()
+	case '*':
+		return e.left.linear() && e.right.linear() && !(e.left.hasMetric() && e.right.hasMetric())
+	default:
+		return e.left.linear() && !e.right.hasMetric()
+	}
+}
+
+func (e *binaryExpr) hasMetric() bool {
+	return e.left.hasMetric() || e.right.hasMetric()
+}
+
+// exprParser is a recursive-descent parser of the grammar:
+//
+//	expr   = term { ("+" | "-") term }
+//	term   = factor { ("*" | "/") factor }
+//	factor = number | metric | "-" factor | "(" expr ")"
+type exprParser struct {
+	input string
+	pos   int
+}
+
+func (p *exprParser) parse() (derivedExpr, error) {
+	e, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+	if p.skipSpaces(); p.pos < len(p.input) {
+		return nil, fmt.Errorf("unexpected \"%s\" at %d", p.input[p.pos:], p.pos)
+	}
+	return e, nil
+}
+
+func (p *exprParser) parseExpr() (derivedExpr, error) {
+	left, err := p.parseTerm()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op := p.peek()
+		if op != '+' && op != '-' {
+			return left, nil
+		}
+		p.pos++
+		right, err := p.parseTerm()
+		if err != nil {
+			return nil, err
+		}
+		left = &binaryExpr{op: op, left: left, right: right}
+	}
+}
+
+func (p *exprParser) parseTerm() (derivedExpr, error) {
+	left, err := p.parseFactor()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op := p.peek()
+		if op != '*' && op != '/' {
+			return left, nil
+		}
+		p.pos++
+		right, err := p.parseFactor()
+		if err != nil {
+			return nil, err
+		}
+		left = &binaryExpr{op: op, left: left, right: right}
+	}
+}
+
+func (p *exprParser) parseFactor() (derivedExpr, error) {
+	c := p.peek()
+	switch {
+	case c == 0:
+		return nil, fmt.Errorf("unexpected end of expression")
+	case c == '-':
+		p.pos++
+		operand, err := p.parseFactor()
+		if err != nil {
+			return nil, err
+		}
+		return &negExpr{operand: operand}, nil
+	case c == '(':
+		p.pos++
+		e, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek() != ')' {
+			return nil, fmt.Errorf("missing \")\" at %d", p.pos)
+		}
+		p.pos++
+		return e, nil
+	case c == '.' || unicode.IsDigit(rune(c)):
+		start := p.pos
+		for p.pos < len(p.input) && (p.input[p.pos] == '.' || unicode.IsDigit(rune(p.input[p.pos]))) {
+			p.pos++
+		}
+		v, err := strconv.ParseFloat(p.input[start:p.pos], 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number \"%s\" at %d", p.input[start:p.pos], start)
+		}
+		return numberExpr(v), nil
+	case c == '_' || unicode.IsLetter(rune(c)):
+		start := p.pos
+		for p.pos < len(p.input) && isMetricNameChar(p.input[p.pos]) {
+			p.pos++
+		}
+		return metricExpr(p.input[start:p.pos]), nil
+	default:
+		return nil, fmt.Errorf("unexpected \"%c\" at %d", c, p.pos)
+	}
+}
+
+// peek skips the spaces and returns the next character, or 0 at the end of input.
+func (p *exprParser) peek() byte {
+	p.skipSpaces()
+	if p.pos >= len(p.input) {
+		return 0
+	}
+	return p.input[p.pos]
+}
+
+func (p *exprParser) skipSpaces() {
+	for p.pos < len(p.input) && unicode.IsSpace(rune(p.input[p.pos])) {
+		p.pos++
+	}
+}
+
+func isMetricNameChar(c byte) bool {
+	return c == '_' || unicode.IsLetter(rune(c)) || unicode.IsDigit(rune(c))
+}
@@ -0,0 +1,87 @@
+package aggregate
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDerivedMetricExpr(t *testing.T) {
+	stats := map[string]float64{"a": 6, "b": 3, "zero": 0}
+	tests := []struct {
+		expr   string
+		value  float64
+		ok     bool
+		linear bool
+	}{
+		{"a + b", 9, true, true},
+		{"a - b - 1", 2, true, true},
+		{"a / b", 2, true, false},
+		{"a + b * 2", 12, true, true},
+		{"(a + b) * 2", 18, true, true},
+		{"a * b", 18, true, false},
+		{"-a + 1.5", -4.5, true, true},
+		{"a / 2", 3, true, true},
+		{"a + absent", 6, true, true},
+		{"a / zero", 0, false, false},
+		{"a / (b - 3)", 0, false, false},
+	}
+	for _, tt := range tests {
+		m, err := NewDerivedMetric("m", tt.expr)
+		assert.Nil(t, err)
+		v, ok := m.expr.eval(stats)
+		assert.Equal(t, ok, tt.ok, tt.expr)
+		assert.Equal(t, v, tt.value, tt.expr)
+		assert.Equal(t, m.expr.linear(), tt.linear, tt.expr)
+	}
+
+	for _, expr := range []string{"", "a +", "(a + b", "a b", "a % b", "1.2.3"} {
+		_, err := NewDerivedMetric("m", expr)
+		assert.NotNil(t, err, expr)
+	}
+	_, err := NewDerivedMetric("", "a")
+	assert.NotNil(t, err)
+}
+
+func TestSetDerivedMetrics(t *testing.T) {
+	defer SetDerivedMetrics(nil)
+
+	ratio, _ := NewDerivedMetric("hit_ratio", "rdb_block_cache_hit_count / rdb_block_cache_total_count")
+	readQPS, _ := NewDerivedMetric("read_qps", "get_qps")
+	SetDerivedMetrics([]DerivedMetric{ratio, readQPS})
+	assert.Contains(t, AllMetrics(), "hit_ratio")
+
+	stats := map[string]float64{
+		"get_qps":                     10,
+		"scan_qps":                    5,
+		"put_qps":                     2,
+		"rdb_block_cache_hit_count":   1,
+		"rdb_block_cache_total_count": 4,
+	}
+	extendStats(&stats)
+	assert.Equal(t, stats["read_qps"], float64(10))
+	assert.Equal(t, stats["write_qps"], float64(2))
+	assert.Equal(t, stats["hit_ratio"], 0.25)
+
+	// the ratio is undefined without any access
+	stats = map[string]float64{"rdb_block_cache_total_count": 0}
+	extendStats(&stats)
+	assert.NotContains(t, stats, "hit_ratio")
+
+	// the ratio of the aggregated stats is evaluated from the aggregated operands
+	aggregated := aggregateStats([]map[string]float64{
+		{"rdb_block_cache_hit_count": 1, "rdb_block_cache_total_count": 4, "hit_ratio": 0.25},
+		{"rdb_block_cache_hit_count": 3, "rdb_block_cache_total_count": 4, "hit_ratio": 0.75},
+	}, AggregateOptions{}, 1)
+	reapplyDerivedMetrics(aggregated)
+	assert.Equal(t, aggregated["hit_ratio"], 0.5)
+	assert.NotContains(t, aggregated, "read_qps")
+
+	// the sums are aggregated as they are
+	aggregated = map[string]float64{"read_qps": 20}
+	reapplyDerivedMetrics(aggregated)
+	assert.Equal(t, aggregated["read_qps"], float64(20))
+
+	SetDerivedMetrics(nil)
+	assert.NotContains(t, AllMetrics(), "hit_ratio")
+}
@@ -0,0 +1,42 @@
+package aggregate
+
+import (
+	"time"
+)
+
+// CollectionDiagnostics records how long the collection of a table took in one round.
+// Slow tables, for example those with stale leaders causing timeouts, can be found
+// by watching the diagnostics.
+type CollectionDiagnostics struct {
+	TableName string
+
+	// The time spent on querying the partition configuration of this table from meta.
+	QueryConfigDuration time.Duration
+
+	// Address of the replica node -> the time spent on GetPerfCounters.
+	// Only the nodes hosting partitions of this table are included.
+	NodeDurations map[string]time.Duration
+}
+
+// diagnose generates the CollectionDiagnostics of each table from the durations
+// recorded in the last round of collection.
+func (ag *tableStatsAggregator) diagnose() []*CollectionDiagnostics {
+	nodeDurations := ag.client.lastNodeDurations()
+	queryConfigDurations := ag.client.lastQueryConfigDurations()
+
+	var diags []*CollectionDiagnostics
+	for _, tb := range ag.tables {
+		diag := &CollectionDiagnostics{
+			TableName:           tb.TableName,
+			QueryConfigDuration: queryConfigDurations[tb.TableName],
+			NodeDurations:       make(map[string]time.Duration),
+		}
+		for _, part := range tb.Partitions {
+			if d, found := nodeDurations[part.Addr]; found {
+				diag.NodeDurations[part.Addr] = d
+			}
+		}
+		diags = append(diags, diag)
+	}
+	return diags
+}
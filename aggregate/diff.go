@@ -0,0 +1,115 @@
+package aggregate
+
+import (
+	"sort"
+)
+
+// ClusterStatsDiff is the changes of metrics between two ClusterStats.
+type ClusterStatsDiff struct {
+	// metric -> the absolute delta of the value
+	Increased map[string]float64
+	Decreased map[string]float64
+
+	// metric -> the value
+	Unchanged map[string]float64
+	// the metrics new in the current stats
+	Added map[string]float64
+	// the metrics present in the previous stats but absent in the current one
+	Removed map[string]float64
+}
+
+// DiffReport compares two consecutive snapshots of the cluster. It's useful for
+// detecting changes after a rollout, where the cluster stats should remain stable.
+func DiffReport(prev, curr *ClusterStats) *ClusterStatsDiff {
+	diff := &ClusterStatsDiff{
+		Increased: make(map[string]float64),
+		Decreased: make(map[string]float64),
+		Unchanged: make(map[string]float64),
+		Added:     make(map[string]float64),
+		Removed:   make(map[string]float64),
+	}
+	var prevStats, currStats map[string]float64
+	if prev != nil {
+		prevStats = prev.Stats
+	}
+	if curr != nil {
+		currStats = curr.Stats
+	}
+
+	for name, value := range currStats {
+		prevValue, found := prevStats[name]
+		switch {
+		case !found:
+			diff.Added[name] = value
+		case value > prevValue:
+			diff.Increased[name] = value - prevValue
+		case value < prevValue:
+			diff.Decreased[name] = prevValue - value
+		default:
+			diff.Unchanged[name] = value
+		}
+	}
+	for name, value := range prevStats {
+		if _, found := currStats[name]; !found {
+			diff.Removed[name] = value
+		}
+	}
+	return diff
+}
+
+// NodeStatDiff is the changes of the replica nodes between two collections.
+type NodeStatDiff struct {
+	// the nodes present in the current collection only, sorted by address
+	AddedNodes []string
+	// the nodes present in the previous collection only, sorted by address
+	RemovedNodes []string
+
+	// node address -> metric -> the current value minus the previous value.
+	// Only the nodes in both collections are compared, and a metric missing on
+	// one side is counted as 0. The unchanged metrics and nodes are omitted.
+	ChangedNodes map[string]map[string]float64
+}
+
+// DiffNodeStats compares two collections of the replica nodes, so that the membership
+// changes of the cluster can be correlated with the changes of traffic.
+func DiffNodeStats(prev, curr []*NodeStat) *NodeStatDiff {
+	diff := &NodeStatDiff{ChangedNodes: make(map[string]map[string]float64)}
+	prevNodes := make(map[string]*NodeStat)
+	for _, n := range prev {
+		prevNodes[n.Addr] = n
+	}
+	currNodes := make(map[string]*NodeStat)
+	for _, n := range curr {
+		currNodes[n.Addr] = n
+	}
+
+	for addr, n := range currNodes {
+		prevNode, found := prevNodes[addr]
+		if !found {
+			diff.AddedNodes = append(diff.AddedNodes, addr)
+			continue
+		}
+		deltas := make(map[string]float64)
+		for name, value := range n.Stats {
+			if delta := value - prevNode.Stats[name]; delta != 0 {
+				deltas[name] = delta
+			}
+		}
+		for name, value := range prevNode.Stats {
+			if _, found := n.Stats[name]; !found && value != 0 {
+				deltas[name] = -value
+			}
+		}
+		if len(deltas) != 0 {
+			diff.ChangedNodes[addr] = deltas
+		}
+	}
+	for addr := range prevNodes {
+		if _, found := currNodes[addr]; !found {
+			diff.RemovedNodes = append(diff.RemovedNodes, addr)
+		}
+	}
+	sort.Strings(diff.AddedNodes)
+	sort.Strings(diff.RemovedNodes)
+	return diff
+}
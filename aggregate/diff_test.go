@@ -0,0 +1,61 @@
+package aggregate
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiffReport(t *testing.T) {
+	prev := &ClusterStats{Stats: map[string]float64{
+		"read_qps":  100,
+		"write_qps": 100,
+		"scan_qps":  10,
+		"get_qps":   50,
+	}}
+	curr := &ClusterStats{Stats: map[string]float64{
+		"read_qps":  150,
+		"write_qps": 80,
+		"scan_qps":  10,
+		"put_qps":   5,
+	}}
+
+	diff := DiffReport(prev, curr)
+	assert.Equal(t, diff.Increased, map[string]float64{"read_qps": 50})
+	assert.Equal(t, diff.Decreased, map[string]float64{"write_qps": 20})
+	assert.Equal(t, diff.Unchanged, map[string]float64{"scan_qps": 10})
+	assert.Equal(t, diff.Added, map[string]float64{"put_qps": 5})
+	assert.Equal(t, diff.Removed, map[string]float64{"get_qps": 50})
+
+	// no previous snapshot
+	diff = DiffReport(nil, curr)
+	assert.Equal(t, diff.Added, curr.Stats)
+	assert.Empty(t, diff.Removed)
+}
+
+func TestDiffNodeStats(t *testing.T) {
+	prev := []*NodeStat{
+		{Addr: "127.0.0.1:34801", Stats: map[string]float64{"get_qps": 10, "put_qps": 5}},
+		{Addr: "127.0.0.1:34802", Stats: map[string]float64{"get_qps": 20}},
+		{Addr: "127.0.0.1:34803", Stats: map[string]float64{"get_qps": 30}},
+	}
+	curr := []*NodeStat{
+		{Addr: "127.0.0.1:34801", Stats: map[string]float64{"get_qps": 15, "scan_qps": 1}},
+		{Addr: "127.0.0.1:34802", Stats: map[string]float64{"get_qps": 20}},
+		{Addr: "127.0.0.1:34805", Stats: map[string]float64{"get_qps": 1}},
+		{Addr: "127.0.0.1:34804", Stats: map[string]float64{"get_qps": 1}},
+	}
+
+	diff := DiffNodeStats(prev, curr)
+	assert.Equal(t, diff.AddedNodes, []string{"127.0.0.1:34804", "127.0.0.1:34805"})
+	assert.Equal(t, diff.RemovedNodes, []string{"127.0.0.1:34803"})
+	assert.Equal(t, diff.ChangedNodes, map[string]map[string]float64{
+		"127.0.0.1:34801": {"get_qps": 5, "put_qps": -5, "scan_qps": 1},
+	})
+
+	// no previous collection
+	diff = DiffNodeStats(nil, curr[:1])
+	assert.Equal(t, diff.AddedNodes, []string{"127.0.0.1:34801"})
+	assert.Nil(t, diff.RemovedNodes)
+	assert.Equal(t, len(diff.ChangedNodes), 0)
+}
@@ -0,0 +1,315 @@
+package aggregate
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-zookeeper/zk"
+	"github.com/pegasus-kv/collector/shard"
+	"github.com/spf13/viper"
+)
+
+// DiscoveryConfig is the ZooKeeper where the clusters to collect are registered. Each child
+// of Path is a cluster named after the node, whose data is its meta servers separated by
+// commas, e.g. "/pegasus/clusters/c1" of "10.0.0.1:34601,10.0.0.2:34601".
+type DiscoveryConfig struct {
+	Servers        []string
+	Path           string
+	SessionTimeout time.Duration `mapstructure:"session_timeout"`
+}
+
+// DiscoveryFromConfig returns the configuration of "discovery.zookeeper", or nil if no server
+// is given, in which case the discovery is disabled.
+func DiscoveryFromConfig() (*DiscoveryConfig, error) {
+	var cfg DiscoveryConfig
+	if err := viper.UnmarshalKey("discovery.zookeeper", &cfg); err != nil {
+		return nil, err
+	}
+	if len(cfg.Servers) == 0 {
+		return nil, nil
+	}
+	if !strings.HasPrefix(cfg.Path, "/") {
+		return nil, fmt.Errorf("invalid path %q of the cluster discovery, which should be absolute", cfg.Path)
+	}
+	if cfg.SessionTimeout <= 0 {
+		cfg.SessionTimeout = 10 * time.Second
+	}
+	return &cfg, nil
+}
+
+// parseClusterNode returns the cluster of the node under the discovery path.
+func parseClusterNode(name string, data []byte) (ClusterConfig, error) {
+	metaServers := strings.FieldsFunc(string(data), func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t' || r == '\n' || r == '\r'
+	})
+	if len(metaServers) == 0 {
+		return ClusterConfig{}, fmt.Errorf("no meta server of the discovered cluster %s", name)
+	}
+	return ClusterConfig{Name: name, MetaServers: metaServers}, nil
+}
+
+// discoveryRetryInterval is the interval to retry reading the clusters from ZooKeeper after
+// a failure.
+const discoveryRetryInterval = 10 * time.Second
+
+type zkDiscovery struct {
+	cfg  DiscoveryConfig
+	conn *zk.Conn
+}
+
+// newZkDiscovery connects to the ZooKeeper in the background.
+func newZkDiscovery(cfg DiscoveryConfig) (*zkDiscovery, error) {
+	conn, _, err := zk.Connect(cfg.Servers, cfg.SessionTimeout, zk.WithLogger(log))
+	if err != nil {
+		return nil, err
+	}
+	return &zkDiscovery{cfg: cfg, conn: conn}, nil
+}
+
+// read returns the clusters under the path sorted by the name, and a channel that's closed
+// once the clusters change.
+func (d *zkDiscovery) read() ([]ClusterConfig, <-chan struct{}, error) {
+	children, _, childrenEvents, err := d.conn.ChildrenW(d.cfg.Path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to list the clusters under %s: %s", d.cfg.Path, err)
+	}
+	changed := make(chan struct{})
+	var once sync.Once
+	// every watch fires once, including when the session expires or the connection is closed
+	watch := func(events <-chan zk.Event) {
+		go func() {
+			<-events
+			once.Do(func() { close(changed) })
+		}()
+	}
+	watch(childrenEvents)
+
+	sort.Strings(children)
+	var clusters []ClusterConfig
+	for _, name := range children {
+		data, _, dataEvents, err := d.conn.GetW(path.Join(d.cfg.Path, name))
+		if err == zk.ErrNoNode {
+			// removed after listed, which fires the watch of the children
+			continue
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read the cluster %s: %s", name, err)
+		}
+		watch(dataEvents)
+		c, err := parseClusterNode(name, data)
+		if err != nil {
+			log.Warn(err)
+			continue
+		}
+		clusters = append(clusters, c)
+	}
+	return clusters, changed, nil
+}
+
+// watch passes the clusters to `update` initially and whenever they change, until ctx is done.
+func (d *zkDiscovery) watch(ctx context.Context, update func(clusters []ClusterConfig)) {
+	defer d.conn.Close()
+	for {
+		clusters, changed, err := d.read()
+		if err != nil {
+			log.Errorf("failed to discover the clusters, retry in %s: %s", discoveryRetryInterval, err)
+			retry := make(chan struct{})
+			time.AfterFunc(discoveryRetryInterval, func() { close(retry) })
+			changed = retry
+		} else {
+			update(clusters)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-changed:
+		}
+	}
+}
+
+// clusterGroup runs the aggregation loops of the configured and the discovered clusters.
+type clusterGroup struct {
+	ctx context.Context
+
+	// guards the fields below, which may be updated by the discovery and the reload concurrently
+	lock     sync.Mutex
+	opts     PerfClientOptions
+	interval time.Duration
+	// the cluster whose stats are passed to all hooks, while those of the others are passed
+	// to the hooks of all clusters only
+	primary string
+
+	newAggregator func(metaAddrs []string, opts PerfClientOptions) TableStatsAggregator
+
+	wg         sync.WaitGroup
+	configured map[string]bool
+	// the clusters of the last discovery
+	discovered []ClusterConfig
+	running    map[string]*runningCluster
+}
+
+type runningCluster struct {
+	metaServers []string
+	cancel      context.CancelFunc
+	// closed once the aggregation loop returns
+	done chan struct{}
+}
+
+func newClusterGroup(ctx context.Context, opts PerfClientOptions, interval time.Duration, primary string) *clusterGroup {
+	return &clusterGroup{
+		ctx:           ctx,
+		opts:          opts,
+		interval:      interval,
+		primary:       primary,
+		newAggregator: NewTableStatsAggregatorWithOptions,
+		configured:    make(map[string]bool),
+		running:       make(map[string]*runningCluster),
+	}
+}
+
+// start collects the cluster until it's stopped or the context of the group is done.
+func (g *clusterGroup) start(c ClusterConfig) {
+	opts := g.opts
+	opts.ClusterName = c.Name
+	opts.Additional = c.Name != g.primary
+	if shard.Enabled() {
+		name := c.Name
+		opts.TableFilter = andTableFilters(opts.TableFilter, func(table string) bool {
+			return shard.Owns(name, table)
+		})
+	}
+	interval := g.interval
+	ag := g.newAggregator(c.MetaServers, opts)
+	ctx, cancel := context.WithCancel(g.ctx)
+	r := &runningCluster{metaServers: c.MetaServers, cancel: cancel, done: make(chan struct{})}
+	g.running[c.Name] = r
+	log.WithField("cluster", c.Name).Infof("start collecting cluster %s from meta servers %v", c.Name, c.MetaServers)
+
+	g.wg.Add(1)
+	go func() {
+		defer g.wg.Done()
+		defer close(r.done)
+		defer ag.Close()
+		loopAggregation(ctx, ag, interval)
+	}()
+}
+
+// stop stops collecting the cluster, and waits until no more stats of it are emitted.
+func (g *clusterGroup) stop(name string) {
+	r := g.running[name]
+	r.cancel()
+	<-r.done
+	delete(g.running, name)
+}
+
+// updateDiscovered collects the discovered clusters, and stops collecting those no longer
+// discovered. The clusters configured statically are never replaced.
+func (g *clusterGroup) updateDiscovered(clusters []ClusterConfig) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	g.discovered = clusters
+	g.doUpdateDiscovered()
+}
+
+func (g *clusterGroup) doUpdateDiscovered() {
+	discovered := make(map[string]bool)
+	for _, c := range g.discovered {
+		if g.configured[c.Name] {
+			log.WithField("cluster", c.Name).Warnf("ignore the discovered cluster %s, which is configured", c.Name)
+			continue
+		}
+		discovered[c.Name] = true
+		if r, found := g.running[c.Name]; found {
+			if equalStrings(r.metaServers, c.MetaServers) {
+				continue
+			}
+			log.WithField("cluster", c.Name).Infof("the meta servers of cluster %s have changed from %v to %v", c.Name, r.metaServers, c.MetaServers)
+			g.stop(c.Name)
+		}
+		g.start(c)
+	}
+	for name := range g.running {
+		if g.configured[name] || discovered[name] {
+			continue
+		}
+		log.WithField("cluster", name).Infof("stop collecting cluster %s, which is no longer discovered", name)
+		g.stop(name)
+		allClustersHooks.afterClusterRemoved(name)
+	}
+}
+
+// updateConfigured collects the clusters configured statically, of which `primary` is the one
+// whose stats are passed to all hooks, and stops collecting those no longer configured.
+func (g *clusterGroup) updateConfigured(clusters []ClusterConfig, primary string) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	if primary != g.primary {
+		log.Infof("the primary cluster has changed from %q to %q", g.primary, primary)
+		g.primary = primary
+		g.restartAll()
+	}
+	configured := make(map[string]bool)
+	for _, c := range clusters {
+		configured[c.Name] = true
+		if r, found := g.running[c.Name]; found {
+			if g.configured[c.Name] && equalStrings(r.metaServers, c.MetaServers) {
+				continue
+			}
+			g.stop(c.Name)
+		}
+		g.start(c)
+	}
+	for name := range g.configured {
+		if configured[name] {
+			continue
+		}
+		log.WithField("cluster", name).Infof("stop collecting cluster %s, which is no longer configured", name)
+		g.stop(name)
+		allClustersHooks.afterClusterRemoved(name)
+	}
+	g.configured = configured
+	// the discovered clusters may be shadowed or unshadowed by the configured ones
+	g.doUpdateDiscovered()
+}
+
+// setOptions restarts all clusters with the options and the interval of the collection.
+func (g *clusterGroup) setOptions(opts PerfClientOptions, interval time.Duration) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	g.opts = opts
+	g.interval = interval
+	g.restartAll()
+}
+
+func (g *clusterGroup) restartAll() {
+	clusters := make([]ClusterConfig, 0, len(g.running))
+	for name, r := range g.running {
+		clusters = append(clusters, ClusterConfig{Name: name, MetaServers: r.metaServers})
+	}
+	for _, c := range clusters {
+		g.stop(c.Name)
+		g.start(c)
+	}
+}
+
+// wait waits until all the aggregation loops return.
+func (g *clusterGroup) wait() {
+	g.wg.Wait()
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
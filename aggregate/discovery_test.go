@@ -0,0 +1,181 @@
+package aggregate
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseClusterNode(t *testing.T) {
+	c, err := parseClusterNode("c1", []byte("10.0.0.1:34601, 10.0.0.2:34601\n"))
+	assert.Nil(t, err)
+	assert.Equal(t, c, ClusterConfig{Name: "c1", MetaServers: []string{"10.0.0.1:34601", "10.0.0.2:34601"}})
+
+	_, err = parseClusterNode("c1", []byte(" "))
+	assert.NotNil(t, err)
+}
+
+func TestDiscoveryFromConfig(t *testing.T) {
+	cfg, err := DiscoveryFromConfig()
+	assert.Nil(t, err)
+	assert.Nil(t, cfg)
+
+	viper.Set("discovery.zookeeper", map[string]interface{}{
+		"servers": []string{"127.0.0.1:2181"},
+		"path":    "/pegasus/clusters",
+	})
+	defer viper.Set("discovery.zookeeper", nil)
+	cfg, err = DiscoveryFromConfig()
+	assert.Nil(t, err)
+	assert.Equal(t, *cfg, DiscoveryConfig{Servers: []string{"127.0.0.1:2181"}, Path: "/pegasus/clusters", SessionTimeout: 10 * time.Second})
+
+	// all clusters are discovered
+	clusters, err := ClustersFromConfig()
+	assert.Nil(t, err)
+	assert.Equal(t, len(clusters), 0)
+
+	viper.Set("discovery.zookeeper.path", "pegasus")
+	_, err = DiscoveryFromConfig()
+	assert.NotNil(t, err)
+}
+
+type fakeAggregator struct {
+	metaAddrs []string
+	opts      PerfClientOptions
+
+	lock   sync.Mutex
+	closed bool
+}
+
+func (ag *fakeAggregator) Aggregate(ctx context.Context) (map[int32]*TableStats, *ClusterStats) {
+	return nil, nil
+}
+
+func (ag *fakeAggregator) Splits() <-chan *SplitEvent {
+	return nil
+}
+
+func (ag *fakeAggregator) Close() {
+	ag.lock.Lock()
+	defer ag.lock.Unlock()
+	ag.closed = true
+}
+
+func (ag *fakeAggregator) isClosed() bool {
+	ag.lock.Lock()
+	defer ag.lock.Unlock()
+	return ag.closed
+}
+
+func TestClusterGroup(t *testing.T) {
+	var removed []string
+	AddHookAfterClusterRemoved(func(cluster string) {
+		removed = append(removed, cluster)
+	})
+	defer func() {
+		allClustersHooks = tableStatsHooksManager{}
+	}()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	g := newClusterGroup(ctx, DefaultPerfClientOptions(), time.Hour, "c1")
+	aggregators := make(map[string]*fakeAggregator)
+	g.newAggregator = func(metaAddrs []string, opts PerfClientOptions) TableStatsAggregator {
+		ag := &fakeAggregator{metaAddrs: metaAddrs, opts: opts}
+		aggregators[opts.ClusterName] = ag
+		return ag
+	}
+	g.configured["static"] = true
+	g.start(ClusterConfig{Name: "static", MetaServers: []string{"127.0.0.1:34601"}})
+
+	g.updateDiscovered([]ClusterConfig{
+		{Name: "c1", MetaServers: []string{"10.0.0.1:34601"}},
+		{Name: "c2", MetaServers: []string{"10.0.1.1:34601"}},
+		{Name: "static", MetaServers: []string{"10.0.2.1:34601"}},
+	})
+	assert.Equal(t, len(g.running), 3)
+	assert.False(t, aggregators["c1"].opts.Additional)
+	assert.True(t, aggregators["c2"].opts.Additional)
+	// the configured cluster is not replaced
+	assert.Equal(t, aggregators["static"].metaAddrs, []string{"127.0.0.1:34601"})
+
+	c1 := aggregators["c1"]
+	g.updateDiscovered([]ClusterConfig{
+		{Name: "c1", MetaServers: []string{"10.0.0.1:34601", "10.0.0.2:34601"}},
+	})
+	assert.Equal(t, len(g.running), 2)
+	// the cluster whose meta servers have changed is restarted
+	assert.True(t, c1.isClosed())
+	assert.Equal(t, aggregators["c1"].metaAddrs, []string{"10.0.0.1:34601", "10.0.0.2:34601"})
+	assert.True(t, aggregators["c2"].isClosed())
+	assert.Equal(t, removed, []string{"c2"})
+
+	cancel()
+	g.wait()
+	assert.True(t, aggregators["c1"].isClosed())
+	assert.True(t, aggregators["static"].isClosed())
+}
+
+func TestClusterGroupReload(t *testing.T) {
+	defer func() {
+		allClustersHooks = tableStatsHooksManager{}
+	}()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	g := newClusterGroup(ctx, DefaultPerfClientOptions(), time.Hour, "c1")
+	aggregators := make(map[string]*fakeAggregator)
+	g.newAggregator = func(metaAddrs []string, opts PerfClientOptions) TableStatsAggregator {
+		ag := &fakeAggregator{metaAddrs: metaAddrs, opts: opts}
+		aggregators[opts.ClusterName] = ag
+		return ag
+	}
+	g.updateConfigured([]ClusterConfig{
+		{Name: "c1", MetaServers: []string{"10.0.0.1:34601"}},
+		{Name: "c2", MetaServers: []string{"10.0.1.1:34601"}},
+	}, "c1")
+	g.updateDiscovered([]ClusterConfig{
+		{Name: "c3", MetaServers: []string{"10.0.2.1:34601"}},
+	})
+	assert.Equal(t, len(g.running), 3)
+	c1, c2 := aggregators["c1"], aggregators["c2"]
+
+	// the unchanged clusters keep running
+	g.updateConfigured([]ClusterConfig{
+		{Name: "c1", MetaServers: []string{"10.0.0.1:34601"}},
+		{Name: "c3", MetaServers: []string{"10.0.3.1:34601"}},
+	}, "c1")
+	assert.Equal(t, len(g.running), 2)
+	assert.False(t, c1.isClosed())
+	assert.True(t, c2.isClosed())
+	// the configured cluster replaces the discovered one
+	assert.Equal(t, aggregators["c3"].metaAddrs, []string{"10.0.3.1:34601"})
+
+	// the discovered cluster is collected again once it's no longer configured
+	g.updateConfigured([]ClusterConfig{
+		{Name: "c1", MetaServers: []string{"10.0.0.1:34601"}},
+	}, "c1")
+	assert.Equal(t, aggregators["c3"].metaAddrs, []string{"10.0.2.1:34601"})
+
+	// all clusters are restarted with the new options
+	opts := DefaultPerfClientOptions()
+	opts.CollectSecondaries = true
+	g.setOptions(opts, time.Minute)
+	assert.True(t, c1.isClosed())
+	assert.Equal(t, len(g.running), 2)
+	assert.True(t, aggregators["c1"].opts.CollectSecondaries)
+	assert.True(t, aggregators["c3"].opts.CollectSecondaries)
+	assert.False(t, aggregators["c1"].opts.Additional)
+
+	// the primary cluster is changed
+	g.updateConfigured([]ClusterConfig{
+		{Name: "c1", MetaServers: []string{"10.0.0.1:34601"}},
+	}, "c3")
+	assert.True(t, aggregators["c1"].opts.Additional)
+	assert.False(t, aggregators["c3"].opts.Additional)
+
+	cancel()
+	g.wait()
+}
@@ -0,0 +1,83 @@
+package aggregate
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// PartialError is returned when a collection fails on some of the replica nodes or tables.
+// The results of the others are returned along with it, so that the callers can decide
+// whether to use the partial results, e.g. skip the failed nodes while one of them is restarting.
+type PartialError struct {
+	// node address -> the error of querying its perf-counters
+	Nodes map[string]error
+
+	// table name -> the error of querying its partition configuration
+	Tables map[string]error
+
+	// the failures that belong to no node or table, e.g. listing the tables from meta
+	Others []error
+}
+
+func (e *PartialError) Error() string {
+	var msgs []string
+	for _, addr := range sortedKeys(e.Nodes) {
+		msgs = append(msgs, fmt.Sprintf("node %s: %s", addr, e.Nodes[addr]))
+	}
+	for _, name := range sortedKeys(e.Tables) {
+		msgs = append(msgs, fmt.Sprintf("table \"%s\": %s", name, e.Tables[name]))
+	}
+	for _, err := range e.Others {
+		msgs = append(msgs, err.Error())
+	}
+	return fmt.Sprintf("%d failures: %s", len(msgs), strings.Join(msgs, "; "))
+}
+
+func (e *PartialError) addNode(addr string, err error) {
+	if e.Nodes == nil {
+		e.Nodes = make(map[string]error)
+	}
+	e.Nodes[addr] = err
+}
+
+func (e *PartialError) addTable(name string, err error) {
+	if e.Tables == nil {
+		e.Tables = make(map[string]error)
+	}
+	e.Tables[name] = err
+}
+
+// merge adds the failures of `err` if it's a PartialError, or `err` itself otherwise.
+func (e *PartialError) merge(err error) {
+	other, ok := err.(*PartialError)
+	if !ok {
+		e.Others = append(e.Others, err)
+		return
+	}
+	for addr, err := range other.Nodes {
+		e.addNode(addr, err)
+	}
+	for name, err := range other.Tables {
+		e.addTable(name, err)
+	}
+	e.Others = append(e.Others, other.Others...)
+}
+
+// errOrNil returns nil if there's no failure, which avoids returning a non-nil error
+// interface holding an empty PartialError.
+func (e *PartialError) errOrNil() error {
+	if len(e.Nodes) == 0 && len(e.Tables) == 0 && len(e.Others) == 0 {
+		return nil
+	}
+	return e
+}
+
+func sortedKeys(m map[string]error) []string {
+	var keys []string
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
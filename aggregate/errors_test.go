@@ -0,0 +1,30 @@
+package aggregate
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPartialError(t *testing.T) {
+	perr := &PartialError{}
+	assert.Nil(t, perr.errOrNil())
+
+	perr.addNode("127.0.0.1:34802", errors.New("timeout"))
+	other := &PartialError{}
+	other.addNode("127.0.0.1:34801", errors.New("connection refused"))
+	other.addTable("stat", errors.New("table not found"))
+	perr.merge(other)
+	perr.merge(errors.New("not a partial error"))
+
+	assert.NotNil(t, perr.errOrNil())
+	assert.EqualError(t, perr, "4 failures: node 127.0.0.1:34801: connection refused; "+
+		"node 127.0.0.1:34802: timeout; table \"stat\": table not found; not a partial error")
+
+	// the plain errors alone are failures as well
+	perr = &PartialError{}
+	perr.merge(errors.New("failed to list tables"))
+	assert.NotNil(t, perr.errOrNil())
+	assert.EqualError(t, perr, "1 failures: failed to list tables")
+}
@@ -0,0 +1,69 @@
+package aggregate
+
+import ()
+
+// metricExpiryTracker removes the metrics that are not seen in `window` consecutive cycles.
+// For example, if a node stops reporting a perf-counter after an upgrade, the metric
+// would otherwise remain at its last seen value forever.
+type metricExpiryTracker struct {
+	window int
+	cycle  int
+
+	// entity (a partition e.g.) -> metric name -> the last cycle that the metric was seen
+	lastSeen map[string]map[string]int
+}
+
+func newMetricExpiryTracker(window int) *metricExpiryTracker {
+	return &metricExpiryTracker{
+		window:   window,
+		lastSeen: make(map[string]map[string]int),
+	}
+}
+
+func (t *metricExpiryTracker) enabled() bool {
+	return t.window > 0
+}
+
+// nextCycle starts a new cycle of collection.
+func (t *metricExpiryTracker) nextCycle() {
+	t.cycle++
+}
+
+// observe marks the metrics of the entity as seen in the current cycle.
+func (t *metricExpiryTracker) observe(entity string, stats map[string]float64) {
+	seen, found := t.lastSeen[entity]
+	if !found {
+		seen = make(map[string]int)
+		t.lastSeen[entity] = seen
+	}
+	for name := range stats {
+		seen[name] = t.cycle
+	}
+}
+
+// expire removes the metrics of the entity that have not been seen in the last `window` cycles.
+func (t *metricExpiryTracker) expire(entity string, stats map[string]float64) {
+	seen := t.lastSeen[entity]
+	for name := range stats {
+		lastCycle, found := seen[name]
+		if !found {
+			// never seen before, start tracking it from now on
+			if seen == nil {
+				seen = make(map[string]int)
+				t.lastSeen[entity] = seen
+			}
+			seen[name] = t.cycle
+			continue
+		}
+		if t.cycle-lastCycle >= t.window {
+			log.Infof("metric \"%s\" of %s expired after not being seen in %d cycles", name, entity, t.window)
+			delete(stats, name)
+			delete(seen, name)
+		}
+	}
+}
+
+// forget stops tracking the entity.
+func (t *metricExpiryTracker) forget(entity string) {
+	delete(t.lastSeen, entity)
+}
@@ -0,0 +1,28 @@
+package aggregate
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetricExpiry(t *testing.T) {
+	tracker := newMetricExpiryTracker(2)
+	stats := map[string]float64{"read_qps": 10, "sst_count": 3}
+
+	tracker.nextCycle()
+	tracker.observe("1.0", stats)
+	tracker.expire("1.0", stats)
+	assert.Equal(t, len(stats), 2)
+
+	// "sst_count" is no longer reported
+	tracker.nextCycle()
+	tracker.observe("1.0", map[string]float64{"read_qps": 10})
+	tracker.expire("1.0", stats)
+	assert.Equal(t, len(stats), 2)
+
+	tracker.nextCycle()
+	tracker.observe("1.0", map[string]float64{"read_qps": 10})
+	tracker.expire("1.0", stats)
+	assert.Equal(t, stats, map[string]float64{"read_qps": 10})
+}
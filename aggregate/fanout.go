@@ -0,0 +1,31 @@
+package aggregate
+
+import (
+	"sync"
+)
+
+// fanOut calls `fn` for each index in [0, n) concurrently, by at most `concurrency` workers
+// if it's positive, otherwise one goroutine for each index. It returns after all calls finish.
+func fanOut(n int, concurrency int, fn func(i int)) {
+	workers := n
+	if concurrency > 0 && concurrency < n {
+		workers = concurrency
+	}
+	indices := make(chan int, n)
+	for i := 0; i < n; i++ {
+		indices <- i
+	}
+	close(indices)
+
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for w := 0; w < workers; w++ {
+		go func() {
+			defer wg.Done()
+			for i := range indices {
+				fn(i)
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,44 @@
+package aggregate
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFanOut(t *testing.T) {
+	var running, maxRunning int32
+	var lock sync.Mutex
+	called := make(map[int]bool)
+	fanOut(20, 3, func(i int) {
+		n := atomic.AddInt32(&running, 1)
+		defer atomic.AddInt32(&running, -1)
+		for {
+			max := atomic.LoadInt32(&maxRunning)
+			if n <= max || atomic.CompareAndSwapInt32(&maxRunning, max, n) {
+				break
+			}
+		}
+		time.Sleep(time.Millisecond)
+
+		lock.Lock()
+		called[i] = true
+		lock.Unlock()
+	})
+	assert.Equal(t, len(called), 20)
+	assert.True(t, maxRunning <= 3)
+
+	// unbounded
+	count := int32(0)
+	fanOut(5, 0, func(int) {
+		atomic.AddInt32(&count, 1)
+	})
+	assert.Equal(t, count, int32(5))
+
+	fanOut(0, 3, func(int) {
+		t.Fatal("no call is expected")
+	})
+}
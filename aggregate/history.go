@@ -35,28 +35,51 @@ func newHistory(capacity int) *threadSafeHistory {
 	}
 }
 
-//
 type historyStore struct {
 	lock sync.RWMutex
 
+	// cluster -> the history of the cluster
+	clusters map[string]*clusterHistory
+}
+
+// clusterHistory is the history of the tables and the stats of a cluster.
+type clusterHistory struct {
 	tables  map[int]*threadSafeHistory
 	cluster *threadSafeHistory
 }
 
+// of returns the history of the cluster, which is created if it's new. It must be called with
+// the write lock held.
+func (s *historyStore) of(cluster string) *clusterHistory {
+	h, found := s.clusters[cluster]
+	if !found {
+		h = &clusterHistory{
+			tables:  make(map[int]*threadSafeHistory),
+			cluster: newHistory(historyMaxCapacity),
+		}
+		s.clusters[cluster] = h
+	}
+	return h
+}
+
 var globalHistoryStore = &historyStore{
-	tables:  make(map[int]*threadSafeHistory),
-	cluster: newHistory(historyMaxCapacity),
+	clusters: make(map[string]*clusterHistory),
 }
 
-// SnapshotClusterStats takes a snapshot from the history. The returned array is ordered by time.
-func SnapshotClusterStats() []ClusterStats {
+// SnapshotClusterStats takes a snapshot of the history of the cluster. The returned array is
+// ordered by time.
+func SnapshotClusterStats(cluster string) []ClusterStats {
 	s := globalHistoryStore
 
 	s.lock.RLock()
 	defer s.lock.RUnlock()
 
 	var result []ClusterStats
-	l := s.cluster.stats
+	h, found := s.clusters[cluster]
+	if !found {
+		return result
+	}
+	l := h.cluster.stats
 	for e := l.Front(); e != nil; e = e.Next() {
 		stat, _ := e.Value.(*ClusterStats)
 		result = append(result, *stat)
@@ -64,32 +87,68 @@ func SnapshotClusterStats() []ClusterStats {
 	return result
 }
 
+// SnapshotTableStats takes a snapshot of the history of every table of the cluster, keyed by the
+// table name. Each array is ordered by time.
+func SnapshotTableStats(cluster string) map[string][]TableStats {
+	s := globalHistoryStore
+
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	result := make(map[string][]TableStats)
+	h, found := s.clusters[cluster]
+	if !found {
+		return result
+	}
+	for _, history := range h.tables {
+		history.lock.RLock()
+		for e := history.stats.Front(); e != nil; e = e.Next() {
+			stat, _ := e.Value.(*TableStats)
+			result[stat.TableName] = append(result[stat.TableName], *stat)
+		}
+		history.lock.RUnlock()
+	}
+	return result
+}
+
 func init() {
 	initHistoryStore()
 }
 
 func initHistoryStore() {
-	AddHookAfterTableStatEmitted(func(stats []TableStats, allStat ClusterStats) {
+	AddHookAfterClusterStatsEmitted(func(stats []TableStats, allStat ClusterStats) {
 		s := globalHistoryStore
 
 		s.lock.Lock()
 		defer s.lock.Unlock()
+		h := s.of(allStat.Cluster)
 		for _, stat := range stats {
-			history, found := s.tables[stat.AppID]
+			stat := stat
+			history, found := h.tables[stat.AppID]
 			if !found {
 				history = newHistory(historyMaxCapacity)
-				s.tables[stat.AppID] = history
+				h.tables[stat.AppID] = history
 			}
 			history.emit(&stat)
 		}
-		s.cluster.emit(&allStat)
+		h.cluster.emit(&allStat)
+	})
+
+	AddHookAfterClusterTableDropped(func(cluster string, appID int) {
+		s := globalHistoryStore
+
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		if h, found := s.clusters[cluster]; found {
+			delete(h.tables, appID)
+		}
 	})
 
-	AddHookAfterTableDropped(func(appID int) {
+	AddHookAfterClusterRemoved(func(cluster string) {
 		s := globalHistoryStore
 
 		s.lock.Lock()
 		defer s.lock.Unlock()
-		delete(s.tables, appID)
+		delete(s.clusters, cluster)
 	})
 }
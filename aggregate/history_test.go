@@ -7,17 +7,74 @@ import (
 	"github.com/stretchr/testify/assert"
 )
 
-func TestHistory(t *testing.T) {
-	hooksManager = tableStatsHooksManager{}
+func resetHistoryStore() {
+	allClustersHooks = tableStatsHooksManager{}
+	globalHistoryStore.clusters = make(map[string]*clusterHistory)
 	initHistoryStore()
+}
+
+func TestHistory(t *testing.T) {
+	resetHistoryStore()
 
 	for i := 0; i < historyMaxCapacity*2; i++ {
-		hooksManager.afterTableStatsEmitted([]TableStats{},
-			ClusterStats{Stats: map[string]float64{"write": 100.0 * float64(i)}, Timestamp: time.Now()})
+		allClustersHooks.afterTableStatsEmitted([]TableStats{},
+			ClusterStats{Cluster: "onebox", Stats: map[string]float64{"write": 100.0 * float64(i)}, Timestamp: time.Now()})
 	}
-	clusterStats := SnapshotClusterStats()
+	clusterStats := SnapshotClusterStats("onebox")
 	assert.Equal(t, len(clusterStats), historyMaxCapacity)
 	for i := 0; i < historyMaxCapacity; i++ {
 		assert.Equal(t, clusterStats[i].Stats["write"], float64(historyMaxCapacity+i)*100.0)
 	}
 }
+
+func TestTableHistory(t *testing.T) {
+	resetHistoryStore()
+
+	for i := 0; i < historyMaxCapacity+2; i++ {
+		allClustersHooks.afterTableStatsEmitted([]TableStats{
+			{TableName: "stat", AppID: 1, Stats: map[string]float64{"write": float64(i)}},
+			{TableName: "temp", AppID: 2, Stats: map[string]float64{"write": 10 * float64(i)}},
+		}, ClusterStats{Cluster: "onebox", Timestamp: time.Now()})
+	}
+	tables := SnapshotTableStats("onebox")
+	assert.Equal(t, len(tables), 2)
+	assert.Equal(t, len(tables["stat"]), historyMaxCapacity)
+	for i := 0; i < historyMaxCapacity; i++ {
+		assert.Equal(t, tables["stat"][i].Stats["write"], float64(i+2))
+		assert.Equal(t, tables["temp"][i].Stats["write"], 10*float64(i+2))
+	}
+
+	allClustersHooks.afterClusterTableDropped("onebox", 1)
+	tables = SnapshotTableStats("onebox")
+	assert.Equal(t, len(tables), 1)
+}
+
+func TestHistoryOfClusters(t *testing.T) {
+	resetHistoryStore()
+
+	// the tables of different clusters may have the same app ID
+	for i := 0; i < 3; i++ {
+		allClustersHooks.afterTableStatsEmitted([]TableStats{
+			{TableName: "stat", AppID: 1, Stats: map[string]float64{"write": float64(i)}},
+		}, ClusterStats{Cluster: "c1", Timestamp: time.Now(), Stats: map[string]float64{"write": float64(i)}})
+		allClustersHooks.afterTableStatsEmitted([]TableStats{
+			{TableName: "temp", AppID: 1, Stats: map[string]float64{"write": 10 * float64(i)}},
+		}, ClusterStats{Cluster: "c2", Timestamp: time.Now(), Stats: map[string]float64{"write": 10 * float64(i)}})
+	}
+	c1, c2 := SnapshotTableStats("c1"), SnapshotTableStats("c2")
+	assert.Equal(t, len(c1), 1)
+	assert.Equal(t, len(c1["stat"]), 3)
+	assert.Equal(t, len(c2), 1)
+	assert.Equal(t, c2["temp"][2].Stats["write"], float64(20))
+	assert.Equal(t, SnapshotClusterStats("c2")[2].Stats["write"], float64(20))
+	assert.Equal(t, len(SnapshotClusterStats("c3")), 0)
+
+	// the table of c1 is dropped, while the one of c2 with the same app ID is kept
+	allClustersHooks.afterClusterTableDropped("c1", 1)
+	assert.Equal(t, len(SnapshotTableStats("c1")), 0)
+	assert.Equal(t, len(SnapshotTableStats("c2")), 1)
+
+	allClustersHooks.afterClusterRemoved("c2")
+	assert.Equal(t, len(SnapshotClusterStats("c2")), 0)
+	assert.Equal(t, len(SnapshotClusterStats("c1")), 3)
+}
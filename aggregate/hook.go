@@ -1,12 +1,17 @@
 package aggregate
 
-import "sync"
+import (
+	"sync"
+	"time"
+)
 
 // HookAfterTableStatEmitted is a hook of event that new TableStats are generated.
 // Each call of the hook handles a batch of tables.
 type HookAfterTableStatEmitted func(stats []TableStats, allStats ClusterStats)
 
 // AddHookAfterTableStatEmitted adds a hook of event that a new TableStats is generated.
+// It's of the first cluster only if multiple clusters are collected, see
+// AddHookAfterClusterStatsEmitted for the hooks of every cluster.
 func AddHookAfterTableStatEmitted(hk HookAfterTableStatEmitted) {
 	m := &hooksManager
 	m.lock.Lock()
@@ -17,7 +22,8 @@ func AddHookAfterTableStatEmitted(hk HookAfterTableStatEmitted) {
 // HookAfterTableDropped is a hook of event that a table is dropped.
 type HookAfterTableDropped func(appID int)
 
-// AddHookAfterTableDropped adds a hook of event that a table is dropped.
+// AddHookAfterTableDropped adds a hook of event that a table is dropped, of the first cluster
+// only, see AddHookAfterClusterTableDropped for the hooks of every cluster.
 func AddHookAfterTableDropped(hk HookAfterTableDropped) {
 	m := &hooksManager
 	m.lock.Lock()
@@ -25,10 +31,44 @@ func AddHookAfterTableDropped(hk HookAfterTableDropped) {
 	m.droppedHooks = append(m.droppedHooks, hk)
 }
 
+// HookAfterCollectionDiagnosed is a hook of event that the diagnostics of a round
+// of collection are generated. Each call of the hook handles a batch of tables.
+type HookAfterCollectionDiagnosed func(diags []*CollectionDiagnostics)
+
+// AddHookAfterCollectionDiagnosed adds a hook of event that the diagnostics of a round
+// of collection are generated. The diagnostics are generated only if there's any hook of this kind.
+func AddHookAfterCollectionDiagnosed(hk HookAfterCollectionDiagnosed) {
+	m := &hooksManager
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.diagnosedHooks = append(m.diagnosedHooks, hk)
+}
+
+// HookAfterNodeStatsEmitted is a hook of event that the stats of the replica nodes are
+// generated, which are summed up from the partitions whose primaries locate on each node.
+type HookAfterNodeStatsEmitted func(nodes []NodeStat)
+
+// AddHookAfterNodeStatsEmitted adds a hook of event that the stats of the replica nodes are
+// generated. The node stats are generated only if there's any hook of this kind.
+func AddHookAfterNodeStatsEmitted(hk HookAfterNodeStatsEmitted) {
+	m := &hooksManager
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.nodeHooks = append(m.nodeHooks, hk)
+}
+
 type tableStatsHooksManager struct {
-	lock         sync.RWMutex
-	emittedHooks []HookAfterTableStatEmitted
-	droppedHooks []HookAfterTableDropped
+	lock           sync.RWMutex
+	emittedHooks   []HookAfterTableStatEmitted
+	droppedHooks   []HookAfterTableDropped
+	diagnosedHooks []HookAfterCollectionDiagnosed
+	nodeHooks      []HookAfterNodeStatsEmitted
+	configHooks    []HookAfterConfigChanged
+	livenessHooks  []HookAfterNodeStateChanged
+	leaderHooks    []HookAfterMetaLeaderChanged
+	removedHooks   []HookAfterClusterRemoved
+	clusterDropped []HookAfterClusterTableDropped
+	resultHooks    []HookAfterClusterAggregated
 }
 
 func (m *tableStatsHooksManager) afterTableStatsEmitted(stats []TableStats, allStat ClusterStats) {
@@ -49,4 +89,202 @@ func (m *tableStatsHooksManager) afterTableDropped(appID int32) {
 	}
 }
 
-var hooksManager tableStatsHooksManager
+func (m *tableStatsHooksManager) hasDiagnosedHooks() bool {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	return len(m.diagnosedHooks) > 0
+}
+
+func (m *tableStatsHooksManager) afterCollectionDiagnosed(diags []*CollectionDiagnostics) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	for _, hook := range m.diagnosedHooks {
+		hook(diags)
+	}
+}
+
+// hooksManager is the hooks of the first cluster, while allClustersHooks is the hooks of the
+// table and node stats of every cluster.
+var hooksManager, allClustersHooks tableStatsHooksManager
+
+// AddHookAfterClusterStatsEmitted adds a hook of event that new TableStats are generated, of
+// every cluster collected, which is told by ClusterStats.Cluster. The hooks added by
+// AddHookAfterTableStatEmitted are of the first cluster only.
+func AddHookAfterClusterStatsEmitted(hk HookAfterTableStatEmitted) {
+	m := &allClustersHooks
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.emittedHooks = append(m.emittedHooks, hk)
+}
+
+// AddHookAfterClusterNodeStatsEmitted adds a hook of event that the stats of the replica nodes
+// are generated, of every cluster collected, which is told by NodeStat.Cluster.
+func AddHookAfterClusterNodeStatsEmitted(hk HookAfterNodeStatsEmitted) {
+	m := &allClustersHooks
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.nodeHooks = append(m.nodeHooks, hk)
+}
+
+// HookAfterClusterTableDropped is a hook of event that a table of the cluster is dropped.
+type HookAfterClusterTableDropped func(cluster string, appID int)
+
+// AddHookAfterClusterTableDropped adds a hook of event that a table is dropped, of every cluster
+// collected.
+func AddHookAfterClusterTableDropped(hk HookAfterClusterTableDropped) {
+	m := &allClustersHooks
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.clusterDropped = append(m.clusterDropped, hk)
+}
+
+func (m *tableStatsHooksManager) afterClusterTableDropped(cluster string, appID int32) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	for _, hook := range m.clusterDropped {
+		hook(cluster, int(appID))
+	}
+}
+
+// HookAfterClusterRemoved is a hook of event that a cluster is no longer collected.
+type HookAfterClusterRemoved func(cluster string)
+
+// AddHookAfterClusterRemoved adds a hook of event that a cluster discovered from ZooKeeper is
+// removed, after which no stats of the cluster are emitted.
+func AddHookAfterClusterRemoved(hk HookAfterClusterRemoved) {
+	m := &allClustersHooks
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.removedHooks = append(m.removedHooks, hk)
+}
+
+func (m *tableStatsHooksManager) afterClusterRemoved(cluster string) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	for _, hook := range m.removedHooks {
+		hook(cluster)
+	}
+}
+
+// AggregationResult is the outcome of a round of aggregation of a cluster.
+type AggregationResult struct {
+	Cluster string
+	Time    time.Time
+	// the error of the meta servers, in which case the round is skipped
+	MetaErr error
+	// the error of collecting the stats from the replica nodes, in which case the stats of the
+	// failed nodes are stale
+	ScrapeErr error
+}
+
+// HookAfterClusterAggregated is a hook of event that a round of aggregation of a cluster ends,
+// successfully or not.
+type HookAfterClusterAggregated func(r AggregationResult)
+
+// AddHookAfterClusterAggregated adds a hook of event that a round of aggregation ends, of every
+// cluster collected, including the rounds skipped for the unreachable meta servers.
+func AddHookAfterClusterAggregated(hk HookAfterClusterAggregated) {
+	m := &allClustersHooks
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.resultHooks = append(m.resultHooks, hk)
+}
+
+func (m *tableStatsHooksManager) afterClusterAggregated(r AggregationResult) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	for _, hook := range m.resultHooks {
+		hook(r)
+	}
+}
+
+func (m *tableStatsHooksManager) hasNodeHooks() bool {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	return len(m.nodeHooks) > 0
+}
+
+func (m *tableStatsHooksManager) afterNodeStatsEmitted(nodes []NodeStat) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	for _, hook := range m.nodeHooks {
+		hook(nodes)
+	}
+}
+
+// HookAfterConfigChanged is a hook of event that the configurations of some partitions have
+// changed since the last round of collection, e.g. the primaries are moved.
+type HookAfterConfigChanged func(events []*ConfigChangeEvent)
+
+// AddHookAfterConfigChanged adds a hook of event that the configurations of some partitions
+// have changed. The hook is called only if there's any change in the round.
+func AddHookAfterConfigChanged(hk HookAfterConfigChanged) {
+	m := &hooksManager
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.configHooks = append(m.configHooks, hk)
+}
+
+func (m *tableStatsHooksManager) afterConfigChanged(events []*ConfigChangeEvent) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	for _, hook := range m.configHooks {
+		hook(events)
+	}
+}
+
+// HookAfterNodeStateChanged is a hook of event that some replica nodes have transitioned
+// between alive and unalive since the last round of collection.
+type HookAfterNodeStateChanged func(events []*NodeStateEvent)
+
+// AddHookAfterNodeStateChanged adds a hook of event that some replica nodes have transitioned.
+// The hook is called only if there's any transition in the round.
+func AddHookAfterNodeStateChanged(hk HookAfterNodeStateChanged) {
+	m := &hooksManager
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.livenessHooks = append(m.livenessHooks, hk)
+}
+
+func (m *tableStatsHooksManager) afterNodeStateChanged(events []*NodeStateEvent) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	for _, hook := range m.livenessHooks {
+		hook(events)
+	}
+}
+
+// HookAfterMetaLeaderChanged is a hook of event that the leader of the meta servers has changed
+// since the last round of collection.
+type HookAfterMetaLeaderChanged func(event *MetaLeaderChangeEvent)
+
+// AddHookAfterMetaLeaderChanged adds a hook of event that the leader of the meta servers has
+// changed. The leader is queried in every round only if there's any hook of this kind.
+func AddHookAfterMetaLeaderChanged(hk HookAfterMetaLeaderChanged) {
+	m := &hooksManager
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.leaderHooks = append(m.leaderHooks, hk)
+}
+
+func (m *tableStatsHooksManager) hasMetaLeaderHooks() bool {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	return len(m.leaderHooks) > 0
+}
+
+func (m *tableStatsHooksManager) afterMetaLeaderChanged(event *MetaLeaderChangeEvent) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	for _, hook := range m.leaderHooks {
+		hook(event)
+	}
+}
@@ -4,6 +4,8 @@ import (
 	"testing"
 	"time"
 
+	"github.com/XiaoMi/pegasus-go-client/idl/admin"
+	"github.com/spf13/viper"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -32,3 +34,56 @@ func TestAddHook(t *testing.T) {
 	// clear up
 	hooksManager = tableStatsHooksManager{}
 }
+
+func TestAdditionalClusterHooks(t *testing.T) {
+	var dropped []int
+	AddHookAfterTableDropped(func(appID int) {
+		dropped = append(dropped, appID)
+	})
+	defer func() {
+		hooksManager = tableStatsHooksManager{}
+	}()
+
+	tables := []*admin.AppInfo{{AppID: 1, AppName: "stat", PartitionCount: 4}}
+	first := &tableStatsAggregator{tables: make(map[int32]*TableStats), splits: make(chan *SplitEvent, 1)}
+	additional := &tableStatsAggregator{tables: make(map[int32]*TableStats), splits: make(chan *SplitEvent, 1), additional: true}
+	for _, ag := range []*tableStatsAggregator{first, additional} {
+		ag.doUpdateTableMap(tables)
+		ag.doUpdateTableMap(nil)
+	}
+	// only the table of the first cluster is passed to the hook
+	assert.Equal(t, dropped, []int{1})
+	assert.Equal(t, len(noHooks.droppedHooks), 0)
+}
+
+func TestClustersFromConfig(t *testing.T) {
+	viper.Set("cluster_name", "onebox")
+	viper.Set("meta_servers", []string{"127.0.0.1:34601"})
+	defer viper.Set("meta_servers", nil)
+	clusters, err := ClustersFromConfig()
+	assert.Nil(t, err)
+	assert.Equal(t, clusters, []ClusterConfig{{Name: "onebox", MetaServers: []string{"127.0.0.1:34601"}}})
+
+	viper.Set("clusters", []map[string]interface{}{
+		{"name": "c1", "meta_servers": []string{"127.0.0.1:34601"}},
+		{"name": "c2", "meta_servers": []string{"127.0.0.2:34601", "127.0.0.3:34601"}},
+	})
+	defer viper.Set("clusters", nil)
+	clusters, err = ClustersFromConfig()
+	assert.Nil(t, err)
+	assert.Equal(t, len(clusters), 2)
+	assert.Equal(t, clusters[1].MetaServers, []string{"127.0.0.2:34601", "127.0.0.3:34601"})
+
+	for _, invalid := range [][]map[string]interface{}{
+		{{"name": "c1"}},
+		{{"meta_servers": []string{"127.0.0.1:34601"}}},
+		{
+			{"name": "c1", "meta_servers": []string{"127.0.0.1:34601"}},
+			{"name": "c1", "meta_servers": []string{"127.0.0.2:34601"}},
+		},
+	} {
+		viper.Set("clusters", invalid)
+		_, err = ClustersFromConfig()
+		assert.NotNil(t, err)
+	}
+}
@@ -0,0 +1,269 @@
+package aggregate
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/XiaoMi/pegasus-go-client/idl/base"
+	"github.com/tidwall/gjson"
+)
+
+// MetricsBackend is the protocol to collect the metrics from replica nodes.
+type MetricsBackend string
+
+const (
+	// MetricsBackendPerfCounter collects the perf-counters through the remote command,
+	// which is supported by all versions of Pegasus. It's the default backend.
+	MetricsBackendPerfCounter MetricsBackend = "perf_counter"
+
+	// MetricsBackendHTTP collects the entity-based metrics from the HTTP /metrics API
+	// introduced in Pegasus 2.x, which is served on the same port as RPC.
+	MetricsBackendHTTP MetricsBackend = "http"
+
+	// MetricsBackendAuto tries the HTTP API on each node first, and falls back to the
+	// perf-counters if the node doesn't support it.
+	MetricsBackendAuto MetricsBackend = "auto"
+)
+
+// httpMetric describes how a metric of the HTTP API is mapped to the collector's metric.
+type httpMetric struct {
+	name string
+
+	// The metric is a cumulative counter, which is converted to the per-second rate
+	// between two consecutive collections.
+	cumulative bool
+}
+
+// httpMetricsConversion maps the metrics of the replica entities to the names in AllMetrics.
+var httpMetricsConversion = map[string]httpMetric{
+	"get_requests":              {"get_qps", true},
+	"multi_get_requests":        {"multi_get_qps", true},
+	"put_requests":              {"put_qps", true},
+	"multi_put_requests":        {"multi_put_qps", true},
+	"remove_requests":           {"remove_qps", true},
+	"multi_remove_requests":     {"multi_remove_qps", true},
+	"incr_requests":             {"incr_qps", true},
+	"check_and_set_requests":    {"check_and_set_qps", true},
+	"check_and_mutate_requests": {"check_and_mutate_qps", true},
+	"scan_requests":             {"scan_qps", true},
+	"backup_requests":           {"backup_request_qps", true},
+	"dup_requests":              {"duplicate_qps", true},
+
+	"get_bytes":              {"get_bytes", true},
+	"multi_get_bytes":        {"multi_get_bytes", true},
+	"scan_bytes":             {"scan_bytes", true},
+	"put_bytes":              {"put_bytes", true},
+	"multi_put_bytes":        {"multi_put_bytes", true},
+	"check_and_set_bytes":    {"check_and_set_bytes", true},
+	"check_and_mutate_bytes": {"check_and_mutate_bytes", true},
+
+	"rdb_total_sst_files":                         {"sst_count", false},
+	"rdb_total_sst_size_mb":                       {"sst_storage_mb", false},
+	"rdb_estimated_keys":                          {"rdb_estimate_num_keys", false},
+	"rdb_index_and_filter_blocks_mem_usage_bytes": {"rdb_index_and_filter_blocks_mem_usage", false},
+	"rdb_memtable_mem_usage_bytes":                {"rdb_memtable_mem_usage", false},
+}
+
+// httpMetricsCmdCaller emulates the "perf-counters-by-substr" remote command over the HTTP
+// metrics API, so that the metrics flow through the same decoding as the perf-counters.
+// The metrics of each replica entity are named as "<metric>@<table_id>.<partition_id>",
+// where <metric> is already converted by httpMetricsConversion.
+// NOTE: the node-level perf-counters, e.g. the disk capacity, are not available.
+type httpMetricsCmdCaller struct {
+	url    string
+	client *http.Client
+
+	lock sync.Mutex
+	// converts the cumulative metrics of each replica entity into rates
+	rates *counterRateTracker
+}
+
+// httpCumulativeMetrics returns the converted names of the cumulative metrics.
+func httpCumulativeMetrics() []string {
+	var names []string
+	for _, conv := range httpMetricsConversion {
+		if conv.cumulative {
+			names = append(names, conv.name)
+		}
+	}
+	return names
+}
+
+func newHTTPMetricsCmdCaller(addr string, tlsCfg *tls.Config) *httpMetricsCmdCaller {
+	scheme := "http"
+	transport := &http.Transport{}
+	if tlsCfg != nil {
+		scheme = "https"
+		transport.TLSClientConfig = tlsCfg
+	}
+	return &httpMetricsCmdCaller{
+		url:    fmt.Sprintf("%s://%s/metrics?types=replica", scheme, addr),
+		client: &http.Client{Transport: transport},
+		rates:  newCounterRateTracker(httpCumulativeMetrics()),
+	}
+}
+
+func (c *httpMetricsCmdCaller) Call(ctx context.Context, command string, arguments []string) (string, error) {
+	if command != "perf-counters-by-substr" {
+		return "", fmt.Errorf("remote command \"%s\" is not supported by the HTTP metrics API", command)
+	}
+	filter := ""
+	if len(arguments) > 0 {
+		filter = arguments[0]
+	}
+
+	body, err := c.fetch(ctx)
+	if err != nil {
+		return "", err
+	}
+	counters := c.convert(body, time.Now())
+
+	type counter struct {
+		Name  string  `json:"name"`
+		Value float64 `json:"value"`
+	}
+	result := struct {
+		Counters []counter `json:"counters"`
+	}{Counters: []counter{}}
+	for name, value := range counters {
+		if strings.Contains(name, filter) {
+			result.Counters = append(result.Counters, counter{Name: name, Value: value})
+		}
+	}
+	data, err := json.Marshal(result)
+	return string(data), err
+}
+
+func (c *httpMetricsCmdCaller) fetch(ctx context.Context) ([]byte, error) {
+	req, err := http.NewRequest(http.MethodGet, c.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: %s", c.url, resp.Status)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if !gjson.ValidBytes(body) {
+		return nil, fmt.Errorf("GET %s: the response is not a valid JSON", c.url)
+	}
+	return body, nil
+}
+
+// Close releases the idle connections to the node.
+func (c *httpMetricsCmdCaller) Close() {
+	c.client.CloseIdleConnections()
+}
+
+// convert maps the replica entities in the response to the perf-counters. The cumulative
+// metrics are omitted on the first collection of each replica, and the increments since the
+// reset are used after they are reset, see counterRateTracker. The replicas absent from the
+// response are forgotten, e.g. after they are moved to other nodes.
+func (c *httpMetricsCmdCaller) convert(body []byte, now time.Time) map[string]float64 {
+	entities := gjson.GetBytes(body, "entities")
+	if !entities.Exists() {
+		// some versions respond with the array of entities only
+		entities = gjson.ParseBytes(body)
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	counters := make(map[string]float64)
+	seen := make(map[string]bool)
+	for _, entity := range entities.Array() {
+		if entity.Get("type").String() != "replica" {
+			continue
+		}
+		part := &PartitionStats{
+			Gpid: base.Gpid{
+				Appid:          int32(entity.Get("attributes.table_id").Int()),
+				PartitionIndex: int32(entity.Get("attributes.partition_id").Int()),
+			},
+			CollectedAt: now,
+		}
+		gpid := fmt.Sprintf("%d.%d", part.Gpid.Appid, part.Gpid.PartitionIndex)
+		for _, metric := range entity.Get("metrics").Array() {
+			conv, found := httpMetricsConversion[metric.Get("name").String()]
+			if !found {
+				continue
+			}
+			value := metric.Get("value").Float()
+			if conv.cumulative {
+				part.getOrInitStats()[conv.name] = value
+			} else {
+				counters[conv.name+"@"+gpid] = value
+			}
+		}
+		seen[sampleKey(part)] = true
+		c.rates.convert(part)
+		for name, value := range part.Stats {
+			counters[name+"@"+gpid] = value
+		}
+	}
+	c.rates.retain(seen)
+	return counters
+}
+
+// autoDetectCmdCaller decides the backend of a node on the first call that either of
+// the backends succeeds, trying the HTTP metrics API first.
+type autoDetectCmdCaller struct {
+	addr   string
+	http   remoteCmdCaller
+	legacy remoteCmdCaller
+
+	lock     sync.Mutex
+	detected remoteCmdCaller
+}
+
+func (c *autoDetectCmdCaller) Call(ctx context.Context, command string, arguments []string) (string, error) {
+	c.lock.Lock()
+	detected := c.detected
+	c.lock.Unlock()
+	if detected != nil {
+		return detected.Call(ctx, command, arguments)
+	}
+
+	result, httpErr := c.http.Call(ctx, command, arguments)
+	if httpErr == nil {
+		c.detect(c.http, MetricsBackendHTTP)
+		return result, nil
+	}
+	result, err := c.legacy.Call(ctx, command, arguments)
+	if err == nil {
+		log.WithField("node", c.addr).Infof("node %s doesn't support the HTTP metrics API: %s", c.addr, httpErr)
+		c.detect(c.legacy, MetricsBackendPerfCounter)
+	}
+	return result, err
+}
+
+func (c *autoDetectCmdCaller) detect(caller remoteCmdCaller, backend MetricsBackend) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.detected == nil {
+		log.WithField("node", c.addr).Infof("collect the metrics of node %s through the %s backend", c.addr, backend)
+		c.detected = caller
+	}
+}
+
+func (c *autoDetectCmdCaller) Close() {
+	for _, caller := range []remoteCmdCaller{c.http, c.legacy} {
+		if closer, ok := caller.(interface{ Close() }); ok {
+			closer.Close()
+		}
+	}
+}
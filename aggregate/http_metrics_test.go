@@ -0,0 +1,146 @@
+package aggregate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/XiaoMi/pegasus-go-client/idl/base"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func newFakeMetricsServer(getRequests *int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/metrics" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		fmt.Fprintf(w, `{"cluster":"onebox","role":"replica_server","entities":[
+			{"type":"replica","id":"1.0","attributes":{"table_id":"1","partition_id":"0"},
+			 "metrics":[{"name":"get_requests","value":%d},{"name":"rdb_total_sst_files","value":3},{"name":"unknown","value":1}]},
+			{"type":"server","id":"server","attributes":{},"metrics":[{"name":"get_requests","value":100}]}]}`, *getRequests)
+	}))
+}
+
+func TestHTTPMetricsCmdCaller(t *testing.T) {
+	getRequests := 100
+	server := newFakeMetricsServer(&getRequests)
+	defer server.Close()
+
+	caller := newHTTPMetricsCmdCaller(strings.TrimPrefix(server.URL, "http://"), nil)
+	s := &PerfSession{remoteCmdCaller: caller, Address: "127.0.0.1:34801"}
+
+	// the rate of the cumulative counter is unknown on the first collection
+	counters, err := s.GetPerfCounters(context.Background(), "@")
+	assert.Nil(t, err)
+	assert.Equal(t, len(counters), 1)
+	assert.Equal(t, counters[0].Name, "sst_count@1.0")
+
+	// pretend the last collection was 10 seconds ago
+	caller.lock.Lock()
+	last := caller.rates.lastSamples[(&base.Gpid{Appid: 1, PartitionIndex: 0}).String()]
+	last.at = last.at.Add(-10 * time.Second)
+	caller.lock.Unlock()
+	getRequests = 600
+
+	nodes, _, err := (&PerfClient{}).getNodeStats(context.Background(), []*PerfSession{s}, "@", nil)
+	assert.Nil(t, err)
+	partitions := decodePartitionStats(nodes, nil)
+	assert.Equal(t, len(partitions), 1)
+	assert.Equal(t, partitions[0].Gpid, base.Gpid{Appid: 1, PartitionIndex: 0})
+	assert.InDelta(t, partitions[0].Stats["get_qps"], 50, 1)
+	assert.InDelta(t, partitions[0].Stats["read_qps"], 50, 1)
+	assert.Equal(t, partitions[0].Stats["sst_count"], float64(3))
+
+	// filtered by another table
+	counters, err = s.GetPerfCounters(context.Background(), "@2.")
+	assert.Nil(t, err)
+	assert.Equal(t, len(counters), 0)
+
+	_, err = caller.Call(context.Background(), "server-info", nil)
+	assert.NotNil(t, err)
+}
+
+func TestHTTPMetricsCmdCallerCounterReset(t *testing.T) {
+	caller := newHTTPMetricsCmdCaller("127.0.0.1:34801", nil)
+	body := func(v int) []byte {
+		// some versions respond with the array of entities only
+		return []byte(fmt.Sprintf(`[{"type":"replica","attributes":{"table_id":"1","partition_id":"0"},
+			"metrics":[{"name":"get_requests","value":%d}]}]`, v))
+	}
+	now := time.Now()
+	assert.Equal(t, len(caller.convert(body(100), now)), 0)
+	assert.Equal(t, caller.convert(body(200), now.Add(10*time.Second)), map[string]float64{"get_qps@1.0": 10})
+	// the node restarted, so the increment since the reset is used
+	assert.Equal(t, caller.convert(body(10), now.Add(20*time.Second)), map[string]float64{"get_qps@1.0": 1})
+	assert.Equal(t, caller.convert(body(110), now.Add(30*time.Second)), map[string]float64{"get_qps@1.0": 10})
+}
+
+func TestHTTPMetricsCmdCallerForgetReplicas(t *testing.T) {
+	caller := newHTTPMetricsCmdCaller("127.0.0.1:34801", nil)
+	now := time.Now()
+	caller.convert([]byte(`[{"type":"replica","attributes":{"table_id":"1","partition_id":"0"},
+		"metrics":[{"name":"get_requests","value":100}]},
+		{"type":"replica","attributes":{"table_id":"1","partition_id":"1"},
+		"metrics":[{"name":"get_requests","value":100}]}]`), now)
+	assert.Equal(t, len(caller.rates.lastSamples), 2)
+
+	// the partition 1.1 is moved to another node
+	caller.convert([]byte(`[{"type":"replica","attributes":{"table_id":"1","partition_id":"0"},
+		"metrics":[{"name":"get_requests","value":200}]}]`), now.Add(10*time.Second))
+	assert.Equal(t, len(caller.rates.lastSamples), 1)
+	assert.NotNil(t, caller.rates.lastSamples[(&base.Gpid{Appid: 1, PartitionIndex: 0}).String()])
+}
+
+func TestAutoDetectCmdCaller(t *testing.T) {
+	getRequests := 0
+	server := newFakeMetricsServer(&getRequests)
+	defer server.Close()
+
+	legacy := &fakeCmdCaller{result: `{"counters":[]}`}
+	caller := &autoDetectCmdCaller{
+		http:   newHTTPMetricsCmdCaller(strings.TrimPrefix(server.URL, "http://"), nil),
+		legacy: legacy,
+	}
+	_, err := caller.Call(context.Background(), "perf-counters-by-substr", []string{"@"})
+	assert.Nil(t, err)
+	assert.Equal(t, caller.detected, caller.http)
+
+	// the node doesn't support the HTTP API
+	server.Close()
+	caller = &autoDetectCmdCaller{
+		http:   newHTTPMetricsCmdCaller(strings.TrimPrefix(server.URL, "http://"), nil),
+		legacy: legacy,
+	}
+	_, err = caller.Call(context.Background(), "perf-counters-by-substr", []string{"@"})
+	assert.Nil(t, err)
+	assert.Equal(t, caller.detected, remoteCmdCaller(legacy))
+
+	// undecided if both fail
+	caller = &autoDetectCmdCaller{
+		http:   newHTTPMetricsCmdCaller(strings.TrimPrefix(server.URL, "http://"), nil),
+		legacy: &fakeCmdCaller{err: errors.New("connection refused")},
+	}
+	_, err = caller.Call(context.Background(), "perf-counters-by-substr", []string{"@"})
+	assert.NotNil(t, err)
+	assert.Nil(t, caller.detected)
+}
+
+func TestMetricsBackendFromConfig(t *testing.T) {
+	defer viper.Set("metrics.backend", nil)
+
+	viper.Set("metrics.backend", "http")
+	opts, err := PerfClientOptionsFromConfig()
+	assert.Nil(t, err)
+	assert.Equal(t, opts.MetricsBackend, MetricsBackendHTTP)
+
+	viper.Set("metrics.backend", "prometheus")
+	_, err = PerfClientOptionsFromConfig()
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,160 @@
+package aggregate
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/jcmturner/gokrb5/v8/client"
+	"github.com/jcmturner/gokrb5/v8/config"
+	"github.com/jcmturner/gokrb5/v8/gssapi"
+	"github.com/jcmturner/gokrb5/v8/iana/keyusage"
+	"github.com/jcmturner/gokrb5/v8/keytab"
+	"github.com/jcmturner/gokrb5/v8/spnego"
+	"github.com/jcmturner/gokrb5/v8/types"
+	"github.com/spf13/viper"
+)
+
+// KerberosConfig is the Kerberos identity of the collector to authenticate to the Pegasus
+// servers with security enabled.
+type KerberosConfig struct {
+	// The principal of the collector, e.g. "collector@EXAMPLE.COM", whose realm defaults to
+	// the default realm of Krb5Config, and its keytab.
+	Principal string
+	Keytab    string
+
+	// The path of krb5.conf, "/etc/krb5.conf" if it's empty.
+	Krb5Config string `mapstructure:"krb5_config"`
+
+	// The principal of the Pegasus servers is "<ServiceName>/<ServiceFQDN>", where ServiceName
+	// is "pegasus" if it's empty, and ServiceFQDN is the host of each server if it's empty.
+	ServiceName string `mapstructure:"service_name"`
+	ServiceFQDN string `mapstructure:"service_fqdn"`
+}
+
+// KerberosFromConfig returns the configuration of "kerberos", or nil if no principal is
+// given, in which case the authentication is disabled.
+func KerberosFromConfig() (*KerberosConfig, error) {
+	var cfg KerberosConfig
+	if err := viper.UnmarshalKey("kerberos", &cfg); err != nil {
+		return nil, err
+	}
+	if cfg.Principal == "" {
+		return nil, nil
+	}
+	return &cfg, nil
+}
+
+// SASLAuthenticator authenticates the sessions to the Pegasus servers by SASL with the
+// Kerberos V5 mechanism (GSSAPI). It's shared by all sessions, which reuse its tickets.
+type SASLAuthenticator struct {
+	client      *client.Client
+	serviceName string
+	serviceFQDN string
+}
+
+// NewSASLAuthenticator loads the keytab and krb5.conf of the configuration. The collector
+// logs in to the KDC on the first authentication.
+func NewSASLAuthenticator(cfg KerberosConfig) (*SASLAuthenticator, error) {
+	if cfg.Keytab == "" {
+		return nil, fmt.Errorf("the keytab of principal %s is required", cfg.Principal)
+	}
+	kt, err := keytab.Load(cfg.Keytab)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load keytab %s: %s", cfg.Keytab, err)
+	}
+	if cfg.Krb5Config == "" {
+		cfg.Krb5Config = "/etc/krb5.conf"
+	}
+	krb5Cfg, err := config.Load(cfg.Krb5Config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load %s: %s", cfg.Krb5Config, err)
+	}
+	username, realm := cfg.Principal, krb5Cfg.LibDefaults.DefaultRealm
+	if i := strings.LastIndex(cfg.Principal, "@"); i >= 0 {
+		username, realm = cfg.Principal[:i], cfg.Principal[i+1:]
+	}
+	if realm == "" {
+		return nil, fmt.Errorf("no realm of principal %s", cfg.Principal)
+	}
+	if cfg.ServiceName == "" {
+		cfg.ServiceName = "pegasus"
+	}
+	return &SASLAuthenticator{
+		client:      client.NewWithKeytab(username, realm, kt, krb5Cfg, client.DisablePAFXFAST(true)),
+		serviceName: cfg.ServiceName,
+		serviceFQDN: cfg.ServiceFQDN,
+	}, nil
+}
+
+// newMechanism returns the mechanism to authenticate a connection to the server at addr.
+func (a *SASLAuthenticator) newMechanism(addr string) saslMechanism {
+	host := a.serviceFQDN
+	if host == "" {
+		host, _, _ = net.SplitHostPort(addr)
+	}
+	return &gssapiMechanism{client: a.client, spn: a.serviceName + "/" + host}
+}
+
+// gssapiMechanism is the SASL GSSAPI mechanism of RFC 4752 without the security layer, i.e.
+// the connection is authenticated only, and its messages are neither signed nor encrypted.
+type gssapiMechanism struct {
+	client *client.Client
+	spn    string
+
+	// the session key of the service ticket
+	key         types.EncryptionKey
+	established bool
+}
+
+func (m *gssapiMechanism) name() string {
+	return "GSSAPI"
+}
+
+// start returns the AP-REQ of the service ticket, without requesting the mutual
+// authentication, so the server replies the security layers it supports directly.
+func (m *gssapiMechanism) start() ([]byte, error) {
+	if err := m.client.AffirmLogin(); err != nil {
+		return nil, fmt.Errorf("failed to log in to the KDC: %s", err)
+	}
+	tkt, key, err := m.client.GetServiceTicket(m.spn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the service ticket of %s: %s", m.spn, err)
+	}
+	token, err := spnego.NewKRB5TokenAPREQ(m.client, tkt, key, []int{gssapi.ContextFlagInteg, gssapi.ContextFlagConf}, nil)
+	if err != nil {
+		return nil, err
+	}
+	m.key = key
+	return token.Marshal()
+}
+
+// The security layers of RFC 4752.
+const saslNoSecurityLayer = 0x01
+
+// step accepts the security layers that the server supports, and selects no security layer.
+func (m *gssapiMechanism) step(challenge []byte) ([]byte, error) {
+	if m.established {
+		return nil, fmt.Errorf("unexpected challenge after the GSSAPI context is established")
+	}
+	var wt gssapi.WrapToken
+	if err := wt.Unmarshal(challenge, true); err != nil {
+		return nil, fmt.Errorf("invalid security layers of the server: %s", err)
+	}
+	if ok, err := wt.Verify(m.key, keyusage.GSSAPI_ACCEPTOR_SEAL); !ok {
+		return nil, fmt.Errorf("failed to verify the security layers of the server: %s", err)
+	}
+	if len(wt.Payload) != 4 {
+		return nil, fmt.Errorf("invalid length %d of the security layers of the server", len(wt.Payload))
+	}
+	if wt.Payload[0]&saslNoSecurityLayer == 0 {
+		return nil, fmt.Errorf("the server requires a security layer")
+	}
+	// no security layer with the max buffer size of 0, and no authorization identity
+	resp, err := gssapi.NewInitiatorWrapToken([]byte{saslNoSecurityLayer, 0, 0, 0}, m.key)
+	if err != nil {
+		return nil, err
+	}
+	m.established = true
+	return resp.Marshal()
+}
@@ -0,0 +1,52 @@
+package aggregate
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/jcmturner/gokrb5/v8/gssapi"
+	"github.com/jcmturner/gokrb5/v8/iana/etypeID"
+	"github.com/jcmturner/gokrb5/v8/iana/keyusage"
+	"github.com/jcmturner/gokrb5/v8/types"
+	"github.com/stretchr/testify/assert"
+)
+
+// acceptorLayers returns the security layers sent by the server.
+func acceptorLayers(t *testing.T, key types.EncryptionKey, payload []byte) []byte {
+	wt := gssapi.WrapToken{Flags: 0x01, EC: 12, Payload: payload}
+	assert.Nil(t, wt.SetCheckSum(key, keyusage.GSSAPI_ACCEPTOR_SEAL))
+	b, err := wt.Marshal()
+	assert.Nil(t, err)
+	return b
+}
+
+func TestGSSAPIStep(t *testing.T) {
+	key := types.EncryptionKey{KeyType: etypeID.AES256_CTS_HMAC_SHA1_96, KeyValue: make([]byte, 32)}
+	m := &gssapiMechanism{key: key}
+
+	// any of no layer, integrity and privacy, with the max buffer size of 64KB
+	resp, err := m.step(acceptorLayers(t, key, []byte{0x07, 0x01, 0x00, 0x00}))
+	assert.Nil(t, err)
+	var wt gssapi.WrapToken
+	assert.Nil(t, wt.Unmarshal(resp, false))
+	ok, err := wt.Verify(key, keyusage.GSSAPI_INITIATOR_SEAL)
+	assert.True(t, ok, err)
+	assert.Equal(t, wt.Payload, []byte{saslNoSecurityLayer, 0, 0, 0})
+
+	// no more challenge after the context is established
+	_, err = m.step(acceptorLayers(t, key, []byte{0x07, 0x01, 0x00, 0x00}))
+	assert.NotNil(t, err)
+
+	for _, challenge := range [][]byte{
+		// the privacy is required
+		acceptorLayers(t, key, []byte{0x04, 0x01, 0x00, 0x00}),
+		acceptorLayers(t, key, []byte{0x07}),
+		// signed by another key
+		acceptorLayers(t, types.EncryptionKey{KeyType: etypeID.AES256_CTS_HMAC_SHA1_96, KeyValue: bytes.Repeat([]byte{1}, 32)}, []byte{0x07, 0, 0, 0}),
+		[]byte("garbage"),
+	} {
+		m := &gssapiMechanism{key: key}
+		_, err := m.step(challenge)
+		assert.NotNil(t, err)
+	}
+}
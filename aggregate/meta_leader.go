@@ -0,0 +1,63 @@
+package aggregate
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/XiaoMi/pegasus-go-client/idl/admin"
+)
+
+// MetaLeaderChangeEvent indicates that the leader of the meta servers has changed since the
+// last round of collection.
+type MetaLeaderChangeEvent struct {
+	// the cluster of the meta servers, set by the aggregator
+	Cluster string
+
+	OldLeader string
+	NewLeader string
+
+	DetectedAt time.Time
+}
+
+// MetaLeader returns the address of the leader of the meta servers, which is the
+// "primary_meta_server" of the cluster info.
+func (m *PerfClient) MetaLeader(ctx context.Context) (string, error) {
+	resp, err := m.metaManager().QueryClusterInfo(ctx, &admin.ClusterInfoRequest{})
+	if err != nil {
+		return "", err
+	}
+	for i, key := range resp.Keys {
+		if key == "primary_meta_server" && i < len(resp.Values) {
+			return resp.Values[i], nil
+		}
+	}
+	return "", errors.New("no primary_meta_server in the cluster info")
+}
+
+// updateMetaLeader emits the event if the leader of the meta servers differs from the last
+// round. It's skipped unless there's any hook of the event.
+func (ag *tableStatsAggregator) updateMetaLeader(ctx context.Context) {
+	if !ag.hooks().hasMetaLeaderHooks() {
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
+	defer cancel()
+	leader, err := ag.client.MetaLeader(ctx)
+	if err != nil {
+		ag.logger().Errorf("unable to get the meta leader: %s", err)
+		return
+	}
+	prev := ag.metaLeader
+	ag.metaLeader = leader
+	if prev == "" || prev == leader {
+		return
+	}
+	ag.logger().Infof("the meta leader changes from %s to %s", prev, leader)
+	ag.hooks().afterMetaLeaderChanged(&MetaLeaderChangeEvent{
+		Cluster:    ag.cluster,
+		OldLeader:  prev,
+		NewLeader:  leader,
+		DetectedAt: time.Now(),
+	})
+}
@@ -0,0 +1,274 @@
+package aggregate
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/XiaoMi/pegasus-go-client/session"
+	"github.com/apache/thrift/lib/go/thrift"
+)
+
+// The RPC to authenticate a connection by SASL before any other RPC, which is absent from
+// pegasus-go-client. See negotiation_request in security.thrift of Pegasus.
+const rpcNegotiation = "RPC_NEGOTIATION"
+
+func init() {
+	session.RegisterRPCResultHandler(rpcNegotiation+"_ACK", func() session.RpcResponseResult {
+		return &negotiationResult{Success: &negotiationResponse{}}
+	})
+}
+
+// negotiationStatus is the negotiation_status enum.
+type negotiationStatus int32
+
+const (
+	negotiationInvalid negotiationStatus = iota
+	saslListMechanisms
+	saslListMechanismsResp
+	saslSelectMechanisms
+	saslSelectMechanismsResp
+	saslInitiate
+	saslChallenge
+	saslChallengeResp
+	saslSucc
+	saslAuthDisable
+	saslAuthFail
+)
+
+func (s negotiationStatus) String() string {
+	names := []string{
+		"INVALID",
+		"SASL_LIST_MECHANISMS",
+		"SASL_LIST_MECHANISMS_RESP",
+		"SASL_SELECT_MECHANISMS",
+		"SASL_SELECT_MECHANISMS_RESP",
+		"SASL_INITIATE",
+		"SASL_CHALLENGE",
+		"SASL_CHALLENGE_RESP",
+		"SASL_SUCC",
+		"SASL_AUTH_DISABLE",
+		"SASL_AUTH_FAIL",
+	}
+	if s < 0 || int(s) >= len(names) {
+		return fmt.Sprintf("negotiation_status(%d)", int32(s))
+	}
+	return names[s]
+}
+
+// negotiationRequest is the negotiation_request struct.
+type negotiationRequest struct {
+	Status negotiationStatus
+	Msg    []byte
+}
+
+// negotiationResponse is the negotiation_response struct.
+type negotiationResponse struct {
+	Status negotiationStatus
+	Msg    []byte
+}
+
+// negotiationArgs wraps the request as the arguments of the RPC.
+type negotiationArgs struct {
+	Req *negotiationRequest
+}
+
+func (p *negotiationArgs) String() string {
+	if p == nil || p.Req == nil {
+		return "<nil>"
+	}
+	return fmt.Sprintf("negotiationArgs(%s)", p.Req.Status)
+}
+
+func (p *negotiationArgs) Write(oprot thrift.TProtocol) error {
+	if err := oprot.WriteStructBegin("negotiation_args"); err != nil {
+		return err
+	}
+	if err := oprot.WriteFieldBegin("request", thrift.STRUCT, 1); err != nil {
+		return err
+	}
+	if err := p.Req.write(oprot); err != nil {
+		return err
+	}
+	if err := oprot.WriteFieldEnd(); err != nil {
+		return err
+	}
+	if err := oprot.WriteFieldStop(); err != nil {
+		return err
+	}
+	return oprot.WriteStructEnd()
+}
+
+func (r *negotiationRequest) write(oprot thrift.TProtocol) error {
+	if err := oprot.WriteStructBegin("negotiation_request"); err != nil {
+		return err
+	}
+	if err := oprot.WriteFieldBegin("status", thrift.I32, 1); err != nil {
+		return err
+	}
+	if err := oprot.WriteI32(int32(r.Status)); err != nil {
+		return err
+	}
+	if err := oprot.WriteFieldEnd(); err != nil {
+		return err
+	}
+	// the blob of rDSN is encoded as the thrift binary
+	if err := oprot.WriteFieldBegin("msg", thrift.STRING, 2); err != nil {
+		return err
+	}
+	if err := oprot.WriteBinary(r.Msg); err != nil {
+		return err
+	}
+	if err := oprot.WriteFieldEnd(); err != nil {
+		return err
+	}
+	if err := oprot.WriteFieldStop(); err != nil {
+		return err
+	}
+	return oprot.WriteStructEnd()
+}
+
+// negotiationResult wraps the response as the result of the RPC.
+type negotiationResult struct {
+	Success *negotiationResponse
+}
+
+func (p *negotiationResult) String() string {
+	if p == nil || p.Success == nil {
+		return "<nil>"
+	}
+	return fmt.Sprintf("negotiationResult(%s)", p.Success.Status)
+}
+
+func (p *negotiationResult) Read(iprot thrift.TProtocol) error {
+	return readStruct(iprot, func(id int16, typ thrift.TType) (bool, error) {
+		if id != 0 || typ != thrift.STRUCT {
+			return false, nil
+		}
+		return true, p.Success.read(iprot)
+	})
+}
+
+func (r *negotiationResponse) read(iprot thrift.TProtocol) error {
+	return readStruct(iprot, func(id int16, typ thrift.TType) (bool, error) {
+		switch {
+		case id == 1 && typ == thrift.I32:
+			status, err := iprot.ReadI32()
+			r.Status = negotiationStatus(status)
+			return true, err
+		case id == 2 && typ == thrift.STRING:
+			msg, err := iprot.ReadBinary()
+			r.Msg = msg
+			return true, err
+		default:
+			return false, nil
+		}
+	})
+}
+
+// readStruct reads the fields of a struct by `readField`, which returns false if the field
+// is unknown and should be skipped.
+func readStruct(iprot thrift.TProtocol, readField func(id int16, typ thrift.TType) (bool, error)) error {
+	if _, err := iprot.ReadStructBegin(); err != nil {
+		return err
+	}
+	for {
+		_, typ, id, err := iprot.ReadFieldBegin()
+		if err != nil {
+			return err
+		}
+		if typ == thrift.STOP {
+			break
+		}
+		known, err := readField(id, typ)
+		if err != nil {
+			return err
+		}
+		if !known {
+			if err := iprot.Skip(typ); err != nil {
+				return err
+			}
+		}
+		if err := iprot.ReadFieldEnd(); err != nil {
+			return err
+		}
+	}
+	return iprot.ReadStructEnd()
+}
+
+// saslMechanism is the client side of a SASL mechanism on a connection.
+type saslMechanism interface {
+	name() string
+
+	// start returns the initial response of the client.
+	start() ([]byte, error)
+
+	// step returns the response to the challenge of the server.
+	step(challenge []byte) ([]byte, error)
+}
+
+// maxNegotiationSteps bounds the challenges from a misbehaving server.
+const maxNegotiationSteps = 10
+
+// negotiate authenticates the connection by the mechanism, where `call` sends a request of
+// the negotiation and returns its response. The server lists its mechanisms first, one of
+// which is selected, and then it challenges the client until it succeeds or fails.
+func negotiate(call func(req *negotiationRequest) (*negotiationResponse, error), mech saslMechanism) error {
+	resp, err := call(&negotiationRequest{Status: saslListMechanisms})
+	if err != nil {
+		return err
+	}
+	if resp.Status == saslAuthDisable {
+		// the server doesn't authenticate its clients
+		return nil
+	}
+	if err := expectStatus(resp, saslListMechanismsResp); err != nil {
+		return err
+	}
+	mechanisms := strings.Split(string(resp.Msg), ",")
+	found := false
+	for _, m := range mechanisms {
+		if strings.TrimSpace(m) == mech.name() {
+			found = true
+		}
+	}
+	if !found {
+		return fmt.Errorf("mechanism %s is not supported by the server, which supports %q", mech.name(), resp.Msg)
+	}
+
+	resp, err = call(&negotiationRequest{Status: saslSelectMechanisms, Msg: []byte(mech.name())})
+	if err != nil {
+		return err
+	}
+	if err := expectStatus(resp, saslSelectMechanismsResp); err != nil {
+		return err
+	}
+
+	token, err := mech.start()
+	if err != nil {
+		return err
+	}
+	resp, err = call(&negotiationRequest{Status: saslInitiate, Msg: token})
+	for i := 0; err == nil && resp.Status == saslChallenge; i++ {
+		if i == maxNegotiationSteps {
+			return fmt.Errorf("too many challenges of the negotiation")
+		}
+		if token, err = mech.step(resp.Msg); err != nil {
+			return err
+		}
+		resp, err = call(&negotiationRequest{Status: saslChallengeResp, Msg: token})
+	}
+	if err != nil {
+		return err
+	}
+	return expectStatus(resp, saslSucc)
+}
+
+func expectStatus(resp *negotiationResponse, status negotiationStatus) error {
+	if resp.Status == saslAuthFail {
+		return fmt.Errorf("authentication failed: %s", resp.Msg)
+	}
+	if resp.Status != status {
+		return fmt.Errorf("unexpected status %s of the negotiation, which should be %s", resp.Status, status)
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+package aggregate
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/apache/thrift/lib/go/thrift"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeMechanism responds to each challenge with the challenge itself.
+type fakeMechanism struct {
+	challenges [][]byte
+}
+
+func (m *fakeMechanism) name() string {
+	return "GSSAPI"
+}
+
+func (m *fakeMechanism) start() ([]byte, error) {
+	return []byte("initial"), nil
+}
+
+func (m *fakeMechanism) step(challenge []byte) ([]byte, error) {
+	m.challenges = append(m.challenges, challenge)
+	return challenge, nil
+}
+
+// fakeNegotiationServer replies the responses in order, and records the requests.
+type fakeNegotiationServer struct {
+	requests  []*negotiationRequest
+	responses []*negotiationResponse
+}
+
+func (s *fakeNegotiationServer) call(req *negotiationRequest) (*negotiationResponse, error) {
+	s.requests = append(s.requests, req)
+	if len(s.requests) > len(s.responses) {
+		return nil, fmt.Errorf("connection closed")
+	}
+	return s.responses[len(s.requests)-1], nil
+}
+
+func TestNegotiate(t *testing.T) {
+	s := &fakeNegotiationServer{responses: []*negotiationResponse{
+		{Status: saslListMechanismsResp, Msg: []byte("PLAIN,GSSAPI")},
+		{Status: saslSelectMechanismsResp},
+		{Status: saslChallenge, Msg: []byte("layers")},
+		{Status: saslSucc},
+	}}
+	m := &fakeMechanism{}
+	assert.Nil(t, negotiate(s.call, m))
+	var statuses []negotiationStatus
+	for _, req := range s.requests {
+		statuses = append(statuses, req.Status)
+	}
+	assert.Equal(t, statuses, []negotiationStatus{saslListMechanisms, saslSelectMechanisms, saslInitiate, saslChallengeResp})
+	assert.Equal(t, string(s.requests[1].Msg), "GSSAPI")
+	assert.Equal(t, string(s.requests[2].Msg), "initial")
+	assert.Equal(t, string(s.requests[3].Msg), "layers")
+
+	// the server doesn't authenticate
+	s = &fakeNegotiationServer{responses: []*negotiationResponse{{Status: saslAuthDisable}}}
+	assert.Nil(t, negotiate(s.call, &fakeMechanism{}))
+
+	for _, responses := range [][]*negotiationResponse{
+		{{Status: saslListMechanismsResp, Msg: []byte("PLAIN")}},
+		{{Status: saslListMechanismsResp, Msg: []byte("GSSAPI")}, {Status: saslAuthFail}},
+		{{Status: saslListMechanismsResp, Msg: []byte("GSSAPI")}, {Status: saslSelectMechanismsResp}, {Status: saslAuthFail}},
+		// the connection is closed
+		{{Status: saslListMechanismsResp, Msg: []byte("GSSAPI")}, {Status: saslSelectMechanismsResp}, {Status: saslChallenge}},
+	} {
+		s := &fakeNegotiationServer{responses: responses}
+		assert.NotNil(t, negotiate(s.call, &fakeMechanism{}))
+	}
+}
+
+func TestNegotiationCodec(t *testing.T) {
+	buf := thrift.NewTMemoryBuffer()
+	prot := thrift.NewTBinaryProtocolTransport(buf)
+	args := &negotiationArgs{Req: &negotiationRequest{Status: saslInitiate, Msg: []byte("token")}}
+	assert.Nil(t, args.Write(prot))
+
+	// the request is read back as the response, since they share the fields
+	var req negotiationResponse
+	err := readStruct(prot, func(id int16, typ thrift.TType) (bool, error) {
+		assert.Equal(t, id, int16(1))
+		return true, req.read(prot)
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, req.Status, saslInitiate)
+	assert.Equal(t, string(req.Msg), "token")
+
+	// the result is the response of field 0
+	assert.Nil(t, prot.WriteStructBegin("negotiation_result"))
+	assert.Nil(t, prot.WriteFieldBegin("success", thrift.STRUCT, 0))
+	assert.Nil(t, (&negotiationRequest{Status: saslSucc}).write(prot))
+	assert.Nil(t, prot.WriteFieldEnd())
+	assert.Nil(t, prot.WriteFieldStop())
+	assert.Nil(t, prot.WriteStructEnd())
+	result := &negotiationResult{Success: &negotiationResponse{}}
+	assert.Nil(t, result.Read(prot))
+	assert.Equal(t, result.Success.Status, saslSucc)
+	assert.Equal(t, result.String(), "negotiationResult(SASL_SUCC)")
+}
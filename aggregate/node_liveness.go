@@ -0,0 +1,171 @@
+package aggregate
+
+import (
+	"sort"
+	"time"
+
+	"github.com/XiaoMi/pegasus-go-client/idl/admin"
+)
+
+// NodeState is the status of a replica node on the meta server.
+type NodeState string
+
+const (
+	NodeAlive   NodeState = "alive"
+	NodeUnalive NodeState = "unalive"
+)
+
+// The cluster-only metrics of the liveness of the replica nodes.
+const (
+	// the number of the nodes transitioned in the round
+	nodeStateChangeCountMetric = "node_state_change_count"
+	flappingNodeCountMetric    = "flapping_node_count"
+)
+
+// The defaults of PerfClientOptions.NodeFlapWindow and NodeFlapThreshold.
+const (
+	defaultNodeFlapWindow    = 10 * time.Minute
+	defaultNodeFlapThreshold = 3
+)
+
+// NodeStateEvent indicates that a replica node has transitioned between alive and unalive
+// since the last round of collection.
+type NodeStateEvent struct {
+	// the cluster of the node, set by the aggregator
+	Cluster string
+
+	Addr     string
+	OldState NodeState
+	NewState NodeState
+
+	// Flapping is true if the node has transitioned no less than NodeFlapThreshold times
+	// within NodeFlapWindow, including this one.
+	Flapping bool
+
+	DetectedAt time.Time
+}
+
+// nodeStatesOf returns the states of the nodes that are alive or unalive.
+func nodeStatesOf(statuses map[string]admin.NodeStatus) map[string]NodeState {
+	res := make(map[string]NodeState, len(statuses))
+	for addr, status := range statuses {
+		switch status {
+		case admin.NodeStatus_NS_ALIVE:
+			res[addr] = NodeAlive
+		case admin.NodeStatus_NS_UNALIVE:
+			res[addr] = NodeUnalive
+		}
+	}
+	return res
+}
+
+// nodeLivenessTracker remembers the states of the last round, and the recent transitions of
+// every node to detect the flapping.
+type nodeLivenessTracker struct {
+	window    time.Duration
+	threshold int
+
+	last map[string]NodeState
+	// node -> the times of the transitions within the window
+	transitions map[string][]time.Time
+}
+
+func newNodeLivenessTracker(window time.Duration, threshold int) *nodeLivenessTracker {
+	if window <= 0 {
+		window = defaultNodeFlapWindow
+	}
+	if threshold <= 0 {
+		threshold = defaultNodeFlapThreshold
+	}
+	return &nodeLivenessTracker{
+		window:      window,
+		threshold:   threshold,
+		last:        make(map[string]NodeState),
+		transitions: make(map[string][]time.Time),
+	}
+}
+
+// update returns the transitions since the last round, sorted by the address. The nodes that
+// first show up have no event, and the nodes no longer listed are forgotten.
+func (t *nodeLivenessTracker) update(states map[string]NodeState, now time.Time) []*NodeStateEvent {
+	var events []*NodeStateEvent
+	for addr, state := range states {
+		prev, found := t.last[addr]
+		t.last[addr] = state
+		if !found || prev == state {
+			continue
+		}
+		t.transitions[addr] = append(t.transitions[addr], now)
+		events = append(events, &NodeStateEvent{
+			Addr:       addr,
+			OldState:   prev,
+			NewState:   state,
+			DetectedAt: now,
+		})
+	}
+	for addr := range t.last {
+		if _, found := states[addr]; !found {
+			delete(t.last, addr)
+			delete(t.transitions, addr)
+		}
+	}
+	t.expire(now)
+	for _, e := range events {
+		e.Flapping = len(t.transitions[e.Addr]) >= t.threshold
+	}
+	sort.Slice(events, func(i, j int) bool {
+		return events[i].Addr < events[j].Addr
+	})
+	return events
+}
+
+// expire removes the transitions out of the window.
+func (t *nodeLivenessTracker) expire(now time.Time) {
+	for addr, times := range t.transitions {
+		i := 0
+		for i < len(times) && now.Sub(times[i]) > t.window {
+			i++
+		}
+		if i == len(times) {
+			delete(t.transitions, addr)
+		} else {
+			t.transitions[addr] = times[i:]
+		}
+	}
+}
+
+// flapping returns the nodes flapping currently, sorted by the address.
+func (t *nodeLivenessTracker) flapping() []string {
+	var res []string
+	for addr, times := range t.transitions {
+		if len(times) >= t.threshold {
+			res = append(res, addr)
+		}
+	}
+	sort.Strings(res)
+	return res
+}
+
+// updateNodeLiveness tracks the states of the nodes, emits the transitions, and sets the
+// metrics of the liveness into the cluster stats.
+func (ag *tableStatsAggregator) updateNodeLiveness(statuses map[string]admin.NodeStatus) {
+	if ag.liveness == nil {
+		return
+	}
+	events := ag.liveness.update(nodeStatesOf(statuses), time.Now())
+	for _, e := range events {
+		if e.Flapping {
+			ag.logger().WithField("node", e.Addr).Warnf("replica node %s is flapping, %s -> %s", e.Addr, e.OldState, e.NewState)
+		} else {
+			ag.logger().WithField("node", e.Addr).Infof("replica node %s changes its state, %s -> %s", e.Addr, e.OldState, e.NewState)
+		}
+	}
+	ag.allStats.Stats[nodeStateChangeCountMetric] = float64(len(events))
+	ag.allStats.Stats[flappingNodeCountMetric] = float64(len(ag.liveness.flapping()))
+	if len(events) != 0 {
+		for _, e := range events {
+			e.Cluster = ag.cluster
+		}
+		ag.hooks().afterNodeStateChanged(events)
+	}
+}
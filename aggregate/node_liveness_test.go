@@ -0,0 +1,74 @@
+package aggregate
+
+import (
+	"testing"
+	"time"
+
+	"github.com/XiaoMi/pegasus-go-client/idl/admin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNodeLivenessTracker(t *testing.T) {
+	tracker := newNodeLivenessTracker(10*time.Minute, 3)
+	now := time.Now()
+	alive := map[string]NodeState{"127.0.0.1:34801": NodeAlive, "127.0.0.1:34802": NodeAlive}
+	down := map[string]NodeState{"127.0.0.1:34801": NodeUnalive, "127.0.0.1:34802": NodeAlive}
+
+	// no event for the nodes first show up
+	assert.Nil(t, tracker.update(alive, now))
+
+	events := tracker.update(down, now.Add(time.Minute))
+	assert.Equal(t, events, []*NodeStateEvent{{
+		Addr:       "127.0.0.1:34801",
+		OldState:   NodeAlive,
+		NewState:   NodeUnalive,
+		DetectedAt: now.Add(time.Minute),
+	}})
+	assert.Nil(t, tracker.update(down, now.Add(2*time.Minute)))
+	events = tracker.update(alive, now.Add(3*time.Minute))
+	assert.False(t, events[0].Flapping)
+
+	// the 3rd transition within 10 minutes
+	events = tracker.update(down, now.Add(4*time.Minute))
+	assert.True(t, events[0].Flapping)
+	assert.Equal(t, tracker.flapping(), []string{"127.0.0.1:34801"})
+
+	// the first transition is out of the window
+	events = tracker.update(alive, now.Add(12*time.Minute))
+	assert.True(t, events[0].Flapping)
+	assert.Nil(t, tracker.update(alive, now.Add(14*time.Minute)))
+	assert.Equal(t, len(tracker.transitions["127.0.0.1:34801"]), 2)
+	assert.Nil(t, tracker.flapping())
+
+	// the removed node is forgotten
+	tracker.update(map[string]NodeState{"127.0.0.1:34802": NodeAlive}, now.Add(15*time.Minute))
+	assert.NotContains(t, tracker.last, "127.0.0.1:34801")
+	assert.NotContains(t, tracker.transitions, "127.0.0.1:34801")
+}
+
+func TestUpdateNodeLiveness(t *testing.T) {
+	ag := &tableStatsAggregator{
+		tables:   make(map[int32]*TableStats),
+		liveness: newNodeLivenessTracker(time.Hour, 2),
+	}
+	var emitted []*NodeStateEvent
+	AddHookAfterNodeStateChanged(func(events []*NodeStateEvent) {
+		emitted = append(emitted, events...)
+	})
+
+	statuses := map[string]admin.NodeStatus{
+		"127.0.0.1:34801": admin.NodeStatus_NS_ALIVE,
+		"127.0.0.1:34802": admin.NodeStatus_NS_ALIVE,
+		"127.0.0.1:34803": admin.NodeStatus_NS_INVALID,
+	}
+	for _, status := range []admin.NodeStatus{admin.NodeStatus_NS_ALIVE, admin.NodeStatus_NS_UNALIVE, admin.NodeStatus_NS_ALIVE} {
+		statuses["127.0.0.1:34801"] = status
+		ag.aggregateClusterStats()
+		ag.updateNodeLiveness(statuses)
+	}
+	assert.Equal(t, len(emitted), 2)
+	assert.Equal(t, emitted[1].NewState, NodeAlive)
+	assert.True(t, emitted[1].Flapping)
+	assert.Equal(t, ag.allStats.Stats["node_state_change_count"], float64(1))
+	assert.Equal(t, ag.allStats.Stats["flapping_node_count"], float64(1))
+}
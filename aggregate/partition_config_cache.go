@@ -0,0 +1,148 @@
+package aggregate
+
+import (
+	"sync"
+	"time"
+
+	"github.com/XiaoMi/pegasus-go-client/idl/admin"
+	"github.com/XiaoMi/pegasus-go-client/idl/base"
+	"github.com/XiaoMi/pegasus-go-client/idl/replication"
+)
+
+// PartitionConfigCache caches the partition configurations of the tables queried from meta
+// for a period of time (TTL), so that frequent collections don't issue QueryConfig for every
+// table every time. A non-positive TTL disables the cache.
+//
+// The configurations of a table are versioned by its AppID and partition count. The cached
+// ones mismatching the table listed from meta, e.g. after the table is recreated or split,
+// are queried again. The table is also invalidated by Invalidate once its primaries are found
+// to be out of date, see staleConfigTables.
+type PartitionConfigCache struct {
+	lock sync.Mutex
+
+	ttl time.Duration
+	// table name -> the cached configurations
+	entries map[string]*partitionConfigEntry
+}
+
+type partitionConfigEntry struct {
+	appID          int32
+	partitionCount int32
+	configs        []*replication.PartitionConfiguration
+	updatedAt      time.Time
+}
+
+// NewPartitionConfigCache returns an empty PartitionConfigCache.
+func NewPartitionConfigCache(ttl time.Duration) *PartitionConfigCache {
+	return &PartitionConfigCache{
+		ttl:     ttl,
+		entries: make(map[string]*partitionConfigEntry),
+	}
+}
+
+// Get returns the cached configurations of the table, and false if they are absent, expired,
+// or of another version of the table.
+func (c *PartitionConfigCache) Get(tb *admin.AppInfo) ([]*replication.PartitionConfiguration, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	e, found := c.entries[tb.AppName]
+	if !found || c.ttl <= 0 || time.Since(e.updatedAt) >= c.ttl {
+		return nil, false
+	}
+	if e.appID != tb.AppID || e.partitionCount != tb.PartitionCount {
+		return nil, false
+	}
+	return e.configs, true
+}
+
+// Set caches the configurations of the table.
+func (c *PartitionConfigCache) Set(tb *admin.AppInfo, configs []*replication.PartitionConfiguration) {
+	if c.ttl <= 0 {
+		return
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.entries[tb.AppName] = &partitionConfigEntry{
+		appID:          tb.AppID,
+		partitionCount: tb.PartitionCount,
+		configs:        configs,
+		updatedAt:      time.Now(),
+	}
+}
+
+// Invalidate removes the configurations of the tables with the given AppIDs.
+func (c *PartitionConfigCache) Invalidate(appIDs ...int32) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	invalid := make(map[int32]bool)
+	for _, id := range appIDs {
+		invalid[id] = true
+	}
+	for name, e := range c.entries {
+		if invalid[e.appID] {
+			delete(c.entries, name)
+		}
+	}
+}
+
+// InvalidateAll empties the cache.
+func (c *PartitionConfigCache) InvalidateAll() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.entries = make(map[string]*partitionConfigEntry)
+}
+
+// Retain removes the configurations of the tables other than `tables`, e.g. the dropped ones.
+func (c *PartitionConfigCache) Retain(tables []*admin.AppInfo) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	names := make(map[string]bool, len(tables))
+	for _, tb := range tables {
+		names[tb.AppName] = true
+	}
+	for name := range c.entries {
+		if !names[name] {
+			delete(c.entries, name)
+		}
+	}
+}
+
+// staleConfigTables returns the AppIDs of the tables whose configurations are out of date:
+// a primary is not an alive node, or the primary has replied without the partition.
+func staleConfigTables(primaries map[base.Gpid]string, nodes []*NodeStat, alive map[string]bool) []int32 {
+	// node address -> the partitions on the node
+	replied := make(map[string]map[base.Gpid]bool)
+	for _, n := range nodes {
+		gpids := make(map[base.Gpid]bool)
+		for name, value := range n.Stats {
+			if pc := decodePartitionPerfCounter(name, value); pc != nil {
+				gpids[pc.gpid] = true
+			}
+		}
+		replied[n.Addr] = gpids
+	}
+
+	stale := make(map[int32]bool)
+	for gpid, primary := range primaries {
+		if stale[gpid.Appid] {
+			continue
+		}
+		if !alive[primary] {
+			stale[gpid.Appid] = true
+			continue
+		}
+		if gpids, found := replied[primary]; found && !gpids[gpid] {
+			stale[gpid.Appid] = true
+		}
+	}
+	var res []int32
+	for appID := range stale {
+		res = append(res, appID)
+	}
+	return res
+}
@@ -0,0 +1,67 @@
+package aggregate
+
+import (
+	"testing"
+	"time"
+
+	"github.com/XiaoMi/pegasus-go-client/idl/admin"
+	"github.com/XiaoMi/pegasus-go-client/idl/base"
+	"github.com/XiaoMi/pegasus-go-client/idl/replication"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPartitionConfigCache(t *testing.T) {
+	cache := NewPartitionConfigCache(50 * time.Millisecond)
+	stat := &admin.AppInfo{AppID: 1, AppName: "stat", PartitionCount: 1}
+	_, ok := cache.Get(stat)
+	assert.False(t, ok)
+
+	configs := []*replication.PartitionConfiguration{{Pid: &base.Gpid{Appid: 1, PartitionIndex: 0}}}
+	cache.Set(stat, configs)
+	cached, ok := cache.Get(stat)
+	assert.True(t, ok)
+	assert.Equal(t, cached, configs)
+
+	// the table is split or recreated
+	_, ok = cache.Get(&admin.AppInfo{AppID: 1, AppName: "stat", PartitionCount: 2})
+	assert.False(t, ok)
+	_, ok = cache.Get(&admin.AppInfo{AppID: 3, AppName: "stat", PartitionCount: 1})
+	assert.False(t, ok)
+
+	cache.Invalidate(1)
+	_, ok = cache.Get(stat)
+	assert.False(t, ok)
+
+	cache.Set(stat, configs)
+	cache.Retain([]*admin.AppInfo{{AppID: 2, AppName: "test"}})
+	_, ok = cache.Get(stat)
+	assert.False(t, ok)
+
+	cache.Set(stat, configs)
+	time.Sleep(50 * time.Millisecond)
+	_, ok = cache.Get(stat)
+	assert.False(t, ok)
+
+	// the cache is disabled
+	cache = NewPartitionConfigCache(0)
+	cache.Set(stat, configs)
+	_, ok = cache.Get(stat)
+	assert.False(t, ok)
+}
+
+func TestStaleConfigTables(t *testing.T) {
+	primaries := map[base.Gpid]string{
+		{Appid: 1}: "127.0.0.1:34801",
+		{Appid: 2}: "127.0.0.1:34802",
+		{Appid: 3}: "127.0.0.1:34803",
+	}
+	nodes := []*NodeStat{
+		{Addr: "127.0.0.1:34801", Stats: map[string]float64{"replica*app.pegasus*get_qps@1.0": 1}},
+		// the primary of table 2 has moved
+		{Addr: "127.0.0.1:34802", Stats: map[string]float64{}},
+	}
+	alive := map[string]bool{"127.0.0.1:34801": true, "127.0.0.1:34802": true}
+	// the primary of table 3 is dead
+	stale := staleConfigTables(primaries, nodes, alive)
+	assert.ElementsMatch(t, stale, []int32{2, 3})
+}
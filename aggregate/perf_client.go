@@ -2,28 +2,323 @@ package aggregate
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"sync"
 	"time"
 
 	"github.com/XiaoMi/pegasus-go-client/idl/admin"
 	"github.com/XiaoMi/pegasus-go-client/idl/base"
+	"github.com/XiaoMi/pegasus-go-client/idl/replication"
 	"github.com/XiaoMi/pegasus-go-client/session"
-	log "github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus"
 )
 
+// PerfClientOptions is the configuration of PerfClient.
+type PerfClientOptions struct {
+	// DryRun disables the perf-counter RPCs to replica nodes. The topology of the
+	// cluster is still discovered from meta, but the stats returned are all zero.
+	// It's useful for testing the configuration without touching replica nodes.
+	DryRun bool
+
+	// TLSConfig enables TLS on the sessions to replica nodes if it's non-nil.
+	TLSConfig *tls.Config
+
+	// SASL authenticates the sessions to the meta servers and the replica nodes if it's
+	// non-nil, which is required by the Pegasus servers with security enabled.
+	SASL *SASLAuthenticator
+
+	// VerifyServerName decides whether the certificate and the host name of the replica
+	// node are verified when TLS is enabled. Users with self-signed certificates can
+	// disable it. It's true in DefaultPerfClientOptions.
+	VerifyServerName bool
+
+	// MetricExpiryWindow is the number of consecutive cycles after which a metric that
+	// is no longer reported by a partition is removed from the stats. 0 disables the expiry.
+	MetricExpiryWindow int
+
+	// MaxConnections limits the number of sessions opened to replica nodes if it's positive.
+	MaxConnections int
+
+	// TableInfoCacheTTL is how long the tables listed from meta are reused before
+	// they are listed again. 0 disables the cache.
+	TableInfoCacheTTL time.Duration
+
+	// PartitionConfigCacheTTL is how long the partition configurations queried from meta are
+	// reused before they are queried again. 0 disables the cache.
+	PartitionConfigCacheTTL time.Duration
+
+	// NodeDiscoveryFn replaces the meta server as the source of the replica node addresses
+	// if it's non-nil, for example, to use a static list or a service discovery.
+	NodeDiscoveryFn func(ctx context.Context) ([]string, error)
+
+	// ClampNegativeStats sets the negative stats to 0. The negative stats are logged anyway.
+	ClampNegativeStats bool
+
+	// Aggregation is how the aggregator sums the partition stats up into table stats.
+	Aggregation AggregateOptions
+
+	// MetricsBackend is how the metrics are collected from replica nodes.
+	// MetricsBackendPerfCounter is used if it's empty.
+	MetricsBackend MetricsBackend
+
+	// CumulativeCounters are the names of the metrics reported as cumulative totals, which
+	// the aggregator converts into per-second rates before aggregation.
+	CumulativeCounters []string
+
+	// FanOutConcurrency limits the number of concurrent RPCs to the replica nodes or the meta
+	// servers in each fan-out, e.g. GetNodeStats, if it's positive. Otherwise an RPC is sent to
+	// every node or table at once.
+	FanOutConcurrency int
+
+	// NodeTimeout is the timeout of each RPC to a replica node. 5s is used if it's zero.
+	NodeTimeout time.Duration
+
+	// ScrapeTimeout is the overall deadline of GetPartitionStats if it's positive, after which
+	// the nodes that haven't replied are considered failed.
+	ScrapeTimeout time.Duration
+
+	// PrimariesOnly takes the stats of each partition from its primary replica only, which is
+	// resolved from the partition configurations queried from meta. Otherwise the stats are taken
+	// from whichever replica node reports the partition, so the secondaries may be counted
+	// instead of the primary. It's true by default.
+	PrimariesOnly bool
+
+	// CollectSecondaries makes GetPartitionStats return the stats of the secondary replicas as
+	// well, with the Role of RoleSecondary, to compare the load of the primaries and the secondaries.
+	CollectSecondaries bool
+
+	// A replica node is flapping if it transitions between alive and unalive no less than
+	// NodeFlapThreshold times within NodeFlapWindow. 3 times in 10m are used if they're zero.
+	NodeFlapWindow    time.Duration
+	NodeFlapThreshold int
+
+	// ClusterName is the name of the cluster, which the aggregator sets in the stats emitted.
+	ClusterName string
+
+	// Additional is true if the cluster is not the first one collected, in which case the
+	// aggregator passes the stats to the hooks of all clusters only, e.g.
+	// AddHookAfterClusterStatsEmitted, rather than all hooks.
+	Additional bool
+
+	// TableFilter excludes the tables that it returns false for from the collection if it's
+	// non-nil, e.g. the tables not matching "metrics.tables", or those of the other collectors
+	// sharing the cluster. It's called before every collection, so that the excluded tables can
+	// change over time.
+	TableFilter func(table string) bool
+}
+
+// DefaultPerfClientOptions returns the default options of PerfClient.
+func DefaultPerfClientOptions() PerfClientOptions {
+	return PerfClientOptions{
+		VerifyServerName: true,
+		PrimariesOnly:    true,
+	}
+}
+
 // PerfClient manages sessions to all replica nodes.
 type PerfClient struct {
-	meta *session.MetaManager
+	metaLock  sync.RWMutex
+	meta      *session.MetaManager
+	metaAddrs []string
+	// the sessions to retrieve the perf-counters of the meta servers
+	metaNodes map[string]*PerfSession
+
+	// nodesLock guards nodes, which are updated before each collection and may be read
+	// concurrently by the public methods.
+	nodesLock sync.Mutex
+	nodes     map[string]*PerfSession
+
+	opts PerfClientOptions
+
+	// non-nil if the number of sessions is limited
+	pool *SessionPool
+
+	tableCache *TableInfoCache
+
+	configCache *PartitionConfigCache
+
+	alertsLock sync.RWMutex
+	alerts     []*alertRule
+	// tracks the AlertFuncs running in goroutines
+	alertsWg sync.WaitGroup
+
+	durationsLock sync.RWMutex
+	// node address -> the time spent on GetPerfCounters in the last call of GetNodeStats
+	nodeDurations map[string]time.Duration
+	// table name -> the time spent on QueryConfig in the last call of getPartitionConfigs
+	queryConfigDurations map[string]time.Duration
 
-	nodes map[string]*PerfSession
+	configsLock sync.RWMutex
+	// the partition configurations in the last call of getPartitionConfigs
+	lastConfigs map[base.Gpid]*replication.PartitionConfiguration
 }
 
 // GetPartitionStats retrieves all the partition stats from replica nodes.
-func (m *PerfClient) GetPartitionStats() []*PartitionStats {
-	m.updateNodes()
+// NOTE: Only the primaries are counted if PrimariesOnly is set, which is the default. The stats
+// of the secondaries are returned as well if CollectSecondaries is set, marked by RoleSecondary.
+// If some of the nodes or tables fail, the stats collected from the others are returned
+// with a *PartialError.
+func (m *PerfClient) GetPartitionStats(ctx context.Context) ([]*PartitionStats, error) {
+	m.updateNodes(ctx)
+	return m.collectPartitionStats(ctx)
+}
+
+// collectPartitionStats is GetPartitionStats from the current replica nodes, which are not
+// listed again, e.g. after the aggregator updates them by updateNodesWithStatuses.
+func (m *PerfClient) collectPartitionStats(ctx context.Context) ([]*PartitionStats, error) {
+	if m.opts.ScrapeTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, m.opts.ScrapeTimeout)
+		defer cancel()
+	}
+	if m.opts.DryRun {
+		return m.getDryRunPartitionStats(ctx), nil
+	}
+
+	perr := &PartialError{}
+	configs, configErr := m.getPartitionConfigs(ctx)
+	if configErr != nil {
+		perr.merge(configErr)
+	}
+	nodes, err := m.collectNodeStats(ctx, "@")
+	if err != nil {
+		perr.merge(err)
+	}
+	var partitions []*PartitionStats
+	if !m.opts.PrimariesOnly {
+		partitions = decodePartitionStats(nodes, nil)
+	} else if configErr != nil {
+		// fall back to counting the stats from every replica, rather than dropping all of them
+		m.logger().Errorf("unable to get primaries of all tables, count the stats without filtering")
+		partitions = decodePartitionStats(nodes, nil)
+	} else {
+		partitions = decodePartitionStats(nodes, primariesOf(configs))
+	}
+	if m.opts.PartitionConfigCacheTTL > 0 {
+		if stale := staleConfigTables(primariesOf(configs), nodes, m.aliveNodes()); len(stale) != 0 {
+			// the partitions are collected from the cached primaries anyway, and corrected next time
+			m.logger().Infof("the partition configurations of tables %v are out of date", stale)
+			m.configCache.Invalidate(stale...)
+		}
+	}
+	for _, part := range partitions {
+		if cfg, found := configs[part.Gpid]; found {
+			part.getOrInitStats()["replica_count"] = float64(replicaCountOf(cfg))
+		}
+	}
+	if m.opts.CollectSecondaries && configErr == nil {
+		partitions = append(partitions, decodeSecondaryStats(nodes, secondariesOf(configs))...)
+	}
+	m.validatePartitionStats(partitions)
+	return partitions, perr.errOrNil()
+}
+
+// GetPartitionStatsForGpids retrieves the stats of the specified partitions. Only the primaries
+// of these partitions are resolved and only the nodes hosting them are polled.
+// An error is returned if any primary is not an alive node, or `ctx` is done before the
+// nodes reply.
+func (m *PerfClient) GetPartitionStatsForGpids(ctx context.Context, gpids []base.Gpid) ([]*PartitionStats, error) {
+	m.updateNodes(ctx)
+
+	wanted := make(map[base.Gpid]bool)
+	appIDs := make(map[int32]bool)
+	for _, gpid := range gpids {
+		wanted[gpid] = true
+		appIDs[gpid.Appid] = true
+	}
+	listed, err := m.listTables(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var tables []*admin.AppInfo
+	for _, tb := range listed {
+		if appIDs[tb.AppID] {
+			tables = append(tables, tb)
+		}
+	}
+	allPrimaries, err := m.batchQueryConfigs(ctx, tables)
+	if err != nil {
+		return nil, err
+	}
+	primaries := make(map[base.Gpid]string)
+	var sessions []*PerfSession
+	polled := make(map[string]bool)
+	for gpid, addr := range allPrimaries {
+		if !wanted[gpid] {
+			continue
+		}
+		primaries[gpid] = addr
+		if polled[addr] {
+			continue
+		}
+		polled[addr] = true
+		n, found := m.nodeSession(addr)
+		if !found {
+			return nil, fmt.Errorf("primary %s of partition %s is not an alive replica node", addr, gpid.String())
+		}
+		sessions = append(sessions, n)
+	}
+
+	filter := "@"
+	if len(appIDs) == 1 {
+		// only the counters of this table are needed
+		filter = fmt.Sprintf("@%d.", gpids[0].Appid)
+	}
+	nodes, _, err := m.getNodeStats(ctx, sessions, filter, nil)
+	if err != nil {
+		return nil, err
+	}
+	partitions := decodePartitionStats(nodes, primaries)
+	m.validatePartitionStats(partitions)
+	return partitions, nil
+}
 
-	partitions := make(map[base.Gpid]*PartitionStats)
+// validatePartitionStats warns the negative stats, and clamps them if configured.
+func (m *PerfClient) validatePartitionStats(partitions []*PartitionStats) {
+	for _, part := range partitions {
+		errs := ValidateStats(part.Stats)
+		for _, err := range errs {
+			m.logger().WithField("node", part.Addr).Warnf("partition %s on %s: %s", part.Gpid.String(), part.Addr, err)
+			if m.opts.ClampNegativeStats {
+				part.Stats[err.MetricName] = 0
+			}
+		}
+		if len(errs) > 0 && m.opts.ClampNegativeStats {
+			// recompute the derived stats from the clamped values
+			extendStats(&part.Stats)
+			part.Compaction = newCompactionStats(part.Stats)
+		}
+	}
+}
 
-	nodes := m.GetNodeStats("@")
+// decodePartitionStats decodes the partition-level stats from the node stats.
+// A partition's stats are taken only from its primary given in `primaries`. If `primaries`
+// is nil, the stats are taken from whichever node reports the partition.
+func decodePartitionStats(nodes []*NodeStat, primaries map[base.Gpid]string) []*PartitionStats {
+	return decodeReplicaStats(nodes, "", func(gpid base.Gpid, addr string) bool {
+		return primaries == nil || primaries[gpid] == addr
+	})
+}
+
+// decodeSecondaryStats decodes the stats of the secondary replicas given in `secondaries`,
+// one PartitionStats for each replica.
+func decodeSecondaryStats(nodes []*NodeStat, secondaries map[base.Gpid]map[string]bool) []*PartitionStats {
+	return decodeReplicaStats(nodes, RoleSecondary, func(gpid base.Gpid, addr string) bool {
+		return secondaries[gpid][addr]
+	})
+}
+
+// decodeReplicaStats decodes the stats of the replicas accepted by `accept` from the node stats.
+// The stats of a partition reported by multiple nodes are merged, except for the secondaries.
+func decodeReplicaStats(nodes []*NodeStat, role ReplicaRole, accept func(gpid base.Gpid, addr string) bool) []*PartitionStats {
+	type replicaKey struct {
+		gpid base.Gpid
+		addr string
+	}
+	partitions := make(map[replicaKey]*PartitionStats)
+	var ret []*PartitionStats
 	for _, n := range nodes {
 		for name, value := range n.Stats {
 			perfCounter := decodePartitionPerfCounter(name, value)
@@ -33,111 +328,672 @@ func (m *PerfClient) GetPartitionStats() []*PartitionStats {
 			if !aggregatable(perfCounter) {
 				continue
 			}
-			part := partitions[perfCounter.gpid]
+			if !accept(perfCounter.gpid, n.Addr) {
+				// this node doesn't have the wanted replica of this partition
+				continue
+			}
+			key := replicaKey{gpid: perfCounter.gpid}
+			if role == RoleSecondary {
+				key.addr = n.Addr
+			}
+			part := partitions[key]
 			if part == nil {
 				part = &PartitionStats{
-					Gpid:  perfCounter.gpid,
-					Stats: make(map[string]float64),
-					Addr:  n.Addr,
+					Gpid:        perfCounter.gpid,
+					Addr:        n.Addr,
+					CollectedAt: n.CollectedAt,
+					Role:        role,
 				}
-				partitions[perfCounter.gpid] = part
+				partitions[key] = part
+				ret = append(ret, part)
 			}
-			part.Stats[perfCounter.name] = perfCounter.value
+			part.getOrInitStats()[perfCounter.name] = perfCounter.value
 		}
 	}
 
-	var ret []*PartitionStats
-	for _, part := range partitions {
+	for _, part := range ret {
 		extendStats(&part.Stats)
-		ret = append(ret, part)
+		part.Compaction = newCompactionStats(part.Stats)
 	}
 	return ret
 }
 
 // NodeStat contains the stats of a replica node.
 type NodeStat struct {
+	// The name of the cluster, which is set by the aggregator only.
+	Cluster string
+
 	// Address of the replica node.
 	Addr string
 
 	// perfCounter's name -> the value.
 	Stats map[string]float64
+
+	// the time when the stats were retrieved
+	CollectedAt time.Time
 }
 
 // GetNodeStats retrieves all the stats matched with `filter` from replica nodes.
-func (m *PerfClient) GetNodeStats(filter string) []*NodeStat {
-	m.updateNodes()
+// If some of the nodes fail, the stats of the others are returned with a *PartialError.
+func (m *PerfClient) GetNodeStats(ctx context.Context, filter string) ([]*NodeStat, error) {
+	m.updateNodes(ctx)
+	return m.collectNodeStats(ctx, filter)
+}
 
-	var ret []*NodeStat
-	for _, n := range m.nodes {
+// collectNodeStats is GetNodeStats from the current replica nodes, which are not listed again.
+func (m *PerfClient) collectNodeStats(ctx context.Context, filter string) ([]*NodeStat, error) {
+	ret, durations, err := m.getNodeStats(ctx, m.nodeSessions(), filter, nil)
+	observeNodeScrapes(m.opts.ClusterName, durations, err)
+	m.durationsLock.Lock()
+	m.nodeDurations = durations
+	m.durationsLock.Unlock()
+	return ret, err
+}
+
+// getNodeStats retrieves the stats matched with `filter` from the given nodes concurrently,
+// and returns the time spent on each node as well. Each node is given at most NodeTimeout
+// within the deadline of `ctx`. The stats of the nodes that succeed are returned even if
+// the others fail, whose errors are returned in a *PartialError.
+// Each call writes to its own slot of the results, so no lock is required. The stats of every
+// node are summed into `totals` as well if it's not nil, as soon as the node responds.
+func (m *PerfClient) getNodeStats(ctx context.Context, sessions []*PerfSession, filter string, totals *StatsAccumulator) ([]*NodeStat, map[string]time.Duration, error) {
+	results := make([]*NodeStat, len(sessions))
+	errs := make([]error, len(sessions))
+	elapsed := make([]time.Duration, len(sessions))
+
+	fanOut(len(sessions), m.opts.FanOutConcurrency, func(i int) {
+		n := sessions[i]
 		stat := &NodeStat{
 			Addr:  n.Address,
 			Stats: make(map[string]float64),
 		}
-		perfCounters, err := n.GetPerfCounters(filter)
+		if m.opts.DryRun {
+			m.logger().WithField("node", n.Address).Infof("would call GetPerfCounters on [%s]", n.Address)
+			results[i] = stat
+			return
+		}
+		ctx, cancel := context.WithTimeout(ctx, m.nodeTimeout())
+		defer cancel()
+		start := time.Now()
+		perfCounters, err := n.GetPerfCounters(ctx, filter)
+		elapsed[i] = time.Since(start)
 		if err != nil {
-			log.Errorf("unable to query perf-counters: %s", err)
-			return nil
+			errs[i] = err
+			return
 		}
 		for _, p := range perfCounters {
 			stat.Stats[p.Name] = p.Value
+			if totals != nil {
+				totals.Add(p.Name, p.Value)
+			}
+		}
+		stat.CollectedAt = time.Now()
+		results[i] = stat
+	})
+
+	durations := make(map[string]time.Duration)
+	var stats []*NodeStat
+	perr := &PartialError{}
+	for i, n := range sessions {
+		if !m.opts.DryRun {
+			durations[n.Address] = elapsed[i]
 		}
-		ret = append(ret, stat)
+		if errs[i] != nil {
+			perr.addNode(n.Address, errs[i])
+			continue
+		}
+		stats = append(stats, results[i])
 	}
-	return ret
+	return stats, durations, perr.errOrNil()
 }
 
-func (m *PerfClient) listNodes() []*admin.NodeInfo {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+// pingFilter matches no perf-counter, so that a ping replies with an empty list rather than
+// all the perf-counters of the node.
+const pingFilter = "collector.ping"
+
+// PingAllNodes checks the connectivity to all replica nodes concurrently. It returns
+// the mapping of [node address -> error], where a nil error means the node is reachable.
+func (m *PerfClient) PingAllNodes(ctx context.Context) map[string]error {
+	m.updateNodes(ctx)
+
+	sessions := m.nodeSessions()
+	result := make(map[string]error)
+	var mu sync.Mutex
+	fanOut(len(sessions), m.opts.FanOutConcurrency, func(i int) {
+		n := sessions[i]
+		_, err := n.GetPerfCounters(ctx, pingFilter)
+
+		mu.Lock()
+		result[n.Address] = err
+		mu.Unlock()
+	})
+	return result
+}
+
+// nodeTimeout returns the timeout of each RPC to a replica node.
+func (m *PerfClient) nodeTimeout() time.Duration {
+	if m.opts.NodeTimeout > 0 {
+		return m.opts.NodeTimeout
+	}
+	return 5 * time.Second
+}
+
+func (m *PerfClient) lastNodeDurations() map[string]time.Duration {
+	m.durationsLock.RLock()
+	defer m.durationsLock.RUnlock()
+	return m.nodeDurations
+}
+
+func (m *PerfClient) lastQueryConfigDurations() map[string]time.Duration {
+	m.durationsLock.RLock()
+	defer m.durationsLock.RUnlock()
+	return m.queryConfigDurations
+}
+
+// getPartitionConfigs returns the partition configurations of all tables. If some of the tables
+// fail, the configurations of the others are returned with a *PartialError.
+func (m *PerfClient) getPartitionConfigs(ctx context.Context) (map[base.Gpid]*replication.PartitionConfiguration, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*10)
 	defer cancel()
-	resp, err := m.meta.ListNodes(ctx, &admin.ListNodesRequest{
-		Status: admin.NodeStatus_NS_ALIVE,
+	tables, err := m.listCollectedTables(ctx)
+	if err != nil {
+		return nil, err
+	}
+	m.configCache.Retain(tables)
+	configs, durations, err := m.queryPartitionConfigs(ctx, tables)
+	m.durationsLock.Lock()
+	m.queryConfigDurations = durations
+	m.durationsLock.Unlock()
+	m.configsLock.Lock()
+	m.lastConfigs = configs
+	m.configsLock.Unlock()
+	return configs, err
+}
+
+// lastPartitionConfigs returns the partition configurations in the last collection.
+func (m *PerfClient) lastPartitionConfigs() map[base.Gpid]*replication.PartitionConfiguration {
+	m.configsLock.RLock()
+	defer m.configsLock.RUnlock()
+	return m.lastConfigs
+}
+
+// batchQueryConfigs queries the partition configurations of the given tables from meta,
+// and returns the mapping of [partition -> primary address].
+// The partitions that have no primary currently are absent from the result.
+func (m *PerfClient) batchQueryConfigs(ctx context.Context, tables []*admin.AppInfo) (map[base.Gpid]string, error) {
+	configs, _, err := m.queryPartitionConfigs(ctx, tables)
+	return primariesOf(configs), err
+}
+
+// queryPartitionConfigs queries the partition configurations of the given tables from meta.
+//
+// The tables are deduplicated before querying, so that each table is queried only once
+// even if it appears multiple times in `tables`. Two entries are considered as the same
+// table if they have the same AppID, or the same AppName, since QueryConfig is issued by name.
+// The queries are sent concurrently, one RPC per distinct table, limited by FanOutConcurrency.
+// The tables whose configurations are cached are not queried, and have no duration. The time spent on each table
+// is returned as well. The configurations of the tables that succeed are returned even if
+// the others fail, whose errors are returned in a *PartialError.
+func (m *PerfClient) queryPartitionConfigs(ctx context.Context, tables []*admin.AppInfo) (map[base.Gpid]*replication.PartitionConfiguration, map[string]time.Duration, error) {
+	seenIDs := make(map[int32]bool)
+	seenNames := make(map[string]bool)
+	var distinct []*admin.AppInfo
+	for _, tb := range tables {
+		if seenIDs[tb.AppID] || seenNames[tb.AppName] {
+			continue
+		}
+		seenIDs[tb.AppID] = true
+		seenNames[tb.AppName] = true
+		distinct = append(distinct, tb)
+	}
+
+	result := make(map[base.Gpid]*replication.PartitionConfiguration)
+	durations := make(map[string]time.Duration)
+	perr := &PartialError{}
+	var mu sync.Mutex
+	var queried []*admin.AppInfo
+	for _, tb := range distinct {
+		cached, ok := m.configCache.Get(tb)
+		if !ok {
+			queried = append(queried, tb)
+			continue
+		}
+		for _, p := range cached {
+			result[*p.Pid] = p
+		}
+	}
+	fanOut(len(queried), m.opts.FanOutConcurrency, func(i int) {
+		tb := queried[i]
+		start := time.Now()
+		resp, err := m.metaManager().QueryConfig(ctx, tb.AppName)
+
+		mu.Lock()
+		defer mu.Unlock()
+		durations[tb.AppName] = time.Since(start)
+		if err != nil {
+			perr.addTable(tb.AppName, err)
+			return
+		}
+		m.configCache.Set(tb, resp.Partitions)
+		for _, p := range resp.Partitions {
+			result[*p.Pid] = p
+		}
 	})
+	return result, durations, perr.errOrNil()
+}
+
+// primariesOf returns the mapping of [partition -> primary address] from the configurations.
+func primariesOf(configs map[base.Gpid]*replication.PartitionConfiguration) map[base.Gpid]string {
+	result := make(map[base.Gpid]string)
+	for gpid, p := range configs {
+		if p.Primary == nil || p.Primary.GetRawAddress() == 0 {
+			continue
+		}
+		result[gpid] = p.Primary.GetAddress()
+	}
+	return result
+}
+
+// secondariesOf returns the mapping of [partition -> the set of secondary addresses] from the configurations.
+func secondariesOf(configs map[base.Gpid]*replication.PartitionConfiguration) map[base.Gpid]map[string]bool {
+	result := make(map[base.Gpid]map[string]bool)
+	for gpid, p := range configs {
+		for _, secondary := range p.Secondaries {
+			if secondary == nil || secondary.GetRawAddress() == 0 {
+				continue
+			}
+			if result[gpid] == nil {
+				result[gpid] = make(map[string]bool)
+			}
+			result[gpid][secondary.GetAddress()] = true
+		}
+	}
+	return result
+}
+
+// replicaCountOf returns the number of alive replicas, including the primary and the secondaries.
+func replicaCountOf(p *replication.PartitionConfiguration) int {
+	count := len(p.Secondaries)
+	if p.Primary != nil && p.Primary.GetRawAddress() != 0 {
+		count++
+	}
+	return count
+}
+
+func (m *PerfClient) listNodes(ctx context.Context) []*admin.NodeInfo {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
+	defer cancel()
+	nodes, err := m.listNodesWithStatus(ctx, admin.NodeStatus_NS_ALIVE)
 	if err != nil {
-		log.Error(err)
+		m.logger().Error(err)
 		return nil
 	}
-	return resp.Infos
+	return nodes
+}
+
+// MetaHealth returns nil if at least one of the meta servers responds in time.
+func (m *PerfClient) MetaHealth(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	_, err := m.metaManager().ListNodes(ctx, &admin.ListNodesRequest{
+		Status: admin.NodeStatus_NS_ALIVE,
+	})
+	if err != nil {
+		return fmt.Errorf("meta servers are unreachable: %s", err)
+	}
+	return nil
+}
+
+// ListAliveNodes returns the nodes that meta server considers alive.
+func (m *PerfClient) ListAliveNodes(ctx context.Context) ([]*admin.NodeInfo, error) {
+	return m.listNodesWithStatus(ctx, admin.NodeStatus_NS_ALIVE)
+}
+
+// ListDeadNodes returns the nodes that meta server considers unalive.
+func (m *PerfClient) ListDeadNodes(ctx context.Context) ([]*admin.NodeInfo, error) {
+	return m.listNodesWithStatus(ctx, admin.NodeStatus_NS_UNALIVE)
+}
+
+// ListUnknownNodes returns the nodes whose status is invalid, for example,
+// the nodes that were recently decommissioned.
+func (m *PerfClient) ListUnknownNodes(ctx context.Context) ([]*admin.NodeInfo, error) {
+	return m.listNodesWithStatus(ctx, admin.NodeStatus_NS_INVALID)
+}
+
+// listNodesWithStatus returns the nodes in the given status.
+func (m *PerfClient) listNodesWithStatus(ctx context.Context, status admin.NodeStatus) ([]*admin.NodeInfo, error) {
+	resp, err := m.metaManager().ListNodes(ctx, &admin.ListNodesRequest{
+		Status: status,
+	})
+	if err != nil {
+		return nil, err
+	}
+	// NS_INVALID lists all nodes on meta server, so the result is filtered anyway.
+	var nodes []*admin.NodeInfo
+	for _, n := range resp.Infos {
+		if n.Status == status {
+			nodes = append(nodes, n)
+		}
+	}
+	return nodes, nil
 }
 
-func (m *PerfClient) listTables() []*admin.AppInfo {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+// InvalidateTableCache forces the tables to be listed from meta on the next call.
+func (m *PerfClient) InvalidateTableCache() {
+	m.tableCache.Invalidate()
+}
+
+// GetTableInfoMap returns the available tables indexed by name. The result is cached
+// in the same way as the listed tables.
+func (m *PerfClient) GetTableInfoMap(ctx context.Context) (map[string]*admin.AppInfo, error) {
+	if byName, ok := m.tableCache.GetByName(); ok {
+		return byName, nil
+	}
+	tables, err := m.queryTables(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return indexTablesByName(tables), nil
+}
+
+func (m *PerfClient) listTables(ctx context.Context) ([]*admin.AppInfo, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
 	defer cancel()
-	resp, err := m.meta.ListApps(ctx, &admin.ListAppsRequest{
+	tables, err := m.queryTables(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tables: %s", err)
+	}
+	return tables, nil
+}
+
+// listCollectedTables lists the available tables that pass the TableFilter.
+func (m *PerfClient) listCollectedTables(ctx context.Context) ([]*admin.AppInfo, error) {
+	tables, err := m.listTables(ctx)
+	if err != nil || m.opts.TableFilter == nil {
+		return tables, err
+	}
+	collected := []*admin.AppInfo{}
+	for _, tb := range tables {
+		if m.opts.TableFilter(tb.AppName) {
+			collected = append(collected, tb)
+		}
+	}
+	return collected, nil
+}
+
+// queryTables lists the available tables from meta, unless they are cached.
+func (m *PerfClient) queryTables(ctx context.Context) ([]*admin.AppInfo, error) {
+	if tables, ok := m.tableCache.Get(); ok {
+		return tables, nil
+	}
+	resp, err := m.metaManager().ListApps(ctx, &admin.ListAppsRequest{
 		Status: admin.AppStatus_AS_AVAILABLE,
 	})
 	if err != nil {
-		log.Error(err)
-		return nil
+		return nil, err
 	}
-	return resp.Infos
+	m.tableCache.Set(resp.Infos)
+	return resp.Infos, nil
 }
 
-func (m *PerfClient) updateNodes() {
-	nodeInfos := m.listNodes()
+func (m *PerfClient) updateNodes(ctx context.Context) {
+	if m.opts.NodeDiscoveryFn != nil {
+		ctx, cancel := context.WithTimeout(ctx, time.Second*5)
+		defer cancel()
+		addrs, err := m.opts.NodeDiscoveryFn(ctx)
+		if err != nil {
+			// keep the sessions to the previously discovered nodes
+			m.logger().Errorf("failed to discover replica nodes: %s", err)
+			return
+		}
+		m.doUpdateNodes(addrs)
+		return
+	}
+
+	nodeInfos := m.listNodes(ctx)
 
-	newNodes := make(map[string]*PerfSession)
+	var addrs []string
 	for _, n := range nodeInfos {
-		addr := n.Address.GetAddress()
-		node, found := m.nodes[addr]
-		if !found {
-			newNodes[addr] = NewPerfSession(addr)
-		} else {
-			newNodes[addr] = node
+		addrs = append(addrs, n.Address.GetAddress())
+	}
+	m.doUpdateNodes(addrs)
+}
+
+// updateNodesWithStatuses is updateNodes with the statuses of the nodes already listed from meta
+// in this round, which saves listing them again. The nodes from NodeDiscoveryFn are used anyway.
+func (m *PerfClient) updateNodesWithStatuses(ctx context.Context, statuses map[string]admin.NodeStatus) {
+	if m.opts.NodeDiscoveryFn != nil {
+		m.updateNodes(ctx)
+		return
+	}
+	var addrs []string
+	for addr, status := range statuses {
+		if status == admin.NodeStatus_NS_ALIVE {
+			addrs = append(addrs, addr)
+		}
+	}
+	m.doUpdateNodes(addrs)
+}
+
+// doUpdateNodes only adds the sessions to the newly joined nodes and closes the
+// sessions to the disappeared nodes. The sessions to unchanged nodes are kept in place.
+func (m *PerfClient) doUpdateNodes(addrs []string) {
+	m.nodesLock.Lock()
+	defer m.nodesLock.Unlock()
+
+	currentNodeSet := make(map[string]*struct{}, len(addrs))
+	for _, addr := range addrs {
+		currentNodeSet[addr] = nil
+		if _, found := m.nodes[addr]; !found {
+			m.nodes[addr] = m.newPerfSession(addr)
 		}
 	}
-	for n, client := range m.nodes {
+	for addr, client := range m.nodes {
 		// close the unused connections
-		if _, found := newNodes[n]; !found {
+		if _, found := currentNodeSet[addr]; !found {
 			client.Close()
+			delete(m.nodes, addr)
+		}
+	}
+}
+
+// nodeSessions returns the sessions to the current replica nodes.
+func (m *PerfClient) nodeSessions() []*PerfSession {
+	m.nodesLock.Lock()
+	defer m.nodesLock.Unlock()
+
+	sessions := make([]*PerfSession, 0, len(m.nodes))
+	for _, n := range m.nodes {
+		sessions = append(sessions, n)
+	}
+	return sessions
+}
+
+// aliveNodes returns the set of the addresses of the replica nodes.
+func (m *PerfClient) aliveNodes() map[string]bool {
+	m.nodesLock.Lock()
+	defer m.nodesLock.Unlock()
+
+	res := make(map[string]bool, len(m.nodes))
+	for addr := range m.nodes {
+		res[addr] = true
+	}
+	return res
+}
+
+// nodeSession returns the session to the replica node at `addr`.
+func (m *PerfClient) nodeSession(addr string) (*PerfSession, bool) {
+	m.nodesLock.Lock()
+	defer m.nodesLock.Unlock()
+	n, found := m.nodes[addr]
+	return n, found
+}
+
+func (m *PerfClient) newPerfSession(addr string) *PerfSession {
+	if m.pool != nil {
+		return &PerfSession{
+			remoteCmdCaller: &pooledCmdCaller{pool: m.pool, addr: addr},
+			Address:         addr,
+		}
+	}
+	return m.dialPerfSession(addr)
+}
+
+func (m *PerfClient) dialPerfSession(addr string) *PerfSession {
+	cfg := m.opts.TLSConfig
+	if cfg != nil && !m.opts.VerifyServerName {
+		cfg = cfg.Clone()
+		cfg.InsecureSkipVerify = true
+	}
+	if m.opts.MetricsBackend == MetricsBackendHTTP {
+		return &PerfSession{remoteCmdCaller: newHTTPMetricsCmdCaller(addr, cfg), Address: addr}
+	}
+
+	var s *PerfSession
+	if cfg == nil && m.opts.SASL == nil {
+		s = NewPerfSession(addr)
+	} else {
+		s = NewSASLPerfSession(addr, cfg, m.opts.SASL)
+	}
+	if m.opts.MetricsBackend == MetricsBackendAuto {
+		s.remoteCmdCaller = &autoDetectCmdCaller{
+			addr:   addr,
+			http:   newHTTPMetricsCmdCaller(addr, cfg),
+			legacy: s.remoteCmdCaller,
+		}
+	}
+	return s
+}
+
+// getDryRunPartitionStats returns zero-value stats for every partition of the
+// available tables. The partitions are assigned to the alive nodes in turn.
+func (m *PerfClient) getDryRunPartitionStats(ctx context.Context) []*PartitionStats {
+	// the nodes are not queried in dry-run mode, so there's no error
+	nodes, _ := m.collectNodeStats(ctx, "@")
+	if len(nodes) == 0 {
+		return nil
+	}
+
+	tables, err := m.listCollectedTables(ctx)
+	if err != nil {
+		m.logger().Error(err)
+		return nil
+	}
+	var ret []*PartitionStats
+	i := 0
+	for _, tb := range tables {
+		for p := 0; p < int(tb.PartitionCount); p++ {
+			part := &PartitionStats{
+				Gpid:        base.Gpid{Appid: tb.AppID, PartitionIndex: int32(p)},
+				Addr:        nodes[i%len(nodes)].Addr,
+				CollectedAt: time.Now(),
+			}
+			for _, name := range AllMetrics() {
+				part.getOrInitStats()[name] = 0
+			}
+			ret = append(ret, part)
+			i++
 		}
 	}
-	m.nodes = newNodes
+	return ret
+}
+
+// logger returns the logger tagged with the cluster.
+func (m *PerfClient) logger() *logrus.Entry {
+	return log.WithField("cluster", m.opts.ClusterName)
 }
 
 // NewPerfClient returns an instance of PerfClient.
 func NewPerfClient(metaAddrs []string) *PerfClient {
-	return &PerfClient{
-		meta:  session.NewMetaManager(metaAddrs, session.NewNodeSession),
-		nodes: make(map[string]*PerfSession),
+	return NewPerfClientWithOptions(metaAddrs, DefaultPerfClientOptions())
+}
+
+// NodeSessionStats returns the load that the collector has generated on each replica node,
+// indexed by the node address.
+func (m *PerfClient) NodeSessionStats() map[string]*SessionStats {
+	sessions := m.nodeSessions()
+	stats := make(map[string]*SessionStats, len(sessions))
+	for _, n := range sessions {
+		stats[n.Address] = n.Stats()
+	}
+	return stats
+}
+
+// SetMetaAddrs replaces the meta servers at runtime, e.g. for a meta server migration.
+// The RPCs in flight to the previous meta servers may fail.
+func (m *PerfClient) SetMetaAddrs(addrs []string) error {
+	if len(addrs) == 0 {
+		return errors.New("no meta server is given")
+	}
+	meta := session.NewMetaManager(addrs, m.newNodeSession)
+
+	m.metaLock.Lock()
+	prev, prevNodes := m.meta, m.metaNodes
+	m.meta, m.metaAddrs, m.metaNodes = meta, addrs, nil
+	m.metaLock.Unlock()
+	for _, n := range prevNodes {
+		n.Close()
+	}
+	// the cached tables belong to the previous cluster
+	m.tableCache.Invalidate()
+	m.configCache.InvalidateAll()
+
+	m.logger().Infof("meta servers are changed to %s", addrs)
+	return prev.Close()
+}
+
+func (m *PerfClient) metaManager() *session.MetaManager {
+	m.metaLock.RLock()
+	defer m.metaLock.RUnlock()
+	return m.meta
+}
+
+// Close terminates the sessions to meta and all replica nodes, after the running
+// AlertFuncs return.
+func (m *PerfClient) Close() {
+	m.alertsWg.Wait()
+	m.nodesLock.Lock()
+	for addr, n := range m.nodes {
+		n.Close()
+		delete(m.nodes, addr)
+	}
+	m.nodesLock.Unlock()
+	m.metaLock.Lock()
+	for addr, n := range m.metaNodes {
+		n.Close()
+		delete(m.metaNodes, addr)
+	}
+	m.metaLock.Unlock()
+	if err := m.metaManager().Close(); err != nil {
+		m.logger().Error(err)
+	}
+}
+
+// NewPerfClientWithOptions returns an instance of PerfClient configured with `opts`.
+func NewPerfClientWithOptions(metaAddrs []string, opts PerfClientOptions) *PerfClient {
+	m := &PerfClient{
+		metaAddrs:  metaAddrs,
+		nodes:      make(map[string]*PerfSession),
+		opts:       opts,
+		tableCache: NewTableInfoCache(opts.TableInfoCacheTTL),
+
+		configCache: NewPartitionConfigCache(opts.PartitionConfigCacheTTL),
+	}
+	m.meta = session.NewMetaManager(metaAddrs, m.newNodeSession)
+	if opts.MaxConnections > 0 {
+		m.pool = NewSessionPool(opts.MaxConnections, m.dialPerfSession)
+	}
+	return m
+}
+
+// newNodeSession returns a session of pegasus-go-client to the server, or the one authenticated
+// by SASL if it's enabled, whose failed RPCs are counted.
+func (m *PerfClient) newNodeSession(addr string, ntype session.NodeType) session.NodeSession {
+	var s session.NodeSession
+	if m.opts.SASL != nil {
+		s = newConnSession(addr, ntype, nil, m.opts.SASL)
+	} else {
+		s = session.NewNodeSession(addr, ntype)
 	}
+	return &countingSession{NodeSession: s, cluster: m.opts.ClusterName, addr: addr, ntype: ntype}
 }
@@ -1,15 +1,24 @@
 package aggregate
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/XiaoMi/pegasus-go-client/idl/admin"
 	"github.com/XiaoMi/pegasus-go-client/idl/base"
+	"github.com/spf13/viper"
 	"github.com/stretchr/testify/assert"
 )
 
 func TestPerfClientGetNodeStats(t *testing.T) {
 	pclient := NewPerfClient([]string{"127.0.0.1:34601"})
-	nodes := pclient.GetNodeStats("@")
+	defer pclient.Close()
+	nodes, err := pclient.GetNodeStats(context.Background(), "@")
+	assert.Nil(t, err)
 	assert.Greater(t, len(nodes), 0)
 	assert.Greater(t, len(nodes[0].Stats), 0)
 	for _, n := range nodes {
@@ -19,7 +28,9 @@ func TestPerfClientGetNodeStats(t *testing.T) {
 
 func TestPerfClientGetPartitionStats(t *testing.T) {
 	pclient := NewPerfClient([]string{"127.0.0.1:34601"})
-	partitions := pclient.GetPartitionStats()
+	defer pclient.Close()
+	partitions, err := pclient.GetPartitionStats(context.Background())
+	assert.Nil(t, err)
 	assert.Greater(t, len(partitions), 0)
 	assert.Greater(t, len(partitions[0].Stats), 0)
 	for _, p := range partitions {
@@ -28,3 +39,365 @@ func TestPerfClientGetPartitionStats(t *testing.T) {
 		assert.NotEmpty(t, p.Stats)
 	}
 }
+
+func TestPerfClientDryRun(t *testing.T) {
+	pclient := NewPerfClientWithOptions([]string{"127.0.0.1:34601"}, PerfClientOptions{DryRun: true})
+	defer pclient.Close()
+	partitions, err := pclient.GetPartitionStats(context.Background())
+	assert.Nil(t, err)
+	assert.Greater(t, len(partitions), 0)
+	for _, p := range partitions {
+		assert.NotEmpty(t, p.Addr)
+		for _, v := range p.Stats {
+			assert.Equal(t, v, float64(0))
+		}
+	}
+}
+
+func TestPerfClientBatchQueryConfigs(t *testing.T) {
+	pclient := NewPerfClient([]string{"127.0.0.1:34601"})
+	defer pclient.Close()
+	tables, err := pclient.listTables(context.Background())
+	assert.Nil(t, err)
+	totalPartitions := 0
+	for _, tb := range tables {
+		totalPartitions += int(tb.PartitionCount)
+	}
+
+	// duplicated tables are queried only once
+	primaries, err := pclient.batchQueryConfigs(context.Background(), append(tables, tables...))
+	assert.Nil(t, err)
+	assert.Equal(t, len(primaries), totalPartitions)
+	for _, addr := range primaries {
+		assert.NotEmpty(t, addr)
+	}
+}
+
+func TestPerfClientUpdateNodes(t *testing.T) {
+	pclient := NewPerfClient([]string{"127.0.0.1:34601"})
+	defer pclient.Close()
+	pclient.doUpdateNodes([]string{"127.0.0.1:34801", "127.0.0.1:34802"})
+	assert.Equal(t, len(pclient.nodes), 2)
+	unchanged := pclient.nodes["127.0.0.1:34802"]
+
+	pclient.doUpdateNodes([]string{"127.0.0.1:34802", "127.0.0.1:34803"})
+	assert.Equal(t, len(pclient.nodes), 2)
+	assert.NotContains(t, pclient.nodes, "127.0.0.1:34801")
+	assert.Contains(t, pclient.nodes, "127.0.0.1:34803")
+	assert.Same(t, pclient.nodes["127.0.0.1:34802"], unchanged)
+}
+
+func TestPerfClientNodeDiscoveryFn(t *testing.T) {
+	var discoveryErr error
+	opts := DefaultPerfClientOptions()
+	opts.NodeDiscoveryFn = func(ctx context.Context) ([]string, error) {
+		return []string{"127.0.0.1:34801", "127.0.0.1:34802"}, discoveryErr
+	}
+	pclient := NewPerfClientWithOptions([]string{"127.0.0.1:34601"}, opts)
+	defer pclient.Close()
+	pclient.updateNodes(context.Background())
+	assert.Equal(t, len(pclient.nodes), 2)
+	assert.Contains(t, pclient.nodes, "127.0.0.1:34801")
+
+	// the nodes are kept if the discovery fails
+	discoveryErr = errors.New("discovery failure")
+	pclient.doUpdateNodes([]string{"127.0.0.1:34803"})
+	pclient.updateNodes(context.Background())
+	assert.Equal(t, len(pclient.nodes), 1)
+	assert.Contains(t, pclient.nodes, "127.0.0.1:34803")
+}
+
+func TestPerfClientUpdateNodesWithContext(t *testing.T) {
+	opts := DefaultPerfClientOptions()
+	opts.NodeDiscoveryFn = func(ctx context.Context) ([]string, error) {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		return []string{"127.0.0.1:34801"}, nil
+	}
+	pclient := NewPerfClientWithOptions([]string{"127.0.0.1:34601"}, opts)
+	defer pclient.Close()
+
+	// the discovery is cancelled by the caller
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	pclient.updateNodes(ctx)
+	assert.Equal(t, len(pclient.nodes), 0)
+
+	pclient.updateNodes(context.Background())
+	assert.Equal(t, len(pclient.nodes), 1)
+}
+
+func TestPerfClientGetPartitionStatsForGpids(t *testing.T) {
+	pclient := NewPerfClient([]string{"127.0.0.1:34601"})
+	defer pclient.Close()
+	gpids := []base.Gpid{{Appid: 1, PartitionIndex: 0}, {Appid: 1, PartitionIndex: 2}}
+	partitions, err := pclient.GetPartitionStatsForGpids(context.Background(), gpids)
+	assert.Nil(t, err)
+	assert.Equal(t, len(partitions), 2)
+	for _, p := range partitions {
+		assert.Contains(t, gpids, p.Gpid)
+		assert.NotEmpty(t, p.Addr)
+		assert.NotEmpty(t, p.Stats)
+	}
+}
+
+func TestPerfClientPingAllNodes(t *testing.T) {
+	pclient := NewPerfClient([]string{"127.0.0.1:34601"})
+	defer pclient.Close()
+	result := pclient.PingAllNodes(context.Background())
+	assert.Greater(t, len(result), 0)
+	for addr, err := range result {
+		assert.Nil(t, err, addr)
+	}
+}
+
+// filterRecorder replies an empty list, and records the filters of the perf-counters.
+type filterRecorder struct {
+	lock    sync.Mutex
+	filters []string
+}
+
+func (r *filterRecorder) Call(ctx context.Context, command string, arguments []string) (string, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.filters = append(r.filters, arguments...)
+	return `{"counters":[]}`, nil
+}
+
+func TestPingAllNodesFilter(t *testing.T) {
+	opts := DefaultPerfClientOptions()
+	opts.NodeDiscoveryFn = func(ctx context.Context) ([]string, error) {
+		return []string{"127.0.0.1:34801"}, nil
+	}
+	pclient := NewPerfClientWithOptions([]string{"127.0.0.1:34601"}, opts)
+	defer pclient.Close()
+	recorder := &filterRecorder{}
+	pclient.nodes["127.0.0.1:34801"] = &PerfSession{remoteCmdCaller: recorder, Address: "127.0.0.1:34801"}
+
+	result := pclient.PingAllNodes(context.Background())
+	assert.Equal(t, result, map[string]error{"127.0.0.1:34801": nil})
+	// not all the perf-counters are retrieved
+	assert.Equal(t, recorder.filters, []string{pingFilter})
+}
+
+func TestPerfClientListDeadNodes(t *testing.T) {
+	pclient := NewPerfClient([]string{"127.0.0.1:34601"})
+	defer pclient.Close()
+	nodes, err := pclient.ListDeadNodes(context.Background())
+	assert.Nil(t, err)
+	for _, n := range nodes {
+		assert.Equal(t, n.Status, admin.NodeStatus_NS_UNALIVE)
+	}
+
+	nodes, err = pclient.ListUnknownNodes(context.Background())
+	assert.Nil(t, err)
+	for _, n := range nodes {
+		assert.Equal(t, n.Status, admin.NodeStatus_NS_INVALID)
+	}
+}
+
+func TestPerfClientGetTableInfoMap(t *testing.T) {
+	pclient := NewPerfClient([]string{"127.0.0.1:34601"})
+	defer pclient.Close()
+	tables, err := pclient.listTables(context.Background())
+	assert.Nil(t, err)
+	byName, err := pclient.GetTableInfoMap(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, len(byName), len(tables))
+	for _, tb := range tables {
+		assert.Equal(t, byName[tb.AppName].AppID, tb.AppID)
+	}
+}
+
+func TestPerfClientMetaHealth(t *testing.T) {
+	pclient := NewPerfClient([]string{"127.0.0.1:34601"})
+	defer pclient.Close()
+	assert.Nil(t, pclient.MetaHealth(context.Background()))
+
+	unreachable := NewPerfClient([]string{"127.0.0.1:34600"})
+	defer unreachable.Close()
+	assert.NotNil(t, unreachable.MetaHealth(context.Background()))
+}
+
+func TestPerfClientGetClusterCapacityStats(t *testing.T) {
+	pclient := NewPerfClient([]string{"127.0.0.1:34601"})
+	defer pclient.Close()
+	capacity, err := pclient.GetClusterCapacityStats(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, capacity.TotalPartitions, 12) // test(4) + stat(8)
+	assert.Equal(t, capacity.TotalReplicas, 3*capacity.TotalPartitions)
+	assert.Greater(t, capacity.NodeCount, 0)
+	assert.Greater(t, capacity.TotalDiskTotalBytes, capacity.TotalDiskUsedBytes)
+}
+
+func TestPerfClientMetaLeader(t *testing.T) {
+	pclient := NewPerfClient([]string{"127.0.0.1:34601", "127.0.0.1:34602"})
+	defer pclient.Close()
+	leader, err := pclient.MetaLeader(context.Background())
+	assert.Nil(t, err)
+	assert.Contains(t, []string{"127.0.0.1:34601", "127.0.0.1:34602"}, leader)
+}
+
+func TestPerfClientSetMetaAddrs(t *testing.T) {
+	pclient := NewPerfClient([]string{"127.0.0.1:34600"})
+	defer pclient.Close()
+	assert.NotNil(t, pclient.SetMetaAddrs(nil))
+
+	pclient.tableCache.Set([]*admin.AppInfo{{AppName: "temp"}})
+	assert.Nil(t, pclient.SetMetaAddrs([]string{"127.0.0.1:34601"}))
+	_, ok := pclient.tableCache.Get()
+	assert.False(t, ok)
+
+	assert.Nil(t, pclient.SetMetaAddrs([]string{"127.0.0.1:34601", "127.0.0.1:34602"}))
+	assert.Nil(t, pclient.MetaHealth(context.Background()))
+}
+
+func TestPerfClientGetNodeStatsWithContext(t *testing.T) {
+	result := `{"counters":[{"name":"replica*app.pegasus*get_qps@1.0","value":10}]}`
+	s := &PerfSession{remoteCmdCaller: &fakeCmdCaller{result: result}, Address: "127.0.0.1:34801"}
+	pclient := &PerfClient{}
+
+	nodes, durations, err := pclient.getNodeStats(context.Background(), []*PerfSession{s}, "@", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, nodes[0].Stats, map[string]float64{"replica*app.pegasus*get_qps@1.0": 10})
+	assert.Contains(t, durations, s.Address)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, _, err = pclient.getNodeStats(ctx, []*PerfSession{s}, "@", nil)
+	assert.Equal(t, err, &PartialError{Nodes: map[string]error{s.Address: context.Canceled}})
+
+	// the collection diagnostics are only updated by GetNodeStats
+	assert.Nil(t, pclient.lastNodeDurations())
+}
+
+func TestPerfClientGetNodeStatsPartially(t *testing.T) {
+	result := `{"counters":[{"name":"replica*app.pegasus*get_qps@1.0","value":10}]}`
+	alive := &PerfSession{remoteCmdCaller: &fakeCmdCaller{result: result}, Address: "127.0.0.1:34801"}
+	restarting := &PerfSession{remoteCmdCaller: &fakeCmdCaller{err: errors.New("connection refused")}, Address: "127.0.0.1:34802"}
+	pclient := &PerfClient{}
+
+	nodes, durations, err := pclient.getNodeStats(context.Background(), []*PerfSession{alive, restarting}, "@", nil)
+	assert.Equal(t, len(nodes), 1)
+	assert.Equal(t, nodes[0].Addr, alive.Address)
+	assert.Equal(t, len(durations), 2)
+	perr, ok := err.(*PartialError)
+	assert.True(t, ok)
+	assert.Equal(t, len(perr.Nodes), 1)
+	assert.EqualError(t, perr.Nodes[restarting.Address], "connection refused")
+	assert.Nil(t, perr.Tables)
+}
+
+func TestGetNodeStatsTotals(t *testing.T) {
+	var sessions []*PerfSession
+	for i := 0; i < 10; i++ {
+		result := fmt.Sprintf(`{"counters":[{"name":"disk.capacity.total(MB)","value":%d}]}`, i)
+		sessions = append(sessions, &PerfSession{
+			remoteCmdCaller: &fakeCmdCaller{result: result},
+			Address:         fmt.Sprintf("127.0.0.1:%d", 34801+i),
+		})
+	}
+	var totals StatsAccumulator
+	nodes, _, err := (&PerfClient{}).getNodeStats(context.Background(), sessions, "@", &totals)
+	assert.Nil(t, err)
+	assert.Equal(t, len(nodes), 10)
+	assert.Equal(t, totals.Snapshot(), map[string]float64{"disk.capacity.total(MB)": 45})
+}
+
+func TestGetPartitionStatsListTablesFailure(t *testing.T) {
+	opts := DefaultPerfClientOptions()
+	opts.NodeDiscoveryFn = func(ctx context.Context) ([]string, error) {
+		return []string{"127.0.0.1:34801"}, nil
+	}
+	// no meta server is listening, so listing the tables fails once it times out
+	pclient := NewPerfClientWithOptions([]string{"127.0.0.1:1"}, opts)
+	defer pclient.Close()
+	pclient.nodes["127.0.0.1:34801"] = &PerfSession{
+		remoteCmdCaller: &fakeCmdCaller{result: `{"counters":[{"name":"replica*app.pegasus*get_qps@1.0","value":10}]}`},
+		Address:         "127.0.0.1:34801",
+	}
+
+	partitions, err := pclient.GetPartitionStats(context.Background())
+	assert.NotNil(t, err)
+	perr, ok := err.(*PartialError)
+	assert.True(t, ok)
+	assert.Equal(t, len(perr.Others), 1)
+	assert.Contains(t, perr.Error(), "failed to list tables")
+
+	// the stats are decoded without filtering by the primaries
+	assert.Equal(t, len(partitions), 1)
+	assert.Equal(t, partitions[0].Gpid, base.Gpid{Appid: 1, PartitionIndex: 0})
+	assert.Equal(t, partitions[0].Stats["get_qps"], float64(10))
+}
+
+func TestPrimariesOnlyFromConfig(t *testing.T) {
+	// only the primaries are counted by default
+	opts, err := PerfClientOptionsFromConfig()
+	assert.Nil(t, err)
+	assert.True(t, opts.PrimariesOnly)
+
+	viper.Set("metrics.primaries_only", false)
+	defer viper.Set("metrics.primaries_only", nil)
+	opts, err = PerfClientOptionsFromConfig()
+	assert.Nil(t, err)
+	assert.False(t, opts.PrimariesOnly)
+}
+
+func TestUpdateNodesWithStatuses(t *testing.T) {
+	pclient := NewPerfClient([]string{"127.0.0.1:34601"})
+	defer pclient.Close()
+
+	// only the alive nodes are collected, without listing them from meta again
+	pclient.updateNodesWithStatuses(context.Background(), map[string]admin.NodeStatus{
+		"127.0.0.1:34801": admin.NodeStatus_NS_ALIVE,
+		"127.0.0.1:34802": admin.NodeStatus_NS_ALIVE,
+		"127.0.0.1:34803": admin.NodeStatus_NS_UNALIVE,
+	})
+	assert.Equal(t, pclient.aliveNodes(), map[string]bool{"127.0.0.1:34801": true, "127.0.0.1:34802": true})
+
+	pclient.updateNodesWithStatuses(context.Background(), map[string]admin.NodeStatus{
+		"127.0.0.1:34801": admin.NodeStatus_NS_ALIVE,
+		"127.0.0.1:34802": admin.NodeStatus_NS_UNALIVE,
+	})
+	assert.Equal(t, pclient.aliveNodes(), map[string]bool{"127.0.0.1:34801": true})
+}
+
+// BenchmarkGetNodeStats polls 100 nodes concurrently.
+func BenchmarkGetNodeStats(b *testing.B) {
+	result := `{"counters":[{"name":"replica*app.pegasus*get_qps@1.0","value":10}]}`
+	var sessions []*PerfSession
+	for i := 0; i < 100; i++ {
+		sessions = append(sessions, &PerfSession{
+			remoteCmdCaller: &fakeCmdCaller{result: result},
+			Address:         fmt.Sprintf("127.0.0.1:%d", 34801+i),
+		})
+	}
+	pclient := &PerfClient{}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, _, err := pclient.getNodeStats(context.Background(), sessions, "@", nil); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestPerfClientTableFilter(t *testing.T) {
+	opts := DefaultPerfClientOptions()
+	opts.TableInfoCacheTTL = time.Minute
+	opts.TableFilter = func(table string) bool {
+		return table != "temp"
+	}
+	pclient := NewPerfClientWithOptions([]string{"127.0.0.1:34601"}, opts)
+	defer pclient.Close()
+
+	pclient.tableCache.Set([]*admin.AppInfo{{AppID: 1, AppName: "stat"}, {AppID: 2, AppName: "temp"}})
+	tables, err := pclient.listCollectedTables(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, len(tables), 1)
+	assert.Equal(t, tables[0].AppName, "stat")
+	// the excluded tables are still listed
+	tables, err = pclient.listTables(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, len(tables), 2)
+}
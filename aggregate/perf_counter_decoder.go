@@ -1,6 +1,7 @@
 package aggregate
 
 import (
+	"fmt"
 	"strconv"
 	"strings"
 
@@ -16,6 +17,10 @@ type partitionPerfCounter struct {
 // decodePartitionPerfCounter implements the v1 version of metric decoding.
 func decodePartitionPerfCounter(name string, value float64) *partitionPerfCounter {
 	idx := strings.LastIndex(name, "@")
+	if idx == -1 {
+		// not a partition-level perf-counter
+		return nil
+	}
 	gpidStr := name[idx+1:]
 	appIDAndPartitionID := strings.Split(gpidStr, ".")
 	if len(appIDAndPartitionID) != 2 {
@@ -41,5 +46,21 @@ func decodePartitionPerfCounter(name string, value float64) *partitionPerfCounte
 	}
 }
 
+// DecodePerfCounter decodes a partition-level perf-counter like "replica*app.pegasus*get_qps@1.0",
+// whose name is "replica*app.pegasus*get_qps" and gpid is 1.0.
+// An error is returned if the perf-counter doesn't belong to any partition.
+func DecodePerfCounter(name string, value float64) (*PerfCounter, error) {
+	pc := decodePartitionPerfCounter(name, value)
+	if pc == nil {
+		return nil, fmt.Errorf("%s is not a partition-level perf-counter", name)
+	}
+	return &PerfCounter{
+		Name:           pc.name,
+		Value:          pc.value,
+		Gpid:           pc.gpid,
+		PartitionIndex: int(pc.gpid.PartitionIndex),
+	}, nil
+}
+
 // TODO(wutao1): implement the v2 version of metric decoding according to
 // https://github.com/apache/incubator-pegasus/blob/master/rfcs/2020-08-27-metric-api.md
@@ -42,3 +42,60 @@ func TestDecodePartitionPerfCounter(t *testing.T) {
 		}
 	}
 }
+
+func TestDecodePerfCounter(t *testing.T) {
+	pc, err := DecodePerfCounter("replica*app.pegasus*get_qps@1.2", 100)
+	assert.Nil(t, err)
+	assert.Equal(t, pc, &PerfCounter{
+		Name:           "replica*app.pegasus*get_qps",
+		Value:          100,
+		Gpid:           base.Gpid{Appid: 1, PartitionIndex: 2},
+		PartitionIndex: 2,
+	})
+
+	_, err = DecodePerfCounter("replica*eon.replica_stub*disk.capacity.total(MB)", 100)
+	assert.NotNil(t, err)
+
+	// no "@" but looks like a gpid
+	_, err = DecodePerfCounter("1.0", 100)
+	assert.NotNil(t, err)
+}
+
+func TestDecodePartitionStatsWithoutPrimaries(t *testing.T) {
+	nodes := []*NodeStat{
+		{Addr: "127.0.0.1:34801", Stats: map[string]float64{"replica*app.pegasus*get_qps@1.0": 10}},
+		{Addr: "127.0.0.1:34802", Stats: map[string]float64{"replica*app.pegasus*get_qps@1.1": 20}},
+	}
+	gpid0 := base.Gpid{Appid: 1, PartitionIndex: 0}
+
+	// only the primaries are counted
+	partitions := decodePartitionStats(nodes, map[base.Gpid]string{gpid0: "127.0.0.1:34801"})
+	assert.Equal(t, len(partitions), 1)
+	assert.Equal(t, partitions[0].Gpid, gpid0)
+
+	// all partitions are counted if the primaries are unknown
+	partitions = decodePartitionStats(nodes, nil)
+	assert.Equal(t, len(partitions), 2)
+}
+
+func TestDecodeSecondaryStats(t *testing.T) {
+	nodes := []*NodeStat{
+		{Addr: "127.0.0.1:34801", Stats: map[string]float64{"replica*app.pegasus*get_qps@1.0": 10}},
+		{Addr: "127.0.0.1:34802", Stats: map[string]float64{"replica*app.pegasus*get_qps@1.0": 2}},
+		{Addr: "127.0.0.1:34803", Stats: map[string]float64{"replica*app.pegasus*get_qps@1.0": 3}},
+	}
+	gpid0 := base.Gpid{Appid: 1, PartitionIndex: 0}
+
+	partitions := decodeSecondaryStats(nodes, map[base.Gpid]map[string]bool{
+		gpid0: {"127.0.0.1:34802": true, "127.0.0.1:34803": true},
+	})
+	assert.Equal(t, len(partitions), 2)
+	for _, part := range partitions {
+		assert.Equal(t, part.Gpid, gpid0)
+		assert.True(t, part.IsSecondary())
+	}
+	assert.Equal(t, partitions[0].Addr, "127.0.0.1:34802")
+	assert.Equal(t, partitions[0].Stats["read_qps"], float64(2))
+	assert.Equal(t, partitions[1].Addr, "127.0.0.1:34803")
+	assert.Equal(t, partitions[1].Stats["read_qps"], float64(3))
+}
@@ -2,25 +2,104 @@ package aggregate
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
+	"sync/atomic"
 	"time"
 
-	"github.com/XiaoMi/pegasus-go-client/admin"
+	"github.com/XiaoMi/pegasus-go-client/idl/base"
+	"github.com/XiaoMi/pegasus-go-client/idl/cmd"
 	"github.com/XiaoMi/pegasus-go-client/session"
+	"github.com/apache/thrift/lib/go/thrift"
 	"github.com/tidwall/gjson"
 )
 
+// remoteCmdCaller calls remote commands to a Pegasus server.
+type remoteCmdCaller interface {
+	Call(ctx context.Context, command string, arguments []string) (cmdResult string, err error)
+}
+
+// nodeSessionCmdClient calls remote commands through a NodeSession. Unlike admin.RemoteCmdClient,
+// its session can be closed, otherwise the goroutines of the session leak.
+type nodeSessionCmdClient struct {
+	session session.NodeSession
+}
+
+func (c *nodeSessionCmdClient) Call(ctx context.Context, command string, arguments []string) (string, error) {
+	thriftArgs := &cmd.RemoteCmdServiceCallCommandArgs{
+		Cmd: &cmd.Command{Cmd: command, Arguments: arguments},
+	}
+	res, err := c.session.CallWithGpid(ctx, &base.Gpid{}, thriftArgs, "RPC_CLI_CLI_CALL")
+	if err != nil {
+		return "", err
+	}
+	ret, ok := res.(*cmd.RemoteCmdServiceCallCommandResult)
+	if !ok {
+		return "", fmt.Errorf("unexpected result of remote command \"%s\": %T", command, res)
+	}
+	return ret.GetSuccess(), nil
+}
+
+func (c *nodeSessionCmdClient) Close() {
+	_ = c.session.Close()
+}
+
 // PerfSession is a client to get perf-counters from a Pegasus ReplicaServer.
 type PerfSession struct {
-	*admin.RemoteCmdClient
+	// keep it the first field for 64-bit alignment of the atomic counters
+	stats sessionCounters
+
+	remoteCmdCaller
 
 	Address string
 }
 
+// SessionStats is the load that the collector generates on a session.
+type SessionStats struct {
+	TotalRPCs uint64
+
+	// The bytes of the remote commands and of their results, as they're encoded in the RPCs of
+	// Pegasus. The sizes are of the equivalent remote commands for the HTTP metrics backend.
+	TotalBytesSent uint64
+	TotalBytesRecv uint64
+
+	LastRPCDuration time.Duration
+}
+
+// sessionCounters is the atomic counterpart of SessionStats.
+type sessionCounters struct {
+	totalRPCs       uint64
+	totalBytesSent  uint64
+	totalBytesRecv  uint64
+	lastRPCDuration int64
+}
+
+func (c *sessionCounters) record(sent int, recv int, duration time.Duration) {
+	atomic.AddUint64(&c.totalRPCs, 1)
+	atomic.AddUint64(&c.totalBytesSent, uint64(sent))
+	atomic.AddUint64(&c.totalBytesRecv, uint64(recv))
+	atomic.StoreInt64(&c.lastRPCDuration, int64(duration))
+}
+
+func (c *sessionCounters) snapshot() *SessionStats {
+	return &SessionStats{
+		TotalRPCs:       atomic.LoadUint64(&c.totalRPCs),
+		TotalBytesSent:  atomic.LoadUint64(&c.totalBytesSent),
+		TotalBytesRecv:  atomic.LoadUint64(&c.totalBytesRecv),
+		LastRPCDuration: time.Duration(atomic.LoadInt64(&c.lastRPCDuration)),
+	}
+}
+
 // PerfCounter is a Pegasus perf-counter.
 type PerfCounter struct {
 	Name  string
 	Value float64
+
+	// The partition that the perf-counter belongs to, which are set only if the
+	// perf-counter is decoded by DecodePerfCounter. The table can be resolved from
+	// Gpid.Appid, e.g. by PerfClient.GetTableInfoMap.
+	Gpid           base.Gpid
+	PartitionIndex int
 }
 
 func (p *PerfCounter) String() string {
@@ -30,16 +109,36 @@ func (p *PerfCounter) String() string {
 // NewPerfSession returns an instance of PerfSession.
 func NewPerfSession(addr string) *PerfSession {
 	return &PerfSession{
-		RemoteCmdClient: admin.NewRemoteCmdClient(addr, session.NodeTypeReplica),
+		remoteCmdCaller: &nodeSessionCmdClient{session: session.NewNodeSession(addr, session.NodeTypeReplica)},
+		Address:         addr,
+	}
+}
+
+// NewTLSPerfSession returns an instance of PerfSession whose connection is encrypted by TLS.
+func NewTLSPerfSession(addr string, cfg *tls.Config) *PerfSession {
+	return NewSASLPerfSession(addr, cfg, nil)
+}
+
+// NewSASLPerfSession returns an instance of PerfSession whose connection is authenticated by
+// SASL if sasl is non-nil, and encrypted by TLS if tlsCfg is non-nil.
+func NewSASLPerfSession(addr string, tlsCfg *tls.Config, sasl *SASLAuthenticator) *PerfSession {
+	return &PerfSession{
+		remoteCmdCaller: &nodeSessionCmdClient{session: newConnSession(addr, session.NodeTypeReplica, tlsCfg, sasl)},
 		Address:         addr,
 	}
 }
 
 // GetPerfCounters retrieves all perf-counters matched with `filter` from the remote node.
-func (c *PerfSession) GetPerfCounters(filter string) ([]*PerfCounter, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
-	defer cancel()
-	result, err := c.Call(ctx, "perf-counters-by-substr", []string{filter})
+// The call is abandoned once `ctx` is done.
+func (c *PerfSession) GetPerfCounters(ctx context.Context, filter string) ([]*PerfCounter, error) {
+	command := "perf-counters-by-substr"
+	start := time.Now()
+	result, err := c.Call(ctx, command, []string{filter})
+	recv := 0
+	if err == nil {
+		recv = remoteCmdResultSize(result)
+	}
+	c.stats.record(remoteCmdSize(command, []string{filter}), recv, time.Since(start))
 	if err != nil {
 		return nil, err
 	}
@@ -55,7 +154,66 @@ func (c *PerfSession) GetPerfCounters(filter string) ([]*PerfCounter, error) {
 	return ret, nil
 }
 
+// Stats returns the load that this session has generated.
+func (c *PerfSession) Stats() *SessionStats {
+	return c.stats.snapshot()
+}
+
 // Close terminates the session to replica.
 func (c *PerfSession) Close() {
-	/*TODO*/
+	if closer, ok := c.remoteCmdCaller.(interface{ Close() }); ok {
+		closer.Close()
+	}
+}
+
+// rpcHeaderSize is the size of the fixed header of the RPC requests of Pegasus.
+const rpcHeaderSize = 48
+
+// remoteCmdSize returns the bytes of the RPC request of the remote command.
+func remoteCmdSize(command string, arguments []string) int {
+	args := &cmd.RemoteCmdServiceCallCommandArgs{
+		Cmd: &cmd.Command{Cmd: command, Arguments: arguments},
+	}
+	return rpcHeaderSize + thriftMessageSize("RPC_CLI_CLI_CALL", thrift.CALL, args)
+}
+
+// remoteCmdResultSize returns the bytes of the RPC response of a successful remote command,
+// which starts with its length and the error code.
+func remoteCmdResultSize(result string) int {
+	ret := &cmd.RemoteCmdServiceCallCommandResult{Success: &result}
+	return 4 + thriftStructSize(&base.ErrorCode{Errno: base.ERR_OK.String()}) +
+		thriftMessageSize("RPC_CLI_CLI_CALL_ACK", thrift.REPLY, ret)
+}
+
+// thriftMessageSize returns the size of the message of `body` in the thrift binary protocol.
+func thriftMessageSize(name string, typ thrift.TMessageType, body thrift.TStruct) int {
+	return thriftSize(func(prot thrift.TProtocol) {
+		_ = prot.WriteMessageBegin(name, typ, 0)
+		_ = body.Write(prot)
+		_ = prot.WriteMessageEnd()
+	})
+}
+
+// thriftStructSize returns the size of `body` in the thrift binary protocol.
+func thriftStructSize(body thrift.TStruct) int {
+	return thriftSize(func(prot thrift.TProtocol) {
+		_ = body.Write(prot)
+	})
+}
+
+// thriftSize counts the bytes that `write` encodes, without buffering them.
+func thriftSize(write func(prot thrift.TProtocol)) int {
+	var n byteCounter
+	prot := thrift.NewTBinaryProtocolTransport(thrift.NewStreamTransportW(&n))
+	write(prot)
+	_ = prot.Flush(context.Background())
+	return int(n)
+}
+
+// byteCounter is an io.Writer which counts the bytes written and discards them.
+type byteCounter int
+
+func (c *byteCounter) Write(p []byte) (int, error) {
+	*c += byteCounter(len(p))
+	return len(p), nil
 }
@@ -0,0 +1,54 @@
+package aggregate
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/XiaoMi/pegasus-go-client/idl/base"
+	"github.com/XiaoMi/pegasus-go-client/idl/cmd"
+	"github.com/XiaoMi/pegasus-go-client/session"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeCmdCaller replies the remote commands with a fixed result, unless the context is done.
+type fakeCmdCaller struct {
+	result string
+	err    error
+}
+
+func (c *fakeCmdCaller) Call(ctx context.Context, command string, arguments []string) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	return c.result, c.err
+}
+
+func TestPerfSessionStats(t *testing.T) {
+	result := `{"counters":[{"name":"replica*app.pegasus*get_qps@1.0","value":10}]}`
+	caller := &fakeCmdCaller{result: result}
+	s := &PerfSession{remoteCmdCaller: caller, Address: "127.0.0.1:34801"}
+
+	counters, err := s.GetPerfCounters(context.Background(), "@")
+	assert.Nil(t, err)
+	assert.Equal(t, len(counters), 1)
+
+	caller.result, caller.err = "", errors.New("timeout")
+	_, err = s.GetPerfCounters(context.Background(), "@")
+	assert.NotNil(t, err)
+
+	stats := s.Stats()
+	assert.Equal(t, stats.TotalRPCs, uint64(2))
+	// the bytes on the wire, and the failed RPC receives nothing
+	args := &cmd.RemoteCmdServiceCallCommandArgs{
+		Cmd: &cmd.Command{Cmd: "perf-counters-by-substr", Arguments: []string{"@"}},
+	}
+	rcall, err := session.MarshallPegasusRpc(session.NewPegasusCodec(), 1, &base.Gpid{}, args, "RPC_CLI_CLI_CALL")
+	assert.Nil(t, err)
+	assert.Equal(t, stats.TotalBytesSent, uint64(2*len(rcall.RawReq)))
+	assert.Equal(t, stats.TotalBytesRecv, uint64(remoteCmdResultSize(result)))
+	assert.Greater(t, stats.TotalBytesRecv, uint64(len(result)))
+
+	pclient := &PerfClient{nodes: map[string]*PerfSession{s.Address: s}}
+	assert.Equal(t, pclient.NodeSessionStats(), map[string]*SessionStats{s.Address: stats})
+}
@@ -0,0 +1,51 @@
+package aggregate
+
+import (
+	"github.com/XiaoMi/pegasus-go-client/idl/base"
+)
+
+// NormalizeToRate converts the cumulative values in two consecutive snapshots of a table
+// into per-second rates: (curr - prev) / (the seconds elapsed).
+// A metric is omitted if it's absent in `prev`, or if it decreases (e.g. the counter is reset).
+// The partitions are converted in the same way.
+// nil is returned if the snapshots are of different tables, or the time doesn't go forward.
+func NormalizeToRate(prev, curr *TableStats) *TableStats {
+	if prev == nil || curr == nil || prev.AppID != curr.AppID {
+		return nil
+	}
+	seconds := curr.Timestamp.Sub(prev.Timestamp).Seconds()
+	if seconds <= 0 {
+		return nil
+	}
+
+	rates := &TableStats{
+		TableName:  curr.TableName,
+		AppID:      curr.AppID,
+		Partitions: make(map[int]*PartitionStats),
+		Timestamp:  curr.Timestamp,
+	}
+	for i, part := range curr.Partitions {
+		ratePart := &PartitionStats{
+			Gpid: base.Gpid{Appid: int32(curr.AppID), PartitionIndex: int32(i)},
+			Addr: part.Addr,
+		}
+		if prevPart, found := prev.Partitions[i]; found {
+			ratePart.Stats = ratesOf(prevPart.Stats, part.Stats, seconds)
+		}
+		rates.Partitions[i] = ratePart
+	}
+	rates.Stats = ratesOf(prev.Stats, curr.Stats, seconds)
+	return rates
+}
+
+func ratesOf(prev, curr map[string]float64, seconds float64) map[string]float64 {
+	rates := make(map[string]float64)
+	for name, value := range curr {
+		prevValue, found := prev[name]
+		if !found || value < prevValue {
+			continue
+		}
+		rates[name] = (value - prevValue) / seconds
+	}
+	return rates
+}
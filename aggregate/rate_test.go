@@ -0,0 +1,43 @@
+package aggregate
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalizeToRate(t *testing.T) {
+	now := time.Now()
+	prev := &TableStats{
+		TableName: "stat",
+		AppID:     1,
+		Timestamp: now,
+		Stats:     map[string]float64{"get_count": 100, "put_count": 500},
+		Partitions: map[int]*PartitionStats{
+			0: {Stats: map[string]float64{"get_count": 100}},
+		},
+	}
+	curr := &TableStats{
+		TableName: "stat",
+		AppID:     1,
+		Timestamp: now.Add(10 * time.Second),
+		Stats:     map[string]float64{"get_count": 300, "put_count": 100, "scan_count": 10},
+		Partitions: map[int]*PartitionStats{
+			0: {Stats: map[string]float64{"get_count": 300}},
+		},
+	}
+
+	rates := NormalizeToRate(prev, curr)
+	assert.Equal(t, rates.TableName, "stat")
+	assert.Equal(t, rates.Timestamp, curr.Timestamp)
+	assert.Equal(t, rates.Stats, map[string]float64{"get_count": 20})
+	assert.Equal(t, rates.Partitions[0].Stats, map[string]float64{"get_count": 20})
+
+	// time goes backwards
+	assert.Nil(t, NormalizeToRate(curr, prev))
+	assert.Nil(t, NormalizeToRate(prev, prev))
+
+	curr.AppID = 2
+	assert.Nil(t, NormalizeToRate(prev, curr))
+}
@@ -0,0 +1,61 @@
+package aggregate
+
+import (
+	"context"
+	"time"
+
+	"github.com/XiaoMi/pegasus-go-client/idl/base"
+	"github.com/XiaoMi/pegasus-go-client/session"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// The metrics of the collection itself, to tell whether the collector is healthy or lagging.
+var (
+	aggregationDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "collector_aggregation_duration_seconds",
+		Help:    "The duration of a round of aggregation of the cluster.",
+		Buckets: prometheus.ExponentialBuckets(0.05, 2, 10),
+	}, []string{"cluster"})
+	nodeScrapeDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "collector_node_scrape_duration_seconds",
+		Help:    "The duration of collecting the stats from the replica node, whether successful or not.",
+		Buckets: prometheus.ExponentialBuckets(0.01, 2, 10),
+	}, []string{"cluster", "node"})
+	rpcErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "collector_rpc_errors_total",
+		Help: "The number of the failed RPCs to the meta servers or the replica nodes.",
+	}, []string{"cluster", "node", "type"})
+)
+
+func init() {
+	prometheus.MustRegister(aggregationDuration, nodeScrapeDuration, rpcErrors)
+}
+
+// countingSession is a NodeSession that counts its failed RPCs of the cluster.
+type countingSession struct {
+	session.NodeSession
+	cluster string
+	addr    string
+	ntype   session.NodeType
+}
+
+func (s *countingSession) CallWithGpid(ctx context.Context, gpid *base.Gpid, args session.RpcRequestArgs, name string) (session.RpcResponseResult, error) {
+	res, err := s.NodeSession.CallWithGpid(ctx, gpid, args, name)
+	if err != nil {
+		rpcErrors.WithLabelValues(s.cluster, s.addr, string(s.ntype)).Inc()
+	}
+	return res, err
+}
+
+// observeNodeScrapes records the durations and the errors of collecting the stats from the
+// replica nodes of the cluster.
+func observeNodeScrapes(cluster string, durations map[string]time.Duration, err error) {
+	for addr, d := range durations {
+		nodeScrapeDuration.WithLabelValues(cluster, addr).Observe(d.Seconds())
+	}
+	if perr, ok := err.(*PartialError); ok {
+		for addr := range perr.Nodes {
+			rpcErrors.WithLabelValues(cluster, addr, string(session.NodeTypeReplica)).Inc()
+		}
+	}
+}
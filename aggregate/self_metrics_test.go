@@ -0,0 +1,59 @@
+package aggregate
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/XiaoMi/pegasus-go-client/idl/base"
+	"github.com/XiaoMi/pegasus-go-client/session"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+// failingSession is a NodeSession whose RPCs fail if err is non-nil.
+type failingSession struct {
+	session.NodeSession
+	err error
+}
+
+func (s *failingSession) CallWithGpid(ctx context.Context, gpid *base.Gpid, args session.RpcRequestArgs, name string) (session.RpcResponseResult, error) {
+	return nil, s.err
+}
+
+func TestCountingSession(t *testing.T) {
+	rpcErrors.Reset()
+	defer rpcErrors.Reset()
+
+	inner := &failingSession{}
+	s := &countingSession{NodeSession: inner, cluster: "onebox", addr: "127.0.0.1:34601", ntype: session.NodeTypeMeta}
+	_, err := s.CallWithGpid(context.Background(), nil, nil, "RPC_CM_LIST_NODES")
+	assert.Nil(t, err)
+	inner.err = errors.New("connection refused")
+	for i := 0; i < 2; i++ {
+		_, err = s.CallWithGpid(context.Background(), nil, nil, "RPC_CM_LIST_NODES")
+		assert.NotNil(t, err)
+	}
+	assert.Equal(t, testutil.ToFloat64(rpcErrors.WithLabelValues("onebox", "127.0.0.1:34601", "meta")), float64(2))
+}
+
+func TestObserveNodeScrapes(t *testing.T) {
+	rpcErrors.Reset()
+	nodeScrapeDuration.Reset()
+	defer rpcErrors.Reset()
+	defer nodeScrapeDuration.Reset()
+
+	durations := map[string]time.Duration{
+		"127.0.0.1:34801": 10 * time.Millisecond,
+		"127.0.0.1:34802": 5 * time.Second,
+	}
+	perr := &PartialError{}
+	perr.addNode("127.0.0.1:34802", context.DeadlineExceeded)
+	observeNodeScrapes("onebox", durations, perr)
+	observeNodeScrapes("onebox", durations, nil)
+
+	assert.Equal(t, testutil.CollectAndCount(nodeScrapeDuration), 2)
+	assert.Equal(t, testutil.CollectAndCount(rpcErrors), 1)
+	assert.Equal(t, testutil.ToFloat64(rpcErrors.WithLabelValues("onebox", "127.0.0.1:34802", "replica")), float64(1))
+}
@@ -0,0 +1,126 @@
+package aggregate
+
+import (
+	"container/list"
+	"context"
+	"sync"
+)
+
+// SessionPool limits the number of sessions opened to replica nodes cluster-wide.
+// A session is checked out by address before each call, and returned after the call.
+// When the limit is reached, the least recently used idle session is closed to make
+// room for the new one. If no session is idle, Checkout blocks until one is returned.
+type SessionPool struct {
+	lock sync.Mutex
+
+	maxConnections int
+	open           int
+
+	// idle sessions, ordered from the least recently used to the most recently used
+	idle *list.List
+
+	// closed and renewed whenever a session is returned, to wake up the waiters
+	returned chan struct{}
+
+	dial func(addr string) *PerfSession
+}
+
+// NewSessionPool returns a SessionPool opening at most `maxConnections` sessions,
+// which are created by `dial`.
+func NewSessionPool(maxConnections int, dial func(addr string) *PerfSession) *SessionPool {
+	return &SessionPool{
+		maxConnections: maxConnections,
+		idle:           list.New(),
+		returned:       make(chan struct{}),
+		dial:           dial,
+	}
+}
+
+// Checkout gets a session to `addr` from the pool. The session must be returned
+// by Return after use.
+func (p *SessionPool) Checkout(ctx context.Context, addr string) (*PerfSession, error) {
+	for {
+		p.lock.Lock()
+		if s := p.popIdle(addr); s != nil {
+			p.lock.Unlock()
+			return s, nil
+		}
+		if p.open < p.maxConnections {
+			p.open++
+			p.lock.Unlock()
+			return p.dial(addr), nil
+		}
+		if victim := p.idle.Front(); victim != nil {
+			// evict the least recently used session
+			p.idle.Remove(victim)
+			p.open--
+			p.lock.Unlock()
+			victim.Value.(*PerfSession).Close()
+			continue
+		}
+		returned := p.returned
+		p.lock.Unlock()
+
+		select {
+		case <-returned:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// Return puts the session back to the pool.
+func (p *SessionPool) Return(s *PerfSession) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.idle.PushBack(s)
+	close(p.returned)
+	p.returned = make(chan struct{})
+}
+
+// Remove closes the idle sessions to `addr`, for example, when the node is gone.
+func (p *SessionPool) Remove(addr string) {
+	p.lock.Lock()
+	var removed []*PerfSession
+	for s := p.popIdle(addr); s != nil; s = p.popIdle(addr) {
+		removed = append(removed, s)
+		p.open--
+	}
+	p.lock.Unlock()
+
+	for _, s := range removed {
+		s.Close()
+	}
+}
+
+// popIdle takes the most recently used idle session to `addr` out of the pool.
+func (p *SessionPool) popIdle(addr string) *PerfSession {
+	for e := p.idle.Back(); e != nil; e = e.Prev() {
+		s := e.Value.(*PerfSession)
+		if s.Address == addr {
+			p.idle.Remove(e)
+			return s
+		}
+	}
+	return nil
+}
+
+// pooledCmdCaller calls remote commands through a session checked out from the pool.
+type pooledCmdCaller struct {
+	pool *SessionPool
+	addr string
+}
+
+func (c *pooledCmdCaller) Call(ctx context.Context, command string, arguments []string) (string, error) {
+	s, err := c.pool.Checkout(ctx, c.addr)
+	if err != nil {
+		return "", err
+	}
+	defer c.pool.Return(s)
+	return s.Call(ctx, command, arguments)
+}
+
+func (c *pooledCmdCaller) Close() {
+	c.pool.Remove(c.addr)
+}
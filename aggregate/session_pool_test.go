@@ -0,0 +1,45 @@
+package aggregate
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSessionPool(t *testing.T) {
+	dialed := 0
+	pool := NewSessionPool(2, func(addr string) *PerfSession {
+		dialed++
+		return &PerfSession{Address: addr}
+	})
+	ctx := context.Background()
+
+	s1, _ := pool.Checkout(ctx, "127.0.0.1:34801")
+	s2, _ := pool.Checkout(ctx, "127.0.0.1:34802")
+	assert.Equal(t, dialed, 2)
+
+	// the pool is exhausted
+	timeoutCtx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	_, err := pool.Checkout(timeoutCtx, "127.0.0.1:34803")
+	assert.Equal(t, err, context.DeadlineExceeded)
+
+	// reuse the idle session
+	pool.Return(s1)
+	s, _ := pool.Checkout(ctx, "127.0.0.1:34801")
+	assert.Same(t, s, s1)
+	assert.Equal(t, dialed, 2)
+
+	// evict the idle session to another node
+	pool.Return(s1)
+	s3, _ := pool.Checkout(ctx, "127.0.0.1:34803")
+	assert.Equal(t, s3.Address, "127.0.0.1:34803")
+	assert.Equal(t, dialed, 3)
+
+	pool.Return(s2)
+	pool.Return(s3)
+	pool.Remove("127.0.0.1:34802")
+	assert.Equal(t, pool.open, 1)
+}
@@ -0,0 +1,24 @@
+package aggregate
+
+import (
+	"sort"
+)
+
+// SortTableStats returns a copy of the tables ordered by the value of the metric.
+// A table missing the metric is ordered as if its value is 0. Tables with equal values
+// are ordered by name, so that the result is deterministic.
+func SortTableStats(tables []*TableStats, metricName string, descending bool) []*TableStats {
+	sorted := make([]*TableStats, len(tables))
+	copy(sorted, tables)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		vi, vj := sorted[i].Stats[metricName], sorted[j].Stats[metricName]
+		if vi != vj {
+			if descending {
+				return vi > vj
+			}
+			return vi < vj
+		}
+		return sorted[i].TableName < sorted[j].TableName
+	})
+	return sorted
+}
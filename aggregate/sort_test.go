@@ -0,0 +1,29 @@
+package aggregate
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSortTableStats(t *testing.T) {
+	tables := []*TableStats{
+		{TableName: "a", Stats: map[string]float64{"write_qps": 10}},
+		{TableName: "b", Stats: map[string]float64{}},
+		{TableName: "c", Stats: map[string]float64{"write_qps": 30}},
+		{TableName: "d", Stats: map[string]float64{"write_qps": 10}},
+	}
+	names := func(sorted []*TableStats) []string {
+		var ret []string
+		for _, tb := range sorted {
+			ret = append(ret, tb.TableName)
+		}
+		return ret
+	}
+
+	assert.Equal(t, names(SortTableStats(tables, "write_qps", true)), []string{"c", "a", "d", "b"})
+	assert.Equal(t, names(SortTableStats(tables, "write_qps", false)), []string{"b", "a", "d", "c"})
+
+	// the input is unchanged
+	assert.Equal(t, names(tables), []string{"a", "b", "c", "d"})
+}
@@ -0,0 +1,46 @@
+package aggregate
+
+import (
+	"time"
+)
+
+// SplitEvent indicates that the partition count of a table has increased,
+// which is the result of a Pegasus partition split.
+type SplitEvent struct {
+	TableName string
+	AppID     int
+
+	OldPartitionCount int
+	NewPartitionCount int
+
+	DetectedAt time.Time
+}
+
+// DetectPartitionSplits compares two collections of tables, and returns an event for each
+// table whose partition count in `curr` is larger than that in `prev`.
+// Tables are matched by AppID. Tables that only exist in one of them are ignored.
+func DetectPartitionSplits(prev, curr []*TableStats) []*SplitEvent {
+	prevTables := make(map[int]*TableStats)
+	for _, tb := range prev {
+		prevTables[tb.AppID] = tb
+	}
+
+	now := time.Now()
+	var events []*SplitEvent
+	for _, tb := range curr {
+		prevTb, found := prevTables[tb.AppID]
+		if !found {
+			continue
+		}
+		if len(tb.Partitions) > len(prevTb.Partitions) {
+			events = append(events, &SplitEvent{
+				TableName:         tb.TableName,
+				AppID:             tb.AppID,
+				OldPartitionCount: len(prevTb.Partitions),
+				NewPartitionCount: len(tb.Partitions),
+				DetectedAt:        now,
+			})
+		}
+	}
+	return events
+}
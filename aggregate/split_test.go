@@ -0,0 +1,37 @@
+package aggregate
+
+import (
+	"testing"
+
+	"github.com/XiaoMi/pegasus-go-client/idl/admin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDetectPartitionSplits(t *testing.T) {
+	prev := []*TableStats{
+		newTableStats(&admin.AppInfo{AppID: 1, AppName: "stat", PartitionCount: 4}),
+		newTableStats(&admin.AppInfo{AppID: 2, AppName: "test", PartitionCount: 8}),
+	}
+	curr := []*TableStats{
+		newTableStats(&admin.AppInfo{AppID: 1, AppName: "stat", PartitionCount: 8}),
+		newTableStats(&admin.AppInfo{AppID: 2, AppName: "test", PartitionCount: 8}),
+		newTableStats(&admin.AppInfo{AppID: 3, AppName: "new_table", PartitionCount: 16}),
+	}
+	events := DetectPartitionSplits(prev, curr)
+	assert.Equal(t, len(events), 1)
+	assert.Equal(t, events[0].TableName, "stat")
+	assert.Equal(t, events[0].OldPartitionCount, 4)
+	assert.Equal(t, events[0].NewPartitionCount, 8)
+}
+
+func TestAggregatorEmitsSplits(t *testing.T) {
+	ag := NewTableStatsAggregator([]string{"127.0.0.1:34601"}).(*tableStatsAggregator)
+	defer ag.Close()
+	ag.doUpdateTableMap([]*admin.AppInfo{{AppID: 1, AppName: "stat", PartitionCount: 4}})
+	ag.doUpdateTableMap([]*admin.AppInfo{{AppID: 1, AppName: "stat", PartitionCount: 8}})
+	assert.Equal(t, len(ag.tables[1].Partitions), 8)
+
+	event := <-ag.Splits()
+	assert.Equal(t, event.AppID, 1)
+	assert.Equal(t, event.NewPartitionCount, 8)
+}
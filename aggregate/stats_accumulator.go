@@ -0,0 +1,53 @@
+package aggregate
+
+import "sync"
+
+const statsAccumulatorShards = 32
+
+// StatsAccumulator sums up stats concurrently from multiple goroutines.
+// The stats are spread into shards by name, each shard is protected by its own lock,
+// so that goroutines adding different stats rarely contend with each other.
+// The zero value is ready to use.
+type StatsAccumulator struct {
+	shards [statsAccumulatorShards]statsShard
+}
+
+type statsShard struct {
+	lock  sync.Mutex
+	stats map[string]float64
+}
+
+// Add `value` to the stat named `name`.
+func (a *StatsAccumulator) Add(name string, value float64) {
+	sh := &a.shards[shardIndex(name)]
+	sh.lock.Lock()
+	if sh.stats == nil {
+		sh.stats = make(map[string]float64)
+	}
+	sh.stats[name] += value
+	sh.lock.Unlock()
+}
+
+// Snapshot returns a copy of the accumulated stats.
+func (a *StatsAccumulator) Snapshot() map[string]float64 {
+	result := make(map[string]float64)
+	for i := range a.shards {
+		sh := &a.shards[i]
+		sh.lock.Lock()
+		for name, value := range sh.stats {
+			result[name] = value
+		}
+		sh.lock.Unlock()
+	}
+	return result
+}
+
+// shardIndex hashes the name using FNV-1a.
+func shardIndex(name string) uint32 {
+	h := uint32(2166136261)
+	for i := 0; i < len(name); i++ {
+		h ^= uint32(name[i])
+		h *= 16777619
+	}
+	return h % statsAccumulatorShards
+}
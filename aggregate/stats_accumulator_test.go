@@ -0,0 +1,62 @@
+package aggregate
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStatsAccumulator(t *testing.T) {
+	var acc StatsAccumulator
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				acc.Add(fmt.Sprintf("metric_%d", j), 1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	stats := acc.Snapshot()
+	assert.Equal(t, len(stats), 10)
+	for _, v := range stats {
+		assert.Equal(t, v, float64(100))
+	}
+}
+
+var benchmarkMetricNames = AllMetrics()
+
+// BenchmarkStatsAccumulator runs with 100 goroutines per CPU.
+func BenchmarkStatsAccumulator(b *testing.B) {
+	var acc StatsAccumulator
+	b.SetParallelism(100)
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			acc.Add(benchmarkMetricNames[i%len(benchmarkMetricNames)], 1)
+			i++
+		}
+	})
+}
+
+// BenchmarkMutexStats is the baseline of BenchmarkStatsAccumulator, which
+// protects the whole map with a single mutex.
+func BenchmarkMutexStats(b *testing.B) {
+	var mu sync.Mutex
+	stats := make(map[string]float64)
+	b.SetParallelism(100)
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			mu.Lock()
+			stats[benchmarkMetricNames[i%len(benchmarkMetricNames)]]++
+			mu.Unlock()
+			i++
+		}
+	})
+}
@@ -0,0 +1,56 @@
+package aggregate
+
+import "math"
+
+// The metric of the SST files size of a partition, in MB.
+const storageMetric = "sst_storage_mb"
+
+// The cluster-only metrics of the storage distribution over the replica nodes.
+const (
+	nodeMaxStorageMetric  = "node_max_storage_mb"
+	nodeMinStorageMetric  = "node_min_storage_mb"
+	nodeAvgStorageMetric  = "node_avg_storage_mb"
+	storageImbalanceRatio = "storage_imbalance_ratio"
+)
+
+// nodeStorageOf returns the storage of every replica node, summed up over the partitions on the
+// node, including the secondaries if they're collected.
+func nodeStorageOf(tables map[int32]*TableStats) map[string]float64 {
+	res := make(map[string]float64)
+	add := func(part *PartitionStats) {
+		if v, found := part.Stats[storageMetric]; found && part.Addr != "" {
+			res[part.Addr] += v
+		}
+	}
+	for _, tb := range tables {
+		for _, part := range tb.Partitions {
+			add(part)
+		}
+		for _, part := range tb.Secondaries {
+			add(part)
+		}
+	}
+	return res
+}
+
+// Extends the cluster stats with the max/min/avg storage of the replica nodes, and the
+// imbalance ratio which is the max divided by the avg, i.e. 1 if the storage is balanced.
+func extendClusterStorageStats(allStats *ClusterStats, tables map[int32]*TableStats) {
+	nodes := nodeStorageOf(tables)
+	if len(nodes) == 0 {
+		return
+	}
+	min, max, sum := math.Inf(1), math.Inf(-1), float64(0)
+	for _, v := range nodes {
+		min = math.Min(min, v)
+		max = math.Max(max, v)
+		sum += v
+	}
+	avg := sum / float64(len(nodes))
+	allStats.Stats[nodeMaxStorageMetric] = max
+	allStats.Stats[nodeMinStorageMetric] = min
+	allStats.Stats[nodeAvgStorageMetric] = avg
+	if avg > 0 {
+		allStats.Stats[storageImbalanceRatio] = max / avg
+	}
+}
@@ -0,0 +1,62 @@
+package aggregate
+
+import (
+	"testing"
+
+	"github.com/XiaoMi/pegasus-go-client/idl/admin"
+	"github.com/XiaoMi/pegasus-go-client/idl/base"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAggregateClusterStorageStats(t *testing.T) {
+	ag := &tableStatsAggregator{tables: make(map[int32]*TableStats)}
+	ag.doUpdateTableMap([]*admin.AppInfo{
+		{AppID: 1, AppName: "stat", PartitionCount: 2},
+		{AppID: 2, AppName: "test", PartitionCount: 1},
+	})
+	ag.updatePartitionStat(&PartitionStats{
+		Gpid:  base.Gpid{Appid: 1, PartitionIndex: 0},
+		Addr:  "127.0.0.1:34801",
+		Stats: map[string]float64{"sst_storage_mb": 100},
+	})
+	ag.updatePartitionStat(&PartitionStats{
+		Gpid:  base.Gpid{Appid: 1, PartitionIndex: 1},
+		Addr:  "127.0.0.1:34802",
+		Stats: map[string]float64{"sst_storage_mb": 60},
+	})
+	ag.updatePartitionStat(&PartitionStats{
+		Gpid:  base.Gpid{Appid: 2, PartitionIndex: 0},
+		Addr:  "127.0.0.1:34801",
+		Stats: map[string]float64{"sst_storage_mb": 20},
+	})
+	ag.updateSecondaryStat(&PartitionStats{
+		Gpid:  base.Gpid{Appid: 2, PartitionIndex: 0},
+		Addr:  "127.0.0.1:34803",
+		Stats: map[string]float64{"sst_storage_mb": 20},
+		Role:  RoleSecondary,
+	})
+	for _, tb := range ag.tables {
+		tb.aggregate(AggregateOptions{})
+	}
+	ag.aggregateClusterStats()
+
+	assert.Equal(t, nodeStorageOf(ag.tables), map[string]float64{
+		"127.0.0.1:34801": 120,
+		"127.0.0.1:34802": 60,
+		"127.0.0.1:34803": 20,
+	})
+	assert.Equal(t, ag.allStats.Stats["sst_storage_mb"], float64(180))
+	assert.Equal(t, ag.allStats.Stats["node_max_storage_mb"], float64(120))
+	assert.Equal(t, ag.allStats.Stats["node_min_storage_mb"], float64(20))
+	assert.Equal(t, ag.allStats.Stats["node_avg_storage_mb"], float64(200)/3)
+	assert.InDelta(t, ag.allStats.Stats["storage_imbalance_ratio"], 1.8, 1e-9)
+	assert.Contains(t, ClusterMetrics(), "storage_imbalance_ratio")
+}
+
+func TestAggregateClusterStorageStatsNoStorage(t *testing.T) {
+	ag := &tableStatsAggregator{tables: make(map[int32]*TableStats)}
+	ag.doUpdateTableMap([]*admin.AppInfo{{AppID: 1, AppName: "stat", PartitionCount: 1}})
+	ag.tables[1].aggregate(AggregateOptions{})
+	ag.aggregateClusterStats()
+	assert.NotContains(t, ag.allStats.Stats, "storage_imbalance_ratio")
+}
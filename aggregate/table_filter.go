@@ -0,0 +1,72 @@
+package aggregate
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/spf13/viper"
+)
+
+// NewTableFilter returns the TableFilter that passes the tables matching any of the include
+// patterns, or all tables if no include pattern is given, except those matching any of the
+// exclude patterns. It returns nil if no pattern is given.
+func NewTableFilter(include []string, exclude []string) (func(table string) bool, error) {
+	if len(include) == 0 && len(exclude) == 0 {
+		return nil, nil
+	}
+	includeRes, err := compilePatterns(include)
+	if err != nil {
+		return nil, err
+	}
+	excludeRes, err := compilePatterns(exclude)
+	if err != nil {
+		return nil, err
+	}
+	return func(table string) bool {
+		if len(includeRes) != 0 && !matchAny(includeRes, table) {
+			return false
+		}
+		return !matchAny(excludeRes, table)
+	}, nil
+}
+
+func compilePatterns(patterns []string) ([]*regexp.Regexp, error) {
+	res := make([]*regexp.Regexp, 0, len(patterns))
+	for _, p := range patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern of table filter: %s", err)
+		}
+		res = append(res, re)
+	}
+	return res, nil
+}
+
+func matchAny(res []*regexp.Regexp, table string) bool {
+	for _, re := range res {
+		if re.MatchString(table) {
+			return true
+		}
+	}
+	return false
+}
+
+// tableFilterFromConfig parses the patterns of "metrics.tables.include" and
+// "metrics.tables.exclude".
+func tableFilterFromConfig() (func(table string) bool, error) {
+	return NewTableFilter(viper.GetStringSlice("metrics.tables.include"), viper.GetStringSlice("metrics.tables.exclude"))
+}
+
+// andTableFilters returns the TableFilter that passes the tables passed by both filters, where a
+// nil filter passes all tables.
+func andTableFilters(a, b func(table string) bool) func(table string) bool {
+	if a == nil {
+		return b
+	}
+	if b == nil {
+		return a
+	}
+	return func(table string) bool {
+		return a(table) && b(table)
+	}
+}
@@ -0,0 +1,52 @@
+package aggregate
+
+import (
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewTableFilter(t *testing.T) {
+	filter, err := NewTableFilter(nil, nil)
+	assert.Nil(t, err)
+	assert.Nil(t, filter)
+
+	filter, err = NewTableFilter([]string{"^user_", "^order$"}, []string{"_tmp$"})
+	assert.Nil(t, err)
+	for table, passed := range map[string]bool{
+		"user_profile": true,
+		"order":        true,
+		"orders":       false,
+		"stat":         false,
+		"user_tmp":     false,
+	} {
+		assert.Equal(t, filter(table), passed, table)
+	}
+
+	// all tables are included if no include pattern is given
+	filter, err = NewTableFilter(nil, []string{"^temp_"})
+	assert.Nil(t, err)
+	assert.True(t, filter("stat"))
+	assert.False(t, filter("temp_stat"))
+
+	_, err = NewTableFilter([]string{"("}, nil)
+	assert.NotNil(t, err)
+}
+
+func TestTableFilterFromConfig(t *testing.T) {
+	viper.Set("metrics.tables.include", []string{"^user_"})
+	defer viper.Set("metrics.tables.include", nil)
+
+	opts, err := PerfClientOptionsFromConfig()
+	assert.Nil(t, err)
+	assert.True(t, opts.TableFilter("user_profile"))
+	assert.False(t, opts.TableFilter("stat"))
+
+	// combined with the filter of sharding
+	filter := andTableFilters(opts.TableFilter, func(table string) bool { return table != "user_log" })
+	assert.True(t, filter("user_profile"))
+	assert.False(t, filter("user_log"))
+	assert.False(t, filter("stat"))
+	assert.Nil(t, andTableFilters(nil, nil))
+}
@@ -0,0 +1,81 @@
+package aggregate
+
+import (
+	"sync"
+	"time"
+
+	"github.com/XiaoMi/pegasus-go-client/idl/admin"
+)
+
+// TableInfoCache caches the result of ListApps for a period of time (TTL), in order to
+// reduce the RPC load on meta server. A non-positive TTL disables the cache.
+type TableInfoCache struct {
+	lock sync.Mutex
+
+	ttl       time.Duration
+	tables    []*admin.AppInfo
+	byName    map[string]*admin.AppInfo
+	updatedAt time.Time
+}
+
+// NewTableInfoCache returns an empty TableInfoCache.
+func NewTableInfoCache(ttl time.Duration) *TableInfoCache {
+	return &TableInfoCache{ttl: ttl}
+}
+
+// Get returns the cached tables, and false if the cache is empty or expired.
+func (c *TableInfoCache) Get() ([]*admin.AppInfo, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if !c.valid() {
+		return nil, false
+	}
+	return c.tables, true
+}
+
+// GetByName returns the cached tables indexed by name, and false if the cache is empty or expired.
+func (c *TableInfoCache) GetByName() (map[string]*admin.AppInfo, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if !c.valid() {
+		return nil, false
+	}
+	return c.byName, true
+}
+
+func (c *TableInfoCache) valid() bool {
+	return c.ttl > 0 && c.tables != nil && time.Since(c.updatedAt) < c.ttl
+}
+
+// Set refreshes the cache.
+func (c *TableInfoCache) Set(tables []*admin.AppInfo) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if tables == nil {
+		// distinguish "no table" from "not cached"
+		tables = []*admin.AppInfo{}
+	}
+	c.tables = tables
+	c.byName = indexTablesByName(tables)
+	c.updatedAt = time.Now()
+}
+
+// Invalidate empties the cache, so that the next Get misses.
+func (c *TableInfoCache) Invalidate() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.tables = nil
+	c.byName = nil
+}
+
+func indexTablesByName(tables []*admin.AppInfo) map[string]*admin.AppInfo {
+	byName := make(map[string]*admin.AppInfo, len(tables))
+	for _, tb := range tables {
+		byName[tb.AppName] = tb
+	}
+	return byName
+}
@@ -0,0 +1,43 @@
+package aggregate
+
+import (
+	"testing"
+	"time"
+
+	"github.com/XiaoMi/pegasus-go-client/idl/admin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTableInfoCache(t *testing.T) {
+	cache := NewTableInfoCache(50 * time.Millisecond)
+	_, ok := cache.Get()
+	assert.False(t, ok)
+
+	cache.Set([]*admin.AppInfo{{AppID: 1, AppName: "stat"}})
+	tables, ok := cache.Get()
+	assert.True(t, ok)
+	assert.Equal(t, len(tables), 1)
+	byName, ok := cache.GetByName()
+	assert.True(t, ok)
+	assert.Equal(t, byName["stat"].AppID, int32(1))
+
+	cache.Invalidate()
+	_, ok = cache.Get()
+	assert.False(t, ok)
+
+	// an empty table list is also cached
+	cache.Set(nil)
+	tables, ok = cache.Get()
+	assert.True(t, ok)
+	assert.Empty(t, tables)
+
+	time.Sleep(50 * time.Millisecond)
+	_, ok = cache.Get()
+	assert.False(t, ok)
+
+	// the cache is disabled
+	cache = NewTableInfoCache(0)
+	cache.Set([]*admin.AppInfo{{AppID: 1, AppName: "stat"}})
+	_, ok = cache.Get()
+	assert.False(t, ok)
+}
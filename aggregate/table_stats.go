@@ -1,6 +1,9 @@
 package aggregate
 
 import (
+	"fmt"
+	"math"
+	"strconv"
 	"time"
 
 	"github.com/XiaoMi/pegasus-go-client/idl/admin"
@@ -15,7 +18,51 @@ type PartitionStats struct {
 	Addr string
 
 	// perfCounter's name -> the value.
+	// It's nil until the first stat is written via getOrInitStats, reading a nil map is fine.
 	Stats map[string]float64
+
+	// The compaction-related stats, which are also contained in Stats.
+	Compaction CompactionStats
+
+	// The time when the stats were retrieved from the replica node.
+	// It's zero if the partition has never been collected.
+	CollectedAt time.Time
+
+	// perfCounter's name -> the number of counter resets detected by SetWithReset,
+	// e.g. after the replica node restarts.
+	CounterResets map[string]int
+
+	// The role of the replica on Addr. It's empty for the primaries, and RoleSecondary for the
+	// secondaries collected with PerfClientOptions.CollectSecondaries.
+	Role ReplicaRole
+}
+
+// ReplicaRole is the role of the replica which the stats are collected from.
+type ReplicaRole string
+
+const (
+	// RolePrimary is the role of the primary replica.
+	RolePrimary ReplicaRole = "primary"
+
+	// RoleSecondary is the role of the secondary replicas.
+	RoleSecondary ReplicaRole = "secondary"
+)
+
+// IsSecondary returns whether the stats are collected from a secondary replica.
+func (ps *PartitionStats) IsSecondary() bool {
+	return ps.Role == RoleSecondary
+}
+
+// CompactionStats is the typed view of the RocksDB compaction stats of a partition.
+type CompactionStats struct {
+	// The estimated bytes that compactions need to rewrite.
+	PendingBytes float64
+
+	// The number of pending compactions.
+	PendingTasks float64
+
+	// The number of SST files.
+	SSTFileCount float64
 }
 
 // TableStats has the aggregated metrics for this table.
@@ -31,15 +78,88 @@ type TableStats struct {
 	// The aggregated value of table metrics.
 	// perfCounter's name -> the value.
 	Stats map[string]float64
+
+	// The properties of the table, which are refreshed every time the tables are listed from meta.
+	Metadata *TableMetadata
+
+	// The stats of the secondary replicas in the last collection, which are collected with
+	// PerfClientOptions.CollectSecondaries only.
+	Secondaries []*PartitionStats
+
+	// The aggregated value of the secondary replicas, the same way as Stats.
+	// It's nil if no secondary is collected.
+	SecondaryStats map[string]float64
+
+	// whether Partitions have changed since the last aggregation
+	dirty bool
+
+	// the options of the last aggregation, which are reused when re-aggregating lazily
+	options AggregateOptions
+}
+
+// TableMetadata is the properties of a table retrieved from meta.
+type TableMetadata struct {
+	AppType string
+
+	// It's zero if meta doesn't report the creation time.
+	CreatedAt time.Time
+
+	// The table envs, e.g. "default_ttl", "replica.deny_client_write".
+	Envs map[string]string
+
+	// The default TTL of the records parsed from the "default_ttl" env. It's zero if unset.
+	DefaultTTL time.Duration
+
+	MaxReplicaCount    int
+	InitPartitionCount int
+	Duplicating        bool
+}
+
+func newTableMetadata(info *admin.AppInfo) *TableMetadata {
+	meta := &TableMetadata{
+		AppType:            info.AppType,
+		Envs:               make(map[string]string, len(info.Envs)),
+		MaxReplicaCount:    int(info.MaxReplicaCount),
+		InitPartitionCount: int(info.InitPartitionCount),
+		Duplicating:        info.IsSetDuplicating() && *info.Duplicating,
+	}
+	if info.CreateSecond > 0 {
+		meta.CreatedAt = time.Unix(info.CreateSecond, 0)
+	}
+	for k, v := range info.Envs {
+		meta.Envs[k] = v
+	}
+	if ttl, err := strconv.ParseInt(meta.Envs["default_ttl"], 10, 64); err == nil {
+		meta.DefaultTTL = time.Duration(ttl) * time.Second
+	}
+	return meta
+}
+
+// AggregateOptions decides how the partition stats are aggregated into the table stats.
+type AggregateOptions struct {
+	// NormalizeByPartitionCount divides each partition stat by the number of partitions before
+	// summation, so that the table stats are the average per partition. It's useful for comparing
+	// tables with different numbers of partitions.
+	NormalizeByPartitionCount bool
+
+	// Rules decide the policies of the metrics by their names, where the first matched rule
+	// takes effect. The metrics not matched by any rule are summed up.
+	Rules []AggregationRule
 }
 
 // ClusterStats is the aggregated metrics for all the TableStats in this cluster.
 // For example, 3 tables with "write_qps" [25, 70, 100] are summed up to
 // `Stats: {"write_qps" : 195}`.
 type ClusterStats struct {
+	// The name of the cluster, which tells the clusters apart in the hooks of all clusters.
+	Cluster string
+
 	Timestamp time.Time
 
 	Stats map[string]float64
+
+	// The aggregated value of TableStats.SecondaryStats. It's nil if no secondary is collected.
+	SecondaryStats map[string]float64
 }
 
 func newTableStats(info *admin.AppInfo) *TableStats {
@@ -49,69 +169,186 @@ func newTableStats(info *admin.AppInfo) *TableStats {
 		Partitions: make(map[int]*PartitionStats),
 		Stats:      make(map[string]float64),
 		Timestamp:  time.Now(),
+		Metadata:   newTableMetadata(info),
 	}
 	for i := 0; i < int(info.PartitionCount); i++ {
 		tb.Partitions[i] = &PartitionStats{
-			Gpid:  base.Gpid{Appid: int32(info.AppID), PartitionIndex: int32(i)},
-			Stats: make(map[string]float64),
+			Gpid: base.Gpid{Appid: int32(info.AppID), PartitionIndex: int32(i)},
 		}
 	}
 	return tb
 }
 
-func (tb *TableStats) aggregate() {
+// AppendPartitionStats updates or inserts the partition into this table.
+// The table is re-aggregated on the next call of GetStats.
+func (tb *TableStats) AppendPartitionStats(ps *PartitionStats) error {
+	if int(ps.Gpid.Appid) != tb.AppID {
+		return fmt.Errorf("partition %s doesn't belong to table %s(appid=%d)", ps.Gpid.String(), tb.TableName, tb.AppID)
+	}
+	tb.Partitions[int(ps.Gpid.PartitionIndex)] = ps
+	tb.dirty = true
+	return nil
+}
+
+// GetStats returns the aggregated table metrics, aggregating the partitions
+// again if any of them was appended since the last aggregation.
+func (tb *TableStats) GetStats() map[string]float64 {
+	if tb.dirty {
+		tb.aggregate(tb.options)
+	}
+	return tb.Stats
+}
+
+// HasStats returns whether any stat of this partition has been collected.
+func (ps *PartitionStats) HasStats() bool {
+	return len(ps.Stats) != 0
+}
+
+// getOrInitStats returns the Stats map for writing, which is allocated on the first call.
+func (ps *PartitionStats) getOrInitStats() map[string]float64 {
+	if ps.Stats == nil {
+		ps.Stats = make(map[string]float64)
+	}
+	return ps.Stats
+}
+
+// SetWithReset sets the stat to the increment of a cumulative counter since `prev`, and returns it.
+// If the counter decreases, it's considered to be reset to zero in between, so the increment is
+// `value` itself rather than a negative delta, and the reset is counted in CounterResets.
+func (ps *PartitionStats) SetWithReset(name string, value float64, prev float64) float64 {
+	delta := value - prev
+	if value < prev {
+		if ps.CounterResets == nil {
+			ps.CounterResets = make(map[string]int)
+		}
+		ps.CounterResets[name]++
+		delta = value
+	}
+	ps.getOrInitStats()[name] = delta
+	return delta
+}
+
+// deepCopy returns a copy of the table that shares no map with it.
+func (tb *TableStats) deepCopy() *TableStats {
+	cp := *tb
+	cp.Stats = copyStats(tb.Stats)
+	cp.Partitions = make(map[int]*PartitionStats, len(tb.Partitions))
+	for idx, part := range tb.Partitions {
+		cp.Partitions[idx] = part.deepCopy()
+	}
+	if tb.Secondaries != nil {
+		cp.Secondaries = make([]*PartitionStats, 0, len(tb.Secondaries))
+		for _, part := range tb.Secondaries {
+			cp.Secondaries = append(cp.Secondaries, part.deepCopy())
+		}
+		cp.SecondaryStats = copyStats(tb.SecondaryStats)
+	}
+	return &cp
+}
+
+func (ps *PartitionStats) deepCopy() *PartitionStats {
+	cp := *ps
+	cp.Stats = copyStats(ps.Stats)
+	if ps.CounterResets != nil {
+		cp.CounterResets = make(map[string]int, len(ps.CounterResets))
+		for name, n := range ps.CounterResets {
+			cp.CounterResets[name] = n
+		}
+	}
+	return &cp
+}
+
+func copyStats(stats map[string]float64) map[string]float64 {
+	cp := make(map[string]float64, len(stats))
+	for name, value := range stats {
+		cp[name] = value
+	}
+	return cp
+}
+
+// PartitionCount returns the number of partitions of this table.
+func (tb *TableStats) PartitionCount() int {
+	return len(tb.Partitions)
+}
+
+func (tb *TableStats) aggregate(options AggregateOptions) {
 	tb.Timestamp = time.Now()
+	tb.dirty = false
+	tb.options = options
+	divisor := float64(1)
+	if options.NormalizeByPartitionCount && tb.PartitionCount() > 0 {
+		divisor = float64(tb.PartitionCount())
+	}
+	entities := make([]map[string]float64, 0, len(tb.Partitions))
 	for _, part := range tb.Partitions {
-		for name, value := range part.Stats {
-			tb.Stats[name] += value
+		if part.HasStats() {
+			entities = append(entities, part.Stats)
 		}
 	}
+	tb.Stats = aggregateStats(entities, options, divisor)
+	reapplyDerivedMetrics(tb.Stats)
+	extendTableReplicaStats(tb)
+
+	tb.SecondaryStats = nil
+	if len(tb.Secondaries) != 0 {
+		entities = entities[:0]
+		for _, part := range tb.Secondaries {
+			entities = append(entities, part.Stats)
+		}
+		tb.SecondaryStats = aggregateStats(entities, options, divisor)
+		reapplyDerivedMetrics(tb.SecondaryStats)
+	}
 }
 
-func aggregateCustomStats(elements []string, stats *map[string]float64, resultName string) {
-	aggregated := float64(0)
-	for _, ele := range elements {
-		if v, found := (*stats)[ele]; found {
-			aggregated += v
+// Extends the table stats with min/max/avg_replica_count over the partitions reporting replica_count.
+func extendTableReplicaStats(tb *TableStats) {
+	count := 0
+	min, max, sum := math.Inf(1), math.Inf(-1), float64(0)
+	for _, part := range tb.Partitions {
+		v, found := part.Stats["replica_count"]
+		if !found {
+			continue
 		}
+		count++
+		min = math.Min(min, v)
+		max = math.Max(max, v)
+		sum += v
 	}
-	(*stats)[resultName] = aggregated
+	if count == 0 {
+		return
+	}
+	tb.Stats["min_replica_count"] = min
+	tb.Stats["max_replica_count"] = max
+	tb.Stats["avg_replica_count"] = sum / float64(count)
 }
 
-// Extends the stat with read_qps/read_bytes/write_qps/write_bytes.
+// Extends the stat with the derived metrics, e.g. read_qps/read_bytes/write_qps/write_bytes,
+// and the compaction metrics.
 func extendStats(stats *map[string]float64) {
-	var reads = []string{
-		"get",
-		"multi_get",
-		"scan",
-	}
-	var readQPS []string
-	for _, r := range reads {
-		readQPS = append(readQPS, r+"_qps")
-	}
-	var readBytes []string
-	for _, r := range reads {
-		readBytes = append(readBytes, r+"_bytes")
-	}
-	aggregateCustomStats(readQPS, stats, "read_qps")
-	aggregateCustomStats(readBytes, stats, "read_bytes")
-
-	var writes = []string{
-		"put",
-		"remove",
-		"multi_put",
-		"multi_remove",
-		"check_and_set",
-		"check_and_mutate",
-	}
-	var writeQPS []string
-	for _, w := range writes {
-		writeQPS = append(writeQPS, w+"_qps")
-	}
-	var writeBytes []string
-	for _, w := range writes {
-		writeBytes = append(writeBytes, w+"_bytes")
-	}
-	aggregateCustomStats(writeQPS, stats, "write_qps")
-	aggregateCustomStats(writeBytes, stats, "write_bytes")
+	applyDerivedMetrics(*stats)
+	extendCompactionStats(stats)
+}
+
+// compactionStatsSources is the mapping of [derived compaction metric -> the underlying counter].
+var compactionStatsSources = map[string]string{
+	"compaction_pending_bytes": "rdb_estimate_pending_compaction_bytes",
+	"compaction_pending_tasks": "rdb_compaction_pending",
+	"sst_file_count":           "sst_count",
+}
+
+// Extends the stat with the compaction metrics if the underlying counters are present.
+func extendCompactionStats(stats *map[string]float64) {
+	for name, source := range compactionStatsSources {
+		if v, found := (*stats)[source]; found {
+			(*stats)[name] = v
+		}
+	}
+}
+
+func newCompactionStats(stats map[string]float64) CompactionStats {
+	return CompactionStats{
+		PendingBytes: stats["compaction_pending_bytes"],
+		PendingTasks: stats["compaction_pending_tasks"],
+		SSTFileCount: stats["sst_file_count"],
+	}
 }
@@ -0,0 +1,183 @@
+package aggregate
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/XiaoMi/pegasus-go-client/idl/admin"
+	"github.com/XiaoMi/pegasus-go-client/idl/base"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExtendCompactionStats(t *testing.T) {
+	stats := map[string]float64{
+		"sst_count":                             12,
+		"rdb_estimate_pending_compaction_bytes": 1024,
+	}
+	extendStats(&stats)
+	assert.Equal(t, stats["sst_file_count"], float64(12))
+	assert.Equal(t, stats["compaction_pending_bytes"], float64(1024))
+	assert.NotContains(t, stats, "compaction_pending_tasks")
+
+	cstats := newCompactionStats(stats)
+	assert.Equal(t, cstats, CompactionStats{PendingBytes: 1024, SSTFileCount: 12})
+}
+
+func TestExtendTableReplicaStats(t *testing.T) {
+	tb := newTableStats(&admin.AppInfo{AppID: 1, AppName: "stat", PartitionCount: 4})
+	tb.Partitions[0].getOrInitStats()["replica_count"] = 3
+	tb.Partitions[1].getOrInitStats()["replica_count"] = 3
+	tb.Partitions[2].getOrInitStats()["replica_count"] = 2
+	tb.aggregate(AggregateOptions{})
+	assert.Equal(t, tb.Stats["replica_count"], float64(8))
+	assert.Equal(t, tb.Stats["min_replica_count"], float64(2))
+	assert.Equal(t, tb.Stats["max_replica_count"], float64(3))
+	assert.InDelta(t, tb.Stats["avg_replica_count"], 8.0/3, 1e-9)
+}
+
+func TestAppendPartitionStats(t *testing.T) {
+	tb := newTableStats(&admin.AppInfo{AppID: 1, AppName: "stat", PartitionCount: 2})
+	tb.Partitions[0].getOrInitStats()["get_qps"] = 10
+	tb.Partitions[1].getOrInitStats()["get_qps"] = 20
+	tb.aggregate(AggregateOptions{})
+	assert.Equal(t, tb.GetStats()["get_qps"], float64(30))
+
+	// the table is splitted into 3 partitions
+	err := tb.AppendPartitionStats(&PartitionStats{
+		Gpid:  base.Gpid{Appid: 1, PartitionIndex: 2},
+		Stats: map[string]float64{"get_qps": 5},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, len(tb.Partitions), 3)
+	// aggregated lazily on read
+	assert.Equal(t, tb.Stats["get_qps"], float64(30))
+	assert.Equal(t, tb.GetStats()["get_qps"], float64(35))
+
+	// update the existing partition
+	err = tb.AppendPartitionStats(&PartitionStats{
+		Gpid:  base.Gpid{Appid: 1, PartitionIndex: 0},
+		Stats: map[string]float64{"get_qps": 1},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, tb.GetStats()["get_qps"], float64(26))
+
+	err = tb.AppendPartitionStats(&PartitionStats{Gpid: base.Gpid{Appid: 2, PartitionIndex: 0}})
+	assert.NotNil(t, err)
+	assert.Equal(t, len(tb.Partitions), 3)
+}
+
+func TestAggregateStats(t *testing.T) {
+	tb := newTableStats(&admin.AppInfo{AppID: 1, AppName: "stat", PartitionCount: 4})
+	for i, qps := range []float64{2, 4, 4, 6} {
+		tb.Partitions[i].getOrInitStats()["get_qps"] = qps
+	}
+	tb.Partitions[0].getOrInitStats()["put_qps"] = 1
+
+	report := tb.AggregateStats()
+	assert.Equal(t, report.TableName, "stat")
+	assert.Equal(t, len(report.Metrics), 2)
+
+	s := report.Metrics["get_qps"]
+	assert.Equal(t, s.PartitionCount, 4)
+	assert.Equal(t, s.Sum, float64(16))
+	assert.Equal(t, s.Mean, float64(4))
+	assert.Equal(t, s.Min, float64(2))
+	assert.Equal(t, s.Max, float64(6))
+	assert.InDelta(t, s.Variance, 2.0, 1e-9)
+	assert.InDelta(t, s.StdDev, math.Sqrt(2), 1e-9)
+
+	s = report.Metrics["put_qps"]
+	assert.Equal(t, s.PartitionCount, 1)
+	assert.Equal(t, s.Variance, float64(0))
+}
+
+func TestAggregateNormalizeByPartitionCount(t *testing.T) {
+	tb := newTableStats(&admin.AppInfo{AppID: 1, AppName: "stat", PartitionCount: 4})
+	for i := 0; i < tb.PartitionCount(); i++ {
+		tb.Partitions[i].getOrInitStats()["write_bytes"] = float64(100 * (i + 1))
+	}
+	tb.aggregate(AggregateOptions{})
+	assert.Equal(t, tb.Stats["write_bytes"], float64(1000))
+
+	tb.aggregate(AggregateOptions{NormalizeByPartitionCount: true})
+	assert.Equal(t, tb.Stats["write_bytes"], float64(250))
+
+	// the options are kept for the lazy re-aggregation
+	_ = tb.AppendPartitionStats(&PartitionStats{
+		Gpid:  base.Gpid{Appid: 1, PartitionIndex: 4},
+		Stats: map[string]float64{"write_bytes": 1500},
+	})
+	assert.Equal(t, tb.GetStats()["write_bytes"], float64(500))
+}
+
+func TestPartitionStatsLazyInit(t *testing.T) {
+	tb := newTableStats(&admin.AppInfo{AppID: 1, AppName: "stat", PartitionCount: 2})
+	part := tb.Partitions[0]
+	assert.False(t, part.HasStats())
+	assert.Equal(t, part.Stats["get_qps"], float64(0))
+
+	part.getOrInitStats()["get_qps"] = 10
+	assert.True(t, part.HasStats())
+	assert.Nil(t, tb.Partitions[1].Stats)
+
+	tb.aggregate(AggregateOptions{})
+	assert.Equal(t, tb.Stats["get_qps"], float64(10))
+}
+
+// BenchmarkNewTableStats allocates a table of 256 partitions, none of which has stats yet.
+func BenchmarkNewTableStats(b *testing.B) {
+	info := &admin.AppInfo{AppID: 1, AppName: "stat", PartitionCount: 256}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_ = newTableStats(info)
+	}
+}
+
+func TestNewTableMetadata(t *testing.T) {
+	duplicating := true
+	tb := newTableStats(&admin.AppInfo{
+		AppID:              1,
+		AppName:            "stat",
+		AppType:            "pegasus",
+		PartitionCount:     8,
+		Envs:               map[string]string{"default_ttl": "86400"},
+		MaxReplicaCount:    3,
+		CreateSecond:       1600000000,
+		Duplicating:        &duplicating,
+		InitPartitionCount: 4,
+	})
+	assert.Equal(t, tb.Metadata, &TableMetadata{
+		AppType:            "pegasus",
+		CreatedAt:          time.Unix(1600000000, 0),
+		Envs:               map[string]string{"default_ttl": "86400"},
+		DefaultTTL:         24 * time.Hour,
+		MaxReplicaCount:    3,
+		InitPartitionCount: 4,
+		Duplicating:        true,
+	})
+
+	meta := newTableMetadata(&admin.AppInfo{AppID: 1, AppName: "stat"})
+	assert.True(t, meta.CreatedAt.IsZero())
+	assert.Equal(t, meta.DefaultTTL, time.Duration(0))
+	assert.False(t, meta.Duplicating)
+}
+
+func TestPartitionStatsSetWithReset(t *testing.T) {
+	part := &PartitionStats{}
+	assert.Equal(t, part.SetWithReset("get_count", 150, 100), float64(50))
+	assert.Equal(t, part.Stats["get_count"], float64(50))
+	assert.Nil(t, part.CounterResets)
+
+	// the node restarted
+	assert.Equal(t, part.SetWithReset("get_count", 20, 150), float64(20))
+	assert.Equal(t, part.Stats["get_count"], float64(20))
+	assert.Equal(t, part.CounterResets, map[string]int{"get_count": 1})
+
+	assert.Equal(t, part.SetWithReset("get_count", 5, 20), float64(5))
+	assert.Equal(t, part.CounterResets, map[string]int{"get_count": 2})
+
+	cp := (&TableStats{Partitions: map[int]*PartitionStats{0: part}}).deepCopy()
+	cp.Partitions[0].CounterResets["get_count"] = 0
+	assert.Equal(t, part.CounterResets["get_count"], 2)
+}
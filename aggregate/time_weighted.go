@@ -0,0 +1,64 @@
+package aggregate
+
+import (
+	"sort"
+)
+
+// TimeWeightedAverage computes the time-weighted average of each metric over the window
+// of snapshots, using trapezoid integration over the timestamps. For a metric missing
+// from some snapshots, only the intervals where it's present at both ends are counted.
+// The Timestamp of the result is the midpoint of the window. nil is returned if there's no snapshot.
+func TimeWeightedAverage(snapshots []*TableStats) *TableStats {
+	if len(snapshots) == 0 {
+		return nil
+	}
+	sorted := make([]*TableStats, len(snapshots))
+	copy(sorted, snapshots)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Timestamp.Before(sorted[j].Timestamp)
+	})
+	first, last := sorted[0], sorted[len(sorted)-1]
+
+	result := &TableStats{
+		TableName:  last.TableName,
+		AppID:      last.AppID,
+		Partitions: make(map[int]*PartitionStats),
+		Timestamp:  first.Timestamp.Add(last.Timestamp.Sub(first.Timestamp) / 2),
+		Stats:      make(map[string]float64),
+	}
+	if len(sorted) == 1 || !last.Timestamp.After(first.Timestamp) {
+		// no time elapsed, take the arithmetic mean
+		counts := make(map[string]int)
+		for _, s := range sorted {
+			for name, value := range s.Stats {
+				result.Stats[name] += value
+				counts[name]++
+			}
+		}
+		for name, count := range counts {
+			result.Stats[name] /= float64(count)
+		}
+		return result
+	}
+
+	areas := make(map[string]float64)
+	durations := make(map[string]float64)
+	for i := 1; i < len(sorted); i++ {
+		prev, curr := sorted[i-1], sorted[i]
+		seconds := curr.Timestamp.Sub(prev.Timestamp).Seconds()
+		for name, value := range curr.Stats {
+			prevValue, found := prev.Stats[name]
+			if !found {
+				continue
+			}
+			areas[name] += (prevValue + value) / 2 * seconds
+			durations[name] += seconds
+		}
+	}
+	for name, area := range areas {
+		if durations[name] > 0 {
+			result.Stats[name] = area / durations[name]
+		}
+	}
+	return result
+}
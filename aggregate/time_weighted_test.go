@@ -0,0 +1,29 @@
+package aggregate
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTimeWeightedAverage(t *testing.T) {
+	assert.Nil(t, TimeWeightedAverage(nil))
+
+	now := time.Now()
+	snapshots := []*TableStats{
+		{TableName: "stat", Timestamp: now.Add(10 * time.Second), Stats: map[string]float64{"write_qps": 100, "read_qps": 10}},
+		{TableName: "stat", Timestamp: now, Stats: map[string]float64{"write_qps": 0, "read_qps": 10}},
+		{TableName: "stat", Timestamp: now.Add(40 * time.Second), Stats: map[string]float64{"write_qps": 100}},
+	}
+	avg := TimeWeightedAverage(snapshots)
+	assert.Equal(t, avg.Timestamp, now.Add(20*time.Second))
+	// (0+100)/2*10 + 100*30 = 3500 over 40s
+	assert.InDelta(t, avg.Stats["write_qps"], 87.5, 1e-9)
+	// read_qps is present only in the first 10s
+	assert.InDelta(t, avg.Stats["read_qps"], 10, 1e-9)
+
+	// a single snapshot
+	avg = TimeWeightedAverage(snapshots[:1])
+	assert.Equal(t, avg.Stats, snapshots[0].Stats)
+}
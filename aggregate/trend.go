@@ -0,0 +1,100 @@
+package aggregate
+
+import (
+	"math"
+)
+
+// TrendDirection is the direction where a metric goes.
+type TrendDirection int
+
+// The trend directions.
+const (
+	Stable TrendDirection = iota
+	Rising
+	Falling
+)
+
+func (d TrendDirection) String() string {
+	switch d {
+	case Rising:
+		return "Rising"
+	case Falling:
+		return "Falling"
+	default:
+		return "Stable"
+	}
+}
+
+// trendStableTolerance is the maximum ratio of the slope to the mean value
+// that the metric is still considered to be stable.
+const trendStableTolerance = 0.01
+
+// TrendIndicator tells whether a cluster metric is rising, falling or stable,
+// according to the recent ClusterStats.
+type TrendIndicator struct {
+	history *threadSafeHistory
+}
+
+// NewTrendIndicator returns a TrendIndicator keeping at most `capacity` snapshots.
+func NewTrendIndicator(capacity int) *TrendIndicator {
+	return &TrendIndicator{history: newHistory(capacity)}
+}
+
+// Emit appends a ClusterStats. The oldest one is removed if the indicator is full.
+func (t *TrendIndicator) Emit(stats ClusterStats) {
+	t.history.emit(&stats)
+}
+
+// Direction fits a linear regression over the last `window` values of the metric.
+// The metric is Stable if the slope is tiny compared with its mean value, or if
+// there're less than 2 values.
+func (t *TrendIndicator) Direction(metricName string, window int) TrendDirection {
+	values := t.lastValues(metricName, window)
+	if len(values) < 2 {
+		return Stable
+	}
+
+	slope, mean := linearRegression(values)
+	if math.Abs(slope) <= trendStableTolerance*math.Abs(mean) {
+		return Stable
+	}
+	if slope > 0 {
+		return Rising
+	}
+	return Falling
+}
+
+// lastValues returns the last `window` values of the metric, ordered by time.
+func (t *TrendIndicator) lastValues(metricName string, window int) []float64 {
+	h := t.history
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+
+	var values []float64
+	for e := h.stats.Back(); e != nil && len(values) < window; e = e.Prev() {
+		stats := e.Value.(*ClusterStats)
+		if v, found := stats.Stats[metricName]; found {
+			values = append(values, v)
+		}
+	}
+	for i, j := 0, len(values)-1; i < j; i, j = i+1, j-1 {
+		values[i], values[j] = values[j], values[i]
+	}
+	return values
+}
+
+// linearRegression fits y = slope*x + intercept where x is the index of the value,
+// using the least squares method. The mean of y is also returned.
+func linearRegression(y []float64) (slope float64, mean float64) {
+	n := float64(len(y))
+	var sumX, sumY, sumXY, sumXX float64
+	for i, v := range y {
+		x := float64(i)
+		sumX += x
+		sumY += v
+		sumXY += x * v
+		sumXX += x * x
+	}
+	slope = (n*sumXY - sumX*sumY) / (n*sumXX - sumX*sumX)
+	return slope, sumY / n
+}
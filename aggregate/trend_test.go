@@ -0,0 +1,29 @@
+package aggregate
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTrendIndicator(t *testing.T) {
+	trend := NewTrendIndicator(5)
+	assert.Equal(t, trend.Direction("read_qps", 5), Stable)
+
+	for _, qps := range []float64{1000, 1001, 999, 1000, 1000} {
+		trend.Emit(ClusterStats{Stats: map[string]float64{"read_qps": qps, "write_qps": qps}})
+	}
+	assert.Equal(t, trend.Direction("read_qps", 5), Stable)
+
+	// the oldest snapshots are removed
+	for _, qps := range []float64{1500, 2000, 2500} {
+		trend.Emit(ClusterStats{Stats: map[string]float64{"read_qps": qps, "write_qps": 3000 - qps}})
+	}
+	assert.Equal(t, trend.Direction("read_qps", 5), Rising)
+	assert.Equal(t, trend.Direction("write_qps", 5), Falling)
+
+	// only the last 2 values are considered
+	trend.Emit(ClusterStats{Stats: map[string]float64{"read_qps": 2500}})
+	assert.Equal(t, trend.Direction("read_qps", 2), Stable)
+	assert.Equal(t, trend.Direction("not_exist", 5), Stable)
+}
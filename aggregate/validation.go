@@ -0,0 +1,60 @@
+package aggregate
+
+import (
+	"fmt"
+	"time"
+)
+
+// ValidationError is a metric whose value is invalid.
+type ValidationError struct {
+	MetricName string
+	Value      float64
+}
+
+func (e ValidationError) Error() string {
+	return fmt.Sprintf("invalid value of metric %s: %f", e.MetricName, e.Value)
+}
+
+// ValidateStats checks that all the values are non-negative, since perf-counters
+// never go negative unless the data is corrupted, by a protocol bug or counter overflow.
+func ValidateStats(stats map[string]float64) []ValidationError {
+	var errs []ValidationError
+	for name, value := range stats {
+		if value < 0 {
+			errs = append(errs, ValidationError{MetricName: name, Value: value})
+		}
+	}
+	return errs
+}
+
+// configDerivedStats are the partition stats taken from the partition configurations rather than
+// the perf-counters, which say nothing about whether the primary is serving.
+var configDerivedStats = map[string]bool{
+	"replica_count": true,
+}
+
+// DetectZeroStatsPrimaries returns the partitions whose stats are all zero (or empty) even though
+// they were successfully collected within `threshold` from now. Zero stats on an alive primary
+// usually indicates a problem, such as an unhealthy replica or disconnected clients.
+func DetectZeroStatsPrimaries(stats []*PartitionStats, threshold time.Duration) []*PartitionStats {
+	var ret []*PartitionStats
+	for _, part := range stats {
+		if part.CollectedAt.IsZero() || time.Since(part.CollectedAt) > threshold {
+			continue
+		}
+		allZero := true
+		for name, value := range part.Stats {
+			if configDerivedStats[name] {
+				continue
+			}
+			if value != 0 {
+				allZero = false
+				break
+			}
+		}
+		if allZero {
+			ret = append(ret, part)
+		}
+	}
+	return ret
+}
@@ -0,0 +1,69 @@
+package aggregate
+
+import (
+	"testing"
+	"time"
+
+	"github.com/XiaoMi/pegasus-go-client/idl/base"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateStats(t *testing.T) {
+	errs := ValidateStats(map[string]float64{"get_qps": 10, "put_qps": 0})
+	assert.Empty(t, errs)
+
+	errs = ValidateStats(map[string]float64{"get_qps": -10, "put_qps": 0})
+	assert.Equal(t, errs, []ValidationError{{MetricName: "get_qps", Value: -10}})
+}
+
+func TestClampNegativeStats(t *testing.T) {
+	newPartitions := func() []*PartitionStats {
+		stats := map[string]float64{"get_qps": -10, "scan_qps": 5}
+		extendStats(&stats)
+		return []*PartitionStats{{Gpid: base.Gpid{Appid: 1, PartitionIndex: 0}, Stats: stats}}
+	}
+
+	pclient := &PerfClient{opts: DefaultPerfClientOptions()}
+	partitions := newPartitions()
+	pclient.validatePartitionStats(partitions)
+	assert.Equal(t, partitions[0].Stats["get_qps"], float64(-10))
+
+	pclient.opts.ClampNegativeStats = true
+	partitions = newPartitions()
+	pclient.validatePartitionStats(partitions)
+	assert.Equal(t, partitions[0].Stats["get_qps"], float64(0))
+	assert.Equal(t, partitions[0].Stats["read_qps"], float64(5))
+}
+
+func TestDetectZeroStatsPrimaries(t *testing.T) {
+	now := time.Now()
+	zero := &PartitionStats{Stats: map[string]float64{"get_qps": 0}, CollectedAt: now}
+	empty := &PartitionStats{Stats: map[string]float64{}, CollectedAt: now}
+	nonZero := &PartitionStats{Stats: map[string]float64{"get_qps": 0, "put_qps": 1}, CollectedAt: now}
+	stale := &PartitionStats{Stats: map[string]float64{"get_qps": 0}, CollectedAt: now.Add(-time.Minute)}
+	neverCollected := &PartitionStats{Stats: map[string]float64{"get_qps": 0}}
+
+	result := DetectZeroStatsPrimaries([]*PartitionStats{zero, empty, nonZero, stale, neverCollected}, 10*time.Second)
+	assert.Equal(t, result, []*PartitionStats{zero, empty})
+}
+
+func TestDetectZeroStatsPrimariesOfCollectedPartitions(t *testing.T) {
+	nodes := []*NodeStat{{
+		Addr: "127.0.0.1:34801",
+		Stats: map[string]float64{
+			"replica*app.pegasus*get_qps@1.0": 0,
+			"replica*app.pegasus*put_qps@1.0": 0,
+			"replica*app.pegasus*get_qps@1.1": 10,
+		},
+		CollectedAt: time.Now(),
+	}}
+	partitions := decodePartitionStats(nodes, nil)
+	for _, part := range partitions {
+		// injected by GetPartitionStats
+		part.getOrInitStats()["replica_count"] = 3
+	}
+
+	result := DetectZeroStatsPrimaries(partitions, 10*time.Second)
+	assert.Equal(t, len(result), 1)
+	assert.Equal(t, result[0].Gpid, base.Gpid{Appid: 1, PartitionIndex: 0})
+}
@@ -0,0 +1,427 @@
+package alert
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pegasus-kv/collector/aggregate"
+	"github.com/pegasus-kv/collector/election"
+	"github.com/pegasus-kv/collector/logging"
+	"github.com/pegasus-kv/collector/reload"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/spf13/viper"
+	"gopkg.in/tomb.v2"
+)
+
+var log = logging.Module("alert")
+
+// State is the state of an Alert.
+type State string
+
+// The states of the alerts.
+const (
+	// Pending means the condition holds, but not for the duration of the rule yet.
+	Pending State = "pending"
+	Firing  State = "firing"
+	// Resolved means the condition no longer holds after the alert fired.
+	Resolved State = "resolved"
+)
+
+// Alert is the state of a rule on an entity, i.e. a table, a node or the cluster.
+type Alert struct {
+	Cluster  string `json:"cluster"`
+	Rule     string `json:"rule"`
+	Severity string `json:"severity,omitempty"`
+	Scope    Scope  `json:"scope"`
+	Entity   string `json:"entity"`
+
+	Metric     string     `json:"metric"`
+	Comparison Comparison `json:"comparison"`
+	Threshold  float64    `json:"threshold"`
+	// the value in the latest round
+	Value float64 `json:"value"`
+
+	State State `json:"state"`
+	// the time when the condition started to hold
+	ActiveAt   time.Time `json:"active_at"`
+	FiredAt    time.Time `json:"fired_at"`
+	ResolvedAt time.Time `json:"resolved_at"`
+}
+
+type alertKey struct {
+	rule    string
+	cluster string
+	entity  string
+}
+
+// Engine evaluates the rules after every round of aggregation, and tracks the state of each
+// rule on each entity.
+type Engine struct {
+	rules []Rule
+	// the cluster of the stats that are not tagged
+	cluster string
+
+	firing *prometheus.GaugeVec
+
+	lock sync.RWMutex
+	// the pending and firing alerts
+	alerts map[alertKey]*Alert
+}
+
+// NewEngine returns an Engine of the rules, whose names must be unique.
+func NewEngine(rules []Rule, registerer prometheus.Registerer, cluster string) (*Engine, error) {
+	if err := validateRules(rules); err != nil {
+		return nil, err
+	}
+	e := &Engine{
+		rules:   rules,
+		cluster: cluster,
+		firing: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "alert_firing",
+			Help: "1 if the alert of the rule on the entity is firing.",
+		}, []string{"cluster", "rule", "severity", "scope", "entity"}),
+		alerts: make(map[alertKey]*Alert),
+	}
+	registerer.MustRegister(e.firing)
+	return e, nil
+}
+
+func validateRules(rules []Rule) error {
+	names := make(map[string]bool)
+	for i := range rules {
+		if err := rules[i].Validate(); err != nil {
+			return err
+		}
+		if names[rules[i].Name] {
+			return fmt.Errorf("duplicate rule %q", rules[i].Name)
+		}
+		names[rules[i].Name] = true
+	}
+	return nil
+}
+
+// SetRules replaces the rules of the Engine, whose names must be unique. The alerts of the
+// rules that are removed or changed are resolved, and the others keep their states.
+func (e *Engine) SetRules(rules []Rule) error {
+	if err := validateRules(rules); err != nil {
+		return err
+	}
+	kept := make(map[string]bool)
+	e.lock.Lock()
+	for _, old := range e.rules {
+		for _, r := range rules {
+			if r == old {
+				kept[r.Name] = true
+			}
+		}
+	}
+	var changed []Alert
+	now := time.Now()
+	for key := range e.alerts {
+		if kept[key.rule] {
+			continue
+		}
+		if a := e.resolve(key, now); a != nil {
+			changed = append(changed, *a)
+		}
+	}
+	e.rules = rules
+	e.lock.Unlock()
+
+	for _, a := range changed {
+		log.WithField("cluster", a.Cluster).Infof("alert %s on %s %s of %s is resolved since the rule has changed", a.Rule, a.Scope, a.Entity, a.Cluster)
+		hooks.afterAlertChanged(a)
+	}
+	return nil
+}
+
+// hasScope returns whether any rule applies to the scope.
+func (e *Engine) hasScope(scope Scope) bool {
+	e.lock.RLock()
+	defer e.lock.RUnlock()
+	for i := range e.rules {
+		if e.rules[i].Scope == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// Evaluate updates the alerts of the scope of the cluster by the stats of a round, given as the
+// name of each entity -> the stats. The alerts of the entities of the cluster absent from the
+// round are resolved, as well as the alerts whose metrics are absent. The alerts that fire or
+// resolve are passed to the hooks.
+func (e *Engine) Evaluate(cluster string, scope Scope, entities map[string]map[string]float64, now time.Time) {
+	var changed []Alert
+	e.lock.Lock()
+	for i := range e.rules {
+		rule := &e.rules[i]
+		if rule.Scope != scope {
+			continue
+		}
+		for entity, stats := range entities {
+			if !rule.applies(scope, cluster, entity) {
+				continue
+			}
+			key := alertKey{rule: rule.Name, cluster: cluster, entity: entity}
+			value, found := stats[rule.Metric]
+			if !found || !rule.holds(value) {
+				if a := e.resolve(key, now); a != nil {
+					changed = append(changed, *a)
+				}
+				continue
+			}
+			a := e.alerts[key]
+			if a == nil {
+				a = &Alert{
+					Cluster:    cluster,
+					Rule:       rule.Name,
+					Severity:   rule.Severity,
+					Scope:      scope,
+					Entity:     entity,
+					Metric:     rule.Metric,
+					Comparison: rule.Comparison,
+					Threshold:  rule.Threshold,
+					State:      Pending,
+					ActiveAt:   now,
+				}
+				e.alerts[key] = a
+			}
+			a.Value = value
+			if a.State == Pending && now.Sub(a.ActiveAt) >= rule.For {
+				a.State = Firing
+				a.FiredAt = now
+				e.firing.WithLabelValues(a.Cluster, a.Rule, a.Severity, string(a.Scope), a.Entity).Set(1)
+				changed = append(changed, *a)
+			}
+		}
+	}
+	for key, a := range e.alerts {
+		if _, found := entities[key.entity]; !found && a.Scope == scope && key.cluster == cluster {
+			if a := e.resolve(key, now); a != nil {
+				changed = append(changed, *a)
+			}
+		}
+	}
+	e.lock.Unlock()
+
+	for _, a := range changed {
+		if a.State == Firing {
+			log.WithField("cluster", a.Cluster).Warnf("alert %s fires on %s %s of %s: %s = %f %s %f", a.Rule, a.Scope, a.Entity, a.Cluster, a.Metric, a.Value, a.Comparison, a.Threshold)
+		} else {
+			log.WithField("cluster", a.Cluster).Infof("alert %s on %s %s of %s is resolved", a.Rule, a.Scope, a.Entity, a.Cluster)
+		}
+		hooks.afterAlertChanged(a)
+	}
+}
+
+// resolve removes the alert, and returns it if it was firing. It must be called with the
+// lock held.
+func (e *Engine) resolve(key alertKey, now time.Time) *Alert {
+	a, found := e.alerts[key]
+	if !found {
+		return nil
+	}
+	delete(e.alerts, key)
+	if a.State != Firing {
+		return nil
+	}
+	e.firing.DeleteLabelValues(a.Cluster, a.Rule, a.Severity, string(a.Scope), a.Entity)
+	a.State = Resolved
+	a.ResolvedAt = now
+	return a
+}
+
+// Alerts returns the pending and firing alerts, sorted by the rule, the cluster and the entity.
+func (e *Engine) Alerts() []Alert {
+	e.lock.RLock()
+	defer e.lock.RUnlock()
+	res := make([]Alert, 0, len(e.alerts))
+	for _, a := range e.alerts {
+		res = append(res, *a)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Rule != res[j].Rule {
+			return res[i].Rule < res[j].Rule
+		}
+		if res[i].Cluster != res[j].Cluster {
+			return res[i].Cluster < res[j].Cluster
+		}
+		return res[i].Entity < res[j].Entity
+	})
+	return res
+}
+
+// clusterOf returns the cluster that the stats are tagged with, or the configured one.
+func (e *Engine) clusterOf(tagged string) string {
+	if tagged != "" {
+		return tagged
+	}
+	return e.cluster
+}
+
+// evaluateRound evaluates the rules of the tables and the cluster.
+func (e *Engine) evaluateRound(stats []aggregate.TableStats, allStats aggregate.ClusterStats) {
+	now := allStats.Timestamp
+	if now.IsZero() {
+		now = time.Now()
+	}
+	cluster := e.clusterOf(allStats.Cluster)
+	if e.hasScope(ScopeTable) {
+		tables := make(map[string]map[string]float64, len(stats))
+		for _, tb := range stats {
+			tables[tb.TableName] = tb.Stats
+		}
+		e.Evaluate(cluster, ScopeTable, tables, now)
+	}
+	if e.hasScope(ScopeCluster) {
+		e.Evaluate(cluster, ScopeCluster, map[string]map[string]float64{cluster: allStats.Stats}, now)
+	}
+}
+
+func (e *Engine) evaluateNodes(nodes []aggregate.NodeStat) {
+	if len(nodes) == 0 {
+		// unknown cluster
+		return
+	}
+	// the nodes of a round are of the same cluster, collected at the same time
+	now := time.Now()
+	if !nodes[0].CollectedAt.IsZero() {
+		now = nodes[0].CollectedAt
+	}
+	entities := make(map[string]map[string]float64, len(nodes))
+	for _, n := range nodes {
+		entities[n.Addr] = n.Stats
+	}
+	e.Evaluate(e.clusterOf(nodes[0].Cluster), ScopeNode, entities, now)
+}
+
+// removeCluster resolves the alerts of the cluster that is no longer collected.
+func (e *Engine) removeCluster(cluster string) {
+	now := time.Now()
+	for _, scope := range []Scope{ScopeTable, ScopeNode, ScopeCluster} {
+		if e.hasScope(scope) {
+			e.Evaluate(cluster, scope, nil, now)
+		}
+	}
+}
+
+// HookAfterAlertChanged is a hook of event that an alert fires or is resolved.
+type HookAfterAlertChanged func(a Alert)
+
+// AddHookAfterAlertChanged adds a hook of event that an alert fires or is resolved.
+func AddHookAfterAlertChanged(hk HookAfterAlertChanged) {
+	hooks.lock.Lock()
+	defer hooks.lock.Unlock()
+	hooks.changed = append(hooks.changed, hk)
+}
+
+type hooksManager struct {
+	lock    sync.RWMutex
+	changed []HookAfterAlertChanged
+}
+
+var hooks hooksManager
+
+func (m *hooksManager) afterAlertChanged(a Alert) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	for _, hk := range m.changed {
+		hk(a)
+	}
+}
+
+var (
+	defaultLock   sync.RWMutex
+	defaultEngine *Engine
+	// the Notifiers of "alerting.channels"
+	notifiers []*Notifier
+
+	// whether the Engine is hooked to the node stats
+	nodesHooked bool
+)
+
+// Default returns the Engine run by Start, or nil if no rule has been configured.
+func Default() *Engine {
+	defaultLock.RLock()
+	defer defaultLock.RUnlock()
+	return defaultEngine
+}
+
+// RulesFromConfig parses the rules of "alerting.rules", each of which is like
+// `{name: slow_get, metric: get_p99_latency, scope: table, comparison: ">", threshold: 100000, for: 1m}`.
+func RulesFromConfig() ([]Rule, error) {
+	var rules []Rule
+	if err := viper.UnmarshalKey("alerting.rules", &rules); err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+// Start evaluates the rules of "alerting.rules" after every round of aggregation, and notifies
+// the channels of "alerting.channels" of the alerts, until the tomb dies. The rules and the
+// channels are reloaded once "alerting" changes.
+func Start(tom *tomb.Tomb) {
+	AddHookAfterAlertChanged(func(a Alert) {
+		// the standbys of the election don't notify twice
+		if election.IsLeader() {
+			defaultLock.RLock()
+			notifyAll(notifiers, a)
+			defaultLock.RUnlock()
+		}
+	})
+	if err := applyConfig(); err != nil {
+		log.Errorf("failed to start the alerting: %s", err)
+	}
+	reload.AddHookAfterChanged([]string{"alerting"}, applyConfig)
+	<-tom.Dying()
+}
+
+// applyConfig applies the rules and the channels of "alerting". The Engine is created on the
+// first rules, and it's kept once created. It's called by Start and then by the reloads,
+// which are serialized.
+func applyConfig() error {
+	rules, err := RulesFromConfig()
+	if err != nil {
+		return fmt.Errorf("failed to read the alerting rules: %s", err)
+	}
+	if err := validateRules(rules); err != nil {
+		return err
+	}
+	channels, err := ChannelsFromConfig()
+	if err != nil {
+		return fmt.Errorf("failed to read the alerting channels: %s", err)
+	}
+	ns, err := newNotifiers(channels)
+	if err != nil {
+		return err
+	}
+	defaultLock.Lock()
+	notifiers = ns
+	defaultLock.Unlock()
+
+	e := Default()
+	if e == nil {
+		if len(rules) == 0 {
+			return nil
+		}
+		if e, err = NewEngine(rules, prometheus.DefaultRegisterer, viper.GetString("cluster_name")); err != nil {
+			return err
+		}
+		aggregate.AddHookAfterClusterStatsEmitted(e.evaluateRound)
+		aggregate.AddHookAfterClusterRemoved(e.removeCluster)
+		defaultLock.Lock()
+		defaultEngine = e
+		defaultLock.Unlock()
+	} else if err := e.SetRules(rules); err != nil {
+		return err
+	}
+	// the node stats are aggregated only if they're hooked
+	if !nodesHooked && e.hasScope(ScopeNode) {
+		aggregate.AddHookAfterClusterNodeStatsEmitted(e.evaluateNodes)
+		nodesHooked = true
+	}
+	return nil
+}
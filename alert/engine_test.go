@@ -0,0 +1,173 @@
+package alert
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pegasus-kv/collector/aggregate"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEngine(t *testing.T) {
+	e, err := NewEngine([]Rule{
+		{Name: "slow_get", Metric: "get_p99_latency", Scope: ScopeTable, Comparison: GreaterThan, Threshold: 100, For: time.Minute},
+		{Name: "no_write", Metric: "write_qps", Scope: ScopeTable, Target: "stat", Comparison: LessOrEqual, Threshold: 0},
+	}, prometheus.NewRegistry(), "onebox")
+	assert.Nil(t, err)
+	var changed []Alert
+	AddHookAfterAlertChanged(func(a Alert) {
+		changed = append(changed, a)
+	})
+
+	start := time.Now()
+	e.Evaluate("onebox", ScopeTable, map[string]map[string]float64{
+		"stat": {"get_p99_latency": 200, "write_qps": 0},
+		"temp": {"get_p99_latency": 50, "write_qps": 0},
+	}, start)
+	// no_write fires at once, while slow_get is pending
+	alerts := e.Alerts()
+	assert.Equal(t, len(alerts), 2)
+	assert.Equal(t, alerts[0].Rule, "no_write")
+	assert.Equal(t, alerts[0].State, Firing)
+	assert.Equal(t, alerts[1].State, Pending)
+	assert.Equal(t, len(changed), 1)
+	assert.Equal(t, testutil.ToFloat64(e.firing.WithLabelValues("onebox", "no_write", "", "table", "stat")), float64(1))
+
+	e.Evaluate("onebox", ScopeTable, map[string]map[string]float64{
+		"stat": {"get_p99_latency": 300, "write_qps": 0},
+		"temp": {"get_p99_latency": 50, "write_qps": 0},
+	}, start.Add(time.Minute))
+	assert.Equal(t, len(changed), 2)
+	assert.Equal(t, changed[1].Rule, "slow_get")
+	assert.Equal(t, changed[1].State, Firing)
+	assert.Equal(t, changed[1].Value, float64(300))
+	assert.Equal(t, changed[1].ActiveAt, start)
+
+	// the table is dropped
+	e.Evaluate("onebox", ScopeTable, map[string]map[string]float64{
+		"temp": {"get_p99_latency": 50, "write_qps": 0},
+	}, start.Add(2*time.Minute))
+	assert.Equal(t, len(changed), 4)
+	for _, a := range changed[2:] {
+		assert.Equal(t, a.State, Resolved)
+		assert.Equal(t, a.ResolvedAt, start.Add(2*time.Minute))
+	}
+	assert.Equal(t, len(e.Alerts()), 0)
+	assert.Equal(t, testutil.CollectAndCount(e.firing), 0)
+
+	// the pending alert is resolved silently
+	e.Evaluate("onebox", ScopeTable, map[string]map[string]float64{"temp": {"get_p99_latency": 150}}, start.Add(3*time.Minute))
+	e.Evaluate("onebox", ScopeTable, map[string]map[string]float64{"temp": {}}, start.Add(4*time.Minute))
+	assert.Equal(t, len(e.Alerts()), 0)
+	assert.Equal(t, len(changed), 4)
+}
+
+func TestEngineInvalidRules(t *testing.T) {
+	for _, rules := range [][]Rule{
+		{{Name: "a", Metric: "get_qps", Scope: "partition", Comparison: GreaterThan}},
+		{{Name: "a", Metric: "get_qps", Scope: ScopeTable, Comparison: "=>"}},
+		{{Metric: "get_qps", Scope: ScopeTable, Comparison: GreaterThan}},
+		{
+			{Name: "a", Metric: "get_qps", Scope: ScopeTable, Comparison: GreaterThan},
+			{Name: "a", Metric: "set_qps", Scope: ScopeTable, Comparison: GreaterThan},
+		},
+	} {
+		_, err := NewEngine(rules, prometheus.NewRegistry(), "onebox")
+		assert.NotNil(t, err)
+	}
+}
+
+func TestRulesFromConfig(t *testing.T) {
+	viper.Set("alerting.rules", []map[string]interface{}{
+		{"name": "dead_nodes", "metric": "dead_node_count", "scope": "cluster", "comparison": ">", "threshold": 0, "for": "30s", "severity": "critical"},
+	})
+	defer viper.Set("alerting.rules", nil)
+	rules, err := RulesFromConfig()
+	assert.Nil(t, err)
+	assert.Equal(t, rules, []Rule{{Name: "dead_nodes", Metric: "dead_node_count", Scope: ScopeCluster,
+		Comparison: GreaterThan, For: 30 * time.Second, Severity: "critical"}})
+
+	e, err := NewEngine(rules, prometheus.NewRegistry(), "onebox")
+	assert.Nil(t, err)
+	e.Evaluate("onebox", ScopeCluster, map[string]map[string]float64{"onebox": {"dead_node_count": 1}}, time.Now())
+	assert.Equal(t, e.Alerts()[0].Entity, "onebox")
+}
+
+func TestEngineClusters(t *testing.T) {
+	e, err := NewEngine([]Rule{
+		{Name: "no_write", Metric: "write_qps", Scope: ScopeTable, Comparison: LessOrEqual, Threshold: 0},
+		{Name: "c2_dead_nodes", Metric: "dead_node_count", Scope: ScopeCluster, Cluster: "c2", Comparison: GreaterThan, Threshold: 0},
+	}, prometheus.NewRegistry(), "onebox")
+	assert.Nil(t, err)
+
+	now := time.Now()
+	e.evaluateRound([]aggregate.TableStats{{TableName: "stat", Stats: map[string]float64{"write_qps": 0}}},
+		aggregate.ClusterStats{Timestamp: now, Stats: map[string]float64{"dead_node_count": 1}})
+	e.evaluateRound([]aggregate.TableStats{{TableName: "stat", Stats: map[string]float64{"write_qps": 0}}},
+		aggregate.ClusterStats{Cluster: "c2", Timestamp: now, Stats: map[string]float64{"dead_node_count": 1}})
+	alerts := e.Alerts()
+	assert.Equal(t, len(alerts), 3)
+	assert.Equal(t, alerts[0].Rule, "c2_dead_nodes")
+	assert.Equal(t, alerts[0].Entity, "c2")
+	assert.Equal(t, alerts[1].Cluster, "c2")
+	assert.Equal(t, alerts[2].Cluster, "onebox")
+	assert.Equal(t, testutil.ToFloat64(e.firing.WithLabelValues("c2", "no_write", "", "table", "stat")), float64(1))
+
+	// the table dropped from a cluster doesn't resolve the alerts of the others
+	e.evaluateRound(nil, aggregate.ClusterStats{Timestamp: now, Stats: map[string]float64{}})
+	alerts = e.Alerts()
+	assert.Equal(t, len(alerts), 2)
+	for _, a := range alerts {
+		assert.Equal(t, a.Cluster, "c2")
+	}
+
+	// the alerts of the removed cluster are resolved
+	e.removeCluster("c2")
+	assert.Equal(t, len(e.Alerts()), 0)
+}
+
+func TestEngineSetRules(t *testing.T) {
+	e, err := NewEngine([]Rule{
+		{Name: "no_write", Metric: "write_qps", Scope: ScopeTable, Comparison: LessOrEqual, Threshold: 0},
+		{Name: "slow_get", Metric: "get_p99_latency", Scope: ScopeTable, Comparison: GreaterThan, Threshold: 100},
+		{Name: "dead_nodes", Metric: "dead_node_count", Scope: ScopeCluster, Comparison: GreaterThan, Threshold: 0},
+	}, prometheus.NewRegistry(), "onebox")
+	assert.Nil(t, err)
+	var changed []Alert
+	AddHookAfterAlertChanged(func(a Alert) {
+		changed = append(changed, a)
+	})
+	defer func() {
+		hooks = hooksManager{}
+	}()
+	now := time.Now()
+	e.evaluateRound([]aggregate.TableStats{{TableName: "stat", Stats: map[string]float64{"write_qps": 0, "get_p99_latency": 200}}},
+		aggregate.ClusterStats{Timestamp: now, Stats: map[string]float64{"dead_node_count": 1}})
+	assert.Equal(t, len(e.Alerts()), 3)
+	changed = nil
+
+	// invalid rules are rejected
+	assert.NotNil(t, e.SetRules([]Rule{{Name: "a", Metric: "get_qps", Scope: "partition", Comparison: GreaterThan}}))
+	assert.Equal(t, len(e.Alerts()), 3)
+
+	assert.Nil(t, e.SetRules([]Rule{
+		{Name: "no_write", Metric: "write_qps", Scope: ScopeTable, Comparison: LessOrEqual, Threshold: 0},
+		{Name: "slow_get", Metric: "get_p99_latency", Scope: ScopeTable, Comparison: GreaterThan, Threshold: 300},
+	}))
+	// the alerts of the changed and the removed rules are resolved
+	alerts := e.Alerts()
+	assert.Equal(t, len(alerts), 1)
+	assert.Equal(t, alerts[0].Rule, "no_write")
+	assert.Equal(t, len(changed), 2)
+	for _, a := range changed {
+		assert.Equal(t, a.State, Resolved)
+	}
+	assert.False(t, e.hasScope(ScopeCluster))
+
+	e.evaluateRound([]aggregate.TableStats{{TableName: "stat", Stats: map[string]float64{"write_qps": 0, "get_p99_latency": 200}}},
+		aggregate.ClusterStats{Timestamp: now, Stats: map[string]float64{"dead_node_count": 1}})
+	assert.Equal(t, len(e.Alerts()), 1)
+}
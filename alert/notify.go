@@ -0,0 +1,246 @@
+package alert
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// The types of the notification channels.
+const (
+	ChannelWebhook  = "webhook"
+	ChannelSlack    = "slack"
+	ChannelDingTalk = "dingtalk"
+)
+
+// DefaultTemplate is the message of an alert if the channel has no template.
+const DefaultTemplate = `[{{.State}}] {{.Cluster}} {{.Rule}}{{if .Severity}}({{.Severity}}){{end}} on {{.Scope}} {{.Entity}}: ` +
+	`{{.Metric}} = {{.Value}} {{.Comparison}} {{.Threshold}}`
+
+// ChannelConfig is the configuration of a notification channel.
+type ChannelConfig struct {
+	// The unique name of the channel.
+	Name string
+
+	// Any of webhook, slack and dingtalk.
+	Type string
+	URL  string
+
+	// The text/template of the message, executed with the Alert. DefaultTemplate is used if
+	// it's empty. For webhook, the executed template is posted as is, or the alert in JSON if
+	// it's empty.
+	Template string
+
+	// The secret to sign the requests of the DingTalk robot, if its security setting is "sign".
+	Secret string
+
+	// At most RateLimit notifications are sent per minute, and the others are dropped.
+	// 0 means unlimited.
+	RateLimit int `mapstructure:"rate_limit"`
+
+	// Notify once more when the alert is resolved, besides when it fires.
+	SendResolved bool `mapstructure:"send_resolved"`
+
+	// The timeout of each request, 10s by default.
+	Timeout time.Duration
+}
+
+// Validate checks the configuration.
+func (cfg *ChannelConfig) Validate() error {
+	if cfg.Name == "" {
+		return fmt.Errorf("the name of the notification channel of %q is required", cfg.URL)
+	}
+	if _, found := payloadBuilders[cfg.Type]; !found {
+		return fmt.Errorf("invalid type %q of notification channel %q, which should be any of webhook, slack and dingtalk", cfg.Type, cfg.Name)
+	}
+	if _, err := url.ParseRequestURI(cfg.URL); err != nil {
+		return fmt.Errorf("invalid URL of notification channel %q: %s", cfg.Name, err)
+	}
+	if cfg.RateLimit < 0 || cfg.Timeout < 0 {
+		return fmt.Errorf("negative rate limit or timeout of notification channel %q", cfg.Name)
+	}
+	return nil
+}
+
+// payloadBuilder returns the body posted to the channel, given the alert and its message.
+type payloadBuilder func(a *Alert, msg string, hasTemplate bool) ([]byte, error)
+
+var payloadBuilders = map[string]payloadBuilder{
+	ChannelWebhook: func(a *Alert, msg string, hasTemplate bool) ([]byte, error) {
+		if hasTemplate {
+			return []byte(msg), nil
+		}
+		return json.Marshal(a)
+	},
+	ChannelSlack: func(_ *Alert, msg string, _ bool) ([]byte, error) {
+		return json.Marshal(map[string]string{"text": msg})
+	},
+	ChannelDingTalk: func(_ *Alert, msg string, _ bool) ([]byte, error) {
+		return json.Marshal(map[string]interface{}{
+			"msgtype": "text",
+			"text":    map[string]string{"content": msg},
+		})
+	},
+}
+
+// Notifier posts the alerts to a channel.
+type Notifier struct {
+	cfg    ChannelConfig
+	tmpl   *template.Template
+	client *http.Client
+
+	lock sync.Mutex
+	// the times of the notifications sent in the last minute
+	sent []time.Time
+}
+
+// NewNotifier returns a Notifier of the channel.
+func NewNotifier(cfg ChannelConfig) (*Notifier, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	text := cfg.Template
+	if text == "" {
+		text = DefaultTemplate
+	}
+	tmpl, err := template.New(cfg.Name).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid template of notification channel %q: %s", cfg.Name, err)
+	}
+	if cfg.Timeout == 0 {
+		cfg.Timeout = 10 * time.Second
+	}
+	return &Notifier{
+		cfg:    cfg,
+		tmpl:   tmpl,
+		client: &http.Client{Timeout: cfg.Timeout},
+	}, nil
+}
+
+// allow returns whether a notification can be sent at the time under the rate limit, and
+// counts it if so.
+func (n *Notifier) allow(now time.Time) bool {
+	if n.cfg.RateLimit == 0 {
+		return true
+	}
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	i := 0
+	for i < len(n.sent) && now.Sub(n.sent[i]) >= time.Minute {
+		i++
+	}
+	n.sent = n.sent[i:]
+	if len(n.sent) >= n.cfg.RateLimit {
+		return false
+	}
+	n.sent = append(n.sent, now)
+	return true
+}
+
+// Notify posts the alert to the channel. The resolved alert is skipped unless SendResolved,
+// and the alert beyond the rate limit is dropped.
+func (n *Notifier) Notify(ctx context.Context, a Alert) error {
+	if a.State == Resolved && !n.cfg.SendResolved {
+		return nil
+	}
+	if !n.allow(time.Now()) {
+		log.Warnf("drop the notification of alert %s on %s to %s for the rate limit", a.Rule, a.Entity, n.cfg.Name)
+		return nil
+	}
+
+	var msg bytes.Buffer
+	if err := n.tmpl.Execute(&msg, &a); err != nil {
+		return err
+	}
+	body, err := payloadBuilders[n.cfg.Type](&a, msg.String(), n.cfg.Template != "")
+	if err != nil {
+		return err
+	}
+	target := n.cfg.URL
+	if n.cfg.Type == ChannelDingTalk && n.cfg.Secret != "" {
+		target = signDingTalk(target, n.cfg.Secret, time.Now())
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		respBody, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s responds %s: %s", n.cfg.Name, resp.Status, respBody)
+	}
+	return nil
+}
+
+// signDingTalk appends the timestamp and the signature to the URL of the DingTalk robot.
+func signDingTalk(target string, secret string, now time.Time) string {
+	timestamp := strconv.FormatInt(now.UnixNano()/int64(time.Millisecond), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "\n" + secret))
+	sign := base64.StdEncoding.EncodeToString(mac.Sum(nil))
+
+	sep := "?"
+	if u, err := url.Parse(target); err == nil && u.RawQuery != "" {
+		sep = "&"
+	}
+	return target + sep + "timestamp=" + timestamp + "&sign=" + url.QueryEscape(sign)
+}
+
+// ChannelsFromConfig parses the channels of "alerting.channels", each of which is like
+// `{name: ops, type: slack, url: "https://hooks.slack.com/services/...", rate_limit: 10, send_resolved: true}`.
+func ChannelsFromConfig() ([]ChannelConfig, error) {
+	var channels []ChannelConfig
+	if err := viper.UnmarshalKey("alerting.channels", &channels); err != nil {
+		return nil, err
+	}
+	return channels, nil
+}
+
+// newNotifiers returns the Notifiers of the channels, whose names must be unique.
+func newNotifiers(channels []ChannelConfig) ([]*Notifier, error) {
+	names := make(map[string]bool)
+	var notifiers []*Notifier
+	for _, cfg := range channels {
+		if names[cfg.Name] {
+			return nil, fmt.Errorf("duplicate notification channel %q", cfg.Name)
+		}
+		names[cfg.Name] = true
+		n, err := NewNotifier(cfg)
+		if err != nil {
+			return nil, err
+		}
+		notifiers = append(notifiers, n)
+	}
+	return notifiers, nil
+}
+
+// notifyAll posts the alert to every channel in the background, so that the aggregation is
+// never blocked by a slow channel.
+func notifyAll(notifiers []*Notifier, a Alert) {
+	for _, n := range notifiers {
+		go func(n *Notifier) {
+			if err := n.Notify(context.Background(), a); err != nil {
+				log.Errorf("failed to notify alert %s on %s to %s: %s", a.Rule, a.Entity, n.cfg.Name, err)
+			}
+		}(n)
+	}
+}
@@ -0,0 +1,133 @@
+package alert
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestChannel() (*httptest.Server, chan *http.Request, chan []byte) {
+	requests := make(chan *http.Request, 10)
+	bodies := make(chan []byte, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		requests <- r
+		bodies <- body
+	}))
+	return server, requests, bodies
+}
+
+var testAlert = Alert{
+	Cluster:    "onebox",
+	Rule:       "slow_get",
+	Severity:   "warning",
+	Scope:      ScopeTable,
+	Entity:     "stat",
+	Metric:     "get_p99_latency",
+	Comparison: GreaterThan,
+	Threshold:  100,
+	Value:      200,
+	State:      Firing,
+}
+
+func TestNotifierPayloads(t *testing.T) {
+	server, requests, bodies := newTestChannel()
+	defer server.Close()
+
+	n, err := NewNotifier(ChannelConfig{Name: "hook", Type: ChannelWebhook, URL: server.URL})
+	assert.Nil(t, err)
+	assert.Nil(t, n.Notify(context.Background(), testAlert))
+	<-requests
+	var a Alert
+	assert.Nil(t, json.Unmarshal(<-bodies, &a))
+	assert.Equal(t, a, testAlert)
+
+	n, err = NewNotifier(ChannelConfig{Name: "slack", Type: ChannelSlack, URL: server.URL})
+	assert.Nil(t, err)
+	assert.Nil(t, n.Notify(context.Background(), testAlert))
+	<-requests
+	var slack map[string]string
+	assert.Nil(t, json.Unmarshal(<-bodies, &slack))
+	assert.Equal(t, slack["text"], "[firing] onebox slow_get(warning) on table stat: get_p99_latency = 200 > 100")
+
+	n, err = NewNotifier(ChannelConfig{
+		Name:     "dingtalk",
+		Type:     ChannelDingTalk,
+		URL:      server.URL + "/robot/send?access_token=abc",
+		Template: "{{.Rule}} is {{.State}}",
+		Secret:   "SEC",
+	})
+	assert.Nil(t, err)
+	assert.Nil(t, n.Notify(context.Background(), testAlert))
+	r := <-requests
+	assert.Equal(t, r.URL.Query().Get("access_token"), "abc")
+	assert.NotEmpty(t, r.URL.Query().Get("timestamp"))
+	assert.NotEmpty(t, r.URL.Query().Get("sign"))
+	assert.Equal(t, string(<-bodies), `{"msgtype":"text","text":{"content":"slow_get is firing"}}`)
+}
+
+func TestNotifierResolvedAndRateLimit(t *testing.T) {
+	server, requests, _ := newTestChannel()
+	defer server.Close()
+
+	n, err := NewNotifier(ChannelConfig{Name: "hook", Type: ChannelWebhook, URL: server.URL, RateLimit: 2})
+	assert.Nil(t, err)
+	resolved := testAlert
+	resolved.State = Resolved
+	// skipped without SendResolved
+	assert.Nil(t, n.Notify(context.Background(), resolved))
+	for i := 0; i < 3; i++ {
+		assert.Nil(t, n.Notify(context.Background(), testAlert))
+	}
+	assert.Equal(t, len(requests), 2)
+
+	now := time.Now()
+	assert.True(t, n.allow(now.Add(time.Minute)))
+
+	n, err = NewNotifier(ChannelConfig{Name: "hook", Type: ChannelWebhook, URL: server.URL, SendResolved: true})
+	assert.Nil(t, err)
+	assert.Nil(t, n.Notify(context.Background(), resolved))
+	assert.Equal(t, len(requests), 3)
+}
+
+func TestNotifierError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "invalid token", http.StatusForbidden)
+	}))
+	defer server.Close()
+	n, err := NewNotifier(ChannelConfig{Name: "hook", Type: ChannelWebhook, URL: server.URL})
+	assert.Nil(t, err)
+	assert.NotNil(t, n.Notify(context.Background(), testAlert))
+}
+
+func TestInvalidChannels(t *testing.T) {
+	for _, channels := range [][]ChannelConfig{
+		{{Name: "a", Type: "email", URL: "http://127.0.0.1"}},
+		{{Name: "a", Type: ChannelSlack, URL: "hooks.slack.com"}},
+		{{Type: ChannelSlack, URL: "http://127.0.0.1"}},
+		{{Name: "a", Type: ChannelSlack, URL: "http://127.0.0.1", Template: "{{.Rule"}},
+		{{Name: "a", Type: ChannelSlack, URL: "http://127.0.0.1", RateLimit: -1}},
+		{
+			{Name: "a", Type: ChannelSlack, URL: "http://127.0.0.1"},
+			{Name: "a", Type: ChannelWebhook, URL: "http://127.0.0.1"},
+		},
+	} {
+		_, err := newNotifiers(channels)
+		assert.NotNil(t, err)
+	}
+}
+
+func TestSignDingTalk(t *testing.T) {
+	signed := signDingTalk("https://oapi.dingtalk.com/robot/send?access_token=abc", "SEC", time.Unix(1600000000, 0))
+	u, err := url.Parse(signed)
+	assert.Nil(t, err)
+	assert.Equal(t, u.Query().Get("timestamp"), "1600000000000")
+	assert.Equal(t, u.Query().Get("access_token"), "abc")
+}
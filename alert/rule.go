@@ -0,0 +1,105 @@
+package alert
+
+import (
+	"fmt"
+	"time"
+)
+
+// Scope is the kind of the entities that a rule applies to.
+type Scope string
+
+// The scopes of the rules.
+const (
+	ScopeTable   Scope = "table"
+	ScopeNode    Scope = "node"
+	ScopeCluster Scope = "cluster"
+)
+
+// Comparison is how the value of the metric is compared with the threshold.
+type Comparison string
+
+// The comparisons of the rules.
+const (
+	GreaterThan    Comparison = ">"
+	GreaterOrEqual Comparison = ">="
+	LessThan       Comparison = "<"
+	LessOrEqual    Comparison = "<="
+	Equal          Comparison = "=="
+	NotEqual       Comparison = "!="
+)
+
+// Rule is a threshold on a metric of the tables, the nodes or the cluster.
+type Rule struct {
+	// The unique name of the rule.
+	Name string
+
+	Metric string
+	Scope  Scope
+
+	// The table or the node that the rule applies to, or all of the scope if it's empty.
+	// It's ignored for the cluster.
+	Target string
+
+	// The cluster that the rule applies to, or all clusters collected if it's empty.
+	Cluster string
+
+	Comparison Comparison
+	Threshold  float64
+
+	// The alert fires only after the condition holds for the duration, or on the first round
+	// if it's 0.
+	For time.Duration
+
+	// e.g. "warning" or "critical", which is passed to the notifications.
+	Severity string
+}
+
+// Validate checks the rule.
+func (r *Rule) Validate() error {
+	if r.Name == "" {
+		return fmt.Errorf("the name of the rule of %q is required", r.Metric)
+	}
+	if r.Metric == "" {
+		return fmt.Errorf("the metric of rule %q is required", r.Name)
+	}
+	switch r.Scope {
+	case ScopeTable, ScopeNode, ScopeCluster:
+	default:
+		return fmt.Errorf("invalid scope %q of rule %q, which should be any of table, node and cluster", r.Scope, r.Name)
+	}
+	switch r.Comparison {
+	case GreaterThan, GreaterOrEqual, LessThan, LessOrEqual, Equal, NotEqual:
+	default:
+		return fmt.Errorf("invalid comparison %q of rule %q, which should be any of > >= < <= == !=", r.Comparison, r.Name)
+	}
+	if r.For < 0 {
+		return fmt.Errorf("negative duration of rule %q", r.Name)
+	}
+	return nil
+}
+
+// applies returns whether the rule applies to the entity of the scope in the cluster.
+func (r *Rule) applies(scope Scope, cluster string, entity string) bool {
+	if r.Scope != scope || (r.Cluster != "" && r.Cluster != cluster) {
+		return false
+	}
+	return scope == ScopeCluster || r.Target == "" || r.Target == entity
+}
+
+// holds returns whether the value satisfies the condition of the rule.
+func (r *Rule) holds(value float64) bool {
+	switch r.Comparison {
+	case GreaterThan:
+		return value > r.Threshold
+	case GreaterOrEqual:
+		return value >= r.Threshold
+	case LessThan:
+		return value < r.Threshold
+	case LessOrEqual:
+		return value <= r.Threshold
+	case Equal:
+		return value == r.Threshold
+	default:
+		return value != r.Threshold
+	}
+}
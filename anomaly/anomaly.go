@@ -0,0 +1,110 @@
+// Package anomaly flags the sudden spikes and drops of the metrics of the tables, by comparing
+// them with the profiles learned from the history, rather than the manual thresholds.
+package anomaly
+
+import (
+	"sync"
+	"time"
+
+	"github.com/pegasus-kv/collector/aggregate"
+	"github.com/pegasus-kv/collector/logging"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/spf13/viper"
+	"gopkg.in/tomb.v2"
+)
+
+var log = logging.Module("anomaly")
+
+// HookAfterAnomalyChanged is a hook of event that an anomaly is detected or resolved.
+type HookAfterAnomalyChanged func(a Anomaly)
+
+// AddHookAfterAnomalyChanged adds a hook of event that an anomaly is detected or resolved.
+func AddHookAfterAnomalyChanged(hk HookAfterAnomalyChanged) {
+	hooks.lock.Lock()
+	defer hooks.lock.Unlock()
+	hooks.changed = append(hooks.changed, hk)
+}
+
+type hooksManager struct {
+	lock    sync.RWMutex
+	changed []HookAfterAnomalyChanged
+}
+
+var hooks hooksManager
+
+func (m *hooksManager) afterAnomalyChanged(a Anomaly) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	for _, hk := range m.changed {
+		hk(a)
+	}
+}
+
+// observeRound learns the stats of the tables after a round of aggregation.
+func (d *Detector) observeRound(stats []aggregate.TableStats, allStats aggregate.ClusterStats) {
+	now := allStats.Timestamp
+	if now.IsZero() {
+		now = time.Now()
+	}
+	cluster := allStats.Cluster
+	if cluster == "" {
+		cluster = d.cluster
+	}
+	tables := make(map[string]map[string]float64, len(stats))
+	for _, tb := range stats {
+		tables[tb.TableName] = tb.Stats
+	}
+	for _, a := range d.Observe(cluster, tables, now) {
+		if a.Resolved {
+			log.Infof("the %s of %s %s of %s is resolved, which is %f now", a.Direction, a.Table, a.Metric, a.Cluster, a.Value)
+		} else {
+			log.Warnf("the %s of %s %s of %s is detected: %f, while the mean is %f and the stddev is %f", a.Direction, a.Table, a.Metric, a.Cluster, a.Value, a.Mean, a.Stddev)
+		}
+		hooks.afterAnomalyChanged(a)
+	}
+}
+
+var (
+	defaultLock     sync.RWMutex
+	defaultDetector *Detector
+)
+
+// Default returns the Detector run by Start, or nil if the detection is disabled.
+func Default() *Detector {
+	defaultLock.RLock()
+	defer defaultLock.RUnlock()
+	return defaultDetector
+}
+
+// Start detects the anomalies after every round of aggregation of every cluster if
+// "anomaly.enabled" is true, until the tomb dies.
+func Start(tom *tomb.Tomb) {
+	if !viper.GetBool("anomaly.enabled") {
+		return
+	}
+	viper.SetDefault("anomaly.metrics", []string{"read_qps", "write_qps"})
+	viper.SetDefault("anomaly.alpha", 0.1)
+	viper.SetDefault("anomaly.threshold", 3)
+	viper.SetDefault("anomaly.min_change", 0.5)
+	viper.SetDefault("anomaly.warmup", 30)
+	d, err := NewDetector(Config{
+		Metrics:   viper.GetStringSlice("anomaly.metrics"),
+		Alpha:     viper.GetFloat64("anomaly.alpha"),
+		Threshold: viper.GetFloat64("anomaly.threshold"),
+		MinChange: viper.GetFloat64("anomaly.min_change"),
+		Warmup:    viper.GetInt("anomaly.warmup"),
+	}, prometheus.DefaultRegisterer, viper.GetString("cluster_name"))
+	if err != nil {
+		log.Errorf("failed to start the anomaly detection: %s", err)
+		return
+	}
+	aggregate.AddHookAfterClusterStatsEmitted(d.observeRound)
+	aggregate.AddHookAfterClusterRemoved(func(cluster string) {
+		// the profiles of the removed cluster are dropped
+		d.Observe(cluster, nil, time.Now())
+	})
+	defaultLock.Lock()
+	defaultDetector = d
+	defaultLock.Unlock()
+	<-tom.Dying()
+}
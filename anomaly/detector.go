@@ -0,0 +1,263 @@
+package anomaly
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Direction tells whether the value is far above or below the normal profile.
+type Direction string
+
+// The directions of the anomalies.
+const (
+	Spike Direction = "spike"
+	Drop  Direction = "drop"
+)
+
+// Config is the configuration of Detector.
+type Config struct {
+	// The metrics of the tables to learn, e.g. read_qps and write_qps.
+	Metrics []string
+
+	// The weight of the latest value in the EWMA, in (0, 1]. The larger it is, the faster the
+	// profile follows the changes.
+	Alpha float64
+
+	// A value is anomalous if it's more than Threshold standard deviations away from the mean.
+	Threshold float64
+
+	// And if it differs from the mean by more than the ratio of the mean, so that the tiny
+	// changes of the stable metrics are not flagged.
+	MinChange float64 `mapstructure:"min_change"`
+
+	// The number of the rounds learned before any anomaly is flagged.
+	Warmup int
+}
+
+// Validate checks the configuration.
+func (cfg *Config) Validate() error {
+	if len(cfg.Metrics) == 0 {
+		return fmt.Errorf("no metric for the anomaly detection")
+	}
+	if cfg.Alpha <= 0 || cfg.Alpha > 1 {
+		return fmt.Errorf("invalid alpha of the anomaly detection: %f, which should be in (0, 1]", cfg.Alpha)
+	}
+	if cfg.Threshold <= 0 || cfg.MinChange < 0 || cfg.Warmup < 0 {
+		return fmt.Errorf("invalid threshold, min change or warmup of the anomaly detection")
+	}
+	return nil
+}
+
+// Anomaly is a metric of a table deviating from its normal profile, or recovering from it.
+type Anomaly struct {
+	Cluster   string    `json:"cluster"`
+	Table     string    `json:"table"`
+	Metric    string    `json:"metric"`
+	Direction Direction `json:"direction"`
+
+	Value float64 `json:"value"`
+	// the profile before the value
+	Mean   float64 `json:"mean"`
+	Stddev float64 `json:"stddev"`
+	ZScore float64 `json:"zscore"`
+
+	// Resolved is true if the metric goes back to normal.
+	Resolved   bool      `json:"resolved"`
+	DetectedAt time.Time `json:"detected_at"`
+}
+
+// profile is the exponentially weighted moving average and variance of a metric.
+type profile struct {
+	mean     float64
+	variance float64
+	count    int
+
+	// the ongoing anomaly, if any
+	anomaly *Anomaly
+}
+
+// update learns the value.
+func (p *profile) update(value float64, alpha float64) {
+	p.count++
+	if p.count == 1 {
+		p.mean = value
+		return
+	}
+	diff := value - p.mean
+	incr := alpha * diff
+	p.mean += incr
+	p.variance = (1 - alpha) * (p.variance + diff*incr)
+}
+
+// anomalousWeight scales the alpha to learn the anomalous values, so that an outlier doesn't
+// inflate the stddev to hide itself on the next round, while the profile still adapts to a
+// sustained shift, e.g. the traffic migrated from another table.
+const anomalousWeight = 0.1
+
+type profileKey struct {
+	cluster string
+	table   string
+	metric  string
+}
+
+// Detector learns the normal profile of each metric of each table, and flags the values
+// deviating from it.
+type Detector struct {
+	cfg Config
+	// the cluster of the stats that are not tagged
+	cluster string
+
+	flag *prometheus.GaugeVec
+
+	lock     sync.RWMutex
+	profiles map[profileKey]*profile
+}
+
+// NewDetector returns a Detector.
+func NewDetector(cfg Config, registerer prometheus.Registerer, cluster string) (*Detector, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	d := &Detector{
+		cfg:     cfg,
+		cluster: cluster,
+		flag: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "table_anomaly",
+			Help: "1 if the metric of the table deviates from its normal profile.",
+		}, []string{"cluster", "table", "metric", "direction"}),
+		profiles: make(map[profileKey]*profile),
+	}
+	registerer.MustRegister(d.flag)
+	return d, nil
+}
+
+// Observe learns the stats of a round of the cluster, given as the table name -> the stats, and
+// returns the anomalies detected or resolved. The profiles of the tables of the cluster absent
+// from the round are dropped, and so are their anomalies.
+func (d *Detector) Observe(cluster string, tables map[string]map[string]float64, now time.Time) []Anomaly {
+	var changed []Anomaly
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	for table, stats := range tables {
+		for _, metric := range d.cfg.Metrics {
+			value, found := stats[metric]
+			if !found {
+				continue
+			}
+			key := profileKey{cluster: cluster, table: table, metric: metric}
+			p := d.profiles[key]
+			if p == nil {
+				p = &profile{}
+				d.profiles[key] = p
+			}
+			if a := d.check(key, p, value, now); a != nil {
+				changed = append(changed, *a)
+			}
+			if p.anomaly != nil {
+				p.update(value, d.cfg.Alpha*anomalousWeight)
+			} else {
+				p.update(value, d.cfg.Alpha)
+			}
+		}
+	}
+	for key, p := range d.profiles {
+		if _, found := tables[key.table]; found || key.cluster != cluster {
+			continue
+		}
+		if p.anomaly != nil {
+			d.flag.DeleteLabelValues(key.cluster, key.table, key.metric, string(p.anomaly.Direction))
+		}
+		delete(d.profiles, key)
+	}
+	sort.Slice(changed, func(i, j int) bool {
+		if changed[i].Table != changed[j].Table {
+			return changed[i].Table < changed[j].Table
+		}
+		return changed[i].Metric < changed[j].Metric
+	})
+	return changed
+}
+
+// check compares the value with the profile learned so far, and returns the anomaly if it
+// starts or ends. It must be called with the lock held.
+func (d *Detector) check(key profileKey, p *profile, value float64, now time.Time) *Anomaly {
+	if p.count < d.cfg.Warmup || p.count == 0 {
+		return nil
+	}
+	stddev := math.Sqrt(p.variance)
+	diff := value - p.mean
+	zscore := 0.0
+	if stddev > 0 {
+		zscore = diff / stddev
+	} else if diff != 0 {
+		zscore = math.Copysign(math.Inf(1), diff)
+	}
+	anomalous := math.Abs(zscore) > d.cfg.Threshold && math.Abs(diff) > d.cfg.MinChange*math.Abs(p.mean)
+	direction := Spike
+	if diff < 0 {
+		direction = Drop
+	}
+
+	prev := p.anomaly
+	if anomalous && prev != nil && prev.Direction == direction {
+		prev.Value = value
+		prev.ZScore = zscore
+		return nil
+	}
+	if prev != nil {
+		d.flag.DeleteLabelValues(key.cluster, key.table, key.metric, string(prev.Direction))
+		p.anomaly = nil
+	}
+	if !anomalous {
+		if prev == nil {
+			return nil
+		}
+		resolved := *prev
+		resolved.Value = value
+		resolved.Resolved = true
+		resolved.DetectedAt = now
+		return &resolved
+	}
+	// an anomaly in the other direction replaces the previous one
+	p.anomaly = &Anomaly{
+		Cluster:    key.cluster,
+		Table:      key.table,
+		Metric:     key.metric,
+		Direction:  direction,
+		Value:      value,
+		Mean:       p.mean,
+		Stddev:     stddev,
+		ZScore:     zscore,
+		DetectedAt: now,
+	}
+	d.flag.WithLabelValues(key.cluster, key.table, key.metric, string(direction)).Set(1)
+	a := *p.anomaly
+	return &a
+}
+
+// Anomalies returns the ongoing anomalies, sorted by the cluster, the table and the metric.
+func (d *Detector) Anomalies() []Anomaly {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+	res := []Anomaly{}
+	for _, p := range d.profiles {
+		if p.anomaly != nil {
+			res = append(res, *p.anomaly)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Cluster != res[j].Cluster {
+			return res[i].Cluster < res[j].Cluster
+		}
+		if res[i].Table != res[j].Table {
+			return res[i].Table < res[j].Table
+		}
+		return res[i].Metric < res[j].Metric
+	})
+	return res
+}
@@ -0,0 +1,89 @@
+package anomaly
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProfile(t *testing.T) {
+	p := &profile{}
+	for _, v := range []float64{10, 10, 10} {
+		p.update(v, 0.5)
+	}
+	assert.Equal(t, p.mean, float64(10))
+	assert.Equal(t, p.variance, float64(0))
+	p.update(20, 0.5)
+	assert.Equal(t, p.mean, float64(15))
+	assert.Equal(t, p.variance, float64(25))
+}
+
+func TestDetector(t *testing.T) {
+	d, err := NewDetector(Config{
+		Metrics:   []string{"read_qps"},
+		Alpha:     0.1,
+		Threshold: 3,
+		MinChange: 0.5,
+		Warmup:    10,
+	}, prometheus.NewRegistry(), "onebox")
+	assert.Nil(t, err)
+
+	now := time.Now()
+	round := func(stat float64) []Anomaly {
+		now = now.Add(10 * time.Second)
+		return d.Observe("onebox", map[string]map[string]float64{
+			"stat": {"read_qps": stat},
+			"temp": {"read_qps": 100},
+		}, now)
+	}
+	// learn the profile around 1000, with no anomaly during the warmup
+	for i := 0; i < 50; i++ {
+		assert.Nil(t, round(float64(1000+i%3*10)))
+	}
+	// the tiny changes of the stable table are ignored
+	assert.Nil(t, round(1040))
+
+	changed := round(10)
+	assert.Equal(t, len(changed), 1)
+	assert.Equal(t, changed[0].Table, "stat")
+	assert.Equal(t, changed[0].Direction, Drop)
+	assert.False(t, changed[0].Resolved)
+	assert.True(t, changed[0].ZScore < -3)
+	assert.Equal(t, testutil.ToFloat64(d.flag.WithLabelValues("onebox", "stat", "read_qps", "drop")), float64(1))
+	assert.Equal(t, len(d.Anomalies()), 1)
+
+	// still dropping
+	assert.Nil(t, round(20))
+	assert.Equal(t, d.Anomalies()[0].Value, float64(20))
+
+	changed = round(1000)
+	assert.Equal(t, len(changed), 1)
+	assert.True(t, changed[0].Resolved)
+	assert.Equal(t, len(d.Anomalies()), 0)
+	assert.Equal(t, testutil.CollectAndCount(d.flag), 0)
+
+	// the anomaly of the dropped table is forgotten
+	d.profiles[profileKey{cluster: "onebox", table: "stat", metric: "read_qps"}].anomaly = &Anomaly{Direction: Spike}
+	// but not by the round of another cluster
+	d.Observe("c2", map[string]map[string]float64{"temp": {"read_qps": 100}}, now)
+	assert.Equal(t, len(d.profiles), 3)
+	d.Observe("onebox", map[string]map[string]float64{"temp": {"read_qps": 100}}, now)
+	assert.Equal(t, len(d.profiles), 2)
+	assert.Equal(t, len(d.Anomalies()), 0)
+}
+
+func TestDetectorInvalidConfig(t *testing.T) {
+	for _, cfg := range []Config{
+		{Alpha: 0.1, Threshold: 3},
+		{Metrics: []string{"read_qps"}, Alpha: 0, Threshold: 3},
+		{Metrics: []string{"read_qps"}, Alpha: 1.5, Threshold: 3},
+		{Metrics: []string{"read_qps"}, Alpha: 0.1, Threshold: 0},
+		{Metrics: []string{"read_qps"}, Alpha: 0.1, Threshold: 3, Warmup: -1},
+	} {
+		_, err := NewDetector(cfg, prometheus.NewRegistry(), "onebox")
+		assert.NotNil(t, err)
+	}
+}
@@ -0,0 +1,99 @@
+package avail
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/XiaoMi/pegasus-go-client/idl/admin"
+	"github.com/XiaoMi/pegasus-go-client/idl/base"
+	"github.com/XiaoMi/pegasus-go-client/idl/replication"
+)
+
+// metaClient is the operations of session.MetaManager used by the detector.
+type metaClient interface {
+	QueryConfig(ctx context.Context, tableName string) (*replication.QueryCfgResponse, error)
+	CreateApp(ctx context.Context, req *admin.CreateAppRequest) (*admin.CreateAppResponse, error)
+}
+
+// The interval to check whether the created table is ready.
+var tableReadyCheckInterval = time.Second
+
+// ensureTable creates the detect table if it doesn't exist and Config.CreateTable is true, and
+// waits until every partition of the table has a primary.
+func (d *pegasusDetector) ensureTable(ctx context.Context) error {
+	resp, err := d.meta.QueryConfig(ctx, d.cfg.TableName)
+	if err != nil {
+		return err
+	}
+	switch errno := errnoOf(resp); errno {
+	case base.ERR_OK.String():
+		return nil
+	case base.ERR_OBJECT_NOT_FOUND.String(), base.ERR_APP_NOT_EXIST.String():
+		if !d.cfg.CreateTable {
+			return fmt.Errorf("the detect table %s doesn't exist", d.cfg.TableName)
+		}
+	default:
+		return fmt.Errorf("failed to query the config of table %s: %s", d.cfg.TableName, errno)
+	}
+
+	log.Infof("creating the detect table %s with %d partitions and %d replicas",
+		d.cfg.TableName, d.cfg.PartitionCount, d.cfg.ReplicaCount)
+	_, err = d.meta.CreateApp(ctx, &admin.CreateAppRequest{
+		AppName: d.cfg.TableName,
+		Options: &admin.CreateAppOptions{
+			PartitionCount: int32(d.cfg.PartitionCount),
+			ReplicaCount:   int32(d.cfg.ReplicaCount),
+			AppType:        "pegasus",
+			Envs:           make(map[string]string),
+			IsStateful:     true,
+		},
+	})
+	// the table may be created by another collector meanwhile
+	if err != nil && !isAppExist(err) {
+		return fmt.Errorf("failed to create the detect table %s: %s", d.cfg.TableName, err)
+	}
+
+	ticker := time.NewTicker(tableReadyCheckInterval)
+	defer ticker.Stop()
+	for {
+		resp, err := d.meta.QueryConfig(ctx, d.cfg.TableName)
+		if err == nil && tableReady(resp) {
+			log.WithField("table", d.cfg.TableName).Infof("the detect table %s is ready", d.cfg.TableName)
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("the created table %s isn't ready: %s", d.cfg.TableName, ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
+
+// tableReady returns whether every partition of the table has a primary.
+func tableReady(resp *replication.QueryCfgResponse) bool {
+	if errnoOf(resp) != base.ERR_OK.String() || len(resp.Partitions) != int(resp.PartitionCount) {
+		return false
+	}
+	for _, p := range resp.Partitions {
+		if p.Primary == nil || p.Primary.GetRawAddress() == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// errnoOf returns the error of the response, which is ERR_OK if it's absent.
+func errnoOf(resp *replication.QueryCfgResponse) string {
+	if resp.Err == nil {
+		return base.ERR_OK.String()
+	}
+	return resp.Err.Errno
+}
+
+// isAppExist returns whether CreateApp fails since the table exists, which is only told by the
+// message of the error.
+func isAppExist(err error) bool {
+	return strings.Contains(err.Error(), base.ERR_APP_EXIST.String())
+}
@@ -1,114 +1,436 @@
 package avail
 
 import (
+	"bytes"
 	"context"
-	"sync/atomic"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
 	"time"
 
+	"github.com/XiaoMi/pegasus-go-client/idl/base"
 	"github.com/XiaoMi/pegasus-go-client/pegasus"
-	log "github.com/sirupsen/logrus"
+	"github.com/XiaoMi/pegasus-go-client/session"
+	"github.com/pegasus-kv/collector/election"
+	"github.com/pegasus-kv/collector/logging"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/spf13/viper"
+	"gopkg.in/tomb.v2"
 )
 
+var log = logging.Module("avail")
+
 // Detector periodically checks the service availability of the Pegasus cluster.
 type Detector interface {
 
 	// Start detection until the ctx cancelled. This method will block the current thread.
 	Start(ctx context.Context) error
+
+	// Report returns the availability of the recent probes.
+	Report() Report
+}
+
+// Config is the configuration of the availability detection.
+type Config struct {
+	// The dedicated table to probe.
+	TableName string
+
+	// The meta servers to query the partition count of the table.
+	MetaServers []string
+
+	// Whether to create the table with the partition count and the replica count if it doesn't
+	// exist.
+	CreateTable    bool
+	PartitionCount int
+	ReplicaCount   int
+
+	// The interval between the probes.
+	Interval time.Duration
+
+	// The timeout of each probe, which is a failure if exceeded.
+	Timeout time.Duration
+}
+
+// Report is the availability of the recent probes.
+type Report struct {
+	Windows map[Window]WindowStats `json:"windows"`
+
+	// The p99 latency of the probes of the last minute.
+	LatencyP99 time.Duration `json:"latency_p99"`
+
+	// The latest results of the partitions, sorted by the partition index.
+	Partitions []PartitionStatus `json:"partitions"`
+}
+
+// UnavailablePartitions returns the indexes of the partitions whose latest probes failed.
+func (r *Report) UnavailablePartitions() []int {
+	var res []int
+	for _, p := range r.Partitions {
+		if p.ProbedAt != (time.Time{}) && !p.Available {
+			res = append(res, p.PartitionIndex)
+		}
+	}
+	return res
+}
+
+// table is the operations of pegasus.TableConnector used by the probes.
+type table interface {
+	Set(ctx context.Context, hashKey []byte, sortKey []byte, value []byte) error
+	Get(ctx context.Context, hashKey []byte, sortKey []byte) ([]byte, error)
+	Del(ctx context.Context, hashKey []byte, sortKey []byte) error
 }
 
-// NewDetector returns a service-availability detector.
-func NewDetector(client pegasus.Client) Detector {
-	return &pegasusDetector{client: client}
+// The interval to refresh the partition count of the table, which changes after the split.
+const partitionCountRefreshInterval = time.Minute
+
+// NewDetector returns a service-availability detector, whose metrics are registered into
+// `registerer`.
+func NewDetector(client pegasus.Client, cfg Config, registerer prometheus.Registerer, cluster string) Detector {
+	d := &pegasusDetector{
+		client:  client,
+		cfg:     cfg,
+		cluster: cluster,
+		now:     time.Now,
+		detects: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "available_detect_total",
+			Help: "The number of probes to the detect table.",
+		}, []string{"cluster"}),
+		failures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "available_detect_failures_total",
+			Help: "The number of failed probes to the detect table.",
+		}, []string{"cluster"}),
+		ratios: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "available_ratio",
+			Help: "The ratio of the successful probes of the recent window.",
+		}, []string{"cluster", "window"}),
+		latencyP99: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "available_detect_latency_p99_seconds",
+			Help: "The p99 latency of the probes of the last minute.",
+		}, []string{"cluster"}),
+		partitionUp: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "available_partition_up",
+			Help: "Whether the latest probe to the partition of the detect table succeeded.",
+		}, []string{"cluster", "partition"}),
+		partitionFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "available_partition_detect_failures_total",
+			Help: "The number of failed probes to the partition of the detect table.",
+		}, []string{"cluster", "partition"}),
+	}
+	d.hashKeyPrefix = "detect_available_" + hostname()
+	registerer.MustRegister(d.detects, d.failures, d.ratios, d.latencyP99, d.partitionUp, d.partitionFailures)
+	return d
 }
 
 type pegasusDetector struct {
 	// client reads and writes periodically to a specified table.
 	client      pegasus.Client
-	detectTable pegasus.TableConnector
+	detectTable table
+	meta        metaClient
 
-	detectInterval  time.Duration
-	detectTableName string
+	cfg     Config
+	cluster string
 
-	// timeout of a single detect
-	detectTimeout time.Duration
+	hashKeyPrefix string
+	// the hash key routed to each partition, indexed by the partition index
+	hashKeys            [][]byte
+	partitionsRefreshed time.Time
 
-	detectHashKeys [][]byte
+	windows probeWindows
+	now     func() time.Time
 
-	recentMinuteDetectTimes  uint64
-	recentMinuteFailureTimes uint64
+	// rolls up the probes into the SLA if it's not nil
+	sla *SLARecorder
 
-	recentHourDetectTimes  uint64
-	recentHourFailureTimes uint64
+	// the probes are skipped unless it returns true, e.g. on the standby collectors, if it's
+	// not nil
+	active func() bool
 
-	recentDayDetectTimes  uint64
-	recentDayFailureTimes uint64
+	lock sync.RWMutex
+	// the latest result of each partition, indexed by the partition index
+	partitions []PartitionStatus
+
+	detects    *prometheus.CounterVec
+	failures   *prometheus.CounterVec
+	ratios     *prometheus.GaugeVec
+	latencyP99 *prometheus.GaugeVec
+
+	partitionUp       *prometheus.GaugeVec
+	partitionFailures *prometheus.CounterVec
 }
 
 func (d *pegasusDetector) Start(rootCtx context.Context) error {
-	var err error
-	ctx, _ := context.WithTimeout(rootCtx, 10*time.Second)
-	d.detectTable, err = d.client.OpenTable(ctx, d.detectTableName)
+	if d.meta == nil {
+		meta := session.NewMetaManager(d.cfg.MetaServers, session.NewNodeSession)
+		defer meta.Close()
+		d.meta = meta
+	}
+	ctx, cancel := context.WithTimeout(rootCtx, time.Minute)
+	err := d.ensureTable(ctx)
+	cancel()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel = context.WithTimeout(rootCtx, 10*time.Second)
+	tb, err := d.client.OpenTable(ctx, d.cfg.TableName)
+	cancel()
 	if err != nil {
 		return err
 	}
+	d.detectTable = tb
+	if err := d.refreshPartitions(rootCtx); err != nil {
+		return err
+	}
 
-	ticker := time.NewTicker(d.detectInterval)
+	ticker := time.NewTicker(d.cfg.Interval)
+	defer ticker.Stop()
 	for {
 		select {
 		case <-rootCtx.Done(): // check if context cancelled
 			return nil
 		case <-ticker.C:
-			return nil
-		default:
+		}
+		if d.active != nil && !d.active() {
+			continue
 		}
 
-		// periodically set/get a configured Pegasus table.
-		d.detect();
+		// periodically set/get/del a configured Pegasus table.
+		d.detect(rootCtx)
+	}
+}
+
+// refreshPartitions generates the hash keys of the partitions, if the partition count changes.
+func (d *pegasusDetector) refreshPartitions(rootCtx context.Context) error {
+	ctx, cancel := context.WithTimeout(rootCtx, 10*time.Second)
+	defer cancel()
+	resp, err := d.meta.QueryConfig(ctx, d.cfg.TableName)
+	if err != nil {
+		return err
+	}
+	if errno := errnoOf(resp); errno != base.ERR_OK.String() {
+		return fmt.Errorf("failed to query the config of table %s: %s", d.cfg.TableName, errno)
+	}
+	d.partitionsRefreshed = d.now()
+	count := int(resp.PartitionCount)
+	if count == len(d.hashKeys) {
+		return nil
+	}
+	if count <= 0 {
+		return fmt.Errorf("invalid partition count of table %s: %d", d.cfg.TableName, count)
 	}
+	log.WithField("table", d.cfg.TableName).Infof("probing the %d partitions of table %s for availability", count, d.cfg.TableName)
+	d.hashKeys = generateHashKeys(d.hashKeyPrefix, count)
+	partitions := make([]PartitionStatus, count)
+	for i := range partitions {
+		partitions[i].PartitionIndex = i
+	}
+	d.lock.Lock()
+	d.partitions = partitions
+	d.lock.Unlock()
+	d.partitionUp.Reset()
 	return nil
 }
 
+// detect probes every partition of the table once, and updates the metrics.
+func (d *pegasusDetector) detect(rootCtx context.Context) {
+	if d.now().Sub(d.partitionsRefreshed) >= partitionCountRefreshInterval {
+		if err := d.refreshPartitions(rootCtx); err != nil {
+			log.WithField("table", d.cfg.TableName).Warnf("failed to refresh the partitions of table %s: %s", d.cfg.TableName, err)
+		}
+	}
 
-func (d *pegasusDetector) detect() {
+	var wg sync.WaitGroup
+	for idx := range d.hashKeys {
+		wg.Add(1)
+		go func(idx int) {
+			defer wg.Done()
+			d.detectPartition(rootCtx, idx)
+		}(idx)
+	}
+	wg.Wait()
 
+	report := d.Report()
+	if unavailable := report.UnavailablePartitions(); len(unavailable) != 0 {
+		log.WithField("table", d.cfg.TableName).Warnf("partitions %v of table %s are unavailable", unavailable, d.cfg.TableName)
+	}
+	for w, s := range report.Windows {
+		d.ratios.WithLabelValues(d.cluster, string(w)).Set(s.Ratio())
+	}
+	d.latencyP99.WithLabelValues(d.cluster).Set(report.LatencyP99.Seconds())
 }
 
-func (d *pegasusDetector) generateHashKeys() {
+// detectPartition probes a partition, and records the result.
+func (d *pegasusDetector) detectPartition(rootCtx context.Context, idx int) {
+	hashKey := d.hashKeys[idx]
+	start := d.now()
+	err := d.probe(rootCtx, hashKey)
+	latency := d.now().Sub(start)
+	d.windows.record(start, latency, err == nil)
+	if d.sla != nil {
+		d.sla.Record(start, err == nil)
+	}
+
+	partition := strconv.Itoa(idx)
+	d.detects.WithLabelValues(d.cluster).Inc()
+	if err != nil {
+		d.failures.WithLabelValues(d.cluster).Inc()
+		d.partitionFailures.WithLabelValues(d.cluster, partition).Inc()
+		log.WithField("table", d.cfg.TableName).Errorf("availability probe to partition %d of table %s failed, hashkey=\"%s\": %s", idx, d.cfg.TableName, hashKey, err)
+	}
+	d.partitionUp.WithLabelValues(d.cluster, partition).Set(boolToFloat(err == nil))
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if idx < len(d.partitions) {
+		d.partitions[idx].update(start, latency, err)
+	}
+}
 
+func boolToFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
 }
 
-func (d *pegasusDetector) writeResult() {
+// probe writes a key, reads it back, and deletes it.
+func (d *pegasusDetector) probe(rootCtx context.Context, hashKey []byte) error {
+	ctx, cancel := context.WithTimeout(rootCtx, d.cfg.Timeout)
+	defer cancel()
 
+	sortKey := []byte("detect")
+	value := []byte(strconv.FormatInt(d.now().UnixNano(), 10))
+	if err := d.detectTable.Set(ctx, hashKey, sortKey, value); err != nil {
+		return fmt.Errorf("set failed: %s", err)
+	}
+	got, err := d.detectTable.Get(ctx, hashKey, sortKey)
+	if err != nil {
+		return fmt.Errorf("get failed: %s", err)
+	}
+	if !bytes.Equal(got, value) {
+		return fmt.Errorf("get \"%s\" rather than \"%s\"", got, value)
+	}
+	if err := d.detectTable.Del(ctx, hashKey, sortKey); err != nil {
+		return fmt.Errorf("del failed: %s", err)
+	}
+	return nil
 }
 
-func (d *pegasusDetector) detectPartition(rootCtx context.Context, partitionIdx int) {
-	d.incrDetectTimes()
+func (d *pegasusDetector) Report() Report {
+	now := d.now()
+	report := Report{Windows: make(map[Window]WindowStats, len(Windows))}
+	for _, w := range Windows {
+		report.Windows[w] = d.windows.stats(w, now)
+	}
+	report.LatencyP99 = d.windows.latencyPercentile(99, now)
+	d.lock.RLock()
+	report.Partitions = append([]PartitionStatus{}, d.partitions...)
+	d.lock.RUnlock()
+	return report
+}
 
-	go func() {
-		ctx, _ := context.WithTimeout(rootCtx, d.detectTimeout)
+// hostname distinguishes the keys of the collectors probing the same table.
+func hostname() string {
+	name, err := os.Hostname()
+	if err != nil {
+		return "collector"
+	}
+	return name
+}
 
-		hashkey := d.detectHashKeys[partitionIdx]
-		value := []byte("")
+// Start runs the detector configured by the "available_detect" section until the tomb dies.
+// It does nothing if the detection is disabled.
+func Start(tom *tomb.Tomb) {
+	if !viper.GetBool("available_detect.enabled") {
+		return
+	}
+	viper.SetDefault("available_detect.interval", "3s")
+	viper.SetDefault("available_detect.timeout", "1s")
+	viper.SetDefault("available_detect.partition_count", 8)
+	viper.SetDefault("available_detect.replica_count", 3)
+	cfg := Config{
+		TableName:      viper.GetString("available_detect.table_name"),
+		MetaServers:    viper.GetStringSlice("meta_servers"),
+		CreateTable:    viper.GetBool("available_detect.create_table"),
+		PartitionCount: viper.GetInt("available_detect.partition_count"),
+		ReplicaCount:   viper.GetInt("available_detect.replica_count"),
+		Interval:       viper.GetDuration("available_detect.interval"),
+		Timeout:        viper.GetDuration("available_detect.timeout"),
+	}
+	cluster := viper.GetString("cluster_name")
+	sla, err := NewSLARecorder(cluster, viper.GetString("available_detect.sla.dir"))
+	if err != nil {
+		log.Errorf("failed to load the SLA of cluster %s: %s", cluster, err)
+		return
+	}
+	if viper.GetBool("available_detect.sla.email.enabled") {
+		_, err := NewSLAMailer(MailConfig{
+			SMTPAddr: viper.GetString("available_detect.sla.email.smtp_addr"),
+			Username: viper.GetString("available_detect.sla.email.username"),
+			Password: viper.GetString("available_detect.sla.email.password"),
+			From:     viper.GetString("available_detect.sla.email.from"),
+			To:       viper.GetStringSlice("available_detect.sla.email.to"),
+		}, sla)
+		if err != nil {
+			log.Errorf("failed to email the SLA reports: %s", err)
+			return
+		}
+	}
+	tom.Go(func() error {
+		sla.Run(tom.Context(nil), time.Minute)
+		return nil
+	})
 
-		if err := d.detectTable.Set(ctx, hashkey, []byte(""), value); err != nil {
-			d.incrFailureTimes()
-			log.Errorf("set partition [%d] failed, hashkey=\"%s\": %s", partitionIdx, err)
+	client := pegasus.NewClient(pegasus.Config{MetaServers: viper.GetStringSlice("meta_servers")})
+	defer client.Close()
+	d := NewDetector(client, cfg, prometheus.DefaultRegisterer, cluster).(*pegasusDetector)
+	d.sla = sla
+	// only the leader of the election probes
+	d.active = election.IsLeader
+	setDefault(d, sla)
+	for {
+		err := d.Start(tom.Context(nil))
+		if err == nil {
+			return
 		}
-		if _, err := d.detectTable.Get(ctx, hashkey, []byte("")); err != nil {
-			d.incrFailureTimes()
-			log.Errorf("get partition [%d] failed, hashkey=\"%s\": %s", partitionIdx, err)
+		// retry indefinitely
+		log.WithField("table", cfg.TableName).Errorf("failed to start the availability detection of table %s: %s", cfg.TableName, err)
+		select {
+		case <-tom.Dying():
+			return
+		case <-time.After(15 * time.Second):
 		}
-	}()
+	}
 }
 
-func (d *pegasusDetector) incrDetectTimes() {
-	atomic.AddUint64(&d.recentMinuteDetectTimes, 1)
-	atomic.AddUint64(&d.recentHourDetectTimes, 1)
-	atomic.AddUint64(&d.recentDayDetectTimes, 1)
+var (
+	defaultLock     sync.RWMutex
+	defaultDetector Detector
+	defaultSLA      *SLARecorder
+)
+
+func setDefault(d Detector, sla *SLARecorder) {
+	defaultLock.Lock()
+	defer defaultLock.Unlock()
+	defaultDetector = d
+	defaultSLA = sla
+}
+
+// LatestReport returns the report of the detector run by Start, or false if the detection
+// is disabled.
+func LatestReport() (Report, bool) {
+	defaultLock.RLock()
+	defer defaultLock.RUnlock()
+	if defaultDetector == nil {
+		return Report{}, false
+	}
+	return defaultDetector.Report(), true
 }
 
-func (d *pegasusDetector) incrFailureTimes() {
-	atomic.AddUint64(&d.recentMinuteFailureTimes, 1)
-	atomic.AddUint64(&d.recentHourFailureTimes, 1)
-	atomic.AddUint64(&d.recentDayFailureTimes, 1)
+// SLA returns the SLA recorder of the detector run by Start, or nil if the detection is disabled.
+func SLA() *SLARecorder {
+	defaultLock.RLock()
+	defer defaultLock.RUnlock()
+	return defaultSLA
 }
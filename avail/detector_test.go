@@ -0,0 +1,203 @@
+package avail
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/XiaoMi/pegasus-go-client/idl/admin"
+	"github.com/XiaoMi/pegasus-go-client/idl/base"
+	"github.com/XiaoMi/pegasus-go-client/idl/replication"
+	"github.com/apache/thrift/lib/go/thrift"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeTable stores the values in memory, and fails the operations on the partitions of
+// `failedPartitions`.
+type fakeTable struct {
+	partitionCount   int
+	failedPartitions map[int]bool
+
+	lock   sync.Mutex
+	values map[string][]byte
+}
+
+func (t *fakeTable) check(hashKey []byte) error {
+	if t.failedPartitions[partitionIndexOf(hashKey, t.partitionCount)] {
+		return fmt.Errorf("ERR_TIMEOUT")
+	}
+	return nil
+}
+
+func (t *fakeTable) Set(_ context.Context, hashKey []byte, sortKey []byte, value []byte) error {
+	if err := t.check(hashKey); err != nil {
+		return err
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.values[string(hashKey)+string(sortKey)] = value
+	return nil
+}
+
+func (t *fakeTable) Get(_ context.Context, hashKey []byte, sortKey []byte) ([]byte, error) {
+	if err := t.check(hashKey); err != nil {
+		return nil, err
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return t.values[string(hashKey)+string(sortKey)], nil
+}
+
+func (t *fakeTable) Del(_ context.Context, hashKey []byte, sortKey []byte) error {
+	if err := t.check(hashKey); err != nil {
+		return err
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	delete(t.values, string(hashKey)+string(sortKey))
+	return nil
+}
+
+// fakeMeta serves the table of `partitionCount` partitions, which is absent if `created` is false.
+type fakeMeta struct {
+	partitionCount int32
+	created        bool
+	createReq      *admin.CreateAppRequest
+}
+
+func (m *fakeMeta) QueryConfig(context.Context, string) (*replication.QueryCfgResponse, error) {
+	if !m.created {
+		return &replication.QueryCfgResponse{Err: &base.ErrorCode{Errno: base.ERR_OBJECT_NOT_FOUND.String()}}, nil
+	}
+	resp := &replication.QueryCfgResponse{PartitionCount: m.partitionCount}
+	for i := int32(0); i < m.partitionCount; i++ {
+		resp.Partitions = append(resp.Partitions, &replication.PartitionConfiguration{Primary: rpcAddress(0x7f00000187e1)})
+	}
+	return resp, nil
+}
+
+func (m *fakeMeta) CreateApp(_ context.Context, req *admin.CreateAppRequest) (*admin.CreateAppResponse, error) {
+	m.createReq = req
+	m.partitionCount = req.Options.PartitionCount
+	m.created = true
+	return &admin.CreateAppResponse{}, nil
+}
+
+// rpcAddress returns the address of the raw value, since base.RPCAddress has no constructor.
+func rpcAddress(raw int64) *base.RPCAddress {
+	buf := thrift.NewTMemoryBuffer()
+	proto := thrift.NewTBinaryProtocolTransport(buf)
+	_ = proto.WriteI64(raw)
+	_ = proto.Flush(context.Background())
+	addr := &base.RPCAddress{}
+	_ = addr.Read(proto)
+	return addr
+}
+
+func newTestDetector(t *testing.T, partitionCount int) (*pegasusDetector, *fakeTable) {
+	tb := &fakeTable{partitionCount: partitionCount, failedPartitions: make(map[int]bool), values: make(map[string][]byte)}
+	d := NewDetector(nil, Config{TableName: "test", Timeout: time.Second}, prometheus.NewRegistry(), "onebox").(*pegasusDetector)
+	d.detectTable = tb
+	d.meta = &fakeMeta{partitionCount: int32(partitionCount), created: true}
+	assert.Nil(t, d.refreshPartitions(context.Background()))
+	return d, tb
+}
+
+func TestDetect(t *testing.T) {
+	d, tb := newTestDetector(t, 4)
+	now := time.Unix(1600000000, 0)
+	d.now = func() time.Time { return now }
+	d.partitionsRefreshed = now
+
+	d.detect(context.Background())
+	assert.Empty(t, tb.values)
+	tb.failedPartitions[2] = true
+	d.detect(context.Background())
+	d.detect(context.Background())
+
+	report := d.Report()
+	assert.Equal(t, report.Windows[Minute], WindowStats{Total: 12, Failures: 2})
+	assert.Equal(t, report.UnavailablePartitions(), []int{2})
+	assert.Equal(t, report.Partitions[2].ConsecutiveFailures, 2)
+	assert.Equal(t, report.Partitions[2].Error, "set failed: ERR_TIMEOUT")
+	assert.Equal(t, testutil.ToFloat64(d.failures.WithLabelValues("onebox")), float64(2))
+	assert.Equal(t, testutil.ToFloat64(d.partitionUp.WithLabelValues("onebox", "2")), float64(0))
+	assert.Equal(t, testutil.ToFloat64(d.partitionUp.WithLabelValues("onebox", "1")), float64(1))
+	assert.Equal(t, testutil.ToFloat64(d.partitionFailures.WithLabelValues("onebox", "2")), float64(2))
+
+	// the probes of the minute are out of the minute window, but still in the hour window
+	now = now.Add(30 * time.Second)
+	delete(tb.failedPartitions, 2)
+	d.detect(context.Background())
+	now = now.Add(30 * time.Second)
+	report = d.Report()
+	assert.Equal(t, report.Windows[Minute], WindowStats{Total: 4})
+	assert.Equal(t, report.Windows[Hour], WindowStats{Total: 16, Failures: 2})
+	assert.Empty(t, report.UnavailablePartitions())
+}
+
+func TestRefreshPartitions(t *testing.T) {
+	d, _ := newTestDetector(t, 4)
+	assert.Equal(t, len(d.Report().Partitions), 4)
+
+	// the table is split
+	d.meta = &fakeMeta{partitionCount: 8, created: true}
+	assert.Nil(t, d.refreshPartitions(context.Background()))
+	assert.Equal(t, len(d.hashKeys), 8)
+	assert.Equal(t, len(d.Report().Partitions), 8)
+}
+
+func TestGenerateHashKeys(t *testing.T) {
+	keys := generateHashKeys("detect_available_host", 16)
+	assert.Equal(t, len(keys), 16)
+	for idx, key := range keys {
+		assert.Equal(t, partitionIndexOf(key, 16), idx)
+	}
+}
+
+func TestProbeWindows(t *testing.T) {
+	var w probeWindows
+	start := time.Unix(1600000000, 0)
+	for i := 1; i <= 100; i++ {
+		w.record(start, time.Duration(i)*time.Millisecond, true)
+	}
+	assert.Equal(t, w.latencyPercentile(99, start), 99*time.Millisecond)
+	assert.Equal(t, w.latencyPercentile(50, start), 50*time.Millisecond)
+	assert.Equal(t, w.latencyPercentile(99, start.Add(time.Minute)), time.Duration(0))
+
+	// the bucket of a day ago is reused
+	w.record(start.Add(24*time.Hour), time.Millisecond, false)
+	assert.Equal(t, w.stats(Day, start.Add(24*time.Hour)), WindowStats{Total: 1, Failures: 1})
+	assert.Equal(t, w.stats(Minute, start.Add(2*time.Hour)), WindowStats{})
+}
+
+func TestEnsureTable(t *testing.T) {
+	d := NewDetector(nil, Config{TableName: "test", PartitionCount: 4, ReplicaCount: 3}, prometheus.NewRegistry(), "onebox").(*pegasusDetector)
+	meta := &fakeMeta{}
+	d.meta = meta
+	assert.NotNil(t, d.ensureTable(context.Background()))
+	assert.Nil(t, meta.createReq)
+
+	d.cfg.CreateTable = true
+	assert.Nil(t, d.ensureTable(context.Background()))
+	assert.Equal(t, meta.createReq.AppName, "test")
+	assert.Equal(t, meta.createReq.Options.PartitionCount, int32(4))
+	assert.Equal(t, meta.createReq.Options.ReplicaCount, int32(3))
+	assert.Nil(t, d.refreshPartitions(context.Background()))
+	assert.Equal(t, len(d.hashKeys), 4)
+
+	// created already
+	meta.createReq = nil
+	assert.Nil(t, d.ensureTable(context.Background()))
+	assert.Nil(t, meta.createReq)
+
+	// a partition has no primary yet
+	assert.False(t, tableReady(&replication.QueryCfgResponse{
+		PartitionCount: 1,
+		Partitions:     []*replication.PartitionConfiguration{{}},
+	}))
+}
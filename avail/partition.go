@@ -0,0 +1,56 @@
+package avail
+
+import (
+	"fmt"
+	"hash/crc64"
+	"time"
+)
+
+// The CRC64 table by which pegasus-go-client routes the hash keys to the partitions.
+var crc64Table = crc64.MakeTable(0x9a6c9329ac4bc9b5)
+
+func partitionIndexOf(hashKey []byte, partitionCount int) int {
+	return int(crc64.Checksum(hashKey, crc64Table) % uint64(partitionCount))
+}
+
+// generateHashKeys returns a hash key routed to each partition, indexed by the partition index.
+func generateHashKeys(prefix string, partitionCount int) [][]byte {
+	keys := make([][]byte, partitionCount)
+	covered := 0
+	for i := 0; covered < partitionCount; i++ {
+		key := []byte(fmt.Sprintf("%s_%d", prefix, i))
+		idx := partitionIndexOf(key, partitionCount)
+		if keys[idx] == nil {
+			keys[idx] = key
+			covered++
+		}
+	}
+	return keys
+}
+
+// PartitionStatus is the result of the latest probe to a partition.
+type PartitionStatus struct {
+	PartitionIndex int    `json:"partition_index"`
+	Available      bool   `json:"available"`
+	Error          string `json:"error,omitempty"`
+
+	// The number of the consecutive failed probes until the latest one.
+	ConsecutiveFailures int `json:"consecutive_failures"`
+
+	Latency  time.Duration `json:"latency"`
+	ProbedAt time.Time     `json:"probed_at"`
+}
+
+// update records the result of a probe.
+func (s *PartitionStatus) update(at time.Time, latency time.Duration, err error) {
+	s.ProbedAt = at
+	s.Latency = latency
+	s.Available = err == nil
+	if err != nil {
+		s.Error = err.Error()
+		s.ConsecutiveFailures++
+	} else {
+		s.Error = ""
+		s.ConsecutiveFailures = 0
+	}
+}
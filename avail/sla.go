@@ -0,0 +1,213 @@
+package avail
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	slaDayLayout   = "2006-01-02"
+	slaMonthLayout = "2006-01"
+)
+
+// DailyAvailability is the availability of a UTC day.
+type DailyAvailability struct {
+	Date string `json:"date"`
+	WindowStats
+
+	// The percentage of the successful probes.
+	Availability float64 `json:"availability"`
+}
+
+// MonthlyAvailability is the availability of a UTC month, summed up from the days.
+type MonthlyAvailability struct {
+	Cluster string `json:"cluster"`
+	Month   string `json:"month"`
+	WindowStats
+
+	// The percentage of the successful probes.
+	Availability float64 `json:"availability"`
+
+	Days []DailyAvailability `json:"days"`
+}
+
+// SLARecorder rolls up the probe results into the daily availability of the cluster, which is
+// persisted to "sla-<cluster>.json" under the directory if given.
+type SLARecorder struct {
+	cluster string
+	path    string
+
+	lock sync.Mutex
+	// UTC date -> the probe results of the day
+	days  map[string]*WindowStats
+	dirty bool
+	// the UTC date of the latest probe
+	lastDay string
+	// called with the date once a day ends
+	dayEndHooks []func(date string)
+}
+
+// NewSLARecorder returns a SLARecorder, which loads the persisted days from `dir` if it's not
+// empty.
+func NewSLARecorder(cluster string, dir string) (*SLARecorder, error) {
+	r := &SLARecorder{cluster: cluster, days: make(map[string]*WindowStats)}
+	if dir == "" {
+		return r, nil
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	r.path = filepath.Join(dir, "sla-"+cluster+".json")
+	data, err := ioutil.ReadFile(r.path)
+	if os.IsNotExist(err) {
+		return r, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var days []DailyAvailability
+	if err := json.Unmarshal(data, &days); err != nil {
+		return nil, err
+	}
+	for _, d := range days {
+		stats := d.WindowStats
+		r.days[d.Date] = &stats
+		if d.Date > r.lastDay {
+			r.lastDay = d.Date
+		}
+	}
+	return r, nil
+}
+
+// AddDayEndHook adds a hook called with the UTC date once the day ends, i.e. the first probe
+// of the next day is recorded.
+func (r *SLARecorder) AddDayEndHook(hook func(date string)) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.dayEndHooks = append(r.dayEndHooks, hook)
+}
+
+// Record adds the result of a probe.
+func (r *SLARecorder) Record(at time.Time, success bool) {
+	date := at.UTC().Format(slaDayLayout)
+	r.lock.Lock()
+	stats := r.days[date]
+	if stats == nil {
+		stats = &WindowStats{}
+		r.days[date] = stats
+	}
+	stats.Total++
+	if !success {
+		stats.Failures++
+	}
+	r.dirty = true
+	var ended string
+	if date > r.lastDay {
+		ended = r.lastDay
+		r.lastDay = date
+	}
+	hooks := r.dayEndHooks
+	r.lock.Unlock()
+
+	if ended != "" {
+		for _, hook := range hooks {
+			go hook(ended)
+		}
+	}
+}
+
+// Daily returns the availability of the UTC days in [start, end], sorted by the date.
+func (r *SLARecorder) Daily(start, end time.Time) []DailyAvailability {
+	from, to := start.UTC().Format(slaDayLayout), end.UTC().Format(slaDayLayout)
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	res := []DailyAvailability{}
+	for date, stats := range r.days {
+		if date >= from && date <= to {
+			res = append(res, newDailyAvailability(date, *stats))
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Date < res[j].Date
+	})
+	return res
+}
+
+// Monthly returns the availability of the UTC month containing `month`.
+func (r *SLARecorder) Monthly(month time.Time) MonthlyAvailability {
+	month = month.UTC()
+	first := time.Date(month.Year(), month.Month(), 1, 0, 0, 0, 0, time.UTC)
+	res := MonthlyAvailability{
+		Cluster: r.cluster,
+		Month:   first.Format(slaMonthLayout),
+		Days:    r.Daily(first, first.AddDate(0, 1, -1)),
+	}
+	for _, d := range res.Days {
+		res.Total += d.Total
+		res.Failures += d.Failures
+	}
+	res.Availability = res.Ratio() * 100
+	return res
+}
+
+func newDailyAvailability(date string, stats WindowStats) DailyAvailability {
+	return DailyAvailability{Date: date, WindowStats: stats, Availability: stats.Ratio() * 100}
+}
+
+// Flush persists the days if any probe is recorded since the last flush.
+func (r *SLARecorder) Flush() error {
+	if r.path == "" {
+		return nil
+	}
+	r.lock.Lock()
+	if !r.dirty {
+		r.lock.Unlock()
+		return nil
+	}
+	var days []DailyAvailability
+	for date, stats := range r.days {
+		days = append(days, newDailyAvailability(date, *stats))
+	}
+	r.dirty = false
+	r.lock.Unlock()
+
+	sort.Slice(days, func(i, j int) bool {
+		return days[i].Date < days[j].Date
+	})
+	data, err := json.MarshalIndent(days, "", "  ")
+	if err != nil {
+		return err
+	}
+	// written to a temporary file first, so that the file is never partially written
+	tmp := r.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, r.path)
+}
+
+// Run flushes the days every interval until `ctx` is done, and flushes once more before
+// returning.
+func (r *SLARecorder) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			if err := r.Flush(); err != nil {
+				log.Errorf("failed to persist the SLA of cluster %s: %s", r.cluster, err)
+			}
+			return
+		case <-ticker.C:
+		}
+		if err := r.Flush(); err != nil {
+			log.Errorf("failed to persist the SLA of cluster %s: %s", r.cluster, err)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package avail
+
+import (
+	"bytes"
+	"fmt"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// MailConfig is the configuration of the emailed SLA reports.
+type MailConfig struct {
+	// The address of the SMTP server, e.g. "smtp.example.com:25".
+	SMTPAddr string
+
+	// The PLAIN authentication is used if Username is not empty.
+	Username string
+	Password string
+
+	From string
+	To   []string
+}
+
+// SLAMailer emails the availability of the day and the month so far, once a day ends.
+type SLAMailer struct {
+	cfg      MailConfig
+	recorder *SLARecorder
+
+	sendMail func(addr string, a smtp.Auth, from string, to []string, msg []byte) error
+}
+
+// NewSLAMailer returns a SLAMailer, which is hooked to the end of the days of the recorder.
+func NewSLAMailer(cfg MailConfig, recorder *SLARecorder) (*SLAMailer, error) {
+	if cfg.SMTPAddr == "" || cfg.From == "" || len(cfg.To) == 0 {
+		return nil, fmt.Errorf("the SMTP address, the sender and the recipients of the SLA report are required")
+	}
+	m := &SLAMailer{cfg: cfg, recorder: recorder, sendMail: smtp.SendMail}
+	recorder.AddDayEndHook(m.onDayEnd)
+	return m, nil
+}
+
+func (m *SLAMailer) onDayEnd(date string) {
+	if err := m.Send(date); err != nil {
+		log.Errorf("failed to email the SLA report of %s: %s", date, err)
+	}
+}
+
+// Send emails the report of the UTC date.
+func (m *SLAMailer) Send(date string) error {
+	day, err := time.Parse(slaDayLayout, date)
+	if err != nil {
+		return err
+	}
+	var auth smtp.Auth
+	if m.cfg.Username != "" {
+		host := m.cfg.SMTPAddr
+		if i := strings.LastIndex(host, ":"); i >= 0 {
+			host = host[:i]
+		}
+		auth = smtp.PlainAuth("", m.cfg.Username, m.cfg.Password, host)
+	}
+	return m.sendMail(m.cfg.SMTPAddr, auth, m.cfg.From, m.cfg.To, m.compose(day))
+}
+
+func (m *SLAMailer) compose(day time.Time) []byte {
+	month := m.recorder.Monthly(day)
+	var daily *DailyAvailability
+	for i := range month.Days {
+		if month.Days[i].Date == day.Format(slaDayLayout) {
+			daily = &month.Days[i]
+		}
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", m.cfg.From)
+	fmt.Fprintf(&buf, "To: %s\r\n", strings.Join(m.cfg.To, ", "))
+	fmt.Fprintf(&buf, "Subject: [%s] Pegasus availability of %s\r\n", month.Cluster, day.Format(slaDayLayout))
+	buf.WriteString("Content-Type: text/plain; charset=UTF-8\r\n\r\n")
+	if daily != nil {
+		fmt.Fprintf(&buf, "%s: %.4f%% (%d probes, %d failures)\r\n", daily.Date, daily.Availability, daily.Total, daily.Failures)
+	} else {
+		fmt.Fprintf(&buf, "%s: no probe\r\n", day.Format(slaDayLayout))
+	}
+	fmt.Fprintf(&buf, "%s so far: %.4f%% (%d probes, %d failures)\r\n", month.Month, month.Availability, month.Total, month.Failures)
+	return buf.Bytes()
+}
@@ -0,0 +1,75 @@
+package avail
+
+import (
+	"io/ioutil"
+	"net/smtp"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSLARecorder(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sla")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	r, err := NewSLARecorder("onebox", dir)
+	assert.Nil(t, err)
+	ended := make(chan string, 1)
+	r.AddDayEndHook(func(date string) {
+		ended <- date
+	})
+
+	day := time.Date(2020, 11, 30, 23, 0, 0, 0, time.UTC)
+	for i := 0; i < 4; i++ {
+		r.Record(day, i != 0)
+	}
+	r.Record(day.Add(2*time.Hour), true)
+	assert.Equal(t, <-ended, "2020-11-30")
+
+	month := r.Monthly(day)
+	assert.Equal(t, month.Month, "2020-11")
+	assert.Equal(t, month.WindowStats, WindowStats{Total: 4, Failures: 1})
+	assert.Equal(t, month.Availability, float64(75))
+	assert.Equal(t, len(month.Days), 1)
+	assert.Equal(t, r.Monthly(day.AddDate(0, 0, 1)).Days, []DailyAvailability{
+		{Date: "2020-12-01", WindowStats: WindowStats{Total: 1}, Availability: 100},
+	})
+
+	// the days are reloaded after restart
+	assert.Nil(t, r.Flush())
+	r, err = NewSLARecorder("onebox", dir)
+	assert.Nil(t, err)
+	assert.Equal(t, len(r.Daily(day, day.AddDate(0, 0, 1))), 2)
+	assert.Equal(t, r.lastDay, "2020-12-01")
+}
+
+func TestSLAMailer(t *testing.T) {
+	r, err := NewSLARecorder("onebox", "")
+	assert.Nil(t, err)
+	_, err = NewSLAMailer(MailConfig{SMTPAddr: "127.0.0.1:25"}, r)
+	assert.NotNil(t, err)
+
+	// recorded before the mailer is hooked, which would email the report of 2020-11-01
+	day := time.Date(2020, 11, 2, 0, 0, 0, 0, time.UTC)
+	r.Record(day.AddDate(0, 0, -1), false)
+	r.Record(day, true)
+
+	m, err := NewSLAMailer(MailConfig{SMTPAddr: "127.0.0.1:25", From: "collector@example.com", To: []string{"ops@example.com"}}, r)
+	assert.Nil(t, err)
+	var msg string
+	m.sendMail = func(addr string, a smtp.Auth, from string, to []string, body []byte) error {
+		assert.Equal(t, addr, "127.0.0.1:25")
+		assert.Nil(t, a)
+		msg = string(body)
+		return nil
+	}
+
+	assert.Nil(t, m.Send("2020-11-02"))
+	assert.True(t, strings.Contains(msg, "Subject: [onebox] Pegasus availability of 2020-11-02"))
+	assert.True(t, strings.Contains(msg, "2020-11-02: 100.0000% (1 probes, 0 failures)"))
+	assert.True(t, strings.Contains(msg, "2020-11 so far: 50.0000% (2 probes, 1 failures)"))
+}
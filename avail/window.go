@@ -0,0 +1,127 @@
+package avail
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Window is a period of the recent probes over which the availability is calculated.
+type Window string
+
+// The windows ending at the current minute.
+const (
+	Minute Window = "minute"
+	Hour   Window = "hour"
+	Day    Window = "day"
+)
+
+func (w Window) duration() time.Duration {
+	switch w {
+	case Minute:
+		return time.Minute
+	case Hour:
+		return time.Hour
+	default:
+		return 24 * time.Hour
+	}
+}
+
+// Windows are all the windows of the availability.
+var Windows = []Window{Minute, Hour, Day}
+
+// WindowStats is the probe results of a window.
+type WindowStats struct {
+	Total    uint64 `json:"total"`
+	Failures uint64 `json:"failures"`
+}
+
+// Ratio returns the ratio of the successful probes, or 1 if there's no probe.
+func (s WindowStats) Ratio() float64 {
+	if s.Total == 0 {
+		return 1
+	}
+	return float64(s.Total-s.Failures) / float64(s.Total)
+}
+
+// minuteBucket is the probe results of a minute.
+type minuteBucket struct {
+	minute int64
+	WindowStats
+}
+
+type latencySample struct {
+	at      time.Time
+	latency time.Duration
+}
+
+// probeWindows keeps the probe results of the last day in the per-minute buckets, and the
+// latencies of the last minute.
+type probeWindows struct {
+	lock      sync.Mutex
+	buckets   [24 * 60]minuteBucket
+	latencies []latencySample
+}
+
+func (w *probeWindows) record(at time.Time, latency time.Duration, success bool) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	minute := at.Unix() / 60
+	b := &w.buckets[minute%int64(len(w.buckets))]
+	if b.minute != minute {
+		*b = minuteBucket{minute: minute}
+	}
+	b.Total++
+	if !success {
+		b.Failures++
+	}
+	w.latencies = append(w.latencies, latencySample{at: at, latency: latency})
+	w.pruneLatencies(at)
+}
+
+func (w *probeWindows) pruneLatencies(now time.Time) {
+	i := 0
+	for i < len(w.latencies) && now.Sub(w.latencies[i].at) >= time.Minute {
+		i++
+	}
+	w.latencies = w.latencies[i:]
+}
+
+// stats returns the probe results of the window ending at `now`, at the granularity of minutes.
+func (w *probeWindows) stats(window Window, now time.Time) WindowStats {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	curr := now.Unix() / 60
+	oldest := curr - int64(window.duration()/time.Minute) + 1
+	var res WindowStats
+	for _, b := range w.buckets {
+		if b.minute >= oldest && b.minute <= curr {
+			res.Total += b.Total
+			res.Failures += b.Failures
+		}
+	}
+	return res
+}
+
+// latencyPercentile returns the percentile in (0, 100] of the probe latencies of the last minute.
+func (w *probeWindows) latencyPercentile(percentile float64, now time.Time) time.Duration {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.pruneLatencies(now)
+	if len(w.latencies) == 0 {
+		return 0
+	}
+	latencies := make([]time.Duration, len(w.latencies))
+	for i, s := range w.latencies {
+		latencies[i] = s.latency
+	}
+	sort.Slice(latencies, func(i, j int) bool {
+		return latencies[i] < latencies[j]
+	})
+	rank := int(math.Ceil(percentile/100*float64(len(latencies)))) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	return latencies[rank]
+}
@@ -0,0 +1,348 @@
+// Package check validates the deployment of the collector before it runs, e.g. the config file.
+package check
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/pegasus-kv/collector/aggregate"
+	"github.com/pegasus-kv/collector/alert"
+	"github.com/pegasus-kv/collector/logging"
+	"github.com/pegasus-kv/collector/metrics"
+	"github.com/spf13/viper"
+	"gopkg.in/yaml.v3"
+)
+
+// Issue is a problem of the config.
+type Issue struct {
+	// the key of the problematic value, e.g. "alerting.rules[1].comparison"
+	Key string
+	// the line of the key in the config file, or of its closest parent if the key is absent,
+	// 0 if unknown
+	Line int
+	Msg  string
+}
+
+func (i Issue) String() string {
+	return fmt.Sprintf("%s: %s", i.Key, i.Msg)
+}
+
+// Config reads the config file into viper, and validates the clusters, the sinks, the
+// aggregation rules, the table filter, the derived metrics, the alerting and the logging. It
+// returns an error if the file can't be parsed, otherwise the issues found in the order of the
+// checks.
+func Config(file string) ([]Issue, error) {
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(content, &doc); err != nil {
+		return nil, err
+	}
+	viper.SetConfigFile(file)
+	viper.SetConfigType("yaml")
+	if err := viper.ReadInConfig(); err != nil {
+		return nil, err
+	}
+
+	c := &configChecker{}
+	c.checkClusters()
+	c.checkSinks()
+	c.checkAggregationRules()
+	c.checkTableFilter()
+	c.checkDerivedMetrics()
+	c.checkAlerting()
+	c.checkLogging()
+	for i := range c.issues {
+		c.issues[i].Line = locate(&doc, c.issues[i].Key)
+	}
+	return c.issues, nil
+}
+
+type configChecker struct {
+	issues []Issue
+}
+
+func (c *configChecker) addIssue(key string, format string, args ...interface{}) {
+	c.issues = append(c.issues, Issue{Key: key, Msg: fmt.Sprintf(format, args...)})
+}
+
+// unmarshal decodes the list of the key, and reports an issue if it fails.
+func (c *configChecker) unmarshal(key string, v interface{}) bool {
+	if err := viper.UnmarshalKey(key, v); err != nil {
+		c.addIssue(key, "%s", err)
+		return false
+	}
+	return true
+}
+
+func (c *configChecker) checkClusters() {
+	var clusters []aggregate.ClusterConfig
+	if !c.unmarshal("clusters", &clusters) {
+		return
+	}
+	if len(clusters) == 0 {
+		metaServers := viper.GetStringSlice("meta_servers")
+		for i, addr := range metaServers {
+			c.checkAddr(fmt.Sprintf("meta_servers[%d]", i), addr)
+		}
+		discovered := len(viper.GetStringSlice("discovery.zookeeper.servers")) != 0
+		if len(metaServers) == 0 && viper.GetString("meta_server") == "" && !discovered {
+			c.addIssue("meta_servers", "no cluster to collect, any of clusters, meta_servers and discovery.zookeeper.servers is required")
+		}
+		if viper.GetString("cluster_name") == "" && !discovered {
+			c.addIssue("cluster_name", "the name of the cluster is required")
+		}
+	}
+	names := make(map[string]bool)
+	for i, cluster := range clusters {
+		key := fmt.Sprintf("clusters[%d]", i)
+		if cluster.Name == "" {
+			c.addIssue(key+".name", "the name of the cluster is required")
+		} else if names[cluster.Name] {
+			c.addIssue(key+".name", "duplicate cluster %q", cluster.Name)
+		}
+		names[cluster.Name] = true
+		if len(cluster.MetaServers) == 0 {
+			c.addIssue(key+".meta_servers", "the meta servers of cluster %q are required", cluster.Name)
+		}
+		for j, addr := range cluster.MetaServers {
+			c.checkAddr(fmt.Sprintf("%s.meta_servers[%d]", key, j), addr)
+		}
+	}
+	for i, addr := range viper.GetStringSlice("discovery.zookeeper.servers") {
+		c.checkAddr(fmt.Sprintf("discovery.zookeeper.servers[%d]", i), addr)
+	}
+}
+
+// checkAddr checks the address is "<host>:<port>".
+func (c *configChecker) checkAddr(key string, addr string) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		c.addIssue(key, "invalid address %q: %s", addr, err)
+		return
+	}
+	if host == "" {
+		c.addIssue(key, "no host in address %q", addr)
+	}
+	c.checkPort(key, port)
+}
+
+func (c *configChecker) checkPort(key string, port string) {
+	if p, err := strconv.Atoi(port); err != nil || p <= 0 || p > 65535 {
+		c.addIssue(key, "invalid port %q, which should be within [1, 65535]", port)
+	}
+}
+
+// checkURL checks the URL is absolute with the http or https scheme.
+func (c *configChecker) checkURL(key string) {
+	raw := viper.GetString(key)
+	if raw == "" {
+		c.addIssue(key, "the URL is required")
+		return
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		c.addIssue(key, "invalid URL: %s", err)
+		return
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		c.addIssue(key, "invalid scheme %q of %q, which should be http or https", u.Scheme, raw)
+	}
+	if u.Host == "" {
+		c.addIssue(key, "no host in %q", raw)
+	}
+}
+
+// checkSinks checks the sinks are registered, and the endpoints of the sinks enabled.
+func (c *configChecker) checkSinks() {
+	key := "metrics.sinks"
+	names := viper.GetStringSlice(key)
+	if len(names) == 0 && viper.GetString("metrics.sink") != "" {
+		key = "metrics.sink"
+		names = []string{viper.GetString(key)}
+	}
+	registered := metrics.RegisteredSinks()
+	for i, name := range names {
+		if !containsString(registered, name) {
+			if key == "metrics.sinks" {
+				c.addIssue(fmt.Sprintf("%s[%d]", key, i), "invalid sink %q, available sinks are %v", name, registered)
+			} else {
+				c.addIssue(key, "invalid sink %q, available sinks are %v", name, registered)
+			}
+			continue
+		}
+		switch name {
+		case "falcon":
+			if viper.GetString("falcon_agent.host") == "" {
+				c.addIssue("falcon_agent.host", "the host of falcon agent is required")
+			}
+			c.checkPort("falcon_agent.port", viper.GetString("falcon_agent.port"))
+		case "influxdb", "opentsdb":
+			c.checkURL(name + ".url")
+		case "remote_write":
+			c.checkURL("remote_write.url")
+		case "otlp":
+			c.checkAddr("otlp.endpoint", viper.GetString("otlp.endpoint"))
+		case "kafka":
+			brokers := viper.GetStringSlice("kafka.brokers")
+			if len(brokers) == 0 {
+				c.addIssue("kafka.brokers", "the brokers are required")
+			}
+			for j, addr := range brokers {
+				c.checkAddr(fmt.Sprintf("kafka.brokers[%d]", j), addr)
+			}
+			if viper.GetString("kafka.topic") == "" {
+				c.addIssue("kafka.topic", "the topic is required")
+			}
+		case "prometheus":
+			if port := viper.GetString("prometheus.exposer_port"); port != "" && port != "0" {
+				c.checkPort("prometheus.exposer_port", port)
+			}
+		}
+	}
+}
+
+func (c *configChecker) checkAggregationRules() {
+	var rules []struct {
+		Pattern string
+		Policy  string
+		Weight  string
+	}
+	if !c.unmarshal("metrics.aggregation_rules", &rules) {
+		return
+	}
+	for i, r := range rules {
+		if _, err := aggregate.NewAggregationRule(r.Pattern, aggregate.AggregationPolicy(r.Policy), r.Weight); err != nil {
+			c.addIssue(fmt.Sprintf("metrics.aggregation_rules[%d]", i), "%s", err)
+		}
+	}
+}
+
+func (c *configChecker) checkTableFilter() {
+	for _, key := range []string{"metrics.tables.include", "metrics.tables.exclude"} {
+		var patterns []string
+		if !c.unmarshal(key, &patterns) {
+			continue
+		}
+		for i, p := range patterns {
+			if _, err := aggregate.NewTableFilter([]string{p}, nil); err != nil {
+				c.addIssue(fmt.Sprintf("%s[%d]", key, i), "%s", err)
+			}
+		}
+	}
+}
+
+func (c *configChecker) checkDerivedMetrics() {
+	var derived []struct {
+		Name string
+		Expr string
+	}
+	if !c.unmarshal("metrics.derived_metrics", &derived) {
+		return
+	}
+	for i, d := range derived {
+		if _, err := aggregate.NewDerivedMetric(d.Name, d.Expr); err != nil {
+			c.addIssue(fmt.Sprintf("metrics.derived_metrics[%d].expr", i), "%s", err)
+		}
+	}
+}
+
+func (c *configChecker) checkAlerting() {
+	var rules []alert.Rule
+	if c.unmarshal("alerting.rules", &rules) {
+		names := make(map[string]bool)
+		for i := range rules {
+			key := fmt.Sprintf("alerting.rules[%d]", i)
+			if err := rules[i].Validate(); err != nil {
+				c.addIssue(key, "%s", err)
+			} else if names[rules[i].Name] {
+				c.addIssue(key+".name", "duplicate rule %q", rules[i].Name)
+			}
+			names[rules[i].Name] = true
+		}
+	}
+	var channels []alert.ChannelConfig
+	if c.unmarshal("alerting.channels", &channels) {
+		names := make(map[string]bool)
+		for i := range channels {
+			key := fmt.Sprintf("alerting.channels[%d]", i)
+			if err := channels[i].Validate(); err != nil {
+				c.addIssue(key, "%s", err)
+			} else if names[channels[i].Name] {
+				c.addIssue(key+".name", "duplicate notification channel %q", channels[i].Name)
+			}
+			names[channels[i].Name] = true
+		}
+	}
+}
+
+func (c *configChecker) checkLogging() {
+	cfg := logging.Config{File: logging.DefaultFileConfig}
+	if !c.unmarshal("log", &cfg) {
+		return
+	}
+	if err := cfg.Validate(); err != nil {
+		c.addIssue("log", "%s", err)
+	}
+	if err := cfg.File.Validate(); err != nil {
+		c.addIssue("log.file", "%s", err)
+	}
+}
+
+func containsString(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
+
+var indexRegexp = regexp.MustCompile(`\[(\d+)\]`)
+
+// locate returns the line of the key, e.g. "clusters[0].meta_servers[1]", in the document,
+// or of its closest parent present. The keys are matched case-insensitively as viper does.
+func locate(doc *yaml.Node, key string) int {
+	node := doc
+	if node.Kind == yaml.DocumentNode && len(node.Content) != 0 {
+		node = node.Content[0]
+	}
+	line := 0
+	for _, part := range strings.Split(key, ".") {
+		name := part
+		if i := strings.Index(part, "["); i >= 0 {
+			name = part[:i]
+		}
+		if node.Kind != yaml.MappingNode {
+			return line
+		}
+		found := false
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if strings.EqualFold(node.Content[i].Value, name) {
+				line = node.Content[i].Line
+				node = node.Content[i+1]
+				found = true
+				break
+			}
+		}
+		if !found {
+			return line
+		}
+		for _, m := range indexRegexp.FindAllStringSubmatch(part, -1) {
+			idx, _ := strconv.Atoi(m[1])
+			if node.Kind != yaml.SequenceNode || idx >= len(node.Content) {
+				return line
+			}
+			node = node.Content[idx]
+			line = node.Line
+		}
+	}
+	return line
+}
@@ -0,0 +1,151 @@
+package check
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func writeConfig(t *testing.T, content string) (string, func()) {
+	dir, err := ioutil.TempDir("", "check")
+	assert.Nil(t, err)
+	file := filepath.Join(dir, "config.yml")
+	assert.Nil(t, ioutil.WriteFile(file, []byte(content), 0600))
+	return file, func() {
+		os.RemoveAll(dir)
+		viper.Reset()
+	}
+}
+
+func TestConfig(t *testing.T) {
+	file, cleanup := writeConfig(t, `cluster_name : "onebox"
+clusters:
+  - name: c1
+    meta_servers: ["10.0.0.1:34601", "10.0.0.2"]
+  - name: c1
+    meta_servers: ["10.0.1.1:70000"]
+metrics:
+  sinks : [influxdb, kafka, graphite]
+  aggregation_rules:
+    - {pattern: "^get_latency$", policy: weighted_avg}
+  derived_metrics:
+    - {name: ratio, expr: "a / (b"}
+influxdb:
+  url : "127.0.0.1:8086"
+kafka:
+  brokers : ["127.0.0.1:9092"]
+alerting:
+  rules:
+    - {name: slow, metric: get_p99_latency, scope: table, comparison: ">", threshold: 100}
+    - {name: slow, metric: put_p99_latency, scope: table, comparison: ">", threshold: 100}
+    - name: dead
+      metric: dead_node_count
+      scope: cluster
+      comparison: "=>"
+`)
+	defer cleanup()
+	issues, err := Config(file)
+	assert.Nil(t, err)
+	var locations []string
+	lines := make(map[string]int)
+	for _, issue := range issues {
+		locations = append(locations, issue.Key)
+		lines[issue.Key] = issue.Line
+	}
+	assert.Equal(t, locations, []string{
+		"clusters[0].meta_servers[1]",
+		"clusters[1].name",
+		"clusters[1].meta_servers[0]",
+		"influxdb.url",
+		"kafka.topic",
+		"metrics.sinks[2]",
+		"metrics.aggregation_rules[0]",
+		"metrics.derived_metrics[0].expr",
+		"alerting.rules[1].name",
+		"alerting.rules[2]",
+	})
+	assert.Equal(t, lines, map[string]int{
+		"clusters[0].meta_servers[1]":     4,
+		"clusters[1].name":                5,
+		"clusters[1].meta_servers[0]":     6,
+		"influxdb.url":                    14,
+		"kafka.topic":                     15, // the closest parent
+		"metrics.sinks[2]":                8,
+		"metrics.aggregation_rules[0]":    10,
+		"metrics.derived_metrics[0].expr": 12,
+		"alerting.rules[1].name":          20,
+		"alerting.rules[2]":               21,
+	})
+}
+
+func TestConfigValid(t *testing.T) {
+	file, cleanup := writeConfig(t, `cluster_name : "onebox"
+meta_servers:
+  - 127.0.0.1:34601
+metrics:
+  sinks : [falcon, prometheus]
+falcon_agent:
+  host : "127.0.0.1"
+  port : 1988
+prometheus:
+  exposer_port : 1111
+log:
+  format : json
+  modules : {aggregate: debug}
+`)
+	defer cleanup()
+	issues, err := Config(file)
+	assert.Nil(t, err)
+	assert.Equal(t, len(issues), 0)
+}
+
+func TestConfigLog(t *testing.T) {
+	file, cleanup := writeConfig(t, `cluster_name : "onebox"
+meta_servers: ["127.0.0.1:34601"]
+log:
+  level : info
+  modules : {aggregate: verbose}
+  file:
+    path : /var/log/collector.log
+    rotate_interval : 1s
+`)
+	defer cleanup()
+	issues, err := Config(file)
+	assert.Nil(t, err)
+	assert.Equal(t, len(issues), 2)
+	assert.Equal(t, issues[0].Key, "log")
+	assert.Equal(t, issues[0].Line, 3)
+	assert.Contains(t, issues[0].Msg, "module aggregate")
+	assert.Equal(t, issues[1].Key, "log.file")
+	assert.Equal(t, issues[1].Line, 6)
+	assert.Contains(t, issues[1].Msg, "rotate_interval")
+}
+
+func TestConfigTableFilter(t *testing.T) {
+	file, cleanup := writeConfig(t, `cluster_name : "onebox"
+meta_servers: ["127.0.0.1:34601"]
+metrics:
+  tables:
+    include : ["^user_"]
+    exclude : ["_tmp$", "(temp"]
+`)
+	defer cleanup()
+	issues, err := Config(file)
+	assert.Nil(t, err)
+	assert.Equal(t, len(issues), 1)
+	assert.Equal(t, issues[0].Key, "metrics.tables.exclude[1]")
+	assert.Equal(t, issues[0].Line, 6)
+	assert.Contains(t, issues[0].Msg, "invalid pattern of table filter")
+}
+
+func TestConfigMalformed(t *testing.T) {
+	file, cleanup := writeConfig(t, "metrics:\n  sinks: [falcon\n")
+	defer cleanup()
+	_, err := Config(file)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "line")
+}
@@ -0,0 +1,167 @@
+package check
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/pegasus-kv/collector/aggregate"
+)
+
+// NodeReport is the result of fetching the perf-counters of a replica node once.
+type NodeReport struct {
+	Addr string
+	// false if the meta servers consider the node unalive, which is not fetched
+	Alive bool
+
+	Counters int
+	Duration time.Duration
+	Err      error
+}
+
+// ClusterReport is the readiness of a cluster to be collected.
+type ClusterReport struct {
+	Cluster     string
+	MetaServers []string
+
+	// nil if any meta server responds
+	MetaErr    error
+	MetaLeader string
+
+	TablesErr  error
+	Tables     int
+	Partitions int
+
+	NodesErr error
+	// sorted by the address, the alive nodes first
+	Nodes []NodeReport
+}
+
+// Ready returns whether the meta servers, the tables and every alive node are reachable.
+func (r *ClusterReport) Ready() bool {
+	if r.MetaErr != nil || r.TablesErr != nil || r.NodesErr != nil || len(r.Nodes) == 0 {
+		return false
+	}
+	for _, n := range r.Nodes {
+		if n.Alive && n.Err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// Connectivity connects to the meta servers of the cluster with the options of the collection,
+// lists the nodes and the tables, and fetches the perf-counters of each alive node once.
+func Connectivity(ctx context.Context, cluster aggregate.ClusterConfig, opts aggregate.PerfClientOptions) *ClusterReport {
+	r := &ClusterReport{Cluster: cluster.Name, MetaServers: cluster.MetaServers}
+	client := aggregate.NewPerfClientWithOptions(cluster.MetaServers, opts)
+	defer client.Close()
+
+	if r.MetaErr = client.MetaHealth(ctx); r.MetaErr != nil {
+		return r
+	}
+	r.MetaLeader, _ = client.MetaLeader(ctx)
+
+	tables, err := client.GetTableInfoMap(ctx)
+	if err != nil {
+		r.TablesErr = err
+	}
+	for _, tb := range tables {
+		r.Tables++
+		r.Partitions += int(tb.PartitionCount)
+	}
+
+	alive, err := client.ListAliveNodes(ctx)
+	if err != nil {
+		r.NodesErr = err
+		return r
+	}
+	dead, err := client.ListDeadNodes(ctx)
+	if err != nil {
+		r.NodesErr = err
+		return r
+	}
+	// GetNodeStats fetches the alive nodes listed again, which may have changed in between
+	stats, err := client.GetNodeStats(ctx, "")
+	counters := make(map[string]int)
+	for _, n := range stats {
+		counters[n.Addr] = len(n.Stats)
+	}
+	var failures map[string]error
+	if perr, ok := err.(*aggregate.PartialError); ok {
+		failures = perr.Nodes
+	} else if err != nil {
+		r.NodesErr = err
+		return r
+	}
+	sessions := client.NodeSessionStats()
+	for _, n := range alive {
+		addr := n.Address.GetAddress()
+		node := NodeReport{Addr: addr, Alive: true, Counters: counters[addr], Err: failures[addr]}
+		if s, found := sessions[addr]; found {
+			node.Duration = s.LastRPCDuration
+		}
+		if _, found := counters[addr]; !found && node.Err == nil {
+			node.Err = errors.New("not fetched")
+		}
+		r.Nodes = append(r.Nodes, node)
+	}
+	for _, n := range dead {
+		r.Nodes = append(r.Nodes, NodeReport{Addr: n.Address.GetAddress()})
+	}
+	sort.SliceStable(r.Nodes, func(i, j int) bool {
+		if r.Nodes[i].Alive != r.Nodes[j].Alive {
+			return r.Nodes[i].Alive
+		}
+		return r.Nodes[i].Addr < r.Nodes[j].Addr
+	})
+	return r
+}
+
+// Print writes the report in the human-readable form.
+func (r *ClusterReport) Print(w io.Writer) {
+	fmt.Fprintf(w, "cluster %s, meta servers %v\n", r.Cluster, r.MetaServers)
+	if r.MetaErr != nil {
+		fmt.Fprintf(w, "  meta servers:  FAILED, %s\n", r.MetaErr)
+	} else {
+		leader := r.MetaLeader
+		if leader == "" {
+			leader = "unknown"
+		}
+		fmt.Fprintf(w, "  meta servers:  OK, leader %s\n", leader)
+		if r.TablesErr != nil {
+			fmt.Fprintf(w, "  tables:        FAILED, %s\n", r.TablesErr)
+		} else {
+			fmt.Fprintf(w, "  tables:        OK, %d tables, %d partitions\n", r.Tables, r.Partitions)
+		}
+		if r.NodesErr != nil {
+			fmt.Fprintf(w, "  replica nodes: FAILED, %s\n", r.NodesErr)
+		} else {
+			alive := 0
+			for _, n := range r.Nodes {
+				if n.Alive {
+					alive++
+				}
+			}
+			fmt.Fprintf(w, "  replica nodes: %d alive, %d unalive\n", alive, len(r.Nodes)-alive)
+		}
+		for _, n := range r.Nodes {
+			switch {
+			case !n.Alive:
+				fmt.Fprintf(w, "    %-24s SKIPPED, unalive\n", n.Addr)
+			case n.Err != nil:
+				fmt.Fprintf(w, "    %-24s FAILED, %s\n", n.Addr, n.Err)
+			default:
+				fmt.Fprintf(w, "    %-24s OK, %d perf-counters in %s\n", n.Addr, n.Counters, n.Duration.Round(time.Millisecond))
+			}
+		}
+	}
+	if r.Ready() {
+		fmt.Fprintf(w, "  READY\n")
+	} else {
+		fmt.Fprintf(w, "  NOT READY\n")
+	}
+}
@@ -0,0 +1,54 @@
+package check
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/pegasus-kv/collector/aggregate"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConnectivityUnreachable(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	r := Connectivity(ctx, aggregate.ClusterConfig{Name: "onebox", MetaServers: []string{"127.0.0.1:1"}},
+		aggregate.DefaultPerfClientOptions())
+	assert.NotNil(t, r.MetaErr)
+	assert.False(t, r.Ready())
+}
+
+func TestClusterReport(t *testing.T) {
+	r := &ClusterReport{
+		Cluster:     "onebox",
+		MetaServers: []string{"127.0.0.1:34601"},
+		MetaLeader:  "127.0.0.1:34601",
+		Tables:      2,
+		Partitions:  16,
+		Nodes: []NodeReport{
+			{Addr: "127.0.0.1:34801", Alive: true, Counters: 100, Duration: 12 * time.Millisecond},
+			{Addr: "127.0.0.1:34803"},
+		},
+	}
+	// the unalive node doesn't matter
+	assert.True(t, r.Ready())
+	var buf bytes.Buffer
+	r.Print(&buf)
+	assert.Equal(t, buf.String(), `cluster onebox, meta servers [127.0.0.1:34601]
+  meta servers:  OK, leader 127.0.0.1:34601
+  tables:        OK, 2 tables, 16 partitions
+  replica nodes: 1 alive, 1 unalive
+    127.0.0.1:34801          OK, 100 perf-counters in 12ms
+    127.0.0.1:34803          SKIPPED, unalive
+  READY
+`)
+
+	r.Nodes[0].Err = errors.New("timeout")
+	assert.False(t, r.Ready())
+	buf.Reset()
+	r.Print(&buf)
+	assert.Contains(t, buf.String(), "127.0.0.1:34801          FAILED, timeout\n")
+	assert.Contains(t, buf.String(), "NOT READY")
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/pegasus-kv/collector/aggregate"
+	"github.com/pegasus-kv/collector/check"
+	"github.com/pegasus-kv/collector/logging"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// subcommands run instead of the collector, e.g. `collector check-config`, each of which
+// returns the exit code.
+var subcommands = map[string]func(args []string) int{
+	"check-config": checkConfigCommand,
+	"check":        checkCommand,
+	"dump":         dumpCommand,
+}
+
+// checkConfigCommand validates the config file, and prints the issues with their lines.
+func checkConfigCommand(args []string) int {
+	fs := flag.NewFlagSet("check-config", flag.ExitOnError)
+	file := fs.String("config", "config.yml", "the path of the config file")
+	fs.Parse(args)
+
+	issues, err := check.Config(*file)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", *file, err)
+		return 1
+	}
+	for _, issue := range issues {
+		// the location is like "config.yml:12", as the compilers print
+		if issue.Line != 0 {
+			fmt.Fprintf(os.Stderr, "%s:%d: %s\n", *file, issue.Line, issue)
+		} else {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", *file, issue)
+		}
+	}
+	if len(issues) != 0 {
+		fmt.Fprintf(os.Stderr, "%d issues are found in %s\n", len(issues), *file)
+		return 1
+	}
+	fmt.Printf("%s is valid\n", *file)
+	return 0
+}
+
+// checkCommand connects to the clusters configured, and prints whether they're ready to be
+// collected, i.e. the meta servers, the tables and every alive replica node are reachable.
+func checkCommand(args []string) int {
+	fs := flag.NewFlagSet("check", flag.ExitOnError)
+	file := fs.String("config", "config.yml", "the path of the config file")
+	cluster := fs.String("cluster", "", "the cluster to check, all clusters configured if it's empty")
+	timeout := fs.Duration("timeout", 30*time.Second, "the timeout of checking each cluster")
+	fs.Parse(args)
+
+	// the errors are printed in the report instead
+	logging.SetLevel(logrus.FatalLevel)
+	viper.SetConfigFile(*file)
+	viper.SetConfigType("yaml")
+	if err := viper.ReadInConfig(); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to read config: %s\n", err)
+		return 1
+	}
+	clusters, err := aggregate.ClustersFromConfig()
+	if err == nil && len(clusters) == 0 {
+		err = errors.New("no cluster is configured, the clusters discovered from ZooKeeper are not checked")
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	opts, err := aggregate.PerfClientOptionsFromConfig()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	code, checked := 0, 0
+	for _, c := range clusters {
+		if *cluster != "" && c.Name != *cluster {
+			continue
+		}
+		checked++
+		ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+		report := check.Connectivity(ctx, c, opts)
+		cancel()
+		report.Print(os.Stdout)
+		if !report.Ready() {
+			code = 1
+		}
+	}
+	if checked == 0 {
+		fmt.Fprintf(os.Stderr, "cluster %q is not configured\n", *cluster)
+		return 1
+	}
+	return code
+}
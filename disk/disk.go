@@ -0,0 +1,298 @@
+package disk
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/XiaoMi/pegasus-go-client/idl/admin"
+	"github.com/XiaoMi/pegasus-go-client/idl/radmin"
+	"github.com/XiaoMi/pegasus-go-client/session"
+	"github.com/pegasus-kv/collector/logging"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/spf13/viper"
+	"gopkg.in/tomb.v2"
+)
+
+var log = logging.Module("disk")
+
+// Stat is the usage of a data directory of a replica node, reported by query_disk_info.
+type Stat struct {
+	Node string `json:"node"`
+	Tag  string `json:"tag"`
+	Dir  string `json:"dir"`
+
+	CapacityMB  int64 `json:"capacity_mb"`
+	AvailableMB int64 `json:"available_mb"`
+
+	// The number of the replicas held on the disk.
+	PrimaryReplicas   int `json:"primary_replicas"`
+	SecondaryReplicas int `json:"secondary_replicas"`
+}
+
+// UsedRatio returns the ratio of the used space of the disk, or 0 if the capacity is unknown.
+func (s *Stat) UsedRatio() float64 {
+	if s.CapacityMB <= 0 {
+		return 0
+	}
+	return float64(s.CapacityMB-s.AvailableMB) / float64(s.CapacityMB)
+}
+
+func newStats(node string, resp *radmin.QueryDiskInfoResponse) []Stat {
+	var res []Stat
+	for _, d := range resp.DiskInfos {
+		s := Stat{
+			Node:        node,
+			Tag:         d.Tag,
+			Dir:         d.FullDir,
+			CapacityMB:  d.DiskCapacityMb,
+			AvailableMB: d.DiskAvailableMb,
+		}
+		for _, gpids := range d.HoldingPrimaryReplicas {
+			s.PrimaryReplicas += len(gpids)
+		}
+		for _, gpids := range d.HoldingSecondaryReplicas {
+			s.SecondaryReplicas += len(gpids)
+		}
+		res = append(res, s)
+	}
+	return res
+}
+
+// nodeClient is the RPCs to the meta and the replica nodes used by the Collector.
+type nodeClient interface {
+	// aliveNodes returns the addresses of the alive replica nodes.
+	aliveNodes(ctx context.Context) ([]string, error)
+
+	queryDiskInfo(ctx context.Context, addr string) (*radmin.QueryDiskInfoResponse, error)
+
+	// forget closes the session to the node which is no longer alive.
+	forget(addr string)
+}
+
+// sessionClient reuses the sessions to the replica nodes over the rounds.
+type sessionClient struct {
+	meta *session.MetaManager
+
+	sessions map[string]*session.ReplicaSession
+}
+
+func newSessionClient(metaServers []string) *sessionClient {
+	return &sessionClient{
+		meta:     session.NewMetaManager(metaServers, session.NewNodeSession),
+		sessions: make(map[string]*session.ReplicaSession),
+	}
+}
+
+func (c *sessionClient) aliveNodes(ctx context.Context) ([]string, error) {
+	resp, err := c.meta.ListNodes(ctx, &admin.ListNodesRequest{Status: admin.NodeStatus_NS_ALIVE})
+	if err != nil {
+		return nil, err
+	}
+	var addrs []string
+	for _, n := range resp.Infos {
+		if n.Status == admin.NodeStatus_NS_ALIVE {
+			addrs = append(addrs, n.Address.GetAddress())
+		}
+	}
+	return addrs, nil
+}
+
+func (c *sessionClient) queryDiskInfo(ctx context.Context, addr string) (*radmin.QueryDiskInfoResponse, error) {
+	s, found := c.sessions[addr]
+	if !found {
+		s = &session.ReplicaSession{NodeSession: session.NewNodeSession(addr, session.NodeTypeReplica)}
+		c.sessions[addr] = s
+	}
+	return s.QueryDiskInfo(ctx, &radmin.QueryDiskInfoRequest{})
+}
+
+func (c *sessionClient) forget(addr string) {
+	if s, found := c.sessions[addr]; found {
+		s.Close()
+		delete(c.sessions, addr)
+	}
+}
+
+func (c *sessionClient) close() {
+	for addr := range c.sessions {
+		c.forget(addr)
+	}
+	c.meta.Close()
+}
+
+// Collector queries the disks of every alive replica node periodically, and exports them as the
+// prometheus metrics labelled by the node and the disk tag.
+type Collector struct {
+	client  nodeClient
+	cluster string
+	timeout time.Duration
+
+	capacity          *prometheus.GaugeVec
+	available         *prometheus.GaugeVec
+	usedRatio         *prometheus.GaugeVec
+	primaryReplicas   *prometheus.GaugeVec
+	secondaryReplicas *prometheus.GaugeVec
+	failures          *prometheus.CounterVec
+
+	lock  sync.RWMutex
+	stats []Stat
+	// the nodes queried in the last round
+	nodes map[string]bool
+}
+
+func newCollector(client nodeClient, registerer prometheus.Registerer, cluster string, timeout time.Duration) *Collector {
+	labels := []string{"cluster", "node", "disk"}
+	c := &Collector{
+		client:  client,
+		cluster: cluster,
+		timeout: timeout,
+		capacity: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "disk_capacity_mb",
+			Help: "The capacity of the data directory of the replica node.",
+		}, labels),
+		available: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "disk_available_mb",
+			Help: "The available space of the data directory of the replica node.",
+		}, labels),
+		usedRatio: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "disk_used_ratio",
+			Help: "The ratio of the used space of the data directory of the replica node.",
+		}, labels),
+		primaryReplicas: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "disk_primary_replica_count",
+			Help: "The number of the primaries held on the data directory of the replica node.",
+		}, labels),
+		secondaryReplicas: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "disk_secondary_replica_count",
+			Help: "The number of the secondaries held on the data directory of the replica node.",
+		}, labels),
+		failures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "disk_query_failures_total",
+			Help: "The number of the failed query_disk_info to the replica node.",
+		}, []string{"cluster", "node"}),
+		nodes: make(map[string]bool),
+	}
+	registerer.MustRegister(c.capacity, c.available, c.usedRatio, c.primaryReplicas, c.secondaryReplicas, c.failures)
+	return c
+}
+
+// collect queries the disks of all alive nodes. The disks of a node keep their last stats if
+// the query to the node fails.
+func (c *Collector) collect(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	addrs, err := c.client.aliveNodes(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list the alive nodes: %s", err)
+	}
+
+	c.lock.RLock()
+	last := make(map[string][]Stat)
+	for _, s := range c.stats {
+		last[s.Node] = append(last[s.Node], s)
+	}
+	c.lock.RUnlock()
+
+	var stats []Stat
+	current := make(map[string]bool)
+	for _, addr := range addrs {
+		current[addr] = true
+		resp, err := c.client.queryDiskInfo(ctx, addr)
+		if err != nil {
+			log.Warnf("failed to query the disks of %s: %s", addr, err)
+			c.failures.WithLabelValues(c.cluster, addr).Inc()
+			stats = append(stats, last[addr]...)
+			continue
+		}
+		stats = append(stats, newStats(addr, resp)...)
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Node != stats[j].Node {
+			return stats[i].Node < stats[j].Node
+		}
+		return stats[i].Tag < stats[j].Tag
+	})
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for addr := range c.nodes {
+		if !current[addr] {
+			c.client.forget(addr)
+		}
+	}
+	for _, s := range c.stats {
+		c.deleteGauges(s)
+	}
+	for _, s := range stats {
+		labels := []string{c.cluster, s.Node, s.Tag}
+		c.capacity.WithLabelValues(labels...).Set(float64(s.CapacityMB))
+		c.available.WithLabelValues(labels...).Set(float64(s.AvailableMB))
+		c.usedRatio.WithLabelValues(labels...).Set(s.UsedRatio())
+		c.primaryReplicas.WithLabelValues(labels...).Set(float64(s.PrimaryReplicas))
+		c.secondaryReplicas.WithLabelValues(labels...).Set(float64(s.SecondaryReplicas))
+	}
+	c.stats = stats
+	c.nodes = current
+	return nil
+}
+
+func (c *Collector) deleteGauges(s Stat) {
+	for _, g := range []*prometheus.GaugeVec{c.capacity, c.available, c.usedRatio, c.primaryReplicas, c.secondaryReplicas} {
+		g.DeleteLabelValues(c.cluster, s.Node, s.Tag)
+	}
+}
+
+// Stats returns the disks of the latest round, sorted by the node and the tag.
+func (c *Collector) Stats() []Stat {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return append([]Stat{}, c.stats...)
+}
+
+var (
+	defaultLock      sync.RWMutex
+	defaultCollector *Collector
+)
+
+// Stats returns the disks of the latest round of the collector run by Start, or nil if the
+// collection is disabled.
+func Stats() []Stat {
+	defaultLock.RLock()
+	defer defaultLock.RUnlock()
+	if defaultCollector == nil {
+		return nil
+	}
+	return defaultCollector.Stats()
+}
+
+// Start queries the disks of the replica nodes every "disk_info.interval" until the tomb dies,
+// which is disabled if the interval is 0.
+func Start(tom *tomb.Tomb) {
+	interval := viper.GetDuration("disk_info.interval")
+	if interval == 0 {
+		return
+	}
+	viper.SetDefault("disk_info.timeout", "10s")
+	client := newSessionClient(viper.GetStringSlice("meta_servers"))
+	defer client.close()
+	c := newCollector(client, prometheus.DefaultRegisterer, viper.GetString("cluster_name"), viper.GetDuration("disk_info.timeout"))
+	defaultLock.Lock()
+	defaultCollector = c
+	defaultLock.Unlock()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := c.collect(tom.Context(nil)); err != nil {
+			log.Errorf("failed to collect the disks: %s", err)
+		}
+		select {
+		case <-tom.Dying():
+			return
+		case <-ticker.C:
+		}
+	}
+}
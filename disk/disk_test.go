@@ -0,0 +1,98 @@
+package disk
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/XiaoMi/pegasus-go-client/idl/base"
+	"github.com/XiaoMi/pegasus-go-client/idl/radmin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeClient struct {
+	nodes     []string
+	disks     map[string]*radmin.QueryDiskInfoResponse
+	forgotten []string
+}
+
+func (c *fakeClient) aliveNodes(ctx context.Context) ([]string, error) {
+	return c.nodes, nil
+}
+
+func (c *fakeClient) queryDiskInfo(ctx context.Context, addr string) (*radmin.QueryDiskInfoResponse, error) {
+	if resp, found := c.disks[addr]; found {
+		return resp, nil
+	}
+	return nil, errors.New("timeout")
+}
+
+func (c *fakeClient) forget(addr string) {
+	c.forgotten = append(c.forgotten, addr)
+}
+
+func diskInfo(tag string, capacity, available int64, primaries, secondaries int) *radmin.DiskInfo {
+	d := &radmin.DiskInfo{
+		Tag:                      tag,
+		FullDir:                  "/home/work/ssd/" + tag,
+		DiskCapacityMb:           capacity,
+		DiskAvailableMb:          available,
+		HoldingPrimaryReplicas:   map[int32][]*base.Gpid{},
+		HoldingSecondaryReplicas: map[int32][]*base.Gpid{},
+	}
+	for i := 0; i < primaries; i++ {
+		d.HoldingPrimaryReplicas[1] = append(d.HoldingPrimaryReplicas[1], &base.Gpid{Appid: 1, PartitionIndex: int32(i)})
+	}
+	for i := 0; i < secondaries; i++ {
+		d.HoldingSecondaryReplicas[int32(i%2+1)] = append(d.HoldingSecondaryReplicas[int32(i%2+1)], &base.Gpid{Appid: int32(i%2 + 1)})
+	}
+	return d
+}
+
+func TestCollector(t *testing.T) {
+	client := &fakeClient{
+		nodes: []string{"127.0.0.1:34802", "127.0.0.1:34801"},
+		disks: map[string]*radmin.QueryDiskInfoResponse{
+			"127.0.0.1:34801": {DiskInfos: []*radmin.DiskInfo{
+				diskInfo("ssd2", 1000, 250, 1, 3),
+				diskInfo("ssd1", 1000, 500, 2, 4),
+			}},
+			"127.0.0.1:34802": {DiskInfos: []*radmin.DiskInfo{
+				diskInfo("ssd1", 2000, 2000, 0, 0),
+			}},
+		},
+	}
+	c := newCollector(client, prometheus.NewRegistry(), "onebox", time.Second)
+	assert.Nil(t, c.collect(context.Background()))
+
+	stats := c.Stats()
+	assert.Equal(t, len(stats), 3)
+	assert.Equal(t, stats[0], Stat{Node: "127.0.0.1:34801", Tag: "ssd1", Dir: "/home/work/ssd/ssd1",
+		CapacityMB: 1000, AvailableMB: 500, PrimaryReplicas: 2, SecondaryReplicas: 4})
+	assert.Equal(t, stats[1].Tag, "ssd2")
+	assert.Equal(t, stats[2].Node, "127.0.0.1:34802")
+	assert.Equal(t, testutil.ToFloat64(c.usedRatio.WithLabelValues("onebox", "127.0.0.1:34801", "ssd2")), 0.75)
+	assert.Equal(t, testutil.ToFloat64(c.secondaryReplicas.WithLabelValues("onebox", "127.0.0.1:34801", "ssd1")), float64(4))
+	assert.Equal(t, testutil.ToFloat64(c.usedRatio.WithLabelValues("onebox", "127.0.0.1:34802", "ssd1")), float64(0))
+
+	// the failed node keeps its last stats
+	delete(client.disks, "127.0.0.1:34801")
+	client.disks["127.0.0.1:34802"].DiskInfos[0].DiskAvailableMb = 1000
+	assert.Nil(t, c.collect(context.Background()))
+	stats = c.Stats()
+	assert.Equal(t, len(stats), 3)
+	assert.Equal(t, stats[0].AvailableMB, int64(500))
+	assert.Equal(t, stats[2].AvailableMB, int64(1000))
+	assert.Equal(t, testutil.ToFloat64(c.failures.WithLabelValues("onebox", "127.0.0.1:34801")), float64(1))
+
+	// the node no longer alive is removed
+	client.nodes = []string{"127.0.0.1:34802"}
+	assert.Nil(t, c.collect(context.Background()))
+	stats = c.Stats()
+	assert.Equal(t, len(stats), 1)
+	assert.Equal(t, client.forgotten, []string{"127.0.0.1:34801"})
+	assert.Equal(t, testutil.CollectAndCount(c.capacity), 1)
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/pegasus-kv/collector/aggregate"
+	"github.com/pegasus-kv/collector/logging"
+	"github.com/pegasus-kv/collector/metrics"
+	"github.com/pegasus-kv/collector/output"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// dumpCommand aggregates the stats of a cluster once, and prints them to stdout.
+func dumpCommand(args []string) int {
+	fs := flag.NewFlagSet("dump", flag.ExitOnError)
+	file := fs.String("config", "config.yml", "the path of the config file")
+	cluster := fs.String("cluster", "", "the cluster to dump, the first cluster configured if it's empty")
+	table := fs.String("table", "", "the table to dump, all tables and the cluster if it's empty")
+	format := fs.String("format", "json", "the output format, json, csv or text")
+	top := fs.Int("top", 10, "the number of the tables listed by write QPS, in text only, 0 for all")
+	partitions := fs.Bool("partitions", false, "dump the stats of every partition as well, in json only")
+	timeout := fs.Duration("timeout", time.Minute, "the timeout of the aggregation")
+	fs.Parse(args)
+
+	if *format != "json" && *format != "csv" && *format != "text" {
+		fmt.Fprintf(os.Stderr, "invalid format %q, which should be json, csv or text\n", *format)
+		return 2
+	}
+	// only the failures are logged, to stderr
+	logging.SetLevel(logrus.WarnLevel)
+	viper.SetConfigFile(*file)
+	viper.SetConfigType("yaml")
+	if err := viper.ReadInConfig(); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to read config: %s\n", err)
+		return 1
+	}
+	c, err := dumpedCluster(*cluster)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	opts, err := aggregate.PerfClientOptionsFromConfig()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	opts.ClusterName = c.Name
+	if *table != "" {
+		name := *table
+		opts.TableFilter = func(table string) bool {
+			return table == name
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	ag := aggregate.NewTableStatsAggregatorWithOptions(c.MetaServers, opts)
+	tableMap, allStats := ag.Aggregate(ctx)
+	ag.Close()
+	if allStats == nil {
+		fmt.Fprintf(os.Stderr, "failed to aggregate the stats of cluster %s\n", c.Name)
+		return 1
+	}
+	var tables []aggregate.TableStats
+	for _, tb := range tableMap {
+		tables = append(tables, *tb)
+	}
+	sort.Slice(tables, func(i, j int) bool {
+		return tables[i].TableName < tables[j].TableName
+	})
+	if *table != "" {
+		if len(tables) == 0 {
+			fmt.Fprintf(os.Stderr, "table %q is not found in cluster %s\n", *table, c.Name)
+			return 1
+		}
+		// the cluster stats are of the table only
+		allStats = nil
+	}
+
+	switch *format {
+	case "csv":
+		writeDumpCSV(os.Stdout, tables, allStats)
+		return 0
+	case "text":
+		writeDumpText(os.Stdout, tables, *top)
+		return 0
+	}
+	if err := writeDumpJSON(os.Stdout, c.Name, tables, allStats, *partitions); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+// dumpedCluster returns the cluster of the name, or the first cluster if the name is empty.
+func dumpedCluster(name string) (aggregate.ClusterConfig, error) {
+	clusters, err := aggregate.ClustersFromConfig()
+	if err != nil {
+		return aggregate.ClusterConfig{}, err
+	}
+	if len(clusters) == 0 {
+		return aggregate.ClusterConfig{}, errors.New("no cluster is configured, the clusters discovered from ZooKeeper are not dumped")
+	}
+	if name == "" {
+		return clusters[0], nil
+	}
+	for _, c := range clusters {
+		if c.Name == name {
+			return c, nil
+		}
+	}
+	return aggregate.ClusterConfig{}, fmt.Errorf("cluster %q is not configured", name)
+}
+
+// writeDumpCSV writes the rows of "timestamp,table,metric,value" as the csv sink does, where
+// the table of the cluster stats is empty.
+func writeDumpCSV(w io.Writer, tables []aggregate.TableStats, allStats *aggregate.ClusterStats) {
+	if allStats != nil {
+		tables = append(tables, aggregate.TableStats{Timestamp: allStats.Timestamp, Stats: allStats.Stats})
+	}
+	metrics.NewCSVSink(w).Report(tables, aggregate.ClusterStats{})
+}
+
+// writeDumpText writes the human-readable summary of the cluster and the top tables.
+func writeDumpText(w io.Writer, tables []aggregate.TableStats, top int) {
+	var ptrs []*aggregate.TableStats
+	for i := range tables {
+		ptrs = append(ptrs, &tables[i])
+	}
+	io.WriteString(w, output.FormatClusterSummary(ptrs, top))
+}
+
+type dumpJSON struct {
+	Cluster string `json:"cluster"`
+	// absent if a single table is dumped
+	ClusterStats *dumpStatsJSON  `json:"cluster_stats,omitempty"`
+	Tables       []dumpTableJSON `json:"tables"`
+}
+
+type dumpStatsJSON struct {
+	Timestamp      time.Time          `json:"timestamp"`
+	Stats          map[string]float64 `json:"stats"`
+	SecondaryStats map[string]float64 `json:"secondary_stats,omitempty"`
+}
+
+type dumpTableJSON struct {
+	TableName string `json:"table_name"`
+	AppID     int    `json:"app_id"`
+	dumpStatsJSON
+	Partitions []dumpPartitionJSON `json:"partitions,omitempty"`
+}
+
+type dumpPartitionJSON struct {
+	PartitionIndex int                `json:"partition_index"`
+	Addr           string             `json:"addr"`
+	Stats          map[string]float64 `json:"stats"`
+}
+
+// writeDumpJSON writes the stats in the same fields as the JSON API.
+func writeDumpJSON(w io.Writer, cluster string, tables []aggregate.TableStats, allStats *aggregate.ClusterStats, withPartitions bool) error {
+	res := dumpJSON{Cluster: cluster, Tables: []dumpTableJSON{}}
+	if allStats != nil {
+		res.ClusterStats = &dumpStatsJSON{Timestamp: allStats.Timestamp, Stats: allStats.Stats, SecondaryStats: allStats.SecondaryStats}
+	}
+	for _, tb := range tables {
+		t := dumpTableJSON{
+			TableName:     tb.TableName,
+			AppID:         tb.AppID,
+			dumpStatsJSON: dumpStatsJSON{Timestamp: tb.Timestamp, Stats: tb.Stats, SecondaryStats: tb.SecondaryStats},
+		}
+		if withPartitions {
+			for idx, part := range tb.Partitions {
+				t.Partitions = append(t.Partitions, dumpPartitionJSON{PartitionIndex: idx, Addr: part.Addr, Stats: part.Stats})
+			}
+			sort.Slice(t.Partitions, func(i, j int) bool {
+				return t.Partitions[i].PartitionIndex < t.Partitions[j].PartitionIndex
+			})
+		}
+		res.Tables = append(res.Tables, t)
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(res)
+}
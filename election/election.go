@@ -0,0 +1,317 @@
+// Package election elects the leader of the collectors of the same cluster through ZooKeeper,
+// so that only the leader reports the metrics and probes the availability, while the standbys
+// take over once the leader dies.
+package election
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-zookeeper/zk"
+	"github.com/pegasus-kv/collector/logging"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/spf13/viper"
+	"gopkg.in/tomb.v2"
+)
+
+var log = logging.Module("election")
+
+// Config is the configuration of the election.
+type Config struct {
+	// The ZooKeeper servers.
+	Servers []string
+
+	// The collectors campaigning under the same path compete for the leadership.
+	Path string
+
+	// The leader is considered dead by ZooKeeper if its session times out.
+	SessionTimeout time.Duration `mapstructure:"session_timeout"`
+}
+
+// Validate checks the configuration.
+func (cfg *Config) Validate() error {
+	if len(cfg.Servers) == 0 {
+		return fmt.Errorf("no ZooKeeper server of the election")
+	}
+	if !strings.HasPrefix(cfg.Path, "/") || path.Clean(cfg.Path) != cfg.Path || cfg.Path == "/" {
+		return fmt.Errorf("invalid path %q of the election, which should be absolute and not the root", cfg.Path)
+	}
+	return nil
+}
+
+// nodePrefix is the prefix of the ephemeral sequential node of each candidate.
+const nodePrefix = "candidate-"
+
+// retryInterval is the interval to campaign again after a failure.
+const retryInterval = 5 * time.Second
+
+// HookAfterLeadershipChanged is a hook of event that this collector becomes the leader or
+// steps down.
+type HookAfterLeadershipChanged func(leader bool)
+
+// AddHookAfterLeadershipChanged adds a hook of event that this collector becomes the leader or
+// steps down.
+func AddHookAfterLeadershipChanged(hk HookAfterLeadershipChanged) {
+	hooks.lock.Lock()
+	defer hooks.lock.Unlock()
+	hooks.changed = append(hooks.changed, hk)
+}
+
+type hooksManager struct {
+	lock    sync.RWMutex
+	changed []HookAfterLeadershipChanged
+}
+
+var hooks hooksManager
+
+func (m *hooksManager) afterLeadershipChanged(leader bool) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	for _, hk := range m.changed {
+		hk(leader)
+	}
+}
+
+// Elector campaigns for the leadership with an ephemeral sequential node under the path, and
+// the candidate of the smallest sequence is the leader. The node is removed by ZooKeeper once
+// the session of the candidate expires, e.g. the collector dies.
+type Elector struct {
+	cfg Config
+	// the identity of this collector, which is the data of its node
+	id string
+
+	conn   *zk.Conn
+	events <-chan zk.Event
+	// the name of the node of this collector, empty if it's not created yet
+	node string
+
+	isLeader prometheus.Gauge
+
+	lock   sync.RWMutex
+	leader bool
+}
+
+// NewElector returns an Elector connecting to the ZooKeeper in the background.
+func NewElector(cfg Config, id string, registerer prometheus.Registerer) (*Elector, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	if cfg.SessionTimeout <= 0 {
+		cfg.SessionTimeout = 10 * time.Second
+	}
+	conn, events, err := zk.Connect(cfg.Servers, cfg.SessionTimeout, zk.WithLogger(log))
+	if err != nil {
+		return nil, err
+	}
+	e := &Elector{
+		cfg:    cfg,
+		id:     id,
+		conn:   conn,
+		events: events,
+		isLeader: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "collector_is_leader",
+			Help: "1 if this collector is the leader of the election.",
+		}),
+	}
+	registerer.MustRegister(e.isLeader)
+	return e, nil
+}
+
+// IsLeader returns whether this collector is the leader.
+func (e *Elector) IsLeader() bool {
+	e.lock.RLock()
+	defer e.lock.RUnlock()
+	return e.leader
+}
+
+func (e *Elector) setLeader(leader bool) {
+	e.lock.Lock()
+	changed := e.leader != leader
+	e.leader = leader
+	e.lock.Unlock()
+	if !changed {
+		return
+	}
+	if leader {
+		log.Infof("%s becomes the leader of %s", e.id, e.cfg.Path)
+		e.isLeader.Set(1)
+	} else {
+		log.Infof("%s steps down from the leader of %s", e.id, e.cfg.Path)
+		e.isLeader.Set(0)
+	}
+	hooks.afterLeadershipChanged(leader)
+}
+
+// Run campaigns for the leadership until ctx is done, after which the node is removed so that
+// a standby takes over immediately. It steps down once the connection to ZooKeeper is lost,
+// since the session may expire without being noticed.
+func (e *Elector) Run(ctx context.Context) {
+	defer e.conn.Close()
+	for {
+		var retry <-chan time.Time
+		watch, err := e.campaign()
+		if err != nil {
+			log.Errorf("failed to campaign for the leader of %s, retry in %s: %s", e.cfg.Path, retryInterval, err)
+			e.setLeader(false)
+			retry = time.After(retryInterval)
+		}
+		select {
+		case <-ctx.Done():
+			e.setLeader(false)
+			if e.node != "" {
+				if err := e.conn.Delete(path.Join(e.cfg.Path, e.node), -1); err != nil {
+					log.Warnf("failed to remove the candidate %s: %s", e.node, err)
+				}
+			}
+			return
+		case <-watch:
+		case <-retry:
+		case ev := <-e.events:
+			if ev.Type == zk.EventSession && ev.State != zk.StateHasSession {
+				e.setLeader(false)
+			}
+		}
+	}
+}
+
+// campaign creates the node of this collector if it's absent, and updates the leadership by
+// the candidates. It returns the watch of the candidates.
+func (e *Elector) campaign() (<-chan zk.Event, error) {
+	if e.conn.State() != zk.StateHasSession {
+		return nil, fmt.Errorf("no session to ZooKeeper in state %s", e.conn.State())
+	}
+	if err := e.ensurePath(); err != nil {
+		return nil, err
+	}
+	for {
+		children, _, watch, err := e.conn.ChildrenW(e.cfg.Path)
+		if err != nil {
+			return nil, err
+		}
+		if e.node == "" || !contains(children, e.node) {
+			// the node is removed once the session expires
+			created, err := e.conn.CreateProtectedEphemeralSequential(path.Join(e.cfg.Path, nodePrefix), []byte(e.id), zk.WorldACL(zk.PermAll))
+			if err != nil {
+				return nil, err
+			}
+			e.node = path.Base(created)
+			continue
+		}
+		leader := leaderOf(children)
+		e.setLeader(leader == e.node)
+		return watch, nil
+	}
+}
+
+// ensurePath creates the path and its parents if they're absent.
+func (e *Elector) ensurePath() error {
+	p := ""
+	for _, part := range strings.Split(e.cfg.Path[1:], "/") {
+		p += "/" + part
+		_, err := e.conn.Create(p, nil, 0, zk.WorldACL(zk.PermAll))
+		if err != nil && err != zk.ErrNodeExists {
+			return fmt.Errorf("failed to create %s: %s", p, err)
+		}
+	}
+	return nil
+}
+
+// leaderOf returns the candidate of the smallest sequence, which is the suffix of every name.
+func leaderOf(candidates []string) string {
+	var sorted []string
+	for _, c := range candidates {
+		if strings.Contains(c, nodePrefix) {
+			sorted = append(sorted, c)
+		}
+	}
+	if len(sorted) == 0 {
+		return ""
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return sequenceOf(sorted[i]) < sequenceOf(sorted[j])
+	})
+	return sorted[0]
+}
+
+// sequenceOf returns the sequence appended by ZooKeeper, which is zero padded to 10 digits.
+func sequenceOf(node string) string {
+	return node[strings.LastIndex(node, nodePrefix)+len(nodePrefix):]
+}
+
+func contains(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	defaultLock    sync.RWMutex
+	defaultElector *Elector
+)
+
+// Default returns the Elector run by Start, or nil if the election is disabled.
+func Default() *Elector {
+	defaultLock.RLock()
+	defer defaultLock.RUnlock()
+	return defaultElector
+}
+
+// Enabled returns whether the collector campaigns for the leadership, i.e. any server of
+// "election.zookeeper.servers" is given.
+func Enabled() bool {
+	return len(viper.GetStringSlice("election.zookeeper.servers")) != 0
+}
+
+// IsLeader returns whether this collector is the leader, which is always true if the election
+// is disabled, and false until the election starts otherwise.
+func IsLeader() bool {
+	if !Enabled() {
+		return true
+	}
+	e := Default()
+	return e != nil && e.IsLeader()
+}
+
+// FromConfig returns the configuration of "election.zookeeper".
+func FromConfig() (Config, error) {
+	var cfg Config
+	err := viper.UnmarshalKey("election.zookeeper", &cfg)
+	return cfg, err
+}
+
+// Start campaigns for the leadership among the collectors of "election.zookeeper" until the
+// tomb dies. It returns immediately if the election is disabled.
+func Start(tom *tomb.Tomb) {
+	if !Enabled() {
+		return
+	}
+	cfg, err := FromConfig()
+	if err != nil {
+		log.Fatal("failed to read the election config: ", err)
+		return
+	}
+	host, err := os.Hostname()
+	if err != nil {
+		host = "collector"
+	}
+	id := fmt.Sprintf("%s:%d", host, viper.GetInt("port"))
+	e, err := NewElector(cfg, id, prometheus.DefaultRegisterer)
+	if err != nil {
+		log.Fatal("failed to start the election: ", err)
+		return
+	}
+	defaultLock.Lock()
+	defaultElector = e
+	defaultLock.Unlock()
+	log.Infof("campaign for the leader of %s as %s", cfg.Path, id)
+	e.Run(tom.Context(nil))
+}
@@ -0,0 +1,41 @@
+package election
+
+import (
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLeaderOf(t *testing.T) {
+	assert.Equal(t, leaderOf(nil), "")
+	assert.Equal(t, leaderOf([]string{
+		"_c_0f2e8b0a4a1b4c5d8e9f0a1b2c3d4e5f-candidate-0000000012",
+		"_c_1a2b3c4d5e6f7a8b9c0d1e2f3a4b5c6d-candidate-0000000003",
+		"unknown",
+		"_c_2b3c4d5e6f7a8b9c0d1e2f3a4b5c6d7e-candidate-0000000010",
+	}), "_c_1a2b3c4d5e6f7a8b9c0d1e2f3a4b5c6d-candidate-0000000003")
+}
+
+func TestValidate(t *testing.T) {
+	for _, cfg := range []Config{
+		{Path: "/pegasus/election"},
+		{Servers: []string{"127.0.0.1:2181"}, Path: "pegasus/election"},
+		{Servers: []string{"127.0.0.1:2181"}, Path: "/pegasus/election/"},
+		{Servers: []string{"127.0.0.1:2181"}, Path: "/"},
+	} {
+		assert.NotNil(t, cfg.Validate())
+	}
+	cfg := Config{Servers: []string{"127.0.0.1:2181"}, Path: "/pegasus/election"}
+	assert.Nil(t, cfg.Validate())
+}
+
+func TestIsLeader(t *testing.T) {
+	// always the leader without the election
+	assert.True(t, IsLeader())
+
+	viper.Set("election.zookeeper.servers", []string{"127.0.0.1:2181"})
+	defer viper.Set("election.zookeeper.servers", nil)
+	// not the leader until elected
+	assert.False(t, IsLeader())
+}
@@ -0,0 +1,244 @@
+package events
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// Kind is the kind of an Event.
+type Kind string
+
+// The kinds of the events observed by the collector.
+const (
+	TableCreated          Kind = "table_created"
+	TableDropped          Kind = "table_dropped"
+	PartitionCountChanged Kind = "partition_count_changed"
+	PrimaryMigrated       Kind = "primary_migrated"
+	NodeDown              Kind = "node_down"
+	NodeUp                Kind = "node_up"
+	MetaLeaderChanged     Kind = "meta_leader_changed"
+	AnomalyDetected       Kind = "anomaly_detected"
+	AnomalyResolved       Kind = "anomaly_resolved"
+)
+
+// Event is a notable change of the cluster, to correlate with the anomalies of the metrics.
+type Event struct {
+	// ID increases with the events recorded, starting from 1.
+	ID      uint64    `json:"id"`
+	Time    time.Time `json:"time"`
+	Cluster string    `json:"cluster"`
+	Kind    Kind      `json:"kind"`
+
+	// The table or the node that the event is about, if any.
+	Table string `json:"table,omitempty"`
+	Node  string `json:"node,omitempty"`
+
+	Message string `json:"message"`
+}
+
+// LogConfig is the configuration of Log.
+type LogConfig struct {
+	// The number of the recent events kept in memory for the queries.
+	Capacity int
+
+	// The events are appended to the file as JSON lines if it's not empty, where the recent
+	// events are loaded from on start.
+	Path string
+}
+
+// Validate checks the configuration.
+func (cfg *LogConfig) Validate() error {
+	if cfg.Capacity <= 0 {
+		return fmt.Errorf("invalid capacity of the event log: %d", cfg.Capacity)
+	}
+	return nil
+}
+
+// Log is an append-only log of the events, whose recent events are kept in memory.
+type Log struct {
+	cfg     LogConfig
+	cluster string
+
+	lock   sync.RWMutex
+	events []Event
+	lastID uint64
+	file   *os.File
+}
+
+// NewLog returns a Log, which loads the recent events from the file if it's configured.
+func NewLog(cfg LogConfig, cluster string) (*Log, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	l := &Log{cfg: cfg, cluster: cluster}
+	if cfg.Path == "" {
+		return l, nil
+	}
+	if err := l.load(); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(cfg.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	l.file = f
+	return l, nil
+}
+
+func (l *Log) load() error {
+	f, err := os.Open(l.cfg.Path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var e Event
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			// e.g. the last line partially written before a crash
+			log.Warnf("skip the corrupted event in %s: %s", l.cfg.Path, err)
+			continue
+		}
+		l.append(e)
+	}
+	return scanner.Err()
+}
+
+// append keeps the event in memory. It must be called with the lock held.
+func (l *Log) append(e Event) {
+	if e.ID > l.lastID {
+		l.lastID = e.ID
+	}
+	l.events = append(l.events, e)
+	if len(l.events) > 2*l.cfg.Capacity {
+		// trimmed in batches, rather than copying on every event
+		l.events = append([]Event{}, l.events[len(l.events)-l.cfg.Capacity:]...)
+	}
+}
+
+// Record appends the event with the next ID, the cluster of the log if it has no cluster, and
+// the current time if it has no time. The recorded event is passed to the hooks.
+func (l *Log) Record(e Event) {
+	l.lock.Lock()
+	e.ID = l.lastID + 1
+	if e.Cluster == "" {
+		e.Cluster = l.cluster
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	l.append(e)
+	if l.file != nil {
+		data, err := json.Marshal(e)
+		if err == nil {
+			_, err = l.file.Write(append(data, '\n'))
+		}
+		if err != nil {
+			log.Errorf("failed to write the event %d to %s: %s", e.ID, l.cfg.Path, err)
+		}
+	}
+	l.lock.Unlock()
+
+	log.Infof("event %s: %s", e.Kind, e.Message)
+	hooks.afterEventRecorded(e)
+}
+
+// Filter selects the events to query. The zero value selects all events.
+type Filter struct {
+	// Any of the kinds if it's not empty.
+	Kinds []Kind
+
+	Table string
+	Node  string
+
+	// The range of the time, which is unbounded on the side of a zero value.
+	Start time.Time
+	End   time.Time
+
+	// At most Limit events are returned if it's positive.
+	Limit int
+}
+
+func (f *Filter) match(e *Event) bool {
+	if len(f.Kinds) != 0 {
+		found := false
+		for _, k := range f.Kinds {
+			found = found || k == e.Kind
+		}
+		if !found {
+			return false
+		}
+	}
+	if (f.Table != "" && e.Table != f.Table) || (f.Node != "" && e.Node != f.Node) {
+		return false
+	}
+	if (!f.Start.IsZero() && e.Time.Before(f.Start)) || (!f.End.IsZero() && e.Time.After(f.End)) {
+		return false
+	}
+	return true
+}
+
+// Query returns the events in memory selected by the filter, the latest first.
+func (l *Log) Query(f Filter) []Event {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+	res := []Event{}
+	events := l.events
+	if len(events) > l.cfg.Capacity {
+		events = events[len(events)-l.cfg.Capacity:]
+	}
+	for i := len(events) - 1; i >= 0; i-- {
+		if f.Limit > 0 && len(res) >= f.Limit {
+			break
+		}
+		if f.match(&events[i]) {
+			res = append(res, events[i])
+		}
+	}
+	return res
+}
+
+// Close closes the file of the log.
+func (l *Log) Close() error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.file == nil {
+		return nil
+	}
+	err := l.file.Close()
+	l.file = nil
+	return err
+}
+
+// HookAfterEventRecorded is a hook of event that an event is recorded in the log.
+type HookAfterEventRecorded func(e Event)
+
+// AddHookAfterEventRecorded adds a hook of event that an event is recorded in the log,
+// e.g. to export the events.
+func AddHookAfterEventRecorded(hk HookAfterEventRecorded) {
+	hooks.lock.Lock()
+	defer hooks.lock.Unlock()
+	hooks.recorded = append(hooks.recorded, hk)
+}
+
+type hooksManager struct {
+	lock     sync.RWMutex
+	recorded []HookAfterEventRecorded
+}
+
+var hooks hooksManager
+
+func (m *hooksManager) afterEventRecorded(e Event) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	for _, hk := range m.recorded {
+		hk(e)
+	}
+}
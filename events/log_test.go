@@ -0,0 +1,70 @@
+package events
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "collector-events")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	cfg := LogConfig{Capacity: 3, Path: filepath.Join(dir, "events.log")}
+
+	l, err := NewLog(cfg, "onebox")
+	assert.Nil(t, err)
+	start := time.Date(2020, 11, 19, 0, 0, 0, 0, time.UTC)
+	l.Record(Event{Time: start, Kind: TableCreated, Table: "temp"})
+	l.Record(Event{Time: start.Add(time.Minute), Kind: NodeDown, Node: "127.0.0.1:34801"})
+	l.Record(Event{Time: start.Add(2 * time.Minute), Kind: PrimaryMigrated, Table: "temp", Node: "127.0.0.1:34802"})
+	l.Record(Event{Time: start.Add(3 * time.Minute), Kind: NodeUp, Node: "127.0.0.1:34801"})
+
+	// only the latest 3 events are kept in memory
+	all := l.Query(Filter{})
+	assert.Equal(t, len(all), 3)
+	assert.Equal(t, all[0].ID, uint64(4))
+	assert.Equal(t, all[0].Cluster, "onebox")
+	assert.Equal(t, all[2].Kind, NodeDown)
+
+	assert.Equal(t, len(l.Query(Filter{Node: "127.0.0.1:34801"})), 2)
+	assert.Equal(t, len(l.Query(Filter{Table: "temp"})), 1)
+	assert.Equal(t, len(l.Query(Filter{Kinds: []Kind{NodeUp, NodeDown}, Limit: 1})), 1)
+	inRange := l.Query(Filter{Start: start.Add(90 * time.Second), End: start.Add(150 * time.Second)})
+	assert.Equal(t, len(inRange), 1)
+	assert.Equal(t, inRange[0].Kind, PrimaryMigrated)
+	assert.Nil(t, l.Close())
+
+	// the events are loaded from the file, after a partially written line
+	f, err := os.OpenFile(cfg.Path, os.O_APPEND|os.O_WRONLY, 0644)
+	assert.Nil(t, err)
+	_, err = f.WriteString(`{"id":5,"ti`)
+	assert.Nil(t, err)
+	f.Close()
+	l, err = NewLog(cfg, "onebox")
+	assert.Nil(t, err)
+	defer l.Close()
+	assert.Equal(t, l.Query(Filter{}), all)
+	l.Record(Event{Kind: MetaLeaderChanged})
+	assert.Equal(t, l.Query(Filter{Limit: 1})[0].ID, uint64(5))
+}
+
+func TestLogHook(t *testing.T) {
+	var recorded []Event
+	AddHookAfterEventRecorded(func(e Event) {
+		recorded = append(recorded, e)
+	})
+	l, err := NewLog(LogConfig{Capacity: 10}, "onebox")
+	assert.Nil(t, err)
+	l.Record(Event{Kind: TableDropped, Table: "temp"})
+	assert.Equal(t, len(recorded), 1)
+	assert.Equal(t, recorded[0].ID, uint64(1))
+	assert.False(t, recorded[0].Time.IsZero())
+
+	_, err = NewLog(LogConfig{}, "onebox")
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,190 @@
+package events
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/pegasus-kv/collector/aggregate"
+	"github.com/pegasus-kv/collector/anomaly"
+	"github.com/pegasus-kv/collector/logging"
+	"github.com/spf13/viper"
+	"gopkg.in/tomb.v2"
+)
+
+var log = logging.Module("events")
+
+// recorder converts the changes observed by the aggregator into the events.
+type recorder struct {
+	log *Log
+
+	lock sync.Mutex
+	// cluster -> app ID -> the table of the last round, which is absent until the first round of
+	// the cluster, whose tables are not taken as created
+	clusters map[string]map[int]tableInfo
+}
+
+type tableInfo struct {
+	name       string
+	partitions int
+}
+
+func newRecorder(l *Log) *recorder {
+	return &recorder{log: l, clusters: make(map[string]map[int]tableInfo)}
+}
+
+// register adds the hooks of the aggregator.
+func (r *recorder) register() {
+	aggregate.AddHookAfterClusterStatsEmitted(r.onTableStats)
+	aggregate.AddHookAfterClusterTableDropped(r.onTableDropped)
+	aggregate.AddHookAfterClusterRemoved(r.onClusterRemoved)
+	aggregate.AddHookAfterConfigChanged(r.onConfigChanged)
+	aggregate.AddHookAfterNodeStateChanged(r.onNodeStateChanged)
+	aggregate.AddHookAfterMetaLeaderChanged(r.onMetaLeaderChanged)
+	anomaly.AddHookAfterAnomalyChanged(r.onAnomalyChanged)
+}
+
+func (r *recorder) onTableStats(stats []aggregate.TableStats, allStats aggregate.ClusterStats) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	tables, initialized := r.clusters[allStats.Cluster]
+	if !initialized {
+		tables = make(map[int]tableInfo)
+		r.clusters[allStats.Cluster] = tables
+	}
+	for _, tb := range stats {
+		prev, found := tables[tb.AppID]
+		tables[tb.AppID] = tableInfo{name: tb.TableName, partitions: len(tb.Partitions)}
+		if !found {
+			if initialized {
+				r.log.Record(Event{
+					Cluster: allStats.Cluster,
+					Kind:    TableCreated,
+					Table:   tb.TableName,
+					Message: fmt.Sprintf("table %s(%d) is created with %d partitions", tb.TableName, tb.AppID, len(tb.Partitions)),
+				})
+			}
+			continue
+		}
+		if prev.partitions != len(tb.Partitions) {
+			r.log.Record(Event{
+				Cluster: allStats.Cluster,
+				Kind:    PartitionCountChanged,
+				Table:   tb.TableName,
+				Message: fmt.Sprintf("the partition count of table %s(%d) changes from %d to %d", tb.TableName, tb.AppID, prev.partitions, len(tb.Partitions)),
+			})
+		}
+	}
+}
+
+func (r *recorder) onTableDropped(cluster string, appID int) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	tb, found := r.clusters[cluster][appID]
+	if !found {
+		return
+	}
+	delete(r.clusters[cluster], appID)
+	r.log.Record(Event{
+		Cluster: cluster,
+		Kind:    TableDropped,
+		Table:   tb.name,
+		Message: fmt.Sprintf("table %s(%d) is dropped", tb.name, appID),
+	})
+}
+
+func (r *recorder) onClusterRemoved(cluster string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	delete(r.clusters, cluster)
+}
+
+func (r *recorder) onConfigChanged(changes []*aggregate.ConfigChangeEvent) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	for _, c := range changes {
+		if c.Kind != aggregate.PrimaryMoved {
+			continue
+		}
+		r.log.Record(Event{
+			Time:    c.DetectedAt,
+			Cluster: c.Cluster,
+			Kind:    PrimaryMigrated,
+			Table:   r.clusters[c.Cluster][int(c.Gpid.Appid)].name,
+			Node:    c.NewPrimary,
+			Message: fmt.Sprintf("the primary of partition %s moves from %q to %q", c.Gpid.String(), c.OldPrimary, c.NewPrimary),
+		})
+	}
+}
+
+func (r *recorder) onNodeStateChanged(changes []*aggregate.NodeStateEvent) {
+	for _, c := range changes {
+		e := Event{Time: c.DetectedAt, Cluster: c.Cluster, Kind: NodeUp, Node: c.Addr}
+		if c.NewState == aggregate.NodeUnalive {
+			e.Kind = NodeDown
+		}
+		e.Message = fmt.Sprintf("replica node %s changes from %s to %s", c.Addr, c.OldState, c.NewState)
+		if c.Flapping {
+			e.Message += ", which is flapping"
+		}
+		r.log.Record(e)
+	}
+}
+
+func (r *recorder) onMetaLeaderChanged(c *aggregate.MetaLeaderChangeEvent) {
+	r.log.Record(Event{
+		Time:    c.DetectedAt,
+		Cluster: c.Cluster,
+		Kind:    MetaLeaderChanged,
+		Node:    c.NewLeader,
+		Message: fmt.Sprintf("the meta leader changes from %s to %s", c.OldLeader, c.NewLeader),
+	})
+}
+
+func (r *recorder) onAnomalyChanged(a anomaly.Anomaly) {
+	e := Event{Time: a.DetectedAt, Cluster: a.Cluster, Kind: AnomalyDetected, Table: a.Table}
+	if a.Resolved {
+		e.Kind = AnomalyResolved
+		e.Message = fmt.Sprintf("the %s of %s of table %s is resolved, which is %f now", a.Direction, a.Metric, a.Table, a.Value)
+	} else {
+		e.Message = fmt.Sprintf("%s of table %s %ss to %f, while the mean is %f and the stddev is %f", a.Metric, a.Table, a.Direction, a.Value, a.Mean, a.Stddev)
+	}
+	r.log.Record(e)
+}
+
+var (
+	defaultLock sync.RWMutex
+	defaultLog  *Log
+)
+
+// Default returns the Log run by Start, or nil if the event log is disabled.
+func Default() *Log {
+	defaultLock.RLock()
+	defer defaultLock.RUnlock()
+	return defaultLog
+}
+
+// Start records the events observed by the aggregator if "events.enabled" is true, until the
+// tomb dies.
+func Start(tom *tomb.Tomb) {
+	if !viper.GetBool("events.enabled") {
+		return
+	}
+	viper.SetDefault("events.capacity", 1000)
+	l, err := NewLog(LogConfig{
+		Capacity: viper.GetInt("events.capacity"),
+		Path:     viper.GetString("events.path"),
+	}, viper.GetString("cluster_name"))
+	if err != nil {
+		log.Errorf("failed to start the event log: %s", err)
+		return
+	}
+	newRecorder(l).register()
+	defaultLock.Lock()
+	defaultLog = l
+	defaultLock.Unlock()
+
+	<-tom.Dying()
+	if err := l.Close(); err != nil {
+		log.Errorf("failed to close the event log: %s", err)
+	}
+}
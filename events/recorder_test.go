@@ -0,0 +1,101 @@
+package events
+
+import (
+	"testing"
+	"time"
+
+	"github.com/XiaoMi/pegasus-go-client/idl/base"
+	"github.com/pegasus-kv/collector/aggregate"
+	"github.com/pegasus-kv/collector/anomaly"
+	"github.com/stretchr/testify/assert"
+)
+
+func tableStats(appID int, name string, partitions int) aggregate.TableStats {
+	tb := aggregate.TableStats{AppID: appID, TableName: name, Partitions: make(map[int]*aggregate.PartitionStats)}
+	for i := 0; i < partitions; i++ {
+		tb.Partitions[i] = &aggregate.PartitionStats{}
+	}
+	return tb
+}
+
+func TestRecorder(t *testing.T) {
+	l, err := NewLog(LogConfig{Capacity: 100}, "onebox")
+	assert.Nil(t, err)
+	r := newRecorder(l)
+
+	// the tables of the first round are not created
+	r.onTableStats([]aggregate.TableStats{tableStats(1, "stat", 4)}, aggregate.ClusterStats{})
+	assert.Equal(t, len(l.Query(Filter{})), 0)
+
+	r.onTableStats([]aggregate.TableStats{tableStats(1, "stat", 8), tableStats(2, "temp", 4)}, aggregate.ClusterStats{})
+	r.onConfigChanged([]*aggregate.ConfigChangeEvent{
+		{Gpid: base.Gpid{Appid: 2, PartitionIndex: 1}, Kind: aggregate.PrimaryMoved, OldPrimary: "127.0.0.1:34801", NewPrimary: "127.0.0.1:34802"},
+		{Gpid: base.Gpid{Appid: 2, PartitionIndex: 2}, Kind: aggregate.BallotChanged},
+	})
+	r.onNodeStateChanged([]*aggregate.NodeStateEvent{
+		{Addr: "127.0.0.1:34801", OldState: aggregate.NodeAlive, NewState: aggregate.NodeUnalive, Flapping: true},
+	})
+	r.onMetaLeaderChanged(&aggregate.MetaLeaderChangeEvent{OldLeader: "127.0.0.1:34601", NewLeader: "127.0.0.1:34602", DetectedAt: time.Now()})
+	r.onTableDropped("", 2)
+	r.onTableDropped("", 3)
+
+	var kinds []Kind
+	for _, e := range l.Query(Filter{}) {
+		kinds = append(kinds, e.Kind)
+	}
+	assert.Equal(t, kinds, []Kind{TableDropped, MetaLeaderChanged, NodeDown, PrimaryMigrated, TableCreated, PartitionCountChanged})
+
+	migrated := l.Query(Filter{Kinds: []Kind{PrimaryMigrated}})[0]
+	assert.Equal(t, migrated.Table, "temp")
+	assert.Equal(t, migrated.Node, "127.0.0.1:34802")
+	down := l.Query(Filter{Kinds: []Kind{NodeDown}})[0]
+	assert.Equal(t, down.Message, "replica node 127.0.0.1:34801 changes from alive to unalive, which is flapping")
+	assert.Equal(t, l.Query(Filter{Kinds: []Kind{PartitionCountChanged}})[0].Message,
+		"the partition count of table stat(1) changes from 4 to 8")
+}
+
+func TestRecorderOfClusters(t *testing.T) {
+	l, err := NewLog(LogConfig{Capacity: 100}, "onebox")
+	assert.Nil(t, err)
+	r := newRecorder(l)
+
+	// the tables of different clusters may have the same app ID
+	r.onTableStats([]aggregate.TableStats{tableStats(1, "stat", 4)}, aggregate.ClusterStats{Cluster: "c1"})
+	r.onTableStats([]aggregate.TableStats{tableStats(1, "temp", 8)}, aggregate.ClusterStats{Cluster: "c2"})
+	r.onTableStats([]aggregate.TableStats{tableStats(1, "stat", 4), tableStats(2, "test", 4)}, aggregate.ClusterStats{Cluster: "c1"})
+	r.onTableStats([]aggregate.TableStats{tableStats(1, "temp", 8)}, aggregate.ClusterStats{Cluster: "c2"})
+	r.onConfigChanged([]*aggregate.ConfigChangeEvent{
+		{Cluster: "c2", Gpid: base.Gpid{Appid: 1, PartitionIndex: 1}, Kind: aggregate.PrimaryMoved, OldPrimary: "127.0.0.1:34801", NewPrimary: "127.0.0.1:34802"},
+	})
+	r.onTableDropped("c2", 1)
+
+	res := l.Query(Filter{})
+	assert.Equal(t, len(res), 3)
+	assert.Equal(t, res[0].Kind, TableDropped)
+	assert.Equal(t, res[0].Cluster, "c2")
+	assert.Equal(t, res[0].Table, "temp")
+	assert.Equal(t, res[1].Kind, PrimaryMigrated)
+	assert.Equal(t, res[1].Table, "temp")
+	assert.Equal(t, res[2].Kind, TableCreated)
+	assert.Equal(t, res[2].Cluster, "c1")
+	assert.Equal(t, res[2].Table, "test")
+}
+
+func TestRecorderAnomalies(t *testing.T) {
+	l, err := NewLog(LogConfig{Capacity: 100}, "onebox")
+	assert.Nil(t, err)
+	r := newRecorder(l)
+
+	a := anomaly.Anomaly{Table: "stat", Metric: "read_qps", Direction: anomaly.Drop, Value: 10, Mean: 1000, Stddev: 50}
+	r.onAnomalyChanged(a)
+	a.Resolved = true
+	a.Value = 900
+	r.onAnomalyChanged(a)
+
+	res := l.Query(Filter{Table: "stat"})
+	assert.Equal(t, len(res), 2)
+	assert.Equal(t, res[0].Kind, AnomalyResolved)
+	assert.Equal(t, res[0].Message, "the drop of read_qps of table stat is resolved, which is 900.000000 now")
+	assert.Equal(t, res[1].Kind, AnomalyDetected)
+	assert.Equal(t, res[1].Message, "read_qps of table stat drops to 10.000000, while the mean is 1000.000000 and the stddev is 50.000000")
+}
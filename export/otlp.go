@@ -0,0 +1,281 @@
+package export
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pegasus-kv/collector/aggregate"
+	"github.com/pegasus-kv/collector/logging"
+	colmetricpb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricpb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+)
+
+var log = logging.Module("export")
+
+// OTLPConfig is the configuration of OTLPExporter.
+type OTLPConfig struct {
+	// The address of the OTLP/gRPC receiver, e.g. "127.0.0.1:4317".
+	Endpoint string
+
+	// The "pegasus.cluster" resource attribute, unless the stats are tagged with the cluster.
+	ClusterName string
+
+	// The extra resource attributes, e.g. {"deployment.environment": "production"}.
+	ResourceAttributes map[string]string
+
+	// The gRPC metadata sent with every request, e.g. for the authentication.
+	Headers map[string]string
+
+	// The connection is in plaintext if Insecure is true, otherwise TLS is used, verified
+	// by the CAFile or the system roots if CAFile is empty.
+	Insecure bool
+	CAFile   string
+
+	// The interval to export the latest stats. 10s is used if it's zero.
+	Interval time.Duration
+
+	// The timeout of each export. 10s is used if it's zero.
+	Timeout time.Duration
+
+	// OnError is called on every failed export if it's non-nil, e.g. to count the failures.
+	OnError func(err error)
+}
+
+// OTLPExporter exports the table and cluster stats as OpenTelemetry gauges to an OTLP/gRPC
+// receiver, e.g. the OpenTelemetry Collector. Only the latest stats are exported every interval.
+type OTLPExporter struct {
+	cfg    OTLPConfig
+	conn   *grpc.ClientConn
+	client colmetricpb.MetricsServiceClient
+
+	lock sync.Mutex
+	// cluster -> the latest stats that haven't been exported yet
+	pending map[string]*metricpb.ResourceMetrics
+
+	// stops the Run started by Start, which closes `done` once it returns
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// NewOTLPExporter returns an OTLPExporter. The connection is established lazily.
+func NewOTLPExporter(cfg OTLPConfig) (*OTLPExporter, error) {
+	if cfg.Interval == 0 {
+		cfg.Interval = 10 * time.Second
+	}
+	if cfg.Timeout == 0 {
+		cfg.Timeout = 10 * time.Second
+	}
+	var creds grpc.DialOption
+	if cfg.Insecure {
+		creds = grpc.WithTransportCredentials(insecure.NewCredentials())
+	} else {
+		tlsCfg := &tls.Config{}
+		if cfg.CAFile != "" {
+			pem, err := ioutil.ReadFile(cfg.CAFile)
+			if err != nil {
+				return nil, err
+			}
+			tlsCfg.RootCAs = x509.NewCertPool()
+			if !tlsCfg.RootCAs.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("no certificate is found in %s", cfg.CAFile)
+			}
+		}
+		creds = grpc.WithTransportCredentials(credentials.NewTLS(tlsCfg))
+	}
+	conn, err := grpc.Dial(cfg.Endpoint, creds)
+	if err != nil {
+		return nil, err
+	}
+	return &OTLPExporter{
+		cfg:     cfg,
+		conn:    conn,
+		client:  colmetricpb.NewMetricsServiceClient(conn),
+		pending: make(map[string]*metricpb.ResourceMetrics),
+	}, nil
+}
+
+// Start runs Run in the background until Close.
+func (e *OTLPExporter) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	e.cancel = cancel
+	e.done = make(chan struct{})
+	go func() {
+		defer close(e.done)
+		e.Run(ctx)
+	}()
+}
+
+// Run exports the latest stats every interval until `ctx` is done.
+func (e *OTLPExporter) Run(ctx context.Context) {
+	ticker := time.NewTicker(e.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if err := e.Flush(ctx); err != nil {
+			log.Errorf("failed to export stats via OTLP: %s", err)
+			e.onError(err)
+		}
+	}
+}
+
+func (e *OTLPExporter) onError(err error) {
+	if e.cfg.OnError != nil {
+		e.cfg.OnError(err)
+	}
+}
+
+// Report implements metrics.Sink. The stats are exported on the next interval.
+func (e *OTLPExporter) Report(stats []aggregate.TableStats, allStats aggregate.ClusterStats) {
+	cluster := allStats.Cluster
+	if cluster == "" {
+		cluster = e.cfg.ClusterName
+	}
+	rm := e.toResourceMetrics(cluster, stats, allStats)
+	e.lock.Lock()
+	e.pending[cluster] = rm
+	e.lock.Unlock()
+}
+
+// Flush exports the pending stats immediately, if any, with a resource of each cluster.
+func (e *OTLPExporter) Flush(ctx context.Context) error {
+	e.lock.Lock()
+	pending := e.pending
+	e.pending = make(map[string]*metricpb.ResourceMetrics)
+	e.lock.Unlock()
+	if len(pending) == 0 {
+		return nil
+	}
+	var clusters []string
+	for cluster := range pending {
+		clusters = append(clusters, cluster)
+	}
+	sort.Strings(clusters)
+	req := &colmetricpb.ExportMetricsServiceRequest{}
+	for _, cluster := range clusters {
+		req.ResourceMetrics = append(req.ResourceMetrics, pending[cluster])
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, e.cfg.Timeout)
+	defer cancel()
+	if len(e.cfg.Headers) != 0 {
+		ctx = metadata.NewOutgoingContext(ctx, metadata.New(e.cfg.Headers))
+	}
+	resp, err := e.client.Export(ctx, req)
+	if err != nil {
+		return err
+	}
+	if rejected := resp.GetPartialSuccess().GetRejectedDataPoints(); rejected > 0 {
+		log.Warnf("%d data points are rejected by the OTLP receiver: %s", rejected, resp.GetPartialSuccess().GetErrorMessage())
+	}
+	return nil
+}
+
+// Close stops the Run started by Start, exports the pending stats, and terminates the connection
+// to the receiver.
+func (e *OTLPExporter) Close() error {
+	if e.cancel != nil {
+		e.cancel()
+		<-e.done
+	}
+	if err := e.Flush(context.Background()); err != nil {
+		log.Errorf("failed to export the last stats via OTLP: %s", err)
+		e.onError(err)
+	}
+	return e.conn.Close()
+}
+
+func (e *OTLPExporter) toResourceMetrics(cluster string, stats []aggregate.TableStats, allStats aggregate.ClusterStats) *metricpb.ResourceMetrics {
+	// metric name -> the data points of all tables and the cluster
+	points := make(map[string][]*metricpb.NumberDataPoint)
+	for _, tb := range stats {
+		attrs := []*commonpb.KeyValue{
+			stringAttr("entity", "table"),
+			stringAttr("table", tb.TableName),
+			stringAttr("app_id", strconv.Itoa(tb.AppID)),
+		}
+		for name, value := range tb.Stats {
+			points[name] = append(points[name], newDataPoint(value, tb.Timestamp, attrs))
+		}
+	}
+	attrs := []*commonpb.KeyValue{stringAttr("entity", "cluster")}
+	for name, value := range allStats.Stats {
+		points[name] = append(points[name], newDataPoint(value, allStats.Timestamp, attrs))
+	}
+
+	var names []string
+	for name := range points {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var metrics []*metricpb.Metric
+	for _, name := range names {
+		metrics = append(metrics, &metricpb.Metric{
+			Name: "pegasus." + name,
+			Data: &metricpb.Metric_Gauge{Gauge: &metricpb.Gauge{DataPoints: points[name]}},
+		})
+	}
+
+	return &metricpb.ResourceMetrics{
+		Resource: &resourcepb.Resource{Attributes: e.resourceAttributes(cluster)},
+		ScopeMetrics: []*metricpb.ScopeMetrics{{
+			Scope:   &commonpb.InstrumentationScope{Name: "github.com/pegasus-kv/collector"},
+			Metrics: metrics,
+		}},
+	}
+}
+
+// resourceAttributes returns the attributes of the collector and the cluster, sorted by the keys.
+func (e *OTLPExporter) resourceAttributes(cluster string) []*commonpb.KeyValue {
+	attrs := map[string]string{
+		"service.name":    "pegasus-collector",
+		"pegasus.cluster": cluster,
+	}
+	for k, v := range e.cfg.ResourceAttributes {
+		attrs[k] = v
+	}
+	var keys []string
+	for k := range attrs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var res []*commonpb.KeyValue
+	for _, k := range keys {
+		res = append(res, stringAttr(k, attrs[k]))
+	}
+	return res
+}
+
+func newDataPoint(value float64, ts time.Time, attrs []*commonpb.KeyValue) *metricpb.NumberDataPoint {
+	if ts.IsZero() {
+		ts = time.Now()
+	}
+	return &metricpb.NumberDataPoint{
+		Attributes:   attrs,
+		TimeUnixNano: uint64(ts.UnixNano()),
+		Value:        &metricpb.NumberDataPoint_AsDouble{AsDouble: value},
+	}
+}
+
+func stringAttr(key, value string) *commonpb.KeyValue {
+	return &commonpb.KeyValue{
+		Key:   key,
+		Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: value}},
+	}
+}
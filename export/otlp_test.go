@@ -0,0 +1,117 @@
+package export
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/pegasus-kv/collector/aggregate"
+	"github.com/stretchr/testify/assert"
+	colmetricpb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	"go.uber.org/goleak"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+type fakeMetricsService struct {
+	colmetricpb.UnimplementedMetricsServiceServer
+
+	requests chan *colmetricpb.ExportMetricsServiceRequest
+	tokens   chan []string
+}
+
+func (s *fakeMetricsService) Export(ctx context.Context, req *colmetricpb.ExportMetricsServiceRequest) (*colmetricpb.ExportMetricsServiceResponse, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	s.tokens <- md.Get("x-token")
+	s.requests <- req
+	return &colmetricpb.ExportMetricsServiceResponse{}, nil
+}
+
+func TestOTLPExporterFlush(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	service := &fakeMetricsService{
+		requests: make(chan *colmetricpb.ExportMetricsServiceRequest, 1),
+		tokens:   make(chan []string, 1),
+	}
+	server := grpc.NewServer()
+	colmetricpb.RegisterMetricsServiceServer(server, service)
+	go server.Serve(lis)
+	defer server.Stop()
+
+	exporter, err := NewOTLPExporter(OTLPConfig{
+		Endpoint:           lis.Addr().String(),
+		ClusterName:        "onebox",
+		ResourceAttributes: map[string]string{"region": "bj"},
+		Headers:            map[string]string{"x-token": "secret"},
+		Insecure:           true,
+	})
+	assert.Nil(t, err)
+
+	// nothing to export
+	assert.Nil(t, exporter.Flush(context.Background()))
+
+	ts := time.Unix(1600000000, 0)
+	exporter.Report([]aggregate.TableStats{
+		{TableName: "stat", AppID: 1, Timestamp: ts, Stats: map[string]float64{"get_qps": 1, "put_qps": 2}},
+	}, aggregate.ClusterStats{Timestamp: ts, Stats: map[string]float64{"get_qps": 1}})
+	assert.Nil(t, exporter.Flush(context.Background()))
+
+	assert.Equal(t, <-service.tokens, []string{"secret"})
+	req := <-service.requests
+	assert.Equal(t, len(req.ResourceMetrics), 1)
+	var resource []string
+	for _, attr := range req.ResourceMetrics[0].Resource.Attributes {
+		resource = append(resource, attr.Key+"="+attr.Value.GetStringValue())
+	}
+	assert.Equal(t, resource, []string{"pegasus.cluster=onebox", "region=bj", "service.name=pegasus-collector"})
+
+	metrics := req.ResourceMetrics[0].ScopeMetrics[0].Metrics
+	assert.Equal(t, len(metrics), 2)
+	assert.Equal(t, metrics[0].Name, "pegasus.get_qps")
+	points := metrics[0].GetGauge().DataPoints
+	assert.Equal(t, len(points), 2)
+	assert.Equal(t, points[0].GetAsDouble(), float64(1))
+	assert.Equal(t, points[0].TimeUnixNano, uint64(ts.UnixNano()))
+	assert.Equal(t, points[0].Attributes[1].Value.GetStringValue(), "stat")
+	assert.Equal(t, points[1].Attributes[0].Value.GetStringValue(), "cluster")
+	assert.Equal(t, metrics[1].Name, "pegasus.put_qps")
+
+	// the exported stats are not exported again
+	assert.Nil(t, exporter.Flush(context.Background()))
+	assert.Equal(t, len(service.requests), 0)
+
+	// the pending stats are exported on close
+	exporter.Report(nil, aggregate.ClusterStats{Timestamp: ts, Stats: map[string]float64{"get_qps": 2}})
+	assert.Nil(t, exporter.Close())
+	<-service.tokens
+	req = <-service.requests
+	assert.Equal(t, req.ResourceMetrics[0].ScopeMetrics[0].Metrics[0].GetGauge().DataPoints[0].GetAsDouble(), float64(2))
+}
+
+func TestOTLPExporterClose(t *testing.T) {
+	defer goleak.VerifyNone(t, goleak.IgnoreCurrent())
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	service := &fakeMetricsService{
+		requests: make(chan *colmetricpb.ExportMetricsServiceRequest, 1),
+		tokens:   make(chan []string, 1),
+	}
+	server := grpc.NewServer()
+	colmetricpb.RegisterMetricsServiceServer(server, service)
+	go server.Serve(lis)
+	defer server.Stop()
+
+	exporter, err := NewOTLPExporter(OTLPConfig{Endpoint: lis.Addr().String(), Insecure: true, Interval: time.Hour})
+	assert.Nil(t, err)
+	exporter.Start()
+
+	// the exporting goroutine is stopped before the pending stats are exported
+	exporter.Report(nil, aggregate.ClusterStats{Cluster: "onebox", Stats: map[string]float64{"get_qps": 1}})
+	assert.Nil(t, exporter.Close())
+	<-service.tokens
+	req := <-service.requests
+	assert.Equal(t, len(req.ResourceMetrics), 1)
+}
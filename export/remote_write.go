@@ -0,0 +1,179 @@
+package export
+
+//go:generate protoc -I.. --go_out=.. --go_opt=module=github.com/pegasus-kv/collector ../proto/remote.proto
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/pegasus-kv/collector/aggregate"
+	"github.com/pegasus-kv/collector/export/prompb"
+	"google.golang.org/protobuf/proto"
+)
+
+// RemoteWriteConfig is the configuration of RemoteWriteExporter.
+type RemoteWriteConfig struct {
+	// The remote-write endpoint, e.g. "http://127.0.0.1:9090/api/v1/write".
+	URL string
+
+	// The cluster label attached to every series, unless the stats are tagged with the cluster.
+	ClusterName string
+
+	// Basic auth is enabled if Username is not empty.
+	Username string
+	Password string
+
+	// The PEM files for TLS. The system roots are used if CAFile is empty,
+	// and the client certificate is sent if both CertFile and KeyFile are set.
+	CAFile             string
+	CertFile           string
+	KeyFile            string
+	InsecureSkipVerify bool
+
+	// The timeout of each request. 10s is used if it's zero.
+	Timeout time.Duration
+
+	// OnError is called on every failed export if it's non-nil, e.g. to count the failures.
+	OnError func(err error)
+}
+
+// RemoteWriteExporter pushes the table stats to a Prometheus remote-write endpoint,
+// which is compatible with Thanos, Cortex, Mimir, VictoriaMetrics, etc.
+// It's useful when the collector can't be scraped by Prometheus.
+type RemoteWriteExporter struct {
+	cfg    RemoteWriteConfig
+	client *http.Client
+}
+
+// NewRemoteWriteExporter returns a RemoteWriteExporter. An error is returned if the TLS files
+// are unable to be loaded.
+func NewRemoteWriteExporter(cfg RemoteWriteConfig) (*RemoteWriteExporter, error) {
+	if cfg.Timeout == 0 {
+		cfg.Timeout = 10 * time.Second
+	}
+	tlsCfg := &tls.Config{InsecureSkipVerify: cfg.InsecureSkipVerify}
+	if cfg.CAFile != "" {
+		pem, err := ioutil.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsCfg.RootCAs = x509.NewCertPool()
+		if !tlsCfg.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate is found in %s", cfg.CAFile)
+		}
+	}
+	if cfg.CertFile != "" && cfg.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsCfg.Certificates = []tls.Certificate{cert}
+	}
+	return &RemoteWriteExporter{
+		cfg: cfg,
+		client: &http.Client{
+			Transport: &http.Transport{TLSClientConfig: tlsCfg},
+			Timeout:   cfg.Timeout,
+		},
+	}, nil
+}
+
+// Export sends the metrics of all tables in a single remote-write request.
+func (e *RemoteWriteExporter) Export(ctx context.Context, tables []*aggregate.TableStats) error {
+	return e.exportCluster(ctx, e.cfg.ClusterName, tables)
+}
+
+// exportCluster sends the metrics of the tables labelled by the cluster, if it's not empty.
+func (e *RemoteWriteExporter) exportCluster(ctx context.Context, cluster string, tables []*aggregate.TableStats) error {
+	data, err := proto.Marshal(toWriteRequest(cluster, tables))
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, e.cfg.URL, bytes.NewReader(snappy.Encode(nil, data)))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	if e.cfg.Username != "" {
+		req.SetBasicAuth(e.cfg.Username, e.cfg.Password)
+	}
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("remote write to %s failed [%s]: %s", e.cfg.URL, resp.Status, bytes.TrimSpace(body))
+	}
+	return nil
+}
+
+// Report implements metrics.Sink. The cluster stats are not pushed, since they can be
+// summed up from the table series by the query.
+func (e *RemoteWriteExporter) Report(stats []aggregate.TableStats, allStats aggregate.ClusterStats) {
+	tables := make([]*aggregate.TableStats, 0, len(stats))
+	for i := range stats {
+		tables = append(tables, &stats[i])
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), e.cfg.Timeout)
+	defer cancel()
+	cluster := allStats.Cluster
+	if cluster == "" {
+		cluster = e.cfg.ClusterName
+	}
+	if err := e.exportCluster(ctx, cluster, tables); err != nil {
+		log.Errorf("failed to export stats via remote write: %s", err)
+		if e.cfg.OnError != nil {
+			e.cfg.OnError(err)
+		}
+	}
+}
+
+func toWriteRequest(cluster string, tables []*aggregate.TableStats) *prompb.WriteRequest {
+	req := &prompb.WriteRequest{}
+	for _, tb := range tables {
+		ts := tb.Timestamp.UnixNano() / 1e6
+		var names []string
+		for name := range tb.Stats {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			// the labels are sorted by name
+			labels := []*prompb.Label{{Name: "__name__", Value: sanitizeMetricName(name)}}
+			if cluster != "" {
+				labels = append(labels, &prompb.Label{Name: "cluster", Value: cluster})
+			}
+			labels = append(labels,
+				&prompb.Label{Name: "entity", Value: "table"},
+				&prompb.Label{Name: "table", Value: tb.TableName})
+			req.Timeseries = append(req.Timeseries, &prompb.TimeSeries{
+				Labels:  labels,
+				Samples: []*prompb.Sample{{Value: tb.Stats[name], Timestamp: ts}},
+			})
+		}
+	}
+	return req
+}
+
+var invalidMetricNameChars = regexp.MustCompile("[^a-zA-Z0-9_:]")
+
+// sanitizeMetricName replaces the characters not allowed in a Prometheus metric name with '_'.
+func sanitizeMetricName(name string) string {
+	return invalidMetricNameChars.ReplaceAllString(name, "_")
+}
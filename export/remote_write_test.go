@@ -0,0 +1,78 @@
+package export
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/pegasus-kv/collector/aggregate"
+	"github.com/pegasus-kv/collector/export/prompb"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestRemoteWriteExporterExport(t *testing.T) {
+	received := make(chan *prompb.WriteRequest, 1)
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, password, ok := r.BasicAuth()
+		if !ok || user != "admin" || password != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		assert.Equal(t, r.Header.Get("Content-Encoding"), "snappy")
+		body, _ := ioutil.ReadAll(r.Body)
+		data, err := snappy.Decode(nil, body)
+		assert.Nil(t, err)
+		req := &prompb.WriteRequest{}
+		assert.Nil(t, proto.Unmarshal(data, req))
+		received <- req
+	}))
+	defer server.Close()
+
+	cfg := RemoteWriteConfig{
+		URL:                server.URL,
+		ClusterName:        "onebox",
+		Username:           "admin",
+		Password:           "secret",
+		InsecureSkipVerify: true,
+	}
+	e, err := NewRemoteWriteExporter(cfg)
+	assert.Nil(t, err)
+
+	ts := time.Unix(1, 0)
+	err = e.Export(context.Background(), []*aggregate.TableStats{
+		{TableName: "stat", Timestamp: ts, Stats: map[string]float64{"get_qps": 1, "put_qps": 2}},
+		{TableName: "temp", Timestamp: ts, Stats: map[string]float64{"get_qps": 3}},
+	})
+	assert.Nil(t, err)
+
+	req := <-received
+	assert.Equal(t, len(req.Timeseries), 3)
+	series := req.Timeseries[1]
+	var labels []string
+	for _, l := range series.Labels {
+		labels = append(labels, l.Name+"="+l.Value)
+	}
+	assert.Equal(t, labels, []string{"__name__=put_qps", "cluster=onebox", "entity=table", "table=stat"})
+	assert.Equal(t, series.Samples[0].Value, float64(2))
+	assert.Equal(t, series.Samples[0].Timestamp, int64(1000))
+
+	// rejected without the credentials
+	cfg.Password = ""
+	e, err = NewRemoteWriteExporter(cfg)
+	assert.Nil(t, err)
+	assert.NotNil(t, e.Export(context.Background(), nil))
+}
+
+func TestNewRemoteWriteExporterInvalidCA(t *testing.T) {
+	_, err := NewRemoteWriteExporter(RemoteWriteConfig{CAFile: "/nonexistent/ca.pem"})
+	assert.NotNil(t, err)
+}
+
+func TestSanitizeMetricName(t *testing.T) {
+	assert.Equal(t, sanitizeMetricName("disk.capacity.total(MB)"), "disk_capacity_total_MB_")
+}
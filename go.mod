@@ -5,18 +5,25 @@ go 1.13
 require (
 	github.com/XiaoMi/pegasus-go-client v0.0.0-20201119112224-45f30cd560c7
 	github.com/ajg/form v1.5.1 // indirect
+	github.com/apache/thrift v0.13.0
 	github.com/fasthttp-contrib/websocket v0.0.0-20160511215533-1f3b11f56072 // indirect
+	github.com/go-zookeeper/zk v1.0.3
+	github.com/golang/snappy v0.0.4
 	github.com/google/go-querystring v1.0.0 // indirect
+	github.com/gorilla/websocket v1.4.2
+	github.com/grpc-ecosystem/grpc-gateway/v2 v2.15.2 // indirect
 	github.com/imkira/go-interpol v1.1.0 // indirect
+	github.com/jcmturner/gokrb5/v8 v8.4.2
 	github.com/k0kubun/colorstring v0.0.0-20150214042306-9440f1994b88 // indirect
 	github.com/kataras/iris/v12 v12.1.8
 	github.com/mattn/go-isatty v0.0.12 // indirect
 	github.com/moul/http2curl v1.0.0 // indirect
 	github.com/prometheus/client_golang v1.8.0
+	github.com/segmentio/kafka-go v0.3.5
 	github.com/sergi/go-diff v1.1.0 // indirect
 	github.com/sirupsen/logrus v1.7.0
 	github.com/spf13/viper v1.7.1
-	github.com/stretchr/testify v1.6.1
+	github.com/stretchr/testify v1.8.1
 	github.com/tidwall/gjson v1.6.3
 	github.com/valyala/fasthttp v1.16.0 // indirect
 	github.com/xeipuuv/gojsonschema v1.2.0 // indirect
@@ -24,6 +31,11 @@ require (
 	github.com/yudai/gojsondiff v1.0.0 // indirect
 	github.com/yudai/golcs v0.0.0-20170316035057-ecda9a501e82 // indirect
 	github.com/yudai/pp v2.0.1+incompatible // indirect
+	go.opentelemetry.io/proto/otlp v0.19.0
+	go.uber.org/goleak v1.1.11
+	google.golang.org/grpc v1.54.0
+	google.golang.org/protobuf v1.33.0
 	gopkg.in/natefinch/lumberjack.v2 v2.0.0
 	gopkg.in/tomb.v2 v2.0.0-20161208151619-d5d1b5820637
+	gopkg.in/yaml.v3 v3.0.1
 )
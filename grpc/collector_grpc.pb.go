@@ -0,0 +1,138 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.3.0
+// - protoc             (unknown)
+// source: proto/collector.proto
+
+package grpc
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+const (
+	CollectorService_StreamTableStats_FullMethodName = "/collector.CollectorService/StreamTableStats"
+)
+
+// CollectorServiceClient is the client API for CollectorService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type CollectorServiceClient interface {
+	// StreamTableStats streams the TableStats of every round of aggregation.
+	StreamTableStats(ctx context.Context, in *StreamRequest, opts ...grpc.CallOption) (CollectorService_StreamTableStatsClient, error)
+}
+
+type collectorServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewCollectorServiceClient(cc grpc.ClientConnInterface) CollectorServiceClient {
+	return &collectorServiceClient{cc}
+}
+
+func (c *collectorServiceClient) StreamTableStats(ctx context.Context, in *StreamRequest, opts ...grpc.CallOption) (CollectorService_StreamTableStatsClient, error) {
+	stream, err := c.cc.NewStream(ctx, &CollectorService_ServiceDesc.Streams[0], CollectorService_StreamTableStats_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &collectorServiceStreamTableStatsClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type CollectorService_StreamTableStatsClient interface {
+	Recv() (*TableStatsResponse, error)
+	grpc.ClientStream
+}
+
+type collectorServiceStreamTableStatsClient struct {
+	grpc.ClientStream
+}
+
+func (x *collectorServiceStreamTableStatsClient) Recv() (*TableStatsResponse, error) {
+	m := new(TableStatsResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// CollectorServiceServer is the server API for CollectorService service.
+// All implementations must embed UnimplementedCollectorServiceServer
+// for forward compatibility
+type CollectorServiceServer interface {
+	// StreamTableStats streams the TableStats of every round of aggregation.
+	StreamTableStats(*StreamRequest, CollectorService_StreamTableStatsServer) error
+	mustEmbedUnimplementedCollectorServiceServer()
+}
+
+// UnimplementedCollectorServiceServer must be embedded to have forward compatible implementations.
+type UnimplementedCollectorServiceServer struct {
+}
+
+func (UnimplementedCollectorServiceServer) StreamTableStats(*StreamRequest, CollectorService_StreamTableStatsServer) error {
+	return status.Errorf(codes.Unimplemented, "method StreamTableStats not implemented")
+}
+func (UnimplementedCollectorServiceServer) mustEmbedUnimplementedCollectorServiceServer() {}
+
+// UnsafeCollectorServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to CollectorServiceServer will
+// result in compilation errors.
+type UnsafeCollectorServiceServer interface {
+	mustEmbedUnimplementedCollectorServiceServer()
+}
+
+func RegisterCollectorServiceServer(s grpc.ServiceRegistrar, srv CollectorServiceServer) {
+	s.RegisterService(&CollectorService_ServiceDesc, srv)
+}
+
+func _CollectorService_StreamTableStats_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(StreamRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(CollectorServiceServer).StreamTableStats(m, &collectorServiceStreamTableStatsServer{stream})
+}
+
+type CollectorService_StreamTableStatsServer interface {
+	Send(*TableStatsResponse) error
+	grpc.ServerStream
+}
+
+type collectorServiceStreamTableStatsServer struct {
+	grpc.ServerStream
+}
+
+func (x *collectorServiceStreamTableStatsServer) Send(m *TableStatsResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+// CollectorService_ServiceDesc is the grpc.ServiceDesc for CollectorService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var CollectorService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "collector.CollectorService",
+	HandlerType: (*CollectorServiceServer)(nil),
+	Methods:     []grpc.MethodDesc{},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "StreamTableStats",
+			Handler:       _CollectorService_StreamTableStats_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "proto/collector.proto",
+}
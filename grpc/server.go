@@ -0,0 +1,150 @@
+package grpc
+
+import (
+	"fmt"
+	"net"
+	"sync"
+
+	"github.com/pegasus-kv/collector/aggregate"
+	"github.com/pegasus-kv/collector/logging"
+	"github.com/spf13/viper"
+	grpclib "google.golang.org/grpc"
+	"gopkg.in/tomb.v2"
+)
+
+var log = logging.Module("grpc")
+
+//go:generate protoc -I.. --go_out=.. --go_opt=module=github.com/pegasus-kv/collector --go-grpc_out=.. --go-grpc_opt=module=github.com/pegasus-kv/collector ../proto/collector.proto
+
+// subscriberCapacity is the number of batches buffered for a slow client. Batches are
+// dropped if the buffer is full.
+const subscriberCapacity = 4
+
+// Server implements the CollectorService. It streams the TableStats generated by
+// the background aggregation to the clients.
+type Server struct {
+	UnimplementedCollectorServiceServer
+
+	lock        sync.Mutex
+	subscribers map[*subscriber]struct{}
+}
+
+type subscriber struct {
+	// the watched tables, empty if all tables are watched
+	tables map[string]bool
+	// the watched clusters, empty if all clusters are watched
+	clusters map[string]bool
+
+	batches chan batch
+}
+
+// batch is the TableStats of a round of a cluster.
+type batch struct {
+	cluster string
+	stats   []aggregate.TableStats
+}
+
+// NewServer returns a Server watching the stats of every cluster emitted by the aggregator.
+func NewServer() *Server {
+	s := &Server{subscribers: make(map[*subscriber]struct{})}
+	aggregate.AddHookAfterClusterStatsEmitted(func(stats []aggregate.TableStats, allStats aggregate.ClusterStats) {
+		s.broadcast(allStats.Cluster, stats)
+	})
+	return s
+}
+
+func (s *Server) broadcast(cluster string, stats []aggregate.TableStats) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for sub := range s.subscribers {
+		if len(sub.clusters) != 0 && !sub.clusters[cluster] {
+			continue
+		}
+		select {
+		case sub.batches <- batch{cluster: cluster, stats: stats}:
+		default:
+			log.Warn("gRPC client is too slow to receive the stats, drop a batch")
+		}
+	}
+}
+
+func (s *Server) subscribe(req *StreamRequest) *subscriber {
+	sub := &subscriber{
+		tables:   make(map[string]bool),
+		clusters: make(map[string]bool),
+		batches:  make(chan batch, subscriberCapacity),
+	}
+	for _, name := range req.TableNames {
+		sub.tables[name] = true
+	}
+	for _, name := range req.Clusters {
+		sub.clusters[name] = true
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.subscribers[sub] = struct{}{}
+	return sub
+}
+
+func (s *Server) unsubscribe(sub *subscriber) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.subscribers, sub)
+}
+
+// StreamTableStats sends the TableStats of every round of aggregation until the client quits.
+func (s *Server) StreamTableStats(req *StreamRequest, stream CollectorService_StreamTableStatsServer) error {
+	sub := s.subscribe(req)
+	defer s.unsubscribe(sub)
+
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case b := <-sub.batches:
+			for _, tb := range b.stats {
+				if len(sub.tables) != 0 && !sub.tables[tb.TableName] {
+					continue
+				}
+				resp := &TableStatsResponse{
+					TableName: tb.TableName,
+					AppId:     int32(tb.AppID),
+					Timestamp: tb.Timestamp.UnixNano() / 1e6,
+					Stats:     tb.Stats,
+					Cluster:   b.cluster,
+				}
+				if err := stream.Send(resp); err != nil {
+					return err
+				}
+			}
+		}
+	}
+}
+
+// Start serves the CollectorService on the configured "grpc.port" until the tomb dies.
+// The gRPC server is disabled if the port is not configured.
+func Start(tom *tomb.Tomb) {
+	port := viper.GetInt("grpc.port")
+	if port == 0 {
+		return
+	}
+	lis, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	if err != nil {
+		log.Errorf("failed to start gRPC server: %s", err)
+		return
+	}
+
+	srv := grpclib.NewServer()
+	RegisterCollectorServiceServer(srv, NewServer())
+	go func() {
+		<-tom.Dying()
+		srv.GracefulStop()
+	}()
+
+	log.Infof("start gRPC server on port %d", port)
+	if err := srv.Serve(lis); err != nil {
+		log.Errorf("gRPC server terminates: %s", err)
+	}
+}
@@ -0,0 +1,93 @@
+package grpc
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/pegasus-kv/collector/aggregate"
+	"github.com/stretchr/testify/assert"
+	grpclib "google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// startTestServer serves a Server on a random port, and returns the connection to it.
+func startTestServer(t *testing.T) (*Server, *grpclib.ClientConn, func()) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+
+	s := NewServer()
+	srv := grpclib.NewServer()
+	RegisterCollectorServiceServer(srv, s)
+	go func() {
+		_ = srv.Serve(lis)
+	}()
+
+	conn, err := grpclib.Dial(lis.Addr().String(), grpclib.WithTransportCredentials(insecure.NewCredentials()))
+	assert.Nil(t, err)
+	return s, conn, func() {
+		conn.Close()
+		srv.Stop()
+	}
+}
+
+func TestServerStreamTableStats(t *testing.T) {
+	s, conn, stop := startTestServer(t)
+	defer stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	stream, err := NewCollectorServiceClient(conn).StreamTableStats(ctx, &StreamRequest{TableNames: []string{"temp"}})
+	assert.Nil(t, err)
+
+	waitSubscribed(s)
+
+	now := time.Now()
+	s.broadcast("onebox", []aggregate.TableStats{
+		{TableName: "stat", AppID: 1, Timestamp: now, Stats: map[string]float64{"get_qps": 1}},
+		{TableName: "temp", AppID: 2, Timestamp: now, Stats: map[string]float64{"get_qps": 2, "put_qps": 3}},
+	})
+
+	resp, err := stream.Recv()
+	assert.Nil(t, err)
+	assert.Equal(t, resp.TableName, "temp")
+	assert.Equal(t, resp.AppId, int32(2))
+	assert.Equal(t, resp.Timestamp, now.UnixNano()/1e6)
+	assert.Equal(t, resp.Stats, map[string]float64{"get_qps": 2, "put_qps": 3})
+	assert.Equal(t, resp.Cluster, "onebox")
+}
+
+// waitSubscribed waits until the stream is subscribed.
+func waitSubscribed(s *Server) {
+	for {
+		s.lock.Lock()
+		n := len(s.subscribers)
+		s.lock.Unlock()
+		if n == 1 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestServerStreamClusters(t *testing.T) {
+	s, conn, stop := startTestServer(t)
+	defer stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	stream, err := NewCollectorServiceClient(conn).StreamTableStats(ctx, &StreamRequest{Clusters: []string{"c2"}})
+	assert.Nil(t, err)
+	waitSubscribed(s)
+
+	// the tables of different clusters may have the same app ID
+	now := time.Now()
+	s.broadcast("c1", []aggregate.TableStats{{TableName: "stat", AppID: 1, Timestamp: now}})
+	s.broadcast("c2", []aggregate.TableStats{{TableName: "temp", AppID: 1, Timestamp: now}})
+
+	resp, err := stream.Recv()
+	assert.Nil(t, err)
+	assert.Equal(t, resp.Cluster, "c2")
+	assert.Equal(t, resp.TableName, "temp")
+}
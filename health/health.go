@@ -0,0 +1,209 @@
+// Package health tells whether the collector works, to the liveness and readiness probes of
+// Kubernetes and the load balancers, by the outcomes of the rounds of aggregation and the
+// reports in flight of the sinks.
+package health
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pegasus-kv/collector/aggregate"
+	"github.com/pegasus-kv/collector/metrics"
+	"github.com/pegasus-kv/collector/reload"
+	"github.com/spf13/viper"
+)
+
+// Check is the result of checking an aspect of the collector.
+type Check struct {
+	Name string `json:"name"`
+	OK   bool   `json:"ok"`
+	// why the check fails, or the details of the success
+	Msg string `json:"message,omitempty"`
+}
+
+// Report is the result of a probe, which passes only if all checks pass.
+type Report struct {
+	OK     bool    `json:"ok"`
+	Checks []Check `json:"checks"`
+}
+
+func newReport(checks []Check) Report {
+	r := Report{OK: true, Checks: checks}
+	for _, c := range checks {
+		r.OK = r.OK && c.OK
+	}
+	return r
+}
+
+// clusterState is the outcomes of the rounds of aggregation of a cluster.
+type clusterState struct {
+	last aggregate.AggregationResult
+	// the time of the last round whose stats are fully collected
+	lastSucceeded time.Time
+}
+
+// Checker tracks the outcomes of the rounds of aggregation of every cluster.
+type Checker struct {
+	lock     sync.RWMutex
+	started  time.Time
+	clusters map[string]*clusterState
+	// a cluster is stale if it has no round within the period
+	staleAfter time.Duration
+
+	// stalledSinks is metrics.StalledSinks, which is replaced in the tests
+	stalledSinks func(age time.Duration) []string
+	now          func() time.Time
+}
+
+// NewChecker returns a Checker, where the rounds of aggregation and the reports of the sinks
+// are expected within `staleAfter`.
+func NewChecker(staleAfter time.Duration) *Checker {
+	return &Checker{
+		started:      time.Now(),
+		clusters:     make(map[string]*clusterState),
+		staleAfter:   staleAfter,
+		stalledSinks: metrics.StalledSinks,
+		now:          time.Now,
+	}
+}
+
+// SetStaleAfter updates the period within which the rounds of aggregation and the reports of
+// the sinks are expected.
+func (c *Checker) SetStaleAfter(staleAfter time.Duration) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.staleAfter = staleAfter
+}
+
+// Observe records the outcome of a round of aggregation.
+func (c *Checker) Observe(r aggregate.AggregationResult) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	s, found := c.clusters[r.Cluster]
+	if !found {
+		s = &clusterState{}
+		c.clusters[r.Cluster] = s
+	}
+	s.last = r
+	if r.MetaErr == nil && r.ScrapeErr == nil {
+		s.lastSucceeded = r.Time
+	}
+}
+
+// Remove forgets the cluster which is no longer collected.
+func (c *Checker) Remove(cluster string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	delete(c.clusters, cluster)
+}
+
+// sortedClusters returns the names of the clusters, sorted.
+func (c *Checker) sortedClusters() []string {
+	names := make([]string, 0, len(c.clusters))
+	for name := range c.clusters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Liveness checks the rounds of aggregation of every cluster are going on, whether successful
+// or not, which fails only if the collector is stuck and needs a restart.
+func (c *Checker) Liveness() Report {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	now := c.now()
+	if len(c.clusters) == 0 {
+		check := Check{Name: "aggregation", OK: true, Msg: "no round of aggregation yet"}
+		if now.Sub(c.started) > c.staleAfter {
+			check.OK = false
+			check.Msg = fmt.Sprintf("no round of aggregation in %s since started", c.staleAfter)
+		}
+		return newReport([]Check{check})
+	}
+	var checks []Check
+	for _, name := range c.sortedClusters() {
+		last := c.clusters[name].last
+		check := Check{Name: "cluster/" + name + "/aggregation", OK: true}
+		if age := now.Sub(last.Time); age > c.staleAfter {
+			check.OK = false
+			check.Msg = fmt.Sprintf("the last round of aggregation is %s ago, beyond %s", age.Round(time.Second), c.staleAfter)
+		}
+		checks = append(checks, check)
+	}
+	return newReport(checks)
+}
+
+// Readiness checks the meta servers of every cluster are reachable and its last round of
+// aggregation succeeded recently, and no sink is stalled, i.e. the metrics are up to date.
+func (c *Checker) Readiness() Report {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	now := c.now()
+	var checks []Check
+	if len(c.clusters) == 0 {
+		checks = append(checks, Check{Name: "aggregation", OK: false, Msg: "no round of aggregation yet"})
+	}
+	for _, name := range c.sortedClusters() {
+		s := c.clusters[name]
+		meta := Check{Name: "cluster/" + name + "/meta", OK: s.last.MetaErr == nil}
+		if s.last.MetaErr != nil {
+			meta.Msg = s.last.MetaErr.Error()
+		}
+		scrape := Check{Name: "cluster/" + name + "/scrape", OK: true}
+		switch {
+		case s.last.ScrapeErr != nil:
+			scrape.OK = false
+			scrape.Msg = s.last.ScrapeErr.Error()
+		case s.lastSucceeded.IsZero():
+			scrape.OK = false
+			scrape.Msg = "no round of aggregation succeeded yet"
+		case now.Sub(s.lastSucceeded) > c.staleAfter:
+			scrape.OK = false
+			scrape.Msg = fmt.Sprintf("the last successful round is %s ago, beyond %s", now.Sub(s.lastSucceeded).Round(time.Second), c.staleAfter)
+		}
+		checks = append(checks, meta, scrape)
+	}
+	sinks := Check{Name: "sinks", OK: true}
+	if stalled := c.stalledSinks(c.staleAfter); len(stalled) != 0 {
+		sinks.OK = false
+		sinks.Msg = fmt.Sprintf("the reports of %v are in flight for longer than %s", stalled, c.staleAfter)
+	}
+	checks = append(checks, sinks)
+	return newReport(checks)
+}
+
+// staleAfterFromConfig returns "health.stale_rounds" times "metrics.report_interval".
+func staleAfterFromConfig() time.Duration {
+	viper.SetDefault("health.stale_rounds", 3)
+	return time.Duration(viper.GetInt("health.stale_rounds")) * viper.GetDuration("metrics.report_interval")
+}
+
+var (
+	defaultLock    sync.RWMutex
+	defaultChecker *Checker
+)
+
+// Default returns the Checker run by Start, or nil if it's not started.
+func Default() *Checker {
+	defaultLock.RLock()
+	defer defaultLock.RUnlock()
+	return defaultChecker
+}
+
+// Start tracks the rounds of aggregation of every cluster, which must be called before the
+// aggregation starts.
+func Start() {
+	c := NewChecker(staleAfterFromConfig())
+	aggregate.AddHookAfterClusterAggregated(c.Observe)
+	aggregate.AddHookAfterClusterRemoved(c.Remove)
+	reload.AddHookAfterChanged([]string{"health", "metrics.report_interval"}, func() error {
+		c.SetStaleAfter(staleAfterFromConfig())
+		return nil
+	})
+	defaultLock.Lock()
+	defaultChecker = c
+	defaultLock.Unlock()
+}
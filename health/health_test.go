@@ -0,0 +1,86 @@
+package health
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/pegasus-kv/collector/aggregate"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestChecker(now *time.Time, stalled *[]string) *Checker {
+	c := NewChecker(30 * time.Second)
+	c.started = *now
+	c.now = func() time.Time { return *now }
+	c.stalledSinks = func(age time.Duration) []string { return *stalled }
+	return c
+}
+
+func failedChecks(r Report) []string {
+	var names []string
+	for _, c := range r.Checks {
+		if !c.OK {
+			names = append(names, c.Name)
+		}
+	}
+	return names
+}
+
+func TestCheckerStartup(t *testing.T) {
+	now := time.Now()
+	var stalled []string
+	c := newTestChecker(&now, &stalled)
+
+	// alive but unready before the first round
+	assert.True(t, c.Liveness().OK)
+	assert.Equal(t, failedChecks(c.Readiness()), []string{"aggregation"})
+
+	now = now.Add(time.Minute)
+	assert.Equal(t, failedChecks(c.Liveness()), []string{"aggregation"})
+}
+
+func TestCheckerRounds(t *testing.T) {
+	now := time.Now()
+	var stalled []string
+	c := newTestChecker(&now, &stalled)
+
+	c.Observe(aggregate.AggregationResult{Cluster: "c1", Time: now})
+	c.Observe(aggregate.AggregationResult{Cluster: "c2", Time: now})
+	assert.True(t, c.Liveness().OK)
+	assert.True(t, c.Readiness().OK)
+	assert.Equal(t, len(c.Readiness().Checks), 5)
+
+	// the meta servers are unreachable
+	now = now.Add(10 * time.Second)
+	c.Observe(aggregate.AggregationResult{Cluster: "c1", Time: now, MetaErr: errors.New("connection refused")})
+	c.Observe(aggregate.AggregationResult{Cluster: "c2", Time: now, ScrapeErr: errors.New("node 127.0.0.1:34801 timeout")})
+	assert.True(t, c.Liveness().OK)
+	r := c.Readiness()
+	assert.Equal(t, failedChecks(r), []string{"cluster/c1/meta", "cluster/c2/scrape"})
+	assert.Equal(t, r.Checks[0].Msg, "connection refused")
+
+	// recovered
+	c.Observe(aggregate.AggregationResult{Cluster: "c1", Time: now})
+	c.Observe(aggregate.AggregationResult{Cluster: "c2", Time: now})
+	assert.True(t, c.Readiness().OK)
+
+	// the sinks are stalled
+	stalled = []string{"influxdb"}
+	assert.Equal(t, failedChecks(c.Readiness()), []string{"sinks"})
+	stalled = nil
+
+	// the rounds of c2 are stuck
+	now = now.Add(40 * time.Second)
+	c.Observe(aggregate.AggregationResult{Cluster: "c1", Time: now})
+	assert.Equal(t, failedChecks(c.Liveness()), []string{"cluster/c2/aggregation"})
+	assert.Equal(t, failedChecks(c.Readiness()), []string{"cluster/c2/scrape"})
+
+	c.Remove("c2")
+	assert.True(t, c.Liveness().OK)
+	assert.True(t, c.Readiness().OK)
+
+	c.SetStaleAfter(time.Second)
+	now = now.Add(2 * time.Second)
+	assert.False(t, c.Liveness().OK)
+}
@@ -0,0 +1,412 @@
+package hotkey
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/XiaoMi/pegasus-go-client/idl/base"
+	"github.com/pegasus-kv/collector/hotspot"
+	"github.com/pegasus-kv/collector/logging"
+	"github.com/spf13/viper"
+)
+
+var log = logging.Module("hotkey")
+
+// The timeout of each RPC to the replica server.
+const callTimeout = 5 * time.Second
+
+// Config is the configuration of the hotkey detection.
+type Config struct {
+	// A partition flagged as a hotspot for this number of consecutive rounds triggers the
+	// detection automatically. The automatic trigger is disabled if it's zero.
+	ConsecutiveRounds int
+
+	// The detections beyond this number are rejected, including the manual ones, since the
+	// detection costs the CPU of the replica servers.
+	MaxConcurrentDetections int
+
+	// The interval to query the result of a running detection.
+	PollInterval time.Duration
+
+	// A detection without result after this duration is stopped.
+	Timeout time.Duration
+
+	// The cluster whose persistent hotspots trigger the detections automatically.
+	Cluster string
+}
+
+// Validate checks the configuration.
+func (cfg *Config) Validate() error {
+	if cfg.ConsecutiveRounds < 0 {
+		return fmt.Errorf("the consecutive rounds of hotkey detection must not be negative: %d", cfg.ConsecutiveRounds)
+	}
+	if cfg.MaxConcurrentDetections <= 0 {
+		return fmt.Errorf("the max concurrent hotkey detections must be positive: %d", cfg.MaxConcurrentDetections)
+	}
+	if cfg.PollInterval <= 0 || cfg.Timeout <= 0 {
+		return fmt.Errorf("the poll interval and the timeout of hotkey detection must be positive")
+	}
+	return nil
+}
+
+// State is the state of a Detection.
+type State string
+
+const (
+	// Running is waiting for the result of the replica server.
+	Running State = "running"
+
+	// Finished has found the hot key.
+	Finished State = "finished"
+
+	// Failed is the detection that the replica server refuses to start.
+	Failed State = "failed"
+
+	// Stopped is stopped manually or by the shutdown of the collector.
+	Stopped State = "stopped"
+
+	// TimedOut is stopped without result after the timeout.
+	TimedOut State = "timeout"
+)
+
+// Detection is a hotkey detection of a partition.
+type Detection struct {
+	TableName      string    `json:"table"`
+	AppID          int32     `json:"app_id"`
+	PartitionIndex int32     `json:"partition_index"`
+	Addr           string    `json:"addr"`
+	Type           Type      `json:"type"`
+	State          State     `json:"state"`
+	HotKey         string    `json:"hotkey,omitempty"`
+	Error          string    `json:"error,omitempty"`
+	StartedAt      time.Time `json:"started_at"`
+	FinishedAt     time.Time `json:"finished_at"`
+
+	// Whether it's triggered by a persistent hotspot rather than manually.
+	Automatic bool `json:"automatic"`
+
+	cancel context.CancelFunc
+}
+
+type detectionKey struct {
+	gpid base.Gpid
+	typ  Type
+}
+
+// streak is the number of consecutive rounds that a partition is flagged.
+type streak struct {
+	rounds int
+	// whether the detection has been triggered during this streak
+	triggered bool
+}
+
+// Manager triggers the hotkey detections of the persistent hotspots, and controls the detections
+// on the replica servers. Only the latest detection of each partition and type is kept.
+type Manager struct {
+	cfg    Config
+	caller caller
+
+	// the context of all detections, which are stopped once it's done
+	ctx context.Context
+
+	lock       sync.Mutex
+	streaks    map[detectionKey]*streak
+	detections map[detectionKey]*Detection
+	running    int
+}
+
+// NewManager returns a Manager whose detections are stopped after `ctx` is done.
+func NewManager(ctx context.Context, cfg Config) (*Manager, error) {
+	return newManager(ctx, cfg, sessionCaller{})
+}
+
+func newManager(ctx context.Context, cfg Config, c caller) (*Manager, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return &Manager{
+		cfg:        cfg,
+		caller:     c,
+		ctx:        ctx,
+		streaks:    make(map[detectionKey]*streak),
+		detections: make(map[detectionKey]*Detection),
+	}, nil
+}
+
+// OnHotspotsDetected counts the consecutive rounds of the hotspots, and starts the detection of
+// a partition once it reaches Config.ConsecutiveRounds. A partition is detected at most once
+// during a streak. The hotspots of the clusters other than Config.Cluster are ignored. It
+// implements hotspot.HookAfterDetected.
+func (m *Manager) OnHotspotsDetected(cluster string, hotspots []hotspot.Hotspot) {
+	if m.cfg.ConsecutiveRounds == 0 || cluster != m.cfg.Cluster {
+		return
+	}
+	flagged := make(map[detectionKey]hotspot.Hotspot)
+	for _, h := range hotspots {
+		key := detectionKey{
+			gpid: base.Gpid{Appid: int32(h.AppID), PartitionIndex: int32(h.PartitionIndex)},
+			typ:  typeOfMetric(h.Metric),
+		}
+		flagged[key] = h
+	}
+
+	var triggers []hotspot.Hotspot
+	m.lock.Lock()
+	for key := range m.streaks {
+		if _, found := flagged[key]; !found {
+			delete(m.streaks, key)
+		}
+	}
+	for key, h := range flagged {
+		s := m.streaks[key]
+		if s == nil {
+			s = &streak{}
+			m.streaks[key] = s
+		}
+		s.rounds++
+		if s.rounds >= m.cfg.ConsecutiveRounds && !s.triggered {
+			triggers = append(triggers, h)
+		}
+	}
+	m.lock.Unlock()
+
+	for _, h := range triggers {
+		key := detectionKey{
+			gpid: base.Gpid{Appid: int32(h.AppID), PartitionIndex: int32(h.PartitionIndex)},
+			typ:  typeOfMetric(h.Metric),
+		}
+		_, err := m.start(h.TableName, key, h.Addr, true)
+		if err != nil {
+			// retry in the next round
+			log.Warnf("failed to trigger the hotkey detection of the persistent hotspot %s: %s", &key.gpid, err)
+			continue
+		}
+		m.lock.Lock()
+		if s := m.streaks[key]; s != nil {
+			s.triggered = true
+		}
+		m.lock.Unlock()
+		log.Infof("%s hotkey detection of partition %s of table %s on %s is triggered by the hotspot of %s for %d rounds",
+			key.typ, &key.gpid, h.TableName, h.Addr, h.Metric, m.cfg.ConsecutiveRounds)
+	}
+}
+
+// typeOfMetric returns Write for the metrics of the write operations, otherwise Read.
+func typeOfMetric(metric string) Type {
+	for _, prefix := range []string{"write", "put", "multi_put", "remove", "multi_remove", "check_and_set", "check_and_mutate", "incr"} {
+		if strings.HasPrefix(metric, prefix) {
+			return Write
+		}
+	}
+	return Read
+}
+
+// StartDetection starts the hotkey detection of the partition whose primary is on `addr`.
+func (m *Manager) StartDetection(tableName string, gpid base.Gpid, addr string, typ Type) (Detection, error) {
+	if _, err := typ.thriftValue(); err != nil {
+		return Detection{}, err
+	}
+	return m.start(tableName, detectionKey{gpid: gpid, typ: typ}, addr, false)
+}
+
+func (m *Manager) start(tableName string, key detectionKey, addr string, automatic bool) (Detection, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if d := m.detections[key]; d != nil && d.State == Running {
+		return Detection{}, fmt.Errorf("%s hotkey detection of partition %s is running", key.typ, &key.gpid)
+	}
+	if m.running >= m.cfg.MaxConcurrentDetections {
+		return Detection{}, fmt.Errorf("too many running hotkey detections: %d", m.running)
+	}
+
+	ctx, cancel := context.WithCancel(m.ctx)
+	d := &Detection{
+		TableName:      tableName,
+		AppID:          key.gpid.Appid,
+		PartitionIndex: key.gpid.PartitionIndex,
+		Addr:           addr,
+		Type:           key.typ,
+		State:          Running,
+		StartedAt:      time.Now(),
+		Automatic:      automatic,
+		cancel:         cancel,
+	}
+	m.detections[key] = d
+	m.running++
+	go m.run(ctx, key, d)
+	return *d, nil
+}
+
+// StopDetection stops the running hotkey detection of the partition.
+func (m *Manager) StopDetection(gpid base.Gpid, typ Type) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	d := m.detections[detectionKey{gpid: gpid, typ: typ}]
+	if d == nil || d.State != Running {
+		return fmt.Errorf("no %s hotkey detection of partition %s is running", typ, &gpid)
+	}
+	d.cancel()
+	return nil
+}
+
+// Detections returns the latest detections, of the given table if `table` is not empty, sorted
+// by the gpid and the type.
+func (m *Manager) Detections(table string) []Detection {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	res := []Detection{}
+	for _, d := range m.detections {
+		if table == "" || d.TableName == table {
+			res = append(res, *d)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].AppID != res[j].AppID {
+			return res[i].AppID < res[j].AppID
+		}
+		if res[i].PartitionIndex != res[j].PartitionIndex {
+			return res[i].PartitionIndex < res[j].PartitionIndex
+		}
+		return res[i].Type < res[j].Type
+	})
+	return res
+}
+
+// run starts the detection on the replica server, and queries the result every poll interval
+// until the hot key is found, the timeout expires, or `ctx` is done.
+func (m *Manager) run(ctx context.Context, key detectionKey, d *Detection) {
+	if _, err := m.call(ctx, d.Addr, key, actionStart); err != nil {
+		m.finish(d, Failed, "", err)
+		return
+	}
+
+	ticker := time.NewTicker(m.cfg.PollInterval)
+	defer ticker.Stop()
+	timer := time.NewTimer(m.cfg.Timeout)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			m.stop(key, d, Stopped)
+			return
+		case <-timer.C:
+			m.stop(key, d, TimedOut)
+			return
+		case <-ticker.C:
+		}
+		hotkey, err := m.call(ctx, d.Addr, key, actionQuery)
+		if err != nil {
+			// the replica server responds an error until the hot key is found
+			log.Debugf("no result of %s hotkey detection of partition %s yet: %s", key.typ, &key.gpid, err)
+			continue
+		}
+		if hotkey != "" {
+			log.Infof("%s hotkey of partition %s of table %s on %s is detected: %s",
+				key.typ, &key.gpid, d.TableName, d.Addr, hotkey)
+			m.finish(d, Finished, hotkey, nil)
+			// clear the result on the replica server, so that the partition can be detected again
+			m.stop(key, d, "")
+			return
+		}
+	}
+}
+
+// stop sends STOP to the replica server, and finishes the detection in `state` if it's not empty.
+func (m *Manager) stop(key detectionKey, d *Detection, state State) {
+	// `ctx` of the detection may be done, so the STOP is sent by a fresh one
+	if _, err := m.call(context.Background(), d.Addr, key, actionStop); err != nil {
+		log.Warnf("failed to stop %s hotkey detection of partition %s on %s: %s", key.typ, &key.gpid, d.Addr, err)
+	}
+	if state != "" {
+		log.Infof("%s hotkey detection of partition %s on %s is %s", key.typ, &key.gpid, d.Addr, state)
+		m.finish(d, state, "", nil)
+	}
+}
+
+func (m *Manager) call(ctx context.Context, addr string, key detectionKey, action detectAction) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, callTimeout)
+	defer cancel()
+	return m.caller.call(ctx, addr, key.gpid, key.typ, action)
+}
+
+func (m *Manager) finish(d *Detection, state State, hotkey string, err error) {
+	if err != nil {
+		log.Errorf("%s hotkey detection of partition %d.%d failed: %s", d.Type, d.AppID, d.PartitionIndex, err)
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if d.State != Running {
+		return
+	}
+	d.State = state
+	d.HotKey = hotkey
+	if err != nil {
+		d.Error = err.Error()
+	}
+	d.FinishedAt = time.Now()
+	d.cancel()
+	m.running--
+}
+
+var defaultManager *Manager
+
+// Start creates the manager configured by the "hotkey" section, which triggers the detections
+// of the hotspots flagged by the hotspot package. The detections are stopped once `ctx` is done.
+// It does nothing if the detection is disabled.
+func Start(ctx context.Context) error {
+	if !viper.GetBool("hotkey.enabled") {
+		return nil
+	}
+	viper.SetDefault("hotkey.consecutive_rounds", 3)
+	viper.SetDefault("hotkey.max_concurrent_detections", 2)
+	viper.SetDefault("hotkey.poll_interval", "5s")
+	viper.SetDefault("hotkey.timeout", "5m")
+	cfg := Config{
+		ConsecutiveRounds:       viper.GetInt("hotkey.consecutive_rounds"),
+		MaxConcurrentDetections: viper.GetInt("hotkey.max_concurrent_detections"),
+		PollInterval:            viper.GetDuration("hotkey.poll_interval"),
+		Timeout:                 viper.GetDuration("hotkey.timeout"),
+		Cluster:                 viper.GetString("cluster_name"),
+	}
+	m, err := NewManager(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	hotspot.AddHookAfterDetected(m.OnHotspotsDetected)
+	defaultManager = m
+	return nil
+}
+
+// Enabled returns whether the manager is created by Start.
+func Enabled() bool {
+	return defaultManager != nil
+}
+
+// StartDetection starts a detection by the manager created by Start.
+func StartDetection(tableName string, gpid base.Gpid, addr string, typ Type) (Detection, error) {
+	if defaultManager == nil {
+		return Detection{}, fmt.Errorf("hotkey detection is disabled")
+	}
+	return defaultManager.StartDetection(tableName, gpid, addr, typ)
+}
+
+// StopDetection stops a detection by the manager created by Start.
+func StopDetection(gpid base.Gpid, typ Type) error {
+	if defaultManager == nil {
+		return fmt.Errorf("hotkey detection is disabled")
+	}
+	return defaultManager.StopDetection(gpid, typ)
+}
+
+// Detections returns the detections of the manager created by Start, or nil if the detection
+// is disabled.
+func Detections(table string) []Detection {
+	if defaultManager == nil {
+		return nil
+	}
+	return defaultManager.Detections(table)
+}
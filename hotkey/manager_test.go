@@ -0,0 +1,148 @@
+package hotkey
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/XiaoMi/pegasus-go-client/idl/base"
+	"github.com/pegasus-kv/collector/hotspot"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeCaller finds the hot key of a partition after it's queried `queries` times.
+type fakeCaller struct {
+	queries int
+
+	lock    sync.Mutex
+	actions map[base.Gpid][]detectAction
+}
+
+func (c *fakeCaller) call(_ context.Context, _ string, gpid base.Gpid, _ Type, action detectAction) (string, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.actions[gpid] = append(c.actions[gpid], action)
+	if gpid.PartitionIndex < 0 {
+		return "", fmt.Errorf("ERR_OBJECT_NOT_FOUND")
+	}
+	if action != actionQuery {
+		return "", nil
+	}
+	n := 0
+	for _, a := range c.actions[gpid] {
+		if a == actionQuery {
+			n++
+		}
+	}
+	if n < c.queries {
+		return "", fmt.Errorf("ERR_BUSY")
+	}
+	return "hashkey_1", nil
+}
+
+func (c *fakeCaller) actionsOf(gpid base.Gpid) []detectAction {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return append([]detectAction{}, c.actions[gpid]...)
+}
+
+func newTestManager(t *testing.T, queries int) (*Manager, *fakeCaller) {
+	c := &fakeCaller{queries: queries, actions: make(map[base.Gpid][]detectAction)}
+	m, err := newManager(context.Background(), Config{
+		ConsecutiveRounds:       2,
+		MaxConcurrentDetections: 1,
+		PollInterval:            time.Millisecond,
+		Timeout:                 time.Minute,
+		Cluster:                 "onebox",
+	}, c)
+	assert.Nil(t, err)
+	return m, c
+}
+
+func waitState(t *testing.T, m *Manager, state State) Detection {
+	var d Detection
+	assert.Eventually(t, func() bool {
+		ds := m.Detections("")
+		if len(ds) != 1 {
+			return false
+		}
+		d = ds[0]
+		return d.State == state
+	}, time.Second, time.Millisecond)
+	return d
+}
+
+func TestManagerTriggerOnPersistentHotspot(t *testing.T) {
+	m, c := newTestManager(t, 3)
+	h := hotspot.Hotspot{TableName: "stat", AppID: 1, PartitionIndex: 2, Addr: "127.0.0.1:34801", Metric: "write_qps"}
+	gpid := base.Gpid{Appid: 1, PartitionIndex: 2}
+
+	m.OnHotspotsDetected("onebox", []hotspot.Hotspot{h})
+	assert.Empty(t, m.Detections(""))
+	// the streak breaks
+	m.OnHotspotsDetected("onebox", nil)
+	m.OnHotspotsDetected("onebox", []hotspot.Hotspot{h})
+	assert.Empty(t, m.Detections(""))
+	// the rounds of the other clusters neither break nor count the streak
+	m.OnHotspotsDetected("c2", nil)
+	m.OnHotspotsDetected("c2", []hotspot.Hotspot{h})
+	assert.Empty(t, m.Detections(""))
+
+	m.OnHotspotsDetected("onebox", []hotspot.Hotspot{h})
+	d := waitState(t, m, Finished)
+	assert.Equal(t, d.HotKey, "hashkey_1")
+	assert.Equal(t, d.Type, Write)
+	assert.True(t, d.Automatic)
+	assert.Eventually(t, func() bool {
+		return len(c.actionsOf(gpid)) == 5
+	}, time.Second, time.Millisecond)
+	assert.Equal(t, c.actionsOf(gpid), []detectAction{actionStart, actionQuery, actionQuery, actionQuery, actionStop})
+
+	// not triggered again during the streak
+	m.OnHotspotsDetected("onebox", []hotspot.Hotspot{h})
+	assert.Equal(t, len(c.actionsOf(gpid)), 5)
+}
+
+func TestManagerControl(t *testing.T) {
+	m, c := newTestManager(t, 1<<30)
+	gpid := base.Gpid{Appid: 1, PartitionIndex: 0}
+
+	d, err := m.StartDetection("stat", gpid, "127.0.0.1:34801", Read)
+	assert.Nil(t, err)
+	assert.Equal(t, d.State, Running)
+	_, err = m.StartDetection("stat", gpid, "127.0.0.1:34801", Read)
+	assert.NotNil(t, err)
+	// exceeds the max concurrent detections
+	_, err = m.StartDetection("stat", base.Gpid{Appid: 1, PartitionIndex: 1}, "127.0.0.1:34801", Read)
+	assert.NotNil(t, err)
+
+	assert.Nil(t, m.StopDetection(gpid, Read))
+	waitState(t, m, Stopped)
+	actions := c.actionsOf(gpid)
+	assert.Equal(t, actions[len(actions)-1], actionStop)
+	assert.NotNil(t, m.StopDetection(gpid, Read))
+
+	// the replica server refuses to start
+	failed := base.Gpid{Appid: 1, PartitionIndex: -1}
+	_, err = m.StartDetection("stat", failed, "127.0.0.1:34801", Read)
+	assert.Nil(t, err)
+	assert.Eventually(t, func() bool {
+		for _, d := range m.Detections("stat") {
+			if d.PartitionIndex == -1 {
+				return d.State == Failed && d.Error == "ERR_OBJECT_NOT_FOUND"
+			}
+		}
+		return false
+	}, time.Second, time.Millisecond)
+	assert.Equal(t, len(m.Detections("stat")), 2)
+	assert.Empty(t, m.Detections("temp"))
+}
+
+func TestTypeOfMetric(t *testing.T) {
+	assert.Equal(t, typeOfMetric("write_bytes"), Write)
+	assert.Equal(t, typeOfMetric("multi_put_qps"), Write)
+	assert.Equal(t, typeOfMetric("read_qps"), Read)
+	assert.Equal(t, typeOfMetric("get_bytes"), Read)
+}
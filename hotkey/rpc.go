@@ -0,0 +1,243 @@
+package hotkey
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/XiaoMi/pegasus-go-client/idl/base"
+	"github.com/XiaoMi/pegasus-go-client/session"
+	"github.com/apache/thrift/lib/go/thrift"
+)
+
+// The RPC of the replica server to control the hotkey detection of a partition, which is absent
+// from pegasus-go-client. See detect_hotkey_request in replica_admin.thrift of Pegasus.
+const rpcDetectHotkey = "RPC_DETECT_HOTKEY"
+
+func init() {
+	session.RegisterRPCResultHandler(rpcDetectHotkey+"_ACK", func() session.RpcResponseResult {
+		return &detectHotkeyResult{Success: &detectHotkeyResponse{}}
+	})
+}
+
+// Type is the kind of the requests whose keys are detected.
+type Type string
+
+const (
+	// Read detects the hot keys of the read requests.
+	Read Type = "read"
+
+	// Write detects the hot keys of the write requests.
+	Write Type = "write"
+)
+
+func (t Type) thriftValue() (int32, error) {
+	switch t {
+	case Read:
+		return 0, nil
+	case Write:
+		return 1, nil
+	default:
+		return 0, fmt.Errorf("invalid hotkey type \"%s\"", t)
+	}
+}
+
+// detectAction is the detect_action enum.
+type detectAction int32
+
+const (
+	actionStart detectAction = 0
+	actionStop  detectAction = 1
+	actionQuery detectAction = 2
+)
+
+func (a detectAction) String() string {
+	switch a {
+	case actionStart:
+		return "START"
+	case actionStop:
+		return "STOP"
+	default:
+		return "QUERY"
+	}
+}
+
+// detectHotkeyRequest is the detect_hotkey_request struct.
+type detectHotkeyRequest struct {
+	Type   int32
+	Action detectAction
+	Pid    *base.Gpid
+}
+
+// detectHotkeyArgs wraps the request as the arguments of the RPC.
+type detectHotkeyArgs struct {
+	Req *detectHotkeyRequest
+}
+
+func (p *detectHotkeyArgs) String() string {
+	if p == nil || p.Req == nil {
+		return "<nil>"
+	}
+	return fmt.Sprintf("detectHotkeyArgs(%d, %s, %s)", p.Req.Type, p.Req.Action, p.Req.Pid)
+}
+
+func (p *detectHotkeyArgs) Write(oprot thrift.TProtocol) error {
+	if err := oprot.WriteStructBegin("detect_hotkey_args"); err != nil {
+		return err
+	}
+	if err := oprot.WriteFieldBegin("req", thrift.STRUCT, 1); err != nil {
+		return err
+	}
+	if err := p.Req.write(oprot); err != nil {
+		return err
+	}
+	if err := oprot.WriteFieldEnd(); err != nil {
+		return err
+	}
+	if err := oprot.WriteFieldStop(); err != nil {
+		return err
+	}
+	return oprot.WriteStructEnd()
+}
+
+func (r *detectHotkeyRequest) write(oprot thrift.TProtocol) error {
+	if err := oprot.WriteStructBegin("detect_hotkey_request"); err != nil {
+		return err
+	}
+	for _, f := range []struct {
+		name  string
+		id    int16
+		value int32
+	}{
+		{"type", 1, r.Type},
+		{"action", 2, int32(r.Action)},
+	} {
+		if err := oprot.WriteFieldBegin(f.name, thrift.I32, f.id); err != nil {
+			return err
+		}
+		if err := oprot.WriteI32(f.value); err != nil {
+			return err
+		}
+		if err := oprot.WriteFieldEnd(); err != nil {
+			return err
+		}
+	}
+	if err := oprot.WriteFieldBegin("pid", thrift.STRUCT, 3); err != nil {
+		return err
+	}
+	// base.Gpid.Write of pegasus-go-client loses the partition index by shifting the int32
+	if err := oprot.WriteI64(int64(uint32(r.Pid.Appid)) | int64(r.Pid.PartitionIndex)<<32); err != nil {
+		return err
+	}
+	if err := oprot.WriteFieldEnd(); err != nil {
+		return err
+	}
+	if err := oprot.WriteFieldStop(); err != nil {
+		return err
+	}
+	return oprot.WriteStructEnd()
+}
+
+// detectHotkeyResponse is the detect_hotkey_response struct.
+type detectHotkeyResponse struct {
+	Err          *base.ErrorCode
+	ErrHint      string
+	HotkeyResult string
+}
+
+// detectHotkeyResult wraps the response as the result of the RPC.
+type detectHotkeyResult struct {
+	Success *detectHotkeyResponse
+}
+
+func (p *detectHotkeyResult) String() string {
+	if p == nil || p.Success == nil {
+		return "<nil>"
+	}
+	return fmt.Sprintf("detectHotkeyResult(%+v)", *p.Success)
+}
+
+func (p *detectHotkeyResult) Read(iprot thrift.TProtocol) error {
+	return readStruct(iprot, func(id int16, typ thrift.TType) (bool, error) {
+		if id != 0 || typ != thrift.STRUCT {
+			return false, nil
+		}
+		return true, p.Success.read(iprot)
+	})
+}
+
+func (r *detectHotkeyResponse) read(iprot thrift.TProtocol) error {
+	return readStruct(iprot, func(id int16, typ thrift.TType) (bool, error) {
+		var err error
+		switch {
+		case id == 1 && typ == thrift.STRUCT:
+			r.Err = &base.ErrorCode{}
+			err = r.Err.Read(iprot)
+		case id == 2 && typ == thrift.STRING:
+			r.ErrHint, err = iprot.ReadString()
+		case id == 3 && typ == thrift.STRING:
+			r.HotkeyResult, err = iprot.ReadString()
+		default:
+			return false, nil
+		}
+		return true, err
+	})
+}
+
+// readStruct reads the fields of a struct by `readField`, which returns false if the field
+// is unknown and should be skipped.
+func readStruct(iprot thrift.TProtocol, readField func(id int16, typ thrift.TType) (bool, error)) error {
+	if _, err := iprot.ReadStructBegin(); err != nil {
+		return err
+	}
+	for {
+		_, typ, id, err := iprot.ReadFieldBegin()
+		if err != nil {
+			return err
+		}
+		if typ == thrift.STOP {
+			break
+		}
+		known, err := readField(id, typ)
+		if err != nil {
+			return err
+		}
+		if !known {
+			if err := iprot.Skip(typ); err != nil {
+				return err
+			}
+		}
+		if err := iprot.ReadFieldEnd(); err != nil {
+			return err
+		}
+	}
+	return iprot.ReadStructEnd()
+}
+
+// caller sends the RPC_DETECT_HOTKEY to the replica server at `addr`, and returns the hot key
+// found by QUERY, if any.
+type caller interface {
+	call(ctx context.Context, addr string, gpid base.Gpid, typ Type, action detectAction) (string, error)
+}
+
+// sessionCaller sends the RPC by a new session each time, since the RPCs are rare.
+type sessionCaller struct{}
+
+func (sessionCaller) call(ctx context.Context, addr string, gpid base.Gpid, typ Type, action detectAction) (string, error) {
+	t, err := typ.thriftValue()
+	if err != nil {
+		return "", err
+	}
+	s := session.NewNodeSession(addr, session.NodeTypeReplica)
+	defer s.Close()
+
+	args := &detectHotkeyArgs{Req: &detectHotkeyRequest{Type: t, Action: action, Pid: &gpid}}
+	res, err := s.CallWithGpid(ctx, &gpid, args, rpcDetectHotkey)
+	if err != nil {
+		return "", err
+	}
+	resp := res.(*detectHotkeyResult).Success
+	if resp.Err != nil && resp.Err.Errno != base.ERR_OK.String() {
+		return "", fmt.Errorf("%s of hotkey detection on %s: %s %s", action, addr, resp.Err.Errno, resp.ErrHint)
+	}
+	return resp.HotkeyResult, nil
+}
@@ -0,0 +1,66 @@
+package hotkey
+
+import (
+	"context"
+	"testing"
+
+	"github.com/XiaoMi/pegasus-go-client/idl/base"
+	"github.com/apache/thrift/lib/go/thrift"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDetectHotkeyArgsWrite(t *testing.T) {
+	buf := thrift.NewTMemoryBuffer()
+	oprot := thrift.NewTBinaryProtocolTransport(buf)
+	args := &detectHotkeyArgs{Req: &detectHotkeyRequest{Type: 1, Action: actionQuery, Pid: &base.Gpid{Appid: 2, PartitionIndex: 3}}}
+	assert.Nil(t, args.Write(oprot))
+	assert.Nil(t, oprot.Flush(context.Background()))
+
+	iprot := thrift.NewTBinaryProtocolTransport(buf)
+	var fields []int32
+	assert.Nil(t, readStruct(iprot, func(id int16, typ thrift.TType) (bool, error) {
+		assert.Equal(t, id, int16(1))
+		return true, readStruct(iprot, func(id int16, typ thrift.TType) (bool, error) {
+			if id == 3 {
+				pid := &base.Gpid{}
+				err := pid.Read(iprot)
+				assert.Equal(t, *pid, base.Gpid{Appid: 2, PartitionIndex: 3})
+				return true, err
+			}
+			v, err := iprot.ReadI32()
+			fields = append(fields, v)
+			return true, err
+		})
+	}))
+	assert.Equal(t, fields, []int32{1, 2})
+}
+
+func TestDetectHotkeyResultRead(t *testing.T) {
+	buf := thrift.NewTMemoryBuffer()
+	oprot := thrift.NewTBinaryProtocolTransport(buf)
+	ctx := context.Background()
+	assert.Nil(t, oprot.WriteStructBegin("result"))
+	assert.Nil(t, oprot.WriteFieldBegin("success", thrift.STRUCT, 0))
+	assert.Nil(t, oprot.WriteStructBegin("detect_hotkey_response"))
+	assert.Nil(t, oprot.WriteFieldBegin("err", thrift.STRUCT, 1))
+	assert.Nil(t, (&base.ErrorCode{Errno: "ERR_OK"}).Write(oprot))
+	assert.Nil(t, oprot.WriteFieldEnd())
+	// an unknown field is skipped
+	assert.Nil(t, oprot.WriteFieldBegin("unknown", thrift.I64, 9))
+	assert.Nil(t, oprot.WriteI64(7))
+	assert.Nil(t, oprot.WriteFieldEnd())
+	assert.Nil(t, oprot.WriteFieldBegin("hotkey_result", thrift.STRING, 3))
+	assert.Nil(t, oprot.WriteString("user_1"))
+	assert.Nil(t, oprot.WriteFieldEnd())
+	assert.Nil(t, oprot.WriteFieldStop())
+	assert.Nil(t, oprot.WriteStructEnd())
+	assert.Nil(t, oprot.WriteFieldEnd())
+	assert.Nil(t, oprot.WriteFieldStop())
+	assert.Nil(t, oprot.WriteStructEnd())
+	assert.Nil(t, oprot.Flush(ctx))
+
+	res := &detectHotkeyResult{Success: &detectHotkeyResponse{}}
+	assert.Nil(t, res.Read(thrift.NewTBinaryProtocolTransport(buf)))
+	assert.Equal(t, res.Success.Err.Errno, "ERR_OK")
+	assert.Equal(t, res.Success.HotkeyResult, "user_1")
+}
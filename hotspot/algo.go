@@ -1 +1,172 @@
 package hotspot
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/pegasus-kv/collector/aggregate"
+)
+
+// Method is how the load of a partition is judged as a hotspot, see HotspotDetector.
+type Method string
+
+const (
+	// ZScore flags the partitions whose (value - mean) / stddev exceeds the threshold.
+	ZScore Method = "zscore"
+
+	// RatioToMean flags the partitions whose value / mean exceeds the threshold.
+	RatioToMean Method = "ratio"
+
+	// CoefficientOfVariation flags the partitions above mean + stddev, if the stddev / mean of
+	// the table exceeds the threshold. The score of the hotspots is the coefficient.
+	CoefficientOfVariation Method = "cv"
+
+	// EWMA smooths the value of each partition by the exponentially weighted moving average over
+	// the rounds, and flags the partitions whose smoothed value / smoothed mean exceeds the
+	// threshold, so that a transient spike is not flagged.
+	EWMA Method = "ewma"
+
+	// TopK flags at most Config.TopK partitions with the largest values, among those whose
+	// value / mean exceeds the threshold.
+	TopK Method = "topk"
+)
+
+// Config is the configuration of the hotspot detection.
+type Config struct {
+	// The metrics whose distributions across the partitions are analyzed, e.g. "read_qps".
+	Metrics []string
+
+	Method    Method
+	Threshold float64
+
+	// The tables with fewer partitions reporting the metric are skipped, since the distribution
+	// of a few partitions is meaningless.
+	MinPartitionCount int
+
+	// The max number of hotspots of each table and metric flagged by TopK.
+	TopK int
+
+	// The weight of the latest value in (0, 1] for EWMA.
+	EWMAAlpha float64
+
+	// The method and the threshold of the specific tables, which override the above.
+	Tables []TableConfig
+}
+
+// TableConfig is the method and the threshold of a table. The default ones are used if they
+// are empty.
+type TableConfig struct {
+	Name      string
+	Method    Method
+	Threshold float64
+}
+
+// Validate checks the configuration.
+func (cfg *Config) Validate() error {
+	if err := cfg.validateMethod(cfg.Method, cfg.Threshold); err != nil {
+		return err
+	}
+	for _, tb := range cfg.Tables {
+		if tb.Name == "" {
+			return fmt.Errorf("no table name is given for the hotspot method \"%s\"", tb.Method)
+		}
+		method, threshold := cfg.methodOf(tb.Name)
+		if err := cfg.validateMethod(method, threshold); err != nil {
+			return fmt.Errorf("table %s: %s", tb.Name, err)
+		}
+	}
+	return nil
+}
+
+func (cfg *Config) validateMethod(method Method, threshold float64) error {
+	switch method {
+	case ZScore, RatioToMean, CoefficientOfVariation:
+	case EWMA:
+		if cfg.EWMAAlpha <= 0 || cfg.EWMAAlpha > 1 {
+			return fmt.Errorf("the EWMA alpha must be in (0, 1]: %f", cfg.EWMAAlpha)
+		}
+	case TopK:
+		if cfg.TopK <= 0 {
+			return fmt.Errorf("the K of hotspot method topk must be positive: %d", cfg.TopK)
+		}
+	default:
+		return fmt.Errorf("invalid hotspot method \"%s\"", method)
+	}
+	if threshold <= 0 {
+		return fmt.Errorf("the hotspot threshold must be positive: %f", threshold)
+	}
+	return nil
+}
+
+// methodOf returns the method and the threshold of the table.
+func (cfg *Config) methodOf(table string) (Method, float64) {
+	method, threshold := cfg.Method, cfg.Threshold
+	for _, tb := range cfg.Tables {
+		if tb.Name != table {
+			continue
+		}
+		if tb.Method != "" {
+			method = tb.Method
+		}
+		if tb.Threshold != 0 {
+			threshold = tb.Threshold
+		}
+	}
+	return method, threshold
+}
+
+// methods returns the distinct methods of all tables.
+func (cfg *Config) methods() []Method {
+	res := []Method{cfg.Method}
+	for _, tb := range cfg.Tables {
+		if tb.Method != "" && tb.Method != cfg.Method {
+			res = append(res, tb.Method)
+		}
+	}
+	return res
+}
+
+// Hotspot is a partition whose load of a metric deviates from the others of the table.
+type Hotspot struct {
+	Cluster        string  `json:"cluster,omitempty"`
+	TableName      string  `json:"table"`
+	AppID          int     `json:"app_id"`
+	PartitionIndex int     `json:"partition_index"`
+	Addr           string  `json:"addr"`
+	Metric         string  `json:"metric"`
+	Value          float64 `json:"value"`
+	Mean           float64 `json:"mean"`
+	StdDev         float64 `json:"stddev"`
+
+	// The score compared with the threshold, depending on the Method.
+	Score float64 `json:"score"`
+}
+
+// Detect returns the hotspots of the table by the method of the table, sorted by the metric and
+// the partition index. The EWMA method has no history this way, see Detector.
+func Detect(tb *aggregate.TableStats, cfg Config) []Hotspot {
+	method, threshold := cfg.methodOf(tb.TableName)
+	d, err := NewHotspotDetector(method, cfg)
+	if err != nil {
+		return nil
+	}
+	return detectWith(d, tb, cfg.Metrics, cfg.MinPartitionCount, threshold)
+}
+
+func detectWith(d HotspotDetector, tb *aggregate.TableStats, metrics []string, minPartitionCount int, threshold float64) []Hotspot {
+	var res []Hotspot
+	for _, metric := range metrics {
+		dist := NewDistribution(tb, metric)
+		if len(dist.Values) == 0 || len(dist.Values) < minPartitionCount || dist.Mean <= 0 {
+			continue
+		}
+		res = append(res, d.Detect(tb, dist, threshold)...)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Metric != res[j].Metric {
+			return res[i].Metric < res[j].Metric
+		}
+		return res[i].PartitionIndex < res[j].PartitionIndex
+	})
+	return res
+}
@@ -0,0 +1,49 @@
+package hotspot
+
+import (
+	"testing"
+
+	"github.com/XiaoMi/pegasus-go-client/idl/base"
+	"github.com/pegasus-kv/collector/aggregate"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTable(qps ...float64) *aggregate.TableStats {
+	tb := &aggregate.TableStats{TableName: "stat", AppID: 1, Partitions: make(map[int]*aggregate.PartitionStats)}
+	for i, v := range qps {
+		tb.Partitions[i] = &aggregate.PartitionStats{
+			Gpid:  base.Gpid{Appid: 1, PartitionIndex: int32(i)},
+			Addr:  "127.0.0.1:34801",
+			Stats: map[string]float64{"read_qps": v},
+		}
+	}
+	return tb
+}
+
+func TestDetect(t *testing.T) {
+	tb := newTable(10, 10, 10, 10, 10, 10, 10, 130)
+
+	hotspots := Detect(tb, Config{Metrics: []string{"read_qps"}, Method: RatioToMean, Threshold: 3})
+	assert.Equal(t, len(hotspots), 1)
+	assert.Equal(t, hotspots[0].PartitionIndex, 7)
+	assert.Equal(t, hotspots[0].Mean, float64(25))
+	assert.Equal(t, hotspots[0].Score, 5.2)
+
+	hotspots = Detect(tb, Config{Metrics: []string{"read_qps"}, Method: ZScore, Threshold: 2})
+	assert.Equal(t, len(hotspots), 1)
+	assert.InDelta(t, hotspots[0].Score, 2.6458, 1e-4)
+
+	// too few partitions
+	hotspots = Detect(tb, Config{Metrics: []string{"read_qps"}, Method: RatioToMean, Threshold: 3, MinPartitionCount: 10})
+	assert.Empty(t, hotspots)
+
+	// evenly distributed
+	hotspots = Detect(newTable(10, 10, 10, 10), Config{Metrics: []string{"read_qps"}, Method: ZScore, Threshold: 1})
+	assert.Empty(t, hotspots)
+}
+
+func TestConfigValidate(t *testing.T) {
+	assert.Nil(t, (&Config{Method: ZScore, Threshold: 3}).Validate())
+	assert.NotNil(t, (&Config{Method: "max", Threshold: 3}).Validate())
+	assert.NotNil(t, (&Config{Method: RatioToMean}).Validate())
+}
@@ -0,0 +1,341 @@
+package hotspot
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/pegasus-kv/collector/aggregate"
+	"github.com/pegasus-kv/collector/logging"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/spf13/viper"
+)
+
+var log = logging.Module("hotspot")
+
+// Detector analyzes the partitions of every table after each round of aggregation, and keeps
+// the hotspots of the latest round of each cluster.
+type Detector struct {
+	algLock sync.Mutex
+	// cluster -> method -> the detector of the tables of the method, since the history kept by
+	// the detectors is keyed by the app ID
+	algorithms map[string]map[Method]HotspotDetector
+
+	lock sync.RWMutex
+	// the configuration whose thresholds are tunable at runtime
+	cfg Config
+	// cluster -> the hotspots of the latest round
+	clusters map[string]*clusterHotspots
+
+	// the number of hot partitions of each table and metric
+	counts *prometheus.GaugeVec
+	// the score of each hot partition
+	scores *prometheus.GaugeVec
+
+	// the cluster of the stats not tagged with one
+	cluster string
+}
+
+// clusterHotspots is the hotspots of the latest round of a cluster, along with the labels of
+// the gauges set by the round.
+type clusterHotspots struct {
+	// app ID -> the hotspots of the table
+	hotspots map[int][]Hotspot
+	tables   []string
+	metrics  []string
+}
+
+// NewDetector returns a Detector whose gauges are registered into `registerer`.
+func NewDetector(cfg Config, registerer prometheus.Registerer, cluster string) (*Detector, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	for _, method := range cfg.methods() {
+		if _, err := NewHotspotDetector(method, cfg); err != nil {
+			return nil, err
+		}
+	}
+	cfg.Tables = append([]TableConfig{}, cfg.Tables...)
+	d := &Detector{
+		algorithms: make(map[string]map[Method]HotspotDetector),
+		cfg:        cfg,
+		clusters:   make(map[string]*clusterHotspots),
+		counts: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "hotspot_partition_count",
+			Help: "The number of hot partitions of the table by the metric.",
+		}, []string{"cluster", "table", "metric"}),
+		scores: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "hotspot_partition_score",
+			Help: "The score of the hot partition by the detection method of the table.",
+		}, []string{"cluster", "table", "partition", "metric"}),
+		cluster: cluster,
+	}
+	registerer.MustRegister(d.counts, d.scores)
+	return d, nil
+}
+
+// algorithmsOf returns the detectors of the cluster, which are created on the first round of
+// the cluster.
+func (d *Detector) algorithmsOf(cluster string, cfg Config) map[Method]HotspotDetector {
+	d.algLock.Lock()
+	defer d.algLock.Unlock()
+	algorithms, found := d.algorithms[cluster]
+	if !found {
+		algorithms = make(map[Method]HotspotDetector)
+		for _, method := range cfg.methods() {
+			// validated by NewDetector
+			algorithms[method], _ = NewHotspotDetector(method, cfg)
+		}
+		d.algorithms[cluster] = algorithms
+	}
+	return algorithms
+}
+
+// Report detects the hotspots of the tables of the cluster told by ClusterStats.Cluster. It
+// implements aggregate.HookAfterTableStatEmitted.
+func (d *Detector) Report(stats []aggregate.TableStats, allStats aggregate.ClusterStats) {
+	cluster := allStats.Cluster
+	if cluster == "" {
+		cluster = d.cluster
+	}
+	d.lock.RLock()
+	cfg := d.cfg
+	cfg.Tables = append([]TableConfig{}, d.cfg.Tables...)
+	d.lock.RUnlock()
+
+	algorithms := d.algorithmsOf(cluster, cfg)
+	c := &clusterHotspots{hotspots: make(map[int][]Hotspot, len(stats)), metrics: cfg.Metrics}
+	for i := range stats {
+		tb := &stats[i]
+		method, threshold := cfg.methodOf(tb.TableName)
+		hotspots := detectWith(algorithms[method], tb, cfg.Metrics, cfg.MinPartitionCount, threshold)
+		for j := range hotspots {
+			h := &hotspots[j]
+			h.Cluster = cluster
+			log.WithField("cluster", cluster).Debugf("hotspot detected: partition %d.%d of table %s on %s, %s=%f (mean=%f)",
+				h.AppID, h.PartitionIndex, h.TableName, h.Addr, h.Metric, h.Value, h.Mean)
+		}
+		c.hotspots[tb.AppID] = hotspots
+		c.tables = append(c.tables, tb.TableName)
+	}
+
+	d.lock.Lock()
+	// the gauges of the other clusters are kept
+	d.deleteGauges(cluster)
+	d.clusters[cluster] = c
+	for _, tb := range stats {
+		for _, metric := range cfg.Metrics {
+			d.counts.WithLabelValues(cluster, tb.TableName, metric).Set(0)
+		}
+		for _, h := range c.hotspots[tb.AppID] {
+			d.counts.WithLabelValues(cluster, h.TableName, h.Metric).Inc()
+			d.scores.WithLabelValues(cluster, h.TableName, strconv.Itoa(h.PartitionIndex), h.Metric).Set(h.Score)
+		}
+	}
+	d.lock.Unlock()
+
+	var all []Hotspot
+	for _, tb := range stats {
+		all = append(all, c.hotspots[tb.AppID]...)
+	}
+	afterDetected(cluster, all)
+}
+
+// deleteGauges deletes the gauges set by the latest round of the cluster. It's called with the
+// lock held.
+func (d *Detector) deleteGauges(cluster string) {
+	c, found := d.clusters[cluster]
+	if !found {
+		return
+	}
+	for _, table := range c.tables {
+		for _, metric := range c.metrics {
+			d.counts.DeleteLabelValues(cluster, table, metric)
+		}
+	}
+	for _, hotspots := range c.hotspots {
+		for _, h := range hotspots {
+			d.scores.DeleteLabelValues(cluster, h.TableName, strconv.Itoa(h.PartitionIndex), h.Metric)
+		}
+	}
+}
+
+// RemoveCluster removes the hotspots and the history of the cluster that is no longer
+// collected.
+func (d *Detector) RemoveCluster(cluster string) {
+	d.lock.Lock()
+	d.deleteGauges(cluster)
+	delete(d.clusters, cluster)
+	d.lock.Unlock()
+
+	d.algLock.Lock()
+	delete(d.algorithms, cluster)
+	d.algLock.Unlock()
+}
+
+// Hotspots returns the hotspots of the latest round of the cluster, of the given table if
+// `table` is not empty, sorted by the table name.
+func (d *Detector) Hotspots(cluster, table string) []Hotspot {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+
+	res := []Hotspot{}
+	c, found := d.clusters[cluster]
+	if !found {
+		return res
+	}
+	for _, hotspots := range c.hotspots {
+		for _, h := range hotspots {
+			if table == "" || h.TableName == table {
+				res = append(res, h)
+			}
+		}
+	}
+	sort.SliceStable(res, func(i, j int) bool {
+		return res[i].TableName < res[j].TableName
+	})
+	return res
+}
+
+// HookAfterDetected is a hook of event that the hotspots of a round of a cluster are detected.
+// Each call of the hook handles the hotspots of all tables of the cluster, which is empty if
+// there's none.
+type HookAfterDetected func(cluster string, hotspots []Hotspot)
+
+var (
+	hooksLock     sync.RWMutex
+	detectedHooks []HookAfterDetected
+)
+
+// AddHookAfterDetected adds a hook of event that the hotspots of a round are detected.
+func AddHookAfterDetected(hk HookAfterDetected) {
+	hooksLock.Lock()
+	defer hooksLock.Unlock()
+	detectedHooks = append(detectedHooks, hk)
+}
+
+func afterDetected(cluster string, hotspots []Hotspot) {
+	hooksLock.RLock()
+	defer hooksLock.RUnlock()
+	for _, hk := range detectedHooks {
+		hk(cluster, hotspots)
+	}
+}
+
+// SetThreshold changes the threshold of the table, or the default one if `table` is empty,
+// which takes effect from the next round.
+func (d *Detector) SetThreshold(table string, threshold float64) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	cfg := d.cfg
+	cfg.Tables = append([]TableConfig{}, d.cfg.Tables...)
+	if table == "" {
+		cfg.Threshold = threshold
+	} else {
+		found := false
+		for i := range cfg.Tables {
+			if cfg.Tables[i].Name == table {
+				cfg.Tables[i].Threshold = threshold
+				found = true
+			}
+		}
+		if !found {
+			cfg.Tables = append(cfg.Tables, TableConfig{Name: table, Threshold: threshold})
+		}
+	}
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	d.cfg = cfg
+	log.Infof("the hotspot threshold of %s is changed to %f", tableOrDefault(table), threshold)
+	return nil
+}
+
+// Threshold returns the method and the threshold of the table, or the default ones if `table`
+// is empty.
+func (d *Detector) Threshold(table string) (Method, float64) {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+	return d.cfg.methodOf(table)
+}
+
+// Forget removes the history of the dropped table of the cluster. It implements
+// aggregate.HookAfterClusterTableDropped.
+func (d *Detector) Forget(cluster string, appID int) {
+	d.algLock.Lock()
+	defer d.algLock.Unlock()
+	for _, alg := range d.algorithms[cluster] {
+		if f, ok := alg.(interface{ Forget(appID int) }); ok {
+			f.Forget(appID)
+		}
+	}
+}
+
+func tableOrDefault(table string) string {
+	if table == "" {
+		return "the default"
+	}
+	return "table " + table
+}
+
+var defaultDetector *Detector
+
+// Start creates the detector configured by the "hotspot" section, and watches the table stats.
+// It does nothing if the detection is disabled.
+func Start() error {
+	if !viper.GetBool("hotspot.enabled") {
+		return nil
+	}
+	viper.SetDefault("hotspot.metrics", []string{"read_qps", "write_qps", "read_bytes", "write_bytes"})
+	viper.SetDefault("hotspot.method", RatioToMean)
+	viper.SetDefault("hotspot.threshold", 3)
+	viper.SetDefault("hotspot.min_partition_count", 4)
+	viper.SetDefault("hotspot.topk", 3)
+	viper.SetDefault("hotspot.ewma_alpha", 0.3)
+	cfg := Config{
+		Metrics:           viper.GetStringSlice("hotspot.metrics"),
+		Method:            Method(viper.GetString("hotspot.method")),
+		Threshold:         viper.GetFloat64("hotspot.threshold"),
+		MinPartitionCount: viper.GetInt("hotspot.min_partition_count"),
+		TopK:              viper.GetInt("hotspot.topk"),
+		EWMAAlpha:         viper.GetFloat64("hotspot.ewma_alpha"),
+	}
+	if err := viper.UnmarshalKey("hotspot.tables", &cfg.Tables); err != nil {
+		return err
+	}
+	d, err := NewDetector(cfg, prometheus.DefaultRegisterer, viper.GetString("cluster_name"))
+	if err != nil {
+		return err
+	}
+	aggregate.AddHookAfterClusterStatsEmitted(d.Report)
+	aggregate.AddHookAfterClusterTableDropped(d.Forget)
+	aggregate.AddHookAfterClusterRemoved(d.RemoveCluster)
+	defaultDetector = d
+	return nil
+}
+
+// Hotspots returns the hotspots of the latest round of the cluster detected by the detector
+// created by Start, or nil if the detection is disabled.
+func Hotspots(cluster, table string) []Hotspot {
+	if defaultDetector == nil {
+		return nil
+	}
+	return defaultDetector.Hotspots(cluster, table)
+}
+
+// SetThreshold changes the threshold of the detector created by Start.
+func SetThreshold(table string, threshold float64) error {
+	if defaultDetector == nil {
+		return fmt.Errorf("hotspot detection is disabled")
+	}
+	return defaultDetector.SetThreshold(table, threshold)
+}
+
+// Threshold returns the method and the threshold of the table by the detector created by Start.
+func Threshold(table string) (Method, float64) {
+	if defaultDetector == nil {
+		return "", 0
+	}
+	return defaultDetector.Threshold(table)
+}
@@ -0,0 +1,83 @@
+package hotspot
+
+import (
+	"testing"
+
+	"github.com/pegasus-kv/collector/aggregate"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDetectorReport(t *testing.T) {
+	cfg := Config{Metrics: []string{"read_qps", "write_qps"}, Method: RatioToMean, Threshold: 3}
+	d, err := NewDetector(cfg, prometheus.NewRegistry(), "onebox")
+	assert.Nil(t, err)
+
+	hot := newTable(10, 10, 10, 10, 10, 10, 10, 130)
+	cold := newTable(10, 10)
+	cold.TableName = "temp"
+	cold.AppID = 2
+	d.Report([]aggregate.TableStats{*hot, *cold}, aggregate.ClusterStats{})
+
+	assert.Equal(t, len(d.Hotspots("onebox", "")), 1)
+	assert.Equal(t, len(d.Hotspots("onebox", "stat")), 1)
+	assert.Empty(t, d.Hotspots("onebox", "temp"))
+	assert.Equal(t, testutil.ToFloat64(d.counts.WithLabelValues("onebox", "stat", "read_qps")), float64(1))
+	assert.Equal(t, testutil.ToFloat64(d.counts.WithLabelValues("onebox", "temp", "read_qps")), float64(0))
+	assert.Equal(t, testutil.ToFloat64(d.scores.WithLabelValues("onebox", "stat", "7", "read_qps")), 5.2)
+
+	// the hotspot disappears in the next round
+	d.Report([]aggregate.TableStats{*cold}, aggregate.ClusterStats{})
+	assert.Empty(t, d.Hotspots("onebox", ""))
+	assert.Equal(t, testutil.CollectAndCount(d.scores), 0)
+}
+
+func TestDetectorSetThreshold(t *testing.T) {
+	cfg := Config{Metrics: []string{"read_qps"}, Method: RatioToMean, Threshold: 3}
+	d, err := NewDetector(cfg, prometheus.NewRegistry(), "onebox")
+	assert.Nil(t, err)
+
+	hot := newTable(10, 10, 10, 10, 10, 10, 10, 130)
+	d.Report([]aggregate.TableStats{*hot}, aggregate.ClusterStats{})
+	assert.Equal(t, len(d.Hotspots("onebox", "stat")), 1)
+
+	assert.Nil(t, d.SetThreshold("stat", 6))
+	d.Report([]aggregate.TableStats{*hot}, aggregate.ClusterStats{})
+	assert.Empty(t, d.Hotspots("onebox", "stat"))
+	method, threshold := d.Threshold("stat")
+	assert.Equal(t, method, RatioToMean)
+	assert.Equal(t, threshold, float64(6))
+
+	// the table keeps its own threshold
+	assert.Nil(t, d.SetThreshold("", 1))
+	_, threshold = d.Threshold("stat")
+	assert.Equal(t, threshold, float64(6))
+
+	assert.NotNil(t, d.SetThreshold("stat", -1))
+	_, threshold = d.Threshold("stat")
+	assert.Equal(t, threshold, float64(6))
+}
+
+func TestDetectorOfClusters(t *testing.T) {
+	cfg := Config{Metrics: []string{"read_qps"}, Method: RatioToMean, Threshold: 3}
+	d, err := NewDetector(cfg, prometheus.NewRegistry(), "onebox")
+	assert.Nil(t, err)
+
+	// the tables of different clusters may have the same app ID
+	hot := newTable(10, 10, 10, 10, 10, 10, 10, 130)
+	cold := newTable(10, 10)
+	d.Report([]aggregate.TableStats{*hot}, aggregate.ClusterStats{Cluster: "c1"})
+	d.Report([]aggregate.TableStats{*cold}, aggregate.ClusterStats{Cluster: "c2"})
+	assert.Equal(t, len(d.Hotspots("c1", "stat")), 1)
+	assert.Equal(t, d.Hotspots("c1", "stat")[0].Cluster, "c1")
+	assert.Empty(t, d.Hotspots("c2", "stat"))
+	// the gauges of c1 are kept by the round of c2
+	assert.Equal(t, testutil.ToFloat64(d.counts.WithLabelValues("c1", "stat", "read_qps")), float64(1))
+	assert.Equal(t, testutil.CollectAndCount(d.scores), 1)
+
+	d.RemoveCluster("c1")
+	assert.Empty(t, d.Hotspots("c1", ""))
+	assert.Equal(t, testutil.CollectAndCount(d.scores), 0)
+	assert.Equal(t, testutil.CollectAndCount(d.counts), 1)
+}
@@ -0,0 +1,215 @@
+package hotspot
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+
+	"github.com/pegasus-kv/collector/aggregate"
+)
+
+// HotspotDetector is an algorithm to flag the hot partitions of a table by a metric.
+type HotspotDetector interface {
+	// Detect returns the partitions of the distribution flagged by the threshold, whose meaning
+	// depends on the algorithm.
+	Detect(tb *aggregate.TableStats, dist Distribution, threshold float64) []Hotspot
+}
+
+// NewHotspotDetector returns the HotspotDetector of the method.
+func NewHotspotDetector(method Method, cfg Config) (HotspotDetector, error) {
+	switch method {
+	case ZScore:
+		return zscoreDetector{}, nil
+	case RatioToMean:
+		return ratioDetector{}, nil
+	case CoefficientOfVariation:
+		return cvDetector{}, nil
+	case EWMA:
+		return newEWMADetector(cfg.EWMAAlpha), nil
+	case TopK:
+		return topKDetector{k: cfg.TopK}, nil
+	default:
+		return nil, fmt.Errorf("invalid hotspot method \"%s\"", method)
+	}
+}
+
+// PartitionValue is the value of a metric on a partition.
+type PartitionValue struct {
+	PartitionIndex int
+	Addr           string
+	Value          float64
+}
+
+// Distribution is the values of a metric over the partitions of a table.
+type Distribution struct {
+	Metric string
+
+	// sorted by the partition index
+	Values []PartitionValue
+
+	Mean   float64
+	StdDev float64
+}
+
+// NewDistribution returns the distribution of the metric over the partitions reporting it.
+func NewDistribution(tb *aggregate.TableStats, metric string) Distribution {
+	dist := Distribution{Metric: metric}
+	for idx, part := range tb.Partitions {
+		if value, found := part.Stats[metric]; found {
+			dist.Values = append(dist.Values, PartitionValue{PartitionIndex: idx, Addr: part.Addr, Value: value})
+		}
+	}
+	sort.Slice(dist.Values, func(i, j int) bool {
+		return dist.Values[i].PartitionIndex < dist.Values[j].PartitionIndex
+	})
+	dist.summarize()
+	return dist
+}
+
+// summarize computes the mean and the population stddev of the values.
+func (dist *Distribution) summarize() {
+	dist.Mean, dist.StdDev = 0, 0
+	if len(dist.Values) == 0 {
+		return
+	}
+	for _, v := range dist.Values {
+		dist.Mean += v.Value
+	}
+	dist.Mean /= float64(len(dist.Values))
+	for _, v := range dist.Values {
+		dist.StdDev += (v.Value - dist.Mean) * (v.Value - dist.Mean)
+	}
+	dist.StdDev = math.Sqrt(dist.StdDev / float64(len(dist.Values)))
+}
+
+func (dist *Distribution) hotspot(tb *aggregate.TableStats, v PartitionValue, score float64) Hotspot {
+	return Hotspot{
+		TableName:      tb.TableName,
+		AppID:          tb.AppID,
+		PartitionIndex: v.PartitionIndex,
+		Addr:           v.Addr,
+		Metric:         dist.Metric,
+		Value:          v.Value,
+		Mean:           dist.Mean,
+		StdDev:         dist.StdDev,
+		Score:          score,
+	}
+}
+
+type ratioDetector struct{}
+
+func (ratioDetector) Detect(tb *aggregate.TableStats, dist Distribution, threshold float64) []Hotspot {
+	var res []Hotspot
+	for _, v := range dist.Values {
+		if score := v.Value / dist.Mean; score > threshold {
+			res = append(res, dist.hotspot(tb, v, score))
+		}
+	}
+	return res
+}
+
+type zscoreDetector struct{}
+
+func (zscoreDetector) Detect(tb *aggregate.TableStats, dist Distribution, threshold float64) []Hotspot {
+	if dist.StdDev == 0 {
+		return nil
+	}
+	var res []Hotspot
+	for _, v := range dist.Values {
+		if score := (v.Value - dist.Mean) / dist.StdDev; score > threshold {
+			res = append(res, dist.hotspot(tb, v, score))
+		}
+	}
+	return res
+}
+
+type cvDetector struct{}
+
+func (cvDetector) Detect(tb *aggregate.TableStats, dist Distribution, threshold float64) []Hotspot {
+	cv := dist.StdDev / dist.Mean
+	if cv <= threshold {
+		return nil
+	}
+	var res []Hotspot
+	for _, v := range dist.Values {
+		if v.Value > dist.Mean+dist.StdDev {
+			res = append(res, dist.hotspot(tb, v, cv))
+		}
+	}
+	return res
+}
+
+type topKDetector struct {
+	k int
+}
+
+func (d topKDetector) Detect(tb *aggregate.TableStats, dist Distribution, threshold float64) []Hotspot {
+	values := append([]PartitionValue{}, dist.Values...)
+	sort.SliceStable(values, func(i, j int) bool {
+		return values[i].Value > values[j].Value
+	})
+	var res []Hotspot
+	for _, v := range values {
+		if len(res) == d.k {
+			break
+		}
+		if score := v.Value / dist.Mean; score > threshold {
+			res = append(res, dist.hotspot(tb, v, score))
+		}
+	}
+	return res
+}
+
+// ewmaDetector keeps the smoothed values of the partitions over the rounds.
+type ewmaDetector struct {
+	alpha float64
+
+	lock sync.Mutex
+	// app ID -> metric -> partition index -> the smoothed value
+	smoothed map[int]map[string]map[int]float64
+}
+
+func newEWMADetector(alpha float64) *ewmaDetector {
+	return &ewmaDetector{alpha: alpha, smoothed: make(map[int]map[string]map[int]float64)}
+}
+
+func (d *ewmaDetector) Detect(tb *aggregate.TableStats, dist Distribution, threshold float64) []Hotspot {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if d.smoothed[tb.AppID] == nil {
+		d.smoothed[tb.AppID] = make(map[string]map[int]float64)
+	}
+	prev := d.smoothed[tb.AppID][dist.Metric]
+	curr := make(map[int]float64, len(dist.Values))
+	smoothedDist := Distribution{Metric: dist.Metric}
+	for _, v := range dist.Values {
+		s := v.Value
+		if p, found := prev[v.PartitionIndex]; found {
+			s = d.alpha*v.Value + (1-d.alpha)*p
+		}
+		curr[v.PartitionIndex] = s
+		smoothedDist.Values = append(smoothedDist.Values, PartitionValue{PartitionIndex: v.PartitionIndex, Addr: v.Addr, Value: s})
+	}
+	d.smoothed[tb.AppID][dist.Metric] = curr
+	smoothedDist.summarize()
+	if smoothedDist.Mean <= 0 {
+		return nil
+	}
+
+	// the hotspots report the latest values, along with the mean and stddev of the smoothed ones
+	var res []Hotspot
+	for i, v := range smoothedDist.Values {
+		if score := v.Value / smoothedDist.Mean; score > threshold {
+			res = append(res, smoothedDist.hotspot(tb, dist.Values[i], score))
+		}
+	}
+	return res
+}
+
+// Forget removes the history of the dropped table.
+func (d *ewmaDetector) Forget(appID int) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	delete(d.smoothed, appID)
+}
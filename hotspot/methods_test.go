@@ -0,0 +1,75 @@
+package hotspot
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func detectBy(d HotspotDetector, qps []float64, threshold float64) []Hotspot {
+	return detectWith(d, newTable(qps...), []string{"read_qps"}, 0, threshold)
+}
+
+func TestCoefficientOfVariation(t *testing.T) {
+	d, err := NewHotspotDetector(CoefficientOfVariation, Config{})
+	assert.Nil(t, err)
+
+	// mean=25, stddev=39.69
+	hotspots := detectBy(d, []float64{10, 10, 10, 10, 10, 10, 10, 130}, 1)
+	assert.Equal(t, len(hotspots), 1)
+	assert.Equal(t, hotspots[0].PartitionIndex, 7)
+	assert.InDelta(t, hotspots[0].Score, 1.5875, 1e-4)
+
+	assert.Empty(t, detectBy(d, []float64{10, 10, 10, 10, 10, 10, 10, 130}, 2))
+}
+
+func TestTopK(t *testing.T) {
+	d, err := NewHotspotDetector(TopK, Config{TopK: 2})
+	assert.Nil(t, err)
+
+	hotspots := detectBy(d, []float64{10, 50, 10, 40, 10, 60}, 1.5)
+	assert.Equal(t, len(hotspots), 2)
+	assert.Equal(t, hotspots[0].PartitionIndex, 1)
+	assert.Equal(t, hotspots[1].PartitionIndex, 5)
+
+	// only one partition exceeds the threshold
+	hotspots = detectBy(d, []float64{10, 50, 10, 40, 10, 60}, 1.8)
+	assert.Equal(t, len(hotspots), 1)
+	assert.Equal(t, hotspots[0].PartitionIndex, 5)
+}
+
+func TestEWMA(t *testing.T) {
+	d, err := NewHotspotDetector(EWMA, Config{EWMAAlpha: 0.5})
+	assert.Nil(t, err)
+
+	assert.Empty(t, detectBy(d, []float64{10, 10, 10, 10}, 2))
+	// the spike is smoothed: (10 + 90) / 2 = 50, mean = 20
+	hotspots := detectBy(d, []float64{10, 10, 10, 90}, 2)
+	assert.Equal(t, len(hotspots), 1)
+	assert.Equal(t, hotspots[0].Value, float64(90))
+	assert.Equal(t, hotspots[0].Score, 2.5)
+	// the spike disappears: (50 + 10) / 2 = 30, mean = 15
+	assert.Empty(t, detectBy(d, []float64{10, 10, 10, 10}, 2))
+
+	d.(*ewmaDetector).Forget(1)
+	assert.Empty(t, d.(*ewmaDetector).smoothed)
+}
+
+func TestConfigTables(t *testing.T) {
+	cfg := Config{
+		Method:    RatioToMean,
+		Threshold: 3,
+		Tables:    []TableConfig{{Name: "stat", Method: ZScore}, {Name: "temp", Threshold: 5}},
+	}
+	assert.Nil(t, cfg.Validate())
+	method, threshold := cfg.methodOf("stat")
+	assert.Equal(t, method, ZScore)
+	assert.Equal(t, threshold, float64(3))
+	method, threshold = cfg.methodOf("temp")
+	assert.Equal(t, method, RatioToMean)
+	assert.Equal(t, threshold, float64(5))
+	assert.Equal(t, cfg.methods(), []Method{RatioToMean, ZScore})
+
+	cfg.Tables = append(cfg.Tables, TableConfig{Name: "test", Method: TopK})
+	assert.NotNil(t, cfg.Validate())
+}
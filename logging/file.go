@@ -0,0 +1,125 @@
+package logging
+
+import (
+	"fmt"
+	"time"
+
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+// FileConfig is the config of the log file, which is rotated once it's larger than MaxSize, or
+// once it's been written for RotateInterval. The rotated files are retained by MaxAge and
+// MaxBackups.
+type FileConfig struct {
+	// the path of the log file, where the rotated files are renamed with the time in the same
+	// directory, e.g. pegasus-2020-11-23T10-22-38.000.log
+	Path string
+	// the file is rotated once it's larger than MaxSize megabytes
+	MaxSize int `mapstructure:"max_size"`
+	// the file is rotated once it's been written for the interval, e.g. 24h, never if it's 0
+	RotateInterval time.Duration `mapstructure:"rotate_interval"`
+	// the rotated files older than MaxAge days are removed, never if it's 0
+	MaxAge int `mapstructure:"max_age"`
+	// at most MaxBackups rotated files are retained, all if it's 0
+	MaxBackups int `mapstructure:"max_backups"`
+	// the rotated files are compressed by gzip
+	Compress bool
+}
+
+// DefaultFileConfig is the config of the log file if it's not configured.
+var DefaultFileConfig = FileConfig{
+	Path:    "./pegasus.log",
+	MaxSize: 50,
+	MaxAge:  2,
+}
+
+// Validate checks the path and the limits.
+func (cfg *FileConfig) Validate() error {
+	if cfg.Path == "" {
+		return fmt.Errorf("path of the log file is empty")
+	}
+	if cfg.MaxSize <= 0 {
+		return fmt.Errorf("max_size of the log file should be positive: %d", cfg.MaxSize)
+	}
+	if cfg.RotateInterval < 0 {
+		return fmt.Errorf("rotate_interval of the log file should not be negative: %s", cfg.RotateInterval)
+	}
+	if cfg.RotateInterval > 0 && cfg.RotateInterval < time.Minute {
+		return fmt.Errorf("rotate_interval of the log file should be at least 1m: %s", cfg.RotateInterval)
+	}
+	if cfg.MaxAge < 0 || cfg.MaxBackups < 0 {
+		return fmt.Errorf("max_age and max_backups of the log file should not be negative")
+	}
+	return nil
+}
+
+// rotatingFile is the log file written by lumberjack, rotated by a goroutine every interval.
+type rotatingFile struct {
+	cfg    FileConfig
+	logger *lumberjack.Logger
+	stop   chan struct{}
+}
+
+func openFile(cfg FileConfig) *rotatingFile {
+	f := &rotatingFile{
+		cfg: cfg,
+		logger: &lumberjack.Logger{
+			Filename:   cfg.Path,
+			MaxSize:    cfg.MaxSize,
+			MaxAge:     cfg.MaxAge,
+			MaxBackups: cfg.MaxBackups,
+			Compress:   cfg.Compress,
+			LocalTime:  true,
+		},
+		stop: make(chan struct{}),
+	}
+	if cfg.RotateInterval > 0 {
+		go f.rotateEvery(cfg.RotateInterval)
+	}
+	return f
+}
+
+func (f *rotatingFile) rotateEvery(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := f.logger.Rotate(); err != nil {
+				log.Errorf("failed to rotate the log file %s: %s", f.cfg.Path, err)
+			}
+		case <-f.stop:
+			return
+		}
+	}
+}
+
+func (f *rotatingFile) close() {
+	close(f.stop)
+	_ = f.logger.Close()
+}
+
+// file is the log file opened by SetFile, nil if the logs are not written to a file.
+var file *rotatingFile
+
+// SetFile writes the logs of all modules to the file rotated as configured. The file is reopened
+// only if the config is changed.
+func SetFile(cfg FileConfig) error {
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+	if file != nil && file.cfg == cfg {
+		return nil
+	}
+	old := file
+	file = openFile(cfg)
+	output = file.logger
+	update()
+	if old != nil {
+		old.close()
+	}
+	return nil
+}
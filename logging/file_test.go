@@ -0,0 +1,62 @@
+package logging
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSetFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logging")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	defer SetOutput(os.Stderr)
+
+	cfg := DefaultFileConfig
+	cfg.Path = filepath.Join(dir, "collector.log")
+	assert.Nil(t, SetFile(cfg))
+	opened := file
+	Module("test_aggregate").Warn("written to the file")
+	content, err := ioutil.ReadFile(cfg.Path)
+	assert.Nil(t, err)
+	assert.Contains(t, string(content), "written to the file")
+
+	// the file is reopened only if the config is changed
+	assert.Nil(t, SetFile(cfg))
+	assert.True(t, file == opened)
+	cfg.MaxBackups = 3
+	assert.Nil(t, SetFile(cfg))
+	assert.False(t, file == opened)
+
+	// the rotated file is retained
+	assert.Nil(t, file.logger.Rotate())
+	Module("test_aggregate").Warn("written to the new file")
+	files, err := ioutil.ReadDir(dir)
+	assert.Nil(t, err)
+	assert.Equal(t, len(files), 2)
+	content, err = ioutil.ReadFile(cfg.Path)
+	assert.Nil(t, err)
+	assert.NotContains(t, string(content), "written to the file")
+	assert.Contains(t, string(content), "written to the new file")
+}
+
+func TestInvalidFileConfig(t *testing.T) {
+	valid := DefaultFileConfig
+	assert.Nil(t, valid.Validate())
+	for _, update := range []func(cfg *FileConfig){
+		func(cfg *FileConfig) { cfg.Path = "" },
+		func(cfg *FileConfig) { cfg.MaxSize = 0 },
+		func(cfg *FileConfig) { cfg.RotateInterval = -1 },
+		func(cfg *FileConfig) { cfg.RotateInterval = 1 },
+		func(cfg *FileConfig) { cfg.MaxAge = -1 },
+		func(cfg *FileConfig) { cfg.MaxBackups = -1 },
+	} {
+		cfg := DefaultFileConfig
+		update(&cfg)
+		assert.NotNil(t, cfg.Validate())
+		assert.NotNil(t, SetFile(cfg))
+	}
+}
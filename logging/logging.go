@@ -0,0 +1,235 @@
+// Package logging is the structured logging of the collector on logrus. Every module, i.e. a
+// package, logs through its own logger, whose entries are tagged with the "module" field and
+// filtered by the level of the module, which is configurable at runtime. The logs are in text,
+// or in JSON for the log pipelines.
+package logging
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// Config is the config of the logging.
+type Config struct {
+	// "text" or "json", "text" is used if it's empty
+	Format string
+	// the level of the modules not in Modules, e.g. "info", which is used if it's empty
+	Level string
+	// the module -> its level, e.g. {aggregate: debug}
+	Modules map[string]string
+	// the log file, DefaultFileConfig if it's absent
+	File FileConfig
+}
+
+// ConfigFromConfig parses the config of "log", where the absent fields of "log.file" are those
+// of DefaultFileConfig.
+func ConfigFromConfig() (Config, error) {
+	cfg := Config{File: DefaultFileConfig}
+	if err := viper.UnmarshalKey("log", &cfg); err != nil {
+		return cfg, err
+	}
+	return cfg, nil
+}
+
+var (
+	lock sync.Mutex
+	// the module -> its logger
+	loggers = make(map[string]*logrus.Logger)
+
+	output       io.Writer = os.Stderr
+	formatter              = newFormatter("text")
+	defaultLevel           = logrus.InfoLevel
+	// the module -> its level, overriding defaultLevel
+	moduleLevels = make(map[string]logrus.Level)
+)
+
+var log = Module("logging")
+
+// callerPrettifier simplifies the caller info
+func callerPrettifier(f *runtime.Frame) (function string, file string) {
+	function = f.Function[strings.LastIndex(f.Function, "/")+1:]
+	file = fmt.Sprint(f.File[strings.LastIndex(f.File, "/")+1:], ":", f.Line)
+	return function, file
+}
+
+func newFormatter(format string) logrus.Formatter {
+	if format == "json" {
+		return &logrus.JSONFormatter{CallerPrettyfier: callerPrettifier}
+	}
+	return &logrus.TextFormatter{
+		DisableColors:    true,
+		FullTimestamp:    true,
+		CallerPrettyfier: callerPrettifier,
+	}
+}
+
+// Module returns the logger of the module, whose entries are tagged with the "module" field,
+// e.g. `var log = logging.Module("aggregate")` of package aggregate. The entries with more
+// fields are derived by WithField, e.g. `log.WithField("cluster", "onebox")`.
+func Module(name string) *logrus.Entry {
+	lock.Lock()
+	defer lock.Unlock()
+	logger, found := loggers[name]
+	if !found {
+		logger = logrus.New()
+		logger.SetOutput(output)
+		logger.SetFormatter(formatter)
+		logger.SetReportCaller(true)
+		logger.SetLevel(levelOf(name))
+		loggers[name] = logger
+	}
+	return logger.WithField("module", name)
+}
+
+func levelOf(module string) logrus.Level {
+	if level, found := moduleLevels[module]; found {
+		return level
+	}
+	return defaultLevel
+}
+
+// update applies the output, the formatter and the levels to the loggers of all modules, as
+// well as the standard logger used by the libraries.
+func update() {
+	for name, logger := range loggers {
+		logger.SetOutput(output)
+		logger.SetFormatter(formatter)
+		logger.SetLevel(levelOf(name))
+	}
+	logrus.SetOutput(output)
+	logrus.SetFormatter(formatter)
+	logrus.SetReportCaller(true)
+	logrus.SetLevel(defaultLevel)
+}
+
+// SetOutput sets the destination of the logs of all modules, closing the file set by SetFile.
+func SetOutput(w io.Writer) {
+	lock.Lock()
+	defer lock.Unlock()
+	if file != nil {
+		file.close()
+		file = nil
+	}
+	output = w
+	update()
+}
+
+// SetLevel sets the level of all modules, clearing the levels of the modules.
+func SetLevel(level logrus.Level) {
+	lock.Lock()
+	defer lock.Unlock()
+	defaultLevel = level
+	moduleLevels = make(map[string]logrus.Level)
+	update()
+}
+
+// SetModuleLevel sets the level of the module, e.g. "debug", or resets it to the level of all
+// modules if the level is empty.
+func SetModuleLevel(module string, level string) error {
+	lock.Lock()
+	defer lock.Unlock()
+	if level == "" {
+		delete(moduleLevels, module)
+	} else {
+		lvl, err := logrus.ParseLevel(level)
+		if err != nil {
+			return err
+		}
+		moduleLevels[module] = lvl
+	}
+	update()
+	return nil
+}
+
+// Levels returns the level of every module created, as well as "default" of the others.
+func Levels() map[string]string {
+	lock.Lock()
+	defer lock.Unlock()
+	levels := map[string]string{"default": defaultLevel.String()}
+	for name := range loggers {
+		levels[name] = levelOf(name).String()
+	}
+	return levels
+}
+
+// Modules returns the names of the modules created, sorted.
+func Modules() []string {
+	lock.Lock()
+	defer lock.Unlock()
+	names := make([]string, 0, len(loggers))
+	for name := range loggers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Validate checks the format and the levels.
+func (cfg *Config) Validate() error {
+	_, _, err := cfg.levels()
+	return err
+}
+
+// levels parses the level of all modules and those of the modules.
+func (cfg *Config) levels() (logrus.Level, map[string]logrus.Level, error) {
+	if cfg.Format != "" && cfg.Format != "text" && cfg.Format != "json" {
+		return 0, nil, fmt.Errorf("invalid log format %q, which should be text or json", cfg.Format)
+	}
+	level := logrus.InfoLevel
+	if cfg.Level != "" {
+		var err error
+		if level, err = logrus.ParseLevel(cfg.Level); err != nil {
+			return 0, nil, err
+		}
+	}
+	levels := make(map[string]logrus.Level)
+	for module, l := range cfg.Modules {
+		lvl, err := logrus.ParseLevel(l)
+		if err != nil {
+			return 0, nil, fmt.Errorf("invalid level of module %s: %s", module, err)
+		}
+		levels[module] = lvl
+	}
+	return level, levels, nil
+}
+
+// Apply validates the config, and applies it to all modules. Nothing is changed if the config
+// is invalid.
+func Apply(cfg Config) error {
+	level, levels, err := cfg.levels()
+	if err != nil {
+		return err
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+	formatter = newFormatter(cfg.Format)
+	defaultLevel = level
+	moduleLevels = levels
+	update()
+	return nil
+}
+
+// Reload applies the config of "log" and writes the logs to the log file, which is called once
+// the config is changed.
+func Reload() error {
+	cfg, err := ConfigFromConfig()
+	if err != nil {
+		return err
+	}
+	if err := cfg.File.Validate(); err != nil {
+		return err
+	}
+	if err := Apply(cfg); err != nil {
+		return err
+	}
+	return SetFile(cfg.File)
+}
@@ -0,0 +1,80 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestModuleLevels(t *testing.T) {
+	var buf bytes.Buffer
+	SetOutput(&buf)
+	defer SetOutput(os.Stderr)
+	defer SetLevel(logrus.InfoLevel)
+
+	aggregate := Module("test_aggregate")
+	metrics := Module("test_metrics")
+	assert.Nil(t, Apply(Config{Level: "warning", Modules: map[string]string{"test_aggregate": "debug"}}))
+	aggregate.Debug("aggregate debug")
+	metrics.Info("metrics info")
+	metrics.Warn("metrics warning")
+	assert.Contains(t, buf.String(), "aggregate debug")
+	assert.NotContains(t, buf.String(), "metrics info")
+	assert.Contains(t, buf.String(), "metrics warning")
+	assert.Contains(t, buf.String(), "module=test_aggregate")
+	assert.Equal(t, Levels()["test_aggregate"], "debug")
+	assert.Equal(t, Levels()["test_metrics"], "warning")
+	assert.Equal(t, Levels()["default"], "warning")
+
+	// the level is changed at runtime
+	buf.Reset()
+	assert.Nil(t, SetModuleLevel("test_metrics", "info"))
+	assert.Nil(t, SetModuleLevel("test_aggregate", ""))
+	aggregate.Debug("aggregate debug")
+	metrics.Info("metrics info")
+	assert.NotContains(t, buf.String(), "aggregate debug")
+	assert.Contains(t, buf.String(), "metrics info")
+	assert.NotNil(t, SetModuleLevel("test_metrics", "verbose"))
+
+	// the modules created later take the levels as well
+	assert.Nil(t, SetModuleLevel("test_alert", "error"))
+	assert.Equal(t, Module("test_alert").Logger.GetLevel(), logrus.ErrorLevel)
+	assert.Contains(t, Modules(), "test_alert")
+}
+
+func TestJSONFormat(t *testing.T) {
+	var buf bytes.Buffer
+	SetOutput(&buf)
+	defer SetOutput(os.Stderr)
+	defer Apply(Config{})
+
+	assert.Nil(t, Apply(Config{Format: "json"}))
+	Module("test_aggregate").WithField("cluster", "onebox").WithField("node", "127.0.0.1:34801").Warn("node is flapping")
+	var fields map[string]interface{}
+	assert.Nil(t, json.Unmarshal(buf.Bytes(), &fields))
+	assert.Equal(t, fields["module"], "test_aggregate")
+	assert.Equal(t, fields["cluster"], "onebox")
+	assert.Equal(t, fields["node"], "127.0.0.1:34801")
+	assert.Equal(t, fields["msg"], "node is flapping")
+	assert.Equal(t, fields["level"], "warning")
+	assert.True(t, strings.HasPrefix(fields["file"].(string), "logging_test.go:"))
+}
+
+func TestInvalidConfig(t *testing.T) {
+	defer Apply(Config{})
+	assert.Nil(t, Apply(Config{Level: "debug"}))
+	for _, cfg := range []Config{
+		{Format: "xml"},
+		{Level: "verbose"},
+		{Modules: map[string]string{"aggregate": "verbose"}},
+	} {
+		assert.NotNil(t, Apply(cfg))
+	}
+	// the invalid config is not applied
+	assert.Equal(t, Levels()["default"], "debug")
+}
@@ -2,34 +2,40 @@ package main
 
 import (
 	"errors"
-	"fmt"
 	"os"
 	"os/signal"
-	"runtime"
-	"strings"
 	"syscall"
+	"time"
 
+	"github.com/pegasus-kv/collector/admin"
 	"github.com/pegasus-kv/collector/aggregate"
+	"github.com/pegasus-kv/collector/alert"
+	"github.com/pegasus-kv/collector/anomaly"
+	"github.com/pegasus-kv/collector/avail"
+	"github.com/pegasus-kv/collector/disk"
+	"github.com/pegasus-kv/collector/election"
+	"github.com/pegasus-kv/collector/events"
+	"github.com/pegasus-kv/collector/grpc"
+	"github.com/pegasus-kv/collector/health"
+	"github.com/pegasus-kv/collector/hotkey"
+	"github.com/pegasus-kv/collector/hotspot"
+	"github.com/pegasus-kv/collector/logging"
+	"github.com/pegasus-kv/collector/metrics"
+	"github.com/pegasus-kv/collector/reload"
+	"github.com/pegasus-kv/collector/shard"
+	"github.com/pegasus-kv/collector/store"
 	"github.com/pegasus-kv/collector/usage"
 	"github.com/pegasus-kv/collector/webui"
-	log "github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
-	"gopkg.in/natefinch/lumberjack.v2"
 	"gopkg.in/tomb.v2"
 )
 
-// callerPrettifier simplifies the caller info
-func callerPrettifier(f *runtime.Frame) (function string, file string) {
-	function = f.Function[strings.LastIndex(f.Function, "/")+1:]
-	file = fmt.Sprint(f.File[strings.LastIndex(f.File, "/")+1:], ":", f.Line)
-	return function, file
-}
+var log = logging.Module("main")
 
-// setupSignalHandler setup signal handler for collector
+// setupSignalHandler setup signal handler for collector, while SIGHUP reloads the config
 func setupSignalHandler(shutdownFunc func()) {
 	closeSignalChan := make(chan os.Signal, 1)
 	signal.Notify(closeSignalChan,
-		syscall.SIGHUP,
 		syscall.SIGINT,
 		syscall.SIGTERM,
 		syscall.SIGQUIT)
@@ -38,23 +44,44 @@ func setupSignalHandler(shutdownFunc func()) {
 		sig := <-closeSignalChan
 		log.Infof("got signal %s to exit", sig.String())
 		shutdownFunc()
+		// exit immediately on the second signal, e.g. the shutdown gets stuck
+		sig = <-closeSignalChan
+		log.Warnf("got signal %s again, exit immediately", sig.String())
+		os.Exit(1)
 	}()
 }
 
+// waitShutdown waits until all goroutines of the tomb terminate and the sinks are flushed,
+// but no longer than "shutdown_timeout".
+func waitShutdown(tom *tomb.Tomb) {
+	done := make(chan struct{})
+	go func() {
+		<-tom.Dead() // the in-flight collections are cancelled, and the sessions are closed
+		metrics.Close()
+		close(done)
+	}()
+	viper.SetDefault("shutdown_timeout", 30*time.Second)
+	timeout := viper.GetDuration("shutdown_timeout")
+	select {
+	case <-done:
+		log.Info("collector exits gracefully")
+	case <-time.After(timeout):
+		log.Warnf("collector exits before the shutdown completes in %s", timeout)
+	}
+}
+
 func main() {
-	// initialize logging
-	log.SetFormatter(&log.TextFormatter{
-		DisableColors:    true,
-		FullTimestamp:    true,
-		CallerPrettyfier: callerPrettifier,
-	})
-	log.SetOutput(&lumberjack.Logger{ // rolling log
-		Filename:  "./pegasus.log",
-		MaxSize:   50, // MegaBytes
-		MaxAge:    2,  // days
-		LocalTime: true,
-	})
-	log.SetReportCaller(true)
+	if len(os.Args) > 1 {
+		if cmd, found := subcommands[os.Args[1]]; found {
+			os.Exit(cmd(os.Args[2:]))
+		}
+	}
+
+	// initialize logging, the log file is reopened as "log.file" once the config is read
+	if err := logging.SetFile(logging.DefaultFileConfig); err != nil {
+		log.Fatal("failed to open the log file: ", err)
+		return
+	}
 
 	// TODO(wutao1): use args[1] as config path
 	viper.SetConfigFile("config.yml")
@@ -63,13 +90,61 @@ func main() {
 		log.Fatal("failed to read config: ", err)
 		return
 	}
+	if err := logging.Reload(); err != nil {
+		log.Fatal("failed to read the log config: ", err)
+		return
+	}
+	reload.AddHookAfterChanged([]string{"log"}, logging.Reload)
+
+	derived, err := aggregate.DerivedMetricsFromConfig()
+	if err != nil {
+		log.Fatal("failed to read derived metrics: ", err)
+		return
+	}
+	aggregate.SetDerivedMetrics(derived)
+	reload.AddHookAfterChanged([]string{"metrics.derived_metrics"}, func() error {
+		derived, err := aggregate.DerivedMetricsFromConfig()
+		if err != nil {
+			return err
+		}
+		aggregate.SetDerivedMetrics(derived)
+		return nil
+	})
 
-	webui.StartWebServer()
+	health.Start()
+
+	if err := hotspot.Start(); err != nil {
+		log.Fatal("failed to start the hotspot detection: ", err)
+		return
+	}
 
 	tom := &tomb.Tomb{}
+	if err := hotkey.Start(tom.Context(nil)); err != nil {
+		log.Fatal("failed to start the hotkey detection: ", err)
+		return
+	}
+
+	webui.StartWebServer(tom)
+
 	setupSignalHandler(func() {
 		tom.Kill(errors.New("collector terminates")) // kill other goroutines
 	})
+	tom.Go(func() error {
+		reload.Start(tom)
+		return nil
+	})
+	tom.Go(func() error {
+		election.Start(tom)
+		return nil
+	})
+	tom.Go(func() error {
+		shard.Start(tom)
+		return nil
+	})
+	tom.Go(func() error {
+		metrics.Start(tom)
+		return nil
+	})
 	tom.Go(func() error {
 		aggregate.Start(tom)
 		return nil
@@ -78,9 +153,46 @@ func main() {
 		usage.NewTableUsageRecorder().Start(tom)
 		return nil
 	})
-	select {
-	case <-tom.Dying():
-		<-tom.Dead() // gracefully wait until all goroutines dead
-		return
-	}
+	tom.Go(func() error {
+		usage.StartStatRecorder(tom)
+		return nil
+	})
+	tom.Go(func() error {
+		usage.StartCUAccounting(tom)
+		return nil
+	})
+	tom.Go(func() error {
+		admin.Start(tom)
+		return nil
+	})
+	tom.Go(func() error {
+		grpc.Start(tom)
+		return nil
+	})
+	tom.Go(func() error {
+		store.Start(tom)
+		return nil
+	})
+	tom.Go(func() error {
+		avail.Start(tom)
+		return nil
+	})
+	tom.Go(func() error {
+		disk.Start(tom)
+		return nil
+	})
+	tom.Go(func() error {
+		events.Start(tom)
+		return nil
+	})
+	tom.Go(func() error {
+		alert.Start(tom)
+		return nil
+	})
+	tom.Go(func() error {
+		anomaly.Start(tom)
+		return nil
+	})
+	<-tom.Dying()
+	waitShutdown(tom)
 }
@@ -0,0 +1,46 @@
+package metrics
+
+import (
+	"encoding/csv"
+	"io"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/pegasus-kv/collector/aggregate"
+)
+
+// csvSink writes the table metrics as rows of "timestamp,table,metric,value",
+// where the timestamp is in unix milliseconds.
+type csvSink struct {
+	lock sync.Mutex
+	w    *csv.Writer
+}
+
+// NewCSVSink returns a Sink writing the table metrics to w in CSV format.
+func NewCSVSink(w io.Writer) Sink {
+	return &csvSink{w: csv.NewWriter(w)}
+}
+
+func (s *csvSink) Report(stats []aggregate.TableStats, _ aggregate.ClusterStats) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for _, tb := range stats {
+		ts := strconv.FormatInt(tb.Timestamp.UnixNano()/1e6, 10)
+		var names []string
+		for name := range tb.Stats {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			value := strconv.FormatFloat(tb.Stats[name], 'f', -1, 64)
+			_ = s.w.Write([]string{ts, tb.TableName, name, value})
+		}
+	}
+	s.w.Flush()
+	if err := s.w.Error(); err != nil {
+		log.Errorf("failed to write metrics in csv: %s", err)
+		sinkFailed("csv")
+	}
+}
@@ -3,123 +3,231 @@ package metrics
 import (
 	"bytes"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"net/http"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/pegasus-kv/collector/aggregate"
-	log "github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
 )
 
 type falconConfig struct {
-	falconAgentHost     string
-	falconAgentPort     uint32
-	falconAgentHTTPPath string
+	url string
 
-	clusterName           string
-	port                  uint32
-	metricsReportInterval time.Duration
+	// The endpoint of all metrics, where "{cluster}" is replaced with the cluster name.
+	endpoint    string
+	clusterName string
+
+	// The tags attached to all metrics, besides "entity" and "table".
+	tags map[string]string
+
+	pushInterval  time.Duration
+	batchSize     int
+	retryTimes    int
+	retryInterval time.Duration
+	timeout       time.Duration
 }
 
+// falconSink pushes the latest stats to the falcon agent (or transfer) every push interval.
+// The metrics are posted in batches, and a failed batch is retried for a few times before
+// it's dropped.
 type falconSink struct {
-	cfg *falconConfig
+	cfg    *falconConfig
+	client *http.Client
+
+	lock sync.Mutex
+	// cluster -> the metrics of the last report that hasn't been pushed yet
+	pending map[string][]*falconMetricData
+
+	// closed by Close to stop the pushes started by start, which closes `done` once stopped
+	stop chan struct{}
+	done chan struct{}
 }
 
 type falconMetricData struct {
-	endpoint    string  // the cluster name
-	metric      string  // metric name
-	timestamp   int64   // the reporting time in unix seconds
-	step        int32   // the reporting time interval in seconds
-	value       float64 // metric value
-	counterType string  // GAUGE or COUNTER
-	tags        string
+	Endpoint    string  `json:"endpoint"`
+	Metric      string  `json:"metric"`
+	Timestamp   int64   `json:"timestamp"` // the reporting time in unix seconds
+	Step        int32   `json:"step"`      // the reporting time interval in seconds
+	Value       float64 `json:"value"`
+	CounterType string  `json:"counterType"` // GAUGE or COUNTER
+	Tags        string  `json:"tags"`
 }
 
 func newFalconSink() *falconSink {
-	sink := &falconSink{}
-	sink.cfg = &falconConfig{
-		falconAgentHost:       viper.GetString("falcon_agent.host"),
-		falconAgentPort:       viper.GetUint32("falcon_agent.port"),
-		falconAgentHTTPPath:   viper.GetString("falcon_agent.http_path"),
-		clusterName:           viper.GetString("cluster_name"),
-		port:                  viper.GetUint32("port"),
-		metricsReportInterval: viper.GetDuration("metrics.report_interval"),
+	// the metrics are pushed as often as they're aggregated by default
+	pushInterval := viper.GetDuration("falcon_agent.push_interval")
+	if pushInterval == 0 {
+		pushInterval = viper.GetDuration("metrics.report_interval")
+	}
+	cfg := &falconConfig{
+		url: fmt.Sprintf("http://%s:%d/%s",
+			viper.GetString("falcon_agent.host"),
+			viper.GetUint32("falcon_agent.port"),
+			strings.TrimPrefix(viper.GetString("falcon_agent.http_path"), "/")),
+		endpoint:      viper.GetString("falcon_agent.endpoint"),
+		clusterName:   viper.GetString("cluster_name"),
+		tags:          viper.GetStringMapString("falcon_agent.tags"),
+		pushInterval:  pushInterval,
+		batchSize:     viper.GetInt("falcon_agent.batch_size"),
+		retryTimes:    viper.GetInt("falcon_agent.retry_times"),
+		retryInterval: viper.GetDuration("falcon_agent.retry_interval"),
+		timeout:       viper.GetDuration("falcon_agent.timeout"),
 	}
+	sink := newFalconSinkWithConfig(cfg)
+	sink.start()
 	return sink
 }
 
-func (m *falconMetricData) setData(name string, value float64, tags map[string]string) {
-	m.metric = name
-	m.value = value
-	m.timestamp = time.Now().Unix()
-
-	firstTag := true
-	for k, v := range tags {
-		if firstTag {
-			firstTag = false
-		} else {
-			m.tags += ","
-		}
-		m.tags += k + "=" + v
+func newFalconSinkWithConfig(cfg *falconConfig) *falconSink {
+	if cfg.pushInterval <= 0 {
+		cfg.pushInterval = 10 * time.Second
 	}
-}
-
-func (m *falconMetricData) toJSON() []byte {
-	result, err := json.Marshal(m)
-	if err != nil {
-		log.Fatal("failed to marshall falcon metric to json: ", err)
+	if cfg.batchSize <= 0 {
+		cfg.batchSize = 1
+	}
+	return &falconSink{
+		cfg:     cfg,
+		client:  &http.Client{Timeout: cfg.timeout},
+		pending: make(map[string][]*falconMetricData),
 	}
-	return result
 }
 
-type falconDataSerializer struct {
-	buf *bytes.Buffer
+// start pushes the pending metrics every push interval until Close.
+func (sink *falconSink) start() {
+	sink.stop = make(chan struct{})
+	sink.done = make(chan struct{})
+	go func() {
+		defer close(sink.done)
+		ticker := time.NewTicker(sink.cfg.pushInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-sink.stop:
+				return
+			case <-ticker.C:
+			}
+			sink.push()
+		}
+	}()
+}
 
-	mdata *falconMetricData
+// Close stops the pushes every push interval, and pushes the pending metrics once more, so that
+// the last report is delivered.
+func (sink *falconSink) Close() error {
+	if sink.stop != nil {
+		close(sink.stop)
+		<-sink.done
+	}
+	sink.push()
+	return nil
 }
 
-func (s *falconDataSerializer) serialize(stats []aggregate.TableStats, allStats aggregate.ClusterStats) {
-	s.buf.WriteString("[")
+// Report replaces the stats of the cluster to push. Only the latest stats are pushed if the
+// stats are reported more frequently than the push interval.
+func (sink *falconSink) Report(stats []aggregate.TableStats, allStats aggregate.ClusterStats) {
+	cluster := clusterOf(allStats.Cluster, sink.cfg.clusterName)
+	endpoint := strings.ReplaceAll(sink.cfg.endpoint, "{cluster}", cluster)
+	var data []*falconMetricData
 	for _, table := range stats {
+		tags := sink.formatTags(map[string]string{
+			"entity": "table",
+			"table":  table.TableName,
+		})
 		for name, value := range table.Stats {
-			s.mdata.setData(name, value, map[string]string{
-				"entity": "table",
-				"table":  name,
-			})
+			data = append(data, sink.newMetricData(endpoint, name, value, table.Timestamp, tags))
 		}
-		s.buf.Write(s.mdata.toJSON())
 	}
+	tags := sink.formatTags(map[string]string{"entity": "cluster"})
 	for name, value := range allStats.Stats {
-		s.mdata.setData(name, value, map[string]string{
-			"entity": "cluster",
-		})
-		s.buf.Write(s.mdata.toJSON())
+		data = append(data, sink.newMetricData(endpoint, name, value, allStats.Timestamp, tags))
 	}
+
+	sink.lock.Lock()
+	sink.pending[cluster] = data
+	sink.lock.Unlock()
 }
 
-func (sink *falconSink) Report(stats []aggregate.TableStats, allStats aggregate.ClusterStats) {
-	serializer := &falconDataSerializer{
-		buf: bytes.NewBuffer(nil),
+func (sink *falconSink) newMetricData(endpoint string, name string, value float64, ts time.Time, tags string) *falconMetricData {
+	if ts.IsZero() {
+		ts = time.Now()
 	}
-	serializer.mdata = &falconMetricData{
-		endpoint:    sink.cfg.clusterName,
-		step:        int32(sink.cfg.metricsReportInterval.Seconds()),
-		counterType: "GAUGE",
+	return &falconMetricData{
+		Endpoint:    endpoint,
+		Metric:      name,
+		Timestamp:   ts.Unix(),
+		Step:        int32(sink.cfg.pushInterval.Seconds()),
+		Value:       value,
+		CounterType: "GAUGE",
+		Tags:        tags,
 	}
-	serializer.serialize(stats, allStats)
-	sink.postHTTPData(serializer.buf.Bytes())
 }
 
-func (sink *falconSink) postHTTPData(data []byte) {
-	url := fmt.Sprintf("http://%s:%d/%s", sink.cfg.falconAgentHost, sink.cfg.falconAgentPort, sink.cfg.falconAgentHTTPPath)
-	resp, err := http.Post(url, "application/x-www-form-urlencoded", bytes.NewReader([]byte(data)))
-	if err == nil && resp.StatusCode != http.StatusOK {
-		err = errors.New(resp.Status)
+// formatTags merges the configured tags with `tags`, formatted as "k1=v1,k2=v2" sorted by key.
+func (sink *falconSink) formatTags(tags map[string]string) string {
+	merged := make(map[string]string)
+	for k, v := range sink.cfg.tags {
+		merged[k] = v
 	}
+	for k, v := range tags {
+		merged[k] = v
+	}
+	var pairs []string
+	for k, v := range merged {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+// push posts the pending metrics in batches.
+func (sink *falconSink) push() {
+	sink.lock.Lock()
+	var data []*falconMetricData
+	for _, d := range sink.pending {
+		data = append(data, d...)
+	}
+	sink.pending = make(map[string][]*falconMetricData)
+	sink.lock.Unlock()
+
+	for start := 0; start < len(data); start += sink.cfg.batchSize {
+		end := start + sink.cfg.batchSize
+		if end > len(data) {
+			end = len(data)
+		}
+		if err := sink.postWithRetry(data[start:end]); err != nil {
+			log.Errorf("failed to push %d metrics to falcon: %s", end-start, err)
+			sinkFailed("falcon")
+		}
+	}
+}
+
+func (sink *falconSink) postWithRetry(batch []*falconMetricData) error {
+	body, err := json.Marshal(batch)
 	if err != nil {
-		log.Errorf("failed to post metrics to falcon agent: %s", err)
-		return
+		return err
+	}
+	for i := 0; ; i++ {
+		err = sink.post(body)
+		if err == nil || i >= sink.cfg.retryTimes {
+			return err
+		}
+		log.Warnf("failed to push metrics to falcon, retry later [%d/%d]: %s", i+1, sink.cfg.retryTimes, err)
+		time.Sleep(sink.cfg.retryInterval)
+	}
+}
+
+func (sink *falconSink) post(body []byte) error {
+	resp, err := sink.client.Post(sink.cfg.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("POST %s: %s", sink.cfg.url, resp.Status)
 	}
+	return nil
 }
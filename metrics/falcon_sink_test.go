@@ -0,0 +1,134 @@
+package metrics
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pegasus-kv/collector/aggregate"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeFalconAgent struct {
+	lock     sync.Mutex
+	failures int // the number of requests to fail before succeeding
+	requests int
+	received []*falconMetricData
+}
+
+func (a *fakeFalconAgent) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.requests++
+	if a.failures > 0 {
+		a.failures--
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	var batch []*falconMetricData
+	if err := json.NewDecoder(r.Body).Decode(&batch); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	a.received = append(a.received, batch...)
+}
+
+func newTestFalconSink(url string) *falconSink {
+	return newFalconSinkWithConfig(&falconConfig{
+		url:          url,
+		endpoint:     "onebox",
+		tags:         map[string]string{"region": "bj"},
+		pushInterval: 10 * time.Second,
+		batchSize:    2,
+		retryTimes:   2,
+	})
+}
+
+func TestFalconSinkPush(t *testing.T) {
+	agent := &fakeFalconAgent{failures: 1}
+	server := httptest.NewServer(agent)
+	defer server.Close()
+
+	sink := newTestFalconSink(server.URL)
+	ts := time.Unix(1600000000, 0)
+	sink.Report([]aggregate.TableStats{
+		{TableName: "stat", Timestamp: ts, Stats: map[string]float64{"read_qps": 10, "write_qps": 5}},
+	}, aggregate.ClusterStats{Timestamp: ts, Stats: map[string]float64{"read_qps": 10}})
+	sink.push()
+
+	// 2 batches, one retried
+	assert.Equal(t, agent.requests, 3)
+	assert.Equal(t, len(agent.received), 3)
+	for _, m := range agent.received {
+		assert.Equal(t, m.Endpoint, "onebox")
+		assert.Equal(t, m.Timestamp, int64(1600000000))
+		assert.Equal(t, m.Step, int32(10))
+		assert.Equal(t, m.CounterType, "GAUGE")
+		if m.Tags == "entity=cluster,region=bj" {
+			assert.Equal(t, m.Metric, "read_qps")
+		} else {
+			assert.Equal(t, m.Tags, "entity=table,region=bj,table=stat")
+		}
+	}
+
+	// the pushed metrics are not pushed again
+	sink.push()
+	assert.Equal(t, agent.requests, 3)
+}
+
+func TestFalconSinkRetryExhausted(t *testing.T) {
+	agent := &fakeFalconAgent{failures: 10}
+	server := httptest.NewServer(agent)
+	defer server.Close()
+
+	sink := newTestFalconSink(server.URL)
+	sink.Report(nil, aggregate.ClusterStats{Stats: map[string]float64{"read_qps": 10}})
+	sink.push()
+
+	assert.Equal(t, agent.requests, 3)
+	assert.Empty(t, agent.received)
+}
+
+func TestFalconSinkClose(t *testing.T) {
+	agent := &fakeFalconAgent{}
+	server := httptest.NewServer(agent)
+	defer server.Close()
+
+	sink := newTestFalconSink(server.URL)
+	sink.start()
+	sink.Report(nil, aggregate.ClusterStats{Stats: map[string]float64{"read_qps": 10}})
+
+	// the pending metrics are pushed on close, rather than after the push interval
+	assert.Nil(t, sink.Close())
+	assert.Equal(t, len(agent.received), 1)
+	select {
+	case <-sink.done:
+	default:
+		t.Fatal("the pushes are not stopped")
+	}
+}
+
+func TestFalconSinkReload(t *testing.T) {
+	defer viper.Reset()
+	viper.Set("metrics.sinks", []string{"falcon"})
+	viper.Set("metrics.report_interval", "10s")
+	s := &sinkSet{}
+	assert.Nil(t, s.apply(enabledSinks()))
+	old := s.sinks[0].(*falconSink)
+	assert.Equal(t, old.cfg.pushInterval, 10*time.Second)
+
+	// the push interval follows the report interval, and the replaced sink stops pushing
+	viper.Set("metrics.report_interval", "20s")
+	assert.Nil(t, s.reload())
+	assert.Equal(t, s.sinks[0].(*falconSink).cfg.pushInterval, 20*time.Second)
+	select {
+	case <-old.done:
+	default:
+		t.Fatal("the replaced sink is not closed")
+	}
+	s.Close()
+}
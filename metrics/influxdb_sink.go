@@ -0,0 +1,227 @@
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pegasus-kv/collector/aggregate"
+	"github.com/spf13/viper"
+)
+
+type influxDBConfig struct {
+	url string
+
+	// 1 for InfluxDB 1.x, authenticated by the username and password, or 2 for InfluxDB 2.x,
+	// authenticated by the token.
+	version         int
+	database        string
+	retentionPolicy string
+	username        string
+	password        string
+	org             string
+	bucket          string
+	token           string
+
+	tableMeasurement   string
+	nodeMeasurement    string
+	clusterMeasurement string
+
+	clusterName string
+	batchSize   int
+	timeout     time.Duration
+}
+
+// influxDBSink writes the table, node and cluster stats to InfluxDB in line protocol.
+// Each entity is written as one point whose fields are the metrics.
+type influxDBSink struct {
+	cfg    *influxDBConfig
+	client *http.Client
+}
+
+func newInfluxDBSink() *influxDBSink {
+	sink := newInfluxDBSinkWithConfig(&influxDBConfig{
+		url:                viper.GetString("influxdb.url"),
+		version:            viper.GetInt("influxdb.version"),
+		database:           viper.GetString("influxdb.database"),
+		retentionPolicy:    viper.GetString("influxdb.retention_policy"),
+		username:           viper.GetString("influxdb.username"),
+		password:           viper.GetString("influxdb.password"),
+		org:                viper.GetString("influxdb.org"),
+		bucket:             viper.GetString("influxdb.bucket"),
+		token:              viper.GetString("influxdb.token"),
+		tableMeasurement:   viper.GetString("influxdb.measurements.table"),
+		nodeMeasurement:    viper.GetString("influxdb.measurements.node"),
+		clusterMeasurement: viper.GetString("influxdb.measurements.cluster"),
+		clusterName:        viper.GetString("cluster_name"),
+		batchSize:          viper.GetInt("influxdb.batch_size"),
+		timeout:            viper.GetDuration("influxdb.timeout"),
+	})
+	return sink
+}
+
+func newInfluxDBSinkWithConfig(cfg *influxDBConfig) *influxDBSink {
+	if cfg.batchSize <= 0 {
+		cfg.batchSize = 1
+	}
+	return &influxDBSink{
+		cfg:    cfg,
+		client: &http.Client{Timeout: cfg.timeout},
+	}
+}
+
+func (sink *influxDBSink) Report(stats []aggregate.TableStats, allStats aggregate.ClusterStats) {
+	var lines []string
+	cluster := clusterOf(allStats.Cluster, sink.cfg.clusterName)
+	for _, table := range stats {
+		line := formatLinePoint(sink.cfg.tableMeasurement, map[string]string{
+			"cluster": cluster,
+			"table":   table.TableName,
+			"app_id":  strconv.Itoa(table.AppID),
+		}, table.Stats, table.Timestamp)
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	line := formatLinePoint(sink.cfg.clusterMeasurement, map[string]string{
+		"cluster": cluster,
+	}, allStats.Stats, allStats.Timestamp)
+	if line != "" {
+		lines = append(lines, line)
+	}
+	sink.write(lines)
+}
+
+// Close releases the idle connections. Nothing is pending, since the stats are written as soon
+// as they're reported.
+func (sink *influxDBSink) Close() error {
+	sink.client.CloseIdleConnections()
+	return nil
+}
+
+// ReportNodes writes the stats of the replica nodes.
+func (sink *influxDBSink) ReportNodes(nodes []aggregate.NodeStat) {
+	var lines []string
+	for _, node := range nodes {
+		line := formatLinePoint(sink.cfg.nodeMeasurement, map[string]string{
+			"cluster": clusterOf(node.Cluster, sink.cfg.clusterName),
+			"node":    node.Addr,
+		}, node.Stats, node.CollectedAt)
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	sink.write(lines)
+}
+
+// write posts the lines in batches. A failed batch is dropped.
+func (sink *influxDBSink) write(lines []string) {
+	for start := 0; start < len(lines); start += sink.cfg.batchSize {
+		end := start + sink.cfg.batchSize
+		if end > len(lines) {
+			end = len(lines)
+		}
+		if err := sink.post(strings.Join(lines[start:end], "\n")); err != nil {
+			log.Errorf("failed to write %d points to influxdb: %s", end-start, err)
+			sinkFailed("influxdb")
+		}
+	}
+}
+
+func (sink *influxDBSink) post(body string) error {
+	req, err := http.NewRequest(http.MethodPost, sink.writeURL(), bytes.NewBufferString(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if sink.cfg.version == 2 {
+		req.Header.Set("Authorization", "Token "+sink.cfg.token)
+	} else if sink.cfg.username != "" {
+		req.SetBasicAuth(sink.cfg.username, sink.cfg.password)
+	}
+
+	resp, err := sink.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	// InfluxDB responds 204 on success
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("POST %s: %s", req.URL.Path, resp.Status)
+	}
+	return nil
+}
+
+func (sink *influxDBSink) writeURL() string {
+	params := url.Values{}
+	params.Set("precision", "ns")
+	if sink.cfg.version == 2 {
+		params.Set("org", sink.cfg.org)
+		params.Set("bucket", sink.cfg.bucket)
+		return strings.TrimSuffix(sink.cfg.url, "/") + "/api/v2/write?" + params.Encode()
+	}
+	params.Set("db", sink.cfg.database)
+	if sink.cfg.retentionPolicy != "" {
+		params.Set("rp", sink.cfg.retentionPolicy)
+	}
+	return strings.TrimSuffix(sink.cfg.url, "/") + "/write?" + params.Encode()
+}
+
+// formatLinePoint formats a point in the line protocol, e.g.
+// "pegasus_table,app_id=1,cluster=onebox,table=stat get_qps=10,put_qps=5 1600000000000000000".
+// The tags and fields are sorted by key. An empty string is returned if there's no valid field,
+// since InfluxDB rejects the point. NaN and infinity are not supported by InfluxDB either.
+func formatLinePoint(measurement string, tags map[string]string, fields map[string]float64, ts time.Time) string {
+	var fieldPairs []string
+	for k, v := range fields {
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			continue
+		}
+		fieldPairs = append(fieldPairs, escapeLineKey(k)+"="+strconv.FormatFloat(v, 'f', -1, 64))
+	}
+	if len(fieldPairs) == 0 {
+		return ""
+	}
+	sort.Strings(fieldPairs)
+
+	var tagPairs []string
+	for k, v := range tags {
+		if v == "" {
+			// empty tag values are not allowed
+			continue
+		}
+		tagPairs = append(tagPairs, escapeLineKey(k)+"="+escapeLineKey(v))
+	}
+	sort.Strings(tagPairs)
+
+	if ts.IsZero() {
+		ts = time.Now()
+	}
+	var b strings.Builder
+	b.WriteString(lineMeasurementEscaper.Replace(measurement))
+	for _, pair := range tagPairs {
+		b.WriteString(",")
+		b.WriteString(pair)
+	}
+	b.WriteString(" ")
+	b.WriteString(strings.Join(fieldPairs, ","))
+	b.WriteString(" ")
+	b.WriteString(strconv.FormatInt(ts.UnixNano(), 10))
+	return b.String()
+}
+
+var (
+	lineMeasurementEscaper = strings.NewReplacer(",", `\,`, " ", `\ `)
+	lineKeyEscaper         = strings.NewReplacer(",", `\,`, " ", `\ `, "=", `\=`)
+)
+
+// escapeLineKey escapes the tag keys, tag values and field keys.
+func escapeLineKey(s string) string {
+	return lineKeyEscaper.Replace(s)
+}
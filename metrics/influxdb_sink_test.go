@@ -0,0 +1,80 @@
+package metrics
+
+import (
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pegasus-kv/collector/aggregate"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFormatLinePoint(t *testing.T) {
+	ts := time.Unix(1600000000, 0)
+	line := formatLinePoint("pegasus table", map[string]string{
+		"table":   "a,b=c d",
+		"cluster": "onebox",
+		"app_id":  "",
+	}, map[string]float64{"put_qps": 5, "get_qps": 1.5, "bad": math.NaN()}, ts)
+	assert.Equal(t, line, `pegasus\ table,cluster=onebox,table=a\,b\=c\ d get_qps=1.5,put_qps=5 1600000000000000000`)
+
+	assert.Equal(t, formatLinePoint("m", nil, map[string]float64{}, ts), "")
+}
+
+func TestInfluxDBSinkWrite(t *testing.T) {
+	var requests []*http.Request
+	var bodies []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		requests = append(requests, r)
+		bodies = append(bodies, string(body))
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	sink := newInfluxDBSinkWithConfig(&influxDBConfig{
+		url:                server.URL,
+		version:            2,
+		org:                "infra",
+		bucket:             "pegasus",
+		token:              "secret",
+		tableMeasurement:   "pegasus_table",
+		nodeMeasurement:    "pegasus_node",
+		clusterMeasurement: "pegasus_cluster",
+		clusterName:        "onebox",
+		batchSize:          2,
+	})
+	ts := time.Unix(1, 0)
+	sink.Report([]aggregate.TableStats{
+		{TableName: "stat", AppID: 1, Timestamp: ts, Stats: map[string]float64{"get_qps": 1}},
+		{TableName: "temp", AppID: 2, Timestamp: ts, Stats: map[string]float64{"get_qps": 2}},
+	}, aggregate.ClusterStats{Timestamp: ts, Stats: map[string]float64{"get_qps": 3}})
+
+	assert.Equal(t, len(requests), 2)
+	assert.Equal(t, requests[0].URL.Path, "/api/v2/write")
+	assert.Equal(t, requests[0].URL.Query().Get("org"), "infra")
+	assert.Equal(t, requests[0].URL.Query().Get("bucket"), "pegasus")
+	assert.Equal(t, requests[0].Header.Get("Authorization"), "Token secret")
+	assert.Equal(t, bodies[0], strings.Join([]string{
+		"pegasus_table,app_id=1,cluster=onebox,table=stat get_qps=1 1000000000",
+		"pegasus_table,app_id=2,cluster=onebox,table=temp get_qps=2 1000000000",
+	}, "\n"))
+	assert.Equal(t, bodies[1], "pegasus_cluster,cluster=onebox get_qps=3 1000000000")
+
+	sink.ReportNodes([]aggregate.NodeStat{{Addr: "127.0.0.1:34801", CollectedAt: ts, Stats: map[string]float64{"get_qps": 3}}})
+	assert.Equal(t, bodies[2], "pegasus_node,cluster=onebox,node=127.0.0.1:34801 get_qps=3 1000000000")
+}
+
+func TestInfluxDBSinkV1URL(t *testing.T) {
+	sink := newInfluxDBSinkWithConfig(&influxDBConfig{
+		url:             "http://127.0.0.1:8086/",
+		version:         1,
+		database:        "pegasus",
+		retentionPolicy: "autogen",
+	})
+	assert.Equal(t, sink.writeURL(), "http://127.0.0.1:8086/write?db=pegasus&precision=ns&rp=autogen")
+}
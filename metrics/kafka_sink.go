@@ -0,0 +1,186 @@
+package metrics
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/pegasus-kv/collector/aggregate"
+	"github.com/pegasus-kv/collector/events"
+	"github.com/segmentio/kafka-go"
+	"github.com/spf13/viper"
+)
+
+// kafkaMessageWriter is the part of kafka.Writer used by kafkaSink.
+type kafkaMessageWriter interface {
+	WriteMessages(ctx context.Context, msgs ...kafka.Message) error
+}
+
+// kafkaSink publishes the snapshot of every table, node and the cluster as a JSON message,
+// keyed by the entity, so that the messages of an entity are kept in order within a partition.
+// The events of the cluster are published to another topic if it's configured.
+type kafkaSink struct {
+	writer  kafkaMessageWriter
+	events  kafkaMessageWriter
+	cluster string
+	timeout time.Duration
+}
+
+// kafkaStatsMessage is the JSON value of the messages.
+type kafkaStatsMessage struct {
+	Cluster string `json:"cluster"`
+	// "table", "node" or "cluster"
+	Entity string `json:"entity"`
+	Table  string `json:"table,omitempty"`
+	AppID  int    `json:"app_id,omitempty"`
+	Node   string `json:"node,omitempty"`
+	// unix milliseconds
+	Timestamp int64              `json:"timestamp"`
+	Stats     map[string]float64 `json:"stats"`
+}
+
+func newKafkaSink() (*kafkaSink, error) {
+	brokers := viper.GetStringSlice("kafka.brokers")
+	topic := viper.GetString("kafka.topic")
+	if len(brokers) == 0 || topic == "" {
+		return nil, errors.New("kafka.brokers and kafka.topic are required")
+	}
+	writer := kafka.NewWriter(kafka.WriterConfig{
+		Brokers:      brokers,
+		Topic:        topic,
+		Balancer:     &kafka.Hash{},
+		BatchSize:    viper.GetInt("kafka.batch_size"),
+		BatchTimeout: 100 * time.Millisecond,
+	})
+	sink := &kafkaSink{
+		writer:  writer,
+		cluster: viper.GetString("cluster_name"),
+		timeout: viper.GetDuration("kafka.timeout"),
+	}
+	if topic := viper.GetString("kafka.events_topic"); topic != "" {
+		sink.events = kafka.NewWriter(kafka.WriterConfig{
+			Brokers:      brokers,
+			Topic:        topic,
+			Balancer:     &kafka.Hash{},
+			BatchTimeout: 100 * time.Millisecond,
+		})
+	}
+	return sink, nil
+}
+
+func (sink *kafkaSink) Report(stats []aggregate.TableStats, allStats aggregate.ClusterStats) {
+	var msgs []*kafkaStatsMessage
+	cluster := clusterOf(allStats.Cluster, sink.cluster)
+	for _, table := range stats {
+		msgs = append(msgs, &kafkaStatsMessage{
+			Cluster:   cluster,
+			Entity:    "table",
+			Table:     table.TableName,
+			AppID:     table.AppID,
+			Timestamp: unixMillis(table.Timestamp),
+			Stats:     table.Stats,
+		})
+	}
+	msgs = append(msgs, &kafkaStatsMessage{
+		Cluster:   cluster,
+		Entity:    "cluster",
+		Timestamp: unixMillis(allStats.Timestamp),
+		Stats:     allStats.Stats,
+	})
+	sink.publish(msgs)
+}
+
+// ReportNodes implements NodeSink, which publishes the stats of the replica nodes.
+func (sink *kafkaSink) ReportNodes(nodes []aggregate.NodeStat) {
+	var msgs []*kafkaStatsMessage
+	for _, node := range nodes {
+		msgs = append(msgs, &kafkaStatsMessage{
+			Cluster:   clusterOf(node.Cluster, sink.cluster),
+			Entity:    "node",
+			Node:      node.Addr,
+			Timestamp: unixMillis(node.CollectedAt),
+			Stats:     node.Stats,
+		})
+	}
+	sink.publish(msgs)
+}
+
+// ReportEvent implements EventSink, which publishes the event keyed by the cluster, if the
+// events topic is configured.
+func (sink *kafkaSink) ReportEvent(e events.Event) {
+	if sink.events == nil {
+		return
+	}
+	value, err := json.Marshal(e)
+	if err != nil {
+		log.Errorf("failed to marshal the event %d: %s", e.ID, err)
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), sink.timeout)
+	defer cancel()
+	msg := kafka.Message{Key: []byte(e.Cluster), Value: value, Time: e.Time}
+	if err := sink.events.WriteMessages(ctx, msg); err != nil {
+		log.Errorf("failed to publish the event %d to kafka: %s", e.ID, err)
+		sinkFailed("kafka")
+	}
+}
+
+// Close implements io.Closer, which flushes the messages and closes the writers.
+func (sink *kafkaSink) Close() error {
+	var err error
+	for _, w := range []kafkaMessageWriter{sink.writer, sink.events} {
+		if c, ok := w.(io.Closer); ok {
+			if closeErr := c.Close(); closeErr != nil {
+				err = closeErr
+			}
+		}
+	}
+	return err
+}
+
+func (sink *kafkaSink) publish(msgs []*kafkaStatsMessage) {
+	if len(msgs) == 0 {
+		return
+	}
+	var kafkaMsgs []kafka.Message
+	for _, msg := range msgs {
+		value, err := json.Marshal(msg)
+		if err != nil {
+			log.Errorf("failed to marshal the stats of %s: %s", msg.key(), err)
+			continue
+		}
+		kafkaMsgs = append(kafkaMsgs, kafka.Message{
+			Key:   []byte(msg.key()),
+			Value: value,
+			Time:  time.Unix(0, msg.Timestamp*int64(time.Millisecond)),
+		})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), sink.timeout)
+	defer cancel()
+	if err := sink.writer.WriteMessages(ctx, kafkaMsgs...); err != nil {
+		log.Errorf("failed to publish %d messages to kafka: %s", len(kafkaMsgs), err)
+		sinkFailed("kafka")
+	}
+}
+
+func (msg *kafkaStatsMessage) key() string {
+	switch msg.Entity {
+	case "table":
+		return msg.Cluster + "/" + msg.Table + "(" + strconv.Itoa(msg.AppID) + ")"
+	case "node":
+		return msg.Cluster + "/" + msg.Node
+	default:
+		return msg.Cluster
+	}
+}
+
+func unixMillis(t time.Time) int64 {
+	if t.IsZero() {
+		t = time.Now()
+	}
+	return t.UnixNano() / int64(time.Millisecond)
+}
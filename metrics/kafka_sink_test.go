@@ -0,0 +1,82 @@
+package metrics
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/pegasus-kv/collector/aggregate"
+	"github.com/pegasus-kv/collector/events"
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeKafkaWriter struct {
+	msgs []kafka.Message
+	err  error
+}
+
+func (w *fakeKafkaWriter) WriteMessages(ctx context.Context, msgs ...kafka.Message) error {
+	w.msgs = append(w.msgs, msgs...)
+	return w.err
+}
+
+func TestKafkaSinkReport(t *testing.T) {
+	writer := &fakeKafkaWriter{}
+	sink := &kafkaSink{writer: writer, cluster: "onebox", timeout: time.Second}
+
+	ts := time.Unix(1600000000, 0)
+	sink.Report([]aggregate.TableStats{
+		{TableName: "stat", AppID: 1, Timestamp: ts, Stats: map[string]float64{"get_qps": 1}},
+	}, aggregate.ClusterStats{Timestamp: ts, Stats: map[string]float64{"get_qps": 1}})
+	sink.ReportNodes([]aggregate.NodeStat{{Addr: "127.0.0.1:34801", CollectedAt: ts, Stats: map[string]float64{"get_qps": 1}}})
+
+	assert.Equal(t, len(writer.msgs), 3)
+	var keys []string
+	for _, msg := range writer.msgs {
+		keys = append(keys, string(msg.Key))
+		assert.Equal(t, msg.Time, ts)
+	}
+	assert.Equal(t, keys, []string{"onebox/stat(1)", "onebox", "onebox/127.0.0.1:34801"})
+
+	var table kafkaStatsMessage
+	assert.Nil(t, json.Unmarshal(writer.msgs[0].Value, &table))
+	assert.Equal(t, table, kafkaStatsMessage{
+		Cluster:   "onebox",
+		Entity:    "table",
+		Table:     "stat",
+		AppID:     1,
+		Timestamp: 1600000000000,
+		Stats:     map[string]float64{"get_qps": 1},
+	})
+	assert.JSONEq(t, string(writer.msgs[2].Value),
+		`{"cluster":"onebox","entity":"node","node":"127.0.0.1:34801","timestamp":1600000000000,"stats":{"get_qps":1}}`)
+
+	// the failure is only logged
+	writer.err = errors.New("leader not available")
+	sink.ReportNodes([]aggregate.NodeStat{{Addr: "127.0.0.1:34801"}})
+	sink.ReportNodes(nil)
+	assert.Equal(t, len(writer.msgs), 4)
+}
+
+func TestKafkaSinkReportEvent(t *testing.T) {
+	sink := &kafkaSink{writer: &fakeKafkaWriter{}, cluster: "onebox", timeout: time.Second}
+	e := events.Event{ID: 1, Time: time.Unix(1600000000, 0), Cluster: "onebox", Kind: events.NodeDown,
+		Node: "127.0.0.1:34801", Message: "replica node 127.0.0.1:34801 changes from alive to unalive"}
+	// no events topic
+	sink.ReportEvent(e)
+
+	writer := &fakeKafkaWriter{}
+	sink.events = writer
+	sink.ReportEvent(e)
+	assert.Equal(t, len(writer.msgs), 1)
+	assert.Equal(t, string(writer.msgs[0].Key), "onebox")
+	assert.Equal(t, writer.msgs[0].Time, e.Time)
+	var published events.Event
+	assert.Nil(t, json.Unmarshal(writer.msgs[0].Value, &published))
+	assert.Equal(t, published.Time.Unix(), e.Time.Unix())
+	published.Time = e.Time
+	assert.Equal(t, published, e)
+}
@@ -0,0 +1,167 @@
+package metrics
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pegasus-kv/collector/aggregate"
+	"github.com/spf13/viper"
+)
+
+type openTSDBConfig struct {
+	url string
+
+	// The prefix of the metric names, e.g. "pegasus." for "pegasus.get_qps".
+	metricPrefix string
+
+	// The tag keys of the cluster name, the table name and the node address.
+	clusterTag string
+	tableTag   string
+	nodeTag    string
+
+	clusterName string
+	chunkSize   int
+	timeout     time.Duration
+}
+
+// openTSDBSink writes the table, node and cluster stats through the OpenTSDB /api/put API.
+type openTSDBSink struct {
+	cfg    *openTSDBConfig
+	client *http.Client
+}
+
+type openTSDBDataPoint struct {
+	Metric    string            `json:"metric"`
+	Timestamp int64             `json:"timestamp"` // in unix seconds
+	Value     float64           `json:"value"`
+	Tags      map[string]string `json:"tags"`
+}
+
+func newOpenTSDBSink() *openTSDBSink {
+	sink := newOpenTSDBSinkWithConfig(&openTSDBConfig{
+		url:          viper.GetString("opentsdb.url"),
+		metricPrefix: viper.GetString("opentsdb.metric_prefix"),
+		clusterTag:   viper.GetString("opentsdb.tags.cluster"),
+		tableTag:     viper.GetString("opentsdb.tags.table"),
+		nodeTag:      viper.GetString("opentsdb.tags.node"),
+		clusterName:  viper.GetString("cluster_name"),
+		chunkSize:    viper.GetInt("opentsdb.chunk_size"),
+		timeout:      viper.GetDuration("opentsdb.timeout"),
+	})
+	return sink
+}
+
+func newOpenTSDBSinkWithConfig(cfg *openTSDBConfig) *openTSDBSink {
+	if cfg.chunkSize <= 0 {
+		cfg.chunkSize = 1
+	}
+	return &openTSDBSink{
+		cfg:    cfg,
+		client: &http.Client{Timeout: cfg.timeout},
+	}
+}
+
+func (sink *openTSDBSink) Report(stats []aggregate.TableStats, allStats aggregate.ClusterStats) {
+	var points []*openTSDBDataPoint
+	cluster := clusterOf(allStats.Cluster, sink.cfg.clusterName)
+	for _, table := range stats {
+		points = sink.appendPoints(points, table.Stats, table.Timestamp, map[string]string{
+			sink.cfg.clusterTag: cluster,
+			sink.cfg.tableTag:   table.TableName,
+		})
+	}
+	points = sink.appendPoints(points, allStats.Stats, allStats.Timestamp, map[string]string{
+		sink.cfg.clusterTag: cluster,
+	})
+	sink.put(points)
+}
+
+// Close releases the idle connections. Nothing is pending, since the stats are written as soon
+// as they're reported.
+func (sink *openTSDBSink) Close() error {
+	sink.client.CloseIdleConnections()
+	return nil
+}
+
+// ReportNodes writes the stats of the replica nodes.
+func (sink *openTSDBSink) ReportNodes(nodes []aggregate.NodeStat) {
+	var points []*openTSDBDataPoint
+	for _, node := range nodes {
+		points = sink.appendPoints(points, node.Stats, node.CollectedAt, map[string]string{
+			sink.cfg.clusterTag: clusterOf(node.Cluster, sink.cfg.clusterName),
+			sink.cfg.nodeTag:    node.Addr,
+		})
+	}
+	sink.put(points)
+}
+
+func (sink *openTSDBSink) appendPoints(points []*openTSDBDataPoint, stats map[string]float64, ts time.Time, tags map[string]string) []*openTSDBDataPoint {
+	if ts.IsZero() {
+		ts = time.Now()
+	}
+	sanitized := make(map[string]string, len(tags))
+	for k, v := range tags {
+		sanitized[sanitizeOpenTSDBName(k)] = sanitizeOpenTSDBName(v)
+	}
+	for name, value := range stats {
+		if math.IsNaN(value) || math.IsInf(value, 0) {
+			continue
+		}
+		points = append(points, &openTSDBDataPoint{
+			Metric:    sanitizeOpenTSDBName(sink.cfg.metricPrefix + name),
+			Timestamp: ts.Unix(),
+			Value:     value,
+			Tags:      sanitized,
+		})
+	}
+	return points
+}
+
+// put writes the points in chunks. A failed chunk is dropped.
+func (sink *openTSDBSink) put(points []*openTSDBDataPoint) {
+	for start := 0; start < len(points); start += sink.cfg.chunkSize {
+		end := start + sink.cfg.chunkSize
+		if end > len(points) {
+			end = len(points)
+		}
+		if err := sink.post(points[start:end]); err != nil {
+			log.Errorf("failed to put %d data points to opentsdb: %s", end-start, err)
+			sinkFailed("opentsdb")
+		}
+	}
+}
+
+func (sink *openTSDBSink) post(points []*openTSDBDataPoint) error {
+	body, err := json.Marshal(points)
+	if err != nil {
+		return err
+	}
+	url := strings.TrimSuffix(sink.cfg.url, "/") + "/api/put"
+	resp, err := sink.client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	// OpenTSDB responds 204 on success
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("POST %s: %s", url, resp.Status)
+	}
+	return nil
+}
+
+// sanitizeOpenTSDBName replaces the characters that OpenTSDB doesn't allow in the metric
+// names and tags with "_". Only letters, digits, "-", "_", "." and "/" are allowed.
+func sanitizeOpenTSDBName(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' ||
+			r == '-' || r == '_' || r == '.' || r == '/' {
+			return r
+		}
+		return '_'
+	}, s)
+}
@@ -0,0 +1,54 @@
+package metrics
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/pegasus-kv/collector/aggregate"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOpenTSDBSinkPut(t *testing.T) {
+	var chunks [][]*openTSDBDataPoint
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, r.URL.Path, "/api/put")
+		var points []*openTSDBDataPoint
+		assert.Nil(t, json.NewDecoder(r.Body).Decode(&points))
+		chunks = append(chunks, points)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	sink := newOpenTSDBSinkWithConfig(&openTSDBConfig{
+		url:          server.URL + "/",
+		metricPrefix: "pegasus.",
+		clusterTag:   "cluster",
+		tableTag:     "app",
+		nodeTag:      "host",
+		clusterName:  "onebox",
+		chunkSize:    2,
+	})
+	ts := time.Unix(1600000000, 0)
+	sink.Report([]aggregate.TableStats{
+		{TableName: "stat", Timestamp: ts, Stats: map[string]float64{"get_qps": 1}},
+		{TableName: "temp", Timestamp: ts, Stats: map[string]float64{"get_qps": 2}},
+	}, aggregate.ClusterStats{Timestamp: ts, Stats: map[string]float64{"get_qps": 3}})
+
+	assert.Equal(t, len(chunks), 2)
+	assert.Equal(t, len(chunks[0]), 2)
+	assert.Equal(t, chunks[1], []*openTSDBDataPoint{
+		{Metric: "pegasus.get_qps", Timestamp: 1600000000, Value: 3, Tags: map[string]string{"cluster": "onebox"}},
+	})
+	assert.Equal(t, chunks[0][0].Tags, map[string]string{"cluster": "onebox", "app": "stat"})
+
+	sink.ReportNodes([]aggregate.NodeStat{{Addr: "127.0.0.1:34801", CollectedAt: ts, Stats: map[string]float64{"get_qps": 3}}})
+	assert.Equal(t, chunks[2][0].Tags, map[string]string{"cluster": "onebox", "host": "127.0.0.1_34801"})
+}
+
+func TestSanitizeOpenTSDBName(t *testing.T) {
+	assert.Equal(t, sanitizeOpenTSDBName("pegasus.sst_storage(MB)"), "pegasus.sst_storage_MB_")
+	assert.Equal(t, sanitizeOpenTSDBName("a-b/c.d_e"), "a-b/c.d_e")
+}
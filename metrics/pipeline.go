@@ -0,0 +1,135 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/pegasus-kv/collector/aggregate"
+)
+
+// Stage is a step of the Pipeline. It takes the tables from the previous stage and
+// passes the processed tables to the next one.
+type Stage interface {
+	Process(tables []*aggregate.TableStats) ([]*aggregate.TableStats, error)
+}
+
+// Pipeline exports the stats through a chain of stages, e.g. filtering the tables,
+// renaming the metrics, then reporting them to the sinks.
+type Pipeline struct {
+	stages []Stage
+}
+
+// AddStage appends a stage to the end of the pipeline.
+func (p *Pipeline) AddStage(stage Stage) {
+	p.stages = append(p.stages, stage)
+}
+
+// Run processes every batch of tables from `input` through the stages in order, until
+// `input` is closed or `ctx` is cancelled. It stops at the first error of any stage.
+func (p *Pipeline) Run(ctx context.Context, input <-chan []*aggregate.TableStats) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case tables, ok := <-input:
+			if !ok {
+				return nil
+			}
+			if err := p.process(tables); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (p *Pipeline) process(tables []*aggregate.TableStats) error {
+	var err error
+	for i, stage := range p.stages {
+		tables, err = stage.Process(tables)
+		if err != nil {
+			return fmt.Errorf("stage %d (%T) failed: %s", i, stage, err)
+		}
+	}
+	return nil
+}
+
+// FilterStage drops the tables whose names match the pattern.
+type FilterStage struct {
+	pattern *regexp.Regexp
+}
+
+// NewFilterStage returns a FilterStage dropping the tables matched with the regular expression.
+func NewFilterStage(pattern string) (*FilterStage, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	return &FilterStage{pattern: re}, nil
+}
+
+// Process implements Stage.
+func (s *FilterStage) Process(tables []*aggregate.TableStats) ([]*aggregate.TableStats, error) {
+	var ret []*aggregate.TableStats
+	for _, tb := range tables {
+		if !s.pattern.MatchString(tb.TableName) {
+			ret = append(ret, tb)
+		}
+	}
+	return ret, nil
+}
+
+// TransformStage renames and rescales the table metrics. The input tables are left unchanged,
+// the transformed metrics are written to copies of them.
+type TransformStage struct {
+	// metric name -> the new name
+	Aliases map[string]string
+
+	// metric name -> the factor multiplied to the value, e.g. 1.0/1024 converts bytes to KB.
+	// The factors are looked up by the original names.
+	Scales map[string]float64
+}
+
+// Process implements Stage.
+func (s *TransformStage) Process(tables []*aggregate.TableStats) ([]*aggregate.TableStats, error) {
+	ret := make([]*aggregate.TableStats, 0, len(tables))
+	for _, tb := range tables {
+		transformed := *tb
+		transformed.Stats = make(map[string]float64, len(tb.Stats))
+		for name, value := range tb.Stats {
+			if factor, found := s.Scales[name]; found {
+				value *= factor
+			}
+			if alias, found := s.Aliases[name]; found {
+				name = alias
+			}
+			transformed.Stats[name] = value
+		}
+		ret = append(ret, &transformed)
+	}
+	return ret, nil
+}
+
+// SinkStage reports the tables to a Sink, and passes them unchanged to the next stage.
+// The cluster stats given to the Sink are summed up from the tables.
+type SinkStage struct {
+	Sink Sink
+}
+
+// Process implements Stage.
+func (s *SinkStage) Process(tables []*aggregate.TableStats) ([]*aggregate.TableStats, error) {
+	stats := make([]aggregate.TableStats, 0, len(tables))
+	allStats := aggregate.ClusterStats{
+		Timestamp: time.Now(),
+		Stats:     make(map[string]float64),
+	}
+	for _, tb := range tables {
+		stats = append(stats, *tb)
+		for name, value := range tb.Stats {
+			allStats.Stats[name] += value
+		}
+	}
+	s.Sink.Report(stats, allStats)
+	return tables, nil
+}
@@ -0,0 +1,66 @@
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/pegasus-kv/collector/aggregate"
+	"github.com/stretchr/testify/assert"
+)
+
+type failingStage struct{}
+
+func (failingStage) Process([]*aggregate.TableStats) ([]*aggregate.TableStats, error) {
+	return nil, errors.New("failed")
+}
+
+func TestPipelineRun(t *testing.T) {
+	filter, err := NewFilterStage("^temp")
+	assert.Nil(t, err)
+
+	buf := &bytes.Buffer{}
+	p := &Pipeline{}
+	p.AddStage(filter)
+	p.AddStage(&TransformStage{
+		Aliases: map[string]string{"storage_mb": "storage_kb"},
+		Scales:  map[string]float64{"storage_mb": 1024},
+	})
+	p.AddStage(&SinkStage{Sink: NewCSVSink(buf)})
+
+	ts := time.Unix(1, 0)
+	stat := &aggregate.TableStats{TableName: "stat", Timestamp: ts, Stats: map[string]float64{"get_qps": 1, "storage_mb": 2}}
+	temp := &aggregate.TableStats{TableName: "temp_1", Timestamp: ts, Stats: map[string]float64{"get_qps": 3}}
+
+	input := make(chan []*aggregate.TableStats, 1)
+	input <- []*aggregate.TableStats{stat, temp}
+	close(input)
+	assert.Nil(t, p.Run(context.Background(), input))
+
+	assert.Equal(t, buf.String(), "1000,stat,get_qps,1\n1000,stat,storage_kb,2048\n")
+	// the input tables are unchanged
+	assert.Equal(t, stat.Stats, map[string]float64{"get_qps": 1, "storage_mb": 2})
+}
+
+func TestPipelineRunError(t *testing.T) {
+	p := &Pipeline{}
+	p.AddStage(failingStage{})
+
+	input := make(chan []*aggregate.TableStats, 1)
+	input <- []*aggregate.TableStats{{TableName: "stat"}}
+	assert.NotNil(t, p.Run(context.Background(), input))
+}
+
+func TestPipelineRunCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	p := &Pipeline{}
+	assert.Equal(t, p.Run(ctx, make(chan []*aggregate.TableStats)), context.Canceled)
+}
+
+func TestNewFilterStageInvalidPattern(t *testing.T) {
+	_, err := NewFilterStage("(")
+	assert.NotNil(t, err)
+}
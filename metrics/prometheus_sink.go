@@ -1,93 +1,187 @@
 package metrics
 
 import (
+	"strconv"
 	"sync"
 
 	"github.com/pegasus-kv/collector/aggregate"
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
 )
 
-type prometheusMetricFamily struct {
-	metrics map[string]prometheus.Gauge
-}
-
-func (f *prometheusMetricFamily) set(name string, value float64) {
-	f.metrics[name].Set(value)
-}
+// The labels of every metric. The table and app_id are empty for the cluster-level metrics.
+// The role is "secondary" for the stats of the secondary replicas, otherwise "primary".
+var prometheusLabels = []string{"cluster", "entity", "table", "app_id", "role"}
 
 type prometheusSink struct {
-	tableMap map[int]*prometheusMetricFamily
+	lock sync.Mutex
+
+	// metric name -> the gauges of the metric, one for each table and one for the cluster
+	gauges map[string]*prometheus.GaugeVec
+
+	// cluster -> app ID -> table name, of the tables reported in the last round
+	tables map[string]map[int]string
 
-	tableLock sync.RWMutex
+	// the storage of each replica node, i.e. the sst_storage_mb of its primaries
+	nodeStorage *prometheus.GaugeVec
+	// cluster -> the nodes reported in the last round
+	nodes map[string]map[string]bool
 
-	clusterMetric *prometheusMetricFamily
+	// the cluster of the stats that are not tagged
+	cluster string
 
-	allTrackedMetrics []string
+	registerer prometheus.Registerer
 }
 
 func newPrometheusSink() *prometheusSink {
+	return newPrometheusSinkWithRegisterer(prometheus.DefaultRegisterer,
+		viper.GetString("cluster_name"),
+		viper.GetString("prometheus.namespace"),
+		viper.GetString("prometheus.subsystem"))
+}
+
+// newPrometheusSinkWithRegisterer registers the gauges of all metrics into `registerer`,
+// named as "<namespace>_<subsystem>_<metric>", e.g. "pegasus_cluster_a_read_qps".
+func newPrometheusSinkWithRegisterer(registerer prometheus.Registerer, cluster, namespace, subsystem string) *prometheusSink {
 	sink := &prometheusSink{
-		tableMap:          make(map[int]*prometheusMetricFamily),
-		allTrackedMetrics: aggregate.AllMetrics(),
+		gauges: make(map[string]*prometheus.GaugeVec),
+		tables: make(map[string]map[int]string),
+		nodeStorage: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: prometheus.BuildFQName(namespace, subsystem, "node_sst_storage_mb"),
+		}, []string{"cluster", "node"}),
+		nodes:      make(map[string]map[string]bool),
+		cluster:    cluster,
+		registerer: registerer,
+	}
+	registerer.MustRegister(sink.nodeStorage)
+	// ClusterMetrics includes all table metrics
+	for _, m := range aggregate.ClusterMetrics() {
+		gauge := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: prometheus.BuildFQName(namespace, subsystem, m),
+		}, prometheusLabels)
+		registerer.MustRegister(gauge)
+		sink.gauges[m] = gauge
 	}
-	sink.clusterMetric = sink.newClusterMetricFamily()
-
-	aggregate.AddHookAfterTableDropped(func(appID int) {
-		// remove the metrics family belongs to the table
-		sink.tableLock.Lock()
-		for _, gauge := range sink.tableMap[appID].metrics {
-			prometheus.Unregister(gauge)
-		}
-		delete(sink.tableMap, appID)
-		sink.tableLock.Unlock()
-	})
 	return sink
 }
 
+// Report sets the gauges of the tables and the cluster. The gauges of the tables of the cluster
+// absent from the round, i.e. dropped, are removed.
 func (sink *prometheusSink) Report(stats []aggregate.TableStats, allStats aggregate.ClusterStats) {
+	sink.lock.Lock()
+	defer sink.lock.Unlock()
+
+	cluster := clusterOf(allStats.Cluster, sink.cluster)
+	prev := sink.tables[cluster]
+	current := make(map[int]string, len(stats))
 	for _, table := range stats {
-		sink.tableLock.Lock()
-		defer sink.tableLock.Unlock()
-
-		var mfamily *prometheusMetricFamily
-		var found bool
-		if mfamily, found = sink.tableMap[table.AppID]; !found {
-			mfamily = sink.newTableMetricFamily(table.TableName)
-			// insert table metrics family
-			sink.tableMap[table.AppID] = mfamily
+		current[table.AppID] = table.TableName
+		sink.fillGauges(table.Stats, tableLabels(cluster, table.AppID, table.TableName, aggregate.RolePrimary))
+		sink.fillGauges(table.SecondaryStats, tableLabels(cluster, table.AppID, table.TableName, aggregate.RoleSecondary))
+	}
+	sink.tables[cluster] = current
+	for appID, name := range prev {
+		if _, found := current[appID]; !found {
+			sink.removeTable(cluster, appID, name)
 		}
-		fillStatsIntoGauges(table.Stats, mfamily)
 	}
-	fillStatsIntoGauges(allStats.Stats, sink.clusterMetric)
+	sink.fillGauges(allStats.Stats, clusterLabels(cluster, aggregate.RolePrimary))
+	sink.fillGauges(allStats.SecondaryStats, clusterLabels(cluster, aggregate.RoleSecondary))
 }
 
-func fillStatsIntoGauges(stats map[string]float64, family *prometheusMetricFamily) {
-	for name, value := range stats {
-		family.set(name, value)
+// ReportNodes implements NodeSink. The gauges of the nodes absent from the round are removed.
+func (sink *prometheusSink) ReportNodes(nodes []aggregate.NodeStat) {
+	sink.lock.Lock()
+	defer sink.lock.Unlock()
+
+	cluster := sink.cluster
+	if len(nodes) != 0 {
+		// the nodes of a round are of the same cluster
+		cluster = clusterOf(nodes[0].Cluster, sink.cluster)
+	}
+	current := make(map[string]bool)
+	for _, n := range nodes {
+		if v, found := n.Stats["sst_storage_mb"]; found {
+			sink.nodeStorage.WithLabelValues(cluster, n.Addr).Set(v)
+			current[n.Addr] = true
+		}
+	}
+	for addr := range sink.nodes[cluster] {
+		if !current[addr] {
+			sink.nodeStorage.DeleteLabelValues(cluster, addr)
+		}
 	}
+	sink.nodes[cluster] = current
 }
 
-func (sink *prometheusSink) newTableMetricFamily(tableName string) *prometheusMetricFamily {
-	return sink.newMetricFamily(map[string]string{"table": tableName, "entity": "table"})
-}
+// RemoveCluster implements ClusterSink.
+func (sink *prometheusSink) RemoveCluster(cluster string) {
+	sink.lock.Lock()
+	defer sink.lock.Unlock()
 
-func (sink *prometheusSink) newClusterMetricFamily() *prometheusMetricFamily {
-	return sink.newMetricFamily(map[string]string{"entity": "cluster"})
+	for appID, name := range sink.tables[cluster] {
+		sink.removeTable(cluster, appID, name)
+	}
+	delete(sink.tables, cluster)
+	for _, gauge := range sink.gauges {
+		gauge.Delete(clusterLabels(cluster, aggregate.RolePrimary))
+		gauge.Delete(clusterLabels(cluster, aggregate.RoleSecondary))
+	}
+	for addr := range sink.nodes[cluster] {
+		sink.nodeStorage.DeleteLabelValues(cluster, addr)
+	}
+	delete(sink.nodes, cluster)
 }
 
-func (sink *prometheusSink) newMetricFamily(labels map[string]string) *prometheusMetricFamily {
-	mfamily := &prometheusMetricFamily{
-		metrics: make(map[string]prometheus.Gauge),
+// Close unregisters the gauges, so that a new sink can be registered once the sink is replaced
+// on reload.
+func (sink *prometheusSink) Close() error {
+	sink.lock.Lock()
+	defer sink.lock.Unlock()
+	sink.registerer.Unregister(sink.nodeStorage)
+	for _, gauge := range sink.gauges {
+		sink.registerer.Unregister(gauge)
 	}
-	for _, m := range sink.allTrackedMetrics {
-		// create and register a gauge
-		opts := prometheus.GaugeOpts{
-			Name:        m,
-			ConstLabels: labels,
+	return nil
+}
+
+func (sink *prometheusSink) fillGauges(stats map[string]float64, labels prometheus.Labels) {
+	for name, value := range stats {
+		gauge, found := sink.gauges[name]
+		if !found {
+			// not a tracked metric
+			continue
 		}
-		gauge := prometheus.NewGauge(opts)
-		prometheus.MustRegister(gauge)
-		mfamily.metrics[m] = gauge
+		gauge.With(labels).Set(value)
+	}
+}
+
+// removeTable deletes the metrics of the dropped table. It must be called with the lock held.
+func (sink *prometheusSink) removeTable(cluster string, appID int, name string) {
+	for _, gauge := range sink.gauges {
+		gauge.Delete(tableLabels(cluster, appID, name, aggregate.RolePrimary))
+		gauge.Delete(tableLabels(cluster, appID, name, aggregate.RoleSecondary))
+	}
+	log.WithFields(logrus.Fields{"cluster": cluster, "table": name}).Infof("removed the prometheus metrics of table %s(appid=%d) of cluster %s", name, appID, cluster)
+}
+
+func tableLabels(cluster string, appID int, name string, role aggregate.ReplicaRole) prometheus.Labels {
+	return prometheus.Labels{
+		"cluster": cluster,
+		"entity":  "table",
+		"table":   name,
+		"app_id":  strconv.Itoa(appID),
+		"role":    string(role),
+	}
+}
+
+func clusterLabels(cluster string, role aggregate.ReplicaRole) prometheus.Labels {
+	return prometheus.Labels{
+		"cluster": cluster,
+		"entity":  "cluster",
+		"table":   "",
+		"app_id":  "",
+		"role":    string(role),
 	}
-	return mfamily
 }
@@ -0,0 +1,75 @@
+package metrics
+
+import (
+	"testing"
+
+	"github.com/pegasus-kv/collector/aggregate"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrometheusSinkReport(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	sink := newPrometheusSinkWithRegisterer(registry, "onebox", "pegasus", "")
+
+	stats := []aggregate.TableStats{
+		{TableName: "stat", AppID: 1, Stats: map[string]float64{"read_qps": 10, "unknown": 1},
+			SecondaryStats: map[string]float64{"read_qps": 2}},
+		{TableName: "temp", AppID: 2, Stats: map[string]float64{"read_qps": 20}},
+	}
+	allStats := aggregate.ClusterStats{Stats: map[string]float64{"read_qps": 30, "dead_node_count": 1}}
+	sink.Report(stats, allStats)
+
+	readQPS := sink.gauges["read_qps"]
+	assert.Equal(t, testutil.ToFloat64(readQPS.WithLabelValues("onebox", "table", "stat", "1", "primary")), float64(10))
+	assert.Equal(t, testutil.ToFloat64(readQPS.WithLabelValues("onebox", "table", "temp", "2", "primary")), float64(20))
+	assert.Equal(t, testutil.ToFloat64(readQPS.WithLabelValues("onebox", "cluster", "", "", "primary")), float64(30))
+	assert.Equal(t, testutil.ToFloat64(sink.gauges["dead_node_count"].WithLabelValues("onebox", "cluster", "", "", "primary")), float64(1))
+	assert.Equal(t, testutil.ToFloat64(readQPS.WithLabelValues("onebox", "table", "stat", "1", "secondary")), float64(2))
+	assert.Equal(t, testutil.CollectAndCount(readQPS), 4)
+
+	families, err := registry.Gather()
+	assert.Nil(t, err)
+	found := false
+	for _, f := range families {
+		if f.GetName() == "pegasus_read_qps" {
+			found = true
+		}
+	}
+	assert.True(t, found)
+
+	// the gauges of both roles of the dropped table are removed
+	sink.Report(stats[1:], allStats)
+	assert.Equal(t, testutil.CollectAndCount(readQPS), 2)
+
+	// the stats of another cluster are labelled by its name
+	allStats.Cluster = "c2"
+	sink.Report(stats[:1], allStats)
+	assert.Equal(t, testutil.ToFloat64(readQPS.WithLabelValues("c2", "table", "stat", "1", "primary")), float64(10))
+	assert.Equal(t, testutil.ToFloat64(readQPS.WithLabelValues("onebox", "table", "temp", "2", "primary")), float64(20))
+	assert.Equal(t, testutil.CollectAndCount(readQPS), 5)
+
+	// the gauges of the removed cluster are removed
+	sink.RemoveCluster("c2")
+	assert.Equal(t, testutil.CollectAndCount(readQPS), 2)
+}
+
+func TestPrometheusSinkReportNodes(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	sink := newPrometheusSinkWithRegisterer(registry, "onebox", "pegasus", "")
+
+	sink.ReportNodes([]aggregate.NodeStat{
+		{Addr: "127.0.0.1:34801", Stats: map[string]float64{"sst_storage_mb": 100}},
+		{Addr: "127.0.0.1:34802", Stats: map[string]float64{"sst_storage_mb": 60}},
+	})
+	assert.Equal(t, testutil.ToFloat64(sink.nodeStorage.WithLabelValues("onebox", "127.0.0.1:34801")), float64(100))
+	assert.Equal(t, testutil.CollectAndCount(sink.nodeStorage), 2)
+
+	// the absent node is removed
+	sink.ReportNodes([]aggregate.NodeStat{
+		{Addr: "127.0.0.1:34801", Stats: map[string]float64{"sst_storage_mb": 110}},
+	})
+	assert.Equal(t, testutil.ToFloat64(sink.nodeStorage.WithLabelValues("onebox", "127.0.0.1:34801")), float64(110))
+	assert.Equal(t, testutil.CollectAndCount(sink.nodeStorage), 1)
+}
@@ -1,11 +1,32 @@
 package metrics
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
 	"github.com/pegasus-kv/collector/aggregate"
-	log "github.com/sirupsen/logrus"
+	"github.com/pegasus-kv/collector/election"
+	"github.com/pegasus-kv/collector/events"
+	"github.com/pegasus-kv/collector/export"
+	"github.com/pegasus-kv/collector/logging"
+	"github.com/pegasus-kv/collector/reload"
+	"github.com/pegasus-kv/collector/security"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/spf13/viper"
+	"gopkg.in/tomb.v2"
 )
 
+var log = logging.Module("metrics")
+
 // Sink is the destination where the metrics are reported to.
 type Sink interface {
 
@@ -13,24 +34,603 @@ type Sink interface {
 	Report(stats []aggregate.TableStats, allStats aggregate.ClusterStats)
 }
 
-// NewSink creates a Sink which reports metrics to the configured monitoring system.
-func NewSink() Sink {
-	var sink Sink
-	cfgSink := viper.Get("metrics.sink")
-	if cfgSink == "falcon" {
-		sink = newFalconSink()
-	} else if cfgSink == "prometheus" {
-		sink = newPrometheusSink()
-	} else {
-		log.Fatalf("invalid metrics_sink = %s", cfgSink)
-		return nil
+// NodeSink is a Sink which reports the stats of the replica nodes as well.
+type NodeSink interface {
+	Sink
+
+	// ReportNodes reports the stats of the replica nodes of a round.
+	ReportNodes(nodes []aggregate.NodeStat)
+}
+
+// EventSink is a Sink which exports the events of the cluster as well.
+type EventSink interface {
+	Sink
+
+	// ReportEvent exports an event recorded in the event log.
+	ReportEvent(e events.Event)
+}
+
+// ClusterSink is a Sink which keeps the metrics of each cluster until it's removed.
+type ClusterSink interface {
+	Sink
+
+	// RemoveCluster removes the metrics of the cluster that is no longer collected.
+	RemoveCluster(cluster string)
+}
+
+// SinkFactory creates a Sink from the configuration.
+type SinkFactory func() (Sink, error)
+
+var sinkFactories = make(map[string]SinkFactory)
+
+// RegisterSink makes a sink available by `name` in the config "metrics.sinks".
+// It panics if the name has been registered.
+func RegisterSink(name string, factory SinkFactory) {
+	if _, found := sinkFactories[name]; found {
+		panic(fmt.Sprintf("sink \"%s\" is registered twice", name))
 	}
+	sinkFactories[name] = factory
+}
 
-	aggregate.AddHookAfterTableStatEmitted(func(stats []aggregate.TableStats, allStats aggregate.ClusterStats) {
-		go func() {
-			sink.Report(stats, allStats)
-		}()
+// RegisteredSinks returns the names of all registered sinks in alphabetical order.
+func RegisteredSinks() []string {
+	var names []string
+	for name := range sinkFactories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func init() {
+	RegisterSink("falcon", func() (Sink, error) {
+		return newFalconSink(), nil
 	})
+	RegisterSink("influxdb", func() (Sink, error) {
+		return newInfluxDBSink(), nil
+	})
+	RegisterSink("kafka", func() (Sink, error) {
+		return newKafkaSink()
+	})
+	RegisterSink("opentsdb", func() (Sink, error) {
+		return newOpenTSDBSink(), nil
+	})
+	RegisterSink("otlp", func() (Sink, error) {
+		exporter, err := export.NewOTLPExporter(export.OTLPConfig{
+			Endpoint:           viper.GetString("otlp.endpoint"),
+			ClusterName:        viper.GetString("cluster_name"),
+			ResourceAttributes: viper.GetStringMapString("otlp.resource_attributes"),
+			Headers:            viper.GetStringMapString("otlp.headers"),
+			Insecure:           viper.GetBool("otlp.insecure"),
+			CAFile:             viper.GetString("otlp.ca_file"),
+			Interval:           viper.GetDuration("otlp.export_interval"),
+			Timeout:            viper.GetDuration("otlp.timeout"),
+			OnError:            func(error) { sinkFailed("otlp") },
+		})
+		if err != nil {
+			return nil, err
+		}
+		exporter.Start()
+		return exporter, nil
+	})
+	RegisterSink("prometheus", func() (Sink, error) {
+		return newPrometheusSink(), nil
+	})
+	RegisterSink("remote_write", func() (Sink, error) {
+		return export.NewRemoteWriteExporter(export.RemoteWriteConfig{
+			URL:                viper.GetString("remote_write.url"),
+			ClusterName:        viper.GetString("cluster_name"),
+			Username:           viper.GetString("remote_write.username"),
+			Password:           viper.GetString("remote_write.password"),
+			CAFile:             viper.GetString("remote_write.tls.ca_file"),
+			CertFile:           viper.GetString("remote_write.tls.cert_file"),
+			KeyFile:            viper.GetString("remote_write.tls.key_file"),
+			InsecureSkipVerify: viper.GetBool("remote_write.tls.insecure_skip_verify"),
+			Timeout:            viper.GetDuration("remote_write.timeout"),
+			OnError:            func(error) { sinkFailed("remote_write") },
+		})
+	})
+	prometheus.MustRegister(sinkReportDuration, sinkReportFailures)
+}
+
+// The metrics of the reports to the sinks, to tell whether the sinks are healthy or lagging.
+var (
+	sinkReportDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "collector_sink_report_duration_seconds",
+		Help:    "The duration of a report to the sink, which is buffered by some sinks and pushed later.",
+		Buckets: prometheus.ExponentialBuckets(0.005, 2, 12),
+	}, []string{"sink"})
+	sinkReportFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "collector_sink_report_failures_total",
+		Help: "The number of the failed pushes to the sink.",
+	}, []string{"sink"})
+)
+
+// sinkFailed counts a failed push to the sink.
+func sinkFailed(name string) {
+	sinkReportFailures.WithLabelValues(name).Inc()
+}
+
+// sinkNames is the registered name of the type of every sink created, which labels the metrics
+// of the reports.
+var sinkNames sync.Map
+
+// createSink creates the sink of the name through the registered factory.
+func createSink(name string) (Sink, error) {
+	sink, err := sinkFactories[name]()
+	if err != nil {
+		return nil, err
+	}
+	sinkNames.Store(reflect.TypeOf(sink), name)
+	return sink, nil
+}
+
+// nameOf returns the registered name of the sink, or its type if it's not created by
+// createSink.
+func nameOf(sink Sink) string {
+	if name, found := sinkNames.Load(reflect.TypeOf(sink)); found {
+		return name.(string)
+	}
+	return fmt.Sprintf("%T", sink)
+}
+
+// enabledSinks returns the sinks configured in "metrics.sinks", or the single "metrics.sink"
+// for compatibility.
+func enabledSinks() []string {
+	names := viper.GetStringSlice("metrics.sinks")
+	if len(names) == 0 && viper.GetString("metrics.sink") != "" {
+		names = []string{viper.GetString("metrics.sink")}
+	}
+	return names
+}
+
+func isSinkEnabled(name string) bool {
+	for _, n := range enabledSinks() {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// newSinks creates the sinks of `names` through the registered factories.
+func newSinks(names []string) ([]Sink, error) {
+	if err := validateSinks(names); err != nil {
+		return nil, err
+	}
+	var sinks []Sink
+	for _, name := range names {
+		sink, err := createSink(name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create sink \"%s\": %s", name, err)
+		}
+		sinks = append(sinks, sink)
+	}
+	return sinks, nil
+}
+
+// multiSink reports to every sink independently, so a slow sink doesn't block the others.
+// The reports in flight are tracked by the WaitGroup of the sinkSet, which are waited before
+// the sinks are closed.
+type multiSink []Sink
+
+// inflightReport is a report in flight of a sink.
+type inflightReport struct {
+	sink  string
+	start time.Time
+}
+
+var (
+	inflightLock sync.Mutex
+	// the id of the report -> the report, to tell the stalled sinks
+	inflight     = make(map[uint64]inflightReport)
+	nextReportID uint64
+)
+
+// goReport runs the report of the sink in a goroutine tracked by `reporting`.
+func goReport(reporting *sync.WaitGroup, sink Sink, report func()) {
+	inflightLock.Lock()
+	nextReportID++
+	id := nextReportID
+	name := nameOf(sink)
+	inflight[id] = inflightReport{sink: name, start: time.Now()}
+	inflightLock.Unlock()
+
+	reporting.Add(1)
+	go func() {
+		defer reporting.Done()
+		start := time.Now()
+		defer func() {
+			sinkReportDuration.WithLabelValues(name).Observe(time.Since(start).Seconds())
+			inflightLock.Lock()
+			delete(inflight, id)
+			inflightLock.Unlock()
+		}()
+		report()
+	}()
+}
+
+// StalledSinks returns the names of the sinks, sorted, having any report in flight for longer
+// than `age`, e.g. blocked by an unresponsive endpoint.
+func StalledSinks(age time.Duration) []string {
+	inflightLock.Lock()
+	defer inflightLock.Unlock()
+	stalled := make(map[string]bool)
+	for _, r := range inflight {
+		if time.Since(r.start) > age {
+			stalled[r.sink] = true
+		}
+	}
+	res := make([]string, 0, len(stalled))
+	for sink := range stalled {
+		res = append(res, sink)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// isLeader returns whether this collector is the leader, which is replaced in the tests.
+var isLeader = election.IsLeader
+
+// reportable returns whether the sink is reported by this collector. Only the leader of the
+// election pushes to the sinks, so that the metrics are not reported twice, while the
+// prometheus sink is scraped rather than pushed, which is kept up to date on the standbys.
+func reportable(sink Sink) bool {
+	if _, scraped := sink.(*prometheusSink); scraped {
+		return true
+	}
+	return isLeader()
+}
+
+func (m multiSink) report(reporting *sync.WaitGroup, stats []aggregate.TableStats, allStats aggregate.ClusterStats) {
+	for _, sink := range m {
+		if reportable(sink) {
+			sink := sink
+			goReport(reporting, sink, func() { sink.Report(stats, allStats) })
+		}
+	}
+}
+
+// reportNodes reports to every NodeSink independently.
+func (m multiSink) reportNodes(reporting *sync.WaitGroup, nodes []aggregate.NodeStat) {
+	for _, sink := range m {
+		if ns, ok := sink.(NodeSink); ok && reportable(sink) {
+			goReport(reporting, sink, func() { ns.ReportNodes(nodes) })
+		}
+	}
+}
+
+func (m multiSink) hasNodeSinks() bool {
+	for _, sink := range m {
+		if _, ok := sink.(NodeSink); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// reportEvent exports the event to every EventSink independently.
+func (m multiSink) reportEvent(reporting *sync.WaitGroup, e events.Event) {
+	for _, sink := range m {
+		if es, ok := sink.(EventSink); ok && reportable(sink) {
+			goReport(reporting, sink, func() { es.ReportEvent(e) })
+		}
+	}
+}
 
+func (m multiSink) hasEventSinks() bool {
+	for _, sink := range m {
+		if _, ok := sink.(EventSink); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// RemoveCluster removes the metrics of the cluster from every ClusterSink.
+func (m multiSink) RemoveCluster(cluster string) {
+	for _, sink := range m {
+		if cs, ok := sink.(ClusterSink); ok {
+			cs.RemoveCluster(cluster)
+		}
+	}
+}
+
+// close waits for the reports in flight tracked by `reporting`, and then closes the sinks holding
+// resources, e.g. the pending stats or the connections, so that the last stats are delivered
+// before exit. No more stats should be reported afterwards.
+func (m multiSink) close(reporting *sync.WaitGroup) {
+	reporting.Wait()
+	for _, sink := range m {
+		if c, ok := sink.(io.Closer); ok {
+			if err := c.Close(); err != nil {
+				log.Errorf("failed to close sink %T: %s", sink, err)
+			}
+		}
+	}
+}
+
+// clusterOf returns the cluster that the stats are tagged with, or the configured one if they're
+// not tagged, e.g. reported by the tests.
+func clusterOf(tagged string, configured string) string {
+	if tagged != "" {
+		return tagged
+	}
+	return configured
+}
+
+// sinkConfigKeys are the config keys of the sinks besides "cluster_name", a change of which
+// recreates the sink on reload. The sinks absent here are configured by the section of their
+// names, e.g. "influxdb".
+var sinkConfigKeys = map[string][]string{
+	"falcon": {"falcon_agent", "metrics.report_interval"},
+}
+
+func configKeysOf(name string) []string {
+	keys, found := sinkConfigKeys[name]
+	if !found {
+		keys = []string{name}
+	}
+	return append([]string{"cluster_name"}, keys...)
+}
+
+// sinkReloadKeys returns the config keys of all the registered sinks, and of the sinks enabled.
+func sinkReloadKeys() []string {
+	keys := []string{"metrics.sinks", "metrics.sink"}
+	seen := make(map[string]bool)
+	for _, name := range RegisteredSinks() {
+		for _, key := range configKeysOf(name) {
+			if !seen[key] {
+				seen[key] = true
+				keys = append(keys, key)
+			}
+		}
+	}
+	return keys
+}
+
+type sinkEntry struct {
+	name string
+	// nil if the sink failed to be created
+	sink     Sink
+	settings []interface{}
+}
+
+// sinkSet is the sinks enabled, which are recreated on reload once their config changes.
+type sinkSet struct {
+	lock    sync.RWMutex
+	entries []sinkEntry
+	sinks   multiSink
+
+	// the reports in flight of the sinks in this set only, so that closing the sinks doesn't
+	// wait for the reports of the other sets
+	reporting sync.WaitGroup
+
+	// whether the set is hooked to the node stats and the events, which are accessed by
+	// NewSink and the reloads only
+	nodesHooked  bool
+	eventsHooked bool
+}
+
+func (s *sinkSet) Report(stats []aggregate.TableStats, allStats aggregate.ClusterStats) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	s.sinks.report(&s.reporting, stats, allStats)
+}
+
+func (s *sinkSet) ReportNodes(nodes []aggregate.NodeStat) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	s.sinks.reportNodes(&s.reporting, nodes)
+}
+
+func (s *sinkSet) ReportEvent(e events.Event) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	s.sinks.reportEvent(&s.reporting, e)
+}
+
+func (s *sinkSet) RemoveCluster(cluster string) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	s.sinks.RemoveCluster(cluster)
+}
+
+// reload recreates the sinks whose config has changed, closes the sinks no longer enabled, and
+// keeps the others. The sinks failing to be created are retried on the next reload.
+func (s *sinkSet) reload() error {
+	names := enabledSinks()
+	if err := validateSinks(names); err != nil {
+		return err
+	}
+	err := s.apply(names)
+	s.hook()
+	return err
+}
+
+func validateSinks(names []string) error {
+	for _, name := range names {
+		if _, found := sinkFactories[name]; !found {
+			return fmt.Errorf("invalid sink \"%s\", available sinks are %v", name, RegisteredSinks())
+		}
+	}
+	return nil
+}
+
+func (s *sinkSet) apply(names []string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	unused := make(map[string][]sinkEntry)
+	for _, e := range s.entries {
+		unused[e.name] = append(unused[e.name], e)
+	}
+	entries := make([]sinkEntry, len(names))
+	for i, name := range names {
+		settings := reload.Snapshot(configKeysOf(name))
+		if olds := unused[name]; len(olds) != 0 && olds[0].sink != nil && reflect.DeepEqual(olds[0].settings, settings) {
+			entries[i] = olds[0]
+			unused[name] = olds[1:]
+			continue
+		}
+		entries[i] = sinkEntry{name: name, settings: settings}
+	}
+	// the replaced sinks are closed before the new ones are created, which may take the same
+	// resources, e.g. the names of the prometheus metrics
+	var closing multiSink
+	for _, olds := range unused {
+		for _, e := range olds {
+			if e.sink != nil {
+				log.Infof("close sink %s", e.name)
+				closing = append(closing, e.sink)
+			}
+		}
+	}
+	closing.close(&s.reporting)
+
+	var errs []string
+	s.sinks = nil
+	for i := range entries {
+		e := &entries[i]
+		if e.sink == nil {
+			sink, err := createSink(e.name)
+			if err != nil {
+				errs = append(errs, fmt.Sprintf("failed to create sink \"%s\": %s", e.name, err))
+				e.settings = nil
+				continue
+			}
+			log.Infof("create sink %s", e.name)
+			e.sink = sink
+		}
+		s.sinks = append(s.sinks, e.sink)
+	}
+	s.entries = entries
+	if len(errs) != 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// hook adds the hooks of the node stats and the events once any sink reports them. It's called
+// without the lock, which is taken by the hooks.
+func (s *sinkSet) hook() {
+	s.lock.RLock()
+	hasNodeSinks, hasEventSinks := s.sinks.hasNodeSinks(), s.sinks.hasEventSinks()
+	s.lock.RUnlock()
+	// the node stats are aggregated only if they're hooked
+	if hasNodeSinks && !s.nodesHooked {
+		aggregate.AddHookAfterClusterNodeStatsEmitted(s.ReportNodes)
+		s.nodesHooked = true
+	}
+	if hasEventSinks && !s.eventsHooked {
+		events.AddHookAfterEventRecorded(s.ReportEvent)
+		s.eventsHooked = true
+	}
+}
+
+// Close closes all sinks.
+func (s *sinkSet) Close() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.sinks.close(&s.reporting)
+	s.sinks = nil
+	s.entries = nil
+}
+
+var (
+	defaultLock sync.Mutex
+	defaultSink []*sinkSet
+)
+
+// setSinkDefaults sets the defaults of the sink configs once at startup, rather than by the
+// sinks recreated on reload.
+func setSinkDefaults() {
+	viper.SetDefault("falcon_agent.endpoint", "{cluster}")
+	viper.SetDefault("falcon_agent.batch_size", 500)
+	viper.SetDefault("falcon_agent.retry_times", 3)
+	viper.SetDefault("falcon_agent.retry_interval", time.Second)
+	viper.SetDefault("falcon_agent.timeout", 5*time.Second)
+
+	viper.SetDefault("influxdb.version", 1)
+	viper.SetDefault("influxdb.measurements.table", "pegasus_table")
+	viper.SetDefault("influxdb.measurements.node", "pegasus_node")
+	viper.SetDefault("influxdb.measurements.cluster", "pegasus_cluster")
+	viper.SetDefault("influxdb.batch_size", 5000)
+	viper.SetDefault("influxdb.timeout", 5*time.Second)
+
+	viper.SetDefault("kafka.batch_size", 100)
+	viper.SetDefault("kafka.timeout", 10*time.Second)
+
+	viper.SetDefault("opentsdb.metric_prefix", "pegasus.")
+	viper.SetDefault("opentsdb.tags.cluster", "cluster")
+	viper.SetDefault("opentsdb.tags.table", "table")
+	viper.SetDefault("opentsdb.tags.node", "node")
+	viper.SetDefault("opentsdb.chunk_size", 50)
+	viper.SetDefault("opentsdb.timeout", 5*time.Second)
+}
+
+// NewSink creates a Sink which reports metrics to all the configured monitoring systems,
+// after every aggregation of every cluster. The sinks are recreated once their config is
+// changed by a reload. It's closed by Close.
+func NewSink() Sink {
+	setSinkDefaults()
+	sink := &sinkSet{}
+	names := enabledSinks()
+	err := validateSinks(names)
+	if err == nil {
+		err = sink.apply(names)
+	}
+	if err != nil {
+		log.Fatal(err)
+		return nil
+	}
+	defaultLock.Lock()
+	defaultSink = append(defaultSink, sink)
+	defaultLock.Unlock()
+	aggregate.AddHookAfterClusterStatsEmitted(sink.Report)
+	sink.hook()
+	aggregate.AddHookAfterClusterRemoved(sink.RemoveCluster)
+	reload.AddHookAfterChanged(sinkReloadKeys(), sink.reload)
 	return sink
 }
+
+// Start reports the stats of every aggregation to the configured sink. For the prometheus
+// sink, the metrics are exposed on "/metrics" of the "prometheus.exposer_port" until the
+// collector shuts down. The exposer isn't changed by a reload, which requires a restart.
+func Start(tom *tomb.Tomb) {
+	NewSink()
+	port := viper.GetInt("prometheus.exposer_port")
+	if !isSinkEnabled("prometheus") || port == 0 {
+		return
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	srv := &http.Server{
+		Addr:    fmt.Sprintf(":%d", port),
+		Handler: mux,
+	}
+	go func() {
+		<-tom.Dying()
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(ctx)
+	}()
+
+	log.Infof("expose prometheus metrics on %s", srv.Addr)
+	if err := security.ListenAndServe(srv); err != nil && err != http.ErrServerClosed {
+		log.Errorf("prometheus exposer terminates: %s", err)
+	}
+}
+
+// Close flushes and closes the sinks created by NewSink on shutdown, once the stats are no
+// longer aggregated.
+func Close() {
+	defaultLock.Lock()
+	sinks := defaultSink
+	defaultSink = nil
+	defaultLock.Unlock()
+	for _, sink := range sinks {
+		sink.Close()
+	}
+}
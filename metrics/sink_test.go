@@ -0,0 +1,269 @@
+package metrics
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pegasus-kv/collector/aggregate"
+	"github.com/pegasus-kv/collector/election"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+type countingSink struct {
+	wg    *sync.WaitGroup
+	lock  sync.Mutex
+	count int
+}
+
+func (s *countingSink) Report(stats []aggregate.TableStats, allStats aggregate.ClusterStats) {
+	s.lock.Lock()
+	s.count++
+	s.lock.Unlock()
+	s.wg.Done()
+}
+
+func TestSinkRegistry(t *testing.T) {
+	wg := &sync.WaitGroup{}
+	counting := &countingSink{wg: wg}
+	RegisterSink("test_counting", func() (Sink, error) {
+		return counting, nil
+	})
+	defer delete(sinkFactories, "test_counting")
+
+	assert.Panics(t, func() {
+		RegisterSink("test_counting", nil)
+	})
+	assert.Contains(t, RegisteredSinks(), "test_counting")
+	assert.Contains(t, RegisteredSinks(), "falcon")
+
+	_, err := newSinks([]string{"test_counting", "no_such_sink"})
+	assert.NotNil(t, err)
+
+	sinks, err := newSinks([]string{"test_counting", "test_counting"})
+	assert.Nil(t, err)
+	wg.Add(2)
+	(&sinkSet{sinks: sinks}).Report(nil, aggregate.ClusterStats{})
+	wg.Wait()
+	assert.Equal(t, counting.count, 2)
+}
+
+func TestEnabledSinks(t *testing.T) {
+	defer viper.Reset()
+
+	viper.Set("metrics.sink", "falcon")
+	assert.Equal(t, enabledSinks(), []string{"falcon"})
+
+	viper.Set("metrics.sinks", []string{"prometheus", "remote_write"})
+	assert.Equal(t, enabledSinks(), []string{"prometheus", "remote_write"})
+	assert.True(t, isSinkEnabled("prometheus"))
+	assert.False(t, isSinkEnabled("falcon"))
+}
+
+func TestStandbySink(t *testing.T) {
+	isLeader = func() bool { return false }
+	defer func() {
+		isLeader = election.IsLeader
+	}()
+
+	wg := &sync.WaitGroup{}
+	counting := &countingSink{wg: wg}
+	registry := prometheus.NewRegistry()
+	scraped := newPrometheusSinkWithRegisterer(registry, "onebox", "pegasus", "")
+	assert.False(t, reportable(counting))
+	assert.True(t, reportable(scraped))
+
+	// the pushed sink is skipped by the standby
+	(&sinkSet{sinks: multiSink{counting}}).Report(nil, aggregate.ClusterStats{})
+	assert.Equal(t, counting.count, 0)
+}
+
+// closingSink records the reports and whether it's closed.
+type closingSink struct {
+	lock    sync.Mutex
+	reports int
+	closed  bool
+}
+
+func (s *closingSink) Report(stats []aggregate.TableStats, allStats aggregate.ClusterStats) {
+	time.Sleep(10 * time.Millisecond)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if !s.closed {
+		s.reports++
+	}
+}
+
+func (s *closingSink) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.closed = true
+	return nil
+}
+
+func TestSinkClose(t *testing.T) {
+	isLeader = func() bool { return true }
+	defer func() {
+		isLeader = election.IsLeader
+	}()
+
+	closing := &closingSink{}
+	sink := &sinkSet{sinks: multiSink{closing}}
+	for i := 0; i < 3; i++ {
+		sink.Report(nil, aggregate.ClusterStats{})
+	}
+	// the reports in flight are delivered before the sink is closed
+	sink.Close()
+	assert.Equal(t, closing.reports, 3)
+	assert.True(t, closing.closed)
+}
+
+// blockingSink blocks the reports until it's released.
+type blockingSink struct {
+	release chan struct{}
+}
+
+func (s *blockingSink) Report(stats []aggregate.TableStats, allStats aggregate.ClusterStats) {
+	<-s.release
+}
+
+func TestStalledSinks(t *testing.T) {
+	isLeader = func() bool { return true }
+	defer func() {
+		isLeader = election.IsLeader
+	}()
+
+	blocking := &blockingSink{release: make(chan struct{})}
+	sink := &sinkSet{sinks: multiSink{blocking, &closingSink{}}}
+	sink.Report(nil, aggregate.ClusterStats{})
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, StalledSinks(30*time.Millisecond), []string{"*metrics.blockingSink"})
+	assert.Equal(t, StalledSinks(time.Minute), []string{})
+
+	close(blocking.release)
+	sink.Close()
+	assert.Equal(t, StalledSinks(0), []string{})
+}
+
+func TestSinkSetsCloseIndependently(t *testing.T) {
+	isLeader = func() bool { return true }
+	defer func() {
+		isLeader = election.IsLeader
+	}()
+
+	blocking := &blockingSink{release: make(chan struct{})}
+	stalled := &sinkSet{sinks: multiSink{blocking}}
+	stalled.Report(nil, aggregate.ClusterStats{})
+
+	// the report in flight of another set isn't waited
+	closing := &closingSink{}
+	set := &sinkSet{sinks: multiSink{closing}}
+	set.Report(nil, aggregate.ClusterStats{})
+	set.Close()
+	assert.Equal(t, closing.reports, 1)
+	assert.True(t, closing.closed)
+
+	close(blocking.release)
+	stalled.Close()
+}
+
+func TestSinkReportMetrics(t *testing.T) {
+	isLeader = func() bool { return true }
+	defer func() {
+		isLeader = election.IsLeader
+	}()
+	RegisterSink("test_blocking", func() (Sink, error) {
+		release := make(chan struct{})
+		close(release)
+		return &blockingSink{release: release}, nil
+	})
+	defer delete(sinkFactories, "test_blocking")
+
+	sinks, err := newSinks([]string{"test_blocking"})
+	assert.Nil(t, err)
+	assert.Equal(t, nameOf(sinks[0]), "test_blocking")
+	assert.Equal(t, nameOf(&closingSink{}), "*metrics.closingSink")
+
+	sinkReportDuration.Reset()
+	set := &sinkSet{sinks: sinks}
+	set.Report(nil, aggregate.ClusterStats{})
+	set.Close()
+	assert.Equal(t, testutil.CollectAndCount(sinkReportDuration), 1)
+
+	sinkFailed("test_blocking")
+	assert.Equal(t, testutil.ToFloat64(sinkReportFailures.WithLabelValues("test_blocking")), float64(1))
+}
+
+func TestSinkReload(t *testing.T) {
+	isLeader = func() bool { return true }
+	defer func() {
+		isLeader = election.IsLeader
+	}()
+	defer viper.Reset()
+	var created []*closingSink
+	for _, name := range []string{"test_a", "test_b"} {
+		RegisterSink(name, func() (Sink, error) {
+			if viper.GetBool("test_b.fail") {
+				return nil, errors.New("failed")
+			}
+			s := &closingSink{}
+			created = append(created, s)
+			return s, nil
+		})
+		defer delete(sinkFactories, name)
+	}
+
+	viper.Set("metrics.sinks", []string{"test_a", "test_b"})
+	viper.Set("test_a.url", "http://127.0.0.1:8080")
+	s := &sinkSet{}
+	assert.Nil(t, s.apply(enabledSinks()))
+	assert.Equal(t, len(s.sinks), 2)
+	a, b := created[0], created[1]
+
+	// only the changed sink is recreated
+	viper.Set("test_b.url", "http://127.0.0.1:8081")
+	assert.Nil(t, s.reload())
+	assert.Equal(t, len(created), 3)
+	assert.Equal(t, s.sinks, multiSink{a, created[2]})
+	assert.False(t, a.closed)
+	assert.True(t, b.closed)
+
+	// the sink no longer enabled is closed
+	viper.Set("metrics.sinks", []string{"test_b"})
+	assert.Nil(t, s.reload())
+	assert.Equal(t, s.sinks, multiSink{created[2]})
+	assert.True(t, a.closed)
+
+	// the invalid sinks are rejected
+	viper.Set("metrics.sinks", []string{"no_such_sink"})
+	assert.NotNil(t, s.reload())
+	assert.Equal(t, s.sinks, multiSink{created[2]})
+
+	// the sink failing to be created is retried on the next reload
+	viper.Set("metrics.sinks", []string{"test_b"})
+	viper.Set("test_b.fail", true)
+	assert.NotNil(t, s.reload())
+	assert.Equal(t, len(s.sinks), 0)
+	viper.Set("test_b.fail", false)
+	assert.Nil(t, s.reload())
+	assert.Equal(t, len(s.sinks), 1)
+
+	s.Report(nil, aggregate.ClusterStats{})
+	s.Close()
+	assert.Equal(t, created[3].reports, 1)
+	assert.True(t, created[3].closed)
+}
+
+func TestPrometheusSinkClose(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	sink := newPrometheusSinkWithRegisterer(registry, "onebox", "pegasus", "")
+	assert.Nil(t, sink.Close())
+	// the gauges are registered again by the new sink
+	assert.NotPanics(t, func() {
+		newPrometheusSinkWithRegisterer(registry, "onebox", "pegasus", "")
+	})
+}
@@ -0,0 +1,108 @@
+package output
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pegasus-kv/collector/aggregate"
+)
+
+// summaryMetrics are the columns of the tables in the summary.
+var summaryMetrics = []string{
+	"read_qps",
+	"write_qps",
+	"read_bytes",
+	"write_bytes",
+}
+
+// FormatClusterSummary returns a human-readable report of the cluster: the cluster totals,
+// followed by the top `topN` tables by write QPS. All tables are listed if `topN` is not positive.
+func FormatClusterSummary(tables []*aggregate.TableStats, topN int) string {
+	totals := make(map[string]float64)
+	var collectedAt time.Time
+	for _, tb := range tables {
+		for _, name := range summaryMetrics {
+			totals[name] += tb.Stats[name]
+		}
+		if tb.Timestamp.After(collectedAt) {
+			collectedAt = tb.Timestamp
+		}
+	}
+
+	sorted := aggregate.SortTableStats(tables, "write_qps", true)
+	if topN > 0 && len(sorted) > topN {
+		sorted = sorted[:topN]
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "Cluster (%d tables)\n", len(tables))
+	writeTable(&buf, [][]string{
+		append([]string{""}, upper(summaryMetrics)...),
+		append([]string{"total"}, formatValues(totals)...),
+	})
+
+	fmt.Fprintf(&buf, "\nTop %d tables by write_qps\n", len(sorted))
+	rows := [][]string{append([]string{"TABLE", "APP_ID", "PARTITIONS"}, upper(summaryMetrics)...)}
+	for _, tb := range sorted {
+		row := []string{tb.TableName, fmt.Sprint(tb.AppID), fmt.Sprint(len(tb.Partitions))}
+		rows = append(rows, append(row, formatValues(tb.Stats)...))
+	}
+	writeTable(&buf, rows)
+
+	if !collectedAt.IsZero() {
+		fmt.Fprintf(&buf, "\nCollected at %s\n", collectedAt.Format("2006-01-02 15:04:05"))
+	}
+	return buf.String()
+}
+
+// writeTable writes the rows with aligned columns. The first row is the header, which is
+// followed by a separator line. The first column is left-aligned, and the others are right-aligned.
+func writeTable(buf *bytes.Buffer, rows [][]string) {
+	widths := make([]int, len(rows[0]))
+	for _, row := range rows {
+		for i, cell := range row {
+			if len(cell) > widths[i] {
+				widths[i] = len(cell)
+			}
+		}
+	}
+	writeRow := func(row []string) {
+		var cells []string
+		for i, cell := range row {
+			if i == 0 {
+				cells = append(cells, fmt.Sprintf("%-*s", widths[i], cell))
+			} else {
+				cells = append(cells, fmt.Sprintf("%*s", widths[i], cell))
+			}
+		}
+		buf.WriteString(strings.Join(cells, "  ") + "\n")
+	}
+
+	writeRow(rows[0])
+	totalWidth := 2 * (len(widths) - 1)
+	for _, w := range widths {
+		totalWidth += w
+	}
+	buf.WriteString(strings.Repeat("-", totalWidth) + "\n")
+	for _, row := range rows[1:] {
+		writeRow(row)
+	}
+}
+
+func formatValues(stats map[string]float64) []string {
+	var values []string
+	for _, name := range summaryMetrics {
+		values = append(values, fmt.Sprintf("%.2f", stats[name]))
+	}
+	return values
+}
+
+func upper(names []string) []string {
+	var res []string
+	for _, name := range names {
+		res = append(res, strings.ToUpper(name))
+	}
+	return res
+}
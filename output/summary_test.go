@@ -0,0 +1,28 @@
+package output
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pegasus-kv/collector/aggregate"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFormatClusterSummary(t *testing.T) {
+	now := time.Date(2020, 12, 1, 10, 0, 0, 0, time.Local)
+	tables := []*aggregate.TableStats{
+		{TableName: "temp", AppID: 1, Timestamp: now, Stats: map[string]float64{"write_qps": 10, "read_qps": 100}},
+		{TableName: "stat", AppID: 2, Timestamp: now, Stats: map[string]float64{"write_qps": 300}},
+		{TableName: "usage", AppID: 3, Timestamp: now, Stats: map[string]float64{"write_qps": 20}},
+	}
+
+	summary := FormatClusterSummary(tables, 2)
+	assert.Contains(t, summary, "Cluster (3 tables)")
+	assert.Contains(t, summary, "330.00")
+	assert.Contains(t, summary, "Collected at 2020-12-01 10:00:00")
+
+	// sorted by write_qps, and only the top 2 tables are listed
+	assert.Less(t, strings.Index(summary, "stat"), strings.Index(summary, "usage"))
+	assert.NotContains(t, summary, "temp")
+}
@@ -0,0 +1,138 @@
+// Package reload re-reads the config file at runtime, on SIGHUP or by the admin API, and
+// notifies the components whose config has changed, which then recreate themselves from the
+// new config without restarting the collector.
+package reload
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"reflect"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/pegasus-kv/collector/logging"
+	"github.com/spf13/viper"
+	"gopkg.in/tomb.v2"
+)
+
+var log = logging.Module("reload")
+
+// HookAfterChanged is a hook of event that any of its config keys is changed by a reload. It
+// returns an error if the component fails to apply the new config, keeping the old one.
+type HookAfterChanged func() error
+
+type hook struct {
+	keys []string
+	fn   HookAfterChanged
+}
+
+var (
+	lock  sync.Mutex
+	hooks []hook
+)
+
+// AddHookAfterChanged adds a hook of event that any of the config keys is changed by a reload.
+// A key covers the keys under it as well, e.g. "alerting" covers "alerting.rules".
+func AddHookAfterChanged(keys []string, hk HookAfterChanged) {
+	lock.Lock()
+	defer lock.Unlock()
+	hooks = append(hooks, hook{keys: keys, fn: hk})
+}
+
+// Snapshot returns a copy of the current values of the config keys, which is unaffected by the
+// later changes of the config, to be compared with reflect.DeepEqual.
+func Snapshot(keys []string) []interface{} {
+	values := make([]interface{}, len(keys))
+	for i, key := range keys {
+		values[i] = deepCopy(viper.Get(key))
+	}
+	return values
+}
+
+// deepCopy copies the maps and the slices of a config value, which may be updated in place by
+// viper.Set.
+func deepCopy(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		res := make(map[string]interface{}, len(v))
+		for k, e := range v {
+			res[k] = deepCopy(e)
+		}
+		return res
+	case map[interface{}]interface{}:
+		res := make(map[interface{}]interface{}, len(v))
+		for k, e := range v {
+			res[k] = deepCopy(e)
+		}
+		return res
+	case []interface{}:
+		res := make([]interface{}, len(v))
+		for i, e := range v {
+			res[i] = deepCopy(e)
+		}
+		return res
+	default:
+		return v
+	}
+}
+
+// snapshot returns the values of the keys of every hook.
+func snapshot() [][]interface{} {
+	values := make([][]interface{}, len(hooks))
+	for i, hk := range hooks {
+		values[i] = Snapshot(hk.keys)
+	}
+	return values
+}
+
+// Reload re-reads the config file, and calls the hooks whose keys have changed in the order
+// they're added. The config is unchanged if the file fails to be read, e.g. it's malformed,
+// otherwise the errors of the hooks are returned after all hooks are called.
+func Reload() error {
+	lock.Lock()
+	defer lock.Unlock()
+
+	before := snapshot()
+	if err := viper.ReadInConfig(); err != nil {
+		return fmt.Errorf("failed to read config: %s", err)
+	}
+	after := snapshot()
+	changed := 0
+	var errs []string
+	for i, hk := range hooks {
+		if reflect.DeepEqual(before[i], after[i]) {
+			continue
+		}
+		log.Infof("reload the components of config %v", hk.keys)
+		changed++
+		if err := hk.fn(); err != nil {
+			log.Errorf("failed to reload the components of config %v: %s", hk.keys, err)
+			errs = append(errs, fmt.Sprintf("%v: %s", hk.keys, err))
+		}
+	}
+	log.Infof("config %s is reloaded, %d components are changed", viper.ConfigFileUsed(), changed)
+	if len(errs) != 0 {
+		return fmt.Errorf("failed to reload config: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// Start reloads the config on every SIGHUP until the tomb dies.
+func Start(tom *tomb.Tomb) {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGHUP)
+	defer signal.Stop(sigs)
+	for {
+		select {
+		case <-tom.Dying():
+			return
+		case <-sigs:
+			log.Info("got signal SIGHUP to reload config")
+			if err := Reload(); err != nil {
+				log.Error(err)
+			}
+		}
+	}
+}
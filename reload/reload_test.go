@@ -0,0 +1,78 @@
+package reload
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReload(t *testing.T) {
+	defer viper.Reset()
+	defer func() {
+		hooks = nil
+	}()
+	dir, err := ioutil.TempDir("", "reload")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "config.yml")
+	write := func(content string) {
+		assert.Nil(t, ioutil.WriteFile(file, []byte(content), 0600))
+	}
+	write("metrics:\n  report_interval: 10s\nalerting:\n  rules: []\n")
+	viper.SetConfigFile(file)
+	viper.SetConfigType("yaml")
+	assert.Nil(t, viper.ReadInConfig())
+
+	var calls []string
+	AddHookAfterChanged([]string{"metrics.report_interval"}, func() error {
+		calls = append(calls, "interval")
+		if viper.GetDuration("metrics.report_interval") == 0 {
+			return errors.New("invalid interval")
+		}
+		return nil
+	})
+	AddHookAfterChanged([]string{"alerting", "cluster_name"}, func() error {
+		calls = append(calls, "alerting")
+		return nil
+	})
+
+	// unchanged
+	assert.Nil(t, Reload())
+	assert.Equal(t, len(calls), 0)
+
+	write("metrics:\n  report_interval: 10s\nalerting:\n  rules: [{name: slow}]\n")
+	assert.Nil(t, Reload())
+	assert.Equal(t, calls, []string{"alerting"})
+
+	write("metrics:\n  report_interval: 5s\nalerting:\n  rules: [{name: slow}]\ncluster_name: onebox\n")
+	assert.Nil(t, Reload())
+	assert.Equal(t, calls, []string{"alerting", "interval", "alerting"})
+	assert.Equal(t, viper.GetString("metrics.report_interval"), "5s")
+
+	// the malformed config is not applied
+	write("metrics: [\n")
+	assert.NotNil(t, Reload())
+	assert.Equal(t, viper.GetString("metrics.report_interval"), "5s")
+	assert.Equal(t, len(calls), 3)
+
+	// the errors of the hooks are returned after all hooks are called
+	write("metrics:\n  report_interval: 0s\n")
+	assert.NotNil(t, Reload())
+	assert.Equal(t, calls, []string{"alerting", "interval", "alerting", "interval", "alerting"})
+}
+
+func TestSnapshot(t *testing.T) {
+	defer viper.Reset()
+	viper.Set("influxdb.url", "http://127.0.0.1:8086")
+	before := Snapshot([]string{"influxdb", "cluster_name"})
+	// viper.Set updates the map of "influxdb" in place
+	viper.Set("influxdb.timeout", "5s")
+	after := Snapshot([]string{"influxdb", "cluster_name"})
+	assert.NotEqual(t, before, after)
+	assert.Equal(t, before, []interface{}{map[string]interface{}{"url": "http://127.0.0.1:8086"}, nil})
+}
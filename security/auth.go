@@ -0,0 +1,158 @@
+package security
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// Role is what a user is allowed to do.
+type Role string
+
+// The roles of the users.
+const (
+	// Viewer can only read, i.e. GET and HEAD.
+	Viewer Role = "viewer"
+	// Admin can trigger the actions as well, e.g. starting the hotkey detection.
+	Admin Role = "admin"
+)
+
+// User is a user of the HTTP servers, who authenticates either by the basic auth of the name
+// and the password, or by the bearer token.
+type User struct {
+	Name     string
+	Password string
+	Token    string
+	Role     Role
+}
+
+// Validate checks the user.
+func (u *User) Validate() error {
+	if u.Name == "" {
+		return fmt.Errorf("the name of the user is required")
+	}
+	if u.Password == "" && u.Token == "" {
+		return fmt.Errorf("either the password or the token of user %s is required", u.Name)
+	}
+	if u.Role != Viewer && u.Role != Admin {
+		return fmt.Errorf("invalid role %q of user %s, which should be viewer or admin", u.Role, u.Name)
+	}
+	return nil
+}
+
+// Authenticator authenticates the requests, and authorizes them by the roles of the users.
+type Authenticator struct {
+	users []User
+}
+
+// NewAuthenticator returns an Authenticator of the users, whose names must be unique.
+func NewAuthenticator(users []User) (*Authenticator, error) {
+	names := make(map[string]bool)
+	for i := range users {
+		if err := users[i].Validate(); err != nil {
+			return nil, err
+		}
+		if names[users[i].Name] {
+			return nil, fmt.Errorf("duplicate user %q", users[i].Name)
+		}
+		names[users[i].Name] = true
+	}
+	return &Authenticator{users: users}, nil
+}
+
+// authenticate returns the user of the request, or nil if it's not authenticated.
+func (a *Authenticator) authenticate(r *http.Request) *User {
+	if name, password, ok := r.BasicAuth(); ok {
+		for i := range a.users {
+			u := &a.users[i]
+			// compare both anyway, so that the time doesn't tell whether the name exists
+			nameMatched := subtle.ConstantTimeCompare([]byte(u.Name), []byte(name)) == 1
+			passwordMatched := subtle.ConstantTimeCompare([]byte(u.Password), []byte(password)) == 1
+			if nameMatched && passwordMatched && u.Password != "" {
+				return u
+			}
+		}
+		return nil
+	}
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return nil
+	}
+	token := strings.TrimPrefix(auth, "Bearer ")
+	for i := range a.users {
+		u := &a.users[i]
+		if u.Token != "" && subtle.ConstantTimeCompare([]byte(u.Token), []byte(token)) == 1 {
+			return u
+		}
+	}
+	return nil
+}
+
+// allows returns whether the role is allowed to send the request.
+func allows(role Role, r *http.Request) bool {
+	if role == Admin {
+		return true
+	}
+	return r.Method == http.MethodGet || r.Method == http.MethodHead
+}
+
+// publicPaths are served to the anonymous requests, which are the probes of the health, e.g.
+// by Kubernetes and the load balancers, having no credential.
+var publicPaths = map[string]bool{
+	"/healthz": true,
+	"/readyz":  true,
+}
+
+// Wrap returns the handler that serves the requests authenticated and authorized only, except
+// the GETs of publicPaths. It responds 401 to the anonymous requests, and 403 to the requests
+// beyond the role.
+func (a *Authenticator) Wrap(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if publicPaths[r.URL.Path] && (r.Method == http.MethodGet || r.Method == http.MethodHead) {
+			h.ServeHTTP(w, r)
+			return
+		}
+		u := a.authenticate(r)
+		if u == nil {
+			w.Header().Set("WWW-Authenticate", `Basic realm="pegasus collector"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if !allows(u.Role, r) {
+			log.Warnf("user %s of role %s is forbidden to %s %s", u.Name, u.Role, r.Method, r.URL.Path)
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// UsersFromConfig parses the users of "http_auth.users", each of which is like
+// `{name: ops, password: "secret", role: admin}` or `{name: grafana, token: "secret", role: viewer}`.
+func UsersFromConfig() ([]User, error) {
+	var users []User
+	if err := viper.UnmarshalKey("http_auth.users", &users); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// protect wraps the handler with the Authenticator of "http_auth.users", or returns it as is
+// if there's no user.
+func protect(h http.Handler) (http.Handler, error) {
+	users, err := UsersFromConfig()
+	if err != nil {
+		return nil, err
+	}
+	if len(users) == 0 {
+		return h, nil
+	}
+	a, err := NewAuthenticator(users)
+	if err != nil {
+		return nil, err
+	}
+	return a.Wrap(h), nil
+}
@@ -0,0 +1,62 @@
+package security
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuthenticator(t *testing.T) {
+	a, err := NewAuthenticator([]User{
+		{Name: "ops", Password: "secret", Role: Admin},
+		{Name: "grafana", Token: "abc", Role: Viewer},
+	})
+	assert.Nil(t, err)
+	h := a.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+
+	serve := func(method string, setAuth func(r *http.Request)) int {
+		r := httptest.NewRequest(method, "/hotkeys/start", nil)
+		if setAuth != nil {
+			setAuth(r)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w.Code
+	}
+	assert.Equal(t, serve(http.MethodGet, nil), http.StatusUnauthorized)
+	assert.Equal(t, serve(http.MethodGet, func(r *http.Request) { r.SetBasicAuth("ops", "wrong") }), http.StatusUnauthorized)
+	assert.Equal(t, serve(http.MethodGet, func(r *http.Request) { r.SetBasicAuth("grafana", "") }), http.StatusUnauthorized)
+	assert.Equal(t, serve(http.MethodPost, func(r *http.Request) { r.SetBasicAuth("ops", "secret") }), http.StatusOK)
+
+	viewer := func(r *http.Request) { r.Header.Set("Authorization", "Bearer abc") }
+	assert.Equal(t, serve(http.MethodGet, viewer), http.StatusOK)
+	// the viewer can't trigger the actions
+	assert.Equal(t, serve(http.MethodPost, viewer), http.StatusForbidden)
+	assert.Equal(t, serve(http.MethodGet, func(r *http.Request) { r.Header.Set("Authorization", "Bearer abd") }), http.StatusUnauthorized)
+
+	// the health probes are anonymous
+	for _, path := range []string{"/healthz", "/readyz"} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		assert.Equal(t, w.Code, http.StatusOK)
+		w = httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, path, nil))
+		assert.Equal(t, w.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestInvalidUsers(t *testing.T) {
+	for _, users := range [][]User{
+		{{Password: "secret", Role: Admin}},
+		{{Name: "ops", Role: Admin}},
+		{{Name: "ops", Password: "secret", Role: "root"}},
+		{{Name: "ops", Password: "secret", Role: Admin}, {Name: "ops", Token: "abc", Role: Viewer}},
+	} {
+		_, err := NewAuthenticator(users)
+		assert.NotNil(t, err)
+	}
+}
@@ -0,0 +1,88 @@
+// Package security protects the HTTP servers of the collector, i.e. the web UI with the JSON
+// API, and the prometheus exposer, so that they can be exposed beyond a trusted network.
+package security
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/pegasus-kv/collector/logging"
+	"github.com/spf13/viper"
+)
+
+var log = logging.Module("security")
+
+// TLSConfig is the TLS configuration of the HTTP servers.
+type TLSConfig struct {
+	// The certificate and the private key of the servers in PEM. TLS is enabled if the
+	// certificate is given.
+	CertFile string `mapstructure:"cert_file"`
+	KeyFile  string `mapstructure:"key_file"`
+
+	// The clients are required to present the certificates signed by the CAs in PEM if it's
+	// given.
+	ClientCAFile string `mapstructure:"client_ca_file"`
+}
+
+// Enabled returns whether the servers serve HTTPS.
+func (cfg *TLSConfig) Enabled() bool {
+	return cfg.CertFile != ""
+}
+
+// ServerConfig loads the files into the tls.Config of a server.
+func (cfg *TLSConfig) ServerConfig() (*tls.Config, error) {
+	if cfg.KeyFile == "" {
+		return nil, fmt.Errorf("the key file of certificate %s is required", cfg.CertFile)
+	}
+	cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	tlsCfg := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if cfg.ClientCAFile != "" {
+		pem, err := ioutil.ReadFile(cfg.ClientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsCfg.ClientCAs = x509.NewCertPool()
+		if !tlsCfg.ClientCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate is found in %s", cfg.ClientCAFile)
+		}
+		tlsCfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return tlsCfg, nil
+}
+
+// TLSFromConfig returns the configuration of "http_tls".
+func TLSFromConfig() (TLSConfig, error) {
+	var cfg TLSConfig
+	err := viper.UnmarshalKey("http_tls", &cfg)
+	return cfg, err
+}
+
+// ListenAndServe serves HTTPS on the server if TLS is enabled by "http_tls", or HTTP otherwise.
+// The requests are authenticated if any user of "http_auth" is configured.
+func ListenAndServe(srv *http.Server) error {
+	cfg, err := TLSFromConfig()
+	if err != nil {
+		return err
+	}
+	if srv.Handler, err = protect(srv.Handler); err != nil {
+		return err
+	}
+	if !cfg.Enabled() {
+		return srv.ListenAndServe()
+	}
+	srv.TLSConfig, err = cfg.ServerConfig()
+	if err != nil {
+		return err
+	}
+	// the certificate is loaded into TLSConfig
+	return srv.ListenAndServeTLS("", "")
+}
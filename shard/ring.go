@@ -0,0 +1,65 @@
+package shard
+
+import (
+	"hash/crc32"
+	"sort"
+	"strconv"
+)
+
+// DefaultReplicas is the number of the virtual nodes of each member on the ring, which makes
+// the tables evenly distributed.
+const DefaultReplicas = 128
+
+// Ring is a consistent hash ring, where each key is owned by the first virtual node clockwise
+// from its hash. Once a member joins or leaves, only the keys of the virtual nodes next to it
+// are moved.
+type Ring struct {
+	members []string
+	// the hashes of the virtual nodes in ascending order
+	points []uint32
+	// the hash of a virtual node -> the member
+	owners map[uint32]string
+}
+
+// NewRing returns the Ring of the members, each of which has `replicas` virtual nodes.
+func NewRing(members []string, replicas int) *Ring {
+	r := &Ring{owners: make(map[uint32]string)}
+	r.members = append(r.members, members...)
+	sort.Strings(r.members)
+	for _, m := range r.members {
+		for i := 0; i < replicas; i++ {
+			h := crc32.ChecksumIEEE([]byte(m + "#" + strconv.Itoa(i)))
+			if _, found := r.owners[h]; found {
+				// the collision is resolved the same way on every collector, since the
+				// members are sorted
+				continue
+			}
+			r.owners[h] = m
+			r.points = append(r.points, h)
+		}
+	}
+	sort.Slice(r.points, func(i, j int) bool {
+		return r.points[i] < r.points[j]
+	})
+	return r
+}
+
+// Members returns the members sorted by name.
+func (r *Ring) Members() []string {
+	return r.members
+}
+
+// Owner returns the member owning the key, or "" if the ring is empty.
+func (r *Ring) Owner(key string) string {
+	if len(r.points) == 0 {
+		return ""
+	}
+	h := crc32.ChecksumIEEE([]byte(key))
+	i := sort.Search(len(r.points), func(i int) bool {
+		return r.points[i] >= h
+	})
+	if i == len(r.points) {
+		i = 0
+	}
+	return r.owners[r.points[i]]
+}
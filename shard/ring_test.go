@@ -0,0 +1,67 @@
+package shard
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRing(t *testing.T) {
+	assert.Equal(t, NewRing(nil, DefaultReplicas).Owner("onebox/stat"), "")
+
+	members := []string{"c1:34101", "c2:34101", "c3:34101"}
+	ring := NewRing(members, DefaultReplicas)
+	// the order of the members doesn't matter
+	reversed := NewRing([]string{"c3:34101", "c2:34101", "c1:34101"}, DefaultReplicas)
+	counts := make(map[string]int)
+	owners := make(map[string]string)
+	for i := 0; i < 3000; i++ {
+		key := fmt.Sprintf("onebox/table_%d", i)
+		owners[key] = ring.Owner(key)
+		counts[owners[key]]++
+		assert.Equal(t, reversed.Owner(key), owners[key])
+	}
+	for _, m := range members {
+		// roughly evenly distributed
+		assert.True(t, counts[m] > 600, "%s owns %d tables", m, counts[m])
+	}
+
+	// only the tables of the dead member are moved
+	shrunk := NewRing(members[:2], DefaultReplicas)
+	for key, owner := range owners {
+		if owner != "c3:34101" {
+			assert.Equal(t, shrunk.Owner(key), owner)
+		} else {
+			assert.NotEqual(t, shrunk.Owner(key), owner)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for _, cfg := range []Config{
+		{Path: "/pegasus/shards"},
+		{Servers: []string{"127.0.0.1:2181"}, Path: "pegasus/shards"},
+		{Servers: []string{"127.0.0.1:2181"}, Path: "/"},
+		{Servers: []string{"127.0.0.1:2181"}, Path: "/pegasus/shards", Replicas: -1},
+	} {
+		assert.NotNil(t, cfg.Validate())
+	}
+}
+
+func TestOwns(t *testing.T) {
+	// all tables are owned without the sharding
+	assert.True(t, Owns("onebox", "stat"))
+
+	viper.Set("sharding.zookeeper.servers", []string{"127.0.0.1:2181"})
+	defer viper.Set("sharding.zookeeper.servers", nil)
+	// no table is owned until joined
+	assert.False(t, Owns("onebox", "stat"))
+
+	g := &Group{cfg: Config{Replicas: DefaultReplicas}, id: "c1:34101"}
+	g.ring = NewRing([]string{"c1:34101"}, DefaultReplicas)
+	assert.True(t, g.Owns("onebox", "stat"))
+	g.ring = nil
+	assert.False(t, g.Owns("onebox", "stat"))
+}
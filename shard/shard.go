@@ -0,0 +1,298 @@
+// Package shard splits the tables of the clusters among the collectors through consistent
+// hashing, so that each collector collects and exports only its shard. The collectors join
+// the shard group through ZooKeeper, and the tables are rebalanced once any of them joins or
+// dies.
+package shard
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-zookeeper/zk"
+	"github.com/pegasus-kv/collector/logging"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/spf13/viper"
+	"gopkg.in/tomb.v2"
+)
+
+var log = logging.Module("shard")
+
+// Config is the configuration of the sharding.
+type Config struct {
+	// The ZooKeeper servers.
+	Servers []string
+
+	// The collectors joining under the same path share the tables.
+	Path string
+
+	// A collector is considered dead by ZooKeeper if its session times out, after which its
+	// tables are moved to the others.
+	SessionTimeout time.Duration `mapstructure:"session_timeout"`
+
+	// The number of the virtual nodes of each collector on the ring, DefaultReplicas if 0.
+	Replicas int
+}
+
+// Validate checks the configuration.
+func (cfg *Config) Validate() error {
+	if len(cfg.Servers) == 0 {
+		return fmt.Errorf("no ZooKeeper server of the sharding")
+	}
+	if !strings.HasPrefix(cfg.Path, "/") || path.Clean(cfg.Path) != cfg.Path || cfg.Path == "/" {
+		return fmt.Errorf("invalid path %q of the sharding, which should be absolute and not the root", cfg.Path)
+	}
+	if cfg.Replicas < 0 {
+		return fmt.Errorf("negative replicas of the sharding: %d", cfg.Replicas)
+	}
+	return nil
+}
+
+// retryInterval is the interval to join again after a failure.
+const retryInterval = 5 * time.Second
+
+// Group tracks the collectors of the shard group, and tells whether a table belongs to this
+// collector. Each collector joins with an ephemeral node named after its id, which is removed
+// by ZooKeeper once the session of the collector expires.
+type Group struct {
+	cfg Config
+	// the identity of this collector
+	id string
+
+	conn   *zk.Conn
+	events <-chan zk.Event
+
+	members prometheus.Gauge
+
+	lock sync.RWMutex
+	// nil if this collector is not in the group, e.g. the connection is lost, in which case it
+	// owns no table, since the others may have taken over its tables
+	ring *Ring
+}
+
+// NewGroup returns a Group connecting to the ZooKeeper in the background.
+func NewGroup(cfg Config, id string, registerer prometheus.Registerer) (*Group, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	if cfg.SessionTimeout <= 0 {
+		cfg.SessionTimeout = 10 * time.Second
+	}
+	if cfg.Replicas == 0 {
+		cfg.Replicas = DefaultReplicas
+	}
+	conn, events, err := zk.Connect(cfg.Servers, cfg.SessionTimeout, zk.WithLogger(log))
+	if err != nil {
+		return nil, err
+	}
+	g := &Group{
+		cfg:    cfg,
+		id:     id,
+		conn:   conn,
+		events: events,
+		members: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "shard_members",
+			Help: "The number of the collectors sharing the tables, 0 if this collector is not in the group.",
+		}),
+	}
+	registerer.MustRegister(g.members)
+	return g, nil
+}
+
+// Owns returns whether the table of the cluster belongs to this collector.
+func (g *Group) Owns(cluster, table string) bool {
+	g.lock.RLock()
+	defer g.lock.RUnlock()
+	return g.ring != nil && g.ring.Owner(cluster+"/"+table) == g.id
+}
+
+func (g *Group) setMembers(members []string) {
+	var ring *Ring
+	if len(members) != 0 {
+		ring = NewRing(members, g.cfg.Replicas)
+	}
+	g.lock.Lock()
+	prev := g.ring
+	g.ring = ring
+	g.lock.Unlock()
+	if prev == nil && ring == nil || prev != nil && ring != nil && equalStrings(prev.Members(), ring.Members()) {
+		return
+	}
+	if ring == nil {
+		log.Warnf("%s leaves the shard group %s, and collects no table", g.id, g.cfg.Path)
+		g.members.Set(0)
+		return
+	}
+	log.Infof("the tables are rebalanced among the shard group %s: %v", g.cfg.Path, ring.Members())
+	g.members.Set(float64(len(members)))
+}
+
+// Run joins the group and tracks its members until ctx is done, after which this collector
+// leaves, so that its tables are taken over immediately.
+func (g *Group) Run(ctx context.Context) {
+	defer g.conn.Close()
+	for {
+		var retry <-chan time.Time
+		watch, err := g.join()
+		if err != nil {
+			log.Errorf("failed to join the shard group %s, retry in %s: %s", g.cfg.Path, retryInterval, err)
+			g.setMembers(nil)
+			retry = time.After(retryInterval)
+		}
+		select {
+		case <-ctx.Done():
+			g.setMembers(nil)
+			if err := g.conn.Delete(path.Join(g.cfg.Path, g.id), -1); err != nil && err != zk.ErrNoNode {
+				log.Warnf("failed to leave the shard group %s: %s", g.cfg.Path, err)
+			}
+			return
+		case <-watch:
+		case <-retry:
+		case ev := <-g.events:
+			if ev.Type == zk.EventSession && ev.State != zk.StateHasSession {
+				g.setMembers(nil)
+			}
+		}
+	}
+}
+
+// join creates the node of this collector if it's absent, and updates the members. It returns
+// the watch of the members.
+func (g *Group) join() (<-chan zk.Event, error) {
+	if g.conn.State() != zk.StateHasSession {
+		return nil, fmt.Errorf("no session to ZooKeeper in state %s", g.conn.State())
+	}
+	if err := g.ensurePath(); err != nil {
+		return nil, err
+	}
+	node := path.Join(g.cfg.Path, g.id)
+	for {
+		members, _, watch, err := g.conn.ChildrenW(g.cfg.Path)
+		if err != nil {
+			return nil, err
+		}
+		if contains(members, g.id) {
+			_, stat, err := g.conn.Get(node)
+			if err == zk.ErrNoNode {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			if stat.EphemeralOwner == g.conn.SessionID() {
+				g.setMembers(members)
+				return watch, nil
+			}
+			// left by the previous session of this collector, e.g. before it restarted
+			if err := g.conn.Delete(node, stat.Version); err != nil && err != zk.ErrNoNode {
+				return nil, err
+			}
+			continue
+		}
+		_, err = g.conn.Create(node, nil, zk.FlagEphemeral, zk.WorldACL(zk.PermAll))
+		if err != nil && err != zk.ErrNodeExists {
+			return nil, err
+		}
+	}
+}
+
+// ensurePath creates the path and its parents if they're absent.
+func (g *Group) ensurePath() error {
+	p := ""
+	for _, part := range strings.Split(g.cfg.Path[1:], "/") {
+		p += "/" + part
+		_, err := g.conn.Create(p, nil, 0, zk.WorldACL(zk.PermAll))
+		if err != nil && err != zk.ErrNodeExists {
+			return fmt.Errorf("failed to create %s: %s", p, err)
+		}
+	}
+	return nil
+}
+
+func contains(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+var (
+	defaultLock  sync.RWMutex
+	defaultGroup *Group
+)
+
+// Default returns the Group run by Start, or nil if the sharding is disabled.
+func Default() *Group {
+	defaultLock.RLock()
+	defer defaultLock.RUnlock()
+	return defaultGroup
+}
+
+// Enabled returns whether the tables are sharded, i.e. any server of "sharding.zookeeper.servers"
+// is given.
+func Enabled() bool {
+	return len(viper.GetStringSlice("sharding.zookeeper.servers")) != 0
+}
+
+// Owns returns whether the table of the cluster belongs to this collector, which is always true
+// if the sharding is disabled, and false until this collector joins the group otherwise.
+func Owns(cluster, table string) bool {
+	if !Enabled() {
+		return true
+	}
+	g := Default()
+	return g != nil && g.Owns(cluster, table)
+}
+
+// FromConfig returns the configuration of "sharding.zookeeper".
+func FromConfig() (Config, error) {
+	var cfg Config
+	err := viper.UnmarshalKey("sharding.zookeeper", &cfg)
+	return cfg, err
+}
+
+// Start joins the shard group of "sharding.zookeeper" until the tomb dies. It returns
+// immediately if the sharding is disabled.
+func Start(tom *tomb.Tomb) {
+	if !Enabled() {
+		return
+	}
+	cfg, err := FromConfig()
+	if err != nil {
+		log.Fatal("failed to read the sharding config: ", err)
+		return
+	}
+	host, err := os.Hostname()
+	if err != nil {
+		host = "collector"
+	}
+	id := fmt.Sprintf("%s:%d", host, viper.GetInt("port"))
+	g, err := NewGroup(cfg, id, prometheus.DefaultRegisterer)
+	if err != nil {
+		log.Fatal("failed to start the sharding: ", err)
+		return
+	}
+	defaultLock.Lock()
+	defaultGroup = g
+	defaultLock.Unlock()
+	log.Infof("join the shard group %s as %s", cfg.Path, id)
+	g.Run(tom.Context(nil))
+}
@@ -0,0 +1,127 @@
+package store
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/pegasus-kv/collector/aggregate"
+)
+
+// MemoryStores keeps a MemoryStore for every cluster collected, which is created on the first
+// stats of the cluster.
+type MemoryStores struct {
+	cfg MemoryStoreConfig
+
+	lock     sync.RWMutex
+	clusters map[string]*MemoryStore
+}
+
+// NewMemoryStores returns the MemoryStores of no cluster.
+func NewMemoryStores(cfg MemoryStoreConfig) (*MemoryStores, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return &MemoryStores{cfg: cfg, clusters: make(map[string]*MemoryStore)}, nil
+}
+
+// Of returns the MemoryStore of the cluster, or nil if there's no stats of the cluster.
+func (s *MemoryStores) Of(cluster string) *MemoryStore {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.clusters[cluster]
+}
+
+// Append writes the stats of a round of the cluster told by ClusterStats.Cluster. It implements
+// aggregate.HookAfterTableStatEmitted.
+func (s *MemoryStores) Append(stats []aggregate.TableStats, allStats aggregate.ClusterStats) {
+	s.lock.Lock()
+	m, found := s.clusters[allStats.Cluster]
+	if !found {
+		// validated by NewMemoryStores
+		m, _ = NewMemoryStore(s.cfg)
+		s.clusters[allStats.Cluster] = m
+	}
+	s.lock.Unlock()
+
+	m.AppendTables(stats)
+	_ = m.Append(&allStats)
+}
+
+// DropTable removes the history of the table of the cluster. It implements
+// aggregate.HookAfterClusterTableDropped.
+func (s *MemoryStores) DropTable(cluster string, appID int) {
+	if m := s.Of(cluster); m != nil {
+		m.DropTable(appID)
+	}
+}
+
+// RemoveCluster removes the history of the cluster that is no longer collected.
+func (s *MemoryStores) RemoveCluster(cluster string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.clusters, cluster)
+}
+
+// diskStores keeps a DiskStatsStore for every cluster, under the subdirectory of the directory
+// named by the cluster.
+type diskStores struct {
+	dir string
+
+	lock     sync.Mutex
+	clusters map[string]*DiskStatsStore
+}
+
+func newDiskStores(dir string) *diskStores {
+	return &diskStores{dir: dir, clusters: make(map[string]*DiskStatsStore)}
+}
+
+// of returns the DiskStatsStore of the cluster, which is opened if it's not yet.
+func (s *diskStores) of(cluster string) (*DiskStatsStore, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	d, found := s.clusters[cluster]
+	if !found {
+		var err error
+		if d, err = NewDiskStatsStore(filepath.Join(s.dir, cluster)); err != nil {
+			return nil, err
+		}
+		s.clusters[cluster] = d
+	}
+	return d, nil
+}
+
+// Append persists the cluster stats of a round. It implements
+// aggregate.HookAfterTableStatEmitted.
+func (s *diskStores) Append(_ []aggregate.TableStats, allStats aggregate.ClusterStats) {
+	d, err := s.of(allStats.Cluster)
+	if err == nil {
+		err = d.Append(&allStats)
+	}
+	if err != nil {
+		log.WithField("cluster", allStats.Cluster).Errorf("failed to persist the cluster stats: %s", err)
+	}
+}
+
+// compact compacts the stores of all clusters under the directory, including those of the
+// clusters no longer collected.
+func (s *diskStores) compact(olderThan time.Time) error {
+	files, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		if !f.IsDir() {
+			continue
+		}
+		d, err := s.of(f.Name())
+		if err != nil {
+			return err
+		}
+		if err := d.Compact(olderThan); err != nil {
+			return err
+		}
+	}
+	return nil
+}
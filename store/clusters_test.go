@@ -0,0 +1,70 @@
+package store
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pegasus-kv/collector/aggregate"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryStores(t *testing.T) {
+	s, err := NewMemoryStores(MemoryStoreConfig{
+		Retention:          time.Hour,
+		RawRetention:       10 * time.Minute,
+		DownsampleInterval: 5 * time.Minute,
+	})
+	assert.Nil(t, err)
+	assert.Nil(t, s.Of("c1"))
+
+	now := time.Now()
+	// the tables of different clusters may have the same app ID
+	s.Append([]aggregate.TableStats{{TableName: "stat", AppID: 1, Timestamp: now, Stats: map[string]float64{"read_qps": 1}}},
+		aggregate.ClusterStats{Cluster: "c1", Timestamp: now, Stats: map[string]float64{"read_qps": 1}})
+	s.Append([]aggregate.TableStats{{TableName: "temp", AppID: 1, Timestamp: now, Stats: map[string]float64{"read_qps": 2}}},
+		aggregate.ClusterStats{Cluster: "c2", Timestamp: now, Stats: map[string]float64{"read_qps": 2}})
+	assert.Equal(t, s.Of("c1").Tables(), []string{"stat"})
+	assert.Equal(t, s.Of("c2").Tables(), []string{"temp"})
+	stats, _ := s.Of("c2").QueryRange(now.Add(-time.Minute), now)
+	assert.Equal(t, len(stats), 1)
+	assert.Equal(t, stats[0].Stats["read_qps"], float64(2))
+
+	s.DropTable("c1", 1)
+	assert.Equal(t, len(s.Of("c1").Tables()), 0)
+	assert.Equal(t, s.Of("c2").Tables(), []string{"temp"})
+
+	s.RemoveCluster("c2")
+	assert.Nil(t, s.Of("c2"))
+}
+
+func TestDiskStores(t *testing.T) {
+	dir, err := ioutil.TempDir("", "stores")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	s := newDiskStores(dir)
+	old := time.Date(2020, 11, 1, 0, 0, 0, 0, time.UTC)
+	now := time.Date(2020, 11, 19, 0, 0, 0, 0, time.UTC)
+	for _, c := range []string{"c1", "c2"} {
+		s.Append(nil, aggregate.ClusterStats{Cluster: c, Timestamp: old, Stats: map[string]float64{"read_qps": 1}})
+		s.Append(nil, aggregate.ClusterStats{Cluster: c, Timestamp: now, Stats: map[string]float64{"read_qps": 2}})
+	}
+	c1, err := NewDiskStatsStore(filepath.Join(dir, "c1"))
+	assert.Nil(t, err)
+	stats, err := c1.QueryRange(old, now)
+	assert.Nil(t, err)
+	assert.Equal(t, len(stats), 2)
+
+	// the stores of all clusters are compacted, including those opened by another process
+	assert.Nil(t, newDiskStores(dir).compact(now.Add(-24*time.Hour)))
+	for _, c := range []string{"c1", "c2"} {
+		d, err := s.of(c)
+		assert.Nil(t, err)
+		stats, err := d.QueryRange(old, now)
+		assert.Nil(t, err)
+		assert.Equal(t, len(stats), 1)
+	}
+}
@@ -0,0 +1,226 @@
+package store
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pegasus-kv/collector/aggregate"
+)
+
+const (
+	diskFilePrefix = "cluster-stats-"
+	diskFileSuffix = ".bin"
+	diskDayLayout  = "2006-01-02"
+	day            = 24 * time.Hour
+)
+
+// DiskStatsStore persists the ClusterStats to daily files under a directory, which are named
+// by the UTC date, e.g. "cluster-stats-2020-11-19.bin". Each record is encoded as:
+//
+//	timestamp (varint, unix nanoseconds)
+//	number of metrics (uvarint)
+//	for each metric: name length (uvarint), name, value (8 bytes, IEEE 754 little-endian)
+type DiskStatsStore struct {
+	lock sync.Mutex
+	dir  string
+}
+
+// NewDiskStatsStore returns a DiskStatsStore writing to `dir`, which is created if not existed.
+func NewDiskStatsStore(dir string) (*DiskStatsStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &DiskStatsStore{dir: dir}, nil
+}
+
+// Append implements StatsStore.
+func (s *DiskStatsStore) Append(stats *aggregate.ClusterStats) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	f, err := os.OpenFile(s.pathOf(stats.Timestamp), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(encodeRecord(stats))
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// QueryRange implements StatsStore.
+func (s *DiskStatsStore) QueryRange(start, end time.Time) ([]*aggregate.ClusterStats, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	days, err := s.listDays()
+	if err != nil {
+		return nil, err
+	}
+	var result []*aggregate.ClusterStats
+	for _, d := range days {
+		if d.Add(day).Before(start) || d.After(end) {
+			continue
+		}
+		records, err := s.readFile(s.pathOf(d))
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range records {
+			if !r.Timestamp.Before(start) && !r.Timestamp.After(end) {
+				result = append(result, r)
+			}
+		}
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].Timestamp.Before(result[j].Timestamp)
+	})
+	return result, nil
+}
+
+// Compact deletes the files whose records are all older than `olderThan`.
+func (s *DiskStatsStore) Compact(olderThan time.Time) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	days, err := s.listDays()
+	if err != nil {
+		return err
+	}
+	for _, d := range days {
+		if d.Add(day).After(olderThan) {
+			continue
+		}
+		if err := os.Remove(s.pathOf(d)); err != nil {
+			return err
+		}
+		log.Infof("removed the cluster stats of %s", d.Format(diskDayLayout))
+	}
+	return nil
+}
+
+func (s *DiskStatsStore) pathOf(t time.Time) string {
+	return filepath.Join(s.dir, diskFilePrefix+t.UTC().Format(diskDayLayout)+diskFileSuffix)
+}
+
+// listDays returns the days of the files in the directory, in ascending order.
+func (s *DiskStatsStore) listDays() ([]time.Time, error) {
+	files, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	var days []time.Time
+	for _, f := range files {
+		name := f.Name()
+		if f.IsDir() || !strings.HasPrefix(name, diskFilePrefix) || !strings.HasSuffix(name, diskFileSuffix) {
+			continue
+		}
+		d, err := time.Parse(diskDayLayout, strings.TrimSuffix(strings.TrimPrefix(name, diskFilePrefix), diskFileSuffix))
+		if err != nil {
+			// not written by this store
+			continue
+		}
+		days = append(days, d)
+	}
+	sort.Slice(days, func(i, j int) bool {
+		return days[i].Before(days[j])
+	})
+	return days, nil
+}
+
+func (s *DiskStatsStore) readFile(path string) ([]*aggregate.ClusterStats, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	var records []*aggregate.ClusterStats
+	for {
+		stats, err := decodeRecord(r)
+		if err == io.EOF {
+			return records, nil
+		}
+		if err == io.ErrUnexpectedEOF {
+			// the last record may be partially written if the process crashed
+			log.Warnf("ignore the truncated record at the end of %s", path)
+			return records, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %s", path, err)
+		}
+		records = append(records, stats)
+	}
+}
+
+func encodeRecord(stats *aggregate.ClusterStats) []byte {
+	buf := make([]byte, 0, 16+len(stats.Stats)*32)
+	var tmp [binary.MaxVarintLen64]byte
+
+	n := binary.PutVarint(tmp[:], stats.Timestamp.UnixNano())
+	buf = append(buf, tmp[:n]...)
+	n = binary.PutUvarint(tmp[:], uint64(len(stats.Stats)))
+	buf = append(buf, tmp[:n]...)
+	for name, value := range stats.Stats {
+		n = binary.PutUvarint(tmp[:], uint64(len(name)))
+		buf = append(buf, tmp[:n]...)
+		buf = append(buf, name...)
+		binary.LittleEndian.PutUint64(tmp[:8], math.Float64bits(value))
+		buf = append(buf, tmp[:8]...)
+	}
+	return buf
+}
+
+// maxMetricNameLen guards against allocating a huge buffer for a corrupted record.
+const maxMetricNameLen = 4096
+
+// decodeRecord returns io.EOF if there's no more record, or io.ErrUnexpectedEOF if the record is truncated.
+func decodeRecord(r *bufio.Reader) (*aggregate.ClusterStats, error) {
+	ts, err := binary.ReadVarint(r)
+	if err != nil {
+		return nil, err
+	}
+	count, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	stats := &aggregate.ClusterStats{
+		Timestamp: time.Unix(0, ts),
+		Stats:     make(map[string]float64),
+	}
+	for i := uint64(0); i < count; i++ {
+		nameLen, err := binary.ReadUvarint(r)
+		if err != nil {
+			return nil, unexpectedEOF(err)
+		}
+		if nameLen > maxMetricNameLen {
+			return nil, errors.New("corrupted record: metric name is too long")
+		}
+		buf := make([]byte, nameLen+8)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, unexpectedEOF(err)
+		}
+		stats.Stats[string(buf[:nameLen])] = math.Float64frombits(binary.LittleEndian.Uint64(buf[nameLen:]))
+	}
+	return stats, nil
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
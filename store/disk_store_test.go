@@ -0,0 +1,85 @@
+package store
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pegasus-kv/collector/aggregate"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestDiskStatsStore(t *testing.T) (*DiskStatsStore, func()) {
+	dir, err := ioutil.TempDir("", "collector-store")
+	assert.Nil(t, err)
+	s, err := NewDiskStatsStore(dir)
+	assert.Nil(t, err)
+	return s, func() {
+		os.RemoveAll(dir)
+	}
+}
+
+func TestDiskStatsStore(t *testing.T) {
+	s, cleanup := newTestDiskStatsStore(t)
+	defer cleanup()
+
+	day1 := time.Date(2020, 11, 19, 23, 0, 0, 0, time.UTC)
+	for i := 0; i < 3; i++ {
+		err := s.Append(&aggregate.ClusterStats{
+			Timestamp: day1.Add(time.Duration(i) * time.Hour),
+			Stats:     map[string]float64{"read_qps": float64(i), "write_qps": 1.5},
+		})
+		assert.Nil(t, err)
+	}
+	days, err := s.listDays()
+	assert.Nil(t, err)
+	assert.Equal(t, len(days), 2)
+
+	result, err := s.QueryRange(day1, day1.Add(time.Hour))
+	assert.Nil(t, err)
+	assert.Equal(t, len(result), 2)
+	assert.True(t, result[0].Timestamp.Equal(day1))
+	assert.Equal(t, result[1].Stats, map[string]float64{"read_qps": 1, "write_qps": 1.5})
+
+	result, err = s.QueryRange(day1.Add(-day), day1.Add(day))
+	assert.Nil(t, err)
+	assert.Equal(t, len(result), 3)
+
+	// only the file of 2020-11-19 is entirely older than the threshold
+	assert.Nil(t, s.Compact(day1.Add(90*time.Minute)))
+	result, err = s.QueryRange(day1.Add(-day), day1.Add(day))
+	assert.Nil(t, err)
+	assert.Equal(t, len(result), 2)
+	assert.Equal(t, result[0].Stats["read_qps"], float64(1))
+}
+
+func TestDiskStatsStoreTruncatedRecord(t *testing.T) {
+	s, cleanup := newTestDiskStatsStore(t)
+	defer cleanup()
+
+	now := time.Now()
+	for i := 0; i < 2; i++ {
+		assert.Nil(t, s.Append(&aggregate.ClusterStats{Timestamp: now, Stats: map[string]float64{"read_qps": 1}}))
+	}
+	// cut off the last byte of the second record
+	path := s.pathOf(now)
+	info, err := os.Stat(path)
+	assert.Nil(t, err)
+	assert.Nil(t, os.Truncate(path, info.Size()-1))
+
+	result, err := s.QueryRange(now.Add(-time.Minute), now.Add(time.Minute))
+	assert.Nil(t, err)
+	assert.Equal(t, len(result), 1)
+}
+
+func TestDiskStatsStoreIgnoreOtherFiles(t *testing.T) {
+	s, cleanup := newTestDiskStatsStore(t)
+	defer cleanup()
+
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(s.dir, "cluster-stats-latest.bin"), []byte("x"), 0644))
+	days, err := s.listDays()
+	assert.Nil(t, err)
+	assert.Equal(t, len(days), 0)
+}
@@ -0,0 +1,198 @@
+package store
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pegasus-kv/collector/aggregate"
+)
+
+// MemoryStoreConfig is the configuration of MemoryStore.
+type MemoryStoreConfig struct {
+	// The snapshots older than Retention are dropped.
+	Retention time.Duration
+
+	// The snapshots older than RawRetention are downsampled into the averages of every
+	// DownsampleInterval.
+	RawRetention       time.Duration
+	DownsampleInterval time.Duration
+}
+
+// Validate checks the configuration.
+func (cfg *MemoryStoreConfig) Validate() error {
+	if cfg.RawRetention <= 0 || cfg.RawRetention > cfg.Retention {
+		return errors.New("the raw retention must be positive and no longer than the retention")
+	}
+	if cfg.DownsampleInterval <= 0 {
+		return errors.New("the downsample interval must be positive")
+	}
+	return nil
+}
+
+// MemoryStore keeps the recent history of the ClusterStats and the TableStats in memory, so
+// that the recent stats are queried without an external TSDB. The partitions and the metadata
+// of the tables are not kept.
+type MemoryStore struct {
+	cfg MemoryStoreConfig
+
+	lock    sync.RWMutex
+	cluster *series
+	// table name -> the history of the table
+	tables map[string]*series
+}
+
+// NewMemoryStore returns an empty MemoryStore.
+func NewMemoryStore(cfg MemoryStoreConfig) (*MemoryStore, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return &MemoryStore{cfg: cfg, cluster: &series{}, tables: make(map[string]*series)}, nil
+}
+
+// Append implements StatsStore.
+func (s *MemoryStore) Append(stats *aggregate.ClusterStats) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.cluster.append(&s.cfg, stats.Timestamp, stats.Stats)
+	return nil
+}
+
+// QueryRange implements StatsStore. A downsampled snapshot is stamped with the start of the
+// interval.
+func (s *MemoryStore) QueryRange(start, end time.Time) ([]*aggregate.ClusterStats, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	var result []*aggregate.ClusterStats
+	for _, p := range s.cluster.query(start, end) {
+		result = append(result, &aggregate.ClusterStats{Timestamp: p.timestamp, Stats: p.stats})
+	}
+	return result, nil
+}
+
+// AppendTables writes the TableStats of a round to the store.
+func (s *MemoryStore) AppendTables(stats []aggregate.TableStats) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for i := range stats {
+		tb := &stats[i]
+		history, found := s.tables[tb.TableName]
+		if !found || history.appID != tb.AppID {
+			// the table may be recreated with the same name
+			history = &series{appID: tb.AppID}
+			s.tables[tb.TableName] = history
+		}
+		history.append(&s.cfg, tb.Timestamp, tb.Stats)
+	}
+}
+
+// QueryTableRange returns the snapshots of the table whose timestamps are within [start, end],
+// ordered by time. A downsampled snapshot is stamped with the start of the interval.
+func (s *MemoryStore) QueryTableRange(table string, start, end time.Time) []aggregate.TableStats {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	history, found := s.tables[table]
+	if !found {
+		return nil
+	}
+	var result []aggregate.TableStats
+	for _, p := range history.query(start, end) {
+		result = append(result, aggregate.TableStats{
+			TableName: table,
+			AppID:     history.appID,
+			Timestamp: p.timestamp,
+			Stats:     p.stats,
+		})
+	}
+	return result
+}
+
+// Tables returns the names of the tables in the store, sorted.
+func (s *MemoryStore) Tables() []string {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	var names []string
+	for name := range s.tables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// DropTable removes the history of the table.
+func (s *MemoryStore) DropTable(appID int) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for name, history := range s.tables {
+		if history.appID == appID {
+			delete(s.tables, name)
+		}
+	}
+}
+
+type point struct {
+	timestamp time.Time
+	stats     map[string]float64
+
+	// metric -> the number of the snapshots averaged into the point, nil if it's not downsampled
+	counts map[string]int
+}
+
+// series is the history of a table or the cluster, ordered by time.
+type series struct {
+	appID int
+
+	downsampled []point
+	raw         []point
+}
+
+func (s *series) append(cfg *MemoryStoreConfig, timestamp time.Time, stats map[string]float64) {
+	s.raw = append(s.raw, point{timestamp: timestamp, stats: copyStats(stats)})
+
+	for len(s.raw) > 0 && s.raw[0].timestamp.Before(timestamp.Add(-cfg.RawRetention)) {
+		s.downsample(cfg, s.raw[0])
+		s.raw = s.raw[1:]
+	}
+	for len(s.downsampled) > 0 && s.downsampled[0].timestamp.Before(timestamp.Add(-cfg.Retention)) {
+		s.downsampled = s.downsampled[1:]
+	}
+}
+
+// downsample averages the raw point into the point of its interval.
+func (s *series) downsample(cfg *MemoryStoreConfig, raw point) {
+	start := raw.timestamp.Truncate(cfg.DownsampleInterval)
+	if n := len(s.downsampled); n == 0 || !s.downsampled[n-1].timestamp.Equal(start) {
+		s.downsampled = append(s.downsampled, point{
+			timestamp: start,
+			stats:     make(map[string]float64),
+			counts:    make(map[string]int),
+		})
+	}
+	p := &s.downsampled[len(s.downsampled)-1]
+	for k, v := range raw.stats {
+		count := p.counts[k]
+		p.stats[k] = (p.stats[k]*float64(count) + v) / float64(count+1)
+		p.counts[k] = count + 1
+	}
+}
+
+func (s *series) query(start, end time.Time) []point {
+	var result []point
+	for _, points := range [][]point{s.downsampled, s.raw} {
+		for _, p := range points {
+			if !p.timestamp.Before(start) && !p.timestamp.After(end) {
+				result = append(result, point{timestamp: p.timestamp, stats: copyStats(p.stats)})
+			}
+		}
+	}
+	return result
+}
+
+func copyStats(stats map[string]float64) map[string]float64 {
+	res := make(map[string]float64, len(stats))
+	for k, v := range stats {
+		res[k] = v
+	}
+	return res
+}
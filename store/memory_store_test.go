@@ -0,0 +1,74 @@
+package store
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pegasus-kv/collector/aggregate"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryStoreConfigValidate(t *testing.T) {
+	cfg := MemoryStoreConfig{Retention: time.Hour, RawRetention: 2 * time.Hour, DownsampleInterval: time.Minute}
+	assert.NotNil(t, cfg.Validate())
+	cfg.RawRetention = 0
+	assert.NotNil(t, cfg.Validate())
+	cfg.RawRetention = time.Hour
+	assert.Nil(t, cfg.Validate())
+	cfg.DownsampleInterval = 0
+	assert.NotNil(t, cfg.Validate())
+}
+
+func TestMemoryStore(t *testing.T) {
+	s, err := NewMemoryStore(MemoryStoreConfig{
+		Retention:          time.Hour,
+		RawRetention:       10 * time.Minute,
+		DownsampleInterval: 5 * time.Minute,
+	})
+	assert.Nil(t, err)
+
+	// a snapshot every minute for 2 hours
+	start := time.Date(2020, 11, 19, 0, 0, 0, 0, time.UTC)
+	var now time.Time
+	for i := 0; i < 120; i++ {
+		now = start.Add(time.Duration(i) * time.Minute)
+		assert.Nil(t, s.Append(&aggregate.ClusterStats{Timestamp: now, Stats: map[string]float64{"read_qps": float64(i)}}))
+		s.AppendTables([]aggregate.TableStats{
+			{TableName: "temp", AppID: 1, Timestamp: now, Stats: map[string]float64{"read_qps": 2 * float64(i)}},
+		})
+	}
+
+	result, err := s.QueryRange(start, now)
+	assert.Nil(t, err)
+	// the last 11 minutes are raw, and the hour before is downsampled into 5-minute intervals
+	assert.Equal(t, len(result), 11+10)
+	assert.True(t, result[0].Timestamp.Equal(start.Add(time.Hour)))
+	// the average of the minutes 60~64
+	assert.Equal(t, result[0].Stats["read_qps"], float64(62))
+	assert.True(t, result[len(result)-1].Timestamp.Equal(now))
+	assert.Equal(t, result[len(result)-1].Stats["read_qps"], float64(119))
+	for i := 1; i < len(result); i++ {
+		assert.True(t, result[i-1].Timestamp.Before(result[i].Timestamp))
+	}
+
+	// what happened 30 minutes ago
+	result, err = s.QueryRange(now.Add(-35*time.Minute), now.Add(-30*time.Minute))
+	assert.Nil(t, err)
+	assert.Equal(t, len(result), 1)
+	// the average of the minutes 85~89
+	assert.Equal(t, result[0].Stats["read_qps"], float64(87))
+
+	tables := s.QueryTableRange("temp", now.Add(-time.Minute), now)
+	assert.Equal(t, len(tables), 2)
+	assert.Equal(t, tables[1].AppID, 1)
+	assert.Equal(t, tables[1].Stats["read_qps"], float64(238))
+	assert.Equal(t, s.Tables(), []string{"temp"})
+
+	// the queried stats are copied
+	tables[1].Stats["read_qps"] = 0
+	assert.Equal(t, s.QueryTableRange("temp", now, now)[0].Stats["read_qps"], float64(238))
+
+	s.DropTable(1)
+	assert.Nil(t, s.QueryTableRange("temp", start, now))
+	assert.Nil(t, s.Tables())
+}
@@ -0,0 +1,96 @@
+package store
+
+import (
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pegasus-kv/collector/aggregate"
+	"github.com/pegasus-kv/collector/logging"
+	"github.com/spf13/viper"
+	"gopkg.in/tomb.v2"
+)
+
+var log = logging.Module("store")
+
+// StatsStore persists the history of ClusterStats.
+type StatsStore interface {
+	// Append writes a snapshot to the store.
+	Append(stats *aggregate.ClusterStats) error
+
+	// QueryRange returns the snapshots whose timestamps are within [start, end], ordered by time.
+	QueryRange(start, end time.Time) ([]*aggregate.ClusterStats, error)
+}
+
+var (
+	memoryLock    sync.RWMutex
+	defaultMemory *MemoryStores
+)
+
+// Memory returns the MemoryStores created by Start, or nil if it's disabled.
+func Memory() *MemoryStores {
+	memoryLock.RLock()
+	defer memoryLock.RUnlock()
+	return defaultMemory
+}
+
+// startMemoryStore keeps the recent stats of every cluster in memory for
+// "history_store.memory.retention", which is disabled if the retention is 0.
+func startMemoryStore() {
+	cfg := MemoryStoreConfig{
+		Retention:          viper.GetDuration("history_store.memory.retention"),
+		RawRetention:       viper.GetDuration("history_store.memory.raw_retention"),
+		DownsampleInterval: viper.GetDuration("history_store.memory.downsample_interval"),
+	}
+	if cfg.Retention == 0 {
+		return
+	}
+	s, err := NewMemoryStores(cfg)
+	if err != nil {
+		log.Errorf("invalid config of the memory history store: %s", err)
+		return
+	}
+	aggregate.AddHookAfterClusterStatsEmitted(s.Append)
+	aggregate.AddHookAfterClusterTableDropped(s.DropTable)
+	aggregate.AddHookAfterClusterRemoved(s.RemoveCluster)
+
+	memoryLock.Lock()
+	defer memoryLock.Unlock()
+	defaultMemory = s
+}
+
+// Start keeps the recent stats in memory, and persists the ClusterStats of every aggregation of
+// every cluster to the subdirectory of the cluster under "history_store.dir", and removes the
+// files older than "history_store.retention" hourly. Nothing is persisted if the directory is
+// not configured.
+func Start(tom *tomb.Tomb) {
+	startMemoryStore()
+
+	dir := viper.GetString("history_store.dir")
+	if dir == "" {
+		return
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		log.Errorf("failed to open the history store: %s", err)
+		return
+	}
+	s := newDiskStores(dir)
+	aggregate.AddHookAfterClusterStatsEmitted(s.Append)
+
+	retention := viper.GetDuration("history_store.retention")
+	if retention == 0 {
+		return
+	}
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	for {
+		if err := s.compact(time.Now().Add(-retention)); err != nil {
+			log.Errorf("failed to compact the history store: %s", err)
+		}
+		select {
+		case <-tom.Dying():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,374 @@
+package usage
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pegasus-kv/collector/aggregate"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/spf13/viper"
+	"gopkg.in/tomb.v2"
+)
+
+// Granularity is the length of the periods that the CU usage is summed up over.
+type Granularity string
+
+// The granularities of CUAccountant.Query.
+const (
+	Hourly Granularity = "hour"
+	Daily  Granularity = "day"
+)
+
+// CUConfig is the configuration of CUAccountant.
+type CUConfig struct {
+	// The formulas normalizing the collected counters into the billed CU, in the expressions of
+	// the derived metrics, e.g. "recent_read_cu * 0.5".
+	ReadFormula  string
+	WriteFormula string
+
+	// The hourly usage of each cluster is persisted to "cu-<cluster>.json" under Dir if it's not
+	// empty.
+	Dir string
+
+	// The hourly usage older than Retention is removed, 0 keeps it forever.
+	Retention time.Duration
+}
+
+// CUUsage is the CU consumed by a table in a period.
+type CUUsage struct {
+	Cluster string    `json:"cluster"`
+	Table   string    `json:"table"`
+	Start   time.Time `json:"start"`
+
+	ReadCU  float64 `json:"read_cu"`
+	WriteCU float64 `json:"write_cu"`
+}
+
+// CUAccountant accumulates the CU consumed by every table of every cluster per UTC hour, for
+// billing. The counters "recent_read_cu" and "recent_write_cu" are the CU consumed since the
+// last collection, which are normalized by the formulas and summed up.
+type CUAccountant struct {
+	cfg CUConfig
+	// the cluster of the stats not tagged with one
+	cluster string
+
+	readFormula  aggregate.DerivedMetric
+	writeFormula aggregate.DerivedMetric
+
+	readTotal  *prometheus.CounterVec
+	writeTotal *prometheus.CounterVec
+
+	lock sync.Mutex
+	// cluster -> table -> the start of the hour -> the usage of the hour
+	hours map[string]map[string]map[time.Time]*CUUsage
+	// the clusters changed since the last flush
+	dirty map[string]bool
+}
+
+// NewCUAccountant returns a CUAccountant, which loads the persisted usage from the directory
+// if it's configured.
+func NewCUAccountant(cfg CUConfig, registerer prometheus.Registerer, cluster string) (*CUAccountant, error) {
+	if cfg.ReadFormula == "" {
+		cfg.ReadFormula = "recent_read_cu"
+	}
+	if cfg.WriteFormula == "" {
+		cfg.WriteFormula = "recent_write_cu"
+	}
+	readFormula, err := aggregate.NewDerivedMetric("read_cu", cfg.ReadFormula)
+	if err != nil {
+		return nil, err
+	}
+	writeFormula, err := aggregate.NewDerivedMetric("write_cu", cfg.WriteFormula)
+	if err != nil {
+		return nil, err
+	}
+	a := &CUAccountant{
+		cfg:          cfg,
+		cluster:      cluster,
+		readFormula:  readFormula,
+		writeFormula: writeFormula,
+		readTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "table_read_cu_total",
+			Help: "The normalized read CU consumed by the table.",
+		}, []string{"cluster", "table"}),
+		writeTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "table_write_cu_total",
+			Help: "The normalized write CU consumed by the table.",
+		}, []string{"cluster", "table"}),
+		hours: make(map[string]map[string]map[time.Time]*CUUsage),
+		dirty: make(map[string]bool),
+	}
+	if err := a.load(); err != nil {
+		return nil, err
+	}
+	registerer.MustRegister(a.readTotal, a.writeTotal)
+	return a, nil
+}
+
+// load loads the usage of every cluster persisted under the directory.
+func (a *CUAccountant) load() error {
+	if a.cfg.Dir == "" {
+		return nil
+	}
+	if err := os.MkdirAll(a.cfg.Dir, 0755); err != nil {
+		return err
+	}
+	paths, err := filepath.Glob(filepath.Join(a.cfg.Dir, "cu-*.json"))
+	if err != nil {
+		return err
+	}
+	for _, path := range paths {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		var usages []CUUsage
+		if err := json.Unmarshal(data, &usages); err != nil {
+			return fmt.Errorf("failed to load %s: %s", path, err)
+		}
+		// the cluster is told by the file name, since it's absent in the files of old versions
+		cluster := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(path), "cu-"), ".json")
+		for _, u := range usages {
+			hour := a.hourOf(cluster, u.Table, u.Start)
+			hour.ReadCU, hour.WriteCU = u.ReadCU, u.WriteCU
+		}
+	}
+	return nil
+}
+
+// pathOf returns the file persisting the usage of the cluster.
+func (a *CUAccountant) pathOf(cluster string) string {
+	return filepath.Join(a.cfg.Dir, "cu-"+cluster+".json")
+}
+
+// hourOf returns the usage of the table of the cluster in the hour containing `at`, which is
+// created if absent. It must be called with the lock held.
+func (a *CUAccountant) hourOf(cluster, table string, at time.Time) *CUUsage {
+	start := at.UTC().Truncate(time.Hour)
+	tables := a.hours[cluster]
+	if tables == nil {
+		tables = make(map[string]map[time.Time]*CUUsage)
+		a.hours[cluster] = tables
+	}
+	hours := tables[table]
+	if hours == nil {
+		hours = make(map[time.Time]*CUUsage)
+		tables[table] = hours
+	}
+	u := hours[start]
+	if u == nil {
+		u = &CUUsage{Cluster: cluster, Table: table, Start: start}
+		hours[start] = u
+	}
+	return u
+}
+
+// Record accumulates the CU of the tables of the cluster in a round. The stats of an empty
+// cluster are taken as those of the cluster given to NewCUAccountant.
+func (a *CUAccountant) Record(cluster string, stats []aggregate.TableStats) {
+	if cluster == "" {
+		cluster = a.cluster
+	}
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	for i := range stats {
+		tb := &stats[i]
+		readCU, _ := a.readFormula.Eval(tb.Stats)
+		writeCU, _ := a.writeFormula.Eval(tb.Stats)
+		if readCU <= 0 && writeCU <= 0 {
+			continue
+		}
+		u := a.hourOf(cluster, tb.TableName, tb.Timestamp)
+		if readCU > 0 {
+			u.ReadCU += readCU
+			a.readTotal.WithLabelValues(cluster, tb.TableName).Add(readCU)
+		}
+		if writeCU > 0 {
+			u.WriteCU += writeCU
+			a.writeTotal.WithLabelValues(cluster, tb.TableName).Add(writeCU)
+		}
+		a.dirty[cluster] = true
+	}
+}
+
+// Query returns the usage of the table of the cluster, or all tables if it's empty, in the
+// periods overlapping with [start, end], sorted by the table and the period.
+func (a *CUAccountant) Query(cluster, table string, start, end time.Time, granularity Granularity) []CUUsage {
+	truncate := func(t time.Time) time.Time {
+		t = t.UTC()
+		if granularity == Daily {
+			return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+		}
+		return t.Truncate(time.Hour)
+	}
+	from, to := truncate(start), end.UTC()
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	periods := make(map[string]map[time.Time]*CUUsage)
+	for name, hours := range a.hours[cluster] {
+		if table != "" && name != table {
+			continue
+		}
+		for hour, u := range hours {
+			if hour.Before(from) || hour.After(to) {
+				continue
+			}
+			if periods[name] == nil {
+				periods[name] = make(map[time.Time]*CUUsage)
+			}
+			p := periods[name][truncate(hour)]
+			if p == nil {
+				p = &CUUsage{Cluster: cluster, Table: name, Start: truncate(hour)}
+				periods[name][p.Start] = p
+			}
+			p.ReadCU += u.ReadCU
+			p.WriteCU += u.WriteCU
+		}
+	}
+
+	res := []CUUsage{}
+	for _, ps := range periods {
+		for _, p := range ps {
+			res = append(res, *p)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Table != res[j].Table {
+			return res[i].Table < res[j].Table
+		}
+		return res[i].Start.Before(res[j].Start)
+	})
+	return res
+}
+
+// Expire removes the hourly usage older than the retention.
+func (a *CUAccountant) Expire(now time.Time) {
+	if a.cfg.Retention == 0 {
+		return
+	}
+	olderThan := now.Add(-a.cfg.Retention)
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	for cluster, tables := range a.hours {
+		for name, hours := range tables {
+			for hour := range hours {
+				if hour.Add(time.Hour).Before(olderThan) {
+					delete(hours, hour)
+					a.dirty[cluster] = true
+				}
+			}
+			if len(hours) == 0 {
+				delete(tables, name)
+			}
+		}
+	}
+}
+
+// Flush persists the hourly usage of the clusters changed since the last flush.
+func (a *CUAccountant) Flush() error {
+	if a.cfg.Dir == "" {
+		return nil
+	}
+	a.lock.Lock()
+	clusters := make(map[string][]CUUsage, len(a.dirty))
+	for cluster := range a.dirty {
+		usages := []CUUsage{}
+		for _, hours := range a.hours[cluster] {
+			for _, u := range hours {
+				usages = append(usages, *u)
+			}
+		}
+		clusters[cluster] = usages
+	}
+	a.dirty = make(map[string]bool)
+	a.lock.Unlock()
+
+	for cluster, usages := range clusters {
+		if err := a.flush(cluster, usages); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (a *CUAccountant) flush(cluster string, usages []CUUsage) error {
+	sort.Slice(usages, func(i, j int) bool {
+		if usages[i].Table != usages[j].Table {
+			return usages[i].Table < usages[j].Table
+		}
+		return usages[i].Start.Before(usages[j].Start)
+	})
+	data, err := json.MarshalIndent(usages, "", "  ")
+	if err != nil {
+		return err
+	}
+	// written to a temporary file first, so that the file is never partially written
+	path := a.pathOf(cluster)
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+const cuFlushInterval = time.Minute
+
+var (
+	cuLock    sync.RWMutex
+	defaultCU *CUAccountant
+)
+
+// CU returns the CUAccountant run by StartCUAccounting, or nil if it's disabled.
+func CU() *CUAccountant {
+	cuLock.RLock()
+	defer cuLock.RUnlock()
+	return defaultCU
+}
+
+// StartCUAccounting accumulates the CU of every round if "cu_accounting.enabled" is true, and
+// persists the usage every minute until the tomb dies.
+func StartCUAccounting(tom *tomb.Tomb) {
+	if !viper.GetBool("cu_accounting.enabled") {
+		return
+	}
+	a, err := NewCUAccountant(CUConfig{
+		ReadFormula:  viper.GetString("cu_accounting.read_formula"),
+		WriteFormula: viper.GetString("cu_accounting.write_formula"),
+		Dir:          viper.GetString("cu_accounting.dir"),
+		Retention:    viper.GetDuration("cu_accounting.retention"),
+	}, prometheus.DefaultRegisterer, viper.GetString("cluster_name"))
+	if err != nil {
+		log.Errorf("failed to start the CU accounting: %s", err)
+		return
+	}
+	aggregate.AddHookAfterClusterStatsEmitted(func(stats []aggregate.TableStats, allStats aggregate.ClusterStats) {
+		a.Record(allStats.Cluster, stats)
+	})
+	cuLock.Lock()
+	defaultCU = a
+	cuLock.Unlock()
+
+	ticker := time.NewTicker(cuFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-tom.Dying():
+		case <-ticker.C:
+		}
+		a.Expire(time.Now())
+		if err := a.Flush(); err != nil {
+			log.Errorf("failed to persist the CU usage: %s", err)
+		}
+		if !tom.Alive() {
+			return
+		}
+	}
+}
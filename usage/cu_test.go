@@ -0,0 +1,91 @@
+package usage
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/pegasus-kv/collector/aggregate"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCUAccountant(t *testing.T) {
+	dir, err := ioutil.TempDir("", "collector-cu")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	cfg := CUConfig{WriteFormula: "recent_write_cu * 2", Dir: dir, Retention: 48 * time.Hour}
+	a, err := NewCUAccountant(cfg, prometheus.NewRegistry(), "onebox")
+	assert.Nil(t, err)
+
+	// every 30 minutes in 2 days
+	start := time.Date(2020, 11, 19, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 96; i++ {
+		a.Record("", []aggregate.TableStats{
+			{TableName: "temp", Timestamp: start.Add(time.Duration(i) * 30 * time.Minute),
+				Stats: map[string]float64{"recent_read_cu": 1, "recent_write_cu": 2}},
+			{TableName: "stat", Timestamp: start.Add(time.Duration(i) * 30 * time.Minute),
+				Stats: map[string]float64{}},
+		})
+	}
+	assert.Equal(t, testutil.ToFloat64(a.readTotal.WithLabelValues("onebox", "temp")), float64(96))
+	assert.Equal(t, testutil.ToFloat64(a.writeTotal.WithLabelValues("onebox", "temp")), float64(384))
+
+	hourly := a.Query("onebox", "temp", start.Add(90*time.Minute), start.Add(3*time.Hour), Hourly)
+	assert.Equal(t, hourly, []CUUsage{
+		{Cluster: "onebox", Table: "temp", Start: start.Add(time.Hour), ReadCU: 2, WriteCU: 8},
+		{Cluster: "onebox", Table: "temp", Start: start.Add(2 * time.Hour), ReadCU: 2, WriteCU: 8},
+		{Cluster: "onebox", Table: "temp", Start: start.Add(3 * time.Hour), ReadCU: 2, WriteCU: 8},
+	})
+	daily := a.Query("onebox", "", start, start.Add(48*time.Hour), Daily)
+	assert.Equal(t, daily, []CUUsage{
+		{Cluster: "onebox", Table: "temp", Start: start, ReadCU: 48, WriteCU: 192},
+		{Cluster: "onebox", Table: "temp", Start: start.Add(24 * time.Hour), ReadCU: 48, WriteCU: 192},
+	})
+
+	// the usage of the first day is expired
+	a.Expire(start.Add(73 * time.Hour))
+	assert.Nil(t, a.Flush())
+	a, err = NewCUAccountant(cfg, prometheus.NewRegistry(), "onebox")
+	assert.Nil(t, err)
+	daily = a.Query("onebox", "temp", start, start.Add(48*time.Hour), Daily)
+	assert.Equal(t, daily, []CUUsage{
+		{Cluster: "onebox", Table: "temp", Start: start.Add(24 * time.Hour), ReadCU: 48, WriteCU: 192},
+	})
+}
+
+func TestCUAccountantOfClusters(t *testing.T) {
+	dir, err := ioutil.TempDir("", "collector-cu")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	a, err := NewCUAccountant(CUConfig{Dir: dir}, prometheus.NewRegistry(), "onebox")
+	assert.Nil(t, err)
+	start := time.Date(2020, 11, 19, 0, 0, 0, 0, time.UTC)
+	for i, cluster := range []string{"onebox", "c2"} {
+		a.Record(cluster, []aggregate.TableStats{
+			{TableName: "temp", Timestamp: start, Stats: map[string]float64{"recent_read_cu": float64(i + 1)}},
+		})
+	}
+	assert.Equal(t, testutil.ToFloat64(a.readTotal.WithLabelValues("c2", "temp")), float64(2))
+	assert.Nil(t, a.Flush())
+
+	// the usage of every cluster is loaded
+	a, err = NewCUAccountant(CUConfig{Dir: dir}, prometheus.NewRegistry(), "onebox")
+	assert.Nil(t, err)
+	assert.Equal(t, a.Query("onebox", "temp", start, start, Hourly), []CUUsage{
+		{Cluster: "onebox", Table: "temp", Start: start, ReadCU: 1},
+	})
+	assert.Equal(t, a.Query("c2", "temp", start, start, Hourly), []CUUsage{
+		{Cluster: "c2", Table: "temp", Start: start, ReadCU: 2},
+	})
+	assert.Empty(t, a.Query("c3", "", start, start, Hourly))
+}
+
+func TestCUAccountantInvalidFormula(t *testing.T) {
+	_, err := NewCUAccountant(CUConfig{ReadFormula: "recent_read_cu *"}, prometheus.NewRegistry(), "onebox")
+	assert.NotNil(t, err)
+}
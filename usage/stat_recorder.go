@@ -0,0 +1,158 @@
+package usage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/XiaoMi/pegasus-go-client/pegasus"
+	"github.com/pegasus-kv/collector/aggregate"
+	"github.com/spf13/viper"
+	"gopkg.in/tomb.v2"
+)
+
+// The table name in the hash keys of the cluster stats.
+const clusterStatTable = "_all"
+
+const (
+	statDateLayout     = "2006-01-02"
+	statMaxRetryCount  = 3
+	statDefaultTimeout = 5 * time.Second
+)
+
+// StatTableConfig is the configuration of StatRecorder.
+type StatTableConfig struct {
+	// The Pegasus table to write, empty disables the recorder.
+	AppName string
+
+	// The TTL of the written stats, 0 never expires.
+	TTL time.Duration
+
+	// The timeout of each write.
+	Timeout time.Duration
+
+	// The cluster whose stats are written in the layout of info_collector. The stats of the other
+	// clusters have the cluster in the hash keys.
+	Cluster string
+}
+
+// statSetter is the operation of pegasus.TableConnector used by StatRecorder.
+type statSetter interface {
+	SetTTL(ctx context.Context, hashKey []byte, sortKey []byte, value []byte, ttl time.Duration) error
+}
+
+// StatRecorder writes the stats of the tables and the cluster of every round into a Pegasus
+// table, in the layout of the "usage_stat" table produced by the info_collector of Pegasus:
+//
+//	hash key: "<date>:<table>" in the UTC date, e.g. "2020-11-19:temp", where the table of
+//	          the cluster stats is "_all". The tables of the clusters other than
+//	          StatTableConfig.Cluster are "<cluster>/<table>", e.g. "2020-11-19:c2/temp"
+//	sort key: the unix timestamp in seconds
+//	value:    the stats in JSON, e.g. {"get_qps":1,"put_qps":2}
+type StatRecorder struct {
+	cfg   StatTableConfig
+	table statSetter
+}
+
+func newStatRecorder(cfg StatTableConfig, table statSetter) *StatRecorder {
+	if cfg.Timeout == 0 {
+		cfg.Timeout = statDefaultTimeout
+	}
+	return &StatRecorder{cfg: cfg, table: table}
+}
+
+// Record writes the stats of a round of the cluster told by ClusterStats.Cluster. The failed
+// writes are retried, then logged.
+func (rec *StatRecorder) Record(ctx context.Context, stats []aggregate.TableStats, allStats aggregate.ClusterStats) {
+	for _, tb := range stats {
+		rec.write(ctx, rec.tableKey(allStats.Cluster, tb.TableName), tb.Timestamp, tb.Stats)
+	}
+	rec.write(ctx, rec.tableKey(allStats.Cluster, clusterStatTable), allStats.Timestamp, allStats.Stats)
+}
+
+// tableKey returns the table in the hash keys of the table of the cluster.
+func (rec *StatRecorder) tableKey(cluster, table string) string {
+	if cluster == "" || cluster == rec.cfg.Cluster {
+		return table
+	}
+	return cluster + "/" + table
+}
+
+func (rec *StatRecorder) write(ctx context.Context, table string, timestamp time.Time, stats map[string]float64) {
+	hashKey, sortKey := statKeys(table, timestamp)
+	value, err := json.Marshal(stats)
+	if err != nil {
+		log.Errorf("failed to encode the stats of table %s: %s", table, err)
+		return
+	}
+	for i := 0; i < statMaxRetryCount; i++ {
+		writeCtx, cancel := context.WithTimeout(ctx, rec.cfg.Timeout)
+		err = rec.table.SetTTL(writeCtx, hashKey, sortKey, value, rec.cfg.TTL)
+		cancel()
+		if err == nil || ctx.Err() != nil {
+			break
+		}
+	}
+	if err != nil {
+		log.Errorf("failed to write the stats [table: %s, timestamp: %s] to %s: %s",
+			table, timestamp.Local().String(), rec.cfg.AppName, err)
+	}
+}
+
+func statKeys(table string, timestamp time.Time) (hashKey []byte, sortKey []byte) {
+	hashKey = []byte(fmt.Sprintf("%s:%s", timestamp.UTC().Format(statDateLayout), table))
+	sortKey = []byte(fmt.Sprintf("%d", timestamp.Unix()))
+	return hashKey, sortKey
+}
+
+// StartStatRecorder writes the stats of every round into the table configured by
+// "stat_table.app_name" until the tomb dies. It's disabled if the table is not configured.
+func StartStatRecorder(tom *tomb.Tomb) {
+	cfg := StatTableConfig{
+		AppName: viper.GetString("stat_table.app_name"),
+		TTL:     viper.GetDuration("stat_table.ttl"),
+		Timeout: viper.GetDuration("stat_table.timeout"),
+		Cluster: viper.GetString("cluster_name"),
+	}
+	if cfg.AppName == "" {
+		return
+	}
+
+	client := pegasus.NewClient(pegasus.Config{MetaServers: viper.GetStringSlice("meta_servers")})
+	defer client.Close()
+	var table pegasus.TableConnector
+	for {
+		var err error
+		table, err = client.OpenTable(tom.Context(nil), cfg.AppName)
+		if err == nil {
+			break
+		}
+		// retry indefinitely
+		log.Errorf("failed to open the stat table %s: %s", cfg.AppName, err)
+		select {
+		case <-tom.Dying():
+			return
+		case <-time.After(15 * time.Second):
+		}
+	}
+
+	rec := newStatRecorder(cfg, table)
+	rounds := make(chan func(), 1)
+	aggregate.AddHookAfterClusterStatsEmitted(func(stats []aggregate.TableStats, allStats aggregate.ClusterStats) {
+		select {
+		case rounds <- func() { rec.Record(tom.Context(nil), stats, allStats) }:
+		default:
+			log.Warnf("writing the stats to %s is too slow, drop a round", cfg.AppName)
+		}
+	})
+	log.Infof("start writing the stats to table %s", cfg.AppName)
+	for {
+		select {
+		case <-tom.Dying():
+			return
+		case record := <-rounds:
+			record()
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package usage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/pegasus-kv/collector/aggregate"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeStatTable struct {
+	failures int
+	values   map[string][]byte
+	ttl      time.Duration
+}
+
+func (f *fakeStatTable) SetTTL(ctx context.Context, hashKey []byte, sortKey []byte, value []byte, ttl time.Duration) error {
+	if f.failures > 0 {
+		f.failures--
+		return errors.New("timeout")
+	}
+	f.values[string(hashKey)+"/"+string(sortKey)] = value
+	f.ttl = ttl
+	return nil
+}
+
+func TestStatRecorder(t *testing.T) {
+	table := &fakeStatTable{failures: 2, values: make(map[string][]byte)}
+	rec := newStatRecorder(StatTableConfig{AppName: "stat", TTL: time.Hour, Cluster: "onebox"}, table)
+
+	now := time.Date(2020, 11, 19, 23, 59, 0, 0, time.UTC)
+	rec.Record(context.Background(), []aggregate.TableStats{
+		{TableName: "temp", Timestamp: now, Stats: map[string]float64{"get_qps": 1, "put_qps": 2}},
+	}, aggregate.ClusterStats{Timestamp: now, Stats: map[string]float64{"read_qps": 3}})
+
+	assert.Equal(t, len(table.values), 2)
+	assert.Equal(t, table.ttl, time.Hour)
+	var stats map[string]float64
+	assert.Nil(t, json.Unmarshal(table.values["2020-11-19:temp/1605830340"], &stats))
+	assert.Equal(t, stats, map[string]float64{"get_qps": 1, "put_qps": 2})
+	assert.Nil(t, json.Unmarshal(table.values["2020-11-19:_all/1605830340"], &stats))
+	assert.Equal(t, stats["read_qps"], float64(3))
+}
+
+func TestStatRecorderOfClusters(t *testing.T) {
+	table := &fakeStatTable{values: make(map[string][]byte)}
+	rec := newStatRecorder(StatTableConfig{AppName: "stat", Cluster: "onebox"}, table)
+
+	now := time.Date(2020, 11, 19, 23, 59, 0, 0, time.UTC)
+	for _, cluster := range []string{"onebox", "c2"} {
+		rec.Record(context.Background(), []aggregate.TableStats{
+			{TableName: "temp", Timestamp: now, Stats: map[string]float64{"get_qps": 1}},
+		}, aggregate.ClusterStats{Cluster: cluster, Timestamp: now, Stats: map[string]float64{"read_qps": 1}})
+	}
+
+	assert.Equal(t, len(table.values), 4)
+	assert.Contains(t, table.values, "2020-11-19:temp/1605830340")
+	assert.Contains(t, table.values, "2020-11-19:_all/1605830340")
+	assert.Contains(t, table.values, "2020-11-19:c2/temp/1605830340")
+	assert.Contains(t, table.values, "2020-11-19:c2/_all/1605830340")
+}
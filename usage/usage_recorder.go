@@ -7,11 +7,13 @@ import (
 
 	"github.com/XiaoMi/pegasus-go-client/pegasus"
 	"github.com/pegasus-kv/collector/aggregate"
-	log "github.com/sirupsen/logrus"
+	"github.com/pegasus-kv/collector/logging"
 	"github.com/spf13/viper"
 	"gopkg.in/tomb.v2"
 )
 
+var log = logging.Module("usage")
+
 // TableUsageRecorder records the usage of each table into a Pegasus table.
 // The usage statistics can be used for service cost calculation.
 type TableUsageRecorder interface {
@@ -24,6 +26,7 @@ type TableUsageRecorder interface {
 func NewTableUsageRecorder() TableUsageRecorder {
 	return &tableUsageRecorder{
 		usageStatApp: viper.GetString("usage_stat_app"),
+		cluster:      viper.GetString("cluster_name"),
 	}
 }
 
@@ -32,6 +35,9 @@ type tableUsageRecorder struct {
 	table  pegasus.TableConnector
 
 	usageStatApp string
+	// the cluster whose usage is written with the sort key "cu", while the usage of the other
+	// clusters is written with "cu:<cluster>"
+	cluster string
 }
 
 func (rec *tableUsageRecorder) Start(tom *tomb.Tomb) {
@@ -53,10 +59,10 @@ func (rec *tableUsageRecorder) Start(tom *tomb.Tomb) {
 		break
 	}
 
-	aggregate.AddHookAfterTableStatEmitted(func(stats []aggregate.TableStats, allStat aggregate.ClusterStats) {
+	aggregate.AddHookAfterClusterStatsEmitted(func(stats []aggregate.TableStats, allStat aggregate.ClusterStats) {
 		rootCtx := tom.Context(nil)
 		for _, s := range stats {
-			rec.writeTableUsage(rootCtx, &s)
+			rec.writeTableUsage(rootCtx, allStat.Cluster, &s)
 		}
 	})
 }
@@ -70,9 +76,12 @@ func sleepWait(tom *tomb.Tomb, waitTime time.Duration) {
 	}
 }
 
-func (rec *tableUsageRecorder) writeTableUsage(ctx context.Context, tb *aggregate.TableStats) {
+func (rec *tableUsageRecorder) writeTableUsage(ctx context.Context, cluster string, tb *aggregate.TableStats) {
 	hashKey := []byte(fmt.Sprintf("%d", tb.Timestamp.Unix()))
 	sortkey := []byte("cu")
+	if cluster != "" && cluster != rec.cluster {
+		sortkey = []byte("cu:" + cluster)
+	}
 
 	readCU := tb.Stats["recent_read_cu"]
 	writeCU := tb.Stats["recent_write_cu"]
@@ -86,7 +95,7 @@ func (rec *tableUsageRecorder) writeTableUsage(ctx context.Context, tb *aggregat
 			if err == nil {
 				break
 			}
-			log.Errorf("failed to write cu [timestamp: %s, appid: %d, readcu: %d, writecu: %d]",
+			log.Errorf("failed to write cu [timestamp: %s, appid: %d, readcu: %f, writecu: %f]",
 				tb.Timestamp.Local().String(),
 				tb.AppID,
 				readCU,
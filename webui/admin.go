@@ -0,0 +1,41 @@
+package webui
+
+import (
+	"github.com/kataras/iris/v12"
+	"github.com/pegasus-kv/collector/logging"
+	"github.com/pegasus-kv/collector/reload"
+)
+
+// reloadHandler re-reads the config file, and applies the changes to the components at runtime.
+// It's allowed for the admins only if "http_auth" is configured.
+func reloadHandler(ctx iris.Context) {
+	if err := reload.Reload(); err != nil {
+		ctx.StatusCode(iris.StatusInternalServerError)
+		ctx.WriteString(err.Error())
+		return
+	}
+	ctx.WriteString("config is reloaded")
+}
+
+// logLevelsHandler responds the log level of every module.
+func logLevelsHandler(ctx iris.Context) {
+	ctx.JSON(logging.Levels())
+}
+
+// logLevelHandler sets the log level of the module given by the "module" and "level"
+// parameters at runtime, or resets it to the default level if the level is empty, until the
+// config of "log" is reloaded.
+func logLevelHandler(ctx iris.Context) {
+	module := ctx.URLParam("module")
+	if module == "" {
+		ctx.StatusCode(iris.StatusBadRequest)
+		ctx.WriteString("the module is required")
+		return
+	}
+	if err := logging.SetModuleLevel(module, ctx.URLParam("level")); err != nil {
+		ctx.StatusCode(iris.StatusBadRequest)
+		ctx.WriteString(err.Error())
+		return
+	}
+	ctx.JSON(logging.Levels())
+}
@@ -0,0 +1,26 @@
+package webui
+
+import (
+	"github.com/kataras/iris/v12"
+	"github.com/pegasus-kv/collector/alert"
+)
+
+// alertsHandler responds the pending and firing alerts, or only those in the state and of the
+// cluster given by the "state" and "cluster" parameters.
+func alertsHandler(ctx iris.Context) {
+	e := alert.Default()
+	if e == nil {
+		ctx.StatusCode(iris.StatusNotFound)
+		ctx.WriteString("alerting is disabled")
+		return
+	}
+	state := alert.State(ctx.URLParam("state"))
+	cluster := ctx.URLParam("cluster")
+	res := []alert.Alert{}
+	for _, a := range e.Alerts() {
+		if (state == "" || a.State == state) && (cluster == "" || a.Cluster == cluster) {
+			res = append(res, a)
+		}
+	}
+	ctx.JSON(res)
+}
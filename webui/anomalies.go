@@ -0,0 +1,26 @@
+package webui
+
+import (
+	"github.com/kataras/iris/v12"
+	"github.com/pegasus-kv/collector/anomaly"
+)
+
+// anomaliesHandler responds the ongoing anomalies of the tables, or only those of the cluster
+// and the table given by the "cluster" and "table" parameters.
+func anomaliesHandler(ctx iris.Context) {
+	d := anomaly.Default()
+	if d == nil {
+		ctx.StatusCode(iris.StatusNotFound)
+		ctx.WriteString("anomaly detection is disabled")
+		return
+	}
+	cluster := ctx.URLParam("cluster")
+	table := ctx.URLParam("table")
+	res := []anomaly.Anomaly{}
+	for _, a := range d.Anomalies() {
+		if (cluster == "" || a.Cluster == cluster) && (table == "" || a.Table == table) {
+			res = append(res, a)
+		}
+	}
+	ctx.JSON(res)
+}
@@ -0,0 +1,284 @@
+package webui
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/kataras/iris/v12"
+	"github.com/pegasus-kv/collector/aggregate"
+)
+
+// statsSnapshot keeps the stats of the latest round of aggregation of every cluster for the
+// JSON API.
+type statsSnapshot struct {
+	lock sync.RWMutex
+	// the names of the clusters in the order of the config, the first of which is responded
+	// unless the "cluster" parameter is given
+	names    []string
+	clusters map[string]*clusterSnapshot
+}
+
+// clusterSnapshot is the stats of the latest round of a cluster.
+type clusterSnapshot struct {
+	tables  []aggregate.TableStats
+	cluster *aggregate.ClusterStats
+	nodes   []aggregate.NodeStat
+	// address -> the last time of the nodes absent from the latest round
+	lostNodes map[string]time.Time
+}
+
+// newStatsSnapshot returns a statsSnapshot watching the stats emitted by the aggregators of
+// all clusters.
+func newStatsSnapshot() *statsSnapshot {
+	s := &statsSnapshot{clusters: make(map[string]*clusterSnapshot)}
+	clusters, err := aggregate.ClustersFromConfig()
+	if err != nil {
+		log.Errorf("failed to read the clusters: %s", err)
+	}
+	for _, c := range clusters {
+		s.of(c.Name)
+	}
+	aggregate.AddHookAfterClusterStatsEmitted(s.updateTables)
+	aggregate.AddHookAfterClusterNodeStatsEmitted(s.updateNodes)
+	aggregate.AddHookAfterClusterRemoved(s.removeCluster)
+	return s
+}
+
+// of returns the snapshot of the cluster, which is added if it's new. It must be called with
+// the write lock held.
+func (s *statsSnapshot) of(name string) *clusterSnapshot {
+	c, found := s.clusters[name]
+	if !found {
+		c = &clusterSnapshot{lostNodes: make(map[string]time.Time)}
+		s.clusters[name] = c
+		s.names = append(s.names, name)
+	}
+	return c
+}
+
+// get returns the snapshot of the cluster given by the "cluster" parameter, or the first
+// cluster if it's not given. It responds 404 and returns nil if there's no such cluster. It
+// must be called with the read lock held.
+func (s *statsSnapshot) get(ctx iris.Context) *clusterSnapshot {
+	name := s.nameOf(ctx)
+	c, found := s.clusters[name]
+	if !found {
+		ctx.StatusCode(iris.StatusNotFound)
+		ctx.WriteString("cluster " + name + " is not found")
+		return nil
+	}
+	return c
+}
+
+// nameOf returns the cluster given by the "cluster" parameter, or the first cluster if it's not
+// given. It must be called with the read lock held.
+func (s *statsSnapshot) nameOf(ctx iris.Context) string {
+	name := ctx.URLParam("cluster")
+	if name == "" && len(s.names) != 0 {
+		name = s.names[0]
+	}
+	return name
+}
+
+// clusterOf is nameOf with the read lock acquired, for the handlers of the stats of the clusters
+// kept elsewhere, e.g. the history.
+func (s *statsSnapshot) clusterOf(ctx iris.Context) string {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.nameOf(ctx)
+}
+
+// removeCluster forgets the cluster that is no longer collected.
+func (s *statsSnapshot) removeCluster(name string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.clusters, name)
+	for i, n := range s.names {
+		if n == name {
+			s.names = append(s.names[:i], s.names[i+1:]...)
+			break
+		}
+	}
+}
+
+func (s *statsSnapshot) updateTables(stats []aggregate.TableStats, allStats aggregate.ClusterStats) {
+	tables := append([]aggregate.TableStats{}, stats...)
+	sort.Slice(tables, func(i, j int) bool {
+		return tables[i].TableName < tables[j].TableName
+	})
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	c := s.of(allStats.Cluster)
+	c.tables = tables
+	c.cluster = &allStats
+}
+
+func (s *statsSnapshot) updateNodes(nodes []aggregate.NodeStat) {
+	if len(nodes) == 0 {
+		return
+	}
+	nodes = append([]aggregate.NodeStat{}, nodes...)
+	sort.Slice(nodes, func(i, j int) bool {
+		return nodes[i].Addr < nodes[j].Addr
+	})
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	// the nodes of a round are of the same cluster
+	c := s.of(nodes[0].Cluster)
+	for _, n := range c.nodes {
+		c.lostNodes[n.Addr] = n.CollectedAt
+	}
+	for _, n := range nodes {
+		delete(c.lostNodes, n.Addr)
+	}
+	c.nodes = nodes
+}
+
+// tableJSON is the JSON of a TableStats, whose partitions are included only if a single table
+// is queried.
+type tableJSON struct {
+	TableName      string                   `json:"table_name"`
+	AppID          int                      `json:"app_id"`
+	Timestamp      time.Time                `json:"timestamp"`
+	Stats          map[string]float64       `json:"stats"`
+	SecondaryStats map[string]float64       `json:"secondary_stats,omitempty"`
+	Metadata       *aggregate.TableMetadata `json:"metadata,omitempty"`
+	Partitions     []partitionJSON          `json:"partitions,omitempty"`
+}
+
+type partitionJSON struct {
+	PartitionIndex int                `json:"partition_index"`
+	Addr           string             `json:"addr"`
+	CollectedAt    time.Time          `json:"collected_at"`
+	Stats          map[string]float64 `json:"stats"`
+}
+
+func newTableJSON(tb *aggregate.TableStats, withPartitions bool) tableJSON {
+	res := tableJSON{
+		TableName:      tb.TableName,
+		AppID:          tb.AppID,
+		Timestamp:      tb.Timestamp,
+		Stats:          tb.Stats,
+		SecondaryStats: tb.SecondaryStats,
+		Metadata:       tb.Metadata,
+	}
+	if !withPartitions {
+		return res
+	}
+	res.Partitions = []partitionJSON{}
+	for idx, part := range tb.Partitions {
+		res.Partitions = append(res.Partitions, partitionJSON{
+			PartitionIndex: idx,
+			Addr:           part.Addr,
+			CollectedAt:    part.CollectedAt,
+			Stats:          part.Stats,
+		})
+	}
+	sort.Slice(res.Partitions, func(i, j int) bool {
+		return res.Partitions[i].PartitionIndex < res.Partitions[j].PartitionIndex
+	})
+	return res
+}
+
+type nodeJSON struct {
+	Addr        string             `json:"addr"`
+	CollectedAt time.Time          `json:"collected_at"`
+	Stats       map[string]float64 `json:"stats"`
+}
+
+type clusterJSON struct {
+	Timestamp      time.Time          `json:"timestamp"`
+	Stats          map[string]float64 `json:"stats"`
+	SecondaryStats map[string]float64 `json:"secondary_stats,omitempty"`
+}
+
+type clusterSummaryJSON struct {
+	Name string `json:"name"`
+	// the time of the latest round, zero if no stats is aggregated yet
+	Timestamp  time.Time `json:"timestamp"`
+	TableCount int       `json:"table_count"`
+}
+
+// clustersHandler responds the clusters collected, in the order of the config. The other APIs
+// respond the stats of the cluster given by the "cluster" parameter, or the first cluster.
+func (s *statsSnapshot) clustersHandler(ctx iris.Context) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	res := []clusterSummaryJSON{}
+	for _, name := range s.names {
+		c := s.clusters[name]
+		summary := clusterSummaryJSON{Name: name, TableCount: len(c.tables)}
+		if c.cluster != nil {
+			summary.Timestamp = c.cluster.Timestamp
+		}
+		res = append(res, summary)
+	}
+	ctx.JSON(res)
+}
+
+// tablesHandler responds the latest stats of all tables, sorted by the name.
+func (s *statsSnapshot) tablesHandler(ctx iris.Context) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	c := s.get(ctx)
+	if c == nil {
+		return
+	}
+	res := []tableJSON{}
+	for i := range c.tables {
+		res = append(res, newTableJSON(&c.tables[i], false))
+	}
+	ctx.JSON(res)
+}
+
+// tableHandler responds the latest stats of the table given by the path, including the
+// partitions.
+func (s *statsSnapshot) tableHandler(ctx iris.Context) {
+	name := ctx.Params().Get("name")
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	c := s.get(ctx)
+	if c == nil {
+		return
+	}
+	for i := range c.tables {
+		if c.tables[i].TableName == name {
+			ctx.JSON(newTableJSON(&c.tables[i], true))
+			return
+		}
+	}
+	ctx.StatusCode(iris.StatusNotFound)
+	ctx.WriteString("table " + name + " is not found")
+}
+
+// nodesHandler responds the latest stats of all replica nodes, sorted by the address.
+func (s *statsSnapshot) nodesHandler(ctx iris.Context) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	c := s.get(ctx)
+	if c == nil {
+		return
+	}
+	res := []nodeJSON{}
+	for _, n := range c.nodes {
+		res = append(res, nodeJSON{Addr: n.Addr, CollectedAt: n.CollectedAt, Stats: n.Stats})
+	}
+	ctx.JSON(res)
+}
+
+// clusterHandler responds the latest stats of the cluster.
+func (s *statsSnapshot) clusterHandler(ctx iris.Context) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	c := s.get(ctx)
+	if c == nil {
+		return
+	}
+	if c.cluster == nil {
+		ctx.StatusCode(iris.StatusServiceUnavailable)
+		ctx.WriteString("no stats is aggregated yet")
+		return
+	}
+	ctx.JSON(clusterJSON{Timestamp: c.cluster.Timestamp, Stats: c.cluster.Stats, SecondaryStats: c.cluster.SecondaryStats})
+}
@@ -0,0 +1,41 @@
+package webui
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/kataras/iris/v12"
+	"github.com/pegasus-kv/collector/avail"
+)
+
+// availabilityHandler responds the availability of the recent probes in JSON.
+func availabilityHandler(ctx iris.Context) {
+	report, ok := avail.LatestReport()
+	if !ok {
+		ctx.StatusCode(iris.StatusNotFound)
+		ctx.WriteString("availability detection is disabled")
+		return
+	}
+	ctx.JSON(report)
+}
+
+// slaHandler responds the daily and monthly availability of the month given by the "month"
+// parameter, e.g. "2020-11", or the current month if it's absent.
+func slaHandler(ctx iris.Context) {
+	sla := avail.SLA()
+	if sla == nil {
+		ctx.StatusCode(iris.StatusNotFound)
+		ctx.WriteString("availability detection is disabled")
+		return
+	}
+	month := time.Now()
+	if m := ctx.URLParam("month"); m != "" {
+		var err error
+		if month, err = time.Parse("2006-01", m); err != nil {
+			ctx.StatusCode(iris.StatusBadRequest)
+			ctx.WriteString(fmt.Sprintf("invalid \"month\": %s", err))
+			return
+		}
+	}
+	ctx.JSON(sla.Monthly(month))
+}
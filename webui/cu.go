@@ -0,0 +1,32 @@
+package webui
+
+import (
+	"github.com/kataras/iris/v12"
+	"github.com/pegasus-kv/collector/usage"
+)
+
+// cuHandler responds the CU consumed by the table given by the "table" parameter, or all tables
+// if it's absent, within the range of the "start" and "end" parameters, of the cluster given by
+// the "cluster" parameter, or the first cluster if it's absent. The usage is summed up per
+// "granularity", which is "hour" (default) or "day".
+func (s *statsSnapshot) cuHandler(ctx iris.Context) {
+	a := usage.CU()
+	if a == nil {
+		ctx.StatusCode(iris.StatusNotFound)
+		ctx.WriteString("CU accounting is disabled")
+		return
+	}
+	start, end, err := historyRange(ctx)
+	if err != nil {
+		ctx.StatusCode(iris.StatusBadRequest)
+		ctx.WriteString(err.Error())
+		return
+	}
+	granularity := usage.Granularity(ctx.URLParamDefault("granularity", string(usage.Hourly)))
+	if granularity != usage.Hourly && granularity != usage.Daily {
+		ctx.StatusCode(iris.StatusBadRequest)
+		ctx.WriteString("invalid \"granularity\": " + string(granularity))
+		return
+	}
+	ctx.JSON(a.Query(s.clusterOf(ctx), ctx.URLParam("table"), start, end, granularity))
+}
@@ -0,0 +1,151 @@
+package webui
+
+import (
+	"sort"
+	"time"
+
+	"github.com/kataras/iris/v12"
+	"github.com/pegasus-kv/collector/aggregate"
+	"github.com/pegasus-kv/collector/avail"
+	"github.com/pegasus-kv/collector/hotspot"
+	"github.com/pegasus-kv/collector/store"
+)
+
+// The metrics charted on the dashboard.
+var dashboardMetrics = []string{
+	"read_qps",
+	"write_qps",
+	"read_bytes",
+	"write_bytes",
+	"sst_storage_mb",
+}
+
+// seriesJSON is the history of the dashboard metrics, ordered by time.
+type seriesJSON struct {
+	Timestamps []time.Time          `json:"timestamps"`
+	Stats      map[string][]float64 `json:"stats"`
+}
+
+func (s *seriesJSON) add(timestamp time.Time, stats map[string]float64) {
+	s.Timestamps = append(s.Timestamps, timestamp)
+	for _, m := range dashboardMetrics {
+		s.Stats[m] = append(s.Stats[m], stats[m])
+	}
+}
+
+func newSeriesJSON() seriesJSON {
+	return seriesJSON{Timestamps: []time.Time{}, Stats: make(map[string][]float64)}
+}
+
+type dashboardTableJSON struct {
+	TableName string     `json:"table_name"`
+	Series    seriesJSON `json:"series"`
+
+	// the partitions detected as hotspots in the latest round
+	HotPartitions []int `json:"hot_partitions"`
+}
+
+type dashboardNodeJSON struct {
+	Addr        string    `json:"addr"`
+	Healthy     bool      `json:"healthy"`
+	CollectedAt time.Time `json:"collected_at"`
+}
+
+type dashboardJSON struct {
+	Cluster seriesJSON           `json:"cluster"`
+	Tables  []dashboardTableJSON `json:"tables"`
+	Nodes   []dashboardNodeJSON  `json:"nodes"`
+
+	// absent if the availability detection is disabled
+	Availability *avail.Report `json:"availability,omitempty"`
+}
+
+// dashboardHandler renders the dashboard, whose data is polled from dashboardDataHandler.
+func dashboardHandler(ctx iris.Context) {
+	ctx.View("dashboard.html")
+}
+
+// dashboardDataHandler responds the history of the cluster and the tables, the health of the
+// replica nodes, and the hot partitions of the latest round, of the cluster given by the
+// "cluster" parameter, or the first cluster if it's not given.
+func (s *statsSnapshot) dashboardDataHandler(ctx iris.Context) {
+	res := dashboardJSON{Cluster: newSeriesJSON(), Tables: []dashboardTableJSON{}, Nodes: []dashboardNodeJSON{}}
+	cluster := s.clusterOf(ctx)
+	clusterHistory, tablesHistory := dashboardHistory(cluster)
+	for _, c := range clusterHistory {
+		res.Cluster.add(c.Timestamp, c.Stats)
+	}
+
+	hotPartitions := make(map[string][]int)
+	for _, h := range hotspot.Hotspots(cluster, "") {
+		hotPartitions[h.TableName] = append(hotPartitions[h.TableName], h.PartitionIndex)
+	}
+	for name, history := range tablesHistory {
+		tb := dashboardTableJSON{TableName: name, Series: newSeriesJSON(), HotPartitions: uniqueSorted(hotPartitions[name])}
+		for _, stat := range history {
+			tb.Series.add(stat.Timestamp, stat.Stats)
+		}
+		res.Tables = append(res.Tables, tb)
+	}
+	sort.Slice(res.Tables, func(i, j int) bool {
+		return res.Tables[i].TableName < res.Tables[j].TableName
+	})
+
+	s.lock.RLock()
+	if c, found := s.clusters[cluster]; found {
+		for _, n := range c.nodes {
+			res.Nodes = append(res.Nodes, dashboardNodeJSON{Addr: n.Addr, Healthy: true, CollectedAt: n.CollectedAt})
+		}
+		for addr, at := range c.lostNodes {
+			res.Nodes = append(res.Nodes, dashboardNodeJSON{Addr: addr, Healthy: false, CollectedAt: at})
+		}
+	}
+	s.lock.RUnlock()
+	sort.Slice(res.Nodes, func(i, j int) bool {
+		return res.Nodes[i].Addr < res.Nodes[j].Addr
+	})
+
+	if report, ok := avail.LatestReport(); ok {
+		res.Availability = &report
+	}
+	ctx.JSON(res)
+}
+
+// dashboardHistory returns the stats of the cluster of the last hour from the history store, or
+// the latest rounds kept by the aggregator if the store is disabled.
+func dashboardHistory(cluster string) ([]aggregate.ClusterStats, map[string][]aggregate.TableStats) {
+	stores := store.Memory()
+	if stores == nil {
+		return aggregate.SnapshotClusterStats(cluster), aggregate.SnapshotTableStats(cluster)
+	}
+	s := stores.Of(cluster)
+	if s == nil {
+		return nil, nil
+	}
+	end := time.Now()
+	start := end.Add(-defaultHistoryRange)
+	var history []aggregate.ClusterStats
+	stats, _ := s.QueryRange(start, end)
+	for _, c := range stats {
+		history = append(history, *c)
+	}
+	tables := make(map[string][]aggregate.TableStats)
+	for _, name := range s.Tables() {
+		tables[name] = s.QueryTableRange(name, start, end)
+	}
+	return history, tables
+}
+
+// uniqueSorted returns the distinct values of `a` in the ascending order.
+func uniqueSorted(a []int) []int {
+	res := []int{}
+	seen := make(map[int]bool)
+	for _, v := range a {
+		if !seen[v] {
+			seen[v] = true
+			res = append(res, v)
+		}
+	}
+	sort.Ints(res)
+	return res
+}
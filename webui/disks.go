@@ -0,0 +1,25 @@
+package webui
+
+import (
+	"github.com/kataras/iris/v12"
+	"github.com/pegasus-kv/collector/disk"
+)
+
+// disksHandler responds the disks of the replica nodes in JSON, of the node given by the "node"
+// parameter, or all nodes if it's absent.
+func disksHandler(ctx iris.Context) {
+	stats := disk.Stats()
+	if stats == nil {
+		ctx.StatusCode(iris.StatusNotFound)
+		ctx.WriteString("disk info collection is disabled")
+		return
+	}
+	node := ctx.URLParam("node")
+	res := []disk.Stat{}
+	for _, s := range stats {
+		if node == "" || s.Node == node {
+			res = append(res, s)
+		}
+	}
+	ctx.JSON(res)
+}
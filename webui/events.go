@@ -0,0 +1,44 @@
+package webui
+
+import (
+	"strings"
+
+	"github.com/kataras/iris/v12"
+	"github.com/pegasus-kv/collector/events"
+)
+
+// eventsHandler responds the recorded events, the latest first, filtered by the parameters
+// "kind" (comma-separated), "table", "node", "start" and "end". At most "limit" events are
+// responded, which is 100 by default.
+func eventsHandler(ctx iris.Context) {
+	l := events.Default()
+	if l == nil {
+		ctx.StatusCode(iris.StatusNotFound)
+		ctx.WriteString("event log is disabled")
+		return
+	}
+	filter := events.Filter{
+		Table: ctx.URLParam("table"),
+		Node:  ctx.URLParam("node"),
+		Limit: ctx.URLParamIntDefault("limit", 100),
+	}
+	for _, kind := range strings.Split(ctx.URLParam("kind"), ",") {
+		if kind != "" {
+			filter.Kinds = append(filter.Kinds, events.Kind(kind))
+		}
+	}
+	// unbounded unless the range is given
+	start, end, err := historyRange(ctx)
+	if err != nil {
+		ctx.StatusCode(iris.StatusBadRequest)
+		ctx.WriteString(err.Error())
+		return
+	}
+	if ctx.URLParam("start") != "" {
+		filter.Start = start
+	}
+	if ctx.URLParam("end") != "" {
+		filter.End = end
+	}
+	ctx.JSON(l.Query(filter))
+}
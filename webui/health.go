@@ -0,0 +1,31 @@
+package webui
+
+import (
+	"github.com/kataras/iris/v12"
+	"github.com/pegasus-kv/collector/health"
+)
+
+// healthzHandler responds 200 if the collector is alive, or 503 if it's stuck, with the checks.
+func healthzHandler(ctx iris.Context) {
+	probe(ctx, (*health.Checker).Liveness)
+}
+
+// readyzHandler responds 200 if the metrics of the collector are up to date, or 503 otherwise,
+// with the checks.
+func readyzHandler(ctx iris.Context) {
+	probe(ctx, (*health.Checker).Readiness)
+}
+
+func probe(ctx iris.Context, check func(c *health.Checker) health.Report) {
+	c := health.Default()
+	if c == nil {
+		ctx.StatusCode(iris.StatusServiceUnavailable)
+		ctx.WriteString("the health check is not started")
+		return
+	}
+	r := check(c)
+	if !r.OK {
+		ctx.StatusCode(iris.StatusServiceUnavailable)
+	}
+	ctx.JSON(r)
+}
@@ -0,0 +1,98 @@
+package webui
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/kataras/iris/v12"
+	"github.com/pegasus-kv/collector/store"
+)
+
+// The range queried by default.
+const defaultHistoryRange = time.Hour
+
+// parseTime parses the time given in RFC3339, or as a duration before now, e.g. "30m".
+func parseTime(value string, now time.Time) (time.Time, error) {
+	if d, err := time.ParseDuration(value); err == nil {
+		return now.Add(-d), nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+// historyRange returns the range given by the "start" and "end" parameters, which is the last
+// hour by default.
+func historyRange(ctx iris.Context) (start, end time.Time, err error) {
+	now := time.Now()
+	start, end = now.Add(-defaultHistoryRange), now
+	if v := ctx.URLParam("start"); v != "" {
+		if start, err = parseTime(v, now); err != nil {
+			return start, end, fmt.Errorf("invalid \"start\": %s", err)
+		}
+	}
+	if v := ctx.URLParam("end"); v != "" {
+		if end, err = parseTime(v, now); err != nil {
+			return start, end, fmt.Errorf("invalid \"end\": %s", err)
+		}
+	}
+	return start, end, nil
+}
+
+// historyStore returns the memory store of the cluster given by the "cluster" parameter, or the
+// first cluster if it's not given. It responds 404 and returns nil if the store is disabled or
+// there's no history of the cluster.
+func (s *statsSnapshot) historyStore(ctx iris.Context) *store.MemoryStore {
+	stores := store.Memory()
+	if stores == nil {
+		ctx.StatusCode(iris.StatusNotFound)
+		ctx.WriteString("history store is disabled")
+		return nil
+	}
+	name := s.clusterOf(ctx)
+	history := stores.Of(name)
+	if history == nil {
+		ctx.StatusCode(iris.StatusNotFound)
+		ctx.WriteString("no history of cluster " + name)
+	}
+	return history
+}
+
+// clusterHistoryHandler responds the stats of the cluster within the range, ordered by time.
+func (s *statsSnapshot) clusterHistoryHandler(ctx iris.Context) {
+	history := s.historyStore(ctx)
+	if history == nil {
+		return
+	}
+	start, end, err := historyRange(ctx)
+	if err != nil {
+		ctx.StatusCode(iris.StatusBadRequest)
+		ctx.WriteString(err.Error())
+		return
+	}
+	stats, _ := history.QueryRange(start, end)
+	res := []clusterJSON{}
+	for _, c := range stats {
+		res = append(res, clusterJSON{Timestamp: c.Timestamp, Stats: c.Stats})
+	}
+	ctx.JSON(res)
+}
+
+// tableHistoryHandler responds the stats of the table given by the path within the range,
+// ordered by time.
+func (s *statsSnapshot) tableHistoryHandler(ctx iris.Context) {
+	history := s.historyStore(ctx)
+	if history == nil {
+		return
+	}
+	start, end, err := historyRange(ctx)
+	if err != nil {
+		ctx.StatusCode(iris.StatusBadRequest)
+		ctx.WriteString(err.Error())
+		return
+	}
+	res := []tableJSON{}
+	stats := history.QueryTableRange(ctx.Params().Get("name"), start, end)
+	for i := range stats {
+		res = append(res, newTableJSON(&stats[i], false))
+	}
+	ctx.JSON(res)
+}
@@ -0,0 +1,72 @@
+package webui
+
+import (
+	"fmt"
+
+	"github.com/XiaoMi/pegasus-go-client/idl/base"
+	"github.com/kataras/iris/v12"
+	"github.com/pegasus-kv/collector/hotkey"
+)
+
+// hotkeysHandler responds the latest hotkey detections in JSON, of the table given by the
+// "table" parameter, or all tables if it's absent.
+func hotkeysHandler(ctx iris.Context) {
+	if !hotkey.Enabled() {
+		ctx.StatusCode(iris.StatusNotFound)
+		ctx.WriteString("hotkey detection is disabled")
+		return
+	}
+	ctx.JSON(hotkey.Detections(ctx.URLParam("table")))
+}
+
+// hotkeysStartHandler starts the hotkey detection of the partition given by the "app_id",
+// "partition_index", "type" (read or write) and "addr" (the primary) parameters.
+func hotkeysStartHandler(ctx iris.Context) {
+	gpid, typ, err := hotkeyParams(ctx)
+	if err == nil && ctx.URLParam("addr") == "" {
+		err = fmt.Errorf("\"addr\" is required")
+	}
+	if err != nil {
+		ctx.StatusCode(iris.StatusBadRequest)
+		ctx.WriteString(err.Error())
+		return
+	}
+	d, err := hotkey.StartDetection(ctx.URLParam("table"), gpid, ctx.URLParam("addr"), typ)
+	if err != nil {
+		ctx.StatusCode(iris.StatusConflict)
+		ctx.WriteString(err.Error())
+		return
+	}
+	ctx.JSON(d)
+}
+
+// hotkeysStopHandler stops the hotkey detection of the partition given by the "app_id",
+// "partition_index" and "type" parameters.
+func hotkeysStopHandler(ctx iris.Context) {
+	gpid, typ, err := hotkeyParams(ctx)
+	if err != nil {
+		ctx.StatusCode(iris.StatusBadRequest)
+		ctx.WriteString(err.Error())
+		return
+	}
+	if err := hotkey.StopDetection(gpid, typ); err != nil {
+		ctx.StatusCode(iris.StatusConflict)
+		ctx.WriteString(err.Error())
+	}
+}
+
+func hotkeyParams(ctx iris.Context) (base.Gpid, hotkey.Type, error) {
+	appID, err := ctx.URLParamInt("app_id")
+	if err != nil {
+		return base.Gpid{}, "", fmt.Errorf("invalid \"app_id\": %s", err)
+	}
+	partitionIndex, err := ctx.URLParamInt("partition_index")
+	if err != nil {
+		return base.Gpid{}, "", fmt.Errorf("invalid \"partition_index\": %s", err)
+	}
+	typ := hotkey.Type(ctx.URLParamDefault("type", string(hotkey.Read)))
+	if typ != hotkey.Read && typ != hotkey.Write {
+		return base.Gpid{}, "", fmt.Errorf("invalid \"type\": %s", typ)
+	}
+	return base.Gpid{Appid: int32(appID), PartitionIndex: int32(partitionIndex)}, typ, nil
+}
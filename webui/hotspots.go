@@ -0,0 +1,41 @@
+package webui
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/kataras/iris/v12"
+	"github.com/pegasus-kv/collector/hotspot"
+)
+
+// hotspotsHandler responds the hot partitions of the latest round in JSON, of the table given
+// by the "table" parameter, or all tables if it's absent, of the cluster given by the "cluster"
+// parameter, or the first cluster if it's absent.
+func (s *statsSnapshot) hotspotsHandler(ctx iris.Context) {
+	hotspots := hotspot.Hotspots(s.clusterOf(ctx), ctx.URLParam("table"))
+	if hotspots == nil {
+		ctx.StatusCode(iris.StatusNotFound)
+		ctx.WriteString("hotspot detection is disabled")
+		return
+	}
+	ctx.JSON(hotspots)
+}
+
+// hotspotsThresholdHandler changes the threshold of the table given by the "table" parameter,
+// or the default one if it's absent, to the "threshold" parameter.
+func hotspotsThresholdHandler(ctx iris.Context) {
+	table := ctx.URLParam("table")
+	threshold, err := strconv.ParseFloat(ctx.URLParam("threshold"), 64)
+	if err != nil {
+		ctx.StatusCode(iris.StatusBadRequest)
+		ctx.WriteString(fmt.Sprintf("invalid \"threshold\": %s", err))
+		return
+	}
+	if err := hotspot.SetThreshold(table, threshold); err != nil {
+		ctx.StatusCode(iris.StatusBadRequest)
+		ctx.WriteString(err.Error())
+		return
+	}
+	method, threshold := hotspot.Threshold(table)
+	ctx.JSON(iris.Map{"table": table, "method": method, "threshold": threshold})
+}
@@ -12,14 +12,14 @@ var indexPageClusterStats = []string{
 	"read_qps",
 }
 
-func renderIndexClusterCharts(ctx iris.Context) {
+func renderIndexClusterCharts(ctx iris.Context, cluster string) {
 	type perfCounterHTML struct {
 		PerfCounter string
 		Values      []float64
 	}
 	var PerfCounters []*perfCounterHTML
 
-	snapshots := aggregate.SnapshotClusterStats()
+	snapshots := aggregate.SnapshotClusterStats(cluster)
 	for _, s := range indexPageClusterStats {
 		PerfCounters = append(PerfCounters, &perfCounterHTML{
 			PerfCounter: s,
@@ -40,8 +40,10 @@ func renderIndexClusterCharts(ctx iris.Context) {
 	ctx.ViewData("PerfIDs", PerfIDs)
 }
 
-func indexHandler(ctx iris.Context) {
-	renderIndexClusterCharts(ctx)
+// indexHandler renders the charts of the cluster given by the "cluster" parameter, or the first
+// cluster if it's not given.
+func (s *statsSnapshot) indexHandler(ctx iris.Context) {
+	renderIndexClusterCharts(ctx, s.clusterOf(ctx))
 
 	// metaClient := client(viper.GetString("meta_server"))
 	// tables, err := metaClient.ListTables()
@@ -0,0 +1,79 @@
+package webui
+
+import (
+	"sync"
+	"time"
+
+	"github.com/kataras/iris/v12"
+	"github.com/pegasus-kv/collector/aggregate"
+)
+
+// The number of the recent node state changes kept for the API.
+const nodeEventsCapacity = 256
+
+// nodeEvents keeps the recent state changes of the replica nodes, the oldest first.
+type nodeEvents struct {
+	lock   sync.RWMutex
+	events []nodeEventJSON
+}
+
+type nodeEventJSON struct {
+	Node       string    `json:"node"`
+	OldState   string    `json:"old_state"`
+	NewState   string    `json:"new_state"`
+	Flapping   bool      `json:"flapping"`
+	DetectedAt time.Time `json:"detected_at"`
+}
+
+// newNodeEvents returns a nodeEvents watching the state changes detected by the aggregator.
+func newNodeEvents() *nodeEvents {
+	e := &nodeEvents{}
+	aggregate.AddHookAfterNodeStateChanged(e.append)
+	return e
+}
+
+func (e *nodeEvents) append(events []*aggregate.NodeStateEvent) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	for _, ev := range events {
+		e.events = append(e.events, nodeEventJSON{
+			Node:       ev.Addr,
+			OldState:   string(ev.OldState),
+			NewState:   string(ev.NewState),
+			Flapping:   ev.Flapping,
+			DetectedAt: ev.DetectedAt,
+		})
+	}
+	if len(e.events) > nodeEventsCapacity {
+		e.events = append([]nodeEventJSON{}, e.events[len(e.events)-nodeEventsCapacity:]...)
+	}
+}
+
+// handler responds the recent state changes, the latest first, of the node given by the "node"
+// parameter or all nodes, detected since the "start" parameter if it's given. At most "limit"
+// events are responded, which is 100 by default.
+func (e *nodeEvents) handler(ctx iris.Context) {
+	node := ctx.URLParam("node")
+	var since time.Time
+	if v := ctx.URLParam("start"); v != "" {
+		var err error
+		if since, err = parseTime(v, time.Now()); err != nil {
+			ctx.StatusCode(iris.StatusBadRequest)
+			ctx.WriteString("invalid \"start\": " + err.Error())
+			return
+		}
+	}
+	limit := ctx.URLParamIntDefault("limit", 100)
+
+	e.lock.RLock()
+	defer e.lock.RUnlock()
+	res := []nodeEventJSON{}
+	for i := len(e.events) - 1; i >= 0 && len(res) < limit; i-- {
+		ev := e.events[i]
+		if (node != "" && ev.Node != node) || ev.DetectedAt.Before(since) {
+			continue
+		}
+		res = append(res, ev)
+	}
+	ctx.JSON(res)
+}
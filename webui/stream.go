@@ -0,0 +1,142 @@
+package webui
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/kataras/iris/v12"
+	"github.com/pegasus-kv/collector/aggregate"
+)
+
+// streamCapacity is the number of rounds buffered for a slow client. Rounds are dropped if the
+// buffer is full.
+const streamCapacity = 4
+
+const streamWriteTimeout = 10 * time.Second
+
+var upgrader = websocket.Upgrader{}
+
+// roundJSON is a round of aggregation of a cluster sent to the WebSocket clients.
+type roundJSON struct {
+	Cluster   string      `json:"cluster"`
+	Timestamp time.Time   `json:"timestamp"`
+	Tables    []tableJSON `json:"tables"`
+}
+
+// statsStream pushes every round of aggregation of every cluster to the WebSocket clients.
+type statsStream struct {
+	lock        sync.Mutex
+	subscribers map[*streamSubscriber]struct{}
+}
+
+type streamSubscriber struct {
+	// the watched clusters, empty if all clusters are watched
+	clusters map[string]bool
+	// the watched tables, empty if all tables are watched
+	tables map[string]bool
+
+	rounds chan roundJSON
+}
+
+// newStatsStream returns a statsStream watching the stats emitted by the aggregators of all
+// clusters.
+func newStatsStream() *statsStream {
+	s := &statsStream{subscribers: make(map[*streamSubscriber]struct{})}
+	aggregate.AddHookAfterClusterStatsEmitted(func(stats []aggregate.TableStats, allStats aggregate.ClusterStats) {
+		s.broadcast(stats, allStats.Cluster, allStats.Timestamp)
+	})
+	return s
+}
+
+func (s *statsStream) broadcast(stats []aggregate.TableStats, cluster string, timestamp time.Time) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for sub := range s.subscribers {
+		if len(sub.clusters) != 0 && !sub.clusters[cluster] {
+			continue
+		}
+		round := roundJSON{Cluster: cluster, Timestamp: timestamp, Tables: []tableJSON{}}
+		for i := range stats {
+			if len(sub.tables) != 0 && !sub.tables[stats[i].TableName] {
+				continue
+			}
+			round.Tables = append(round.Tables, newTableJSON(&stats[i], false))
+		}
+		select {
+		case sub.rounds <- round:
+		default:
+			log.Warn("WebSocket client is too slow to receive the stats, drop a round")
+		}
+	}
+}
+
+func (s *statsStream) subscribe(clusters []string, tables []string) *streamSubscriber {
+	sub := &streamSubscriber{
+		clusters: make(map[string]bool),
+		tables:   make(map[string]bool),
+		rounds:   make(chan roundJSON, streamCapacity),
+	}
+	for _, name := range clusters {
+		sub.clusters[name] = true
+	}
+	for _, name := range tables {
+		sub.tables[name] = true
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.subscribers[sub] = struct{}{}
+	return sub
+}
+
+func (s *statsStream) unsubscribe(sub *streamSubscriber) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.subscribers, sub)
+}
+
+// handler upgrades the request to a WebSocket, which receives every round of aggregation of
+// every cluster until the client quits. The clusters and the tables are filtered by the
+// "cluster" and the "table" parameters, e.g. "/api/stream?cluster=c1&table=temp&table=stat".
+func (s *statsStream) handler(ctx iris.Context) {
+	s.serve(ctx.ResponseWriter(), ctx.Request())
+}
+
+func (s *statsStream) serve(w http.ResponseWriter, r *http.Request) {
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Errorf("failed to upgrade to WebSocket: %s", err)
+		return
+	}
+	defer conn.Close()
+
+	sub := s.subscribe(r.URL.Query()["cluster"], r.URL.Query()["table"])
+	defer s.unsubscribe(sub)
+
+	// the messages from the client are discarded, reading is only to be notified of the closure
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case <-closed:
+			return
+		case round := <-sub.rounds:
+			_ = conn.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+			if err := conn.WriteJSON(round); err != nil {
+				log.Warnf("failed to send the stats to WebSocket client %s: %s", r.RemoteAddr, err)
+				return
+			}
+		}
+	}
+}
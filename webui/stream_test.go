@@ -0,0 +1,70 @@
+package webui
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/pegasus-kv/collector/aggregate"
+	"github.com/stretchr/testify/assert"
+)
+
+func dialStream(t *testing.T, s *statsStream, url string, query string) *websocket.Conn {
+	s.lock.Lock()
+	n := len(s.subscribers)
+	s.lock.Unlock()
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(url, "http")+"?"+query, nil)
+	assert.Nil(t, err)
+	// wait until the stream is subscribed
+	for {
+		s.lock.Lock()
+		subscribed := len(s.subscribers) > n
+		s.lock.Unlock()
+		if subscribed {
+			return conn
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestStatsStreamOfClusters(t *testing.T) {
+	s := &statsStream{subscribers: make(map[*streamSubscriber]struct{})}
+	srv := httptest.NewServer(http.HandlerFunc(s.serve))
+	defer srv.Close()
+
+	all := dialStream(t, s, srv.URL, "table=temp")
+	defer all.Close()
+	c2 := dialStream(t, s, srv.URL, "cluster=c2")
+	defer c2.Close()
+
+	now := time.Now()
+	s.broadcast([]aggregate.TableStats{
+		{TableName: "stat", AppID: 1, Stats: map[string]float64{"get_qps": 1}},
+		{TableName: "temp", AppID: 2, Stats: map[string]float64{"get_qps": 2}},
+	}, "c1", now)
+	s.broadcast([]aggregate.TableStats{
+		{TableName: "temp", AppID: 1, Stats: map[string]float64{"get_qps": 3}},
+	}, "c2", now)
+
+	// the rounds of both clusters are received, each tagged with its cluster
+	var round roundJSON
+	for _, expected := range []struct {
+		cluster string
+		qps     float64
+	}{{"c1", 2}, {"c2", 3}} {
+		assert.Nil(t, all.ReadJSON(&round))
+		assert.Equal(t, round.Cluster, expected.cluster)
+		assert.Equal(t, len(round.Tables), 1)
+		assert.Equal(t, round.Tables[0].TableName, "temp")
+		assert.Equal(t, round.Tables[0].Stats["get_qps"], expected.qps)
+	}
+
+	// only the rounds of c2 are received
+	assert.Nil(t, c2.ReadJSON(&round))
+	assert.Equal(t, round.Cluster, "c2")
+	assert.Equal(t, len(round.Tables), 1)
+	assert.Equal(t, round.Tables[0].AppID, 1)
+}
@@ -2,29 +2,75 @@ package webui
 
 import (
 	"context"
+	"net/http"
 	"time"
 
 	"github.com/kataras/iris/v12"
+	"github.com/pegasus-kv/collector/logging"
+	"github.com/pegasus-kv/collector/security"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"gopkg.in/tomb.v2"
 )
 
-// StartWebServer starts an iris-powered HTTP server.
-func StartWebServer() {
+var log = logging.Module("webui")
+
+// StartWebServer starts an iris-powered HTTP server, which is shut down once the tomb dies.
+func StartWebServer(tom *tomb.Tomb) {
 	app := iris.New()
-	app.Get("/", indexHandler)
+	// the latest stats of every cluster, which also resolves the "cluster" parameter of the
+	// pages and the JSON API
+	snapshot := newStatsSnapshot()
+
+	app.Get("/", snapshot.indexHandler)
+	app.Get("/dashboard", dashboardHandler)
 	app.Get("/tables", tablesHandler)
+	app.Get("/hotspots", snapshot.hotspotsHandler)
+	app.Post("/hotspots/threshold", hotspotsThresholdHandler)
+	app.Get("/hotkeys", hotkeysHandler)
+	app.Get("/availability", availabilityHandler)
+	app.Get("/availability/sla", slaHandler)
+	app.Post("/hotkeys/start", hotkeysStartHandler)
+	app.Post("/hotkeys/stop", hotkeysStopHandler)
+	app.Get("/usage/cu", snapshot.cuHandler)
+	app.Get("/disks", disksHandler)
+
+	app.Get("/api/clusters", snapshot.clustersHandler)
+	app.Get("/api/tables", snapshot.tablesHandler)
+	app.Get("/api/tables/{name}", snapshot.tableHandler)
+	app.Get("/api/nodes", snapshot.nodesHandler)
+	app.Get("/api/cluster", snapshot.clusterHandler)
+	app.Get("/api/stream", newStatsStream().handler)
+	app.Get("/api/dashboard", snapshot.dashboardDataHandler)
+	app.Get("/api/history/cluster", snapshot.clusterHistoryHandler)
+	app.Get("/api/history/tables/{name}", snapshot.tableHistoryHandler)
+	app.Get("/api/events", eventsHandler)
+	app.Get("/api/alerts", alertsHandler)
+	app.Get("/api/anomalies", anomaliesHandler)
+	app.Get("/api/events/nodes", newNodeEvents().handler)
+
+	app.Post("/admin/reload", reloadHandler)
+	app.Get("/admin/log/levels", logLevelsHandler)
+	app.Post("/admin/log/level", logLevelHandler)
+
+	// the probes of Kubernetes and the load balancers, which are anonymous
+	app.Get("/healthz", healthzHandler)
+	app.Get("/readyz", readyzHandler)
+
 	app.Get("/metrics", func(ctx iris.Context) {
 		handler := promhttp.Handler()
 		handler.ServeHTTP(ctx.ResponseWriter(), ctx.Request())
 	})
 
-	iris.RegisterOnInterrupt(func() {
-		// gracefully shutdown on interrupt
+	// served by the standard server, which is HTTPS if "http_tls" is configured
+	srv := &http.Server{Addr: ":8080", Handler: app}
+	go func() {
+		// gracefully shutdown, so that the requests in flight are served
+		<-tom.Dying()
 		timeout := 5 * time.Second
 		ctx, cancel := context.WithTimeout(context.Background(), timeout)
 		defer cancel()
-		app.Shutdown(ctx)
-	})
+		srv.Shutdown(ctx)
+	}()
 
 	// Register the view engine to the views,
 	// this will load the templates.
@@ -33,6 +79,12 @@ func StartWebServer() {
 	app.RegisterView(tmpl)
 
 	go func() {
-		app.Listen(":8080")
+		if err := app.Build(); err != nil {
+			log.Errorf("failed to build the web server: %s", err)
+			return
+		}
+		if err := security.ListenAndServe(srv); err != nil && err != http.ErrServerClosed {
+			log.Errorf("the web server terminates: %s", err)
+		}
 	}()
 }